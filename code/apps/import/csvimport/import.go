@@ -1,6 +1,7 @@
 package csvimport
 
 import (
+	"context"
 	"encoding/csv"
 	"errors"
 	"fmt"
@@ -170,7 +171,7 @@ func getLine(field []string) (*CSVLine, error) {
 	return &record, nil
 }
 
-func CreateRecords(db *database.Database, file fs.File, membershipYearEnd int) {
+func CreateRecords(ctx context.Context, db *database.Database, file fs.File, membershipYearEnd int) {
 
 	records, importError := Import(file, membershipYearEnd)
 
@@ -187,18 +188,18 @@ func CreateRecords(db *database.Database, file fs.File, membershipYearEnd int) {
 			record.Email)
 
 		slog.Info(line)
-		ProcessRecord(db, &record)
+		ProcessRecord(ctx, db, &record)
 	}
 }
 
 // ProcessRecord creates a user, member etc from the given CSV line.  On
 // success it returns the ID of the user.
-func ProcessRecord(db *database.Database, line *CSVLine) (int64, error) {
+func ProcessRecord(ctx context.Context, db *database.Database, line *CSVLine) (int64, error) {
 
 	// Check that the account name is not already in use - sometimes two  members use the
 	// same email address.
 
-	memberExists, checkMemberError := db.MemberExists(line.UserName, line.Email)
+	memberExists, checkMemberError := db.MemberExists(ctx, line.UserName, line.Email)
 	if checkMemberError != nil {
 		return 0, checkMemberError
 	}
@@ -211,7 +212,7 @@ func ProcessRecord(db *database.Database, line *CSVLine) (int64, error) {
 
 	// The LoginName is available.  Create the records.
 
-	role, roleError := db.GetRole("Member")
+	role, roleError := db.GetRole(ctx, db.Config.RoleName(database.MembershipTypeOrdinary))
 	if roleError != nil {
 		return 0, roleError
 	}
@@ -221,7 +222,7 @@ func ProcessRecord(db *database.Database, line *CSVLine) (int64, error) {
 	}
 
 	user := database.NewUser(line.UserName)
-	createUserError := db.CreateUser(user)
+	createUserError := db.CreateUser(ctx, user)
 	if createUserError != nil {
 		slog.Error(createUserError.Error())
 		db.Rollback()
@@ -229,247 +230,42 @@ func ProcessRecord(db *database.Database, line *CSVLine) (int64, error) {
 	}
 
 	member := database.NewMember(user, role, line.MembershipStart, line.MembershipEnd)
-	memberError := db.CreateMember(member)
+	memberError := db.CreateMember(ctx, member)
 	if memberError != nil {
 		slog.Error(memberError.Error())
 		db.Rollback()
 		return 0, memberError
 	}
 
-	// A member ca nly be in the download if they have given this permission.
-	idp, idpe := db.GetUserDataFieldIDByNameIntern(database.DataStoragePermNameIntern)
-	if idpe != nil {
-		slog.Error(database.DataStoragePermNameIntern + ": " + idpe.Error())
+	// Save the profile fields that are set in the line in one go.  A member can
+	// only be in the download if they have given permission for us to hold
+	// their details.  If they have an email address, assume permission to send
+	// emails and leave it to the user to turn it off.
+	ud := database.UserData{
+		UserID:          user.ID,
+		Title:           line.Title,
+		FirstName:       line.FirstName,
+		LastName:        line.Surname,
+		Email:           line.Email,
+		AddressLine1:    line.AddressLine1,
+		AddressLine2:    line.AddressLine2,
+		AddressLine3:    line.AddressLine3,
+		Town:            line.Town,
+		County:          line.County,
+		Postcode:        line.Postcode,
+		CountryCode:     line.Country,
+		Phone:           line.Phone,
+		Mobile:          line.Mobile,
+		DataProtection:  true,
+		EmailPermission: len(line.Email) > 0,
+	}
+
+	saveError := db.SaveUserData(ctx, &ud, database.ImportedFields(&ud)...)
+	if saveError != nil {
+		slog.Error(saveError.Error())
 		db.Rollback()
-		return 0, idpe
+		return 0, saveError
 	}
 
-	// This "boolean" field contains 1 (yes) or 0 (no).
-	err := database.SetUserDataField(db, idp, user.ID, 1)
-	if err != nil {
-		slog.Error(err.Error())
-		db.Rollback()
-		return 0, err
-	}
-
-	if len(line.Email) > 0 {
-		// Also, assume permission to send emails and leave it to the user to turn it off.
-		ipe, ipee := db.GetUserDataFieldIDByNameIntern(database.EmailPermNameIntern)
-		if ipee != nil {
-			slog.Error(database.EmailPermNameIntern + ": " + ipee.Error())
-			db.Rollback()
-			return 0, ipee
-		}
-		// This "boolean" field contains 1 (yes) or 0 (no).
-		err := database.SetUserDataField(db, ipe, user.ID, 1)
-		if err != nil {
-			slog.Error(err.Error())
-			db.Rollback()
-			return 0, err
-		}
-
-	}
-
-	if len(line.Title) > 0 {
-		is, se := db.GetUserDataFieldIDByNameIntern("SALUTATION")
-		if se != nil {
-			slog.Error("SALUTATION: " + se.Error())
-			db.Rollback()
-			return 0, se
-		}
-		err := database.SetUserDataField(db, is, user.ID, line.Title)
-		if err != nil {
-			slog.Error(err.Error())
-			db.Rollback()
-			return 0, err
-		}
-
-	}
-
-	if len(line.FirstName) > 0 {
-		ifn, fne := db.GetUserDataFieldIDByNameIntern("FIRST_NAME")
-		if fne != nil {
-			slog.Error("FIRST_NAME: " + fne.Error())
-			db.Rollback()
-			return 0, fne
-		}
-		err := database.SetUserDataField(db, ifn, user.ID, line.FirstName)
-		if err != nil {
-			slog.Error(err.Error())
-			db.Rollback()
-			return 0, err
-		}
-	}
-
-	if len(line.Surname) > 0 {
-		if len(line.Surname) > 0 {
-			iln, lne := db.GetUserDataFieldIDByNameIntern("LAST_NAME")
-			if lne != nil {
-				slog.Error("LAST_NAME: " + lne.Error())
-				db.Rollback()
-				return 0, lne
-			}
-			snError := database.SetUserDataField(db, iln, user.ID, line.Surname)
-			if snError != nil {
-				slog.Error(snError.Error())
-				db.Rollback()
-				return 0, snError
-			}
-		}
-	}
-
-	if len(line.Email) > 0 {
-		// We use the email address as the account name in adm_users but that's our choice.
-		// We also have a separate email entry in adm_user_data.
-		ie, ee := db.GetUserDataFieldIDByNameIntern("EMAIL")
-		if ee != nil {
-			slog.Error("EMAIL: " + ee.Error())
-			db.Rollback()
-			return 0, ee
-		}
-		emailError := database.SetUserDataField(db, ie, user.ID, line.Email)
-		if emailError != nil {
-			slog.Error(emailError.Error())
-			db.Rollback()
-			return 0, emailError
-		}
-	}
-
-	if len(line.AddressLine1) > 0 {
-		ist, ste := db.GetUserDataFieldIDByNameIntern("STREET")
-		if ste != nil {
-			slog.Error("STREET: " + ste.Error())
-			db.Rollback()
-			return 0, ste
-		}
-
-		a1Error :=
-			database.SetUserDataField(db, ist, user.ID, line.AddressLine1)
-		if a1Error != nil {
-			slog.Error(a1Error.Error())
-			db.Rollback()
-			return 0, a1Error
-		}
-	}
-
-	if len(line.AddressLine2) > 0 {
-		ial2, al2e := db.GetUserDataFieldIDByNameIntern("ADDRESS_LINE_2")
-		if al2e != nil {
-			slog.Error("ADDRESS_LINE_2: " + al2e.Error())
-			db.Rollback()
-			return 0, al2e
-		}
-		err := database.SetUserDataField(db, ial2, user.ID, line.AddressLine2)
-		if err != nil {
-			slog.Error(err.Error())
-			db.Rollback()
-			return 0, err
-		}
-	}
-
-	if len(line.AddressLine3) > 0 {
-		ial3, al3e := db.GetUserDataFieldIDByNameIntern("ADDRESS_LINE_3")
-		if al3e != nil {
-			slog.Error("ADDRESS_LINE_3: " + al3e.Error())
-			db.Rollback()
-			return 0, al3e
-		}
-		err := database.SetUserDataField(db, ial3, user.ID, line.AddressLine3)
-		if err != nil {
-			slog.Error(err.Error())
-			db.Rollback()
-			return 0, err
-		}
-	}
-
-	if len(line.Town) > 0 {
-		ic, ce := db.GetUserDataFieldIDByNameIntern("CITY")
-		if ce != nil {
-			slog.Error("CITY: " + ce.Error())
-			db.Rollback()
-			return 0, ce
-		}
-		err := database.SetUserDataField(db, ic, user.ID, line.Town)
-		if err != nil {
-			slog.Error(err.Error())
-			db.Rollback()
-			return 0, err
-		}
-	}
-
-	if len(line.County) > 0 {
-		ict, cte := db.GetUserDataFieldIDByNameIntern("COUNTY")
-		if cte != nil {
-			slog.Error("COUNTY: " + cte.Error())
-			db.Rollback()
-			return 0, cte
-		}
-		err := database.SetUserDataField(db, ict, user.ID, line.County)
-		if err != nil {
-			slog.Error(err.Error())
-			db.Rollback()
-			return 0, err
-		}
-	}
-
-	if len(line.Postcode) > 0 {
-		ipc, pce := db.GetUserDataFieldIDByNameIntern("POSTCODE")
-		if pce != nil {
-			slog.Error("POSTCODE: " + pce.Error())
-			db.Rollback()
-			return 0, pce
-		}
-		err := database.SetUserDataField(db, ipc, user.ID, line.Postcode)
-		if err != nil {
-			slog.Error(err.Error())
-			db.Rollback()
-			return 0, err
-		}
-	}
-
-	if len(line.Country) > 0 {
-		ictr, ctre := db.GetUserDataFieldIDByNameIntern("COUNTRY")
-		if ctre != nil {
-			slog.Error("COUNTRY: " + ctre.Error())
-			db.Rollback()
-			return 0, ctre
-		}
-		err := database.SetUserDataField(db, ictr, user.ID, line.Country)
-		if err != nil {
-			slog.Error(err.Error())
-			db.Rollback()
-			return 0, err
-		}
-	}
-
-	if len(line.Phone) > 0 {
-		ip, fpe := db.GetUserDataFieldIDByNameIntern("PHONE")
-		if fpe != nil {
-			slog.Error("PHONE: " + fpe.Error())
-			db.Rollback()
-			return 0, fpe
-		}
-		err := database.SetUserDataField(db, ip, user.ID, line.Phone)
-		if err != nil {
-			slog.Error(err.Error())
-			db.Rollback()
-			return 0, err
-		}
-	}
-
-	if len(line.Mobile) > 0 {
-		im, fme := db.GetUserDataFieldIDByNameIntern("MOBILE")
-		if fme != nil {
-			slog.Error("MOBILE: " + fme.Error())
-			db.Rollback()
-			return 0, fme
-		}
-		err := database.SetUserDataField(db, im, user.ID, line.Mobile)
-		if err != nil {
-			slog.Error(err.Error())
-			db.Rollback()
-			return 0, err
-		}
-	}
 	return user.ID, nil
 }
@@ -14,12 +14,13 @@ import (
 	// _ "github.com/mattn/go-sqlite3"
 )
 
-var databaseList = []string{"postgres", "sqlite"}
+var databaseList = database.DatabaseListForTesting("postgres", "sqlite")
 
 // TestAdmidioEmailFilter checks the query used by the Admidio system to get the list of members
 // to email - used by message_write.php in
 // /var/www/html/members.sihg.org.uk/admidio/adm_program/modules/messages.
 func TestAdmidioEmailFilter(t *testing.T) {
+	ctx := t.Context()
 
 	ukTime, err := time.LoadLocation("Europe/London")
 	if err != nil {
@@ -225,7 +226,7 @@ func TestAdmidioEmailFilter(t *testing.T) {
 		}
 
 		// Count the number of users already set up in the DB.
-		r, e := db.Query(sql1)
+		r, e := db.Query(ctx, sql1)
 		if e != nil {
 			t.Error(e)
 			return
@@ -242,55 +243,55 @@ func TestAdmidioEmailFilter(t *testing.T) {
 		}
 		r.Close()
 
-		un1, un1e := database.CreateUuid(db.Transaction, "usr_login_name", "adm_users")
+		un1, un1e := database.CreateUuid(ctx, db.Transaction, "usr_login_name", "adm_users")
 		if un1e != nil {
 			t.Errorf("%s - %v", "un7", un1e)
 			return
 		}
 
-		un2, un2e := database.CreateUuid(db.Transaction, "usr_login_name", "adm_users")
+		un2, un2e := database.CreateUuid(ctx, db.Transaction, "usr_login_name", "adm_users")
 		if un2e != nil {
 			t.Errorf("%s - %v", "un7", un2e)
 			return
 		}
 
-		un3, un3e := database.CreateUuid(db.Transaction, "usr_login_name", "adm_users")
+		un3, un3e := database.CreateUuid(ctx, db.Transaction, "usr_login_name", "adm_users")
 		if un3e != nil {
 			t.Errorf("%s - %v", "un7", un3e)
 			return
 		}
 
-		un4, un4e := database.CreateUuid(db.Transaction, "usr_login_name", "adm_users")
+		un4, un4e := database.CreateUuid(ctx, db.Transaction, "usr_login_name", "adm_users")
 		if un4e != nil {
 			t.Errorf("%s - %v", "un7", un4e)
 			return
 		}
 
-		un5, un5e := database.CreateUuid(db.Transaction, "usr_login_name", "adm_users")
+		un5, un5e := database.CreateUuid(ctx, db.Transaction, "usr_login_name", "adm_users")
 		if un5e != nil {
 			t.Errorf("%s - %v", "un7", un5e)
 			return
 		}
 
-		un6, un6e := database.CreateUuid(db.Transaction, "usr_login_name", "adm_users")
+		un6, un6e := database.CreateUuid(ctx, db.Transaction, "usr_login_name", "adm_users")
 		if un6e != nil {
 			t.Errorf("%s - %v", "un7", un6e)
 			return
 		}
 
-		un7, un7e := database.CreateUuid(db.Transaction, "usr_login_name", "adm_users")
+		un7, un7e := database.CreateUuid(ctx, db.Transaction, "usr_login_name", "adm_users")
 		if un7e != nil {
 			t.Errorf("%s - %v", "un7", un7e)
 			return
 		}
 
-		firstname, fne := database.CreateUuid(db.Transaction, "usr_login_name", "adm_users")
+		firstname, fne := database.CreateUuid(ctx, db.Transaction, "usr_login_name", "adm_users")
 		if fne != nil {
 			t.Errorf("%s - %v", "firstname", fne)
 			return
 		}
 
-		lastname, psee := database.CreateUuid(db.Transaction, "usr_login_name", "adm_users")
+		lastname, psee := database.CreateUuid(ctx, db.Transaction, "usr_login_name", "adm_users")
 		if psee != nil {
 			t.Errorf("%s - %v", "lastname", psee)
 			return
@@ -401,7 +402,7 @@ func TestAdmidioEmailFilter(t *testing.T) {
 
 		// Create the users, members etc.
 		for _, td := range testData {
-			id, err := ProcessRecord(db, &td.line)
+			id, err := ProcessRecord(ctx, db, &td.line)
 			if err != nil {
 				t.Error(td.line.UserName + ": " + err.Error())
 				return
@@ -413,7 +414,7 @@ func TestAdmidioEmailFilter(t *testing.T) {
 		}
 
 		// Unset the valid flag for un3.
-		u3, ue3 := db.GetUsersByLoginName(un3)
+		u3, ue3 := db.GetUsersByLoginName(ctx, un3)
 		if ue3 != nil {
 			t.Error(ue3)
 			return
@@ -424,14 +425,14 @@ func TestAdmidioEmailFilter(t *testing.T) {
 			return
 		}
 		u3[0].Valid = false
-		uu3e := db.UpdateUser(&u3[0])
+		uu3e := db.UpdateUser(ctx, &u3[0])
 		if uu3e != nil {
 			t.Error(uu3e)
 			return
 		}
 
 		// Delete the member record for user un4.
-		u4, ue4 := db.GetUsersByLoginName(un4)
+		u4, ue4 := db.GetUsersByLoginName(ctx, un4)
 		if ue4 != nil {
 			t.Error(ue4)
 			return
@@ -442,18 +443,18 @@ func TestAdmidioEmailFilter(t *testing.T) {
 			return
 		}
 
-		m, me := db.GetMemberOfUser(&u4[0])
+		m, me := db.GetMemberOfUser(ctx, &u4[0])
 		if me != nil {
 			t.Error(me)
 		}
 
-		mde := db.DeleteMember(m)
+		mde := db.DeleteMember(ctx, m)
 		if mde != nil {
 			t.Error(mde)
 		}
 
 		// Remove the email permission from un7.
-		u7, ue7 := db.GetUsersByLoginName(un7)
+		u7, ue7 := db.GetUsersByLoginName(ctx, un7)
 		if ue7 != nil {
 			t.Error(ue7)
 			return
@@ -464,14 +465,14 @@ func TestAdmidioEmailFilter(t *testing.T) {
 			return
 		}
 
-		pse, psee := db.GetUserDataFieldIDByNameIntern(database.EmailPermNameIntern)
+		pse, psee := db.GetUserDataFieldIDByNameIntern(ctx, database.EmailPermNameIntern)
 		if psee != nil {
 			t.Error(psee)
 		}
 
 		// The permission field is 1 (allowed) or 0 (not allowed).
 		// Set it to not allowed.
-		snError := database.SetUserDataField(db, pse, u7[0].ID, 0)
+		snError := database.SetUserDataField(ctx, db, pse, u7[0].ID, 0)
 		if snError != nil {
 			t.Error(snError)
 			return
@@ -479,7 +480,7 @@ func TestAdmidioEmailFilter(t *testing.T) {
 
 		// Now we can run the test.
 
-		rows1, selectError1 := db.Query(sql1)
+		rows1, selectError1 := db.Query(ctx, sql1)
 		if selectError1 != nil {
 			t.Error(selectError1)
 		}
@@ -521,7 +522,7 @@ func TestAdmidioEmailFilter(t *testing.T) {
 			return
 		}
 
-		rows2, selectError2 := db.Query(sql2)
+		rows2, selectError2 := db.Query(ctx, sql2)
 		if selectError2 != nil {
 			t.Error(selectError2)
 		}
@@ -566,7 +567,7 @@ func TestAdmidioEmailFilter(t *testing.T) {
 			return
 		}
 
-		rows3, selectError3 := db.Query(sql3)
+		rows3, selectError3 := db.Query(ctx, sql3)
 		if selectError3 != nil {
 			t.Error(selectError3)
 		}
@@ -611,7 +612,7 @@ func TestAdmidioEmailFilter(t *testing.T) {
 			return
 		}
 
-		rows4, selectError4 := db.Query(sql4, membershipStart.Format("2006-01-02"))
+		rows4, selectError4 := db.Query(ctx, sql4, membershipStart.Format("2006-01-02"))
 		if selectError4 != nil {
 			t.Error(selectError4)
 		}
@@ -654,7 +655,7 @@ func TestAdmidioEmailFilter(t *testing.T) {
 			return
 		}
 
-		rows5, selectError5 := db.Query(sql5, membershipStart.Format("2006-01-02"))
+		rows5, selectError5 := db.Query(ctx, sql5, membershipStart.Format("2006-01-02"))
 		if selectError5 != nil {
 			t.Error(selectError5)
 			return
@@ -699,7 +700,7 @@ func TestAdmidioEmailFilter(t *testing.T) {
 			return
 		}
 
-		rows6, selectError6 := db.Query(sql6, membershipStart.Format("2006-01-02"))
+		rows6, selectError6 := db.Query(ctx, sql6, membershipStart.Format("2006-01-02"))
 		if selectError6 != nil {
 			t.Error(selectError6)
 			return
@@ -905,6 +906,7 @@ func TestImportJustEmail(t *testing.T) {
 }
 
 func TestImportToDatabase(t *testing.T) {
+	ctx := t.Context()
 
 	ukTime, err := time.LoadLocation("Europe/London")
 	if err != nil {
@@ -930,37 +932,37 @@ func TestImportToDatabase(t *testing.T) {
 		// There will be all sorts of users and members in the Postgres database,
 		// just the  system user in SQLite.  See how many we already have.  We will
 		// check later how  many extra are created.
-		initialUsers, ue := db.GetUsers()
+		initialUsers, ue := db.GetUsers(ctx)
 		if ue != nil {
 			t.Error(ue)
 			continue
 		}
 
-		initialMembers, me := db.GetMembers()
+		initialMembers, me := db.GetMembers(ctx)
 		if me != nil {
 			t.Error(me)
 			continue
 		}
 
-		email1, e1 := database.CreateUuid(db.Transaction, "usr_login_name", "adm_users")
+		email1, e1 := database.CreateUuid(ctx, db.Transaction, "usr_login_name", "adm_users")
 		if e1 != nil {
 			t.Errorf("%s - %v", email1, e1)
 			continue
 		}
 
-		email2, e2 := database.CreateUuid(db.Transaction, "usr_login_name", "adm_users")
+		email2, e2 := database.CreateUuid(ctx, db.Transaction, "usr_login_name", "adm_users")
 		if e2 != nil {
 			t.Errorf("%s - %v", email2, e2)
 			continue
 		}
 
-		firstName, e3 := database.CreateUuid(db.Transaction, "usd_value", "adm_user_data")
+		firstName, e3 := database.CreateUuid(ctx, db.Transaction, "usd_value", "adm_user_data")
 		if e3 != nil {
 			t.Errorf("%s - %v", firstName, e3)
 			continue
 		}
 
-		lastName, e4 := database.CreateUuid(db.Transaction, "usd_value", "adm_user_data")
+		lastName, e4 := database.CreateUuid(ctx, db.Transaction, "usd_value", "adm_user_data")
 		if e4 != nil {
 			t.Errorf("%s - %v", firstName, e4)
 			continue
@@ -1024,7 +1026,7 @@ func TestImportToDatabase(t *testing.T) {
 
 		// Create the users, members etc.
 		for _, record := range wantLine {
-			id, err := ProcessRecord(db, &record)
+			id, err := ProcessRecord(ctx, db, &record)
 			if err != nil {
 				t.Error(record.UserName + ": " + err.Error())
 				continue
@@ -1036,7 +1038,7 @@ func TestImportToDatabase(t *testing.T) {
 		}
 
 		// Find out how many users we now have.
-		users, getUsersError := db.GetUsers()
+		users, getUsersError := db.GetUsers(ctx)
 
 		if getUsersError != nil {
 			t.Error(getUsersError)
@@ -1050,7 +1052,7 @@ func TestImportToDatabase(t *testing.T) {
 			continue
 		}
 
-		members, getMembersError := db.GetMembers()
+		members, getMembersError := db.GetMembers(ctx)
 		if getMembersError != nil {
 			t.Error(getMembersError)
 			continue
@@ -1063,19 +1065,19 @@ func TestImportToDatabase(t *testing.T) {
 		}
 
 		for i, record := range wantLine {
-			user, ue := db.GetUserByLoginName(record.UserName)
+			user, ue := db.GetUserByLoginName(ctx, record.UserName)
 			if ue != nil {
 				t.Error(ue)
 				break
 			}
 
-			member, merr := db.GetMemberOfUser(user)
+			member, merr := db.GetMemberOfUser(ctx, user)
 			if merr != nil {
 				t.Error(merr)
 				break
 			}
 
-			// Date fields in sqlite are like "2025-04-01", in Postgres "2025-04-01T00:00:00Z".
+			// Date fields in sqlite and MySQL are like "2025-04-01", in Postgres "2025-04-01T00:00:00Z".
 			ms := "2025-04-01"
 			if dbType == "postgres" {
 				ms = ms + "T00:00:00Z"
@@ -1093,7 +1095,7 @@ func TestImportToDatabase(t *testing.T) {
 			}
 
 			// Get the users with the given email address - should be exactly one.
-			users, getUserError := db.GetUsersByLoginName(wantLine[i].UserName)
+			users, getUserError := db.GetUsersByLoginName(ctx, wantLine[i].UserName)
 			if getUserError != nil {
 				t.Error(getUserError)
 				continue
@@ -1117,13 +1119,13 @@ func TestImportToDatabase(t *testing.T) {
 			if len(wantLine[i].Email) > 0 {
 				// Check fields in adm_user_data.
 				emID, emError :=
-					db.GetUserDataFieldIDByNameIntern("EMAIL")
+					db.GetUserDataFieldIDByNameIntern(ctx, "EMAIL")
 				if emError != nil {
 					t.Error(emError)
 					continue
 				}
 
-				email, emailError := database.GetUserDataField[string](db, emID, users[0].ID)
+				email, emailError := database.GetUserDataField[string](ctx, db, emID, users[0].ID)
 				if emailError != nil {
 					t.Error(emailError)
 					continue
@@ -1135,13 +1137,13 @@ func TestImportToDatabase(t *testing.T) {
 				}
 			} else {
 				// Expect no rows in the results.
-				emID, emError := db.GetUserDataFieldIDByNameIntern("EMAIL")
+				emID, emError := db.GetUserDataFieldIDByNameIntern(ctx, "EMAIL")
 				if emError != nil {
 					t.Error(emError)
 					continue
 				}
 
-				_, emailError := database.GetUserDataFieldErrorOnNotFound[string](db, emID, users[0].ID)
+				_, emailError := database.GetUserDataFieldErrorOnNotFound[string](ctx, db, emID, users[0].ID)
 
 				if !strings.Contains(emailError.Error(), "no rows") {
 					t.Errorf("%s: %s expected a 'no rows'  error", dbType, wantLine[i].Email)
@@ -1149,14 +1151,14 @@ func TestImportToDatabase(t *testing.T) {
 				}
 			}
 
-			salID, se := db.GetUserDataFieldIDByNameIntern("SALUTATION")
+			salID, se := db.GetUserDataFieldIDByNameIntern(ctx, "SALUTATION")
 			if se != nil {
 				t.Error(se)
 				continue
 			}
 
 			title, titleError :=
-				database.GetUserDataField[string](db, salID, users[0].ID)
+				database.GetUserDataField[string](ctx, db, salID, users[0].ID)
 			if titleError != nil {
 				t.Error(titleError)
 				continue
@@ -1167,13 +1169,13 @@ func TestImportToDatabase(t *testing.T) {
 				continue
 			}
 
-			ifn, fne := db.GetUserDataFieldIDByNameIntern("FIRST_NAME")
+			ifn, fne := db.GetUserDataFieldIDByNameIntern(ctx, "FIRST_NAME")
 			if fne != nil {
 				t.Error(fne)
 				continue
 			}
 
-			val, fnError := database.GetUserDataField[string](db, ifn, users[0].ID)
+			val, fnError := database.GetUserDataField[string](ctx, db, ifn, users[0].ID)
 			if fnError != nil {
 				t.Error(fnError)
 				continue
@@ -1183,13 +1185,13 @@ func TestImportToDatabase(t *testing.T) {
 				continue
 			}
 
-			iln, lne := db.GetUserDataFieldIDByNameIntern("LAST_NAME")
+			iln, lne := db.GetUserDataFieldIDByNameIntern(ctx, "LAST_NAME")
 			if lne != nil {
 				t.Error(lne)
 				continue
 			}
 
-			val, lnError := database.GetUserDataField[string](db, iln, users[0].ID)
+			val, lnError := database.GetUserDataField[string](ctx, db, iln, users[0].ID)
 			if lnError != nil {
 				t.Error(lnError)
 				continue
@@ -1199,14 +1201,14 @@ func TestImportToDatabase(t *testing.T) {
 				continue
 			}
 
-			a1ID, a1e := db.GetUserDataFieldIDByNameIntern("STREET")
+			a1ID, a1e := db.GetUserDataFieldIDByNameIntern(ctx, "STREET")
 			if a1e != nil {
 				t.Error(a1e)
 				continue
 			}
 
 			street, sError :=
-				database.GetUserDataField[string](db, a1ID, users[0].ID)
+				database.GetUserDataField[string](ctx, db, a1ID, users[0].ID)
 			if sError != nil {
 				t.Error(sError)
 				continue
@@ -1217,14 +1219,14 @@ func TestImportToDatabase(t *testing.T) {
 				continue
 			}
 
-			a2ID, a2e := db.GetUserDataFieldIDByNameIntern("ADDRESS_LINE_2")
+			a2ID, a2e := db.GetUserDataFieldIDByNameIntern(ctx, "ADDRESS_LINE_2")
 			if a2e != nil {
 				t.Error(a2e)
 				continue
 			}
 
 			al2, a2Error :=
-				database.GetUserDataField[string](db, a2ID, users[0].ID)
+				database.GetUserDataField[string](ctx, db, a2ID, users[0].ID)
 			if a2Error != nil {
 				t.Error(a2Error)
 				continue
@@ -1235,14 +1237,14 @@ func TestImportToDatabase(t *testing.T) {
 				continue
 			}
 
-			a3ID, a3e := db.GetUserDataFieldIDByNameIntern("ADDRESS_LINE_3")
+			a3ID, a3e := db.GetUserDataFieldIDByNameIntern(ctx, "ADDRESS_LINE_3")
 			if a3e != nil {
 				t.Error(a3e)
 				continue
 			}
 
 			al3, al3Error :=
-				database.GetUserDataField[string](db, a3ID, users[0].ID)
+				database.GetUserDataField[string](ctx, db, a3ID, users[0].ID)
 			if al3Error != nil {
 				t.Error(al3Error)
 				continue
@@ -1253,14 +1255,14 @@ func TestImportToDatabase(t *testing.T) {
 				continue
 			}
 
-			cityID, citye := db.GetUserDataFieldIDByNameIntern("CITY")
+			cityID, citye := db.GetUserDataFieldIDByNameIntern(ctx, "CITY")
 			if citye != nil {
 				t.Error(citye)
 				continue
 			}
 
 			city, cError :=
-				database.GetUserDataField[string](db, cityID, users[0].ID)
+				database.GetUserDataField[string](ctx, db, cityID, users[0].ID)
 			if cError != nil {
 				t.Error(cError)
 				continue
@@ -1271,14 +1273,14 @@ func TestImportToDatabase(t *testing.T) {
 				continue
 			}
 
-			cID, ce := db.GetUserDataFieldIDByNameIntern("COUNTY")
+			cID, ce := db.GetUserDataFieldIDByNameIntern(ctx, "COUNTY")
 			if ce != nil {
 				t.Error(ce)
 				continue
 			}
 
 			county, cError :=
-				database.GetUserDataField[string](db, cID, users[0].ID)
+				database.GetUserDataField[string](ctx, db, cID, users[0].ID)
 			if cError != nil {
 				t.Error(cError)
 				continue
@@ -1289,14 +1291,14 @@ func TestImportToDatabase(t *testing.T) {
 				continue
 			}
 
-			pcID, pce := db.GetUserDataFieldIDByNameIntern("POSTCODE")
+			pcID, pce := db.GetUserDataFieldIDByNameIntern(ctx, "POSTCODE")
 			if pce != nil {
 				t.Error(pce)
 				continue
 			}
 
 			postcode, pcError :=
-				database.GetUserDataField[string](db, pcID, users[0].ID)
+				database.GetUserDataField[string](ctx, db, pcID, users[0].ID)
 			if pcError != nil {
 				t.Error(pcError)
 				continue
@@ -1307,14 +1309,14 @@ func TestImportToDatabase(t *testing.T) {
 				continue
 			}
 
-			ctID, cte := db.GetUserDataFieldIDByNameIntern("COUNTRY")
+			ctID, cte := db.GetUserDataFieldIDByNameIntern(ctx, "COUNTRY")
 			if cte != nil {
 				t.Error(cte)
 				continue
 			}
 
 			country, ctrError :=
-				database.GetUserDataField[string](db, ctID, users[0].ID)
+				database.GetUserDataField[string](ctx, db, ctID, users[0].ID)
 			if ctrError != nil {
 				t.Error(ctrError)
 				continue
@@ -1325,14 +1327,14 @@ func TestImportToDatabase(t *testing.T) {
 				continue
 			}
 
-			phID, phe := db.GetUserDataFieldIDByNameIntern("PHONE")
+			phID, phe := db.GetUserDataFieldIDByNameIntern(ctx, "PHONE")
 			if phe != nil {
 				t.Error(phe)
 				continue
 			}
 
 			phone, pError :=
-				database.GetUserDataField[string](db, phID, users[0].ID)
+				database.GetUserDataField[string](ctx, db, phID, users[0].ID)
 			if pError != nil {
 				t.Error(pError)
 				continue
@@ -1343,14 +1345,14 @@ func TestImportToDatabase(t *testing.T) {
 				continue
 			}
 
-			mobID, getMerr := db.GetUserDataFieldIDByNameIntern("MOBILE")
+			mobID, getMerr := db.GetUserDataFieldIDByNameIntern(ctx, "MOBILE")
 			if getMerr != nil {
 				t.Error(getMerr)
 				continue
 			}
 
 			mobile, mError :=
-				database.GetUserDataField[string](db, mobID, users[0].ID)
+				database.GetUserDataField[string](ctx, db, mobID, users[0].ID)
 			if mError != nil {
 				t.Error(mError)
 				continue
@@ -1367,6 +1369,7 @@ func TestImportToDatabase(t *testing.T) {
 // TestProcesRecordEmailOnly checks that ProcessRecord handles a record
 // where only an Email address is given/
 func TestProcesRecordEmailOnly(t *testing.T) {
+	ctx := t.Context()
 
 	ukTime, err := time.LoadLocation("Europe/London")
 	if err != nil {
@@ -1392,7 +1395,7 @@ func TestProcesRecordEmailOnly(t *testing.T) {
 			return
 		}
 
-		db.BeginTx()
+		db.BeginTx(ctx)
 
 		defer db.Rollback()
 		defer db.CloseAndDelete()
@@ -1405,14 +1408,14 @@ func TestProcesRecordEmailOnly(t *testing.T) {
 		log.Printf("%s\\%s", db.SQLiteTempDir+"\\sqlite.db", db.Config.Name)
 
 		// Create the users, members etc.
-		id, createError := ProcessRecord(db, &line)
+		id, createError := ProcessRecord(ctx, db, &line)
 		if createError != nil {
 			t.Error(createError)
 			return
 		}
 
 		// Get the users with the given email address - should be exactly one.
-		users, getUserError := db.GetUsersByLoginName(line.UserName)
+		users, getUserError := db.GetUsersByLoginName(ctx, line.UserName)
 		if getUserError != nil {
 			t.Error(getUserError)
 		}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"log/slog"
@@ -16,6 +17,8 @@ import (
 var yearMembershipEnds int // The year that the membership ends.
 
 func main() {
+	ctx := context.Background()
+
 	usage := fmt.Sprintf("usage %s  CSV_file_name  year_membership_ends", os.Args[0])
 	if len(os.Args) < 3 {
 		slog.Error(usage)
@@ -57,7 +60,7 @@ func main() {
 		os.Exit(-1)
 	}
 
-	txError := db.BeginTx()
+	txError := db.BeginTx(ctx)
 	if txError != nil {
 		slog.Error(txError.Error())
 		os.Exit(-1)
@@ -65,6 +68,12 @@ func main() {
 	defer db.Rollback()
 	defer db.Close()
 
+	_, versionError := db.DetectAdmidioVersion(ctx)
+	if versionError != nil {
+		slog.Error(versionError.Error())
+		os.Exit(-1)
+	}
+
 	// Traverse the imported data and produce DB records.
 	for _, record := range records {
 		line := fmt.Sprintf("%d %s %s: %s, %s",
@@ -75,7 +84,7 @@ func main() {
 			record.Email)
 		slog.Info(line)
 
-		id, err := csvimport.ProcessRecord(db, &record)
+		id, err := csvimport.ProcessRecord(ctx, db, &record)
 		if err != nil {
 			slog.Error(err.Error())
 			continue
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"time"
+)
+
+// runCheck handles the check command, which lists anything that the payments
+// server needs but the database lacks - user fields, the Member role and the
+// tables created by the migrations.  The server makes the same check when it
+// starts and refuses to run if there are problems.
+func runCheck(args []string) error {
+	ctx := context.Background()
+
+	flags := flag.NewFlagSet("check", flag.ContinueOnError)
+	parseError := flags.Parse(args)
+	if parseError != nil {
+		return parseError
+	}
+
+	db, dbError := openDatabase()
+	if dbError != nil {
+		return dbError
+	}
+	defer db.Rollback()
+	defer db.Close()
+
+	problems, checkError := db.CheckSchema(ctx)
+	if checkError != nil {
+		return checkError
+	}
+
+	if len(problems) == 0 {
+		fmt.Println("the database is set up")
+		return nil
+	}
+
+	for _, p := range problems {
+		fmt.Println(p)
+	}
+
+	em := fmt.Sprintf("%d problems - run \"members bootstrap\" to fix them", len(problems))
+	return errors.New(em)
+}
+
+// runBootstrap handles the bootstrap command, which sets up whatever the check
+// command finds missing.
+func runBootstrap(args []string) error {
+	ctx := context.Background()
+
+	flags := flag.NewFlagSet("bootstrap", flag.ContinueOnError)
+	parseError := flags.Parse(args)
+	if parseError != nil {
+		return parseError
+	}
+
+	db, dbError := openDatabase()
+	if dbError != nil {
+		return dbError
+	}
+	defer db.Rollback()
+	defer db.Close()
+
+	done, bootstrapError := db.Bootstrap(ctx, time.Now())
+	if bootstrapError != nil {
+		return bootstrapError
+	}
+
+	if len(done) == 0 {
+		fmt.Println("nothing to do - the database is set up")
+		return nil
+	}
+
+	commitError := db.Commit()
+	if commitError != nil {
+		return commitError
+	}
+
+	for _, d := range done {
+		fmt.Println(d)
+	}
+
+	return nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/goblimey/go-stripe-payments/code/pkg/database"
+)
+
+// runDuplicates handles the duplicates command, which lists the pairs of
+// accounts that are likely to belong to the same person, best match first.
+func runDuplicates(args []string) error {
+	ctx := context.Background()
+
+	flags := flag.NewFlagSet("duplicates", flag.ContinueOnError)
+	minScore := flags.Int("min-score", database.MinimumMatchScore,
+		"only list pairs that score at least this")
+	parseError := flags.Parse(args)
+	if parseError != nil {
+		return parseError
+	}
+
+	db, dbError := openDatabase()
+	if dbError != nil {
+		return dbError
+	}
+	defer db.Rollback()
+	defer db.Close()
+
+	duplicates, findError := db.FindDuplicates(ctx)
+	if findError != nil {
+		return findError
+	}
+
+	n := 0
+	for _, d := range duplicates {
+		if d.Score < *minScore {
+			continue
+		}
+		n++
+		fmt.Printf("score %d (%s)\n", d.Score, strings.Join(d.Reasons, ", "))
+		fmt.Printf("    %s\n    %s\n", describeAccount(&d.First), describeAccount(&d.Second))
+	}
+
+	if n == 0 {
+		fmt.Println("no likely duplicates")
+		return nil
+	}
+
+	fmt.Printf("%d likely duplicates - run \"members merge -keep ID -drop ID\" to merge a pair\n", n)
+
+	return nil
+}
+
+// describeAccount gives one line describing an account for the duplicates
+// command.
+func describeAccount(md *database.MatchDetails) string {
+	parts := []string{strconv.FormatInt(md.UserID, 10), md.LoginName}
+	for _, s := range []string{md.FirstName + " " + md.LastName, md.Email, md.Street, md.Postcode} {
+		if s = strings.TrimSpace(s); len(s) > 0 {
+			parts = append(parts, s)
+		}
+	}
+	return strings.Join(parts, " | ")
+}
+
+// runMerge handles the merge command, which moves everything belonging to
+// one account onto another in one transaction.  With -dry-run it reports what
+// it would do and rolls back.
+func runMerge(args []string) error {
+	ctx := context.Background()
+
+	flags := flag.NewFlagSet("merge", flag.ContinueOnError)
+	keepID := flags.Int64("keep", 0, "the user ID of the account to keep")
+	dropID := flags.Int64("drop", 0, "the user ID of the account to merge into it")
+	dryRun := flags.Bool("dry-run", false, "report what would be done without changing anything")
+	parseError := flags.Parse(args)
+	if parseError != nil {
+		return parseError
+	}
+
+	if *keepID <= 0 || *dropID <= 0 {
+		flags.Usage()
+		return errors.New("merge: -keep and -drop are required")
+	}
+
+	db, dbError := openDatabase()
+	if dbError != nil {
+		return dbError
+	}
+	defer db.Rollback()
+	defer db.Close()
+
+	done, mergeError := db.MergeUsers(ctx, *keepID, *dropID)
+	if mergeError != nil {
+		return mergeError
+	}
+
+	if *dryRun {
+		fmt.Printf("dry run - merging user %d into user %d would do this:\n", *dropID, *keepID)
+	} else {
+		commitError := db.Commit()
+		if commitError != nil {
+			return commitError
+		}
+		fmt.Printf("merged user %d into user %d:\n", *dropID, *keepID)
+	}
+
+	for _, d := range done {
+		fmt.Println("    " + d)
+	}
+
+	return nil
+}
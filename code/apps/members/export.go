@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/goblimey/go-stripe-payments/code/pkg/database"
+	"github.com/goblimey/go-stripe-payments/code/pkg/export"
+)
+
+// runExport handles the export command, which writes the contact details of
+// the members to stdout.
+func runExport(args []string) error {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	status := flags.String("status", database.MemberStatusCurrent,
+		"the members to export - current, lapsed or all")
+	interest := flags.String("interest", "", "only members with this interest")
+	consent := flags.String("consent", "",
+		"only members who have ticked this field, for example "+database.EmailPermNameIntern)
+	format := flags.String("format", export.FormatCSV, "the output format, csv or vcard")
+	parseError := flags.Parse(args)
+	if parseError != nil {
+		return parseError
+	}
+
+	db, dbError := openDatabase()
+	if dbError != nil {
+		return dbError
+	}
+	defer db.Rollback()
+	defer db.Close()
+
+	filter := database.MemberFilter{Status: *status, ConsentField: *consent}
+
+	return writeExport(os.Stdout, db, &filter, *interest, *format, time.Now())
+}
+
+// writeExport writes the members selected by the filter and, if it's not
+// empty, the name of an interest.
+func writeExport(w io.Writer, db *database.Database, filter *database.MemberFilter, interest, format string, now time.Time) error {
+	ctx := context.Background()
+
+	if interest != "" {
+		i, interestError := db.GetInterestByName(ctx, interest)
+		if interestError != nil {
+			return interestError
+		}
+		filter.InterestID = i.ID
+	}
+
+	if filter.ConsentField != "" {
+		_, fieldError := db.GetUserDataFieldIDByNameIntern(ctx, filter.ConsentField)
+		if fieldError != nil {
+			em := fmt.Sprintf("unknown consent field %s", filter.ConsentField)
+			return errors.New(em)
+		}
+	}
+
+	members, fetchError := db.GetMemberDetails(ctx, filter, now)
+	if fetchError != nil {
+		return fetchError
+	}
+
+	return export.Write(w, format, members)
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/goblimey/go-stripe-payments/code/pkg/database"
+	"github.com/goblimey/go-stripe-payments/code/pkg/labels"
+)
+
+// runLabels handles the labels command, which writes address labels for the
+// current members who have to be written to by post.
+func runLabels(args []string) error {
+	ctx := context.Background()
+
+	flags := flag.NewFlagSet("labels", flag.ContinueOnError)
+	pdfFile := flags.String("pdf", "", "write a PDF of sheets of labels to this file")
+	csvFile := flags.String("csv", "", "write the labels as CSV to this file (default stdout)")
+	layoutName := flags.String("layout", "L7160",
+		"the sheet of labels - "+strings.Join(labels.LayoutNames(), ", "))
+	skip := flags.Int("skip", 0, "the number of labels already used on the first sheet")
+	homeCountry := flags.String("home-country", "United Kingdom",
+		"the country that is left out of addresses")
+	parseError := flags.Parse(args)
+	if parseError != nil {
+		return parseError
+	}
+
+	layout, ok := labels.Layouts[*layoutName]
+	if !ok {
+		em := fmt.Sprintf("unknown layout %s - want one of %s",
+			*layoutName, strings.Join(labels.LayoutNames(), ", "))
+		return errors.New(em)
+	}
+
+	db, dbError := openDatabase()
+	if dbError != nil {
+		return dbError
+	}
+	defer db.Rollback()
+	defer db.Close()
+
+	members, fetchError := db.GetMemberDetails(ctx, &database.MemberFilter{}, time.Now())
+	if fetchError != nil {
+		return fetchError
+	}
+
+	byPost := make([]database.MemberDetails, 0)
+	for i := range members {
+		if !labels.NeedsPost(&members[i]) {
+			continue
+		}
+		if !labels.HasAddress(&members[i]) {
+			slog.Warn("no postal address for " + members[i].LoginName)
+			continue
+		}
+		byPost = append(byPost, members[i])
+	}
+
+	households := labels.Households(byPost, *homeCountry)
+
+	slog.Info(fmt.Sprintf("%d members to write to by post, %d labels", len(byPost), len(households)))
+
+	if *pdfFile != "" {
+		pdfError := writeFile(*pdfFile, func(f *os.File) error {
+			return labels.WritePDF(f, households, layout, *skip)
+		})
+		if pdfError != nil {
+			return pdfError
+		}
+	}
+
+	if *csvFile == "" {
+		if *pdfFile != "" {
+			// Just the PDF.
+			return nil
+		}
+		return labels.WriteCSV(os.Stdout, households)
+	}
+
+	return writeFile(*csvFile, func(f *os.File) error {
+		return labels.WriteCSV(f, households)
+	})
+}
+
+// writeFile creates the named file and writes to it using the given function.
+func writeFile(name string, write func(f *os.File) error) error {
+	f, createError := os.Create(name)
+	if createError != nil {
+		return createError
+	}
+
+	writeError := write(f)
+	closeError := f.Close()
+	if writeError != nil {
+		return writeError
+	}
+
+	return closeError
+}
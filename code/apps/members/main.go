@@ -0,0 +1,116 @@
+/*
+members is a command line tool for the membership secretary.  It works on the
+same database as the payments web server and takes the database details from
+the same environment variables (DBType, DBHost and so on).
+
+The first argument is the command, for example:
+
+	members bootstrap
+	members migrate up
+	members report -year 2025 -format csv > 2025.csv
+	members export -interest "Roman roads" -format vcard > roads.vcf
+	members labels -layout L7163 -pdf labels.pdf -csv labels.csv
+	members duplicates
+	members merge -keep 42 -drop 57 -dry-run
+
+Run it with no arguments for a list of the commands.
+*/
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+
+	_ "github.com/lib/pq"
+
+	"github.com/goblimey/go-stripe-payments/code/pkg/database"
+)
+
+// command is one of the subcommands.
+type command struct {
+	run         func(args []string) error // Runs the command with the rest of the arguments.
+	description string                    // One line for the usage message.
+}
+
+// commands maps the name of each subcommand to the command.
+var commands = map[string]command{
+	"bootstrap":  {runBootstrap, "create the user fields, role and tables that the check command finds missing"},
+	"check":      {runCheck, "check that the database has the user fields, role and tables that the server needs"},
+	"duplicates": {runDuplicates, "list the pairs of accounts that are likely to belong to the same person"},
+	"export":     {runExport, "write the members' contact details as CSV or vCard"},
+	"labels":     {runLabels, "write address labels for the members without email as PDF and CSV"},
+	"merge":      {runMerge, "move everything belonging to one account onto another"},
+	"migrate":    {runMigrate, "apply the schema migrations (up) or list them (status)"},
+	"report":     {runReport, "summarise the membership sales of a year as CSV or JSON"},
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(-1)
+	}
+
+	c, ok := commands[os.Args[1]]
+	if !ok {
+		slog.Error("unknown command " + os.Args[1])
+		usage()
+		os.Exit(-1)
+	}
+
+	runError := c.run(os.Args[2:])
+	if runError != nil {
+		slog.Error(runError.Error())
+		os.Exit(-1)
+	}
+}
+
+// usage writes a list of the commands to stderr.
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: %s command [flags]\n\ncommands:\n", os.Args[0])
+
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "    %-12s %s\n", name, commands[name].description)
+	}
+
+	fmt.Fprintf(os.Stderr, "\nrun '%s command -h' for the flags of a command\n", os.Args[0])
+}
+
+// openDatabase connects to the database given by the environment variables,
+// starts a transaction and checks the version of Admidio.  The caller should roll back (or commit) and close.
+func openDatabase() (*database.Database, error) {
+	ctx := context.Background()
+
+	dbConfig := database.GetDBConfigFromTheEnvironment()
+
+	db := database.New(&dbConfig)
+
+	connError := db.Connect()
+	if connError != nil {
+		return nil, connError
+	}
+
+	txError := db.BeginTx(ctx)
+	if txError != nil {
+		db.Close()
+		return nil, txError
+	}
+
+	// Choose the queries for the version of Admidio.
+	_, versionError := db.DetectAdmidioVersion(ctx)
+	if versionError != nil {
+		db.Rollback()
+		db.Close()
+		return nil, versionError
+	}
+
+	return db, nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"time"
+)
+
+// runMigrate handles the migrate command.  "migrate up" applies the schema
+// migrations that haven't been applied yet.  "migrate status" lists the
+// migrations and says which have been applied.
+func runMigrate(args []string) error {
+	ctx := context.Background()
+
+	flags := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: members migrate up|status\n")
+	}
+	parseError := flags.Parse(args)
+	if parseError != nil {
+		return parseError
+	}
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return errors.New("migrate: want one argument, up or status")
+	}
+
+	db, dbError := openDatabase()
+	if dbError != nil {
+		return dbError
+	}
+	defer db.Rollback()
+	defer db.Close()
+
+	switch flags.Arg(0) {
+	case "up":
+		done, migrateError := db.MigrateUp(ctx, time.Now())
+		if migrateError != nil {
+			return migrateError
+		}
+
+		if len(done) == 0 {
+			fmt.Println("nothing to do - the schema is up to date")
+			return nil
+		}
+
+		commitError := db.Commit()
+		if commitError != nil {
+			return commitError
+		}
+
+		for _, m := range done {
+			fmt.Printf("applied %04d_%s\n", m.Version, m.Name)
+		}
+
+	case "status":
+		statuses, statusError := db.MigrationStatuses(ctx)
+		if statusError != nil {
+			return statusError
+		}
+
+		for _, s := range statuses {
+			applied := "pending"
+			if s.Applied {
+				applied = s.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%04d_%-40s %s\n", s.Version, s.Name, applied)
+		}
+
+	default:
+		flags.Usage()
+		em := fmt.Sprintf("migrate: unknown argument %q - want up or status", flags.Arg(0))
+		return errors.New(em)
+	}
+
+	return nil
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/goblimey/go-stripe-payments/code/pkg/database"
+	"github.com/goblimey/go-stripe-payments/code/pkg/report"
+)
+
+// runReport handles the report command, which writes a summary of the sales
+// of a membership year to stdout.
+func runReport(args []string) error {
+	flags := flag.NewFlagSet("report", flag.ContinueOnError)
+	year := flags.Int("year", database.GetMembershipYear(time.Now()),
+		"the membership year")
+	format := flags.String("format", report.FormatCSV, "the output format, csv or json")
+	parseError := flags.Parse(args)
+	if parseError != nil {
+		return parseError
+	}
+
+	db, dbError := openDatabase()
+	if dbError != nil {
+		return dbError
+	}
+	defer db.Rollback()
+	defer db.Close()
+
+	return writeReport(os.Stdout, db, *year, *format)
+}
+
+// writeReport writes the summary of the sales of the given membership year.
+func writeReport(w io.Writer, db *database.Database, year int, format string) error {
+	ctx := context.Background()
+
+	summaries, reportError := db.GetSalesSummaries(ctx, year)
+	if reportError != nil {
+		em := fmt.Sprintf("report for %d: %v", year, reportError)
+		return errors.New(em)
+	}
+
+	return report.Write(w, format, summaries)
+}
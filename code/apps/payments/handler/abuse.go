@@ -0,0 +1,132 @@
+package handler
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/goblimey/go-stripe-payments/code/pkg/token"
+)
+
+// Protection against bots and other abuse of the sale form.  The form contains
+// a honeypot - a text box that a human can't see and so leaves empty - and a
+// signed timestamp recording when it was displayed.  A bot tends to fill in
+// every box and submit the form straight away.  On top of that, submissions are
+// rate limited per IP address and per email address.  All of this is done in
+// memory and blocked attempts are logged.  The signed timestamp is refused
+// once it's older than the configured maximum age, so one harvested from the
+// form can't be replayed for ever.
+
+// honeypotField is the name of the hidden text box in the sale form.  It's named
+// to look attractive to a bot.
+const honeypotField = "website"
+
+// formStartedField is the name of the hidden field carrying the signed time at
+// which the sale form was displayed.
+const formStartedField = "form_started"
+
+// issueFormStarted returns a signed token recording the given time, to be put
+// in the sale form.
+func (h *Handler) issueFormStarted(now time.Time) string {
+	return h.Signer.Sign(token.PurposeForm, strconv.FormatInt(now.Unix(), 10))
+}
+
+// saleFormBlocked checks a submission of the sale form for signs of abuse.  If
+// it finds any it logs the attempt and returns true.
+func (h *Handler) saleFormBlocked(r *http.Request, now time.Time) bool {
+
+	const fn = "saleFormBlocked"
+
+	if len(r.PostFormValue(honeypotField)) > 0 {
+		h.logBlocked(fn, r, "honeypot filled in")
+		return true
+	}
+
+	started, startedOK := h.formStarted(r.PostFormValue(formStartedField))
+	if !startedOK {
+		h.logBlocked(fn, r, "missing or invalid form timestamp")
+		return true
+	}
+
+	if now.Sub(started) < h.Conf.MinTimeToSubmit() {
+		h.logBlocked(fn, r, fmt.Sprintf("submitted after %v", now.Sub(started)))
+		return true
+	}
+
+	if now.Sub(started) > h.Conf.MaxFormAge() {
+		h.logBlocked(fn, r, fmt.Sprintf("form timestamp is %v old", now.Sub(started)))
+		return true
+	}
+
+	return h.rateLimited(r, now)
+}
+
+// rateLimited counts a submission against the IP address that sent it and the
+// email addresses in it.  If any of them is over its limit it logs the attempt
+// and returns true.
+func (h *Handler) rateLimited(r *http.Request, now time.Time) bool {
+
+	const fn = "rateLimited"
+
+	if h.ipRateLimited(r, now) {
+		return true
+	}
+
+	for _, name := range []string{"email", "assoc_email"} {
+		email := strings.ToLower(strings.TrimSpace(r.PostFormValue(name)))
+		if len(email) == 0 {
+			continue
+		}
+		if !h.EmailLimiter.Allow(email, now) {
+			h.logBlocked(fn, r, "too many requests for "+email)
+			return true
+		}
+	}
+
+	return false
+}
+
+// ipRateLimited counts a request against the IP address that sent it.  If the
+// address is over its limit it logs the attempt and returns true.
+func (h *Handler) ipRateLimited(r *http.Request, now time.Time) bool {
+	if !h.IPLimiter.Allow(clientIP(r), now) {
+		h.logBlocked("ipRateLimited", r, "too many requests from this address")
+		return true
+	}
+
+	return false
+}
+
+// formStarted checks the signed timestamp from the sale form and returns the
+// time that it contains.
+func (h *Handler) formStarted(tok string) (time.Time, bool) {
+	payload, verifyError := h.Signer.Verify(token.PurposeForm, tok)
+	if verifyError != nil {
+		return time.Time{}, false
+	}
+
+	seconds, parseError := strconv.ParseInt(payload, 10, 64)
+	if parseError != nil {
+		return time.Time{}, false
+	}
+
+	return time.Unix(seconds, 0), true
+}
+
+// logBlocked logs a blocked attempt to use the sale form.
+func (h *Handler) logBlocked(fn string, r *http.Request, reason string) {
+	h.Logger.Warn("blocked sale form submission", "fn", fn, "reason", reason,
+		"remote", clientIP(r), "email", r.PostFormValue("email"))
+}
+
+// clientIP returns the IP address of the client that sent the request.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
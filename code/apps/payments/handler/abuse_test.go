@@ -0,0 +1,107 @@
+package handler
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/goblimey/go-stripe-payments/code/pkg/config"
+	"github.com/goblimey/go-stripe-payments/code/pkg/token"
+)
+
+// TestSaleFormBlocked checks the honeypot and time-to-submit checks.
+func TestSaleFormBlocked(t *testing.T) {
+
+	now := time.Date(2025, time.March, 1, 12, 0, 0, 0, time.UTC)
+
+	h := New(&testConfig)
+	h.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	started := h.issueFormStarted(now.Add(-time.Minute))
+	tooRecent := h.issueFormStarted(now.Add(-time.Second))
+	tooOld := h.issueFormStarted(now.Add(-h.Conf.MaxFormAge() - time.Second))
+	forged := token.NewSigner("not the secret").Sign(token.PurposeForm, "0")
+	saleToken := h.Signer.IssueSaleToken(&token.SaleToken{SaleID: 1, Expires: now})
+
+	var testData = []struct {
+		description string
+		honeypot    string
+		formStarted string
+		want        bool
+	}{
+		{"human", "", started, false},
+		{"honeypot", "http://spam.example.com", started, true},
+		{"too fast", "", tooRecent, true},
+		{"replayed old timestamp", "", tooOld, true},
+		{"no timestamp", "", "", true},
+		{"forged timestamp", "", forged, true},
+		{"token for another purpose", "", saleToken, true},
+	}
+
+	for i, td := range testData {
+		values := make(url.Values, 0)
+		values.Add("email", "a@example.com")
+		values.Add(honeypotField, td.honeypot)
+		values.Add(formStartedField, td.formStarted)
+		r := http.Request{PostForm: values, RemoteAddr: fmt.Sprintf("192.0.2.%d:1234", i+1)}
+
+		got := h.saleFormBlocked(&r, now)
+		if got != td.want {
+			t.Errorf("%s: want %v got %v", td.description, td.want, got)
+		}
+	}
+}
+
+// TestRateLimited checks that submissions are limited per IP address and per
+// email address.
+func TestRateLimited(t *testing.T) {
+
+	now := time.Date(2025, time.March, 1, 12, 0, 0, 0, time.UTC)
+
+	conf := testConfig
+	conf.RateLimitPerIP = 3
+	conf.RateLimitPerEmail = 2
+
+	var testData = []struct {
+		description string
+		config      config.Config
+		remoteAddrs []string
+		emails      []string
+		want        []bool
+	}{
+		{
+			"per IP",
+			conf,
+			[]string{"192.0.2.1:1", "192.0.2.1:2", "192.0.2.1:3", "192.0.2.1:4", "192.0.2.2:1"},
+			[]string{"a@example.com", "b@example.com", "c@example.com", "d@example.com", "e@example.com"},
+			[]bool{false, false, false, true, false},
+		},
+		{
+			"per email",
+			conf,
+			[]string{"192.0.2.1:1", "192.0.2.2:1", "192.0.2.3:1"},
+			[]string{"a@example.com", "A@Example.com ", "a@example.com"},
+			[]bool{false, false, true},
+		},
+	}
+
+	for _, td := range testData {
+		h := New(&td.config)
+		h.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+		for i := range td.remoteAddrs {
+			values := make(url.Values, 0)
+			values.Add("email", td.emails[i])
+			r := http.Request{PostForm: values, RemoteAddr: td.remoteAddrs[i]}
+
+			got := h.rateLimited(&r, now)
+			if got != td.want[i] {
+				t.Errorf("%s: request %d: want %v got %v", td.description, i, td.want[i], got)
+			}
+		}
+	}
+}
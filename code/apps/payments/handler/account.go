@@ -0,0 +1,439 @@
+package handler
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"text/template"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/goblimey/go-stripe-payments/code/pkg/csrf"
+	"github.com/goblimey/go-stripe-payments/code/pkg/database"
+)
+
+// The member's account area.  A member logs in with the user name and password
+// of their Admidio account and sees their membership status and the history
+// of their online payments.  They can change their extra details (address etc),
+// their email and data protection consents and their Gift Aid declaration
+// without making a payment.  Admidio stores a bcrypt hash of the password in
+// adm_users.usr_password.  A successful login creates a session that is carried
+// in a cookie.
+
+// accountSessionCookie is the name of the cookie that carries the session ID.
+const accountSessionCookie = "account_session"
+
+// loginFailedMessage is displayed when a login attempt fails.  It doesn't say
+// whether the user name or the password was wrong.
+const loginFailedMessage = "The user name or password is not correct."
+
+// detailsSavedMessage is displayed when the member's details have been saved.
+const detailsSavedMessage = "Your details have been saved."
+
+// accountLogin holds the data for the login page.
+type accountLogin struct {
+	OrganisationName string
+	CSRFToken        string
+	LoginName        string
+	ErrorMessage     string
+}
+
+// accountPage holds the data for the account page.  The extra details form uses
+// the same fields as the extra details form after a sale, so the page data
+// includes a MembershipSale.
+type accountPage struct {
+	*database.MembershipSale
+	Status         string                    // A description of the membership status.
+	Sales          []database.MembershipSale // The member's payments, newest first.
+	ReceiveEmail   bool                      // True if the member agrees to receive email.
+	DataProtection bool                      // True if the member agrees to us holding their details.
+	Message        string                    // Displayed when the details have been saved.
+}
+
+// AccountLogin handles the /account/login request.  A GET displays the login
+// form.  A POST checks the user name and password and, if they are correct,
+// logs the member in and redirects to the account page.
+func (h *Handler) AccountLogin(w http.ResponseWriter, r *http.Request) {
+
+	h.Logger.Info("AccountLogin")
+
+	if r.Method != http.MethodPost {
+		h.displayAccountLoginPage(w, r, "", "")
+		return
+	}
+
+	// Logging in doesn't change the database.
+	h.withRequest(r.Context(), w, h.PrePaymentErrorHTML, func(h *Handler) {
+		h.accountLoginHelper(w, r, time.Now())
+	})
+}
+
+// accountLoginHelper checks the login form and, if the user name and password
+// are correct, creates a session.  Attempts are rate limited per IP address,
+// and a user name is locked out for a while after too many failed attempts,
+// to slow down password guessing.  The helper is separated out to support unit
+// testing.
+func (h *Handler) accountLoginHelper(w http.ResponseWriter, r *http.Request, now time.Time) {
+
+	const fn = "accountLoginHelper"
+
+	loginName := strings.TrimSpace(r.PostFormValue("login_name"))
+	password := r.PostFormValue("password")
+
+	if h.ipRateLimited(r, now) {
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write([]byte(h.BlockedHTML))
+		return
+	}
+
+	if h.LoginLimiter.Blocked(strings.ToLower(loginName), now) {
+		h.Logger.Warn("blocked login", "fn", fn, "reason", "too many failed attempts",
+			"remote", clientIP(r), "login_name", loginName)
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write([]byte(h.BlockedHTML))
+		return
+	}
+
+	userID, ok, checkError := h.checkLogin(loginName, password)
+	if checkError != nil {
+		h.reportError(w, h.PrePaymentErrorHTML, checkError)
+		return
+	}
+
+	if !ok {
+		h.LoginLimiter.Record(strings.ToLower(loginName), now)
+		h.Logger.Warn("login failed", "fn", fn, "remote", clientIP(r), "login_name", loginName)
+		w.WriteHeader(http.StatusUnauthorized)
+		h.displayAccountLoginPage(w, r, loginName, loginFailedMessage)
+		return
+	}
+
+	_, sessionError := h.Sessions.Create(w, userID, now)
+	if sessionError != nil {
+		h.reportError(w, h.PrePaymentErrorHTML, sessionError)
+		return
+	}
+
+	h.logMessage("%s: user %d logged in", fn, userID)
+
+	http.Redirect(w, r, "/account", http.StatusSeeOther)
+}
+
+// dummyPasswordHash is a bcrypt hash that matches no password.  checkLogin
+// compares the password with it when there is no account to check, so that a
+// login name that doesn't exist takes as long to reject as a wrong password.
+const dummyPasswordHash = "$2a$10$myG98h/SCSo951uiop3qPum/hhuniI9p/9v4CAl3FbOyk3mNe/8C2"
+
+// checkLogin checks the given user name and password against adm_users.  It
+// returns the user ID and true if they match a valid account.
+func (h *Handler) checkLogin(loginName, password string) (int64, bool, error) {
+
+	if len(loginName) == 0 || len(password) == 0 {
+		return 0, false, nil
+	}
+
+	user, userError := h.DB.GetUserByLoginName(h.ctx, loginName)
+	if userError != nil && !errors.Is(userError, sql.ErrNoRows) {
+		return 0, false, userError
+	}
+
+	found := userError == nil && user.Valid
+
+	hash := dummyPasswordHash
+	if found {
+		hash = user.Password
+	}
+
+	// An account that can't log in to Admidio has a password that is not a
+	// bcrypt hash, for example '*LK*'.  bcrypt rejects those.
+	compareError := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	if compareError != nil || !found {
+		return 0, false, nil
+	}
+
+	// Success!
+	return user.ID, true, nil
+}
+
+// AccountLogout handles the /account/logout request.  A POST ends the session
+// and redirects to the login page.  Any other method is refused.
+func (h *Handler) AccountLogout(w http.ResponseWriter, r *http.Request) {
+
+	h.Logger.Info("AccountLogout")
+
+	// Logging out changes state, so, like the other forms, it must be a POST,
+	// which carries the CSRF token.
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	h.Sessions.Delete(w, r)
+
+	http.Redirect(w, r, "/account/login", http.StatusSeeOther)
+}
+
+// Account handles the /account request.  If the member is not logged in it
+// redirects to the login page.  A GET displays the account page.  A POST saves
+// the details from the form and displays the page again.
+func (h *Handler) Account(w http.ResponseWriter, r *http.Request) {
+
+	h.Logger.Info("Account")
+
+	now := time.Now().In(h.TZ)
+
+	s := h.Sessions.Get(r, now)
+	if s == nil {
+		http.Redirect(w, r, "/account/login", http.StatusSeeOther)
+		return
+	}
+
+	// The helper commits if the member's details are saved.
+	h.withRequest(r.Context(), w, h.PrePaymentErrorHTML, func(h *Handler) {
+		h.accountHelper(w, r, s.UserID, now)
+	})
+}
+
+// accountHelper displays the account page for the given user and, on a POST,
+// saves the details from the form first.  The helper is separated out to
+// support unit testing.
+func (h *Handler) accountHelper(w http.ResponseWriter, r *http.Request, userID int64, now time.Time) {
+
+	const fn = "accountHelper"
+
+	user, userError := h.DB.GetUser(h.ctx, userID)
+	if userError != nil {
+		h.logError("%s: user %d - %v", fn, userID, userError)
+		w.Write([]byte(h.PrePaymentErrorHTML))
+		return
+	}
+
+	ms := database.NewMembershipSale(h.Conf)
+	ms.UserID = user.ID
+	ms.AccountName = user.LoginName
+	ms.CSRFToken = csrf.Token(r)
+
+	page := accountPage{MembershipSale: ms}
+
+	if r.Method == http.MethodPost {
+
+		readError := h.readExtraDetails(r, ms, false)
+		if readError != nil {
+			h.logError("%s: %v", fn, readError)
+			w.Write([]byte(h.PrePaymentErrorHTML))
+			return
+		}
+
+		page.ReceiveEmail = len(r.PostFormValue("receive_email")) > 0
+		page.DataProtection = len(r.PostFormValue("data_protection")) > 0
+		ms.Giftaid = len(r.PostFormValue("giftaid")) > 0
+
+		if h.validateExtraDetails(ms) {
+
+			saveError := h.saveAccountDetails(&page)
+			if saveError != nil {
+				h.logError("%s: %v", fn, saveError)
+				w.Write([]byte(h.PrePaymentErrorHTML))
+				return
+			}
+
+			commitError := h.DB.Commit()
+			if commitError != nil {
+				h.logError("%s: %v", fn, commitError)
+				w.Write([]byte(h.PrePaymentErrorHTML))
+				return
+			}
+
+			h.logMessage("%s: user %d saved their details", fn, userID)
+
+			page.Message = detailsSavedMessage
+
+			// The rest of the page is read in a new transaction.
+			txError := h.DB.BeginTx(h.ctx)
+			if txError != nil {
+				h.logError("%s: %v", fn, txError)
+				w.Write([]byte(h.PrePaymentErrorHTML))
+				return
+			}
+		}
+	} else {
+		h.fetchCurrentExtraDetails(ms)
+		data, dataError := h.DB.GetUserData(h.ctx, userID)
+		if dataError == nil {
+			page.ReceiveEmail = data[userID].NoticesByEmail
+			page.DataProtection = data[userID].DataProtection
+			ms.Giftaid = data[userID].Giftaid
+		}
+	}
+
+	page.Status = h.describeMembershipStatus(userID, now)
+
+	var salesError error
+	page.Sales, salesError = h.DB.GetMembershipSalesOfUser(h.ctx, userID)
+	if salesError != nil {
+		h.logError("%s: %v", fn, salesError)
+		w.Write([]byte(h.PrePaymentErrorHTML))
+		return
+	}
+
+	h.displayAccountPage(w, &page)
+}
+
+// saveAccountDetails saves the details from the account page.  Unlike the extra
+// details form after a sale, the member is changing details that they gave
+// before, so a blank box clears the value on record and the interests replace
+// the ones chosen last time.
+func (h *Handler) saveAccountDetails(page *accountPage) error {
+
+	ms := page.MembershipSale
+
+	// SaveExtraDetails only sets the values that are given.  Clear the optional
+	// ones that have been left blank.  (Saving an empty text field removes it.)
+	optional := []struct {
+		value string
+		field string
+	}{
+		{ms.AddressLine2, "ADDRESS_LINE_2"},
+		{ms.AddressLine3, "ADDRESS_LINE_3"},
+		{ms.County, "COUNTY"},
+		{ms.Phone, "PHONE"},
+		{ms.Mobile, "MOBILE"},
+		{ms.LocationOfInterest, "LOCATION_OF_INTEREST"},
+	}
+	blank := make([]string, 0, len(optional))
+	for _, c := range optional {
+		if len(c.value) == 0 {
+			blank = append(blank, c.field)
+		}
+	}
+	clearError := h.DB.SaveUserData(h.ctx, &database.UserData{UserID: ms.UserID}, blank...)
+	if clearError != nil {
+		return clearError
+	}
+
+	deleteError := h.DB.DeleteMembersInterests(h.ctx, ms.UserID)
+	if deleteError != nil {
+		return deleteError
+	}
+
+	// SaveExtraDetails creates the other interests record, which may already
+	// exist.  Upsert it separately.
+	otherInterests := ms.OtherTopicsOfInterest
+	ms.OtherTopicsOfInterest = ""
+	saveError := h.DB.SaveExtraDetails(h.ctx, ms)
+	ms.OtherTopicsOfInterest = otherInterests
+	if saveError != nil {
+		return saveError
+	}
+
+	moi := database.NewMembersOtherInterests(ms.UserID, otherInterests)
+	moiError := h.DB.UpsertMembersOtherInterests(h.ctx, moi)
+	if moiError != nil {
+		return moiError
+	}
+
+	ud := database.UserData{
+		UserID:         ms.UserID,
+		NoticesByEmail: page.ReceiveEmail,
+		DataProtection: page.DataProtection,
+		Giftaid:        ms.Giftaid,
+	}
+	boxes := []string{"NOTICES_BY_EMAIL", database.DataStoragePermNameIntern}
+	if h.Conf.EnableGiftaid {
+		boxes = append(boxes, "GIFT_AID")
+	}
+	boxError := h.DB.SaveUserData(h.ctx, &ud, boxes...)
+	if boxError != nil {
+		return boxError
+	}
+
+	// Success!
+	return nil
+}
+
+// describeMembershipStatus returns a description of the user's membership for
+// the account page.  Membership runs to the end of the calendar year.
+func (h *Handler) describeMembershipStatus(userID int64, now time.Time) string {
+
+	year, yearError := h.DB.GetMembershipYearOfUser(h.ctx, userID)
+	if yearError != nil || year == 0 {
+		return "We have no record of your membership."
+	}
+
+	if year >= now.Year() {
+		return fmt.Sprintf("Your membership runs until 31 December %d.", year)
+	}
+
+	return fmt.Sprintf("Your membership ended on 31 December %d.", year)
+}
+
+// displayAccountLoginPage displays the login form with the given user name and
+// error message.
+func (h *Handler) displayAccountLoginPage(w http.ResponseWriter, r *http.Request, loginName, errorMessage string) {
+
+	data := accountLogin{
+		OrganisationName: h.Conf.OrganisationName,
+		CSRFToken:        csrf.Token(r),
+		LoginName:        loginName,
+		ErrorMessage:     errorMessage,
+	}
+
+	loginPageTemplate, parseError := template.New("AccountLoginPage").
+		Parse(accountLoginTemplateString)
+	if parseError != nil {
+		h.logError("%v", parseError)
+		w.Write([]byte(h.PrePaymentErrorHTML))
+		return
+	}
+
+	executeError := loginPageTemplate.Execute(w, &data)
+	if executeError != nil {
+		h.logError("%v", executeError)
+		w.Write([]byte(h.PrePaymentErrorHTML))
+		return
+	}
+}
+
+// displayAccountPage displays the account page.
+func (h *Handler) displayAccountPage(w http.ResponseWriter, page *accountPage) {
+
+	// Put the member's country at the top of the selection list if they have
+	// given one, otherwise the UK.
+	var countriesHTML string
+	var countriesError error
+	if len(page.CountryCode) > 0 && page.CountryCode != "0" {
+		countriesHTML, countriesError = h.MakeCountrySelectionListPreSelecting(page.CountryCode)
+	} else {
+		countriesHTML, countriesError = h.MakeCountrySelectionListFavouring("GBR")
+	}
+	if countriesError != nil {
+		h.logError("%v", countriesError)
+		w.Write([]byte(h.PrePaymentErrorHTML))
+		return
+	}
+
+	interestHTML := h.makeInterestSelectionHTML(page.MembershipSale)
+
+	accountPageTemplateString := accountPageTemplateString1 +
+		profileRowsTemplate(countriesHTML, "") + extraFieldRows + interestHTML +
+		accountPageTemplateString2
+
+	accountPageTemplate, parseError := template.New("AccountPage").
+		Parse(accountPageTemplateString)
+	if parseError != nil {
+		h.logError("%v", parseError)
+		w.Write([]byte(h.PrePaymentErrorHTML))
+		return
+	}
+
+	executeError := accountPageTemplate.Execute(w, page)
+	if executeError != nil {
+		h.logError("%v", executeError)
+		w.Write([]byte(h.PrePaymentErrorHTML))
+		return
+	}
+}
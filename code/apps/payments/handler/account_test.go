@@ -0,0 +1,416 @@
+package handler
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/goblimey/go-stripe-payments/code/pkg/database"
+	websession "github.com/goblimey/go-stripe-payments/code/pkg/session"
+)
+
+// TestAccountLogin checks that a member can log in with the password stored in
+// adm_users and that a wrong password or a locked account is refused.
+func TestAccountLogin(t *testing.T) {
+	ctx := t.Context()
+
+	for _, dbType := range databaseList {
+
+		db, connError := database.OpenDBForTesting(dbType)
+		if connError != nil {
+			t.Error(connError)
+			continue
+		}
+
+		db.BeginTx(ctx)
+
+		defer db.Rollback()
+		defer db.CloseAndDelete()
+
+		prepError := database.PrepareTestTables(db)
+		if prepError != nil {
+			t.Error(prepError)
+			continue
+		}
+
+		h := New(&testConfig)
+		h.DB = db
+		h.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+		user := createTestUserWithPassword(db, t, "correct horse")
+
+		// A locked account.  CreateUser sets the password to '*LK*'.
+		locked := createTestUser(db, t)
+		locked.Valid = true
+		db.UpdateUser(ctx, locked)
+
+		// An account that isn't valid can't log in with the right password.
+		invalid := createTestUserWithPassword(db, t, "battery staple")
+		invalid.Valid = false
+		db.UpdateUser(ctx, invalid)
+
+		var testData = []struct {
+			description string
+			loginName   string
+			password    string
+			wantLogin   bool
+		}{
+			{"correct", user.LoginName, "correct horse", true},
+			{"upper case name", strings.ToUpper(user.LoginName), "correct horse", true},
+			{"wrong password", user.LoginName, "Correct horse", false},
+			{"no password", user.LoginName, "", false},
+			{"unknown user", "nobody", "correct horse", false},
+			{"locked", locked.LoginName, "*LK*", false},
+			{"not valid", invalid.LoginName, "battery staple", false},
+		}
+
+		for _, td := range testData {
+			w := accountLoginRequest(h, td.loginName, td.password)
+
+			cookies := w.Result().Cookies()
+
+			if td.wantLogin {
+				if w.Code != http.StatusSeeOther {
+					t.Errorf("%s %s: want %d got %d", dbType, td.description, http.StatusSeeOther, w.Code)
+				}
+				if len(cookies) != 1 || cookies[0].Name != accountSessionCookie {
+					t.Errorf("%s %s: want a session cookie got %v", dbType, td.description, cookies)
+					continue
+				}
+				r := http.Request{Header: http.Header{}}
+				r.AddCookie(cookies[0])
+				s := h.Sessions.Get(&r, time.Now())
+				if s == nil || s.UserID != user.ID {
+					t.Errorf("%s %s: want a session for user %d got %v",
+						dbType, td.description, user.ID, s)
+				}
+			} else {
+				if w.Code != http.StatusUnauthorized {
+					t.Errorf("%s %s: want %d got %d", dbType, td.description, http.StatusUnauthorized, w.Code)
+				}
+				if len(cookies) != 0 {
+					t.Errorf("%s %s: want no cookie got %v", dbType, td.description, cookies)
+				}
+				if !strings.Contains(w.Body.String(), loginFailedMessage) {
+					t.Errorf("%s %s: want the login failed message", dbType, td.description)
+				}
+			}
+		}
+	}
+}
+
+// TestAccountLoginLockout checks that only failed logins count against the
+// user name, and that the name is locked out once they reach the limit.
+func TestAccountLoginLockout(t *testing.T) {
+	ctx := t.Context()
+
+	for _, dbType := range databaseList {
+
+		db, connError := database.OpenDBForTesting(dbType)
+		if connError != nil {
+			t.Error(connError)
+			continue
+		}
+
+		db.BeginTx(ctx)
+
+		defer db.Rollback()
+		defer db.CloseAndDelete()
+
+		prepError := database.PrepareTestTables(db)
+		if prepError != nil {
+			t.Error(prepError)
+			continue
+		}
+
+		conf := testConfig
+		conf.LoginFailuresAllowed = 2
+		h := New(&conf)
+		h.DB = db
+		h.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+		user := createTestUserWithPassword(db, t, "correct horse")
+
+		var testData = []struct {
+			description string
+			password    string
+			want        int
+		}{
+			{"correct", "correct horse", http.StatusSeeOther},
+			{"correct again", "correct horse", http.StatusSeeOther},
+			{"correct a third time", "correct horse", http.StatusSeeOther},
+			{"first failure", "wrong", http.StatusUnauthorized},
+			{"correct after one failure", "correct horse", http.StatusSeeOther},
+			{"second failure", "wrong", http.StatusUnauthorized},
+			{"locked out", "correct horse", http.StatusTooManyRequests},
+		}
+
+		for _, td := range testData {
+			w := accountLoginRequest(h, user.LoginName, td.password)
+			if w.Code != td.want {
+				t.Errorf("%s %s: want %d got %d", dbType, td.description, td.want, w.Code)
+			}
+		}
+
+		// Another user name is not affected.
+		w := accountLoginRequest(h, "nobody", "wrong")
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("%s: want %d got %d", dbType, http.StatusUnauthorized, w.Code)
+		}
+	}
+}
+
+// TestAccountPage checks that the account page shows the member's payments and
+// saves their details.
+func TestAccountPage(t *testing.T) {
+	ctx := t.Context()
+
+	for _, dbType := range databaseList {
+
+		db, connError := database.OpenDBForTesting(dbType)
+		if connError != nil {
+			t.Error(connError)
+			continue
+		}
+
+		db.BeginTx(ctx)
+
+		defer db.Rollback()
+		defer db.CloseAndDelete()
+
+		prepError := database.PrepareTestTables(db)
+		if prepError != nil {
+			t.Error(prepError)
+			continue
+		}
+
+		h := New(&testConfig)
+		h.DB = db
+		h.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+		user := createTestUser(db, t)
+		database.SetProfileField(ctx, db, user.ID, "CITY", "Leatherhead")
+		database.SetProfileField(ctx, db, user.ID, "ADDRESS_LINE_2", "Old Lane")
+
+		sale := database.MembershipSale{
+			PaymentService: "Stripe", PaymentStatus: database.PaymentStatusComplete,
+			MembershipYear: 2023, UserID: user.ID, OrdinaryMemberFeePaid: 24,
+			FirstName: "a", LastName: "b", Email: "c",
+		}
+		_, saleError := sale.Create(ctx, db)
+		if saleError != nil {
+			t.Errorf("%s: %v", dbType, saleError)
+			continue
+		}
+
+		now := time.Date(2025, time.March, 1, 12, 0, 0, 0, time.UTC)
+
+		// Display the page.
+		var getBuffer bytes.Buffer
+		getRequest := http.Request{Method: http.MethodGet}
+		h.accountHelper(NewTestResponseWriter(&getBuffer), &getRequest, user.ID, now)
+
+		page := getBuffer.String()
+		for _, want := range []string{user.LoginName, "Leatherhead", "2023", "24.00"} {
+			if !strings.Contains(page, want) {
+				t.Errorf("%s: want %q in the page\n%s", dbType, want, page)
+			}
+		}
+
+		// Save new details.
+		values := make(url.Values, 0)
+		values.Add("address_line_1", "1 New Road")
+		values.Add("town", "Bookham")
+		values.Add("phone", "01234 567890")
+		values.Add("receive_email", "on")
+		values.Add("giftaid", "on")
+		values.Add("other_topics_of_interest", "mills")
+		postRequest := http.Request{Method: http.MethodPost, PostForm: values}
+
+		var postBuffer bytes.Buffer
+		h.accountHelper(NewTestResponseWriter(&postBuffer), &postRequest, user.ID, now)
+
+		if !strings.Contains(postBuffer.String(), detailsSavedMessage) {
+			t.Errorf("%s: want the saved message\n%s", dbType, postBuffer.String())
+			continue
+		}
+
+		town, _ := database.GetProfileField[string](ctx, db, user.ID, "CITY")
+		if town != "Bookham" {
+			t.Errorf("%s: want Bookham got %s", dbType, town)
+		}
+
+		// A blank box clears the value on record.
+		line2, _ := database.GetProfileField[string](ctx, db, user.ID, "ADDRESS_LINE_2")
+		if line2 != "" {
+			t.Errorf("%s: want no address line 2 got %s", dbType, line2)
+		}
+
+		receiveEmail, _ := database.GetProfileField[bool](ctx, db, user.ID, "NOTICES_BY_EMAIL")
+		if !receiveEmail {
+			t.Errorf("%s: want receive email set", dbType)
+		}
+
+		dataProtection, _ := database.GetProfileField[bool](ctx, db, user.ID, database.DataStoragePermNameIntern)
+		if dataProtection {
+			t.Errorf("%s: want data protection not set", dbType)
+		}
+
+		giftaid, _ := database.GetProfileField[bool](ctx, db, user.ID, "GIFT_AID")
+		if !giftaid {
+			t.Errorf("%s: want giftaid set", dbType)
+		}
+
+		moi, moiError := db.GetMembersOtherInterests(ctx, user.ID)
+		if moiError != nil {
+			t.Errorf("%s: %v", dbType, moiError)
+			continue
+		}
+		if moi.Interests != "mills" {
+			t.Errorf("%s: want mills got %s", dbType, moi.Interests)
+		}
+
+		// Saving again updates the other interests rather than failing.
+		values.Set("other_topics_of_interest", "brewing")
+		var againBuffer bytes.Buffer
+		h.accountHelper(NewTestResponseWriter(&againBuffer), &postRequest, user.ID, now)
+		if !strings.Contains(againBuffer.String(), detailsSavedMessage) {
+			t.Errorf("%s: want the saved message the second time\n%s", dbType, againBuffer.String())
+		}
+	}
+}
+
+// TestDescribeMembershipStatus checks the description of a member with no
+// membership record.
+func TestDescribeMembershipStatus(t *testing.T) {
+	ctx := t.Context()
+
+	for _, dbType := range databaseList {
+
+		db, connError := database.OpenDBForTesting(dbType)
+		if connError != nil {
+			t.Error(connError)
+			continue
+		}
+
+		db.BeginTx(ctx)
+
+		defer db.Rollback()
+		defer db.CloseAndDelete()
+
+		prepError := database.PrepareTestTables(db)
+		if prepError != nil {
+			t.Error(prepError)
+			continue
+		}
+
+		h := New(&testConfig)
+		h.DB = db
+		h.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+		user := createTestUser(db, t)
+
+		got := h.describeMembershipStatus(user.ID, time.Now())
+		if got != "We have no record of your membership." {
+			t.Errorf("%s: got %s", dbType, got)
+		}
+	}
+}
+
+// TestLogout checks that the logout requests only end the session on a POST.
+func TestLogout(t *testing.T) {
+
+	h := New(&testConfig)
+	h.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	var testData = []struct {
+		description string
+		store       *websession.Store
+		logout      http.HandlerFunc
+	}{
+		{"account", h.Sessions, h.AccountLogout},
+		{"admin", h.AdminSessions, h.AdminLogout},
+	}
+
+	for _, td := range testData {
+
+		now := time.Now()
+
+		created := httptest.NewRecorder()
+		_, createError := td.store.Create(created, 42, now)
+		if createError != nil {
+			t.Errorf("%s: %v", td.description, createError)
+			continue
+		}
+		cookie := created.Result().Cookies()[0]
+
+		for _, method := range []string{http.MethodGet, http.MethodPost} {
+			r := http.Request{Method: method, URL: &url.URL{Path: "/logout"}, Header: http.Header{}}
+			r.AddCookie(cookie)
+			w := httptest.NewRecorder()
+			td.logout(w, &r)
+
+			check := http.Request{Header: http.Header{}}
+			check.AddCookie(cookie)
+			s := td.store.Get(&check, now)
+
+			if method == http.MethodGet {
+				if w.Code != http.StatusMethodNotAllowed {
+					t.Errorf("%s %s: want %d got %d", td.description, method, http.StatusMethodNotAllowed, w.Code)
+				}
+				if s == nil {
+					t.Errorf("%s %s: want the session to survive", td.description, method)
+				}
+			} else {
+				if w.Code != http.StatusSeeOther {
+					t.Errorf("%s %s: want %d got %d", td.description, method, http.StatusSeeOther, w.Code)
+				}
+				if s != nil {
+					t.Errorf("%s %s: want the session ended", td.description, method)
+				}
+			}
+		}
+	}
+}
+
+// createTestUserWithPassword creates a valid user with the given password.
+func createTestUserWithPassword(db *database.Database, t *testing.T, password string) *database.User {
+	ctx := t.Context()
+
+	user := createTestUser(db, t)
+
+	hash, hashError := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if hashError != nil {
+		t.Fatal(hashError)
+	}
+
+	user.Password = string(hash)
+	user.Valid = true
+	updateError := db.UpdateUser(ctx, user)
+	if updateError != nil {
+		t.Fatal(updateError)
+	}
+
+	return user
+}
+
+// accountLoginRequest runs the login helper with the given user name and
+// password and returns the recorded response.
+func accountLoginRequest(h *Handler, loginName, password string) *httptest.ResponseRecorder {
+	values := make(url.Values, 0)
+	values.Add("login_name", loginName)
+	values.Add("password", password)
+	u := url.URL{Path: "/account/login"}
+	r := http.Request{Method: http.MethodPost, URL: &u, PostForm: values, RemoteAddr: "192.0.2.1:1234"}
+	w := httptest.NewRecorder()
+	h.accountLoginHelper(w, &r, time.Now())
+	return w
+}
@@ -0,0 +1,725 @@
+package handler
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/goblimey/go-stripe-payments/code/pkg/csrf"
+	"github.com/goblimey/go-stripe-payments/code/pkg/database"
+	"github.com/goblimey/go-stripe-payments/code/pkg/report"
+	websession "github.com/goblimey/go-stripe-payments/code/pkg/session"
+)
+
+// The administration pages.  An Admidio user with the Administrator role logs in
+// with their Admidio user name and password.  They can list the membership
+// sales, filtered by year, status and payment service, look at a sale and the
+// member accounts that it refers to, mark it complete or cancelled and add
+// notes to it.  Each action is recorded as a note against the sale.  They can
+// also see statistics for a membership year and download them as CSV or JSON,
+// and the statistics of the pool of database connections.
+// The pages display data typed in by the public, so the templates escape it.
+
+// adminSessionCookie is the name of the cookie that carries the session ID.
+const adminSessionCookie = "admin_session"
+
+// adminActionComplete, adminActionCancel and adminActionAnnotate are the values
+// of the action parameter of a POST to /admin/sale.
+const (
+	adminActionComplete = "complete"
+	adminActionCancel   = "cancel"
+	adminActionAnnotate = "annotate"
+)
+
+// adminStatuses is the list of payment statuses offered by the filter.
+var adminStatuses = []string{
+	database.PaymentStatusPending,
+	database.PaymentStatusUnverified,
+	database.PaymentStatusComplete,
+	database.PaymentStatusCancelled,
+}
+
+// adminListPage holds the data for the list of sales.
+type adminListPage struct {
+	OrganisationName string
+	CSRFToken        string
+	Filter           database.SaleFilter
+	Statuses         []string
+	Services         []string
+	Sales            []database.MembershipSale
+}
+
+// adminReportPage holds the data for the statistics report.
+type adminReportPage struct {
+	OrganisationName string
+	CSRFToken        string
+	Year             int
+	Rows             []adminReportRow
+}
+
+// adminReportRow is one line of the statistics report.
+type adminReportRow struct {
+	Name string // The month, or "total" for the whole year.
+	database.SalesSummary
+}
+
+// adminPoolPage holds the data for the page that shows the statistics of the
+// pool of database connections.
+type adminPoolPage struct {
+	OrganisationName string
+	CSRFToken        string
+	MaxIdleConns     int
+	ConnMaxLifetime  time.Duration
+	ConnMaxIdleTime  time.Duration
+	Stats            sql.DBStats
+}
+
+// adminMember describes a member account referred to by a sale.
+type adminMember struct {
+	ID        int64
+	Role      string // "Ordinary" or "Associate".
+	LoginName string
+	FirstName string
+	LastName  string
+	Email     string
+	EndYear   int // The year in which the membership ends, 0 if not known.
+}
+
+// adminSalePage holds the data for the page that shows one sale.
+type adminSalePage struct {
+	OrganisationName string
+	CSRFToken        string
+	Sale             *database.MembershipSale
+	Members          []adminMember
+	Reviews          []database.Review
+	Notes            []database.SaleNote
+	CanComplete      bool
+	CanCancel        bool
+	NeedsAccounts    bool // True if completing the sale needs the member accounts.
+}
+
+// adminAccounts holds the member accounts that an administrator has chosen when
+// completing a sale that isn't linked to any.
+type adminAccounts struct {
+	UserID      int64 // The ID of the ordinary member's account, 0 if not given.
+	AssocUserID int64 // The ID of the associate member's account, 0 if not given.
+	New         bool  // True if new accounts should be created.
+}
+
+// AdminLogin handles the /admin/login request.  A GET displays the login form.
+// A POST checks the user name and password and that the user is an
+// administrator and, if all is well, logs them in and redirects to /admin.
+func (h *Handler) AdminLogin(w http.ResponseWriter, r *http.Request) {
+
+	h.Logger.Info("AdminLogin")
+
+	if r.Method != http.MethodPost {
+		h.displayAdminLoginPage(w, r, "", "")
+		return
+	}
+
+	// Logging in doesn't change the database.
+	h.withRequest(r.Context(), w, h.PrePaymentErrorHTML, func(h *Handler) {
+		h.adminLoginHelper(w, r, time.Now())
+	})
+}
+
+// adminLoginHelper checks the login form and creates an administrator session.
+// The helper is separated out to support unit testing.
+func (h *Handler) adminLoginHelper(w http.ResponseWriter, r *http.Request, now time.Time) {
+
+	const fn = "adminLoginHelper"
+
+	loginName := strings.TrimSpace(r.PostFormValue("login_name"))
+	password := r.PostFormValue("password")
+
+	if h.ipRateLimited(r, now) {
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write([]byte(h.BlockedHTML))
+		return
+	}
+
+	if h.LoginLimiter.Blocked(strings.ToLower(loginName), now) {
+		h.Logger.Warn("blocked admin login", "fn", fn, "reason", "too many failed attempts",
+			"remote", clientIP(r), "login_name", loginName)
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write([]byte(h.BlockedHTML))
+		return
+	}
+
+	userID, ok, checkError := h.checkLogin(loginName, password)
+	if checkError != nil {
+		h.reportError(w, h.PrePaymentErrorHTML, checkError)
+		return
+	}
+
+	if ok {
+		var roleError error
+		ok, roleError = h.DB.UserHasRole(h.ctx, userID, database.RoleNameAdmin, now)
+		if roleError != nil {
+			h.reportError(w, h.PrePaymentErrorHTML, roleError)
+			return
+		}
+		if !ok {
+			h.Logger.Warn("admin login by a user who is not an administrator",
+				"fn", fn, "remote", clientIP(r), "login_name", loginName)
+		}
+	} else {
+		h.LoginLimiter.Record(strings.ToLower(loginName), now)
+		h.Logger.Warn("admin login failed", "fn", fn, "remote", clientIP(r), "login_name", loginName)
+	}
+
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		h.displayAdminLoginPage(w, r, loginName, loginFailedMessage)
+		return
+	}
+
+	_, sessionError := h.AdminSessions.Create(w, userID, now)
+	if sessionError != nil {
+		h.reportError(w, h.PrePaymentErrorHTML, sessionError)
+		return
+	}
+
+	h.logMessage("%s: administrator %d logged in", fn, userID)
+
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
+
+// AdminLogout handles the /admin/logout request.  A POST ends the session
+// and redirects to the login page.  Any other method is refused.
+func (h *Handler) AdminLogout(w http.ResponseWriter, r *http.Request) {
+
+	h.Logger.Info("AdminLogout")
+
+	// Logging out changes state, so, like the other forms, it must be a POST,
+	// which carries the CSRF token.
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	h.AdminSessions.Delete(w, r)
+
+	http.Redirect(w, r, "/admin/login", http.StatusSeeOther)
+}
+
+// Admin handles the /admin request.  It displays the list of sales, filtered by
+// the request parameters year, status and service.
+func (h *Handler) Admin(w http.ResponseWriter, r *http.Request) {
+
+	h.Logger.Info("Admin")
+
+	s := h.adminSession(w, r)
+	if s == nil {
+		return
+	}
+
+	// The list doesn't change the database.
+	h.withRequest(r.Context(), w, h.PrePaymentErrorHTML, func(h *Handler) {
+		h.adminListHelper(w, r)
+	})
+}
+
+// adminListHelper displays the list of sales.  The helper is separated out to
+// support unit testing.
+func (h *Handler) adminListHelper(w http.ResponseWriter, r *http.Request) {
+
+	const fn = "adminListHelper"
+
+	query := r.URL.Query()
+
+	page := adminListPage{
+		OrganisationName: h.Conf.OrganisationName,
+		CSRFToken:        csrf.Token(r),
+		Statuses:         adminStatuses,
+	}
+
+	// A year that isn't a number is ignored.
+	page.Filter.Year, _ = strconv.Atoi(strings.TrimSpace(query.Get("year")))
+	page.Filter.Status = strings.TrimSpace(query.Get("status"))
+	page.Filter.PaymentService = strings.TrimSpace(query.Get("service"))
+
+	var servicesError error
+	page.Services, servicesError = h.DB.GetPaymentServices(h.ctx)
+	if servicesError != nil {
+		h.logError("%s: %v", fn, servicesError)
+		w.Write([]byte(h.PrePaymentErrorHTML))
+		return
+	}
+
+	var salesError error
+	page.Sales, salesError = h.DB.GetMembershipSales(h.ctx, &page.Filter)
+	if salesError != nil {
+		h.logError("%s: %v", fn, salesError)
+		w.Write([]byte(h.PrePaymentErrorHTML))
+		return
+	}
+
+	h.displayAdminTemplate(w, "AdminListPage", adminListTemplateString, &page)
+}
+
+// AdminReport handles the /admin/report request.  It summarises the sales of
+// the membership year given by the year parameter (by default the year being
+// sold).  The format parameter can be html (the default), csv or json.
+func (h *Handler) AdminReport(w http.ResponseWriter, r *http.Request) {
+
+	h.Logger.Info("AdminReport")
+
+	s := h.adminSession(w, r)
+	if s == nil {
+		return
+	}
+
+	// The report doesn't change the database.
+	h.withRequest(r.Context(), w, h.PrePaymentErrorHTML, func(h *Handler) {
+		h.adminReportHelper(w, r, time.Now())
+	})
+}
+
+// adminReportHelper produces the statistics report.  The helper is separated
+// out to support unit testing.
+func (h *Handler) adminReportHelper(w http.ResponseWriter, r *http.Request, now time.Time) {
+
+	const fn = "adminReportHelper"
+
+	query := r.URL.Query()
+
+	year, yearError := strconv.Atoi(strings.TrimSpace(query.Get("year")))
+	if yearError != nil {
+		year = database.GetMembershipYear(now)
+	}
+
+	format := strings.TrimSpace(query.Get("format"))
+	switch format {
+	case "", "html", report.FormatCSV, report.FormatJSON:
+	default:
+		http.Error(w, "unknown format", http.StatusBadRequest)
+		return
+	}
+
+	summaries, reportError := h.DB.GetSalesSummaries(h.ctx, year)
+	if reportError != nil {
+		h.logError("%s: %v", fn, reportError)
+		w.Write([]byte(h.PrePaymentErrorHTML))
+		return
+	}
+
+	switch format {
+	case report.FormatCSV:
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	case report.FormatJSON:
+		w.Header().Set("Content-Type", "application/json")
+	default:
+		page := adminReportPage{
+			OrganisationName: h.Conf.OrganisationName,
+			CSRFToken:        csrf.Token(r),
+			Year:             year,
+			Rows:             make([]adminReportRow, 0, len(summaries)),
+		}
+		for i := range summaries {
+			row := adminReportRow{report.MonthName(&summaries[i]), summaries[i]}
+			page.Rows = append(page.Rows, row)
+		}
+
+		h.displayAdminTemplate(w, "AdminReportPage", adminReportTemplateString, &page)
+		return
+	}
+
+	// Offer the CSV or JSON as a file to download.
+	fileName := fmt.Sprintf("membership.%d.%s", year, format)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", fileName))
+
+	writeError := report.Write(w, format, summaries)
+	if writeError != nil {
+		h.logError("%s: %v", fn, writeError)
+	}
+}
+
+// AdminPool handles the /admin/pool request.  It displays the statistics of the
+// pool of database connections, which show whether the pool is big enough.
+func (h *Handler) AdminPool(w http.ResponseWriter, r *http.Request) {
+
+	h.Logger.Info("AdminPool")
+
+	s := h.adminSession(w, r)
+	if s == nil {
+		return
+	}
+
+	h.adminPoolHelper(w, r)
+}
+
+// adminPoolHelper displays the pool statistics.  The helper is separated out to
+// support unit testing.
+func (h *Handler) adminPoolHelper(w http.ResponseWriter, r *http.Request) {
+
+	stats := h.Pool.Stats()
+
+	h.logMessage("adminPoolHelper: open %d in use %d idle %d waits %d waited %v",
+		stats.OpenConnections, stats.InUse, stats.Idle, stats.WaitCount, stats.WaitDuration)
+
+	page := adminPoolPage{
+		OrganisationName: h.Conf.OrganisationName,
+		CSRFToken:        csrf.Token(r),
+		MaxIdleConns:     h.Pool.Config.MaxIdleConns,
+		ConnMaxLifetime:  h.Pool.Config.ConnMaxLifetime,
+		ConnMaxIdleTime:  h.Pool.Config.ConnMaxIdleTime,
+		Stats:            stats,
+	}
+
+	h.displayAdminTemplate(w, "AdminPoolPage", adminPoolTemplateString, &page)
+}
+
+// AdminSale handles the /admin/sale request.  A GET displays the sale given by
+// the id parameter.  A POST applies the action given by the action parameter
+// (complete, cancel or annotate) to the sale and redirects to the GET.
+func (h *Handler) AdminSale(w http.ResponseWriter, r *http.Request) {
+
+	h.Logger.Info("AdminSale")
+
+	s := h.adminSession(w, r)
+	if s == nil {
+		return
+	}
+
+	// The helper commits if it changes the sale.
+	h.withRequest(r.Context(), w, h.PrePaymentErrorHTML, func(h *Handler) {
+		h.adminSaleHelper(w, r, s.UserID, time.Now())
+	})
+}
+
+// adminSaleHelper displays a sale or applies an action to it.  The helper is
+// separated out to support unit testing.
+func (h *Handler) adminSaleHelper(w http.ResponseWriter, r *http.Request, adminUserID int64, now time.Time) {
+
+	const fn = "adminSaleHelper"
+
+	var idStr string
+	if r.Method == http.MethodPost {
+		idStr = r.PostFormValue("id")
+	} else {
+		idStr = r.URL.Query().Get("id")
+	}
+
+	saleID, idError := strconv.ParseInt(strings.TrimSpace(idStr), 10, 64)
+	if idError != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(h.PrePaymentErrorHTML))
+		return
+	}
+
+	sale, saleError := h.DB.GetMembershipSale(h.ctx, saleID)
+	if saleError != nil {
+		h.logError("%s: sale %d - %v", fn, saleID, saleError)
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(h.PrePaymentErrorHTML))
+		return
+	}
+
+	if r.Method == http.MethodPost {
+
+		action := r.PostFormValue("action")
+
+		accounts, accountsError := getAdminAccounts(r)
+		if accountsError != nil {
+			h.logError("%s: sale %d - %v", fn, saleID, accountsError)
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(h.PrePaymentErrorHTML))
+			return
+		}
+
+		actionError := h.applyAdminAction(sale, action, r.PostFormValue("note"), accounts, adminUserID, now)
+		if actionError != nil {
+			h.logError("%s: sale %d - %v", fn, saleID, actionError)
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(h.PrePaymentErrorHTML))
+			return
+		}
+
+		commitError := h.DB.Commit()
+		if commitError != nil {
+			h.logError("%s: %v", fn, commitError)
+			w.Write([]byte(h.PrePaymentErrorHTML))
+			return
+		}
+
+		h.logMessage("%s: administrator %d applied %s to sale %d", fn, adminUserID, action, saleID)
+
+		http.Redirect(w, r, fmt.Sprintf("/admin/sale?id=%d", saleID), http.StatusSeeOther)
+		return
+	}
+
+	needsReview, needsReviewError := h.DB.SaleNeedsReview(h.ctx, saleID)
+	if needsReviewError != nil {
+		h.logError("%s: %v", fn, needsReviewError)
+		w.Write([]byte(h.PrePaymentErrorHTML))
+		return
+	}
+
+	// A sale that went into the review queue is paid, and so complete, but the
+	// accounts are left for the administrator, who completes it again.
+	page := adminSalePage{
+		OrganisationName: h.Conf.OrganisationName,
+		CSRFToken:        csrf.Token(r),
+		Sale:             sale,
+		CanComplete:      sale.PaymentStatus != database.PaymentStatusComplete || needsReview,
+		CanCancel:        sale.PaymentStatus != database.PaymentStatusCancelled,
+		NeedsAccounts:    sale.UserID <= 0,
+	}
+
+	if sale.UserID > 0 {
+		page.Members = append(page.Members, h.getAdminMember(sale.UserID, "Ordinary"))
+	}
+	if sale.AssocUserID > 0 {
+		page.Members = append(page.Members, h.getAdminMember(sale.AssocUserID, "Associate"))
+	}
+
+	var reviewsError error
+	page.Reviews, reviewsError = h.DB.GetReviewsOfSale(h.ctx, saleID)
+	if reviewsError != nil {
+		h.logError("%s: %v", fn, reviewsError)
+		w.Write([]byte(h.PrePaymentErrorHTML))
+		return
+	}
+
+	var notesError error
+	page.Notes, notesError = h.DB.GetSaleNotes(h.ctx, saleID)
+	if notesError != nil {
+		h.logError("%s: %v", fn, notesError)
+		w.Write([]byte(h.PrePaymentErrorHTML))
+		return
+	}
+
+	h.displayAdminTemplate(w, "AdminSalePage", adminSaleTemplateString, &page)
+}
+
+// applyAdminAction applies the given action to the sale and records it as a
+// note.  Completing a sale creates or links the member accounts, extends their
+// memberships and closes any open reviews.  Cancelling it closes any open
+// reviews.
+func (h *Handler) applyAdminAction(sale *database.MembershipSale, action, note string, accounts adminAccounts, adminUserID int64, now time.Time) error {
+
+	var record string
+
+	switch action {
+	case adminActionComplete:
+		completeError := h.completeSaleByAdmin(sale, accounts, now)
+		if completeError != nil {
+			return completeError
+		}
+
+		record = "Marked complete."
+
+	case adminActionCancel:
+		if sale.PaymentStatus == database.PaymentStatusCancelled {
+			return fmt.Errorf("the sale is already cancelled")
+		}
+
+		statusError := h.DB.SetMembershipSaleStatus(h.ctx, sale.ID, database.PaymentStatusCancelled)
+		if statusError != nil {
+			return statusError
+		}
+
+		record = "Cancelled."
+
+	case adminActionAnnotate:
+		record = strings.TrimSpace(note)
+		if len(record) == 0 {
+			return fmt.Errorf("empty note")
+		}
+
+	default:
+		return fmt.Errorf("unknown action %q", action)
+	}
+
+	if action != adminActionAnnotate {
+		reviews, reviewsError := h.DB.GetReviewsOfSale(h.ctx, sale.ID)
+		if reviewsError != nil {
+			return reviewsError
+		}
+		for _, review := range reviews {
+			if review.Status != database.ReviewStatusOpen {
+				continue
+			}
+			closeError := h.DB.CloseReview(h.ctx, review.ID)
+			if closeError != nil {
+				return closeError
+			}
+		}
+	}
+
+	n := database.SaleNote{
+		SaleID:  sale.ID,
+		UserID:  adminUserID,
+		Note:    record,
+		Created: now,
+	}
+
+	return h.DB.CreateSaleNote(h.ctx, &n)
+}
+
+// completeSaleByAdmin completes the sale in the same way as a payment through
+// Stripe, creating the member accounts if necessary, extending the memberships
+// and recording the fees paid.  A sale that isn't linked to an account, such as
+// one in the review queue, needs the administrator to give the accounts or ask
+// for new ones.  The status is only set once the memberships are extended, in
+// the same transaction.
+func (h *Handler) completeSaleByAdmin(sale *database.MembershipSale, accounts adminAccounts, now time.Time) error {
+
+	needsReview, reviewError := h.DB.SaleNeedsReview(h.ctx, sale.ID)
+	if reviewError != nil {
+		return reviewError
+	}
+
+	if sale.PaymentStatus == database.PaymentStatusComplete && !needsReview {
+		return fmt.Errorf("the sale is already complete")
+	}
+
+	if accounts.UserID > 0 {
+		_, userError := h.DB.GetUser(h.ctx, accounts.UserID)
+		if userError != nil {
+			return fmt.Errorf("account %d - %v", accounts.UserID, userError)
+		}
+		sale.UserID = accounts.UserID
+	}
+
+	if accounts.AssocUserID > 0 {
+		_, assocError := h.DB.GetUser(h.ctx, accounts.AssocUserID)
+		if assocError != nil {
+			return fmt.Errorf("account %d - %v", accounts.AssocUserID, assocError)
+		}
+		sale.AssocUserID = accounts.AssocUserID
+	}
+
+	if sale.UserID <= 0 && !accounts.New {
+		return fmt.Errorf("the sale is not linked to a member account")
+	}
+
+	// The dates are figured out as in completeSale.
+	yearEnd := time.Date(now.Year(), time.December, 31, 23, 59, 59, 999999999, now.Location())
+
+	detailsError := h.setMemberDetails(sale, now, yearEnd, now, sale.MembershipYear)
+	if detailsError != nil {
+		return detailsError
+	}
+
+	updateError := sale.Update(h.ctx, h.DB)
+	if updateError != nil {
+		return updateError
+	}
+
+	h.setAccountingRecordsForMembers(sale, now)
+
+	// Success!
+	return nil
+}
+
+// getAdminAccounts gets the member accounts given in the form that completes a
+// sale.  The account IDs are optional.
+func getAdminAccounts(r *http.Request) (adminAccounts, error) {
+
+	var accounts adminAccounts
+
+	var idError error
+	accounts.UserID, idError = getAccountID(r, "user_id")
+	if idError != nil {
+		return accounts, idError
+	}
+
+	accounts.AssocUserID, idError = getAccountID(r, "assoc_user_id")
+	if idError != nil {
+		return accounts, idError
+	}
+
+	accounts.New = r.PostFormValue("new_accounts") == "yes"
+
+	return accounts, nil
+}
+
+// getAccountID gets the account ID in the given form field, 0 if it's empty.
+func getAccountID(r *http.Request, name string) (int64, error) {
+
+	value := strings.TrimSpace(r.PostFormValue(name))
+	if len(value) == 0 {
+		return 0, nil
+	}
+
+	id, idError := strconv.ParseInt(value, 10, 64)
+	if idError != nil || id <= 0 {
+		return 0, fmt.Errorf("%s: bad account ID %q", name, value)
+	}
+
+	return id, nil
+}
+
+// getAdminMember gets the details of a member account referred to by a sale.
+// Any value that can't be found is left empty.
+func (h *Handler) getAdminMember(userID int64, role string) adminMember {
+
+	m := adminMember{ID: userID, Role: role}
+
+	user, userError := h.DB.GetUser(h.ctx, userID)
+	if userError == nil {
+		m.LoginName = user.LoginName
+	}
+
+	data, dataError := h.DB.GetUserData(h.ctx, userID)
+	if dataError == nil {
+		m.FirstName = data[userID].FirstName
+		m.LastName = data[userID].LastName
+		m.Email = data[userID].Email
+	}
+	m.EndYear, _ = h.DB.GetMembershipYearOfUser(h.ctx, userID)
+
+	return m
+}
+
+// adminSession returns the administrator's session.  If there isn't one, it
+// redirects to the login page and returns nil.
+func (h *Handler) adminSession(w http.ResponseWriter, r *http.Request) *websession.Session {
+	s := h.AdminSessions.Get(r, time.Now())
+	if s == nil {
+		http.Redirect(w, r, "/admin/login", http.StatusSeeOther)
+		return nil
+	}
+	return s
+}
+
+// displayAdminLoginPage displays the administrator login form with the given
+// user name and error message.
+func (h *Handler) displayAdminLoginPage(w http.ResponseWriter, r *http.Request, loginName, errorMessage string) {
+
+	data := accountLogin{
+		OrganisationName: h.Conf.OrganisationName,
+		CSRFToken:        csrf.Token(r),
+		LoginName:        loginName,
+		ErrorMessage:     errorMessage,
+	}
+
+	h.displayAdminTemplate(w, "AdminLoginPage", adminLoginTemplateString, &data)
+}
+
+// displayAdminTemplate parses the given template and executes it with the
+// given data.
+func (h *Handler) displayAdminTemplate(w http.ResponseWriter, name, templateString string, data any) {
+
+	pageTemplate, parseError := template.New(name).Parse(templateString)
+	if parseError != nil {
+		h.logError("%v", parseError)
+		w.Write([]byte(h.PrePaymentErrorHTML))
+		return
+	}
+
+	executeError := pageTemplate.Execute(w, data)
+	if executeError != nil {
+		h.logError("%v", executeError)
+		w.Write([]byte(h.PrePaymentErrorHTML))
+		return
+	}
+}
@@ -0,0 +1,586 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/goblimey/go-stripe-payments/code/pkg/database"
+)
+
+// TestAdminLogin checks that only a user with the Administrator role can log in
+// to the administration pages.
+func TestAdminLogin(t *testing.T) {
+	ctx := t.Context()
+
+	for _, dbType := range databaseList {
+
+		db, connError := database.OpenDBForTesting(dbType)
+		if connError != nil {
+			t.Error(connError)
+			continue
+		}
+
+		db.BeginTx(ctx)
+
+		defer db.Rollback()
+		defer db.CloseAndDelete()
+
+		prepError := database.PrepareTestTables(db)
+		if prepError != nil {
+			t.Error(prepError)
+			continue
+		}
+
+		h := New(&testConfig)
+		h.DB = db
+		h.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+		now := time.Now()
+
+		admin := createTestUserWithPassword(db, t, "admin password")
+		adminRole, roleError := db.GetRole(ctx, database.RoleNameAdmin)
+		if roleError != nil {
+			t.Errorf("%s: %v", dbType, roleError)
+			continue
+		}
+		db.CreateMember(ctx, database.NewMember(admin, adminRole, now.AddDate(-1, 0, 0), now.AddDate(1, 0, 0)))
+
+		member := createTestUserWithPassword(db, t, "member password")
+
+		var testData = []struct {
+			description string
+			loginName   string
+			password    string
+			wantLogin   bool
+		}{
+			{"administrator", admin.LoginName, "admin password", true},
+			{"wrong password", admin.LoginName, "member password", false},
+			{"not an administrator", member.LoginName, "member password", false},
+		}
+
+		for _, td := range testData {
+			values := make(url.Values, 0)
+			values.Add("login_name", td.loginName)
+			values.Add("password", td.password)
+			u := url.URL{Path: "/admin/login"}
+			r := http.Request{Method: http.MethodPost, URL: &u, PostForm: values, RemoteAddr: "192.0.2.1:1234"}
+			w := httptest.NewRecorder()
+			h.adminLoginHelper(w, &r, now)
+
+			cookies := w.Result().Cookies()
+
+			if td.wantLogin {
+				if w.Code != http.StatusSeeOther {
+					t.Errorf("%s %s: want %d got %d", dbType, td.description, http.StatusSeeOther, w.Code)
+				}
+				if len(cookies) != 1 || cookies[0].Name != adminSessionCookie {
+					t.Errorf("%s %s: want a session cookie got %v", dbType, td.description, cookies)
+				}
+			} else {
+				if w.Code != http.StatusUnauthorized {
+					t.Errorf("%s %s: want %d got %d", dbType, td.description, http.StatusUnauthorized, w.Code)
+				}
+				if len(cookies) != 0 {
+					t.Errorf("%s %s: want no cookie got %v", dbType, td.description, cookies)
+				}
+			}
+		}
+
+		// A member's session doesn't give access to the admin pages.
+		mw := httptest.NewRecorder()
+		session, _ := h.Sessions.Create(mw, member.ID, now)
+		r := http.Request{URL: &url.URL{Path: "/admin"}, Header: http.Header{}}
+		r.AddCookie(&http.Cookie{Name: adminSessionCookie, Value: session.ID})
+		if h.adminSession(httptest.NewRecorder(), &r) != nil {
+			t.Errorf("%s: a member's session should not be an admin session", dbType)
+		}
+	}
+}
+
+// TestAdminList checks that the list of sales is filtered.
+func TestAdminList(t *testing.T) {
+	ctx := t.Context()
+
+	for _, dbType := range databaseList {
+
+		db, connError := database.OpenDBForTesting(dbType)
+		if connError != nil {
+			t.Error(connError)
+			continue
+		}
+
+		db.BeginTx(ctx)
+
+		defer db.Rollback()
+		defer db.CloseAndDelete()
+
+		prepError := database.PrepareTestTables(db)
+		if prepError != nil {
+			t.Error(prepError)
+			continue
+		}
+
+		h := New(&testConfig)
+		h.DB = db
+		h.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+		u, _ := database.CreateUuid(ctx, db.Transaction, "ms_payment_service", "membership_sales")
+		service := "s" + u[:8]
+
+		sales := []database.MembershipSale{
+			{PaymentService: service, PaymentStatus: database.PaymentStatusComplete,
+				MembershipYear: 2024, FirstName: "<b>Ann</b>", LastName: "b", Email: "c"},
+			{PaymentService: service, PaymentStatus: database.PaymentStatusComplete,
+				MembershipYear: 2025, FirstName: "Bob", LastName: "b", Email: "c"},
+		}
+		for i := range sales {
+			_, createError := sales[i].Create(ctx, db)
+			if createError != nil {
+				t.Errorf("%s: %v", dbType, createError)
+			}
+		}
+
+		query := url.Values{}
+		query.Add("service", service)
+		query.Add("year", "2024")
+		r := http.Request{Method: http.MethodGet, URL: &url.URL{Path: "/admin", RawQuery: query.Encode()}}
+		w := httptest.NewRecorder()
+		h.adminListHelper(w, &r)
+
+		page := w.Body.String()
+
+		if !strings.Contains(page, fmt.Sprintf("/admin/sale?id=%d'", sales[0].ID)) {
+			t.Errorf("%s: want sale %d in the list\n%s", dbType, sales[0].ID, page)
+		}
+
+		if strings.Contains(page, fmt.Sprintf("/admin/sale?id=%d'", sales[1].ID)) {
+			t.Errorf("%s: want sale %d not in the list", dbType, sales[1].ID)
+		}
+
+		// Data typed in by the public is escaped.
+		if strings.Contains(page, "<b>Ann</b>") || !strings.Contains(page, "&lt;b&gt;Ann&lt;/b&gt;") {
+			t.Errorf("%s: want the name escaped\n%s", dbType, page)
+		}
+	}
+}
+
+// TestAdminSaleActions checks that an administrator can view, complete, cancel
+// and annotate a sale.
+func TestAdminSaleActions(t *testing.T) {
+	ctx := t.Context()
+
+	for _, dbType := range databaseList {
+
+		db, connError := database.OpenDBForTesting(dbType)
+		if connError != nil {
+			t.Error(connError)
+			continue
+		}
+
+		db.BeginTx(ctx)
+
+		defer db.Rollback()
+		defer db.CloseAndDelete()
+
+		prepError := database.PrepareTestTables(db)
+		if prepError != nil {
+			t.Error(prepError)
+			continue
+		}
+
+		h := New(&testConfig)
+		h.DB = db
+		h.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+		admin := createTestUser(db, t)
+
+		memberRole, roleError := db.GetRole(ctx, database.RoleNameMember)
+		if roleError != nil {
+			t.Errorf("%s: %v", dbType, roleError)
+			continue
+		}
+
+		loginName, _ := database.CreateUuid(ctx, db.Transaction, "usr_login_name", "adm_users")
+		start := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+		end := time.Date(2024, time.December, 31, 0, 0, 0, 0, time.UTC)
+		user, _, userError := db.CreateUserAndMember(ctx, loginName, "Ms", "Jane", "Doe", memberRole, start, end)
+		if userError != nil {
+			t.Errorf("%s: %v", dbType, userError)
+			continue
+		}
+
+		sale := database.MembershipSale{
+			PaymentService: "cheque", PaymentStatus: database.PaymentStatusPending,
+			MembershipYear: 2025, UserID: user.ID,
+			FirstName: "Jane", LastName: "Doe", Email: "c",
+		}
+		saleID, saleError := sale.Create(ctx, db)
+		if saleError != nil {
+			t.Errorf("%s: %v", dbType, saleError)
+			continue
+		}
+
+		review := database.Review{SaleID: saleID, Reason: "name matches an existing member"}
+		db.CreateReview(ctx, &review)
+
+		// Commit the setup so that the helper can commit its own changes.
+		db.Commit()
+
+		now := time.Date(2025, time.March, 1, 12, 0, 0, 0, time.UTC)
+
+		view := adminSaleRequest(ctx, h, db, admin.ID, http.MethodGet, saleID, "", "", now)
+		if !strings.Contains(view, loginName) || !strings.Contains(view, "Mark complete") {
+			t.Errorf("%s: want the member and the complete button\n%s", dbType, view)
+		}
+
+		// Annotate, then complete.
+		adminSaleRequest(ctx, h, db, admin.ID, http.MethodPost, saleID, adminActionAnnotate, "paid by cheque", now)
+		adminSaleRequest(ctx, h, db, admin.ID, http.MethodPost, saleID, adminActionComplete, "", now)
+
+		// Completing it again is refused.
+		db.BeginTx(ctx)
+		var w = httptest.NewRecorder()
+		values := url.Values{"id": {fmt.Sprint(saleID)}, "action": {adminActionComplete}}
+		r := http.Request{Method: http.MethodPost, URL: &url.URL{Path: "/admin/sale"}, PostForm: values}
+		h.adminSaleHelper(w, &r, admin.ID, now)
+		db.Rollback()
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: want %d got %d", dbType, http.StatusBadRequest, w.Code)
+		}
+
+		db.BeginTx(ctx)
+
+		got, fetchError := db.GetMembershipSale(ctx, saleID)
+		if fetchError != nil {
+			t.Errorf("%s: %v", dbType, fetchError)
+			continue
+		}
+
+		if got.PaymentStatus != database.PaymentStatusComplete {
+			t.Errorf("%s: want %s got %s", dbType, database.PaymentStatusComplete, got.PaymentStatus)
+		}
+
+		year, yearError := db.GetMembershipYearOfUser(ctx, user.ID)
+		if yearError != nil {
+			t.Errorf("%s: %v", dbType, yearError)
+		}
+		if year != 2025 {
+			t.Errorf("%s: want 2025 got %d", dbType, year)
+		}
+
+		needsReview, _ := db.SaleNeedsReview(ctx, saleID)
+		if needsReview {
+			t.Errorf("%s: want the review closed", dbType)
+		}
+
+		notes, notesError := db.GetSaleNotes(ctx, saleID)
+		if notesError != nil {
+			t.Errorf("%s: %v", dbType, notesError)
+			continue
+		}
+
+		if len(notes) != 2 || notes[0].Note != "paid by cheque" || notes[1].Note != "Marked complete." {
+			t.Errorf("%s: want two notes got %v", dbType, notes)
+		}
+
+		db.Rollback()
+
+		// Cancel it.
+		adminSaleRequest(ctx, h, db, admin.ID, http.MethodPost, saleID, adminActionCancel, "", now)
+
+		view = adminSaleRequest(ctx, h, db, admin.ID, http.MethodGet, saleID, "", "", now)
+		if !strings.Contains(view, database.PaymentStatusCancelled) ||
+			strings.Contains(view, "Cancel sale") {
+			t.Errorf("%s: want the sale cancelled\n%s", dbType, view)
+		}
+
+		db.BeginTx(ctx)
+	}
+}
+
+// TestAdminCompleteReviewedSale checks that a sale in the review queue can only
+// be completed once it's linked to a member account, and that completing it
+// extends the membership and records the payment.
+func TestAdminCompleteReviewedSale(t *testing.T) {
+	ctx := t.Context()
+
+	for _, dbType := range databaseList {
+
+		db, connError := database.OpenDBForTesting(dbType)
+		if connError != nil {
+			t.Error(connError)
+			continue
+		}
+
+		db.BeginTx(ctx)
+
+		defer db.Rollback()
+		defer db.CloseAndDelete()
+
+		prepError := database.PrepareTestTables(db)
+		if prepError != nil {
+			t.Error(prepError)
+			continue
+		}
+
+		h := New(&testConfig)
+		h.DB = db
+		h.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+		admin := createTestUser(db, t)
+		member := createTestUser(db, t)
+
+		// The customer has paid but we couldn't tell which account is theirs.
+		sale := database.MembershipSale{
+			PaymentService: "stripe", PaymentStatus: database.PaymentStatusComplete,
+			MembershipYear: 2025, OrdinaryMemberFeePaid: 24,
+			FirstName: "Jane", LastName: "Doe", Email: "jane@example.com",
+		}
+		saleID, saleError := sale.Create(ctx, db)
+		if saleError != nil {
+			t.Errorf("%s: %v", dbType, saleError)
+			continue
+		}
+
+		review := database.Review{SaleID: saleID, Reason: "name matches an existing member"}
+		db.CreateReview(ctx, &review)
+
+		db.Commit()
+
+		now := time.Date(2025, time.March, 1, 12, 0, 0, 0, time.UTC)
+
+		view := adminSaleRequest(ctx, h, db, admin.ID, http.MethodGet, saleID, "", "", now)
+		if !strings.Contains(view, "Mark complete") || !strings.Contains(view, "name='user_id'") {
+			t.Errorf("%s: want the complete button and the account fields\n%s", dbType, view)
+		}
+
+		complete := func(values url.Values) int {
+			db.BeginTx(ctx)
+			defer db.Rollback()
+			values.Set("id", fmt.Sprint(saleID))
+			values.Set("action", adminActionComplete)
+			w := httptest.NewRecorder()
+			r := http.Request{Method: http.MethodPost, URL: &url.URL{Path: "/admin/sale"}, PostForm: values}
+			h.adminSaleHelper(w, &r, admin.ID, now)
+			return w.Code
+		}
+
+		// Without an account it's refused.
+		if code := complete(url.Values{}); code != http.StatusBadRequest {
+			t.Errorf("%s: want %d got %d", dbType, http.StatusBadRequest, code)
+		}
+
+		// So is an account that doesn't exist.
+		if code := complete(url.Values{"user_id": {"99999"}}); code != http.StatusBadRequest {
+			t.Errorf("%s: want %d got %d", dbType, http.StatusBadRequest, code)
+		}
+
+		// With the account it's completed.
+		if code := complete(url.Values{"user_id": {fmt.Sprint(member.ID)}}); code != http.StatusSeeOther {
+			t.Errorf("%s: want %d got %d", dbType, http.StatusSeeOther, code)
+		}
+
+		db.BeginTx(ctx)
+
+		got, fetchError := db.GetMembershipSale(ctx, saleID)
+		if fetchError != nil {
+			t.Errorf("%s: %v", dbType, fetchError)
+			continue
+		}
+
+		if got.UserID != member.ID {
+			t.Errorf("%s: want %d got %d", dbType, member.ID, got.UserID)
+		}
+
+		if got.PaymentStatus != database.PaymentStatusComplete {
+			t.Errorf("%s: want %s got %s", dbType, database.PaymentStatusComplete, got.PaymentStatus)
+		}
+
+		year, yearError := db.GetMembershipYearOfUser(ctx, member.ID)
+		if yearError != nil {
+			t.Errorf("%s: %v", dbType, yearError)
+		}
+		if year != 2025 {
+			t.Errorf("%s: want 2025 got %d", dbType, year)
+		}
+
+		lastPaid, lastPaidError := database.GetProfileField[string](ctx, db, member.ID, "DATE_LAST_PAID")
+		if lastPaidError != nil {
+			t.Errorf("%s: %v", dbType, lastPaidError)
+		}
+		if lastPaid != "2025-03-01" {
+			t.Errorf("%s: want 2025-03-01 got %s", dbType, lastPaid)
+		}
+
+		needsReview, _ := db.SaleNeedsReview(ctx, saleID)
+		if needsReview {
+			t.Errorf("%s: want the review closed", dbType)
+		}
+
+		db.Rollback()
+
+		// Now that the review is closed, completing it again is refused.
+		if code := complete(url.Values{"user_id": {fmt.Sprint(member.ID)}}); code != http.StatusBadRequest {
+			t.Errorf("%s: want %d got %d", dbType, http.StatusBadRequest, code)
+		}
+
+		db.BeginTx(ctx)
+	}
+}
+
+// adminSaleRequest runs the admin sale helper in a new transaction and returns
+// the page.  A POST commits the transaction.
+func adminSaleRequest(ctx context.Context, h *Handler, db *database.Database, adminID int64, method string, saleID int64, action, note string, now time.Time) string {
+	db.BeginTx(ctx)
+	defer db.Rollback()
+
+	w := httptest.NewRecorder()
+	r := http.Request{Method: method, URL: &url.URL{Path: "/admin/sale"}}
+	if method == http.MethodPost {
+		r.PostForm = url.Values{
+			"id":     {fmt.Sprint(saleID)},
+			"action": {action},
+			"note":   {note},
+		}
+	} else {
+		r.URL.RawQuery = fmt.Sprintf("id=%d", saleID)
+	}
+
+	h.adminSaleHelper(w, &r, adminID, now)
+
+	return w.Body.String()
+}
+
+// TestAdminReport checks that the statistics report is produced as HTML, CSV
+// and JSON.
+func TestAdminReport(t *testing.T) {
+	ctx := t.Context()
+
+	for _, dbType := range databaseList {
+
+		db, connError := database.OpenDBForTesting(dbType)
+		if connError != nil {
+			t.Error(connError)
+			continue
+		}
+
+		db.BeginTx(ctx)
+
+		defer db.Rollback()
+		defer db.CloseAndDelete()
+
+		prepError := database.PrepareTestTables(db)
+		if prepError != nil {
+			t.Error(prepError)
+			continue
+		}
+
+		h := New(&testConfig)
+		h.DB = db
+		h.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+		// Use a membership year that won't have any sales already.
+		const year = 1901
+		sale := database.MembershipSale{
+			PaymentService: "Stripe", PaymentStatus: database.PaymentStatusComplete,
+			TransactionType: database.TransactionTypeNewMember, MembershipYear: year,
+			FirstName: "a", LastName: "b", Email: "c", OrdinaryMemberFeePaid: 24.5,
+		}
+		_, saleError := sale.Create(ctx, db)
+		if saleError != nil {
+			t.Errorf("%s: %v", dbType, saleError)
+			continue
+		}
+
+		now := time.Date(2025, time.March, 1, 12, 0, 0, 0, time.UTC)
+
+		var testData = []struct {
+			format          string
+			wantStatus      int
+			wantContentType string
+			want            string
+		}{
+			{"", http.StatusOK, "", "<td align='right'>24.50</td>"},
+			{"csv", http.StatusOK, "text/csv; charset=utf-8", "1901,total,1,1,0,0,0,24.50,"},
+			{"json", http.StatusOK, "application/json", `"ordinary_member_fees": 24.5`},
+			{"xml", http.StatusBadRequest, "", ""},
+		}
+
+		for _, td := range testData {
+			query := url.Values{"year": {fmt.Sprint(year)}, "format": {td.format}}
+			r := http.Request{Method: http.MethodGet, URL: &url.URL{Path: "/admin/report", RawQuery: query.Encode()}}
+			w := httptest.NewRecorder()
+			h.adminReportHelper(w, &r, now)
+
+			if w.Code != td.wantStatus {
+				t.Errorf("%s %s: want status %d got %d", dbType, td.format, td.wantStatus, w.Code)
+				continue
+			}
+
+			if td.wantContentType != "" && w.Header().Get("Content-Type") != td.wantContentType {
+				t.Errorf("%s %s: want content type %s got %s",
+					dbType, td.format, td.wantContentType, w.Header().Get("Content-Type"))
+			}
+
+			if !strings.Contains(w.Body.String(), td.want) {
+				t.Errorf("%s %s: want %s in\n%s", dbType, td.format, td.want, w.Body.String())
+			}
+		}
+	}
+}
+
+// TestAdminPool checks that the pool page shows the pool settings and the
+// connections in use.
+func TestAdminPool(t *testing.T) {
+	ctx := t.Context()
+
+	for _, dbType := range databaseList {
+
+		db, connError := database.OpenDBForTesting(dbType)
+		if connError != nil {
+			t.Error(connError)
+			continue
+		}
+
+		defer db.CloseAndDelete()
+
+		// The pool shares the test connection.  The transaction holds one of
+		// the connections.
+		config := *db.Config
+		config.MaxIdleConns = 2
+		config.ConnMaxLifetime = 20 * time.Minute
+		db.Connection.SetMaxOpenConns(3)
+
+		db.BeginTx(ctx)
+		defer db.Rollback()
+
+		h := New(&testConfig)
+		h.Pool = &database.Pool{Config: &config, Connection: db.Connection}
+		h.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+		r := http.Request{Method: http.MethodGet, URL: &url.URL{Path: "/admin/pool"}}
+		w := httptest.NewRecorder()
+		h.adminPoolHelper(w, &r)
+
+		want := []string{
+			"<td>Most connections open at once</td><td align='right'>3</td>",
+			"<td>Most idle connections kept</td><td align='right'>2</td>",
+			"<td>Connection lifetime</td><td align='right'>20m0s</td>",
+			"<td>In use</td><td align='right'>1</td>",
+		}
+
+		for _, s := range want {
+			if !strings.Contains(w.Body.String(), s) {
+				t.Errorf("%s: want %s in\n%s", dbType, s, w.Body.String())
+			}
+		}
+	}
+}
@@ -1,13 +1,13 @@
 package handler
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	"net/http"
 	"os"
-	"regexp"
 	"strings"
 	"text/template"
 	"time"
@@ -18,8 +18,13 @@ import (
 	ps "github.com/goblimey/portablesyscall"
 
 	"github.com/goblimey/go-stripe-payments/code/pkg/config"
+	"github.com/goblimey/go-stripe-payments/code/pkg/csrf"
 	"github.com/goblimey/go-stripe-payments/code/pkg/database"
 	"github.com/goblimey/go-stripe-payments/code/pkg/forms"
+	"github.com/goblimey/go-stripe-payments/code/pkg/mail"
+	"github.com/goblimey/go-stripe-payments/code/pkg/ratelimit"
+	websession "github.com/goblimey/go-stripe-payments/code/pkg/session"
+	"github.com/goblimey/go-stripe-payments/code/pkg/token"
 )
 
 // protocol contains the protocol value for the HTTP requests.  The default is
@@ -35,16 +40,33 @@ func init() {
 type Handler struct {
 	Conf                   *config.Config     // The incoming config.
 	DBConfig               *database.DBConfig // The database config
-	DB                     *database.Database // The database connection.
+	DB                     *database.Database // The transaction, only set in a request's own copy (see forRequest).
+	Pool                   *database.Pool     // The pool of database connections.
 	OrdinaryMembershipFee  float64            // The fee for ordinary membership.
 	AssociateMembershipFee float64            // The fee for associate membership (0 if not enabled).
 	FriendMembershipFee    float64            // The fee for friend's membership (0 if not enabled).
 	PrePaymentErrorHTML    string             // The default error message page before the customer pays.
 	PostPaymentErrorHTML   string             // The default error message page after the customer has paid.
+	CSRFErrorHTML          string             // The page displayed when a form fails the CSRF check.
+	BlockedHTML            string             // The page displayed when a sale form submission is blocked.
+	VerificationFailedHTML string             // The page displayed when a verification link is not valid.
+	ReviewHTML             string             // The page displayed after payment when the sale needs review.
 	SuccessPageHTML        string             // The page displayed on a successful sale.
-	PhoneRegexp            *regexp.Regexp     // The regular expression to valdate a phone number.
 	TZ                     *time.Location     // The timezone for this server.
+	Signer                 *token.Signer      // Signs the tokens that carry state between pages.
+	IPLimiter              *ratelimit.Limiter // Limits sale form submissions per IP address.
+	EmailLimiter           *ratelimit.Limiter // Limits sale form submissions per email address.
+	LoginLimiter           *ratelimit.Limiter // Limits failed logins per user name.
+	Mailer                 mail.Sender        // Sends the verification emails.
+	Sessions               *websession.Store  // The sessions of members logged in to the account pages.
+	AdminSessions          *websession.Store  // The sessions of administrators logged in to the admin pages.
 	Logger                 *slog.Logger       // The daily logger.
+
+	// The request's context, with the deadline for its database work, and the
+	// function that releases it.  Only set in a request's own copy (see
+	// forRequest).
+	ctx    context.Context
+	cancel context.CancelFunc
 }
 
 func New(conf *config.Config) *Handler {
@@ -55,14 +77,33 @@ func New(conf *config.Config) *Handler {
 	// If things go wrong after the customer has paid they should be referred to somebody
 	// who can refund their payment, for example the treasurer.
 	postPaymentErrorHTML := fmt.Sprintf(postPaymentErrorHTMLPattern, conf.EmailAddressForFailures, conf.EmailAddressForFailures)
+	// A form may fail the CSRF check before or after the customer has paid, so refer
+	// them to the people who can deal with failures.
+	csrfErrorHTML := fmt.Sprintf(csrfErrorHTMLPattern, conf.EmailAddressForFailures, conf.EmailAddressForFailures)
+	blockedHTML := fmt.Sprintf(blockedHTMLPattern, conf.EmailAddressForQuestions, conf.EmailAddressForQuestions)
+	verificationFailedHTML := fmt.Sprintf(verificationFailedHTMLPattern, conf.EmailAddressForQuestions, conf.EmailAddressForQuestions)
+	reviewHTML := fmt.Sprintf(reviewPageHTMLPattern, conf.EmailAddressForQuestions, conf.EmailAddressForQuestions)
 
 	dbConfig := database.DBConfig{
 		Type: conf.DBType,
 		Host: conf.DBHostname,
 		Port: conf.DBPort,
 		Name: conf.DBDatabase,
+		Path: conf.DBPath,
 		User: conf.DBUser,
 		Pass: conf.DBPassword,
+
+		TablePrefix: conf.DBTablePrefix,
+		SystemUser:  conf.SystemUser,
+
+		OrdinaryRole:  conf.OrdinaryMemberRole,
+		AssociateRole: conf.AssocMemberRole,
+		FriendRole:    conf.FriendRole,
+
+		MaxOpenConns:    conf.DBMaxOpenConns(),
+		MaxIdleConns:    conf.DBMaxIdleConns(),
+		ConnMaxLifetime: conf.DBConnMaxLifetime(),
+		ConnMaxIdleTime: conf.DBConnMaxIdleTime(),
 	}
 
 	h := Handler{
@@ -73,23 +114,28 @@ func New(conf *config.Config) *Handler {
 		FriendMembershipFee:    conf.FriendFee,
 		PrePaymentErrorHTML:    prePaymentErrorHTML,
 		PostPaymentErrorHTML:   postPaymentErrorHTML,
+		CSRFErrorHTML:          csrfErrorHTML,
+		BlockedHTML:            blockedHTML,
+		VerificationFailedHTML: verificationFailedHTML,
+		ReviewHTML:             reviewHTML,
+		Signer:                 token.NewSigner(conf.TokenSecret),
+		IPLimiter:              ratelimit.New(conf.IPRateLimit(), conf.RateLimitWindow()),
+		EmailLimiter:           ratelimit.New(conf.EmailRateLimit(), conf.RateLimitWindow()),
+		LoginLimiter:           ratelimit.New(conf.LoginFailureLimit(), conf.LoginLockout()),
+		Sessions:               websession.New(accountSessionCookie, conf.SessionLifetime(), ps.OSName != "windows"),
+		AdminSessions:          websession.New(adminSessionCookie, conf.SessionLifetime(), ps.OSName != "windows"),
+		ctx:                    context.Background(),
+		cancel:                 func() {},
+	}
+
+	if len(conf.SMTPHost) > 0 {
+		h.Mailer = mail.NewSMTPSender(conf.SMTPAddress(), conf.SMTPFrom, conf.SMTPUser, conf.SMTPPassword)
 	}
 
 	// Now that have a logger we can do some setup that, if it fails, forces us
 	// to kill the server.  Failure is likely caused by some sort of issue that
 	// must be fixed manually, for example via a bug fix and recompile.
 
-	// Regular expression to validate a phone number.  A phone number should start
-	// with "+" or "0".  That should be followed by a list of (digit or space).  For
-	// example "+44 1234 567890", "01234 567890" or "020 7123 4567".
-	const phonePattern = `[+0][0-9 ]+`
-	var reError error
-	h.PhoneRegexp, reError = regexp.Compile(phonePattern)
-	if reError != nil {
-		h.logError("%v", reError)
-		os.Exit(-1)
-	}
-
 	// Set the server's timezone.
 	var locationError error
 	h.TZ, locationError = time.LoadLocation("Europe/London")
@@ -116,43 +162,15 @@ func (h *Handler) GetPaymentData(w http.ResponseWriter, r *http.Request) {
 	h.Logger.Info("GetPaymentData")
 
 	paymentYear := database.GetMembershipYear(time.Now().In(h.TZ))
-	h.DB = database.New(h.DBConfig)
-	h.DB.Logger = h.Logger
-	connectionError := h.DB.Connect()
-	if connectionError != nil {
-		fmt.Println(connectionError.Error())
-		form := forms.NewSaleForm(h.Conf, paymentYear)
-		form.GeneralErrorMessage = fmt.Sprintf("Fatal error - %v", connectionError)
-		form.Valid = false
-		h.reportError(w, h.PrePaymentErrorHTML, connectionError)
-		return
-	}
-
-	// Start a transaction, stored in the database object.
-	txError := h.DB.BeginTx()
-
-	if txError != nil {
-		h.reportError(w, h.PrePaymentErrorHTML, txError)
-		return
-	}
-
-	// The transaction should be ether committed or rolled back before this function exits.
-	// In case it isn't, set up a deferred rollback now.  We choose a rollback rather than
-	// a commit because failure to close the transaction already might be caused by some
-	// sort of catastrophic error.
-	//
-	// When the function is returning, the transaction may have already been closed, in which
-	// case the second rollback may return an error, but that will be ignored.
-	defer h.DB.Rollback()
 
-	defer h.DB.Close()
+	h.withRequest(r.Context(), w, h.PrePaymentErrorHTML, func(h *Handler) {
+		// The helper does the work.
+		h.paymentDataHelper(w, r, paymentYear)
 
-	// The helper does the work.
-	h.paymentDataHelper(w, r, paymentYear)
-
-	// paymentDataHelper doesn't change the database so we can just
-	// close the transaction via a rollback.
-	h.DB.Rollback()
+		// paymentDataHelper doesn't change the database so we can just
+		// close the transaction via a rollback.
+		h.DB.Rollback()
+	})
 }
 
 // GetPaymentDataHelper validates the form and prepares the response.
@@ -160,11 +178,14 @@ func (h *Handler) GetPaymentData(w http.ResponseWriter, r *http.Request) {
 func (h *Handler) paymentDataHelper(w http.ResponseWriter, r *http.Request, paymentYear int) {
 
 	h.Logger.Info("paymentDataHelper")
+	now := time.Now()
 	sf := forms.NewSaleForm(h.Conf, paymentYear)
 
 	sf.OrdinaryMemberFee = h.Conf.OrdinaryMemberFee
 	sf.AssocMemberFee = h.Conf.AssocMemberFee
 	sf.FriendFee = h.Conf.FriendFee
+	sf.CSRFToken = csrf.Token(r)
+	sf.FormStarted = h.issueFormStarted(now)
 
 	sf.Title = r.PostFormValue("title")
 	sf.FirstName = r.PostFormValue("first_name")
@@ -191,7 +212,14 @@ func (h *Handler) paymentDataHelper(w http.ResponseWriter, r *http.Request, paym
 		len(sf.AssocLastName) == 0 {
 
 		// On the first call in a sequence, display an empty form with mandatory fields marked.
-		h.displayInitialSaleForm(w, paymentYear)
+		h.displayInitialSaleForm(w, paymentYear, sf.CSRFToken, sf.FormStarted)
+		return
+	}
+
+	// Watch out for bots.
+	if h.saleFormBlocked(r, now) {
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write([]byte(h.BlockedHTML))
 		return
 	}
 
@@ -215,6 +243,19 @@ func (h *Handler) paymentDataHelper(w http.ResponseWriter, r *http.Request, paym
 
 	h.setPayments(sf)
 
+	// If an existing account may be one of the members', the page asks.
+	var confirmError error
+	sf.ConfirmMember, confirmError = h.needsConfirmation(sf.FirstName, sf.LastName, sf.Email)
+	if confirmError == nil && sf.EnableOtherMemberTypes && len(sf.AssocFirstName) > 0 {
+		sf.ConfirmAssocMember, confirmError =
+			h.needsConfirmation(sf.AssocFirstName, sf.AssocLastName, sf.AssocEmail)
+	}
+	if confirmError != nil {
+		h.logError("paymentDataHelper: %v", confirmError)
+		w.Write([]byte(h.PrePaymentErrorHTML))
+		return
+	}
+
 	// Check the template.
 	paymentConfirmationPageTemplate, templateError :=
 		template.New("PaymentConfirmationPage").Parse(paymentConfirmationPageTemplate)
@@ -267,35 +308,11 @@ func (h *Handler) Checkout(w http.ResponseWriter, r *http.Request) {
 
 	h.Logger.Info("Checkout")
 
-	h.DB = database.New(h.DBConfig)
-	h.DB.Logger = h.Logger
-	connectionError := h.DB.Connect()
-	if connectionError != nil {
-		h.reportError(w, h.PrePaymentErrorHTML, connectionError)
-		return
-	}
-
-	// Start a transaction, stored in the database object.
-	txError := h.DB.BeginTx()
+	h.withRequest(r.Context(), w, h.PrePaymentErrorHTML, func(h *Handler) {
+		paymentYear := database.GetMembershipYear(time.Now().In(h.TZ))
 
-	if txError != nil {
-		h.reportError(w, h.PrePaymentErrorHTML, txError)
-		return
-	}
-
-	// The transaction should be ether committed or rolled back before this function exits.
-	// In case it isn't, set up a deferred rollback now.  We choose a rollback rather than
-	// a commit because failure to close the transaction already is almost certainly caused
-	// by some sort of catastrophic error.
-	//
-	// When the function is returning, the transaction may have already been closed, in which
-	// case the second rollback may return an error, but that will be ignored.
-	defer h.DB.Rollback()
-	defer h.DB.Close()
-
-	paymentYear := database.GetMembershipYear(time.Now().In(h.TZ))
-
-	h.checkoutHelper(w, r, paymentYear)
+		h.checkoutHelper(w, r, paymentYear)
+	})
 }
 
 // checkoutHelper creates a MembershipSale record to record progress and prepares the response.
@@ -306,6 +323,14 @@ func (h *Handler) checkoutHelper(w http.ResponseWriter, r *http.Request, payment
 	const fn = "checkoutHelper"
 	h.Logger.Info(fn)
 
+	// The confirmation page carries the same data as the sale form, which has
+	// already been checked, but a bot may post here directly.
+	if h.ipRateLimited(r, time.Now()) {
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write([]byte(h.BlockedHTML))
+		return
+	}
+
 	// If the incoming data is the result of the expected page flow, everything has
 	// been validated but we can't assume that.  Somebody may be trying to pull a
 	// fast one, so we validate the form again.  If there is any error, we stop
@@ -376,16 +401,83 @@ func (h *Handler) checkoutHelper(w http.ResponseWriter, r *http.Request, payment
 		}
 	}
 
-	salesID, createError := ms.Create(h.DB)
+	// If the customer has given the details of existing members, they must prove
+	// that they own the accounts before they can pay, or an administrator must
+	// check the sale.
+	toVerify, reviewReasons, matchError := h.matchMembers(ms,
+		r.PostFormValue("member_confirmed"), r.PostFormValue("assoc_member_confirmed"))
+	if matchError != nil {
+		h.DB.Rollback()
+		h.reportError(w, h.PrePaymentErrorHTML, matchError)
+		return
+	}
+
+	if len(toVerify) > 0 {
+		ms.PaymentStatus = database.PaymentStatusUnverified
+	}
+
+	_, createError := ms.Create(h.ctx, h.DB)
 	if createError != nil {
 		h.DB.Rollback()
 		h.logError("%s: CreateError - %v", fn, createError)
 		h.reportError(w, h.PrePaymentErrorHTML, createError)
+		return
+	}
+
+	for _, reason := range reviewReasons {
+		h.logMessage("%s: sale %d needs review - %s", fn, ms.ID, reason)
+		review := database.Review{SaleID: ms.ID, Reason: reason}
+		reviewError := h.DB.CreateReview(h.ctx, &review)
+		if reviewError != nil {
+			h.DB.Rollback()
+			h.reportError(w, h.PrePaymentErrorHTML, reviewError)
+			return
+		}
+	}
+
+	if len(toVerify) > 0 {
+		masked, verifyError := h.startVerification(ms, toVerify, r.Host, time.Now())
+		if verifyError != nil {
+			h.DB.Rollback()
+			h.logError("%s: sale %d - %v", fn, ms.ID, verifyError)
+			h.reportError(w, h.PrePaymentErrorHTML, verifyError)
+			return
+		}
+
+		if len(masked) == 0 {
+			// Nobody could be sent a link, so the sale is in the review queue
+			// instead and can go ahead.
+			ms.PaymentStatus = database.PaymentStatusPending
+		}
+
+		updateError := ms.Update(h.ctx, h.DB)
+		if updateError != nil {
+			h.DB.Rollback()
+			h.reportError(w, h.PrePaymentErrorHTML, updateError)
+			return
+		}
+
+		if len(masked) > 0 {
+			commitError := h.DB.Commit()
+			if commitError != nil {
+				h.reportError(w, h.PrePaymentErrorHTML, commitError)
+				return
+			}
+			h.displayCheckEmailPage(w, ms, masked)
+			return
+		}
 	}
 
 	// We have all we need from the database - commit the transaction.
 	h.DB.Commit()
 
+	h.redirectToStripe(w, r, ms)
+}
+
+// redirectToStripe creates a Stripe checkout session for the given sale and
+// redirects the browser to the Stripe payment page.
+func (h *Handler) redirectToStripe(w http.ResponseWriter, r *http.Request, ms *database.MembershipSale) {
+
 	// Prepare to pass control to the Stripe payment page.
 
 	successURL := fmt.Sprintf("%s://%s/success?session_id={CHECKOUT_SESSION_ID}", protocol, r.Host)
@@ -394,7 +486,7 @@ func (h *Handler) checkoutHelper(w http.ResponseWriter, r *http.Request, payment
 	invoicingEnabled := true
 
 	description := fmt.Sprintf(
-		"%s membership year %d", h.Conf.OrganisationName, paymentYear)
+		"%s membership year %d", h.Conf.OrganisationName, ms.MembershipYear)
 
 	invoiceData := stripe.CheckoutSessionInvoiceCreationInvoiceDataParams{
 		Description: &description,
@@ -409,7 +501,7 @@ func (h *Handler) checkoutHelper(w http.ResponseWriter, r *http.Request, payment
 	// the ms_id of the membership_sales record.  This allows the application to
 	// pick up where it left off when control is returned from Stripe.
 
-	salesIDStr := fmt.Sprintf("%d", salesID)
+	salesIDStr := fmt.Sprintf("%d", ms.ID)
 
 	priceInPennies := int64(ms.Total()*100 + 0.5)
 
@@ -441,10 +533,9 @@ func (h *Handler) checkoutHelper(w http.ResponseWriter, r *http.Request, payment
 	// Create the checkout session.
 	s, sessErr := session.New(params)
 	if sessErr != nil {
-
-		h.DB.Rollback()
 		h.logMessage("error creating Stripe session - %v", sessErr)
 		h.reportError(w, h.PrePaymentErrorHTML, sessErr)
+		return
 	}
 
 	// Redirect to the Stripe system.  On a successful payment, it will
@@ -514,39 +605,8 @@ func (h *Handler) Success(w http.ResponseWriter, r *http.Request) {
 
 	h.logMessage("Success()")
 
-	// We figure out the start and end dates here to support unit testing of the SuccessHelper.
-	startTime := time.Now().In(h.TZ)
-	// The end date is the end of the calendar year of payment.
-	yearEnd := time.Date(
-		startTime.Year(), time.December, 31, 23, 59, 59, 999999999, h.TZ,
-	)
-
-	h.DB = database.New(h.DBConfig)
-	h.DB.Logger = h.Logger
-	connectionError := h.DB.Connect()
-	if connectionError != nil {
-		h.reportError(w, h.PrePaymentErrorHTML, connectionError)
-		return
-	}
-
-	// Start a transaction.
-	txError := h.DB.BeginTx()
-	if txError != nil {
-		h.reportError(w, h.PrePaymentErrorHTML, txError)
-		return
-	}
-
-	// The transaction should be ether committed or rolled back before this function exits.
-	// In case it isn't, set up a deferred rollback now.  We choose a rollback rather than
-	// a commit because failure to close the transaction already is almost certainly caused
-	// by some sort of catastrophic error.
-	//
-	// When the function is returning, the transaction may have already been closed, in which
-	// case the second rollback may return an error, but that will be ignored.
-	defer h.DB.Rollback()
-	defer h.DB.Close()
-
-	// Get the Stripe session.
+	// Get the Stripe session.  This is done before borrowing a transaction so
+	// that the connection isn't held while we wait for Stripe.
 	sessionID := r.URL.Query().Get("session_id")
 	params := stripe.CheckoutSessionParams{}
 	stripeSession, sessionGetError := session.Get(sessionID, &params)
@@ -555,23 +615,36 @@ func (h *Handler) Success(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	connError := h.connectToDB()
-	if connError != nil {
-		h.reportError(w, h.PrePaymentErrorHTML, connError)
-		return
-	}
+	// The customer has paid, so the sale is completed even if the browser goes
+	// away before the response is sent.  The database deadline still applies.
+	h.completeSale(context.WithoutCancel(r.Context()), w, stripeSession, csrf.Token(r))
 
-	now := time.Now().In(h.TZ)
-	paymentYear := database.GetMembershipYear(now)
+	// The helper should send an HTTP response so we shouldn't get to here.
+}
 
-	h.successHelper(w, stripeSession, startTime, yearEnd, now, paymentYear)
+// completeSale borrows a transaction for the request and completes the sale
+// given by the Stripe checkout session.  It's separated out so that the sale
+// can be completed without a call to Stripe, which supports testing.
+func (h *Handler) completeSale(ctx context.Context, w http.ResponseWriter, stripeSession *stripe.CheckoutSession, csrfToken string) {
 
-	// The helper should send an HTTP response so we shouldn't get to here.
+	// We figure out the start and end dates here to support unit testing of the SuccessHelper.
+	startTime := time.Now().In(h.TZ)
+	// The end date is the end of the calendar year of payment.
+	yearEnd := time.Date(
+		startTime.Year(), time.December, 31, 23, 59, 59, 999999999, h.TZ,
+	)
+
+	h.withRequest(ctx, w, h.PostPaymentErrorHTML, func(h *Handler) {
+		now := time.Now().In(h.TZ)
+		paymentYear := database.GetMembershipYear(now)
+
+		h.successHelper(w, stripeSession, startTime, yearEnd, now, paymentYear, csrfToken)
+	})
 }
 
 // successHelper completes the sale.  It's separated out and the start and end dates are supplied to
 // support unit testing.
-func (h *Handler) successHelper(w http.ResponseWriter, stripeSession *stripe.CheckoutSession, startDate, endDate, now time.Time, paymentYear int) {
+func (h *Handler) successHelper(w http.ResponseWriter, stripeSession *stripe.CheckoutSession, startDate, endDate, now time.Time, paymentYear int, csrfToken string) {
 
 	const fn = "successHelper"
 
@@ -586,6 +659,20 @@ func (h *Handler) successHelper(w http.ResponseWriter, stripeSession *stripe.Che
 		fn, ms.TransactionType, ms.Title, ms.FirstName, ms.LastName,
 		ms.AssocTitle, ms.AssocFirstName, ms.AssocLastName)
 
+	needsReview, reviewError := h.DB.SaleNeedsReview(h.ctx, ms.ID)
+	if reviewError != nil {
+		h.reportError(w, h.PostPaymentErrorHTML, reviewError)
+		h.DB.Rollback()
+		return
+	}
+
+	if needsReview {
+		// We can't tell which accounts the sale belongs to, so record the payment
+		// and leave the accounts for an administrator.
+		h.completeSaleForReview(w, ms)
+		return
+	}
+
 	cmError := h.setMemberDetails(ms, startDate, endDate, now, paymentYear)
 	if cmError != nil {
 		h.reportError(w, h.PostPaymentErrorHTML, cmError)
@@ -593,152 +680,10 @@ func (h *Handler) successHelper(w http.ResponseWriter, stripeSession *stripe.Che
 		return
 	}
 
-	// // The user must have paid at least this fee.
-	// ms.OrdinaryMemberFeePaid = h.Conf.OrdinaryMemberFee
-
-	// // The sale is complete.
-	// ms.PaymentStatus = database.PaymentStatusComplete
-
-	// ms.PaymentID = fmt.Sprintf("%s %s", stripeSession.Customer.ID, stripeSession.Customer.Email)
-
-	// // Membership renewal or new member(s)?
-	// var lookupError error
-	// ms.UserID, ms.AssocUserID, lookupError = usersExist(ms, h.DB)
-	// if lookupError != nil {
-	// 	h.DB.Rollback()
-	// 	h.reportError(w, h.PrePaymentErrorHTML, lookupError)
-	// 	return
-	// }
-
-	// var newUser bool
-	// if ms.UserID <= 0 {
-	// 	newUser = true
-	// }
-
-	// if newUser {
-	// 	// A new member is registering.  We haven't created the member account(s) yet.
-	// 	newUser = true
-	// 	ms.TransactionType = database.TransactionTypeNewMember
-	// 	var createUserError error
-	// 	ms.UserID, ms.AssocUserID, createUserError =
-	// 		h.DB.CreateAccounts(ms, startDate, endDate)
-	// 	if createUserError != nil {
-	// 		// Failed to create one or both of the users.  The user has paid but we
-	// 		// can't fulfill the sale so this error is bad.  Report it to the user.
-	// 		h.DB.Rollback()
-	// 		h.reportError(w, h.PostPaymentErrorHTML, createUserError)
-	// 		return
-	// 	}
-	// } else {
-	// 	// An existing member is renewing, possibly with an associate member.
-	// 	ms.TransactionType = database.TransactionTypeRenewal
-
-	// 	// For a new member, various fields are set at this point, so set them
-	// 	// for the renewing member(s) too.  The most important change is setting
-	// 	// the member end date, because that's what marks them as a paid up
-	// 	// member, which is what they've just paid for.
-
-	// 	// Set the end date for the ordinary member.
-	// 	omError := h.DB.SetMemberEndDate(ms.UserID, ms.MembershipYear)
-	// 	if omError != nil {
-	// 		h.DB.Rollback()
-	// 		h.reportError(w, h.PostPaymentErrorHTML, omError)
-	// 		return
-	// 	}
-
-	// 	// Set the name fields.
-	// 	ttError := h.DB.SetTitle(ms.UserID, ms.Title)
-	// 	if ttError != nil {
-	// 		h.DB.Rollback()
-	// 		h.reportError(w, h.PostPaymentErrorHTML, ttError)
-	// 		return
-	// 	}
-
-	// 	fnError := h.DB.SetFirstName(ms.UserID, ms.FirstName)
-	// 	if fnError != nil {
-	// 		h.DB.Rollback()
-	// 		h.reportError(w, h.PostPaymentErrorHTML, fnError)
-	// 		return
-	// 	}
-
-	// 	lnError := h.DB.SetLastName(ms.UserID, ms.LastName)
-	// 	if lnError != nil {
-	// 		h.DB.Rollback()
-	// 		h.reportError(w, h.PostPaymentErrorHTML, lnError)
-	// 		return
-	// 	}
-
-	// 	if h.Conf.EnableOtherMemberTypes && ms.AssocUserID > 0 {
-	// 		// Set the end date for the associate member.
-	// 		assocError := h.DB.SetMemberEndDate(ms.AssocUserID, ms.MembershipYear)
-	// 		if assocError != nil {
-	// 			h.DB.Rollback()
-	// 			h.reportError(w, h.PostPaymentErrorHTML, assocError)
-	// 			return
-	// 		}
-
-	// 		// Set the associate member's name fields.
-	// 		assocTTError := h.DB.SetTitle(ms.AssocUserID, ms.AssocTitle)
-	// 		if assocTTError != nil {
-	// 			h.DB.Rollback()
-	// 			h.reportError(w, h.PostPaymentErrorHTML, assocTTError)
-	// 			return
-	// 		}
-
-	// 		assocFNError := h.DB.SetFirstName(ms.AssocUserID, ms.AssocFirstName)
-	// 		if assocFNError != nil {
-	// 			h.DB.Rollback()
-	// 			h.reportError(w, h.PostPaymentErrorHTML, assocFNError)
-	// 			return
-	// 		}
-
-	// 		assocLNError := h.DB.SetLastName(ms.AssocUserID, ms.AssocLastName)
-	// 		if assocLNError != nil {
-	// 			h.DB.Rollback()
-	// 			h.reportError(w, h.PostPaymentErrorHTML, assocLNError)
-	// 			return
-	// 		}
-	// 	}
-	// }
-
-	// // Set the data protection field for the full-price member.
-	// dpError := h.DB.SetDataProtectionField(ms.UserID, true)
-	// if dpError != nil {
-	// 	h.DB.Rollback()
-	// 	h.reportError(w, h.PostPaymentErrorHTML, dpError)
-	// 	return
-	// }
-
-	// if ms.AssocUserID > 0 {
-	// 	assocDPError := h.DB.SetDataProtectionField(ms.AssocUserID, true)
-	// 	if assocDPError != nil {
-	// 		h.DB.Rollback()
-	// 		h.reportError(w, h.PostPaymentErrorHTML, assocDPError)
-	// 		return
-	// 	}
-	// }
-
-	// // Set the data protection field for the full-price member.
-	// reError := h.DB.SetReceiveEmailField(ms.UserID, true)
-	// if dpError != nil {
-	// 	h.DB.Rollback()
-	// 	h.reportError(w, h.PostPaymentErrorHTML, reError)
-	// 	return
-	// }
-
-	// if ms.AssocUserID > 0 {
-	// 	assocREError := h.DB.SetReceiveEmailField(ms.AssocUserID, true)
-	// 	if assocREError != nil {
-	// 		h.DB.Rollback()
-	// 		h.reportError(w, h.PostPaymentErrorHTML, assocREError)
-	// 		return
-	// 	}
-	// }
-
 	// We've done the important update.  In case something catastrophic happens later,
 	// commit the changes made so far and then open a new transaction.
 
-	updateError1 := ms.Update(h.DB)
+	updateError1 := ms.Update(h.ctx, h.DB)
 	if updateError1 != nil {
 		h.logError("%s: user ID %d - failed to update membership sales record %d - %v",
 			fn, ms.UserID, ms.ID, updateError1)
@@ -753,7 +698,7 @@ func (h *Handler) successHelper(w http.ResponseWriter, stripeSession *stripe.Che
 		return
 	}
 
-	txError := h.DB.BeginTx()
+	txError := h.DB.BeginTx(h.ctx)
 	if txError != nil {
 		h.reportError(w, h.PrePaymentErrorHTML, txError)
 		return
@@ -767,14 +712,14 @@ func (h *Handler) successHelper(w http.ResponseWriter, stripeSession *stripe.Che
 		h.logError("%s: user ID %d - %v", fn, ms.UserID, commit2Error)
 	}
 
-	txe := h.DB.BeginTx()
+	txe := h.DB.BeginTx(h.ctx)
 	if txe != nil {
 		h.logError("%s: %v", fn, txe)
 	}
 	// There are no more DB writes from now on, so there will be nothing to commit.
 	defer h.DB.Rollback()
 
-	user, fue := h.DB.GetUser(ms.UserID)
+	user, fue := h.DB.GetUser(h.ctx, ms.UserID)
 	if fue != nil {
 		h.logError("%s: %v", fn, fue)
 	}
@@ -782,18 +727,33 @@ func (h *Handler) successHelper(w http.ResponseWriter, stripeSession *stripe.Che
 
 	if ms.AssocUserID > 0 {
 		// There is an associate .
-		assocUser, faue := h.DB.GetUser(ms.AssocUserID)
+		assocUser, faue := h.DB.GetUser(h.ctx, ms.AssocUserID)
 		if faue != nil {
 			h.logError("%s: %v", fn, faue)
 		}
 		ms.AssocAccountName = assocUser.LoginName
 	}
 
+	// The extra details page posts back this token rather than the account names, so
+	// that the customer can only update the accounts that they have just paid for.
+	saleToken := token.SaleToken{
+		SaleID:      ms.ID,
+		UserID:      ms.UserID,
+		AssocUserID: ms.AssocUserID,
+		Expires:     now.Add(h.Conf.TokenLifetime()),
+	}
+	ms.Token = h.Signer.IssueSaleToken(&saleToken)
+	ms.CSRFToken = csrfToken
+
 	if ms.TransactionType == database.TransactionTypeRenewal {
 		// A user is renewing.  Get any extra details that they have already set.
 		// (for example, in a previous year.  These are used to pre-populate the
 		// extra details collection page.
 		h.fetchCurrentExtraDetails(ms)
+	} else {
+		// A new member has no details yet but the form shows the fields from
+		// the extra fields category.
+		h.fetchExtraFields(ms)
 	}
 
 	// Create the selection list of countries.
@@ -836,8 +796,9 @@ func (h *Handler) successHelper(w http.ResponseWriter, stripeSession *stripe.Che
 	interestHTML := h.makeInterestSelectionHTML(ms)
 
 	// Create the completion page.
-	successPageTemplateString := successPageTemplateString1 + countriesHTML +
-		successPageTemplateString2 + interestHTML + successPageTemplateString3
+	successPageTemplateString := successPageTemplateString1 +
+		profileRowsTemplate(countriesHTML, successAssocMobileRow) +
+		extraFieldRows + interestHTML + successPageTemplateString2
 
 	successPageTemplate, parseError :=
 		template.New("SuccessPage").Parse(successPageTemplateString)
@@ -858,6 +819,36 @@ func (h *Handler) successHelper(w http.ResponseWriter, stripeSession *stripe.Che
 	// Success!
 }
 
+// completeSaleForReview records the payment for a sale that is in the review
+// queue and tells the customer what happens next.  The member accounts are not
+// touched.
+func (h *Handler) completeSaleForReview(w http.ResponseWriter, ms *database.MembershipSale) {
+
+	const fn = "completeSaleForReview"
+
+	ms.PaymentStatus = database.PaymentStatusComplete
+	ms.OrdinaryMemberFeePaid = h.Conf.OrdinaryMemberFee
+
+	updateError := ms.Update(h.ctx, h.DB)
+	if updateError != nil {
+		h.logError("%s: failed to update membership sales record %d - %v", fn, ms.ID, updateError)
+		h.DB.Rollback()
+		h.reportError(w, h.PostPaymentErrorHTML, updateError)
+		return
+	}
+
+	commitError := h.DB.Commit()
+	if commitError != nil {
+		h.logError("%s: failed to commit membership sales record %d - %v", fn, ms.ID, commitError)
+		h.reportError(w, h.PostPaymentErrorHTML, commitError)
+		return
+	}
+
+	h.logMessage("%s: sale %d paid and waiting for review", fn, ms.ID)
+
+	w.Write([]byte(h.ReviewHTML))
+}
+
 func (h *Handler) getMembershipSaleOnSuccess(stripeSession *stripe.CheckoutSession, startDate, endDate, now time.Time, paymentYear int) (*database.MembershipSale, error) {
 	const fn = "getMembershipSaleOnSuccess"
 
@@ -875,7 +866,7 @@ func (h *Handler) getMembershipSaleOnSuccess(stripeSession *stripe.CheckoutSessi
 
 	// Get the membership sales record.  The ClientReferenceID in the payment
 	// session is the ID of the sales record.
-	ms, fetchError := h.DB.GetMembershipSale(saleID)
+	ms, fetchError := h.DB.GetMembershipSale(h.ctx, saleID)
 	if fetchError != nil {
 		return nil, fetchError
 	}
@@ -901,152 +892,92 @@ func (h *Handler) setMemberDetails(ms *database.MembershipSale, startDate, endDa
 	ms.PaymentStatus = database.PaymentStatusComplete
 	ms.OrdinaryMemberFeePaid = h.Conf.OrdinaryMemberFee
 
-	// Check if the users already exist.
-	var lookupError error
-	ms.UserID, ms.AssocUserID, lookupError = usersExist(ms, h.DB)
-	if lookupError != nil {
-		return lookupError
-	}
-
+	// The sale only holds the IDs of existing users if the customer proved that
+	// they own the accounts before paying.  Otherwise they are new members.
 	if ms.UserID <= 0 {
 		// This is a sale of new membership.
 		ms.TransactionType = database.TransactionTypeNewMember
 		// Create the member account(s).
 		var createUserError error
-		ms.UserID, ms.AssocUserID, createUserError =
-			h.DB.CreateAccounts(ms, startDate, endDate)
+		if ms.AssocUserID > 0 {
+			// The associate has proved that they own an existing account.
+			// Create an account for the ordinary member only.
+			ordinaryOnly := *ms
+			ordinaryOnly.AssocFirstName = ""
+			ordinaryOnly.AssocLastName = ""
+			ordinaryOnly.AssocEmail = ""
+			ms.UserID, _, createUserError =
+				h.DB.CreateAccounts(h.ctx, &ordinaryOnly, startDate, endDate)
+		} else {
+			ms.UserID, ms.AssocUserID, createUserError =
+				h.DB.CreateAccounts(h.ctx, ms, startDate, endDate)
+		}
 		if createUserError != nil {
 			// Failed to create one or both of the users.
 			return createUserError
 		}
-	}
-
-	// Set the end date for the ordinary member.
-	omError := h.DB.SetMemberEndDate(ms.UserID, ms.MembershipYear)
-	if omError != nil {
-		return omError
-	}
-
-	// Set the data fields (adm_user_data table) for the full-price member.
-
-	if len(ms.Title) > 0 {
-		ttError := h.DB.SetTitle(ms.UserID, ms.Title)
-		if ttError != nil {
-			return ttError
-		}
-	}
-
-	if len(ms.FirstName) > 0 {
-		fnError := h.DB.SetFirstName(ms.UserID, ms.FirstName)
-		if fnError != nil {
-			return fnError
-		}
-	}
-
-	if len(ms.LastName) > 0 {
-		lnError := h.DB.SetLastName(ms.UserID, ms.LastName)
-		if lnError != nil {
-			return lnError
-		}
-	}
-
-	if len(ms.Email) > 0 {
-		emError := h.DB.SetEmail(ms.UserID, ms.Email)
-		if emError != nil {
-			return emError
-		}
-	}
-
-	if ms.DonationToSociety > 0 {
-		dtsError := h.DB.SetDonationToSociety(ms.UserID, ms.DonationToSociety)
-		if dtsError != nil {
-			return dtsError
-		}
-	}
-
-	if ms.DonationToMuseum > 0 {
-		dtmError := h.DB.SetDonationToMuseum(ms.UserID, ms.DonationToMuseum)
-		if dtmError != nil {
-			return dtmError
-		}
-	}
+	} else {
+		ms.TransactionType = database.TransactionTypeRenewal
 
-	// Set the associates's friend field (true or false).
-	fError := h.DB.SetFriendField(ms.UserID, ms.Friend)
-	if fError != nil {
-		return fError
-	}
-	if ms.Giftaid {
-		gError := h.DB.SetGiftaid(ms.UserID, true)
-		if gError != nil {
-			return gError
+		if h.Conf.EnableOtherMemberTypes && len(ms.AssocFirstName) > 0 && ms.AssocUserID <= 0 {
+			// An existing member is renewing with a new associate.
+			_, createAssocError := h.DB.CreateAssocAccount(h.ctx, ms, startDate, endDate)
+			if createAssocError != nil {
+				return createAssocError
+			}
 		}
 	}
 
-	// Set the data protection field for the full-price member.
-	dpError := h.DB.SetDataProtectionField(ms.UserID, true)
-	if dpError != nil {
-		return dpError
+	// Extend the memberships that the members have paid for - ordinary,
+	// associate and friend - each in its own role.
+	renewError := h.DB.RenewMemberships(h.ctx, ms, startDate)
+	if renewError != nil {
+		return renewError
 	}
 
-	// Set the receive email field for the full-price member.
-	reError := h.DB.SetReceiveEmailField(ms.UserID, true)
-	if reError != nil {
-		return reError
+	// Set the data fields (adm_user_data table) for the full-price member.
+	// The names, email address, donations and giftaid are only set if they
+	// are given, so as not to wipe out the values from last time.  The friend
+	// field is always set (true or false) and the data protection and receive
+	// email fields are always ticked.
+	ud := database.UserData{
+		UserID:            ms.UserID,
+		Title:             ms.Title,
+		FirstName:         ms.FirstName,
+		LastName:          ms.LastName,
+		Email:             ms.Email,
+		DonationToSociety: ms.DonationToSociety,
+		DonationToMuseum:  ms.DonationToMuseum,
+		Giftaid:           ms.Giftaid,
+		Friend:            ms.Friend,
+		DataProtection:    true,
+		NoticesByEmail:    true,
+	}
+	fields := database.GivenFields(&ud, []string{"SALUTATION", "FIRST_NAME", "LAST_NAME", "EMAIL",
+		"VALUE_OF_DONATION_TO_LDLHS", "VALUE_OF_DONATION_TO_THE_MUSEUM", "GIFT_AID"})
+	fields = append(fields, "FRIEND_OF_THE_MUSEUM", database.DataStoragePermNameIntern, "NOTICES_BY_EMAIL")
+	saveError := h.DB.SaveUserData(h.ctx, &ud, fields...)
+	if saveError != nil {
+		return saveError
 	}
 
 	if h.Conf.EnableOtherMemberTypes && ms.AssocUserID > 0 {
-		// Set the end date for the associate member.
-		assocError := h.DB.SetMemberEndDate(ms.AssocUserID, ms.MembershipYear)
-		if assocError != nil {
-			return assocError
-		}
-
-		// Set the associate member's name fields.
-		if len(ms.AssocTitle) > 0 {
-			assocTTError := h.DB.SetTitle(ms.AssocUserID, ms.AssocTitle)
-			if assocTTError != nil {
-				return assocTTError
-			}
+		// The same for the associate member.
+		assoc := database.UserData{
+			UserID:         ms.AssocUserID,
+			Title:          ms.AssocTitle,
+			FirstName:      ms.AssocFirstName,
+			LastName:       ms.AssocLastName,
+			Email:          ms.AssocEmail,
+			Friend:         ms.AssocFriend,
+			DataProtection: true,
+			NoticesByEmail: true,
 		}
-
-		if len(ms.AssocFirstName) > 0 {
-			assocFNError := h.DB.SetFirstName(ms.AssocUserID, ms.AssocFirstName)
-			if assocFNError != nil {
-				return assocFNError
-			}
-		}
-
-		if len(ms.AssocLastName) > 0 {
-			assocLNError := h.DB.SetLastName(ms.AssocUserID, ms.AssocLastName)
-			if assocLNError != nil {
-				return assocLNError
-			}
-		}
-
-		if len(ms.AssocEmail) > 0 {
-			aemError := h.DB.SetEmail(ms.AssocUserID, ms.AssocEmail)
-			if aemError != nil {
-				return aemError
-			}
-		}
-
-		// Set the associates's friend field (true or false).
-		afError := h.DB.SetFriendField(ms.AssocUserID, ms.AssocFriend)
-		if afError != nil {
-			return afError
-		}
-
-		// Set the data protection field for the associate member.
-		assocDPError := h.DB.SetDataProtectionField(ms.AssocUserID, true)
-		if assocDPError != nil {
-			return assocDPError
-		}
-
-		// Set the receive email field for the full-price member.
-		assocREError := h.DB.SetReceiveEmailField(ms.AssocUserID, true)
-		if assocREError != nil {
-			return assocREError
+		assocFields := database.GivenFields(&assoc, []string{"SALUTATION", "FIRST_NAME", "LAST_NAME", "EMAIL"})
+		assocFields = append(assocFields, "FRIEND_OF_THE_MUSEUM", database.DataStoragePermNameIntern, "NOTICES_BY_EMAIL")
+		saveAssocError := h.DB.SaveUserData(h.ctx, &assoc, assocFields...)
+		if saveAssocError != nil {
+			return saveAssocError
 		}
 	}
 
@@ -1061,10 +992,6 @@ func (h *Handler) setAccountingRecordsForMembers(ms *database.MembershipSale, pa
 	// and continue processing.
 
 	fn := "setAccountingRecordsForMembers"
-	dlpError := h.DB.SetDateLastPaid(ms.UserID, paymentDate)
-	if dlpError != nil {
-		h.logError("%s: user ID %d - %v\n", fn, ms.UserID, dlpError)
-	}
 
 	// The ID of the user record of the ordinary member is in the sale record.  If
 	// the sale includes an associate member, the ID of their user record is there too.
@@ -1085,76 +1012,45 @@ func (h *Handler) setAccountingRecordsForMembers(ms *database.MembershipSale, pa
 		}
 	}
 
-	paymentError := h.DB.SetLastPayment(ms.UserID, ms.Total())
-	if paymentError != nil {
-		em := fmt.Sprintf("error setting last payment for %d - %v",
-			ms.UserID, paymentError)
-		h.logError("%s: user ID %d - %s", fn, ms.UserID, em)
-	}
-
-	// Set the members at address and friends at address in the ordinary member's record.
-	setMembersError := h.DB.SetMembersAtAddress(ms.UserID, membersAtAddress)
-	if setMembersError != nil {
-		h.logError("%s: user ID %d - %v", fn, ms.UserID, setMembersError)
-	}
-
-	if h.Conf.EnableGiftaid && ms.Giftaid {
-		// Set the giftaid tick box, true or false.
-		giftAidError := h.DB.SetGiftaid(ms.UserID, ms.Giftaid)
-		if giftAidError != nil {
-			h.logError("%s: user ID %d - %v\n", fn, ms.UserID, giftAidError)
-		}
-	}
-
-	setFriendsError := h.DB.SetFriendsAtAddress(ms.UserID, friendsAtAddress)
-	if setFriendsError != nil {
-		h.logError("%s: user ID %d - %v", fn, ms.UserID, setFriendsError)
-	}
-
 	// If the member is a friend, tick the box.  The user may have been a friend last
 	// year and so the record in the DB will be ticked.  The user may not be a friend
-	// this year, so always reset the value.
-	friendError := h.DB.SetFriendField(
-		ms.UserID, ms.Friend)
-	if friendError != nil {
-		h.logError("%s: user ID %d - %v\n", fn, ms.UserID, friendError)
-	}
-
-	// Update the user's donation to society.
-	dsError := h.DB.SetDonationToSociety(ms.UserID, ms.DonationToSociety)
-	if dsError != nil {
-		e := fmt.Errorf("error setting donation to society for %d - %v",
-			ms.UserID, dsError)
-		h.logError("%s: user ID %d - %v\n", fn, ms.UserID, e)
+	// this year, so always reset the value.  The giftaid box is only set if it's
+	// ticked.
+	ud := database.UserData{
+		UserID:            ms.UserID,
+		DateLastPaid:      paymentDate.Format("2006-01-02"),
+		LastPayment:       ms.Total(),
+		MembersAtAddress:  membersAtAddress,
+		FriendsAtAddress:  friendsAtAddress,
+		Friend:            ms.Friend,
+		DonationToSociety: ms.DonationToSociety,
+		DonationToMuseum:  ms.DonationToMuseum,
+		Giftaid:           ms.Giftaid,
+	}
+	fields := []string{"DATE_LAST_PAID", "VALUE_OF_LAST_PAYMENT", "MEMBERS_AT_ADDRESS",
+		"NUMBER_OF_FRIENDS_OF_THE_MUSEUM_AT_THIS_ADDRESS", "FRIEND_OF_THE_MUSEUM",
+		"VALUE_OF_DONATION_TO_LDLHS", "VALUE_OF_DONATION_TO_THE_MUSEUM"}
+	if h.Conf.EnableGiftaid && ms.Giftaid {
+		fields = append(fields, "GIFT_AID")
 	}
-
-	// Update the user's donation to museum.
-	dmError := h.DB.SetDonationToMuseum(ms.UserID, ms.DonationToMuseum)
-	if dmError != nil {
-		e := fmt.Errorf("error setting donation to museum for %d - %v",
-			ms.UserID, dmError)
-		h.logError("%s: user ID %d - %v\n", fn, ms.UserID, e)
+	saveError := h.DB.SaveUserData(h.ctx, &ud, fields...)
+	if saveError != nil {
+		h.logError("%s: user ID %d - %v", fn, ms.UserID, saveError)
 	}
 
 	if h.Conf.EnableOtherMemberTypes && ms.AssocUserID > 0 {
-		// Associate members are enabled and there is one.  Set the Friend field in the
-		// associate member's record.
-		setFriendsError := h.DB.SetFriendField(ms.AssocUserID, ms.AssocFriend)
-		if setFriendsError != nil {
-			e := fmt.Errorf("error setting friend value for %d - %v", ms.AssocUserID, friendError)
-			h.logError("%s: user ID %d - %v\n", fn, ms.AssocUserID, e)
-		}
-
-		// Set the members at address in the associate member's record.
-		setMembersError := h.DB.SetMembersAtAddress(ms.AssocUserID, membersAtAddress)
-		if setMembersError != nil {
-			h.logError("%s: user ID %d - %v", fn, ms.AssocUserID, setMembersError)
+		// Associate members are enabled and there is one.  Set the friend field and
+		// the members and friends at the address in the associate member's record.
+		assoc := database.UserData{
+			UserID:           ms.AssocUserID,
+			MembersAtAddress: membersAtAddress,
+			FriendsAtAddress: friendsAtAddress,
+			Friend:           ms.AssocFriend,
 		}
-
-		// Set the members at address in the associate member's record.
-		safe := h.DB.SetFriendsAtAddress(ms.AssocUserID, friendsAtAddress)
-		if safe != nil {
-			h.logError("%s: user ID %d - %v", fn, ms.AssocUserID, setMembersError)
+		saveAssocError := h.DB.SaveUserData(h.ctx, &assoc, "MEMBERS_AT_ADDRESS",
+			"NUMBER_OF_FRIENDS_OF_THE_MUSEUM_AT_THIS_ADDRESS", "FRIEND_OF_THE_MUSEUM")
+		if saveAssocError != nil {
+			h.logError("%s: user ID %d - %v", fn, ms.AssocUserID, saveAssocError)
 		}
 	}
 }
@@ -1168,30 +1064,43 @@ func (h *Handler) fetchCurrentExtraDetails(ms *database.MembershipSale) {
 	// value to the returned zero value.
 
 	const fn = "fetchCurrentExtraDetails"
-	ms.AddressLine1, _ = h.DB.GetAddressLine1(ms.UserID)
-	ms.AddressLine2, _ = h.DB.GetAddressLine2(ms.UserID)
-	ms.AddressLine3, _ = h.DB.GetAddressLine3(ms.UserID)
-	ms.Town, _ = h.DB.GetTown(ms.UserID)
-	ms.County, _ = h.DB.GetCounty(ms.UserID)
-	ms.Postcode, _ = h.DB.GetPostcode(ms.UserID)
-	ms.CountryCode, _ = h.DB.GetCountryCode(ms.UserID)
-	ms.Phone, _ = h.DB.GetPhone(ms.UserID)
-	ms.Mobile, _ = h.DB.GetMobile(ms.UserID)
-	ms.LocationOfInterest, _ = h.DB.GetLocationOfInterest(ms.UserID)
-	moi, _ := h.DB.GetMembersOtherInterests(ms.UserID)
-	if moi != nil {
-		ms.OtherTopicsOfInterest = moi.Interests
-	}
 
+	// Fetch the details of both members in one go.
+	userIDs := []int64{ms.UserID}
 	if ms.AssocUserID > 0 {
-		// There is an associate member.  They have their own mobile number.
-		ms.AssocMobile, _ = h.DB.GetMobile(ms.AssocUserID)
+		userIDs = append(userIDs, ms.AssocUserID)
+	}
+	details, detailsError := h.DB.GetUserData(h.ctx, userIDs...)
+	if detailsError != nil {
+		h.logError("%s: error fetching details - %v", fn, detailsError)
+	} else {
+		ud := details[ms.UserID]
+		ms.AddressLine1 = ud.AddressLine1
+		ms.AddressLine2 = ud.AddressLine2
+		ms.AddressLine3 = ud.AddressLine3
+		ms.Town = ud.Town
+		ms.County = ud.County
+		ms.Postcode = ud.Postcode
+		ms.CountryCode = ud.CountryCode
+		ms.Phone = ud.Phone
+		ms.Mobile = ud.Mobile
+		ms.LocationOfInterest = ud.LocationOfInterest
+
+		if ms.AssocUserID > 0 {
+			// There is an associate member.  They have their own mobile number.
+			ms.AssocMobile = details[ms.AssocUserID].Mobile
+		}
+	}
+
+	moi, _ := h.DB.GetMembersOtherInterests(h.ctx, ms.UserID)
+	if moi != nil {
+		ms.OtherTopicsOfInterest = moi.Interests
 	}
 
 	// Get the interests (if any) that the member selected last time they renewed.
 	// These will be pre-selected in the selection list of interests that we about
 	// to display.
-	interests, ie := h.DB.GetMembersInterests(ms.UserID)
+	interests, ie := h.DB.GetMembersInterests(h.ctx, ms.UserID)
 	if ie != nil {
 		h.logError("%s: error fetching interests - %v", fn, ie)
 	} else {
@@ -1202,72 +1111,79 @@ func (h *Handler) fetchCurrentExtraDetails(ms *database.MembershipSale) {
 			ms.TopicsOfInterest[interest.InterestID] = nil
 		}
 	}
+
+	h.fetchExtraFields(ms)
 }
 
-// ExtraDetails is the handler for the /extradetails request.  After a successful
-// payment, the system displays a page to collect and update extra details such as
-// the user's address and phone number.  For a new member the details are initially
-// blank.  On a renewal the details are populated with the values from the database.
-// Mandatory request parameters: (ordinary member's) account_name, title, first_name,
-// last_name.
-// Optional request parameters: assoc_account_name, assoc_title, assoc_first_name,
-// assoc_last_name.
-func (h *Handler) ExtraDetails(w http.ResponseWriter, r *http.Request) {
+// getExtraFields gets the user fields in the extra fields category given by the
+// config, without their values.  If no category is configured, there are none.
+func (h *Handler) getExtraFields() ([]database.ExtraField, error) {
+	if len(h.Conf.ExtraFieldsCategory) == 0 {
+		return nil, nil
+	}
+	return h.DB.GetExtraFields(h.ctx, h.Conf.ExtraFieldsCategory)
+}
 
-	fn := "ExtraDetails"
-	h.Logger.Info(fn)
+// fetchExtraFields sets the fields from the extra fields category in the sale,
+// with the values that the user has already supplied.  On any error the fields
+// are left out of the forms.
+func (h *Handler) fetchExtraFields(ms *database.MembershipSale) {
+
+	const fn = "fetchExtraFields"
 
-	h.DB = database.New(h.DBConfig)
-	h.DB.Logger = h.Logger
-	connectionError := h.DB.Connect()
-	if connectionError != nil {
-		h.reportError(w, h.PrePaymentErrorHTML, connectionError)
+	fields, fieldsError := h.getExtraFields()
+	if fieldsError != nil {
+		h.logError("%s: %v", fn, fieldsError)
 		return
 	}
 
-	// Start a transaction, stored in the database object.
-	txError := h.DB.BeginTx()
-
-	if txError != nil {
-		h.reportError(w, h.PrePaymentErrorHTML, txError)
+	loadError := h.DB.LoadExtraFields(h.ctx, ms.UserID, fields)
+	if loadError != nil {
+		h.logError("%s: %v", fn, loadError)
 		return
 	}
 
-	// The transaction should be ether committed or rolled back before this function exits.
-	// In case it isn't, set up a deferred rollback now.  We choose a rollback rather than
-	// a commit because failure to close the transaction already is almost certainly caused
-	// by some sort of catastrophic error.
-	//
-	// When the function is returning, the transaction may have already been closed, in which
-	// case the second rollback may return an error, but that will be ignored.
-	defer h.DB.Rollback()
-	defer h.DB.Close()
+	ms.ExtraFields = fields
+}
 
-	// We figure out the start and end dates here to support unit testing of the ExtraDetailsHelper.
+// ExtraDetails is the handler for the /extradetails request.  After a successful
+// payment, the system displays a page to collect and update extra details such as
+// the user's address and phone number.  For a new member the details are initially
+// blank.  On a renewal the details are populated with the values from the database.
+// Mandatory request parameters: token - the signed token issued by the success
+// page, which identifies the sale and the member accounts.
+func (h *Handler) ExtraDetails(w http.ResponseWriter, r *http.Request) {
 
-	// The end date is the end of the calendar year.
-	now := time.Now().In(h.TZ)
-	paymentYear := database.GetMembershipYear(now)
+	fn := "ExtraDetails"
+	h.Logger.Info(fn)
 
-	err := h.ExtraDetailsHelper(w, r, paymentYear, now)
+	h.withRequest(r.Context(), w, h.PrePaymentErrorHTML, func(h *Handler) {
+		// We figure out the start and end dates here to support unit testing of the ExtraDetailsHelper.
 
-	if err != nil {
-		// Roll back the transaction (which should be done by the defer anyway).
-		ce := h.DB.Rollback()
-		if ce != nil {
-			h.logError("%s: %v", fn, ce)
-			w.Write([]byte(h.PrePaymentErrorHTML))
-			return
-		}
-	} else {
-		// Commit the changes.
-		ce := h.DB.Commit()
-		if ce != nil {
-			h.logError("%s: %v", fn, ce)
-			w.Write([]byte(h.PrePaymentErrorHTML))
-			return
+		// The end date is the end of the calendar year.
+		now := time.Now().In(h.TZ)
+		paymentYear := database.GetMembershipYear(now)
+
+		err := h.ExtraDetailsHelper(w, r, paymentYear, now)
+
+		if err != nil {
+			// Roll back the transaction (which withRequest would do anyway).
+			ce := h.DB.Rollback()
+			if ce != nil {
+				h.logError("%s: %v", fn, ce)
+				w.Write([]byte(h.PrePaymentErrorHTML))
+				return
+			}
+		} else {
+			// Commit the changes.
+			ce := h.DB.Commit()
+			if ce != nil {
+				h.logError("%s: %v", fn, ce)
+				w.Write([]byte(h.PrePaymentErrorHTML))
+				return
+			}
 		}
-	}
+	})
 }
 
 // ExtraDetailsHelper is a helper for ExtraDetails.  It collects the user's
@@ -1280,116 +1196,84 @@ func (h *Handler) ExtraDetailsHelper(w http.ResponseWriter, r *http.Request, pay
 
 	const fn = "ExtraDetailsHelper"
 
-	// The request contains the paying user's account name, the associate member's
-	// account if there is one and the extra details.  First check the account names
-	// by fetching the data for the accounts.
+	// The request contains a signed token issued by the success page, binding the
+	// sale to the accounts that it paid for, plus the extra details.  The token is
+	// the only thing that we trust to identify the accounts.  If it's missing,
+	// forged or out of date, somebody may be trying to pull a fast one, so stop
+	// the flow.
+	tokenStr := strings.TrimSpace(r.PostFormValue("token"))
+	saleToken, tokenError := h.Signer.CheckSaleToken(tokenStr, now)
+	if tokenError != nil {
+		h.logError("%s: rejecting token - %v", fn, tokenError)
+		w.Write([]byte(h.PostPaymentErrorHTML))
+		return tokenError
+	}
+
+	// The token says which sale it was issued for.  The sale must be complete
+	// and must still refer to the same accounts.
+	sale, saleError := h.DB.GetMembershipSale(h.ctx, saleToken.SaleID)
+	if saleError != nil {
+		h.logError("%s: sale %d - %v", fn, saleToken.SaleID, saleError)
+		w.Write([]byte(h.PostPaymentErrorHTML))
+		return saleError
+	}
 
-	// Get the record for the ordinary member.
-	accountName := strings.TrimSpace(r.PostFormValue("account_name"))
-	if len(accountName) <= 0 {
-		// The account name should be carried through the page flow automatically
-		// so this is fatal.
-		h.logError("%s: account_name not given", fn)
+	if sale.PaymentStatus != database.PaymentStatusComplete ||
+		sale.UserID != saleToken.UserID ||
+		sale.AssocUserID != saleToken.AssocUserID {
+
+		em := fmt.Sprintf("%s: token does not match sale %d", fn, sale.ID)
+		h.logError("%s", em)
 		w.Write([]byte(h.PostPaymentErrorHTML))
-		return errors.New("account_name not given")
+		return errors.New(em)
 	}
 
-	user, ue := h.DB.GetUserByLoginName(accountName)
+	// Get the record for the ordinary member.
+	user, ue := h.DB.GetUser(h.ctx, saleToken.UserID)
 	if ue != nil {
-		h.logError("%s: ordinary user account %s does not exist", fn, accountName)
+		h.logError("%s: ordinary user account %d does not exist", fn, saleToken.UserID)
 		w.Write([]byte(h.PostPaymentErrorHTML))
 		return ue
 	}
 
 	ms := database.NewMembershipSale(h.Conf)
+	ms.ID = sale.ID
+	ms.Token = tokenStr
+	ms.CSRFToken = csrf.Token(r)
+	ms.TransactionType = sale.TransactionType
 	ms.UserID = user.ID
-	ms.AccountName = accountName
+	ms.AccountName = user.LoginName
 	ms.MembershipYear = paymentYear
+	ms.Title = sale.Title
+	ms.FirstName = sale.FirstName
+	ms.LastName = sale.LastName
 
-	// Carried via hidden variables in the submitting page.
-	ms.Title = strings.TrimSpace(r.PostFormValue("title"))
-	ms.FirstName = strings.TrimSpace(r.PostFormValue("first_name"))
-	ms.LastName = strings.TrimSpace(r.PostFormValue("last_name"))
-
-	// The associate member is optional.  If one is specified, get their user record. If it turns
-	// out to be junk, stop the flow - somebody may be trying to pull a fast one.
+	// The associate member is optional.
 	var assocUser *database.User
-	assocAccountName := strings.TrimSpace(r.PostFormValue("assoc_account_name"))
-	if len(assocAccountName) > 0 {
+	if saleToken.AssocUserID > 0 {
 		var err error
-		assocUser, err = h.DB.GetUserByLoginName(assocAccountName)
+		assocUser, err = h.DB.GetUser(h.ctx, saleToken.AssocUserID)
 		if err != nil {
-			// The associate's account name is optional but if it's given, it should be
-			// carried through the page flow automatically, so this is fatal.
-			h.logError("%s: associate user account %s does not exist",
-				fn, assocAccountName)
+			h.logError("%s: associate user account %d does not exist",
+				fn, saleToken.AssocUserID)
 			w.Write([]byte(h.PostPaymentErrorHTML))
 			return err
 		}
 
 		ms.AssocUserID = assocUser.ID
-		ms.AssocAccountName = assocAccountName
-
-		// These are carried via hidden variables in the submitting page.
-		ms.AssocTitle = strings.TrimSpace(r.PostFormValue("assoc_title"))
-		ms.AssocFirstName = strings.TrimSpace(r.PostFormValue("assoc_first_name"))
-		ms.AssocLastName = strings.TrimSpace(r.PostFormValue("assoc_last_name"))
+		ms.AssocAccountName = assocUser.LoginName
+		ms.AssocTitle = sale.AssocTitle
+		ms.AssocFirstName = sale.AssocFirstName
+		ms.AssocLastName = sale.AssocLastName
 	}
 
 	// Get the incoming form data.
-
-	// Don't assume that the user has filled in the address boxes in order starting at
-	// address line 1.  Go through them one by one and store the non-empty lines.
-	addrLine := make([]string, 0, 3)
-	a := strings.TrimSpace(r.PostFormValue("address_line_1"))
-	if len(a) > 0 {
-		addrLine = append(addrLine, a)
-	}
-	a = strings.TrimSpace(r.PostFormValue("address_line_2"))
-	if len(a) > 0 {
-		addrLine = append(addrLine, a)
-	}
-	a = strings.TrimSpace(r.PostFormValue("address_line_3"))
-	if len(a) > 0 {
-		addrLine = append(addrLine, a)
-	}
-
-	// Read the address lines back and store them.
-	for i, l := range addrLine {
-		switch i {
-		case 0:
-			ms.AddressLine1 = l
-		case 1:
-			ms.AddressLine2 = l
-		case 2:
-			ms.AddressLine3 = l
-		}
-	}
-
-	ms.Town = strings.TrimSpace(r.PostFormValue("town"))
-	ms.County = strings.TrimSpace(r.PostFormValue("county"))
-	ms.Postcode = strings.TrimSpace(r.PostFormValue("postcode"))
-	// CountryCode is three letters, eg "GBR"
-	ms.CountryCode = strings.TrimSpace(r.PostFormValue("country_code"))
-
-	// Country code 0 is the heading "Select your country" - ignore.
-	if len(ms.CountryCode) > 0 && ms.CountryCode != "0" {
-		ct, ce := h.DB.GetCountryByCode(ms.CountryCode)
-		if ce != nil {
-			h.logError("%s: error getting country code - %v", fn, ce)
-			w.Write([]byte(h.PostPaymentErrorHTML))
-			return ce
-		}
-		ms.Country = ct.Name
-	}
-
-	ms.LocationOfInterest = strings.TrimSpace(r.PostFormValue("location_of_interest"))
-	ms.Phone = strings.TrimSpace(r.PostFormValue("phone"))
-	ms.Mobile = strings.TrimSpace(r.PostFormValue("mobile"))
-	if assocUser != nil {
-		ms.AssocMobile = strings.TrimSpace(r.PostFormValue("assoc_mobile"))
+	readError := h.readExtraDetails(r, ms, assocUser != nil)
+	if readError != nil {
+		h.logError("%s: %v", fn, readError)
+		w.Write([]byte(h.PostPaymentErrorHTML))
+		return readError
 	}
-	ms.OtherTopicsOfInterest = strings.TrimSpace(r.PostFormValue("other_topics_of_interest"))
 
 	// This is displayed on the success page.  In test, the result will depend on when the
 	// test is run, so don't check it!
@@ -1405,33 +1289,13 @@ func (h *Handler) ExtraDetailsHelper(w http.ResponseWriter, r *http.Request, pay
 	//
 	// If the user follows the expeted page flow, the interest IDs should be valid.  If not,
 	// something is badly wrong or somebody might be trying to pull a fast one.  Stop the flow.
-	iList, ie := h.DB.GetInterests()
+	iList, ie := h.DB.GetInterests(h.ctx)
 	if ie != nil {
 		h.logError("%s: error fetching interests - %v", fn, ie)
 		w.Write([]byte(h.PostPaymentErrorHTML))
 		return ie
 	}
 
-	// Get the list of given interests.  This is used on invalid input to annotate the
-	// selection list.  We get the parameter values as strings but we check that they are
-	// valid int64 values to guard against funny business by the user.
-	ms.TopicsOfInterest = make(map[int64]interface{}, 0)
-	for _, idStr := range r.PostForm["interest"] {
-		var id int64
-		n, err := fmt.Sscanf(idStr, "%d", &id)
-		if err != nil {
-			h.logError("%s: interest ID %s should be an integer", fn, idStr)
-			w.Write([]byte(h.PostPaymentErrorHTML))
-			return err
-		}
-		if n == 0 {
-			h.logError("%s: failed to convert %s to an integer interest ID", fn, idStr)
-			w.Write([]byte(h.PostPaymentErrorHTML))
-			return errors.New("")
-		}
-		ms.TopicsOfInterest[id] = nil
-	}
-
 	// This is used on valid input to create the adm_members_interest objects in the database.
 	interestList := make(map[int64]database.Interest)
 	for _, interest := range iList {
@@ -1452,7 +1316,7 @@ func (h *Handler) ExtraDetailsHelper(w http.ResponseWriter, r *http.Request, pay
 		// An existing user is renewing.  If they specified a country last time,
 		// put that at the top of the countries selection list, otherwise put
 		// the UK at the top.
-		ms.CountryCode, _ = h.DB.GetCountryCode(ms.UserID)
+		ms.CountryCode, _ = database.GetProfileField[string](h.ctx, h.DB, ms.UserID, "COUNTRY")
 		if len(ms.CountryCode) > 0 {
 			// The user has specified a country code.  (Presumably this is a
 			// membership renewal and they specified a country last time they paid.)
@@ -1482,8 +1346,9 @@ func (h *Handler) ExtraDetailsHelper(w http.ResponseWriter, r *http.Request, pay
 		// Get the HTML to collect the member's interests.
 		interestHTML := h.makeInterestSelectionHTML(ms)
 		// Create the response page.
-		extraDetailsPageTemplateString := successPageTemplateString1 + countriesHTML +
-			successPageTemplateString2 + interestHTML + successPageTemplateString3
+		extraDetailsPageTemplateString := successPageTemplateString1 +
+			profileRowsTemplate(countriesHTML, successAssocMobileRow) +
+			extraFieldRows + interestHTML + successPageTemplateString2
 
 		// Check and create the template.
 		extraDetailsPageTemplate, parseError :=
@@ -1507,7 +1372,7 @@ func (h *Handler) ExtraDetailsHelper(w http.ResponseWriter, r *http.Request, pay
 
 	// The extra details are valid.  Store them in the ordinary user's records.
 
-	saveUserError := h.DB.SaveExtraDetails(ms)
+	saveUserError := h.DB.SaveExtraDetails(h.ctx, ms)
 	if saveUserError != nil {
 		h.logError("%v", saveUserError)
 		w.Write([]byte(h.PrePaymentErrorHTML))
@@ -1527,7 +1392,7 @@ func (h *Handler) ExtraDetailsHelper(w http.ResponseWriter, r *http.Request, pay
 		msAssocUser.County = ms.County
 		msAssocUser.CountryCode = ms.CountryCode
 
-		saveAssocUserError := h.DB.SaveExtraDetails(msAssocUser)
+		saveAssocUserError := h.DB.SaveExtraDetails(h.ctx, msAssocUser)
 		if saveAssocUserError != nil {
 			h.logError("%v", saveAssocUserError)
 			w.Write([]byte(h.PrePaymentErrorHTML))
@@ -1556,6 +1421,86 @@ func (h *Handler) ExtraDetailsHelper(w http.ResponseWriter, r *http.Request, pay
 	return nil
 }
 
+// readExtraDetails reads the extra details (address etc) from the extra details
+// form into the given sale.  The associate's mobile number is only read if
+// withAssoc is true.  An error means that the form has been tampered with.
+func (h *Handler) readExtraDetails(r *http.Request, ms *database.MembershipSale, withAssoc bool) error {
+
+	// Read the fields that are shown on the form.
+	for i := range database.ProfileFields {
+		f := &database.ProfileFields[i]
+		value, _ := f.Sale(ms)
+		if value != nil {
+			*value = strings.TrimSpace(r.PostFormValue(f.FormName))
+		}
+	}
+
+	// Read the fields from the extra fields category, if any.
+	extraFields, extraFieldsError := h.getExtraFields()
+	if extraFieldsError != nil {
+		return extraFieldsError
+	}
+	for i := range extraFields {
+		extraFields[i].SetFromForm(r.PostFormValue(extraFields[i].FormName()))
+	}
+	ms.ExtraFields = extraFields
+
+	// Don't assume that the user has filled in the address boxes in order starting at
+	// address line 1.  Go through them one by one and store the non-empty lines.
+	addrLine := make([]string, 0, 3)
+	for _, a := range []string{ms.AddressLine1, ms.AddressLine2, ms.AddressLine3} {
+		if len(a) > 0 {
+			addrLine = append(addrLine, a)
+		}
+	}
+
+	// Read the address lines back and store them.
+	ms.AddressLine1, ms.AddressLine2, ms.AddressLine3 = "", "", ""
+	for i, l := range addrLine {
+		switch i {
+		case 0:
+			ms.AddressLine1 = l
+		case 1:
+			ms.AddressLine2 = l
+		case 2:
+			ms.AddressLine3 = l
+		}
+	}
+
+	// CountryCode is three letters, eg "GBR".  Country code 0 is the heading
+	// "Select your country" - ignore.
+	if len(ms.CountryCode) > 0 && ms.CountryCode != "0" {
+		ct, ce := h.DB.GetCountryByCode(h.ctx, ms.CountryCode)
+		if ce != nil {
+			return fmt.Errorf("error getting country code - %v", ce)
+		}
+		ms.Country = ct.Name
+	}
+
+	if withAssoc {
+		ms.AssocMobile = strings.TrimSpace(r.PostFormValue("assoc_mobile"))
+	}
+	ms.OtherTopicsOfInterest = strings.TrimSpace(r.PostFormValue("other_topics_of_interest"))
+
+	// Get the list of given interests.  This is used on invalid input to annotate the
+	// selection list.  We get the parameter values as strings but we check that they are
+	// valid int64 values to guard against funny business by the user.
+	ms.TopicsOfInterest = make(map[int64]interface{}, 0)
+	for _, idStr := range r.PostForm["interest"] {
+		var id int64
+		n, err := fmt.Sscanf(idStr, "%d", &id)
+		if err != nil {
+			return fmt.Errorf("interest ID %s should be an integer", idStr)
+		}
+		if n == 0 {
+			return fmt.Errorf("failed to convert %s to an integer interest ID", idStr)
+		}
+		ms.TopicsOfInterest[id] = nil
+	}
+
+	return nil
+}
+
 // validateExtraDetails is called by the /extradetails handler to validate extra details
 // (postal address etc) after a successful sale.
 func (h *Handler) validateExtraDetails(msUser *database.MembershipSale) bool {
@@ -1563,24 +1508,27 @@ func (h *Handler) validateExtraDetails(msUser *database.MembershipSale) bool {
 	// valid starts true and is set false on any validation error.
 	valid := true
 
-	if len(msUser.Phone) > 0 {
-		msUser.PhoneError = h.ValidatePhoneNumber(msUser.Phone)
-		if len(msUser.PhoneError) != 0 {
+	for i := range database.ProfileFields {
+		f := &database.ProfileFields[i]
+		value, errorMessage := f.Sale(msUser)
+		if f.Validate == nil || value == nil || len(*value) == 0 {
+			continue
+		}
+		*errorMessage = f.Validate(*value)
+		if len(*errorMessage) != 0 {
 			// Validation returned an error.
 			valid = false
 		}
 	}
 
-	if len(msUser.Mobile) > 0 {
-		msUser.AssocMobileError = h.ValidatePhoneNumber(msUser.Mobile)
-		if len(msUser.MobileError) != 0 {
-			// Validation returned an error.
+	for i := range msUser.ExtraFields {
+		if !msUser.ExtraFields[i].Validate() {
 			valid = false
 		}
 	}
 
 	if len(msUser.AssocMobile) > 0 {
-		msUser.AssocMobileError = h.ValidatePhoneNumber(msUser.AssocMobile)
+		msUser.AssocMobileError = database.ValidatePhoneNumber(msUser.AssocMobile)
 		if len(msUser.AssocMobileError) != 0 {
 			// Validation returned an error.
 			valid = false
@@ -1644,20 +1592,98 @@ func (h *Handler) Cancel(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte(cancelHTML))
 }
 
-func (h *Handler) connectToDB() error {
-	h.DB = database.New(h.DBConfig)
-	h.DB.Logger = h.Logger
-	connectError := h.DB.Connect()
-	if connectError != nil {
-		return connectError
+// forRequest borrows a transaction from the pool and returns a copy of the
+// handler for one request, holding the transaction in its DB field.  The
+// shared handler never holds a transaction, so concurrent requests can't
+// interfere with each other.  The copy's context is derived from the given
+// one (normally the request's) with the deadline set by db_timeout_seconds,
+// so the database work is abandoned if the browser goes away or the database
+// is too slow.  The caller should commit or roll back and then call release.
+//
+// The handlers don't call it directly but use withRequest.  From then on h
+// is the request's own copy of the handler, so everything that the request
+// does goes through its transaction.
+func (h *Handler) forRequest(ctx context.Context) (*Handler, error) {
+
+	rh := *h
+	rh.ctx, rh.cancel = context.WithTimeout(ctx, h.Conf.DBTimeout())
+
+	db, borrowError := h.Pool.Borrow(rh.ctx)
+	if borrowError != nil {
+		if reason := database.Interrupted(rh.ctx); len(reason) > 0 {
+			h.Logger.Warn("forRequest: request " + reason + " waiting for a database connection")
+		}
+		rh.cancel()
+		return nil, borrowError
 	}
+	db.Logger = h.Logger
+	rh.DB = db
 
-	txError := h.DB.BeginTx()
-	if txError != nil {
-		return txError
+	return &rh, nil
+}
+
+// withRequest borrows a transaction for one request and runs f with the
+// request's own copy of the handler (see forRequest).  If a transaction can't
+// be borrowed, it reports the error using the given error page and doesn't run
+// f.  f should commit or roll back.  In case it doesn't, the transaction is
+// rolled back afterwards - a rollback rather than a commit, because leaving
+// the transaction open is probably caused by some sort of catastrophic error.
+// If f has already finished the transaction, the error from the second
+// rollback is ignored.
+func (h *Handler) withRequest(ctx context.Context, w http.ResponseWriter, errorHTML string, f func(h *Handler)) {
+
+	rh, borrowError := h.forRequest(ctx)
+	if borrowError != nil {
+		h.reportError(w, errorHTML, borrowError)
+		return
 	}
 
-	return nil
+	defer rh.release()
+	defer rh.DB.Rollback()
+
+	f(rh)
+}
+
+// release closes the request's transaction, which hands the connection back
+// to the pool, and releases the request's context.
+func (h *Handler) release() {
+	h.DB.Close()
+	h.cancel()
+}
+
+// profileRowsTemplate gives the rows of the details form for the profile fields
+// that are shown on the form (see database.ProfileFields), in order, as Go HTML
+// template text that takes its data from a MembershipSale.  The given country
+// selection list is put in place of the country field and extraRows follows
+// the mobile number.
+func profileRowsTemplate(countriesHTML, extraRows string) string {
+	const rowFormat = `
+<tr>
+	<td style='border: 0'>%s</td>
+	<td style='border: 0'>
+		<input type='text' size='40' name='%s' value='{{.%s}}'>
+	</td>
+	<td style="color:red;">{{.%sError}}</td>
+</tr>
+`
+	var rows strings.Builder
+	for i := range database.ProfileFields {
+		f := &database.ProfileFields[i]
+		if len(f.FormName) == 0 {
+			continue
+		}
+		switch f.NameIntern {
+		case "COUNTRY":
+			rows.WriteString(countriesHTML)
+		default:
+			fmt.Fprintf(&rows, rowFormat, f.Label, f.FormName, f.SaleField, f.SaleField)
+		}
+		if f.NameIntern == "MOBILE" {
+			rows.WriteString(extraRows)
+		}
+	}
+
+	return rows.String()
 }
 
 // makeInterestSectionHTML creates and returns the HTML to collect a member's interests.
@@ -1684,7 +1710,7 @@ func (h *Handler) makeInterestSelectionHTML(ms *database.MembershipSale) string
 
 	fn := "makeInterestSectionHTML"
 
-	interests, ie := h.DB.GetInterests()
+	interests, ie := h.DB.GetInterests(h.ctx)
 	if ie != nil {
 		h.logError("%s:error getting interest list - %v", fn, ie)
 		return ""
@@ -1738,7 +1764,7 @@ func (h *Handler) makeInterestSelectionHTML(ms *database.MembershipSale) string
 
 func (hdlr *Handler) reportError(w http.ResponseWriter, errorHTML string, err error) {
 
-	hdlr.Logger.Error(err.Error())
+	hdlr.logError("%v", err)
 	w.Write([]byte(errorHTML))
 }
 
@@ -1770,9 +1796,11 @@ func (h *Handler) displaySaleForm(w io.Writer, form *forms.SaleForm) {
 
 // DisplayInitialSaleForm displays an empty payment form
 // with the mandatory parameters marked with asterisks.
-func (h *Handler) displayInitialSaleForm(w io.Writer, paymentYear int) {
+func (h *Handler) displayInitialSaleForm(w io.Writer, paymentYear int, csrfToken, formStarted string) {
 
 	form := forms.NewSaleForm(h.Conf, paymentYear)
+	form.CSRFToken = csrfToken
+	form.FormStarted = formStarted
 	form.MarkMandatoryFields()
 
 	paymentPageTemplate, tpError := template.New("paymentFormTemplate").
@@ -1800,6 +1828,15 @@ func (h *Handler) logMessage(pattern string, a ...any) {
 
 func (h *Handler) logError(pattern string, a ...any) {
 	str := fmt.Sprintf(pattern, a...)
+
+	// If the request was cancelled or ran out of time, the error is almost
+	// certainly caused by that rather than by a problem with the database or
+	// the SQL, so log it as a warning that says so.
+	if reason := database.Interrupted(h.ctx); len(reason) > 0 {
+		h.Logger.Warn("request " + reason + ": " + str)
+		return
+	}
+
 	h.Logger.Error(str)
 }
 
@@ -1808,7 +1845,7 @@ func (h *Handler) logError(pattern string, a ...any) {
 // pre-selected.
 func (h *Handler) MakeCountrySelectionListPreSelecting(codeOfPreSelectedCountry string) (string, error) {
 
-	countries, cse := h.DB.GetCountries()
+	countries, cse := h.DB.GetCountries(h.ctx)
 	if cse != nil {
 		return "", cse
 	}
@@ -1849,12 +1886,12 @@ func (h *Handler) MakeCountrySelectionListPreSelecting(codeOfPreSelectedCountry
 // given by the favoured code (eg "GBR" for the UK) appearing first in the list.
 func (h *Handler) MakeCountrySelectionListFavouring(firstCode string) (string, error) {
 
-	favouredCountry, ce := h.DB.GetCountryByCode(firstCode)
+	favouredCountry, ce := h.DB.GetCountryByCode(h.ctx, firstCode)
 	if ce != nil {
 		return "", ce
 	}
 	leaders := []database.Country{*favouredCountry}
-	countries, cse := h.DB.GetCountries()
+	countries, cse := h.DB.GetCountries(h.ctx)
 	if cse != nil {
 		return "", cse
 	}
@@ -2022,20 +2059,6 @@ func ValidateSaleForm(sf *forms.SaleForm) bool {
 	return sf.Valid
 }
 
-// Error message for an illegalphone number.  Factored out for unit testing.
-const IllegalPhoneNumber = "phone number must start with '+' or '0' and then must be all digits or spaces"
-
-// ValidatePhoneNumber checks a phone number.  It should start with "+"
-// or "0" and this should be followed by digits or spaces. For example
-// "+44 1234 567890" or "01234 567890" or "020 7123 4567".  If the number
-// is invalid, an error message is returned.
-func (h *Handler) ValidatePhoneNumber(phoneNumber string) string {
-	if !h.PhoneRegexp.Match([]byte(phoneNumber)) {
-		return IllegalPhoneNumber
-	}
-	return ""
-}
-
 // checkNonNegativeNumber checks a donation value - must be a valid float
 // and not negative.  Returns an empty error message and the donation
 // as a float64 OR an error message and 0.0.
@@ -2063,33 +2086,6 @@ func checkNonNegativeNumber(str string) (string, float64) {
 	return "", v
 }
 
-// usersExist checks if the users in the payment form are already in the system -
-// meaning that this is a membership renewal. It looks up the ordinary user in
-// the database with the name and/or email address given in the form to check that
-// they exists.  If the details of an associate member are given, it checks that
-// user too.  It returns the user IDs if both user(s) exist - (42,43,nil) or just
-// the ID of the ordinary user if there is no associate - (42,0, nil).  If there
-// is no match, it returns (0,0,nil).  If there is an error it returns (0,0,error).
-func usersExist(ms *database.MembershipSale, db *database.Database) (int64, int64, error) {
-
-	userID, userIDError := db.GetUserIDofMember(ms.FirstName, ms.LastName, ms.Email)
-	if userIDError != nil {
-		return 0, 0, userIDError
-	}
-
-	var assocUserID int64
-
-	if len(ms.AssocFirstName) > 0 {
-		var assocUserIDError error
-		assocUserID, assocUserIDError =
-			db.GetUserIDofMember(ms.AssocFirstName, ms.AssocLastName, ms.AssocEmail)
-		if assocUserIDError != nil {
-			return 0, 0, assocUserIDError
-		}
-	}
-	return userID, assocUserID, nil
-}
-
 // getTickBox returns true and "checked" if the tickbox is ticked ("on"),
 // false and "unchecked" otherwise.
 func getTickBox(value string) (bool, string, string) {
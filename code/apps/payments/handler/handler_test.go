@@ -3,12 +3,15 @@ package handler
 import (
 	"bytes"
 	"fmt"
+	"html/template"
 	"io"
 	"log/slog"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"regexp"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -20,11 +23,12 @@ import (
 	"github.com/goblimey/go-stripe-payments/code/pkg/config"
 	"github.com/goblimey/go-stripe-payments/code/pkg/database"
 	"github.com/goblimey/go-stripe-payments/code/pkg/forms"
+	"github.com/goblimey/go-stripe-payments/code/pkg/token"
 )
 
 // databaseList is a list of database types that will be used in
 // integration tests.
-var databaseList = []string{"postgres", "sqlite"}
+var databaseList = database.DatabaseListForTesting("postgres", "sqlite")
 
 type TestResponseWriter struct {
 	Body io.ReadCloser
@@ -62,6 +66,7 @@ var testConfig = config.Config{
 	EnableGiftaid:            true,
 	EmailAddressForQuestions: "a@b.com",
 	EmailAddressForFailures:  "c@d.com",
+	TokenSecret:              "secret",
 }
 
 func TestSuccess(t *testing.T) {
@@ -74,6 +79,7 @@ func TestSuccess(t *testing.T) {
 // and creates the data that the user has paid for - setting or updating the
 // membership end date and so on.
 func TestSetMemberDetails(t *testing.T) {
+	ctx := t.Context()
 
 	for _, dbType := range databaseList {
 
@@ -102,44 +108,44 @@ func TestSetMemberDetails(t *testing.T) {
 		endDate := time.Date(2024, time.December, 31, 23, 59, 59, 999999999, h.TZ)
 		startDate := time.Date(2024, time.July, 31, 10, 0, 0, 0, h.TZ)
 
-		roleMember, re := db.GetRole("Member")
+		roleMember, re := db.GetRole(ctx, "Member")
 		if re != nil {
 			// This should never fail.
 			t.Fatal(re)
 		}
 
 		// This should never fail so on any error, stop the test suite.
-		u1LoginName, ue1 := database.CreateUuid(db.Transaction, "usr_login_name", "adm_users")
+		u1LoginName, ue1 := database.CreateUuid(ctx, db.Transaction, "usr_login_name", "adm_users")
 		if ue1 != nil {
 			t.Fatal(ue1)
 		}
-		u2LoginName, uie2 := database.CreateUuid(db.Transaction, "usr_login_name", "adm_users")
+		u2LoginName, uie2 := database.CreateUuid(ctx, db.Transaction, "usr_login_name", "adm_users")
 		if uie2 != nil {
 			t.Fatal(uie2)
 		}
-		u3LoginName, ue3 := database.CreateUuid(db.Transaction, "usr_login_name", "adm_users")
+		u3LoginName, ue3 := database.CreateUuid(ctx, db.Transaction, "usr_login_name", "adm_users")
 		if ue3 != nil {
 			t.Fatal(ue3)
 		}
 		u4 := createTestUser(db, t)
 		m1 := database.NewMember(u4, roleMember, membershipStart, membershipEnd)
-		me1 := db.CreateMember(m1)
+		me1 := db.CreateMember(ctx, m1)
 		if me1 != nil {
 			t.Fatal(me1)
 		}
 		u5 := createTestUser(db, t)
 		m2 := database.NewMember(u5, roleMember, membershipStart, membershipEnd)
-		me2 := db.CreateMember(m2)
+		me2 := db.CreateMember(ctx, m2)
 		if me2 != nil {
 			t.Fatal(me2)
 		}
 		u6 := createTestUser(db, t)
 		m3 := database.NewMember(u6, roleMember, membershipStart, membershipEnd)
-		me3 := db.CreateMember(m3)
+		me3 := db.CreateMember(ctx, m3)
 		if me3 != nil {
 			t.Fatal(me3)
 		}
-		u7LoginName, ue7 := database.CreateUuid(db.Transaction, "usr_login_name", "adm_users")
+		u7LoginName, ue7 := database.CreateUuid(ctx, db.Transaction, "usr_login_name", "adm_users")
 		if ue7 != nil {
 			t.Fatal(ue7)
 		}
@@ -277,7 +283,7 @@ func TestSetMemberDetails(t *testing.T) {
 				AssocFriend:       td.assocFriend,
 			}
 
-			_, se := ms.Create(db)
+			_, se := ms.Create(ctx, db)
 			if se != nil {
 				t.Error(se)
 				continue
@@ -292,13 +298,13 @@ func TestSetMemberDetails(t *testing.T) {
 			// Check that the success helper has updated the membership end dates.
 			if td.transactionType == database.TransactionTypeNewMember {
 				var err error
-				td.omUser, err = db.GetUserByLoginName(td.omEmail)
+				td.omUser, err = db.GetUserByLoginName(ctx, td.omEmail)
 				if err != nil {
 					t.Errorf("%s: %v", dbType, err)
 				}
 			}
 
-			fetchedM1, me1 := db.GetMemberOfUser(td.omUser)
+			fetchedM1, me1 := db.GetMemberOfUser(ctx, td.omUser)
 			if me1 != nil {
 				t.Error(me1)
 				continue
@@ -309,14 +315,14 @@ func TestSetMemberDetails(t *testing.T) {
 			var fetchedM2 *database.Member
 			if len(td.wantAssocLoginName) > 0 {
 				var ue error
-				td.assocUser, ue = db.GetUserByLoginName(td.wantAssocLoginName)
+				td.assocUser, ue = db.GetUserByLoginName(ctx, td.wantAssocLoginName)
 				if ue != nil {
 					t.Errorf("%s: %v", dbType, ue)
 					continue
 				}
 
 				var me error
-				fetchedM2, me = db.GetMemberOfUser(td.assocUser)
+				fetchedM2, me = db.GetMemberOfUser(ctx, td.assocUser)
 				if me != nil {
 					t.Errorf("%s: %v", dbType, me)
 					continue
@@ -324,7 +330,7 @@ func TestSetMemberDetails(t *testing.T) {
 			}
 
 			// Check that the membership end date has been set.  (Note that date
-			// formats are different in Postgress from those in SQLite.)
+			// formats are different in Postgress, MySQL and SQLite.)
 			if dbType == "postgres" {
 				if fetchedM1.EndDate != "2025-12-31T00:00:00Z" {
 					t.Errorf("%s: expected end date 2025-12-31T00:00:00Z, got %s",
@@ -334,6 +340,15 @@ func TestSetMemberDetails(t *testing.T) {
 					t.Errorf("%s: expected end date 2025-12-31T00:00:00Z, got %s",
 						dbType, fetchedM2.EndDate)
 				}
+			} else if dbType == "mysql" {
+				if fetchedM1.EndDate != "2025-12-31" {
+					t.Errorf("%s: expected end date 2025-12-31, got %s",
+						dbType, fetchedM1.EndDate)
+				}
+				if fetchedM2 != nil && fetchedM2.EndDate != "2025-12-31" {
+					t.Errorf("%s: expected end date 2025-12-31, got %s",
+						dbType, fetchedM2.EndDate)
+				}
 			} else {
 				if fetchedM1.EndDate != "2025-12-31 23:59:59 999999 +00" {
 					t.Errorf("%s: expected end date 2025-12-31 23:59:59 999999 +00, got %s",
@@ -348,7 +363,7 @@ func TestSetMemberDetails(t *testing.T) {
 
 			// Check the fields in adm_user_data.
 
-			ttl, ttle := db.GetTitle(td.omUser.ID)
+			ttl, ttle := database.GetProfileField[string](ctx, db, td.omUser.ID, "SALUTATION")
 			if ttle != nil {
 				t.Errorf("%s %v", dbType, ttle)
 			}
@@ -357,7 +372,7 @@ func TestSetMemberDetails(t *testing.T) {
 				t.Errorf("%s: want %s got %s", dbType, td.omTitle, ttl)
 			}
 
-			fn, fne := db.GetFirstName(td.omUser.ID)
+			fn, fne := database.GetProfileField[string](ctx, db, td.omUser.ID, "FIRST_NAME")
 			if fne != nil {
 				t.Errorf("%s %v", dbType, fne)
 			}
@@ -366,7 +381,7 @@ func TestSetMemberDetails(t *testing.T) {
 				t.Errorf("%s: want %s got %s", dbType, td.omFirstName, fn)
 			}
 
-			ln, lne := db.GetLastName(td.omUser.ID)
+			ln, lne := database.GetProfileField[string](ctx, db, td.omUser.ID, "LAST_NAME")
 			if lne != nil {
 				t.Errorf("%s %v", dbType, lne)
 			}
@@ -375,7 +390,7 @@ func TestSetMemberDetails(t *testing.T) {
 				t.Errorf("%s: want %s got %s", dbType, td.omLastName, ln)
 			}
 
-			em, eme := db.GetEmail(td.omUser.ID)
+			em, eme := database.GetProfileField[string](ctx, db, td.omUser.ID, "EMAIL")
 			if eme != nil {
 				t.Errorf("%s %v", dbType, eme)
 			}
@@ -385,7 +400,7 @@ func TestSetMemberDetails(t *testing.T) {
 			}
 
 			// Only the ordinary member pays so only they should have Giftaid set.
-			g, ge := db.GetGiftaid(td.omUser.ID)
+			g, ge := database.GetProfileField[bool](ctx, db, td.omUser.ID, "GIFT_AID")
 			if ge != nil {
 				t.Errorf("%s %v", dbType, ge)
 			}
@@ -402,7 +417,7 @@ func TestSetMemberDetails(t *testing.T) {
 			// On a renewal, we leave the permissions as they are because the members may
 			// have revoked one or both of them.
 
-			emp, empe := db.GetReceiveEmailField(td.omUser.ID)
+			emp, empe := database.GetProfileField[bool](ctx, db, td.omUser.ID, "NOTICES_BY_EMAIL")
 			if empe != nil {
 				t.Errorf("%s %v", dbType, empe)
 			}
@@ -413,7 +428,7 @@ func TestSetMemberDetails(t *testing.T) {
 
 			if td.assocUser != nil {
 
-				attl, attle := db.GetTitle(td.assocUser.ID)
+				attl, attle := database.GetProfileField[string](ctx, db, td.assocUser.ID, "SALUTATION")
 				if attle != nil {
 					t.Errorf("%s %v", dbType, attle)
 				}
@@ -422,7 +437,7 @@ func TestSetMemberDetails(t *testing.T) {
 					t.Errorf("%s: want %s got %s", dbType, td.assocTitle, attl)
 				}
 
-				afn, afne := db.GetFirstName(td.assocUser.ID)
+				afn, afne := database.GetProfileField[string](ctx, db, td.assocUser.ID, "FIRST_NAME")
 				if afne != nil {
 					t.Errorf("%s %v", dbType, afne)
 				}
@@ -431,7 +446,7 @@ func TestSetMemberDetails(t *testing.T) {
 					t.Errorf("%s: want %s got %s", dbType, td.assocFirstName, afn)
 				}
 
-				aln, alne := db.GetLastName(td.assocUser.ID)
+				aln, alne := database.GetProfileField[string](ctx, db, td.assocUser.ID, "LAST_NAME")
 				if alne != nil {
 					t.Errorf("%s %v", dbType, alne)
 				}
@@ -440,7 +455,7 @@ func TestSetMemberDetails(t *testing.T) {
 					t.Errorf("%s: want %s got %s", dbType, aln, td.assocLastName)
 				}
 
-				aem, aeme := db.GetReceiveEmailField(td.assocUser.ID)
+				aem, aeme := database.GetProfileField[bool](ctx, db, td.assocUser.ID, "NOTICES_BY_EMAIL")
 				if aeme != nil {
 					t.Errorf("%s %v", dbType, aeme)
 				}
@@ -1304,329 +1319,121 @@ func TestSimpleValidation(t *testing.T) {
 	}
 }
 
-func TestUsersExistWithAssociate(t *testing.T) {
-	for _, dbType := range databaseList {
-		db, connError := database.OpenDBForTesting(dbType)
-
-		if connError != nil {
-			t.Error(connError)
-			return
-		}
-
-		db.BeginTx()
-
-		defer db.Rollback()
-		defer db.CloseAndDelete()
-
-		prepError := database.PrepareTestTables(db)
-		if prepError != nil {
-			t.Error(prepError)
-		}
-
-		oLN, ole := database.CreateUuid(db.Transaction, "usr_login_name", "adm_users")
-		if ole != nil {
-			t.Error(ole)
-		}
-
-		oFN, ofe := database.CreateUuid(db.Transaction, "usr_login_name", "adm_users")
-		if ofe != nil {
-			t.Error(ofe)
-		}
-
-		oEmail, oee := database.CreateUuid(db.Transaction, "usr_login_name", "adm_users")
-		if oee != nil {
-			t.Error(oee)
-		}
-
-		assocFN, afe := database.CreateUuid(db.Transaction, "usr_login_name", "adm_users")
-		if afe != nil {
-			t.Error(afe)
-		}
-
-		assocLN, ale := database.CreateUuid(db.Transaction, "usr_login_name", "adm_users")
-		if ale != nil {
-			t.Error(ale)
-		}
-
-		assocEmail, aee := database.CreateUuid(db.Transaction, "usr_login_name", "adm_users")
-		if aee != nil {
-			t.Error(aee)
-		}
-
-		// Create a structured logger that writes to the dailyLogWriter.
-		dailyLogWriter := dailylogger.New("..", "test.", ".log")
-		logger := slog.New(slog.NewTextHandler(dailyLogWriter, nil))
-		db.Logger = logger
-
-		// Create a handler.
-		h := Handler{DB: db, Logger: logger, Conf: &testConfig}
+// TestConcurrentSales completes many sales at once, each in its own request
+// with its own transaction borrowed from a shared pool.  Run it with the race
+// detector (go test -race) to check that the requests don't share state.  It
+// uses a file-based SQLite database, where the writers queue for the lock.
+func TestConcurrentSales(t *testing.T) {
+	ctx := t.Context()
 
-		sale := database.MembershipSale{
-			OrdinaryMemberFeePaid: 1.2, AssocFeePaid: 3.4, FriendFeePaid: 5.6, MembershipYear: 2024,
-			FirstName: oFN, LastName: oLN, Email: oEmail,
-			AssocFirstName: assocFN, AssocLastName: assocLN, AssocEmail: assocEmail,
-			UserID: 0, AssocUserID: 0,
-		}
-
-		startDate := time.Date(2026, time.January, 1, 0, 0, 0, 0, time.UTC)
-		endDate := time.Date(2026, time.December, 31, 23, 59, 59, 999999999, time.UTC)
-		now := time.Date(2026, time.February, 14, 12, 0, 0, 0, time.UTC)
-		paymentYear := 2026
-
-		// Test.
-
-		// Create the users.
-		cmError := h.setMemberDetails(&sale, startDate, endDate, now, paymentYear)
-		if cmError != nil {
-			t.Error(cmError)
-			return
-		}
-
-		// Add the extra details.
-		h.setAccountingRecordsForMembers(&sale, now)
-
-		ou, oue := h.DB.GetUserByLoginName(sale.Email)
-		if oue != nil {
-			t.Errorf("%s: %v", dbType, oue)
-		}
-
-		au, aue := h.DB.GetUserByLoginName(sale.AssocEmail)
-		if aue != nil {
-			t.Errorf("%s: %v", dbType, aue)
-		}
-
-		// The test - usersExist() should give back the userIDs of the two users.
-		fetchedOID, fetchedAID, lookupError := usersExist(&sale, db)
-		if lookupError != nil {
-			t.Error(lookupError)
-			return
-		}
-
-		if fetchedOID != ou.ID {
-			t.Errorf("want ID %d got %d", ou.ID, fetchedOID)
-		}
-		if fetchedAID != au.ID {
-			t.Errorf("want ID %d got %d", au.ID, fetchedAID)
-
-		}
+	const numberOfSales = 20
 
-		db.Rollback()
+	db, connError := database.ConnectForTesting("sqlite")
+	if connError != nil {
+		t.Fatal(connError)
 	}
-}
-
-// TestUsersExistWhenAssociateHasNoEmailAddress checks UsersExist when there is an
-// associate with no email address.  (The function must use the first and last name.)
-func TestUsersExistWhenAssociateHasNoEmailAddress(t *testing.T) {
-	for _, dbType := range databaseList {
-		db, connError := database.OpenDBForTesting(dbType)
-
-		if connError != nil {
-			t.Error(connError)
-			return
-		}
-
-		db.BeginTx()
-
-		defer db.Rollback()
-		defer db.CloseAndDelete()
-
-		prepError := database.PrepareTestTables(db)
-		if prepError != nil {
-			t.Error(prepError)
-		}
-
-		oLN, ole := database.CreateUuid(db.Transaction, "usr_login_name", "adm_users")
-		if ole != nil {
-			t.Error(ole)
-		}
-
-		oFN, ofe := database.CreateUuid(db.Transaction, "usr_login_name", "adm_users")
-		if ofe != nil {
-			t.Error(ofe)
-		}
-
-		oEmail, oee := database.CreateUuid(db.Transaction, "usr_login_name", "adm_users")
-		if oee != nil {
-			t.Error(oee)
-		}
-
-		assocFN, afe := database.CreateUuid(db.Transaction, "usr_login_name", "adm_users")
-		if afe != nil {
-			t.Error(afe)
-		}
-
-		assocLN, ale := database.CreateUuid(db.Transaction, "usr_login_name", "adm_users")
-		if ale != nil {
-			t.Error(ale)
-		}
-
-		wantAssocLoginName := assocFN + "." + assocLN
-
-		sale := database.MembershipSale{
-			OrdinaryMemberFeePaid: 1.2, AssocFeePaid: 3.4, FriendFeePaid: 5.6, MembershipYear: 2024,
-			FirstName: oFN, LastName: oLN, Email: oEmail,
-			AssocFirstName: assocFN, AssocLastName: assocLN,
-			UserID: 0, AssocUserID: 0,
-		}
-
-		// Create a structured logger that writes to the dailyLogWriter.
-		dailyLogWriter := dailylogger.New("..", "test.", ".log")
-		logger := slog.New(slog.NewTextHandler(dailyLogWriter, nil))
-		db.Logger = logger
-
-		// Create a handler.
-		h := Handler{DB: db, Logger: logger, Conf: &testConfig}
-
-		startDate := time.Date(2026, time.January, 1, 0, 0, 0, 0, time.UTC)
-		endDate := time.Date(2026, time.December, 31, 23, 59, 59, 999999999, time.UTC)
-		now := time.Date(2026, time.February, 14, 12, 0, 0, 0, time.UTC)
-		paymentYear := 2026
-
-		// Create an ordinary user and an associate.  The associate has no email address
-		// so the account name will be "firstname.lastname" and there will be first name
-		// and last name fields in adm_user_data.
-		cmError := h.setMemberDetails(&sale, startDate, endDate, now, paymentYear)
-		if cmError != nil {
-			t.Error(cmError)
-			return
-		}
-
-		// Add the extra details.
-		h.setAccountingRecordsForMembers(&sale, now)
-
-		// The test - usersExist() should give back the userIDs of the two users.
-		fetchedOID, fetchedAID, lookupError := usersExist(&sale, db)
-		if lookupError != nil {
-			t.Error(lookupError)
-			return
-		}
-
-		// Check.
-		ou, oue := h.DB.GetUserByLoginName(sale.Email)
-		if oue != nil {
-			t.Errorf("%s: %v", dbType, oue)
-		}
-
-		// Get the associate - they don't have an email address so the user name is
-		// "firstname.lastname".
-		au, aue := h.DB.GetUserByLoginName(sale.AssocFirstName + "." + sale.AssocLastName)
-		if aue != nil {
-			t.Errorf("%s: %v", dbType, aue)
-		}
-
-		if fetchedOID != ou.ID {
-			t.Errorf("want ID %d got %d", ou.ID, fetchedOID)
-			return
-		}
-		if fetchedAID != au.ID {
-			t.Errorf("want ID %d got %d", au.ID, fetchedAID)
-			return
-		}
-
-		assoc, fetchAssocError := db.GetUser(au.ID)
-		if fetchAssocError != nil {
-			t.Error(fetchAssocError)
-			return
-		}
+	defer db.CloseAndDelete()
 
-		if assoc.LoginName != wantAssocLoginName {
-			t.Errorf("want %s got %s", wantAssocLoginName, assoc.LoginName)
-			return
-		}
-		db.Rollback()
+	// Create the sales, one per new member, and commit them so that the
+	// requests can see them.
+	saleIDs := make([]int64, 0, numberOfSales)
+	for i := 0; i < numberOfSales; i++ {
+		ms := database.MembershipSale{
+			PaymentService:  "Stripe",
+			PaymentStatus:   database.PaymentStatusPending,
+			TransactionType: database.TransactionTypeNewMember,
+			MembershipYear:  2025,
+			FirstName:       "first",
+			LastName:        fmt.Sprintf("last%d", i),
+			Email:           fmt.Sprintf("concurrent%d@example.com", i),
+		}
+		id, createError := ms.Create(ctx, db)
+		if createError != nil {
+			t.Fatal(createError)
+		}
+		saleIDs = append(saleIDs, id)
 	}
-}
 
-func TestUsersExistWithNoAssociate(t *testing.T) {
-	for _, dbType := range databaseList {
-		db, connError := database.OpenDBForTesting(dbType)
-
-		if connError != nil {
-			t.Error(connError)
-			return
-		}
+	commitError := db.Commit()
+	if commitError != nil {
+		t.Fatal(commitError)
+	}
 
-		db.BeginTx()
+	h := New(&testConfig)
+	h.Pool = &database.Pool{Config: db.Config, Connection: db.Connection}
+	h.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	recorders := make([]*httptest.ResponseRecorder, numberOfSales)
+
+	var wg sync.WaitGroup
+	for i := range saleIDs {
+		recorders[i] = httptest.NewRecorder()
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			customer := stripe.Customer{ID: "cus", Email: "a@b.com"}
+			session := stripe.CheckoutSession{
+				PaymentStatus:     "paid",
+				ClientReferenceID: fmt.Sprintf("%d", saleIDs[i]),
+				Customer:          &customer,
+			}
+			h.completeSale(ctx, recorders[i], &session, "")
+		}(i)
+	}
+	wg.Wait()
 
-		defer db.Rollback()
-		defer db.CloseAndDelete()
+	// The shared handler never holds a transaction.
+	if h.DB != nil {
+		t.Error("want the shared handler to have no DB")
+	}
 
-		prepError := database.PrepareTestTables(db)
-		if prepError != nil {
-			t.Error(prepError)
-		}
+	check, borrowError := h.Pool.Borrow(ctx)
+	if borrowError != nil {
+		t.Fatal(borrowError)
+	}
+	defer check.Close()
 
-		oLN, ole := database.CreateUuid(db.Transaction, "usr_login_name", "adm_users")
-		if ole != nil {
-			t.Error(ole)
-		}
+	users := make(map[int64]bool)
+	for i, id := range saleIDs {
 
-		oFN, ofe := database.CreateUuid(db.Transaction, "usr_login_name", "adm_users")
-		if ofe != nil {
-			t.Error(ofe)
+		if strings.Contains(recorders[i].Body.String(), h.PostPaymentErrorHTML) {
+			t.Errorf("sale %d: got the error page", id)
 		}
 
-		oEmail, oee := database.CreateUuid(db.Transaction, "usr_login_name", "adm_users")
-		if oee != nil {
-			t.Error(oee)
+		ms, fetchError := check.GetMembershipSale(ctx, id)
+		if fetchError != nil {
+			t.Errorf("sale %d: %v", id, fetchError)
+			continue
 		}
 
-		sale := database.MembershipSale{
-			OrdinaryMemberFeePaid: 1.2, AssocFeePaid: 3.4, FriendFeePaid: 5.6, MembershipYear: 2024,
-			FirstName: oFN, LastName: oLN, Email: oEmail,
-			UserID: 0, AssocUserID: 0,
+		if ms.PaymentStatus != database.PaymentStatusComplete {
+			t.Errorf("sale %d: want status %s got %s", id, database.PaymentStatusComplete, ms.PaymentStatus)
 		}
 
-		// Create a structured logger that writes to the dailyLogWriter.
-		dailyLogWriter := dailylogger.New("..", "test.", ".log")
-		logger := slog.New(slog.NewTextHandler(dailyLogWriter, nil))
-		db.Logger = logger
-
-		// Create a handler.
-		h := Handler{DB: db, Logger: logger, Conf: &testConfig}
-
-		startDate := time.Date(2026, time.January, 1, 0, 0, 0, 0, time.UTC)
-		endDate := time.Date(2026, time.December, 31, 23, 59, 59, 999999999, time.UTC)
-		now := time.Date(2026, time.February, 14, 12, 0, 0, 0, time.UTC)
-		paymentYear := 2026
-
-		// Create the user with no associate..
-		cmError := h.setMemberDetails(&sale, startDate, endDate, now, paymentYear)
-		if cmError != nil {
-			t.Error(cmError)
-			return
+		if ms.UserID <= 0 {
+			t.Errorf("sale %d: want a user", id)
+			continue
 		}
 
-		// Add the extra details.
-		h.setAccountingRecordsForMembers(&sale, now)
-
-		ou, oue := h.DB.GetUserByLoginName(sale.Email)
-		if oue != nil {
-			t.Errorf("%s: %v", dbType, oue)
+		if users[ms.UserID] {
+			t.Errorf("sale %d: user %d belongs to another sale", id, ms.UserID)
 		}
+		users[ms.UserID] = true
 
-		// Test - usersExist() should give back the userIDs of the ordinary user
-		// but no associate.
-		fetchedOID, fetchedAID, lookupError := usersExist(&sale, db)
-		if lookupError != nil {
-			t.Error(lookupError)
-			return
+		user, userError := check.GetUser(ctx, ms.UserID)
+		if userError != nil {
+			t.Errorf("sale %d: %v", id, userError)
+			continue
 		}
 
-		if fetchedOID != ou.ID {
-			t.Errorf("want ID %d got %d", ou.ID, fetchedOID)
-			return
-		}
-		if fetchedAID != 0 {
-			t.Errorf("want ID 0 (no associate) got %d", fetchedAID)
-			return
+		if user.LoginName != ms.Email {
+			t.Errorf("sale %d: want login name %s got %s", id, ms.Email, user.LoginName)
 		}
-
-		db.Rollback()
 	}
 }
 
 func TestGetMembershipSaleOnSuccess(t *testing.T) {
+	ctx := t.Context()
 
 	for _, dbType := range databaseList {
 
@@ -1649,7 +1456,7 @@ func TestGetMembershipSaleOnSuccess(t *testing.T) {
 		h.DB = db
 		h.Logger = logger
 
-		roleMember, re := db.GetRole("Member")
+		roleMember, re := db.GetRole(ctx, "Member")
 		if re != nil {
 			// This should never fail.
 			t.Fatal(re)
@@ -1659,10 +1466,10 @@ func TestGetMembershipSaleOnSuccess(t *testing.T) {
 		membershipStart := time.Date(2024, time.January, 1, 0, 0, 0, 0, h.TZ)
 		membershipEnd := time.Date(2024, time.December, 31, 23, 59, 59, 999999999, h.TZ)
 		m1 := database.NewMember(u1, roleMember, membershipStart, membershipEnd)
-		db.CreateMember(m1)
+		db.CreateMember(ctx, m1)
 		u2 := createTestUser(db, t)
 		m2 := database.NewMember(u2, roleMember, membershipStart, membershipEnd)
-		db.CreateMember(m2)
+		db.CreateMember(ctx, m2)
 
 		ms := database.MembershipSale{
 			PaymentStatus:  database.PaymentStatusPending,
@@ -1682,7 +1489,7 @@ func TestGetMembershipSaleOnSuccess(t *testing.T) {
 			CountryCode:    "ABW",
 		}
 
-		id, se := ms.Create(db)
+		id, se := ms.Create(ctx, db)
 		if se != nil {
 			t.Error(se)
 			continue
@@ -1714,6 +1521,7 @@ func TestGetMembershipSaleOnSuccess(t *testing.T) {
 
 // TestSetAccountingRecordsForMembers checks setAccountingRecordsForMembers.
 func TestSetAccountingRecordsForMembers(t *testing.T) {
+	ctx := t.Context()
 
 	for _, dbType := range databaseList {
 
@@ -1736,7 +1544,7 @@ func TestSetAccountingRecordsForMembers(t *testing.T) {
 		h.DB = db
 		h.Logger = logger
 
-		roleMember, re := db.GetRole("Member")
+		roleMember, re := db.GetRole(ctx, "Member")
 		if re != nil {
 			// This should never fail.
 			t.Fatal(re)
@@ -1748,11 +1556,11 @@ func TestSetAccountingRecordsForMembers(t *testing.T) {
 		paymentDate := time.Date(2024, time.February, 14, 12, 0, 0, 0, h.TZ)
 
 		m1 := database.NewMember(u, roleMember, membershipStart, membershipEnd)
-		db.CreateMember(m1)
+		db.CreateMember(ctx, m1)
 
 		assocU := createTestUser(db, t)
 		m2 := database.NewMember(assocU, roleMember, membershipStart, membershipEnd)
-		db.CreateMember(m2)
+		db.CreateMember(ctx, m2)
 
 		ms := database.MembershipSale{
 			PaymentStatus:     database.PaymentStatusPending,
@@ -1775,7 +1583,7 @@ func TestSetAccountingRecordsForMembers(t *testing.T) {
 			AssocFriend:       false,
 		}
 
-		_, se := ms.Create(db)
+		_, se := ms.Create(ctx, db)
 		if se != nil {
 			t.Error(se)
 			continue
@@ -1798,7 +1606,7 @@ func TestSetAccountingRecordsForMembers(t *testing.T) {
 
 		// The ordinary member paid, the assocaite member didn't, so only the
 		// ordinary member has this feld set.
-		dlp, dlpError := db.GetDateLastPaid(u.ID)
+		dlp, dlpError := database.GetProfileField[string](ctx, db, u.ID, "DATE_LAST_PAID")
 		if dlpError != nil {
 			t.Error(dbType + ": " + dlpError.Error())
 		}
@@ -1807,7 +1615,7 @@ func TestSetAccountingRecordsForMembers(t *testing.T) {
 				dbType, wantDateLastPaid, dlp)
 		}
 
-		maa1, maae1 := db.GetMembersAtAddress(u.ID)
+		maa1, maae1 := database.GetProfileField[int](ctx, db, u.ID, "MEMBERS_AT_ADDRESS")
 		if maae1 != nil {
 			t.Error(maae1)
 			continue
@@ -1817,7 +1625,7 @@ func TestSetAccountingRecordsForMembers(t *testing.T) {
 			t.Errorf("want 2 got %d", maa1)
 		}
 
-		maa2, maae2 := db.GetMembersAtAddress(assocU.ID)
+		maa2, maae2 := database.GetProfileField[int](ctx, db, assocU.ID, "MEMBERS_AT_ADDRESS")
 		if maae2 != nil {
 			t.Error(maae2)
 			continue
@@ -1827,7 +1635,7 @@ func TestSetAccountingRecordsForMembers(t *testing.T) {
 			t.Errorf("%s: want 2 got %d", dbType, maa2)
 		}
 
-		faa1, faae1 := db.GetFriendsAtAddress(u.ID)
+		faa1, faae1 := database.GetProfileField[int](ctx, db, u.ID, "NUMBER_OF_FRIENDS_OF_THE_MUSEUM_AT_THIS_ADDRESS")
 		if faae1 != nil {
 			t.Error(faae1)
 			continue
@@ -1837,7 +1645,7 @@ func TestSetAccountingRecordsForMembers(t *testing.T) {
 			t.Errorf("want 1 got %d", faa1)
 		}
 
-		faa2, faae2 := db.GetFriendsAtAddress(assocU.ID)
+		faa2, faae2 := database.GetProfileField[int](ctx, db, assocU.ID, "NUMBER_OF_FRIENDS_OF_THE_MUSEUM_AT_THIS_ADDRESS")
 		if faae2 != nil {
 			t.Error(faae2)
 			continue
@@ -1847,7 +1655,7 @@ func TestSetAccountingRecordsForMembers(t *testing.T) {
 			t.Errorf("%s want 1 got %d", dbType, faa2)
 		}
 
-		giftaid, gaError := db.GetGiftaid(u.ID)
+		giftaid, gaError := database.GetProfileField[bool](ctx, db, u.ID, "GIFT_AID")
 		if gaError != nil {
 			t.Error(dbType + " " + gaError.Error())
 		}
@@ -1856,7 +1664,7 @@ func TestSetAccountingRecordsForMembers(t *testing.T) {
 			t.Errorf("%s: want giftaid tickbox set", dbType)
 		}
 
-		dts, dtsError := db.GetDonationToMuseum(u.ID)
+		dts, dtsError := database.GetProfileField[float64](ctx, db, u.ID, "VALUE_OF_DONATION_TO_THE_MUSEUM")
 		if dtsError != nil {
 			t.Error(dbType + " " + dtsError.Error())
 		}
@@ -1865,7 +1673,7 @@ func TestSetAccountingRecordsForMembers(t *testing.T) {
 			t.Errorf("%s: want 2.2 got %f", dbType, dts)
 		}
 
-		dtm, dtmError := db.GetDonationToMuseum(u.ID)
+		dtm, dtmError := database.GetProfileField[float64](ctx, db, u.ID, "VALUE_OF_DONATION_TO_THE_MUSEUM")
 		if dtmError != nil {
 			t.Error(dtmError)
 			continue
@@ -1954,7 +1762,7 @@ func TestSetAccountingRecordsForMembers(t *testing.T) {
 
 // 		now := time.Date(2024, time.October, 1, 0, 0, 0, 0, london)
 // 		endDate := time.Date(2024, time.December, 31, 23, 59, 59, 999999999, london)
-// 		h.successHelper(w, &session, now, endDate, now, 2024)
+// 		h.successHelper(w, &session, now, endDate, now, 2024, "")
 
 // 		// Check that the success helper has updated the membership end dates.
 // 		fetchedM1, me1 := db.GetMemberOfUser(u1)
@@ -2034,6 +1842,8 @@ func TestSetAccountingRecordsForMembers(t *testing.T) {
 
 // TestExtraDetailsHelper tests the extra details helper.
 func TestExtraDetailsHelper(t *testing.T) {
+	ctx := t.Context()
+
 	for _, dbType := range databaseList {
 
 		db, connError := database.ConnectForTesting(dbType)
@@ -2050,18 +1860,46 @@ func TestExtraDetailsHelper(t *testing.T) {
 		u2 := createTestUser(db, t)
 
 		// Get the interests.  If there are any, assign the first one to u1.
-		interests, ie := db.GetInterests()
+		interests, ie := db.GetInterests(ctx)
 		if ie == nil {
 			if len(interests) > 0 {
 				interest := database.NewMembersInterest(u1.ID, interests[0].ID)
-				db.CreateMembersInterest(interest)
+				db.CreateMembersInterest(ctx, interest)
 			}
 		}
 
+		// The sale that paid for the two accounts.
+		sale := database.MembershipSale{
+			PaymentStatus:  database.PaymentStatusComplete,
+			MembershipYear: 2024,
+			UserID:         u1.ID,
+			Title:          "Mr",
+			FirstName:      "a",
+			LastName:       "b",
+			Email:          u1.LoginName,
+			AssocUserID:    u2.ID,
+			AssocFirstName: "c",
+			AssocLastName:  "d",
+			AssocEmail:     u2.LoginName,
+		}
+		saleID, se := sale.Create(ctx, db)
+		if se != nil {
+			t.Error(se)
+			continue
+		}
+
+		now := time.Now()
+
+		h := New(&testConfig)
+		saleToken := token.SaleToken{
+			SaleID:      saleID,
+			UserID:      u1.ID,
+			AssocUserID: u2.ID,
+			Expires:     now.Add(time.Hour),
+		}
+
 		values := make(url.Values, 0)
-		values.Add("title", "Mr")
-		values.Add("account_name", u1.LoginName)
-		values.Add("assoc_account_name", u2.LoginName)
+		values.Add("token", h.Signer.IssueSaleToken(&saleToken))
 		values.Add("phone", "01")
 		values.Add("mobile", "+44 1")
 		values.Add("address_line_2", "Flat 3")
@@ -2086,12 +1924,11 @@ func TestExtraDetailsHelper(t *testing.T) {
 		// Create a structured logger that writes to the dailyLogWriter.
 		logger := slog.New(slog.NewTextHandler(dailyLogWriter, nil))
 		db.Logger = logger
-		h := New(&testConfig)
 		h.DB = db
 		h.Logger = logger
 
 		// Run the test.
-		edhe := h.ExtraDetailsHelper(w, &r, 2024, time.Now())
+		edhe := h.ExtraDetailsHelper(w, &r, 2024, now)
 		if edhe != nil {
 			t.Error(edhe)
 			continue
@@ -2100,7 +1937,7 @@ func TestExtraDetailsHelper(t *testing.T) {
 		// Check the results
 
 		// ordinary user's address line 1.
-		u1a1, u1a1e := db.GetAddressLine1(u1.ID)
+		u1a1, u1a1e := database.GetProfileField[string](ctx, db, u1.ID, "STREET")
 		if u1a1e != nil {
 			t.Errorf("%s: %v", dbType, u1a1e)
 			continue
@@ -2113,7 +1950,7 @@ func TestExtraDetailsHelper(t *testing.T) {
 		}
 
 		// Associate user's address line 1.
-		u2a1, u2a1e := db.GetAddressLine1(u2.ID)
+		u2a1, u2a1e := database.GetProfileField[string](ctx, db, u2.ID, "STREET")
 		if u2a1e != nil {
 			t.Error(u2a1e)
 		}
@@ -2123,7 +1960,7 @@ func TestExtraDetailsHelper(t *testing.T) {
 		}
 
 		// Ordinary user's address line 2.
-		u1a2, u1a2e := db.GetAddressLine2(u1.ID)
+		u1a2, u1a2e := database.GetProfileField[string](ctx, db, u1.ID, "ADDRESS_LINE_2")
 		if u1a2e != nil {
 			t.Error(u1a2e)
 		}
@@ -2133,7 +1970,7 @@ func TestExtraDetailsHelper(t *testing.T) {
 		}
 
 		// Associate uesr's address line 2.
-		u2a2, u2a2e := db.GetAddressLine2(u2.ID)
+		u2a2, u2a2e := database.GetProfileField[string](ctx, db, u2.ID, "ADDRESS_LINE_2")
 		if u2a2e != nil {
 			t.Error(u2a2e)
 		}
@@ -2143,7 +1980,7 @@ func TestExtraDetailsHelper(t *testing.T) {
 		}
 
 		// Ordinary user's town.
-		u1t, u1te := db.GetTown(u1.ID)
+		u1t, u1te := database.GetProfileField[string](ctx, db, u1.ID, "CITY")
 		if u1te != nil {
 			t.Error(u1te)
 		}
@@ -2153,7 +1990,7 @@ func TestExtraDetailsHelper(t *testing.T) {
 		}
 
 		// Associate users town.
-		u2t, u2te := db.GetTown(u2.ID)
+		u2t, u2te := database.GetProfileField[string](ctx, db, u2.ID, "CITY")
 		if u2te != nil {
 			t.Error(u2te)
 		}
@@ -2163,7 +2000,7 @@ func TestExtraDetailsHelper(t *testing.T) {
 		}
 
 		// Ordinary user's county.
-		u1ct, u1cte := db.GetCounty(u1.ID)
+		u1ct, u1cte := database.GetProfileField[string](ctx, db, u1.ID, "COUNTY")
 		if u1cte != nil {
 			t.Error(u1cte)
 		}
@@ -2173,7 +2010,7 @@ func TestExtraDetailsHelper(t *testing.T) {
 		}
 
 		// Associate uset's county.
-		u2ct, u2cte := db.GetCountry(u2.ID)
+		u2ct, u2cte := database.GetProfileField[string](ctx, db, u2.ID, "COUNTRY")
 		if u2cte != nil {
 			t.Error(u2cte)
 		}
@@ -2183,7 +2020,7 @@ func TestExtraDetailsHelper(t *testing.T) {
 		}
 
 		// Ordinary user's country code.
-		u1cnt, u1cnte := db.GetCountryCode(u1.ID)
+		u1cnt, u1cnte := database.GetProfileField[string](ctx, db, u1.ID, "COUNTRY")
 		if u1cnte != nil {
 			t.Error(u1cnte)
 		}
@@ -2193,7 +2030,7 @@ func TestExtraDetailsHelper(t *testing.T) {
 		}
 
 		// Associate user's country_code.
-		u2cnt, u2pc1e := db.GetCountryCode(u2.ID)
+		u2cnt, u2pc1e := database.GetProfileField[string](ctx, db, u2.ID, "COUNTRY")
 		if u2pc1e != nil {
 			t.Error(u2pc1e)
 		}
@@ -2203,7 +2040,7 @@ func TestExtraDetailsHelper(t *testing.T) {
 		}
 
 		// Ordinary user's postcode.
-		u1pc1, u1pc1e := db.GetPostcode(u1.ID)
+		u1pc1, u1pc1e := database.GetProfileField[string](ctx, db, u1.ID, "POSTCODE")
 		if u1pc1e != nil {
 			t.Error(u1pc1e)
 		}
@@ -2213,7 +2050,7 @@ func TestExtraDetailsHelper(t *testing.T) {
 		}
 
 		// Associate user's postcode.
-		u2pc1, u2pc1e := db.GetPostcode(u2.ID)
+		u2pc1, u2pc1e := database.GetProfileField[string](ctx, db, u2.ID, "POSTCODE")
 		if u2pc1e != nil {
 			t.Error(u2pc1e)
 		}
@@ -2223,7 +2060,7 @@ func TestExtraDetailsHelper(t *testing.T) {
 		}
 
 		// Ordinary user's phone number.
-		ph, phe := db.GetPhone(u1.ID)
+		ph, phe := database.GetProfileField[string](ctx, db, u1.ID, "PHONE")
 		if phe != nil {
 			t.Error(phe)
 		}
@@ -2233,7 +2070,7 @@ func TestExtraDetailsHelper(t *testing.T) {
 		}
 
 		// Associate user's phone number.  (Should be the same as the user's number.)
-		aph, aphe := db.GetPhone(u1.ID)
+		aph, aphe := database.GetProfileField[string](ctx, db, u1.ID, "PHONE")
 		if aphe != nil {
 			t.Error(phe)
 		}
@@ -2243,7 +2080,7 @@ func TestExtraDetailsHelper(t *testing.T) {
 		}
 
 		// Ordinary user's mobile number.
-		m, me := db.GetMobile(u1.ID)
+		m, me := database.GetProfileField[string](ctx, db, u1.ID, "MOBILE")
 		if me != nil {
 			t.Error(me)
 		}
@@ -2254,7 +2091,7 @@ func TestExtraDetailsHelper(t *testing.T) {
 		}
 
 		// The associate's mobile number.
-		am, ame := db.GetMobile(u2.ID)
+		am, ame := database.GetProfileField[string](ctx, db, u2.ID, "MOBILE")
 		if ame != nil {
 			t.Error(ame)
 		}
@@ -2265,7 +2102,7 @@ func TestExtraDetailsHelper(t *testing.T) {
 		}
 
 		// Ordinary user's other interests.
-		moi, oie := db.GetMembersOtherInterests(u1.ID)
+		moi, oie := db.GetMembersOtherInterests(ctx, u1.ID)
 		if oie != nil {
 			t.Error(oie)
 		}
@@ -2283,7 +2120,7 @@ func TestExtraDetailsHelper(t *testing.T) {
 		// Ordinary user's selected interests.  The adm_interests table contains
 		// the interests - {id, name}.  The supplied interest values should be ids
 		// from that table.
-		iList, ie := db.GetInterests()
+		iList, ie := db.GetInterests(ctx)
 		if ie != nil {
 			t.Error(ie)
 			break
@@ -2293,7 +2130,7 @@ func TestExtraDetailsHelper(t *testing.T) {
 		for _, interest := range iList {
 			interestList[interest.ID] = interest
 		}
-		nt, nError := db.GetMembersInterests(u1.ID)
+		nt, nError := db.GetMembersInterests(ctx, u1.ID)
 		if nError != nil {
 			t.Error(nError)
 		}
@@ -2330,8 +2167,55 @@ func TestExtraDetailsHelper(t *testing.T) {
 	}
 }
 
+// TestExtraDetailsHelperRejectsBadToken checks that the extra details helper
+// refuses a request with a missing, forged or expired token.  It fails before
+// touching the database so no database is needed.
+func TestExtraDetailsHelperRejectsBadToken(t *testing.T) {
+
+	now := time.Date(2025, time.March, 1, 12, 0, 0, 0, time.UTC)
+
+	h := New(&testConfig)
+	h.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	saleToken := token.SaleToken{SaleID: 1, UserID: 2, Expires: now.Add(-time.Minute)}
+	expired := h.Signer.IssueSaleToken(&saleToken)
+	saleToken.Expires = now.Add(time.Hour)
+	forged := token.NewSigner("not the secret").IssueSaleToken(&saleToken)
+
+	var testData = []struct {
+		description string
+		token       string
+		want        error
+	}{
+		{"missing", "", token.ErrInvalid},
+		{"forged", forged, token.ErrInvalid},
+		{"expired", expired, token.ErrExpired},
+	}
+
+	for _, td := range testData {
+		values := make(url.Values, 0)
+		values.Add("token", td.token)
+		values.Add("account_name", "someone.else")
+		r := http.Request{PostForm: values}
+
+		var buf bytes.Buffer
+		w := NewTestResponseWriter(&buf)
+
+		err := h.ExtraDetailsHelper(w, &r, 2025, now)
+		if err != td.want {
+			t.Errorf("%s: want %v got %v", td.description, td.want, err)
+		}
+
+		if buf.String() != h.PostPaymentErrorHTML {
+			t.Errorf("%s: want the post payment error page", td.description)
+		}
+	}
+}
+
 // TestFetchCurrentExtraDetails checks fetchCurrentExtraDetails.
 func TestFetchCurrentExtraDetails(t *testing.T) {
+	ctx := t.Context()
+
 	for _, dbType := range databaseList {
 		db, connError := database.ConnectForTesting(dbType)
 		if connError != nil {
@@ -2348,55 +2232,55 @@ func TestFetchCurrentExtraDetails(t *testing.T) {
 		ms := database.MembershipSale{UserID: u1.ID, AssocUserID: u2.ID}
 
 		// Get the interests.  If there are any, assign the ones with ID 0 and 2 to u1.
-		interests, ie := db.GetInterests()
+		interests, ie := db.GetInterests(ctx)
 		if ie == nil {
 			if len(interests) > 0 {
 				interest1 := database.NewMembersInterest(u1.ID, interests[0].ID)
-				db.CreateMembersInterest(interest1)
+				db.CreateMembersInterest(ctx, interest1)
 				interest2 := database.NewMembersInterest(u1.ID, interests[2].ID)
-				db.CreateMembersInterest(interest2)
+				db.CreateMembersInterest(ctx, interest2)
 			}
 		}
 
-		db.SetAddressLine1(u1.ID, "a1")
-		db.SetAddressLine1(u2.ID, "a1")
+		database.SetProfileField(ctx, db, u1.ID, "STREET", "a1")
+		database.SetProfileField(ctx, db, u2.ID, "STREET", "a1")
 
-		db.SetAddressLine2(u1.ID, "a2")
-		db.SetAddressLine2(u2.ID, "a2")
+		database.SetProfileField(ctx, db, u1.ID, "ADDRESS_LINE_2", "a2")
+		database.SetProfileField(ctx, db, u2.ID, "ADDRESS_LINE_2", "a2")
 
-		db.SetAddressLine3(u1.ID, "a3")
-		db.SetAddressLine1(u2.ID, "a3")
+		database.SetProfileField(ctx, db, u1.ID, "ADDRESS_LINE_3", "a3")
+		database.SetProfileField(ctx, db, u2.ID, "STREET", "a3")
 
-		db.SetTown(u1.ID, "t")
-		db.SetTown(u2.ID, "t")
+		database.SetProfileField(ctx, db, u1.ID, "CITY", "t")
+		database.SetProfileField(ctx, db, u2.ID, "CITY", "t")
 
-		db.SetPostcode(u1.ID, "pc")
-		db.SetPostcode(u2.ID, "pc")
+		database.SetProfileField(ctx, db, u1.ID, "POSTCODE", "pc")
+		database.SetProfileField(ctx, db, u2.ID, "POSTCODE", "pc")
 
-		db.SetCounty(u1.ID, "cty")
-		db.SetCounty(u2.ID, "cty")
+		database.SetProfileField(ctx, db, u1.ID, "COUNTY", "cty")
+		database.SetProfileField(ctx, db, u2.ID, "COUNTY", "cty")
 
-		db.SetCountryCode(u1.ID, "GBR")
-		db.SetCountryCode(u2.ID, "GBR")
+		database.SetProfileField(ctx, db, u1.ID, "COUNTRY", "GBR")
+		database.SetProfileField(ctx, db, u2.ID, "COUNTRY", "GBR")
 
-		db.SetPhone(u1.ID, "+44 1")
-		db.SetPhone(u2.ID, "+44 1")
+		database.SetProfileField(ctx, db, u1.ID, "PHONE", "+44 1")
+		database.SetProfileField(ctx, db, u2.ID, "PHONE", "+44 1")
 
-		db.SetMobile(u1.ID, "+44 2")
+		database.SetProfileField(ctx, db, u1.ID, "MOBILE", "+44 2")
 
-		db.SetMobile(u2.ID, "+44 3")
+		database.SetProfileField(ctx, db, u2.ID, "MOBILE", "+44 3")
 
-		db.SetLocationOfInterest(u1.ID, "Ashtead")
+		database.SetProfileField(ctx, db, u1.ID, "LOCATION_OF_INTEREST", "Ashtead")
 
 		moi := database.NewMembersOtherInterests(u1.ID, "foobar")
 
-		db.CreateMembersOtherInterests(moi)
+		db.CreateMembersOtherInterests(ctx, moi)
 
 		// Create a structured logger that writes to the dailyLogWriter.
 		dailyLogWriter := dailylogger.New("..", "test.", ".log")
 		logger := slog.New(slog.NewTextHandler(dailyLogWriter, nil))
 		db.Logger = logger
-		h := Handler{DB: db, Logger: logger, Conf: &testConfig}
+		h := Handler{DB: db, Logger: logger, Conf: &testConfig, ctx: ctx}
 
 		// Test.
 		h.fetchCurrentExtraDetails(&ms)
@@ -2446,7 +2330,7 @@ func TestFetchCurrentExtraDetails(t *testing.T) {
 			t.Errorf("%s - want +44 3 got %s", dbType, ms.AssocMobile)
 		}
 
-		fetchedMOI, moie := db.GetMembersOtherInterests(u1.ID)
+		fetchedMOI, moie := db.GetMembersOtherInterests(ctx, u1.ID)
 		if moie != nil {
 			t.Error(moie)
 			continue
@@ -2581,6 +2465,8 @@ func TestMakeCountrySelectionList(t *testing.T) {
 }
 
 func TestMakeCountrySelectionListFavouring(t *testing.T) {
+	ctx := t.Context()
+
 	db, connError := database.OpenDBForTesting("sqlite")
 
 	if connError != nil {
@@ -2588,7 +2474,7 @@ func TestMakeCountrySelectionListFavouring(t *testing.T) {
 		return
 	}
 
-	db.BeginTx()
+	db.BeginTx(ctx)
 
 	defer db.Rollback()
 	defer db.CloseAndDelete()
@@ -2602,7 +2488,7 @@ func TestMakeCountrySelectionListFavouring(t *testing.T) {
 	dailyLogWriter := dailylogger.New("..", "test.", ".log")
 	logger := slog.New(slog.NewTextHandler(dailyLogWriter, nil))
 	db.Logger = logger
-	h := Handler{DB: db, Logger: logger, Conf: &testConfig}
+	h := Handler{DB: db, Logger: logger, Conf: &testConfig, ctx: ctx}
 
 	want := `
 		<select name='country_code' id='countries' size='5'>
@@ -2648,19 +2534,224 @@ func cleanUpHTML(s string) string {
 
 // createtestuser creates a user for testing.
 func createTestUser(db *database.Database, t *testing.T) *database.User {
+	ctx := t.Context()
 
 	// This shoud never fail so on any error, stop the test suite.
-	u, uie := database.CreateUuid(db.Transaction, "usr_login_name", "adm_users")
+	u, uie := database.CreateUuid(ctx, db.Transaction, "usr_login_name", "adm_users")
 	if uie != nil {
 		t.Fatal(uie)
 	}
 
 	user := database.NewUser(u)
 
-	e := db.CreateUser(user)
+	e := db.CreateUser(ctx, user)
 	if e != nil {
 		t.Fatal(e)
 	}
 
 	return user
 }
+
+// TestProfileRowsTemplate checks that the rows of the details form show the
+// value and the error message of each profile field on the form.
+func TestProfileRowsTemplate(t *testing.T) {
+
+	const countries = "<tr><td>countries</td></tr>"
+	const extra = "<tr><td>extra</td></tr>"
+
+	var ms database.MembershipSale
+	for i := range database.ProfileFields {
+		f := &database.ProfileFields[i]
+		value, errorMessage := f.Sale(&ms)
+		if value == nil {
+			continue
+		}
+		*value = "value of " + f.FormName
+		*errorMessage = "error in " + f.FormName
+	}
+
+	tmpl, parseError := template.New("rows").Parse(profileRowsTemplate(countries, extra))
+	if parseError != nil {
+		t.Fatal(parseError)
+	}
+
+	var buf bytes.Buffer
+	executeError := tmpl.Execute(&buf, &ms)
+	if executeError != nil {
+		t.Fatal(executeError)
+	}
+	got := buf.String()
+
+	for i := range database.ProfileFields {
+		f := &database.ProfileFields[i]
+		if len(f.FormName) == 0 || f.NameIntern == "COUNTRY" {
+			continue
+		}
+
+		wantInput := fmt.Sprintf("name='%s' value='value of %s'", f.FormName, f.FormName)
+		if !strings.Contains(got, wantInput) {
+			t.Errorf("%s: want %s", f.NameIntern, wantInput)
+		}
+
+		wantError := "error in " + f.FormName
+		if !strings.Contains(got, wantError) {
+			t.Errorf("%s: want %s", f.NameIntern, wantError)
+		}
+	}
+
+	// The country selection list replaces the country field and the extra
+	// rows follow the mobile number.
+	if !strings.Contains(got, countries) {
+		t.Error("want the country selection list")
+	}
+	mobile := strings.Index(got, "name='mobile'")
+	extraRows := strings.Index(got, extra)
+	if mobile < 0 || extraRows < mobile {
+		t.Errorf("want the extra rows after the mobile number")
+	}
+}
+
+// TestValidateExtraDetails checks that validateExtraDetails checks the phone
+// numbers and puts each error message against the right field.
+func TestValidateExtraDetails(t *testing.T) {
+
+	var testData = []struct {
+		description          string
+		ms                   database.MembershipSale
+		wantValid            bool
+		wantPhoneError       string
+		wantMobileError      string
+		wantAssocMobileError string
+	}{
+		{"all valid", database.MembershipSale{Phone: "01234 567890", Mobile: "+44 7700 900123", AssocMobile: "07700 900123"},
+			true, "", "", ""},
+		{"empty", database.MembershipSale{}, true, "", "", ""},
+		{"bad phone", database.MembershipSale{Phone: "junk"},
+			false, database.IllegalPhoneNumber, "", ""},
+		{"bad mobile", database.MembershipSale{Mobile: "junk"},
+			false, "", database.IllegalPhoneNumber, ""},
+		{"bad associate mobile", database.MembershipSale{AssocMobile: "junk"},
+			false, "", "", database.IllegalPhoneNumber},
+	}
+
+	var h Handler
+	for _, td := range testData {
+		ms := td.ms
+		valid := h.validateExtraDetails(&ms)
+		if td.wantValid != valid {
+			t.Errorf("%s: want %v got %v", td.description, td.wantValid, valid)
+		}
+		if td.wantPhoneError != ms.PhoneError {
+			t.Errorf("%s: want %s got %s", td.description, td.wantPhoneError, ms.PhoneError)
+		}
+		if td.wantMobileError != ms.MobileError {
+			t.Errorf("%s: want %s got %s", td.description, td.wantMobileError, ms.MobileError)
+		}
+		if td.wantAssocMobileError != ms.AssocMobileError {
+			t.Errorf("%s: want %s got %s", td.description, td.wantAssocMobileError, ms.AssocMobileError)
+		}
+	}
+}
+
+// TestExtraFieldsOnForms checks that the fields from the configured extra fields
+// category are read from the details form, validated and shown on the form.
+func TestExtraFieldsOnForms(t *testing.T) {
+	ctx := t.Context()
+
+	for _, dbType := range databaseList {
+
+		db, connError := database.ConnectForTesting(dbType)
+		if connError != nil {
+			t.Error(connError)
+			continue
+		}
+
+		defer db.Rollback()
+		defer db.CloseAndDelete()
+
+		basic, basicError := db.GetCategoryByNameIntern(ctx, "BASIC_DATA")
+		if basicError != nil {
+			t.Fatal(basicError)
+		}
+
+		cat := database.NewCategory(basic.Org, "USF", "VOLUNTEERING", "Volunteering", false, false, 9, basic.CreateUser)
+		if err := db.CreateCategory(ctx, cat); err != nil {
+			t.Fatal(err)
+		}
+
+		fieldTypes := []string{"CHECKBOX", "DATE", "DROPDOWN"}
+		for i, name := range []string{"CAN_DRIVE", "AVAILABLE_FROM", "SKILLS"} {
+			uf := database.NewUserField(name, name, fieldTypes[i], basic.CreateUser, cat)
+			uf.Sequence = i + 1
+			if err := db.CreateUserField(ctx, uf); err != nil {
+				t.Fatal(err)
+			}
+		}
+		_, updateError := db.Exec(ctx,
+			"UPDATE adm_user_fields SET usf_value_list = $1 WHERE usf_name_intern = $2;",
+			"Gardening\nTalks", "SKILLS")
+		if updateError != nil {
+			t.Fatal(updateError)
+		}
+
+		conf := testConfig
+		conf.ExtraFieldsCategory = "VOLUNTEERING"
+		h := Handler{DB: db, Conf: &conf, ctx: ctx}
+
+		fields, fieldsError := db.GetExtraFields(ctx, "VOLUNTEERING")
+		if fieldsError != nil || len(fields) != 3 {
+			t.Fatalf("%s: want 3 fields got %v %v", dbType, fields, fieldsError)
+		}
+
+		values := make(url.Values, 0)
+		values.Add(fields[0].FormName(), "on")
+		values.Add(fields[1].FormName(), "1st June")
+		values.Add(fields[2].FormName(), "2")
+		r := http.Request{PostForm: values}
+
+		var ms database.MembershipSale
+		readError := h.readExtraDetails(&r, &ms, false)
+		if readError != nil {
+			t.Errorf("%s: %v", dbType, readError)
+			continue
+		}
+
+		wantValues := []string{"1", "1st June", "2"}
+		for i, want := range wantValues {
+			if ms.ExtraFields[i].Value != want {
+				t.Errorf("%s: %s: want %s got %s",
+					dbType, ms.ExtraFields[i].NameIntern, want, ms.ExtraFields[i].Value)
+			}
+		}
+
+		// The date is invalid.
+		if h.validateExtraDetails(&ms) {
+			t.Errorf("%s: want the details to be invalid", dbType)
+		}
+		if len(ms.ExtraFields[1].Error) == 0 {
+			t.Errorf("%s: want an error message for the date", dbType)
+		}
+
+		tmpl, parseError := template.New("rows").Parse(extraFieldRows)
+		if parseError != nil {
+			t.Fatal(parseError)
+		}
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, &ms); err != nil {
+			t.Fatal(err)
+		}
+		page := buf.String()
+
+		wantInPage := []string{
+			fmt.Sprintf("name='%s' checked", fields[0].FormName()),
+			fmt.Sprintf("type='date' name='%s' value='1st June'", fields[1].FormName()),
+			"<option value='2' selected>Talks</option>",
+			ms.ExtraFields[1].Error,
+		}
+		for _, want := range wantInPage {
+			if !strings.Contains(page, want) {
+				t.Errorf("%s: want %s in the page", dbType, want)
+			}
+		}
+	}
+}
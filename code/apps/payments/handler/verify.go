@@ -0,0 +1,483 @@
+package handler
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/goblimey/go-stripe-payments/code/pkg/csrf"
+	"github.com/goblimey/go-stripe-payments/code/pkg/database"
+)
+
+// Email-verified renewal.  If the customer gives the email address of an
+// existing member, anybody who knows that address could otherwise pay and then
+// take over the account via the extra details page.  Instead the sale waits
+// (status PaymentStatusUnverified) while a one-time link is sent to the email
+// address on record.  Following the link releases the sale to the payment page.
+//
+// The accounts are found by the matching service in the database package,
+// which scores them on the email address and the names.  If one account
+// clearly matches by name but not by email address, the confirmation page asks
+// the customer whether it's theirs.  If they say yes, it's verified in the same
+// way, using the email address on record.  If they say no, they are taken to be
+// a new member.  If several accounts match equally well, or the customer wasn't
+// asked, we can't tell which of them is the customer's.  The sale goes ahead
+// but it's put in the review queue and, after payment, the accounts are left
+// alone for an administrator to sort out.
+
+// The answers to the question on the confirmation page.
+const (
+	answerYes = "yes" // The account is the customer's.
+	answerNo  = "no"  // The customer is a new member.
+)
+
+// memberMatch describes how the details of one member in the sale form match
+// the existing accounts.
+type memberMatch struct {
+	userID int64  // The account that the member must verify, 0 if none.
+	review string // If not empty, the reason why the sale needs review.
+}
+
+// verificationPage holds the data for the check email page and the page that
+// asks the customer to confirm that they want to continue.
+type verificationPage struct {
+	OrganisationName         string
+	MembershipYear           int
+	EmailAddressForQuestions string
+	MaskedEmails             []string
+	Lifetime                 string
+	Code                     string // The one-time code from the link.
+	CSRFToken                string
+}
+
+// verificationEmail holds the data for the verification email.
+type verificationEmail struct {
+	OrganisationName         string
+	MembershipYear           int
+	EmailAddressForQuestions string
+	Link                     string
+	Lifetime                 string
+}
+
+// matchMember looks for existing accounts belonging to the member with the given
+// details.  An account that clearly matches by email address and name
+// identifies that account, subject to verification.  An account that clearly
+// matches by name only, or by an email address that may be shared with
+// another member of the household, does the same if the customer has
+// confirmed that it's theirs (answer is answerYes).  Anything less certain means that the sale needs review.  No
+// match at all, or the customer saying that the account isn't theirs, means
+// that this is a new member.
+func (h *Handler) matchMember(firstName, lastName, email, answer string) (*memberMatch, error) {
+
+	var match memberMatch
+
+	person := database.MatchDetails{FirstName: firstName, LastName: lastName, Email: email}
+	candidates, matchError := h.DB.FindMemberCandidates(h.ctx, &person)
+	if matchError != nil {
+		return nil, matchError
+	}
+
+	best, outcome := database.ChooseCandidate(candidates)
+	switch outcome {
+	case database.MatchCertain:
+		match.userID = best.UserID
+	case database.MatchProbable:
+		switch answer {
+		case answerYes:
+			match.userID = best.UserID
+		case answerNo:
+			h.logMessage("matchMember: %s %s says account %v is not theirs", firstName, lastName, best)
+		default:
+			match.review = fmt.Sprintf("%s %s matches account %v but did not confirm it",
+				firstName, lastName, best)
+		}
+	case database.MatchAmbiguous:
+		match.review = fmt.Sprintf("%s %s %s matches more than one account %v",
+			firstName, lastName, email, candidates)
+	}
+
+	return &match, nil
+}
+
+// needsConfirmation is true if one existing account clearly matches the member
+// with the given details but not certainly (see database.ChooseCandidate), so
+// the customer should be asked whether it's theirs.
+func (h *Handler) needsConfirmation(firstName, lastName, email string) (bool, error) {
+
+	person := database.MatchDetails{FirstName: firstName, LastName: lastName, Email: email}
+	candidates, matchError := h.DB.FindMemberCandidates(h.ctx, &person)
+	if matchError != nil {
+		return false, matchError
+	}
+
+	_, outcome := database.ChooseCandidate(candidates)
+
+	return outcome == database.MatchProbable, nil
+}
+
+// matchMembers matches the ordinary member and any associate in the sale against
+// the existing accounts, given the customer's answers to the questions on the
+// confirmation page, if any.  It sets the user IDs in the sale of any members
+// who must verify their email address and returns them, along with the reasons
+// why the sale needs review, if any.
+func (h *Handler) matchMembers(ms *database.MembershipSale, answer, assocAnswer string) ([]int64, []string, error) {
+
+	toVerify := make([]int64, 0, 2)
+	reasons := make([]string, 0, 2)
+
+	om, omError := h.matchMember(ms.FirstName, ms.LastName, ms.Email, answer)
+	if omError != nil {
+		return nil, nil, omError
+	}
+
+	if om.userID > 0 {
+		ms.UserID = om.userID
+		toVerify = append(toVerify, om.userID)
+	}
+
+	if len(om.review) > 0 {
+		reasons = append(reasons, om.review)
+	}
+
+	if !ms.EnableOtherMemberTypes || len(ms.AssocFirstName) == 0 {
+		return toVerify, reasons, nil
+	}
+
+	am, amError := h.matchMember(ms.AssocFirstName, ms.AssocLastName, ms.AssocEmail, assocAnswer)
+	if amError != nil {
+		return nil, nil, amError
+	}
+
+	switch {
+	case am.userID > 0 && am.userID == ms.UserID:
+		// Both members in the sale refer to the same account.
+		reasons = append(reasons, fmt.Sprintf(
+			"the member and the associate both match account %d", am.userID))
+	case am.userID > 0:
+		ms.AssocUserID = am.userID
+		toVerify = append(toVerify, am.userID)
+	}
+
+	if len(am.review) > 0 {
+		reasons = append(reasons, am.review)
+	}
+
+	return toVerify, reasons, nil
+}
+
+// startVerification creates a verification for each of the given users and
+// emails them the link.  It returns the masked email addresses for display.  If a
+// user has no email address on record they can't be verified, so the sale is put
+// in the review queue instead.  It's assumed that the sale has been created and
+// that a transaction is already set up in the db object.
+func (h *Handler) startVerification(ms *database.MembershipSale, userIDs []int64, host string, now time.Time) ([]string, error) {
+
+	masked := make([]string, 0, len(userIDs))
+	sent := make(map[string]bool)
+
+	for _, userID := range userIDs {
+
+		email, emailError := h.DB.GetEmailOnRecord(h.ctx, userID)
+		if emailError != nil {
+			return nil, emailError
+		}
+
+		if len(email) == 0 {
+			r := database.Review{
+				SaleID: ms.ID,
+				Reason: fmt.Sprintf("account %d has no email address to verify", userID),
+			}
+			reviewError := h.DB.CreateReview(h.ctx, &r)
+			if reviewError != nil {
+				return nil, reviewError
+			}
+			if ms.UserID == userID {
+				ms.UserID = 0
+			} else {
+				ms.AssocUserID = 0
+			}
+			continue
+		}
+
+		code, codeError := newVerificationCode()
+		if codeError != nil {
+			return nil, codeError
+		}
+
+		v := database.Verification{
+			SaleID:   ms.ID,
+			UserID:   userID,
+			Email:    email,
+			CodeHash: hashVerificationCode(code),
+			Expires:  now.Add(h.Conf.VerificationLifetime()),
+		}
+
+		createError := h.DB.CreateVerification(h.ctx, &v)
+		if createError != nil {
+			return nil, createError
+		}
+
+		sendError := h.sendVerificationEmail(ms, email, code, host)
+		if sendError != nil {
+			return nil, sendError
+		}
+
+		if !sent[email] {
+			masked = append(masked, maskEmail(email))
+			sent[email] = true
+		}
+	}
+
+	return masked, nil
+}
+
+// sendVerificationEmail emails the one-time link to the given address.
+func (h *Handler) sendVerificationEmail(ms *database.MembershipSale, email, code, host string) error {
+
+	if h.Mailer == nil {
+		return errors.New("sendVerificationEmail: no mail server configured")
+	}
+
+	data := verificationEmail{
+		OrganisationName:         h.Conf.OrganisationName,
+		MembershipYear:           ms.MembershipYear,
+		EmailAddressForQuestions: h.Conf.EmailAddressForQuestions,
+		Link:                     fmt.Sprintf("%s://%s/verify?code=%s", protocol, host, code),
+		Lifetime:                 describeDuration(h.Conf.VerificationLifetime()),
+	}
+
+	emailTemplate, parseError := template.New("VerificationEmail").Parse(verificationEmailTemplateString)
+	if parseError != nil {
+		return parseError
+	}
+
+	var body bytes.Buffer
+	executeError := emailTemplate.Execute(&body, &data)
+	if executeError != nil {
+		return executeError
+	}
+
+	subject := fmt.Sprintf("%s membership - please confirm your email address", h.Conf.OrganisationName)
+
+	return h.Mailer.Send(email, subject, body.String())
+}
+
+// displayCheckEmailPage tells the customer to look for the verification email.
+func (h *Handler) displayCheckEmailPage(w http.ResponseWriter, ms *database.MembershipSale, masked []string) {
+
+	data := verificationPage{
+		OrganisationName:         h.Conf.OrganisationName,
+		MembershipYear:           ms.MembershipYear,
+		EmailAddressForQuestions: h.Conf.EmailAddressForQuestions,
+		MaskedEmails:             masked,
+		Lifetime:                 describeDuration(h.Conf.VerificationLifetime()),
+	}
+
+	pageTemplate, parseError := template.New("CheckEmail").Parse(checkEmailPageTemplateString)
+	if parseError != nil {
+		h.reportError(w, h.PrePaymentErrorHTML, parseError)
+		return
+	}
+
+	executeError := pageTemplate.Execute(w, &data)
+	if executeError != nil {
+		h.logError("displayCheckEmailPage: %v", executeError)
+	}
+}
+
+// Verify is the handler for the /verify request, made when the customer follows
+// the link in a verification email (a GET) and when they confirm that they want
+// to continue (a POST).
+func (h *Handler) Verify(w http.ResponseWriter, r *http.Request) {
+
+	h.Logger.Info("Verify")
+
+	// The helper commits if all goes well.
+	h.withRequest(r.Context(), w, h.PrePaymentErrorHTML, func(h *Handler) {
+		h.verifyHelper(w, r, time.Now())
+	})
+}
+
+// verifyHelper checks the code in the verification link.  Email link scanners
+// and browsers that prefetch links follow the link with a GET request, so a GET
+// only displays a page with a button that posts the code back.  The POST uses
+// the code up and, when all the members in the sale have been verified, passes
+// control to Stripe.  The helper is separated out to support unit testing.
+func (h *Handler) verifyHelper(w http.ResponseWriter, r *http.Request, now time.Time) {
+
+	const fn = "verifyHelper"
+
+	code := r.URL.Query().Get("code")
+	if r.Method == http.MethodPost {
+		code = r.PostFormValue("code")
+	}
+	if len(code) == 0 {
+		h.verificationFailed(w, r, "no code")
+		return
+	}
+
+	v, fetchError := h.DB.GetVerificationByCodeHash(h.ctx, hashVerificationCode(code))
+	if fetchError != nil {
+		if errors.Is(fetchError, sql.ErrNoRows) {
+			h.verificationFailed(w, r, "unknown code")
+			return
+		}
+		h.reportError(w, h.PrePaymentErrorHTML, fetchError)
+		return
+	}
+
+	if v.Used {
+		h.verificationFailed(w, r, fmt.Sprintf("verification %d already used", v.ID))
+		return
+	}
+
+	if now.After(v.Expires) {
+		h.verificationFailed(w, r, fmt.Sprintf("verification %d expired", v.ID))
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		h.displayConfirmVerificationPage(w, r, v, code)
+		return
+	}
+
+	useError := h.DB.UseVerification(h.ctx, v)
+	if useError != nil {
+		h.verificationFailed(w, r, useError.Error())
+		return
+	}
+
+	ms, saleError := h.DB.GetMembershipSale(h.ctx, v.SaleID)
+	if saleError != nil {
+		h.reportError(w, h.PrePaymentErrorHTML, saleError)
+		return
+	}
+
+	if ms.PaymentStatus != database.PaymentStatusUnverified {
+		h.verificationFailed(w, r, fmt.Sprintf("sale %d has status %s", ms.ID, ms.PaymentStatus))
+		return
+	}
+
+	h.logMessage("%s: sale %d - user %d verified %s", fn, ms.ID, v.UserID, v.Email)
+
+	unused, countError := h.DB.CountUnusedVerifications(h.ctx, ms.ID)
+	if countError != nil {
+		h.reportError(w, h.PrePaymentErrorHTML, countError)
+		return
+	}
+
+	if unused > 0 {
+		// The other member in the sale has not verified their address yet.
+		commitError := h.DB.Commit()
+		if commitError != nil {
+			h.reportError(w, h.PrePaymentErrorHTML, commitError)
+			return
+		}
+		w.Write([]byte(verificationWaitingHTML))
+		return
+	}
+
+	// Everybody is verified.  The sale can go ahead.
+	ms.PaymentStatus = database.PaymentStatusPending
+	updateError := ms.Update(h.ctx, h.DB)
+	if updateError != nil {
+		h.reportError(w, h.PrePaymentErrorHTML, updateError)
+		return
+	}
+
+	commitError := h.DB.Commit()
+	if commitError != nil {
+		h.reportError(w, h.PrePaymentErrorHTML, commitError)
+		return
+	}
+
+	ms.OrganisationName = h.Conf.OrganisationName
+	h.redirectToStripe(w, r, ms)
+}
+
+// displayConfirmVerificationPage displays the page that asks the customer to
+// confirm that they want to continue to the payment page.  Posting its form
+// uses up the code.
+func (h *Handler) displayConfirmVerificationPage(w http.ResponseWriter, r *http.Request, v *database.Verification, code string) {
+
+	ms, saleError := h.DB.GetMembershipSale(h.ctx, v.SaleID)
+	if saleError != nil {
+		h.reportError(w, h.PrePaymentErrorHTML, saleError)
+		return
+	}
+
+	data := verificationPage{
+		OrganisationName:         h.Conf.OrganisationName,
+		MembershipYear:           ms.MembershipYear,
+		EmailAddressForQuestions: h.Conf.EmailAddressForQuestions,
+		Code:                     code,
+		CSRFToken:                csrf.Token(r),
+	}
+
+	pageTemplate, parseError := template.New("ConfirmVerification").Parse(confirmVerificationPageTemplateString)
+	if parseError != nil {
+		h.reportError(w, h.PrePaymentErrorHTML, parseError)
+		return
+	}
+
+	executeError := pageTemplate.Execute(w, &data)
+	if executeError != nil {
+		h.logError("displayConfirmVerificationPage: %v", executeError)
+	}
+}
+
+// verificationFailed logs a failed verification and displays the failure page.
+func (h *Handler) verificationFailed(w http.ResponseWriter, r *http.Request, reason string) {
+	h.Logger.Warn("verification failed", "reason", reason, "remote", clientIP(r))
+	w.WriteHeader(http.StatusBadRequest)
+	w.Write([]byte(h.VerificationFailedHTML))
+}
+
+// newVerificationCode creates a random one-time code.
+func newVerificationCode() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// hashVerificationCode returns the hash of a code, which is what's stored in the
+// database.
+func hashVerificationCode(code string) string {
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+// maskEmail hides most of the local part of an email address, so that the
+// customer can recognise it without it being revealed to somebody who doesn't
+// already know it - "simon@example.com" becomes "s****@example.com".
+func maskEmail(email string) string {
+	at := strings.LastIndex(email, "@")
+	if at <= 0 {
+		return "****"
+	}
+	return email[:1] + strings.Repeat("*", at-1) + email[at:]
+}
+
+// describeDuration describes a duration in words, in hours if it's a whole
+// number of hours, otherwise in minutes.
+func describeDuration(d time.Duration) string {
+	if d >= time.Hour && d%time.Hour == 0 {
+		hours := int(d / time.Hour)
+		if hours == 1 {
+			return "1 hour"
+		}
+		return fmt.Sprintf("%d hours", hours)
+	}
+	return fmt.Sprintf("%d minutes", int(d/time.Minute))
+}
@@ -0,0 +1,456 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"text/template"
+	"time"
+
+	"github.com/goblimey/go-stripe-payments/code/pkg/database"
+	"github.com/goblimey/go-stripe-payments/code/pkg/forms"
+)
+
+// fakeMailer records the messages that it's asked to send.
+type fakeMailer struct {
+	to   []string
+	body []string
+}
+
+func (m *fakeMailer) Send(to, subject, body string) error {
+	m.to = append(m.to, to)
+	m.body = append(m.body, body)
+	return nil
+}
+
+// codeRegexp extracts the code from the link in a verification email.
+var codeRegexp = regexp.MustCompile(`/verify\?code=([0-9a-f]+)`)
+
+// TestMaskEmail checks maskEmail.
+func TestMaskEmail(t *testing.T) {
+	var testData = []struct {
+		email string
+		want  string
+	}{
+		{"simon@example.com", "s****@example.com"},
+		{"a@example.com", "a@example.com"},
+		{"@example.com", "****"},
+		{"junk", "****"},
+	}
+
+	for _, td := range testData {
+		got := maskEmail(td.email)
+		if got != td.want {
+			t.Errorf("%s: want %s got %s", td.email, td.want, got)
+		}
+	}
+}
+
+// TestDescribeDuration checks describeDuration.
+func TestDescribeDuration(t *testing.T) {
+	var testData = []struct {
+		d    time.Duration
+		want string
+	}{
+		{time.Hour, "1 hour"},
+		{24 * time.Hour, "24 hours"},
+		{90 * time.Minute, "90 minutes"},
+		{30 * time.Minute, "30 minutes"},
+	}
+
+	for _, td := range testData {
+		got := describeDuration(td.d)
+		if got != td.want {
+			t.Errorf("%v: want %s got %s", td.d, td.want, got)
+		}
+	}
+}
+
+// TestMatchMembers checks that a sale is matched against the existing accounts -
+// an email match must be verified, a clear match by name must be confirmed by
+// the customer and then verified, and anything less certain needs review.
+func TestMatchMembers(t *testing.T) {
+	ctx := t.Context()
+
+	for _, dbType := range databaseList {
+
+		db, connError := database.OpenDBForTesting(dbType)
+		if connError != nil {
+			t.Error(connError)
+			continue
+		}
+
+		db.BeginTx(ctx)
+
+		defer db.Rollback()
+		defer db.CloseAndDelete()
+
+		prepError := database.PrepareTestTables(db)
+		if prepError != nil {
+			t.Error(prepError)
+			continue
+		}
+
+		h := New(&testConfig)
+		h.DB = db
+		h.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+		existing := createTestUser(db, t)
+		existingEmail := existing.LoginName + "@example.com"
+		firstName := existing.LoginName + "first"
+		lastName := existing.LoginName + "last"
+		database.SetProfileField(ctx, db, existing.ID, "EMAIL", existingEmail)
+		database.SetProfileField(ctx, db, existing.ID, "FIRST_NAME", firstName)
+		database.SetProfileField(ctx, db, existing.ID, "LAST_NAME", lastName)
+
+		// An account with an accented name, found via a nickname.
+		robert := createTestUser(db, t)
+		robertLastName := "Núñez " + robert.LoginName
+		database.SetProfileField(ctx, db, robert.ID, "FIRST_NAME", "Robert")
+		database.SetProfileField(ctx, db, robert.ID, "LAST_NAME", robertLastName)
+
+		// Two accounts with the same names.
+		twinLastName := existing.LoginName + "twin"
+		twins := []*database.User{createTestUser(db, t), createTestUser(db, t)}
+		for _, twin := range twins {
+			database.SetProfileField(ctx, db, twin.ID, "FIRST_NAME", "Sam")
+			database.SetProfileField(ctx, db, twin.ID, "LAST_NAME", twinLastName)
+		}
+
+		// Two accounts with the same email address, told apart by the names.
+		sharedEmail := robert.LoginName + "@example.com"
+		husband := createTestUser(db, t)
+		wife := createTestUser(db, t)
+		sharedLastName := husband.LoginName + "last"
+		for _, u := range []*database.User{husband, wife} {
+			database.SetProfileField(ctx, db, u.ID, "EMAIL", sharedEmail)
+			database.SetProfileField(ctx, db, u.ID, "LAST_NAME", sharedLastName)
+		}
+		database.SetProfileField(ctx, db, husband.ID, "FIRST_NAME", "John")
+		database.SetProfileField(ctx, db, wife.ID, "FIRST_NAME", "Mary")
+
+		var testData = []struct {
+			description string
+			firstName   string
+			lastName    string
+			email       string
+			answer      string
+			wantVerify  []int64
+			wantReview  bool
+		}{
+			{"new member", "x", "y", "nobody@example.com", "", []int64{}, false},
+			{"email", firstName, lastName, strings.ToUpper(existingEmail), "", []int64{existing.ID}, false},
+			{"email, other names", "x", "y", existingEmail, "", []int64{}, true},
+			{"email, other names, confirmed", "x", "y", existingEmail, answerYes, []int64{existing.ID}, false},
+			{"email, other first name", "x", lastName, existingEmail, "", []int64{}, true},
+			{"name only", firstName, lastName, "nobody@example.com", "", []int64{}, true},
+			{"name only, confirmed", firstName, lastName, "nobody@example.com", answerYes, []int64{existing.ID}, false},
+			{"name only, denied", firstName, lastName, "nobody@example.com", answerNo, []int64{}, false},
+			{"nickname and accents", "Bob", " nunez  " + strings.ToUpper(robert.LoginName), "", answerYes, []int64{robert.ID}, false},
+			{"same names", "Sam", twinLastName, "", answerYes, []int64{}, true},
+			{"shared email", "john", sharedLastName, sharedEmail, "", []int64{husband.ID}, false},
+			{"shared email, no names", "x", "y", sharedEmail, "", []int64{}, true},
+		}
+
+		for _, td := range testData {
+			ms := database.NewMembershipSale(&testConfig)
+			ms.FirstName = td.firstName
+			ms.LastName = td.lastName
+			ms.Email = td.email
+
+			toVerify, reasons, matchError := h.matchMembers(ms, td.answer, "")
+			if matchError != nil {
+				t.Errorf("%s %s: %v", dbType, td.description, matchError)
+				continue
+			}
+
+			if len(toVerify) != len(td.wantVerify) ||
+				(len(toVerify) > 0 && toVerify[0] != td.wantVerify[0]) {
+				t.Errorf("%s %s: want %v got %v", dbType, td.description, td.wantVerify, toVerify)
+			}
+
+			if len(toVerify) > 0 && ms.UserID != td.wantVerify[0] {
+				t.Errorf("%s %s: want user ID %d got %d",
+					dbType, td.description, td.wantVerify[0], ms.UserID)
+			}
+
+			if (len(reasons) > 0) != td.wantReview {
+				t.Errorf("%s %s: want review %v got %v",
+					dbType, td.description, td.wantReview, reasons)
+			}
+		}
+
+		// The confirmation page asks about a clear match by name only.
+		var confirmData = []struct {
+			description string
+			firstName   string
+			lastName    string
+			email       string
+			want        bool
+		}{
+			{"name only", firstName, lastName, "", true},
+			{"email", firstName, lastName, existingEmail, false},
+			{"new member", "x", "y", "", false},
+			{"same names", "Sam", twinLastName, "", false},
+		}
+
+		for _, td := range confirmData {
+			got, confirmError := h.needsConfirmation(td.firstName, td.lastName, td.email)
+			if confirmError != nil {
+				t.Errorf("%s %s: %v", dbType, td.description, confirmError)
+				continue
+			}
+			if td.want != got {
+				t.Errorf("%s %s: want %v got %v", dbType, td.description, td.want, got)
+			}
+		}
+	}
+}
+
+// TestConfirmationPageQuestions checks that the confirmation page asks the
+// customer about an existing account only when it should.
+func TestConfirmationPageQuestions(t *testing.T) {
+
+	tmpl, templateError := template.New("PaymentConfirmationPage").Parse(paymentConfirmationPageTemplate)
+	if templateError != nil {
+		t.Fatal(templateError)
+	}
+
+	var testData = []struct {
+		description string
+		member      bool
+		assoc       bool
+	}{
+		{"neither", false, false},
+		{"member", true, false},
+		{"associate", false, true},
+		{"both", true, true},
+	}
+
+	for _, td := range testData {
+		sf := forms.NewSaleForm(&testConfig, 2025)
+		sf.FirstName = "Bob"
+		sf.LastName = "Smith"
+		sf.AssocFirstName = "Jane"
+		sf.AssocLastName = "Smith"
+		sf.ConfirmMember = td.member
+		sf.ConfirmAssocMember = td.assoc
+
+		var buffer bytes.Buffer
+		if err := tmpl.Execute(&buffer, sf); err != nil {
+			t.Errorf("%s: %v", td.description, err)
+			continue
+		}
+		page := buffer.String()
+
+		if got := strings.Contains(page, "name='member_confirmed'"); got != td.member {
+			t.Errorf("%s: want member question %v got %v", td.description, td.member, got)
+		}
+		if got := strings.Contains(page, "name='assoc_member_confirmed'"); got != td.assoc {
+			t.Errorf("%s: want associate question %v got %v", td.description, td.assoc, got)
+		}
+	}
+}
+
+// TestVerifyRenewal checks the whole verification flow for a sale with two
+// existing members: checkout sends a link to each of them and holds the sale,
+// following one link leaves the sale waiting for the other and a used, forged or
+// expired link is refused.
+func TestVerifyRenewal(t *testing.T) {
+	ctx := t.Context()
+
+	for _, dbType := range databaseList {
+
+		db, connError := database.OpenDBForTesting(dbType)
+		if connError != nil {
+			t.Error(connError)
+			continue
+		}
+
+		db.BeginTx(ctx)
+
+		defer db.Rollback()
+		defer db.CloseAndDelete()
+
+		prepError := database.PrepareTestTables(db)
+		if prepError != nil {
+			t.Error(prepError)
+			continue
+		}
+
+		mailer := fakeMailer{}
+		h := New(&testConfig)
+		h.DB = db
+		h.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+		h.Mailer = &mailer
+
+		user := createTestUser(db, t)
+		email := user.LoginName + "@example.com"
+		database.SetProfileField(ctx, db, user.ID, "EMAIL", email)
+		database.SetProfileField(ctx, db, user.ID, "FIRST_NAME", "a")
+		database.SetProfileField(ctx, db, user.ID, "LAST_NAME", "b")
+
+		assoc := createTestUser(db, t)
+		assocEmail := assoc.LoginName + "@example.com"
+		database.SetProfileField(ctx, db, assoc.ID, "EMAIL", assocEmail)
+		database.SetProfileField(ctx, db, assoc.ID, "FIRST_NAME", "c")
+		database.SetProfileField(ctx, db, assoc.ID, "LAST_NAME", "d")
+
+		values := make(url.Values, 0)
+		values.Add("first_name", "a")
+		values.Add("last_name", "b")
+		values.Add("email", email)
+		values.Add("assoc_first_name", "c")
+		values.Add("assoc_last_name", "d")
+		values.Add("assoc_email", assocEmail)
+		r := http.Request{PostForm: values, Host: "example.com", RemoteAddr: "192.0.2.1:1234"}
+
+		var checkoutBuffer bytes.Buffer
+		h.checkoutHelper(NewTestResponseWriter(&checkoutBuffer), &r, 2025)
+
+		checkoutPage := checkoutBuffer.String()
+		if !strings.Contains(checkoutPage, maskEmail(email)) ||
+			!strings.Contains(checkoutPage, maskEmail(assocEmail)) {
+			t.Errorf("%s: check email page should show the masked addresses\n%s",
+				dbType, checkoutPage)
+		}
+
+		if strings.Contains(checkoutPage, email) {
+			t.Errorf("%s: check email page should not show the address", dbType)
+		}
+
+		if len(mailer.to) != 2 || mailer.to[0] != email || mailer.to[1] != assocEmail {
+			t.Errorf("%s: want mail to %s and %s got %v", dbType, email, assocEmail, mailer.to)
+			continue
+		}
+
+		codes := make([]string, 0, 2)
+		for _, body := range mailer.body {
+			match := codeRegexp.FindStringSubmatch(body)
+			if match == nil {
+				t.Errorf("%s: no link in\n%s", dbType, body)
+				continue
+			}
+			codes = append(codes, match[1])
+		}
+		if len(codes) != 2 {
+			continue
+		}
+
+		// The checkout helper has committed the transaction.  Start another.
+		db.BeginTx(ctx)
+
+		v, fetchError := db.GetVerificationByCodeHash(ctx, hashVerificationCode(codes[0]))
+		if fetchError != nil {
+			t.Errorf("%s: %v", dbType, fetchError)
+			continue
+		}
+
+		sale, saleError := db.GetMembershipSale(ctx, v.SaleID)
+		if saleError != nil {
+			t.Errorf("%s: %v", dbType, saleError)
+			continue
+		}
+
+		if sale.PaymentStatus != database.PaymentStatusUnverified {
+			t.Errorf("%s: want status %s got %s",
+				dbType, database.PaymentStatusUnverified, sale.PaymentStatus)
+		}
+
+		if sale.UserID != user.ID || sale.AssocUserID != assoc.ID {
+			t.Errorf("%s: want users %d and %d got %d and %d",
+				dbType, user.ID, assoc.ID, sale.UserID, sale.AssocUserID)
+		}
+
+		db.Rollback()
+
+		now := time.Now()
+
+		// Following the link shows a page with a button and doesn't use up
+		// the code, so a program that checks the link can't spoil it.
+		var pageBuffer bytes.Buffer
+		followed := verifyRequestWithMethod(ctx, h, db, http.MethodGet, codes[0], now, &pageBuffer)
+		if followed.Code != 0 {
+			t.Errorf("%s followed: want no error status got %d", dbType, followed.Code)
+		}
+		page := pageBuffer.String()
+		if !strings.Contains(page, `<form action="/verify" method="POST">`) ||
+			!strings.Contains(page, "name='code' value='"+codes[0]+"'") {
+			t.Errorf("%s: want the confirm form got\n%s", dbType, page)
+		}
+
+		db.BeginTx(ctx)
+		unused, unusedError := db.CountUnusedVerifications(ctx, v.SaleID)
+		db.Rollback()
+		if unusedError != nil || unused != 2 {
+			t.Errorf("%s: after following the link want 2 unused verifications got %d %v",
+				dbType, unused, unusedError)
+		}
+
+		// An expired link is refused.
+		expired := verifyRequest(ctx, h, db, codes[0], now.Add(25*time.Hour))
+		if expired.Code != http.StatusBadRequest {
+			t.Errorf("%s expired: want %d got %d", dbType, http.StatusBadRequest, expired.Code)
+		}
+
+		// A forged link is refused.
+		forged := verifyRequest(ctx, h, db, strings.Repeat("0", 64), now)
+		if forged.Code != http.StatusBadRequest {
+			t.Errorf("%s forged: want %d got %d", dbType, http.StatusBadRequest, forged.Code)
+		}
+
+		// The first link is accepted and the sale waits for the other member.
+		first := verifyRequest(ctx, h, db, codes[0], now)
+		if first.Code != 0 {
+			t.Errorf("%s first: want no error status got %d", dbType, first.Code)
+		}
+
+		// The first link can't be used again.
+		again := verifyRequest(ctx, h, db, codes[0], now)
+		if again.Code != http.StatusBadRequest {
+			t.Errorf("%s reused: want %d got %d", dbType, http.StatusBadRequest, again.Code)
+		}
+
+		db.BeginTx(ctx)
+
+		n, countError := db.CountUnusedVerifications(ctx, v.SaleID)
+		if countError != nil {
+			t.Errorf("%s: %v", dbType, countError)
+			continue
+		}
+
+		if n != 1 {
+			t.Errorf("%s: want 1 unused verification got %d", dbType, n)
+		}
+	}
+}
+
+// verifyRequest runs the verify helper in a new transaction with the given code,
+// posted as if the customer pressed the button on the confirm page.
+func verifyRequest(ctx context.Context, h *Handler, db *database.Database, code string, now time.Time) *TestResponseWriter {
+	var buffer bytes.Buffer
+	return verifyRequestWithMethod(ctx, h, db, http.MethodPost, code, now, &buffer)
+}
+
+// verifyRequestWithMethod runs the verify helper in a new transaction with the
+// given code, in the query of a GET or the form of a POST.  The page is
+// written to the given buffer.
+func verifyRequestWithMethod(ctx context.Context, h *Handler, db *database.Database, method, code string, now time.Time, buffer *bytes.Buffer) *TestResponseWriter {
+	db.BeginTx(ctx)
+	w := NewTestResponseWriter(buffer)
+	r := http.Request{Method: method, URL: &url.URL{}, RemoteAddr: "192.0.2.1:1234"}
+	if method == http.MethodPost {
+		r.PostForm = url.Values{"code": {code}}
+	} else {
+		r.URL.RawQuery = "code=" + code
+	}
+	h.verifyHelper(w, &r, now)
+	db.Rollback()
+	return w
+}
@@ -42,6 +42,11 @@ const paymentPageTemplateStr = `
 			&nbsp;
 		</p>
 		<form action="/subscribe/" method="POST">	
+			<input type='hidden' name='csrf_token' value='{{.CSRFToken}}'>
+			<input type='hidden' name='form_started' value='{{.FormStarted}}'>
+			<div style='position: absolute; left: -10000px;' aria-hidden='true'>
+				<label>Leave this box empty: <input type='text' name='website' value='' tabindex='-1' autocomplete='off'></label>
+			</div>
 			<table style='font-size: 100%'>
 
 				<tr>
@@ -187,6 +192,7 @@ const paymentConfirmationPageTemplate = `
 			to make the payment.
 		</p>
 		<form action="/checkout" method="POST">
+			<input type='hidden' name='csrf_token' value='{{.CSRFToken}}'>
 			<input type='hidden' name='title' value={{.Title}}>
 			<input type='hidden' name='first_name' value={{.FirstName}}>
 			<input type='hidden' name='last_name' value={{.LastName}}>
@@ -206,6 +212,30 @@ const paymentConfirmationPageTemplate = `
 		{{if .AssocFriend}}
 			<input type='hidden' name='assoc_friend' value='on'>
 		{{end}}
+		{{if .ConfirmMember}}
+			<p>
+				We may already have an account for {{.FirstName}} {{.LastName}}.
+				Have you been a member before?
+				<br>
+				<input type='radio' name='member_confirmed' value='yes' required>
+				Yes - send a link to the email address on the account so that I can confirm that it's mine
+				<br>
+				<input type='radio' name='member_confirmed' value='no'>
+				No - I am a new member
+			</p>
+		{{end}}
+		{{if .ConfirmAssocMember}}
+			<p>
+				We may already have an account for {{.AssocFirstName}} {{.AssocLastName}}.
+				Have they been a member before?
+				<br>
+				<input type='radio' name='assoc_member_confirmed' value='yes' required>
+				Yes - send a link to the email address on the account so that they can confirm that it's theirs
+				<br>
+				<input type='radio' name='assoc_member_confirmed' value='no'>
+				No - they are a new member
+			</p>
+		{{end}}
 
 			<table>
 				<tr>
@@ -287,8 +317,9 @@ const paymentConfirmationPageTemplate = `
 // the form on the first page is submitted.  The page contains a selection list
 // of countries and a selection list of member's interests which is populated
 // from the database.  To support that, the Go HTML template is put together
-// from three strings, one created on the fly containing the selection list (or
-// which is empty if there is not adm_interests table).
+// from the two strings below and some text created on the fly - the rows for the
+// profile fields, which contain the country selection list, and the interests
+// selection list (which is empty if there is not adm_interests table).
 //
 // The resulting template takes data from a MembershipSale object.  Immediately
 // after a succesful sale the PaymentStatus contains a value, which turns on the
@@ -387,6 +418,7 @@ const successPageTemplateString1 = `
 		</p>
 		<p>
 			<form action="/completion" method="POST">
+				<input type='hidden' name='csrf_token' value='{{.CSRFToken}}'>
 				<input type='hidden' name='organisation_name' value='{{.OrganisationName}}'>
 				<input type="submit" value="No Changes">
 			</form>
@@ -396,104 +428,17 @@ const successPageTemplateString1 = `
 		
 		<p>
 		<form action="/extradetails" method="POST">
-			<input type='hidden' name='account_name' value='{{.AccountName}}'>
-		<input type='hidden' name='title' value='{{.Title}}'>
-		<input type='hidden' name='first_name' value='{{.FirstName}}'>
-		<input type='hidden' name='last_name' value='{{.LastName}}'>
-		{{if gt (len .AssocAccountName) 0}}
-			<input type='hidden' name='assoc_account_name' value='{{.AssocAccountName}}'>
-			<input type='hidden' name='assoc_title' value='{{.AssocTitle}}'>
-			<input type='hidden' name='assoc_first_name' value='{{.AssocFirstName}}'>
-			<input type='hidden' name='assoc_last_name' value='{{.AssocLastName}}'>
-		{{end}}
+			<input type='hidden' name='csrf_token' value='{{.CSRFToken}}'>
+			<input type='hidden' name='token' value='{{.Token}}'>
 
 			<table style='font-size: 100%'>
-				<tr>
-					<td style='border: 0'><b>Address</b></td>
-					<td style='border: 0'>
-						<input type='text' size='40' name='address_line_1' value='{{.AddressLine1}}'>
-					</td>
-					<td style="color:red;">{{.AddressLine1Error}}</td>
-				</tr>
-				<tr>
-					<td style='border: 0'>&nbsp;</td>
-					<td style='border: 0'>
-						<input type='text' size='40' name='address_line_2' value='{{.AddressLine2}}'>
-					</td>
-					<td style="color:red;">{{.AddressLine2Error}}</td>
-				</tr>
-				<tr>
-					<td style='border: 0'>&nbsp;</td>
-					<td style='border: 0'>
-						<input type='text' size='40' name='address_line_3' value='{{.AddressLine3}}'>
-					</td>
-					<td style="color:red;">{{.AddressLine3Error}}</td>
-				</tr>
-				<tr>
-					<td style='border: 0'><b>Town</b></td>
-					<td style='border: 0'>
-						<input type='text' size='40' name='town' value='{{.Town}}'>
-					</td>
-					<td style="color:red;">{{.TownError}}</td>
-				</tr>
-				<tr>
-					<td style='border: 0'><b>County</b></td>
-					<td style='border: 0'>
-						<input type='text' size='40' name='county' value='{{.County}}'>
-					</td>
-					<td style="color:red;">{{.CountyError}}</td>
-				</tr>
-				<tr>
-					<td style='border: 0'><b>Postcode</b></td>
-					<td style='border: 0'>
-						<input type='text' size='40' name='postcode' value='{{.Postcode}}'>
-					</td>
-					<td style="color:red;">{{.PostcodeError}}</td>
-				</tr>	
 `
 
-// The country selection list is added here.
+// The rows for the profile fields (see profileRowsTemplate) are added here,
+// with successAssocMobileRow after the mobile number, followed by
+// extraFieldRows and then the interests selection list and other interests
+// box if the adm_interests table exists and contains some rows.
 const successPageTemplateString2 = `
-				<tr>
-					<td style='border: 0'><b>Landline phone number</b></td>
-					<td style='border: 0'>
-						<input type='text' size='40' name='phone' value='{{.Phone}}'>
-					</td>
-					<td style="color:red;">{{.PhoneError}}</td>
-				</tr>
-				<tr>
-					<td style='border: 0'><b>Mobile number for {{.FirstName}} {{.LastName}} </b></td>
-					<td style='border: 0'>
-						<input type='text' size='40' name='mobile' value='{{.Mobile}}'>
-					</td>
-					<td style="color:red;">{{.MobileError}}</td>
-				</tr>
-				{{if gt (len .AssocAccountName) 0}}
-				<tr>
-					<td style='border: 0'><b>Mobile number for {{.AssocFirstName}} {{.AssocLastName}}</b></td>
-					<td style='border: 0'>
-						<input type='text' size='40' name='assoc_mobile' value='{{.AssocMobile}}'>
-					</td>
-					<td style="color:red;">{{.AssocMobileError}}</td>
-				</tr>
-				{{end}}
-				<tr>
-					<td style='border: 0'>
-						<b>Parish of Interest</b>
-						<br>
-						(Ashtead, Fetcham, Bookham,
-						Leatherhead or All Areas)
-					</td>
-					<td style='border: 0'>
-						<input type='text' size='40' name='location_of_interest' value='{{.LocationOfInterest}}'>
-					</td>
-					<td style="color:red;">{{.LocationOfInterestError}}</td>
-				</tr>
-`
-
-// The interests selection list and other interests box are added here if the
-// adm_interests table exists and contains some rows.
-const successPageTemplateString3 = `
 			</table>
 			<input type="submit" value="Update">
 		</form>
@@ -502,6 +447,53 @@ const successPageTemplateString3 = `
 </html>
 `
 
+// successAssocMobileRow collects the associate member's mobile number on the
+// success page.  It follows the member's own mobile number.
+const successAssocMobileRow = `
+{{if gt (len .AssocAccountName) 0}}
+<tr>
+	<td style='border: 0'><b>Mobile number for {{.AssocFirstName}} {{.AssocLastName}}</b></td>
+	<td style='border: 0'>
+		<input type='text' size='40' name='assoc_mobile' value='{{.AssocMobile}}'>
+	</td>
+	<td style="color:red;">{{.AssocMobileError}}</td>
+</tr>
+{{end}}
+`
+
+// extraFieldRows shows the user fields from the extra fields category, if any,
+// on the success page and the account page.  Each field is shown with an input
+// to suit its type.
+const extraFieldRows = `
+{{range .ExtraFields}}
+<tr>
+	<td style='border: 0'>
+		<b>{{.Label}}</b>
+		{{if .Description}}<br>{{.Description}}{{end}}
+	</td>
+	<td style='border: 0'>
+	{{if eq .Type "CHECKBOX"}}
+		<input type='checkbox' name='{{.FormName}}' {{if .Checked}}checked{{end}}>
+	{{else if or (eq .Type "DROPDOWN") (eq .Type "RADIO_BUTTON")}}
+		<select name='{{.FormName}}'>
+			<option value=''>Choose one</option>
+		{{range .Choices}}
+			<option value='{{.Value}}' {{if .Selected}}selected{{end}}>{{.Label}}</option>
+		{{end}}
+		</select>
+	{{else if eq .Type "TEXT_BIG"}}
+		<textarea name='{{.FormName}}' rows='4' cols='40'>{{.Value}}</textarea>
+	{{else if eq .Type "DATE"}}
+		<input type='date' name='{{.FormName}}' value='{{.Value}}'>
+	{{else}}
+		<input type='text' size='40' name='{{.FormName}}' value='{{.Value}}'>
+	{{end}}
+	</td>
+	<td style="color:red;">{{.Error}}</td>
+</tr>
+{{end}}
+`
+
 // completionPageTemplate defines the page shown on completion.
 // Data is taken from a MembershipSale object.
 const completionPageTemplateString = `
@@ -577,3 +569,596 @@ const postPaymentErrorHTMLPattern = `
     </body>
 </html>
 `
+
+// csrfErrorHTMLPattern defines the page displayed when a form fails the cross-site
+// request forgery check.  That happens if the form was posted from another website,
+// but also if the customer's browser refuses cookies or they leave a page open for
+// a long time, so we explain gently.  As with the other error pages, it's expanded
+// using Sprintf.
+const csrfErrorHTMLPattern = `
+<html>
+    <head><title>form expired</title></head>
+    <body style='font-size: 100%%'>
+		<p>
+			Sorry, we couldn't accept that form.
+			This can happen if the page was left open for a long time
+			or if your browser is not accepting cookies from this website.
+		</p>
+		<p>
+			If you haven't paid yet, please
+			<a href="/subscribe">start again</a>.
+		</p>
+		<p>
+			If you have already paid, your payment is safe.
+			If you need help, please email
+			<a href="mailto:%s">
+				%s
+			</a>
+		</p>
+    </body>
+</html>
+`
+
+// blockedHTMLPattern defines the page displayed when a submission of the sale form
+// looks like abuse - too many attempts or a form filled in by a bot.  As with the
+// other error pages, it's expanded using Sprintf.
+const blockedHTMLPattern = `
+<html>
+    <head><title>please wait</title></head>
+    <body style='font-size: 100%%'>
+		<p>
+			Sorry, we couldn't accept that form just now.
+			Please wait a few minutes and then try again.
+		</p>
+		<p>
+			If the problem persists, please email
+			<a href="mailto:%s">
+				%s
+			</a>
+		</p>
+    </body>
+</html>
+`
+
+// checkEmailPageTemplateString defines the page displayed when the customer has
+// given the email address of an existing member and must prove that they own it
+// before they can pay.  Data is taken from a verificationPage object.
+const checkEmailPageTemplateString = `
+<html>
+    <head><title>check your email</title></head>
+	<body style='font-size: 100%'>
+		<h2>{{.OrganisationName}}</h2>
+		<h3>Membership Year {{.MembershipYear}}</h3>
+		<p>
+			You have given the details of an existing member.
+			To protect their account we have sent a link to the email address
+			that we hold for them:
+		</p>
+		<ul>
+		{{range .MaskedEmails}}
+			<li>{{.}}</li>
+		{{end}}
+		</ul>
+		<p>
+			Please follow the link to continue to the payment page.
+			The link can only be used once and will expire in {{.Lifetime}}.
+		</p>
+		<p>
+			If you can't get the email, please contact
+			<a href="mailto:{{.EmailAddressForQuestions}}">
+				{{.EmailAddressForQuestions}}
+			</a>
+		</p>
+	</body>
+</html>
+`
+
+// confirmVerificationPageTemplateString defines the page displayed when the
+// customer follows the link in a verification email.  The code is only used up
+// when they press the button, not by a program that follows the link to check
+// it.  Data is taken from a verificationPage object.
+const confirmVerificationPageTemplateString = `
+<html>
+    <head><title>continue to payment</title></head>
+	<body style='font-size: 100%'>
+		<h2>{{.OrganisationName}}</h2>
+		<h3>Membership Year {{.MembershipYear}}</h3>
+		<p>
+			Thank you for confirming your email address.
+			Please press the button to continue to the payment page.
+		</p>
+		<form action="/verify" method="POST">
+			<input type='hidden' name='csrf_token' value='{{.CSRFToken}}'>
+			<input type='hidden' name='code' value='{{.Code}}'>
+			<input type="submit" value="Continue">
+		</form>
+		<p>
+			If you have any questions, please contact
+			<a href="mailto:{{.EmailAddressForQuestions}}">
+				{{.EmailAddressForQuestions}}
+			</a>
+		</p>
+	</body>
+</html>
+`
+
+// verificationEmailTemplateString defines the body of the email that carries the
+// one-time verification link.  Data is taken from a verificationEmail object.
+const verificationEmailTemplateString = `Dear member,
+
+Somebody, hopefully you, has asked to pay for membership of
+{{.OrganisationName}} for the year {{.MembershipYear}} using this email address.
+
+To continue to the payment page, please follow this link:
+
+{{.Link}}
+
+The link can only be used once and will expire in {{.Lifetime}}.
+
+If you did not ask for this, you can ignore this email.  If you have any
+questions, please contact {{.EmailAddressForQuestions}}.
+`
+
+// verificationFailedHTMLPattern defines the page displayed when the link in a
+// verification email is not valid - it's been used already, it's expired or it's
+// been mangled.  As with the error pages, it's expanded using Sprintf.
+const verificationFailedHTMLPattern = `
+<html>
+    <head><title>link not valid</title></head>
+    <body style='font-size: 100%%'>
+		<p>
+			Sorry, that link is not valid.
+			It may have expired or been used already.
+		</p>
+		<p>
+			Please <a href="/subscribe">start again</a>.
+			If the problem persists, please email
+			<a href="mailto:%s">
+				%s
+			</a>
+		</p>
+    </body>
+</html>
+`
+
+// verificationWaitingHTML defines the page displayed when one of the two members
+// in a sale has followed their verification link but the other has not.
+const verificationWaitingHTML = `
+<html>
+    <head><title>waiting</title></head>
+    <body style='font-size: 100%'>
+		<p>
+			Thank you.  We have also sent a link to the other member in this sale.
+			When they follow it they will be taken to the payment page.
+		</p>
+    </body>
+</html>
+`
+
+// reviewPageHTMLPattern defines the page displayed after payment when we can't
+// tell which member accounts the sale belongs to - for example the customer gave
+// the name of an existing member but a different email address.  An administrator
+// will sort it out.  As with the error pages, it's expanded using Sprintf.
+const reviewPageHTMLPattern = `
+<html>
+    <head><title>payment received</title></head>
+    <body style='font-size: 100%%'>
+		<p>
+			Thank you for your payment.
+		</p>
+		<p>
+			The details that you gave match an existing member,
+			so one of our volunteers will check them
+			and update the membership records.
+			If you have any questions, please email
+			<a href="mailto:%s">
+				%s
+			</a>
+		</p>
+    </body>
+</html>
+`
+
+// accountLoginTemplateString defines the login page of the member's account area.
+// Data is taken from an accountLogin object.
+const accountLoginTemplateString = `
+<html>
+    <head><title>Log in</title></head>
+	<body style='font-size: 100%'>
+		<h2>{{.OrganisationName}}</h2>
+		<h3>Your membership</h3>
+		<p>
+			Log in with the user name and password that you use
+			for the membership website.
+		</p>
+		<span style="color:red;">{{.ErrorMessage}}</span>
+		<form action="/account/login" method="POST">
+			<input type='hidden' name='csrf_token' value='{{.CSRFToken}}'>
+			<table style='font-size: 100%'>
+				<tr>
+					<td style='border: 0'>User name:</td>
+					<td style='border: 0'><input type='text' size='40' name='login_name' value='{{.LoginName}}'></td>
+				</tr>
+				<tr>
+					<td style='border: 0'>Password:</td>
+					<td style='border: 0'><input type='password' size='40' name='password'></td>
+				</tr>
+			</table>
+			<input type="submit" value="Log in">
+		</form>
+	</body>
+</html>
+`
+
+// accountPageTemplateString1 starts the page that shows a logged in member their
+// membership status and payment history and lets them change their extra details.
+// As with the success page, the template is put together from two strings, with
+// the rows for the profile fields and the interests selection list inserted
+// between them.  Data is taken from an accountPage object.
+const accountPageTemplateString1 = `
+<html>
+	<head><title>Your membership</title></head>
+    <body style='font-size: 100%'>
+	<h2>{{.OrganisationName}}</h2>
+	<p>
+		Logged in as {{.AccountName}}.
+		<form action="/account/logout" method="POST">
+			<input type='hidden' name='csrf_token' value='{{.CSRFToken}}'>
+			<input type="submit" value="Log out">
+		</form>
+	</p>
+	<h3>Your membership</h3>
+	<p>{{.Status}}</p>
+	{{if .Sales}}
+	<table style='font-size: 100%'>
+		<tr>
+			<th align='left'>Year</th>
+			<th align='left'>Type</th>
+			<th align='left'>Status</th>
+			<th align='right'>Amount</th>
+		</tr>
+		{{range .Sales}}
+		<tr>
+			<td>{{.MembershipYear}}</td>
+			<td>{{.TransactionType}}</td>
+			<td>{{.PaymentStatus}}</td>
+			<td align='right'>{{.TotalForDisplay}}</td>
+		</tr>
+		{{end}}
+	</table>
+	{{else}}
+	<p>We have no record of online payments.</p>
+	{{end}}
+	<h3>Your details</h3>
+	<p style="color:green;">{{.Message}}</p>
+	<form action="/account" method="POST">
+		<input type='hidden' name='csrf_token' value='{{.CSRFToken}}'>
+		<table style='font-size: 100%'>
+`
+
+// The rows for the profile fields (see profileRowsTemplate) are added here,
+// followed by extraFieldRows, the interests selection list and other interests
+// box and then the consent and Giftaid tick boxes.
+const accountPageTemplateString2 = `
+			<tr>
+				<td style='border: 0'><b>Emails</b></td>
+				<td style='border: 0'>
+					<input type='checkbox' name='receive_email' {{if .ReceiveEmail}}checked{{end}}>
+					I am happy to receive emails from the society
+				</td>
+				<td></td>
+			</tr>
+			<tr>
+				<td style='border: 0'><b>Data protection</b></td>
+				<td style='border: 0'>
+					<input type='checkbox' name='data_protection' {{if .DataProtection}}checked{{end}}>
+					I agree to the society holding these details
+				</td>
+				<td></td>
+			</tr>
+		{{if .EnableGiftaid}}
+			<tr>
+				<td style='border: 0'><b>Gift Aid</b></td>
+				<td style='border: 0'>
+					<input type='checkbox' name='giftaid' {{if .Giftaid}}checked{{end}}>
+					I am a UK taxpayer and I want Gift Aid to be claimed on my payments
+				</td>
+				<td></td>
+			</tr>
+		{{end}}
+		</table>
+		<input type="submit" value="Save">
+	</form>
+    </body>
+</html>
+`
+
+// adminLoginTemplateString defines the login page of the administration pages.
+// Data is taken from an accountLogin object.
+const adminLoginTemplateString = `
+<html>
+    <head><title>Administration</title></head>
+	<body style='font-size: 100%'>
+		<h2>{{.OrganisationName}}</h2>
+		<h3>Administration</h3>
+		<span style="color:red;">{{.ErrorMessage}}</span>
+		<form action="/admin/login" method="POST">
+			<input type='hidden' name='csrf_token' value='{{.CSRFToken}}'>
+			<table style='font-size: 100%'>
+				<tr>
+					<td style='border: 0'>User name:</td>
+					<td style='border: 0'><input type='text' size='40' name='login_name' value='{{html .LoginName}}'></td>
+				</tr>
+				<tr>
+					<td style='border: 0'>Password:</td>
+					<td style='border: 0'><input type='password' size='40' name='password'></td>
+				</tr>
+			</table>
+			<input type="submit" value="Log in">
+		</form>
+	</body>
+</html>
+`
+
+// adminListTemplateString defines the page that lists the sales.  The sale
+// details were typed in by the public, so they are escaped.  Data is taken
+// from an adminListPage object.
+const adminListTemplateString = `
+<html>
+    <head><title>Membership sales</title></head>
+	<body style='font-size: 100%'>
+		<h2>{{.OrganisationName}}</h2>
+		<form action="/admin/logout" method="POST">
+			<input type='hidden' name='csrf_token' value='{{.CSRFToken}}'>
+			<input type="submit" value="Log out">
+		</form>
+		<h3>Membership sales</h3>
+		<p><a href='/admin/report'>Statistics</a> <a href='/admin/pool'>Database connections</a></p>
+		<form action="/admin" method="GET">
+			Year:
+			<input type='text' size='4' name='year' value='{{if .Filter.Year}}{{.Filter.Year}}{{end}}'>
+			Status:
+			<select name='status'>
+				<option value=''>any</option>
+				{{range .Statuses}}
+				<option value='{{.}}' {{if eq . $.Filter.Status}}selected{{end}}>{{.}}</option>
+				{{end}}
+			</select>
+			Payment service:
+			<select name='service'>
+				<option value=''>any</option>
+				{{range .Services}}
+				<option value='{{html .}}' {{if eq . $.Filter.PaymentService}}selected{{end}}>{{html .}}</option>
+				{{end}}
+			</select>
+			<input type="submit" value="Filter">
+		</form>
+		<p>{{len .Sales}} sales</p>
+		<table style='font-size: 100%'>
+			<tr>
+				<th align='left'>ID</th>
+				<th align='left'>Year</th>
+				<th align='left'>Status</th>
+				<th align='left'>Service</th>
+				<th align='left'>Type</th>
+				<th align='left'>Name</th>
+				<th align='left'>Email</th>
+				<th align='right'>Amount</th>
+			</tr>
+			{{range .Sales}}
+			<tr>
+				<td><a href='/admin/sale?id={{.ID}}'>{{.ID}}</a></td>
+				<td>{{.MembershipYear}}</td>
+				<td>{{html .PaymentStatus}}</td>
+				<td>{{html .PaymentService}}</td>
+				<td>{{html .TransactionType}}</td>
+				<td>{{html .FirstName}} {{html .LastName}}</td>
+				<td>{{html .Email}}</td>
+				<td align='right'>{{.TotalForDisplay}}</td>
+			</tr>
+			{{end}}
+		</table>
+	</body>
+</html>
+`
+
+// adminReportTemplateString defines the page that summarises the sales of a
+// membership year by month.  Data is taken from an adminReportPage object.
+const adminReportTemplateString = `
+<html>
+    <head><title>Statistics {{.Year}}</title></head>
+	<body style='font-size: 100%'>
+		<h2>{{.OrganisationName}}</h2>
+		<form action="/admin/logout" method="POST">
+			<input type='hidden' name='csrf_token' value='{{.CSRFToken}}'>
+			<input type="submit" value="Log out">
+		</form>
+		<p><a href='/admin'>Membership sales</a></p>
+		<h3>Completed sales for the membership year {{.Year}}</h3>
+		<form action="/admin/report" method="GET">
+			Year:
+			<input type='text' size='4' name='year' value='{{.Year}}'>
+			<input type="submit" value="Show">
+		</form>
+		<p>
+			Download as
+			<a href='/admin/report?year={{.Year}}&format=csv'>CSV</a> or
+			<a href='/admin/report?year={{.Year}}&format=json'>JSON</a>
+		</p>
+		<table style='font-size: 100%'>
+			<tr>
+				<th align='left'>Month</th>
+				<th align='right'>Sales</th>
+				<th align='right'>New members</th>
+				<th align='right'>Renewals</th>
+				<th align='right'>Associates</th>
+				<th align='right'>Friends</th>
+				<th align='right'>Ordinary fees</th>
+				<th align='right'>Associate fees</th>
+				<th align='right'>Friend fees</th>
+				<th align='right'>Donations to society</th>
+				<th align='right'>Donations to museum</th>
+				<th align='right'>Gift Aid sales</th>
+				<th align='right'>Gift Aid total</th>
+				<th align='right'>Total</th>
+			</tr>
+			{{range .Rows}}
+			<tr>
+				<td>{{.Name}}</td>
+				<td align='right'>{{.Sales}}</td>
+				<td align='right'>{{.NewMembers}}</td>
+				<td align='right'>{{.Renewals}}</td>
+				<td align='right'>{{.Associates}}</td>
+				<td align='right'>{{.Friends}}</td>
+				<td align='right'>{{printf "%.2f" .OrdinaryMemberFees}}</td>
+				<td align='right'>{{printf "%.2f" .AssociateMemberFees}}</td>
+				<td align='right'>{{printf "%.2f" .FriendFees}}</td>
+				<td align='right'>{{printf "%.2f" .DonationsToSociety}}</td>
+				<td align='right'>{{printf "%.2f" .DonationsToMuseum}}</td>
+				<td align='right'>{{.GiftaidSales}}</td>
+				<td align='right'>{{printf "%.2f" .GiftaidTotal}}</td>
+				<td align='right'>{{printf "%.2f" .Total}}</td>
+			</tr>
+			{{end}}
+		</table>
+	</body>
+</html>
+`
+
+// adminPoolTemplateString defines the page that shows the statistics of the
+// pool of database connections.  Data is taken from an adminPoolPage object.
+const adminPoolTemplateString = `
+<html>
+    <head><title>Database connections</title></head>
+	<body style='font-size: 100%'>
+		<h2>{{.OrganisationName}}</h2>
+		<form action="/admin/logout" method="POST">
+			<input type='hidden' name='csrf_token' value='{{.CSRFToken}}'>
+			<input type="submit" value="Log out">
+		</form>
+		<p><a href='/admin'>Membership sales</a></p>
+		<h3>Database connections</h3>
+		<table style='font-size: 100%'>
+			<tr><td>Most connections open at once</td><td align='right'>{{.Stats.MaxOpenConnections}}</td></tr>
+			<tr><td>Most idle connections kept</td><td align='right'>{{.MaxIdleConns}}</td></tr>
+			<tr><td>Connection lifetime</td><td align='right'>{{.ConnMaxLifetime}}</td></tr>
+			<tr><td>Idle connection lifetime</td><td align='right'>{{.ConnMaxIdleTime}}</td></tr>
+			<tr><td>Open connections</td><td align='right'>{{.Stats.OpenConnections}}</td></tr>
+			<tr><td>In use</td><td align='right'>{{.Stats.InUse}}</td></tr>
+			<tr><td>Idle</td><td align='right'>{{.Stats.Idle}}</td></tr>
+			<tr><td>Waits for a connection</td><td align='right'>{{.Stats.WaitCount}}</td></tr>
+			<tr><td>Total time waiting</td><td align='right'>{{.Stats.WaitDuration}}</td></tr>
+			<tr><td>Closed - too many idle</td><td align='right'>{{.Stats.MaxIdleClosed}}</td></tr>
+			<tr><td>Closed - idle too long</td><td align='right'>{{.Stats.MaxIdleTimeClosed}}</td></tr>
+			<tr><td>Closed - lifetime over</td><td align='right'>{{.Stats.MaxLifetimeClosed}}</td></tr>
+		</table>
+	</body>
+</html>
+`
+
+// adminSaleTemplateString defines the page that shows a sale, the member
+// accounts that it refers to, its reviews and notes and the actions that can be
+// applied to it.  Data is taken from an adminSalePage object.
+const adminSaleTemplateString = `
+<html>
+    <head><title>Sale {{.Sale.ID}}</title></head>
+	<body style='font-size: 100%'>
+		<h2>{{.OrganisationName}}</h2>
+		<p><a href='/admin'>All sales</a></p>
+		<h3>Sale {{.Sale.ID}}</h3>
+		<table style='font-size: 100%'>
+			<tr><td>Year</td><td>{{.Sale.MembershipYear}}</td></tr>
+			<tr><td>Status</td><td>{{html .Sale.PaymentStatus}}</td></tr>
+			<tr><td>Payment service</td><td>{{html .Sale.PaymentService}}</td></tr>
+			<tr><td>Payment ID</td><td>{{html .Sale.PaymentID}}</td></tr>
+			<tr><td>Type</td><td>{{html .Sale.TransactionType}}</td></tr>
+			<tr><td>Name</td><td>{{html .Sale.Title}} {{html .Sale.FirstName}} {{html .Sale.LastName}}</td></tr>
+			<tr><td>Email</td><td>{{html .Sale.Email}}</td></tr>
+			<tr><td>Ordinary member fee</td><td>{{.Sale.OrdinaryMemberFeePaid}}</td></tr>
+			<tr><td>Friend</td><td>{{.Sale.Friend}} ({{.Sale.FriendFeePaid}})</td></tr>
+			{{if .Sale.AssocFirstName}}
+			<tr><td>Associate</td><td>{{html .Sale.AssocTitle}} {{html .Sale.AssocFirstName}} {{html .Sale.AssocLastName}}</td></tr>
+			<tr><td>Associate email</td><td>{{html .Sale.AssocEmail}}</td></tr>
+			<tr><td>Associate fee</td><td>{{.Sale.AssocFeePaid}}</td></tr>
+			<tr><td>Associate friend</td><td>{{.Sale.AssocFriend}} ({{.Sale.AssocFriendFeePaid}})</td></tr>
+			{{end}}
+			<tr><td>Donation to the society</td><td>{{.Sale.DonationToSociety}}</td></tr>
+			<tr><td>Donation to the museum</td><td>{{.Sale.DonationToMuseum}}</td></tr>
+			<tr><td>Gift Aid</td><td>{{.Sale.Giftaid}}</td></tr>
+			<tr><td>Total</td><td>{{.Sale.TotalForDisplay}}</td></tr>
+		</table>
+		<h3>Members</h3>
+		{{if .Members}}
+		<table style='font-size: 100%'>
+			<tr>
+				<th align='left'></th>
+				<th align='left'>User ID</th>
+				<th align='left'>Login name</th>
+				<th align='left'>Name</th>
+				<th align='left'>Email</th>
+				<th align='left'>Paid up to</th>
+			</tr>
+			{{range .Members}}
+			<tr>
+				<td>{{.Role}}</td>
+				<td>{{.ID}}</td>
+				<td>{{html .LoginName}}</td>
+				<td>{{html .FirstName}} {{html .LastName}}</td>
+				<td>{{html .Email}}</td>
+				<td>{{if .EndYear}}{{.EndYear}}{{end}}</td>
+			</tr>
+			{{end}}
+		</table>
+		{{else}}
+		<p>The sale is not linked to any member accounts.</p>
+		{{end}}
+		{{if .Reviews}}
+		<h3>Reviews</h3>
+		<ul>
+			{{range .Reviews}}
+			<li>{{.Status}}: {{html .Reason}}</li>
+			{{end}}
+		</ul>
+		{{end}}
+		<h3>Notes</h3>
+		<ul>
+			{{range .Notes}}
+			<li>{{.Created.Format "2006-01-02 15:04"}} {{html .LoginName}}: {{html .Note}}</li>
+			{{end}}
+		</ul>
+		<form action="/admin/sale" method="POST">
+			<input type='hidden' name='csrf_token' value='{{.CSRFToken}}'>
+			<input type='hidden' name='id' value='{{.Sale.ID}}'>
+			<input type='hidden' name='action' value='annotate'>
+			<textarea name='note' rows='3' cols='60'></textarea>
+			<br>
+			<input type="submit" value="Add note">
+		</form>
+		{{if .CanComplete}}
+		<form action="/admin/sale" method="POST">
+			<input type='hidden' name='csrf_token' value='{{.CSRFToken}}'>
+			<input type='hidden' name='id' value='{{.Sale.ID}}'>
+			<input type='hidden' name='action' value='complete'>
+			{{if .NeedsAccounts}}
+			<p>The sale is not linked to any member accounts.  Give the user IDs of the
+			accounts that it belongs to or tick the box to create new ones.</p>
+			<table style='font-size: 100%'>
+				<tr><td>Ordinary member user ID</td><td><input type='text' name='user_id'></td></tr>
+				<tr><td>Associate member user ID</td><td><input type='text' name='assoc_user_id'></td></tr>
+				<tr><td>Create new accounts</td><td><input type='checkbox' name='new_accounts' value='yes'></td></tr>
+			</table>
+			{{end}}
+			<input type="submit" value="Mark complete">
+		</form>
+		{{end}}
+		{{if .CanCancel}}
+		<form action="/admin/sale" method="POST">
+			<input type='hidden' name='csrf_token' value='{{.CSRFToken}}'>
+			<input type='hidden' name='id' value='{{.Sale.ID}}'>
+			<input type='hidden' name='action' value='cancel'>
+			<input type="submit" value="Cancel sale">
+		</form>
+		{{end}}
+	</body>
+</html>
+`
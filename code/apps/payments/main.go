@@ -31,6 +31,7 @@
 package main
 
 import (
+	"context"
 	"crypto/tls"
 	"errors"
 	"fmt"
@@ -39,6 +40,7 @@ import (
 	"os"
 	"os/user"
 	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
@@ -49,6 +51,7 @@ import (
 
 	"github.com/goblimey/go-stripe-payments/code/apps/payments/handler"
 	"github.com/goblimey/go-stripe-payments/code/pkg/config"
+	"github.com/goblimey/go-stripe-payments/code/pkg/csrf"
 	"github.com/goblimey/go-stripe-payments/code/pkg/database"
 	"github.com/goblimey/go-stripe-payments/code/pkg/shutdown"
 )
@@ -69,13 +72,17 @@ func main() {
 	// The stripe secret key.
 	stripe.Key = conf.StripeSecretKey
 
-	dbConfig := database.DBConfig{
-		Type: conf.DBType,
-		Host: conf.DBHostname,
-		Port: conf.DBPort,
-		Name: conf.DBDatabase,
-		User: conf.DBUser,
-		Pass: conf.DBPassword,
+	// The pages after payment are linked by a signed token.  Without a secret
+	// anybody could forge one.
+	if len(conf.TokenSecret) == 0 {
+		fmt.Println("the TokenSecret environment variable must be set")
+		os.Exit(-1)
+	}
+
+	// Existing members must verify their email address before they can renew.
+	if len(conf.SMTPHost) == 0 || len(conf.SMTPFrom) == 0 {
+		fmt.Println("smtp_host and smtp_from must be set in the config file")
+		os.Exit(-1)
 	}
 
 	hdlr := handler.New(conf)
@@ -84,28 +91,52 @@ func main() {
 	// want to have be root to read the log, so set it owned as the target user.
 	hdlr.Logger = GetDailyLogger(conf)
 
+	// The pages that display or accept forms are protected against cross-site
+	// request forgery.  The cookie is marked secure unless we are running HTTP
+	// under Windows.
+	protector := csrf.New(conf.TokenSecret, ps.OSName != "windows", hdlr.CSRFErrorHTML, hdlr.Logger)
+
 	http.HandleFunc("/", hdlr.Home)
 	http.HandleFunc("/index.html", hdlr.Home)
-	http.HandleFunc("/subscribe", hdlr.GetPaymentData)
-	http.HandleFunc("/subscribe/", hdlr.GetPaymentData)
-	http.HandleFunc("/checkout", hdlr.Checkout)
-	http.HandleFunc("/success", hdlr.Success)
-	http.HandleFunc("/extradetails", hdlr.ExtraDetails)
-	http.HandleFunc("/completion", hdlr.Completion)
+	http.HandleFunc("/subscribe", protector.Protect(hdlr.GetPaymentData))
+	http.HandleFunc("/subscribe/", protector.Protect(hdlr.GetPaymentData))
+	http.HandleFunc("/checkout", protector.Protect(hdlr.Checkout))
+	http.HandleFunc("/verify", protector.Protect(hdlr.Verify))
+	http.HandleFunc("/success", protector.Protect(hdlr.Success))
+	http.HandleFunc("/extradetails", protector.Protect(hdlr.ExtraDetails))
+	http.HandleFunc("/completion", protector.Protect(hdlr.Completion))
+	http.HandleFunc("/account", protector.Protect(hdlr.Account))
+	http.HandleFunc("/account/login", protector.Protect(hdlr.AccountLogin))
+	http.HandleFunc("/account/logout", protector.Protect(hdlr.AccountLogout))
+	http.HandleFunc("/admin", protector.Protect(hdlr.Admin))
+	http.HandleFunc("/admin/login", protector.Protect(hdlr.AdminLogin))
+	http.HandleFunc("/admin/logout", protector.Protect(hdlr.AdminLogout))
+	http.HandleFunc("/admin/sale", protector.Protect(hdlr.AdminSale))
+	http.HandleFunc("/admin/report", protector.Protect(hdlr.AdminReport))
+	http.HandleFunc("/admin/pool", protector.Protect(hdlr.AdminPool))
 	http.HandleFunc("/cancel", hdlr.Cancel)
 	http.HandleFunc("/create-checkout-session", hdlr.CreateCheckoutSession)
 	// Backward compatibility:
-	http.HandleFunc("/displayPaymentForm", hdlr.GetPaymentData)
-	http.HandleFunc("/displayPaymentForm/", hdlr.GetPaymentData)
-
-	// Run a test connection to the database.
-	db := database.New(&dbConfig)
-	connectError := db.Connect()
-	if connectError != nil {
-		hdlr.Fatal(connectError)
+	http.HandleFunc("/displayPaymentForm", protector.Protect(hdlr.GetPaymentData))
+	http.HandleFunc("/displayPaymentForm/", protector.Protect(hdlr.GetPaymentData))
+
+	// Open the pool of database connections that the requests share and check
+	// that the database has the user fields, roles and tables that we need.
+	// If not, a sale would fail after the customer had paid.
+	hdlr.DBConfig.Logger = hdlr.Logger
+	pool, poolError := database.OpenPool(hdlr.DBConfig)
+	if poolError != nil {
+		hdlr.Fatal(poolError)
+		return
+	}
+	hdlr.Pool = pool
+	hdlr.Logger.Info("Admidio version " + pool.Version)
+	checkError := checkDatabase(pool)
+	if checkError != nil {
+		fmt.Println(checkError.Error())
+		hdlr.Fatal(checkError)
 		return
 	}
-	db.Close()
 
 	if ps.OSName == "windows" {
 		// The web server is running under Windows.  No TLS certificate files are supplied
@@ -259,6 +290,33 @@ func main() {
 	// Either way we never get to here.
 }
 
+// checkDatabase checks that the database has everything that the server needs.
+// It returns an error listing the problems, if any.
+func checkDatabase(pool *database.Pool) error {
+	ctx := context.Background()
+
+	db, borrowError := pool.Borrow(ctx)
+	if borrowError != nil {
+		return borrowError
+	}
+	// The check creates the schema_migrations table if it doesn't exist.  It
+	// doesn't change anything else, so Close just rolls back.
+	defer db.Close()
+
+	problems, checkError := db.CheckSchema(ctx)
+	if checkError != nil {
+		return checkError
+	}
+
+	if len(problems) > 0 {
+		em := fmt.Sprintf("the database is not set up - %s - run \"members bootstrap\" to fix it",
+			strings.Join(problems, ", "))
+		return errors.New(em)
+	}
+
+	return nil
+}
+
 // GetDailyLogger gets a daily log file which can be written to as a logger
 // (each line decorated with filename, date, time, etc).  The name argument
 // is used to form the log file name.
@@ -6,28 +6,53 @@ import (
 	"io"
 	"os"
 	"strconv"
+	"time"
 )
 
 // Config holds the configuration.
 type Config struct {
 	// These config values are taken from the given config file.
-	RunUser                  string  `json:"run_user"`                    // The name of the non-root user that will run the server.
-	LogDir                   string  `json:"log_dir"`                     // The directory in which the daily log is created.
-	LogFileGroup             string  `json:"logfile_group"`               // The group that the log file will be in.
-	LogDirPermissions        string  `json:"logdir_permissions"`          // The permissions on the directory containing the log files, an int in octal as a string, eg "0700".
-	LogFilePermissions       string  `json:"logfile_permissions"`         // The permission bits for the logfile, an int in octal as a string, eg "0600".
-	LogLeader                string  `json:"log_leader"`                  // The first part of the log file name.
-	LogTrailer               string  `json:"log_trailer"`                 // The last part of the log file name.
-	TLSCertificateFile       string  `json:"tls_certificate_file"`        // The TLS certificate file.
-	TLSCertificateKeyFile    string  `json:"tls_certificate_key_file"`    // the secret TLS key file.
-	OrganisationName         string  `json:"organisation_name"`           // The name of the organisation for display
-	EnableOtherMemberTypes   bool    `json:"enable_other_member_types"`   // Enable associate members, friends etc.
-	EnableGiftaid            bool    `json:"enable_giftaid"`              // Enable Giftaid.
-	EmailAddressForQuestions string  `json:"email_address_for_questions"` // Email address for questions.
-	EmailAddressForFailures  string  `json:"email_address_for_failures"`  // Email address for payment failure messages.
-	OrdinaryMemberFee        float64 `json:"ordinary_member_fee"`         // Ordinary membership fee.
-	AssocMemberFee           float64 `json:"associate_member_fee"`        // Associate membership system.
-	FriendFee                float64 `json:"friend_fee"`                  // Friend of the museum fee.
+	RunUser                  string  `json:"run_user"`                       // The name of the non-root user that will run the server.
+	LogDir                   string  `json:"log_dir"`                        // The directory in which the daily log is created.
+	LogFileGroup             string  `json:"logfile_group"`                  // The group that the log file will be in.
+	LogDirPermissions        string  `json:"logdir_permissions"`             // The permissions on the directory containing the log files, an int in octal as a string, eg "0700".
+	LogFilePermissions       string  `json:"logfile_permissions"`            // The permission bits for the logfile, an int in octal as a string, eg "0600".
+	LogLeader                string  `json:"log_leader"`                     // The first part of the log file name.
+	LogTrailer               string  `json:"log_trailer"`                    // The last part of the log file name.
+	TLSCertificateFile       string  `json:"tls_certificate_file"`           // The TLS certificate file.
+	TLSCertificateKeyFile    string  `json:"tls_certificate_key_file"`       // the secret TLS key file.
+	OrganisationName         string  `json:"organisation_name"`              // The name of the organisation for display
+	EnableOtherMemberTypes   bool    `json:"enable_other_member_types"`      // Enable associate members, friends etc.
+	EnableGiftaid            bool    `json:"enable_giftaid"`                 // Enable Giftaid.
+	EmailAddressForQuestions string  `json:"email_address_for_questions"`    // Email address for questions.
+	EmailAddressForFailures  string  `json:"email_address_for_failures"`     // Email address for payment failure messages.
+	OrdinaryMemberFee        float64 `json:"ordinary_member_fee"`            // Ordinary membership fee.
+	AssocMemberFee           float64 `json:"associate_member_fee"`           // Associate membership system.
+	FriendFee                float64 `json:"friend_fee"`                     // Friend of the museum fee.
+	TokenLifetimeMinutes     int     `json:"token_lifetime_minutes"`         // How long the token issued on the success page is valid.
+	RateLimitPerIP           int     `json:"rate_limit_per_ip"`              // Sale form submissions allowed from one IP address per window (default 20).
+	RateLimitPerEmail        int     `json:"rate_limit_per_email"`           // Sale form submissions allowed for one email address per window (default 5).
+	RateLimitWindowMinutes   int     `json:"rate_limit_window_minutes"`      // The rate limiting window (default 60).
+	LoginFailuresAllowed     int     `json:"login_failures_allowed"`         // Failed logins allowed for one user name per lockout window (default 5).
+	LoginLockoutMinutes      int     `json:"login_lockout_minutes"`          // The window for counting failed logins (default 15).
+	MinSecondsToSubmit       int     `json:"min_seconds_to_submit"`          // A sale form submitted faster than this is from a bot (default 3).
+	MaxFormAgeMinutes        int     `json:"max_form_age_minutes"`           // A sale form submitted longer than this after it was displayed is refused (default 240).
+	VerificationHours        int     `json:"verification_hours"`             // How long an email verification link is valid (default 24).
+	SMTPHost                 string  `json:"smtp_host"`                      // The mail server used to send verification emails.
+	SMTPPort                 string  `json:"smtp_port"`                      // The mail server's port (default 587).
+	SMTPFrom                 string  `json:"smtp_from"`                      // The sender address of verification emails.
+	SessionLifetimeMinutes   int     `json:"session_lifetime_minutes"`       // A login session ends after this long without a request (default 30).
+	DBMaxOpenConnections     int     `json:"db_max_open_connections"`        // The most database connections open at once (default 10).
+	DBMaxIdleConnections     int     `json:"db_max_idle_connections"`        // The most idle database connections kept open (default 5, negative for none).
+	DBConnLifetimeMinutes    int     `json:"db_connection_lifetime_minutes"` // A database connection is closed after this long (default 30).
+	DBConnIdleMinutes        int     `json:"db_connection_idle_minutes"`     // An idle database connection is closed after this long (default 5).
+	DBTimeoutSeconds         int     `json:"db_timeout_seconds"`             // A request's database work is abandoned after this long (default 30).
+	ExtraFieldsCategory      string  `json:"extra_fields_category"`          // The internal name of the Admidio category of user fields shown on the details forms (none if empty).
+	SystemUser               string  `json:"system_user"`                    // The login name of the user that changes are attributed to in the change history (default "System").
+	DBTablePrefix            string  `json:"db_table_prefix"`                // Admidio's table prefix, g_tbl_praefix in its config.php (default "adm").
+	OrdinaryMemberRole       string  `json:"ordinary_member_role"`           // The Admidio role given to ordinary members (default "Member").
+	AssocMemberRole          string  `json:"associate_member_role"`          // The Admidio role given to associate members (default "Member").
+	FriendRole               string  `json:"friend_role"`                    // The Admidio role given to friends of the museum (none if empty).
 
 	// Secrets are taken from the environment.
 	StripeSecretKey string
@@ -37,8 +62,12 @@ type Config struct {
 	DBHostname      string
 	DBPort          string
 	DBDatabase      string
+	DBPath          string
 	DBUser          string
 	DBPassword      string
+	TokenSecret     string
+	SMTPUser        string
+	SMTPPassword    string
 	Address         string
 }
 
@@ -64,6 +93,163 @@ func (conf *Config) LogFileMode() (os.FileMode, error) {
 	return os.FileMode(mode), err
 }
 
+// TokenLifetime gets the lifetime of the token issued on the success page.  If
+// it's not configured, the default is two hours.
+func (conf *Config) TokenLifetime() time.Duration {
+	if conf.TokenLifetimeMinutes <= 0 {
+		return 2 * time.Hour
+	}
+	return time.Duration(conf.TokenLifetimeMinutes) * time.Minute
+}
+
+// IPRateLimit gets the number of sale form submissions allowed from one IP
+// address in each rate limiting window.  The default is 20.
+func (conf *Config) IPRateLimit() int {
+	if conf.RateLimitPerIP <= 0 {
+		return 20
+	}
+	return conf.RateLimitPerIP
+}
+
+// EmailRateLimit gets the number of sale form submissions allowed for one email
+// address in each rate limiting window.  The default is 5.
+func (conf *Config) EmailRateLimit() int {
+	if conf.RateLimitPerEmail <= 0 {
+		return 5
+	}
+	return conf.RateLimitPerEmail
+}
+
+// RateLimitWindow gets the length of the rate limiting window.  The default is
+// one hour.
+func (conf *Config) RateLimitWindow() time.Duration {
+	if conf.RateLimitWindowMinutes <= 0 {
+		return time.Hour
+	}
+	return time.Duration(conf.RateLimitWindowMinutes) * time.Minute
+}
+
+// LoginFailureLimit gets the number of failed logins allowed for one user name
+// in each lockout window.  The default is 5.
+func (conf *Config) LoginFailureLimit() int {
+	if conf.LoginFailuresAllowed <= 0 {
+		return 5
+	}
+	return conf.LoginFailuresAllowed
+}
+
+// LoginLockout gets the length of the window in which failed logins are
+// counted.  The default is fifteen minutes.
+func (conf *Config) LoginLockout() time.Duration {
+	if conf.LoginLockoutMinutes <= 0 {
+		return 15 * time.Minute
+	}
+	return time.Duration(conf.LoginLockoutMinutes) * time.Minute
+}
+
+// MinTimeToSubmit gets the shortest time that a human could take to fill in the
+// sale form.  The default is three seconds.
+func (conf *Config) MinTimeToSubmit() time.Duration {
+	if conf.MinSecondsToSubmit <= 0 {
+		return 3 * time.Second
+	}
+	return time.Duration(conf.MinSecondsToSubmit) * time.Second
+}
+
+// MaxFormAge gets the longest time that may pass between displaying the sale
+// form and submitting it.  After that the signed timestamp in the form is
+// refused, so a harvested timestamp can't be replayed forever.  The default is
+// four hours.
+func (conf *Config) MaxFormAge() time.Duration {
+	if conf.MaxFormAgeMinutes <= 0 {
+		return 4 * time.Hour
+	}
+	return time.Duration(conf.MaxFormAgeMinutes) * time.Minute
+}
+
+// VerificationLifetime gets how long the link in an email verification message
+// is valid.  The default is 24 hours.
+func (conf *Config) VerificationLifetime() time.Duration {
+	if conf.VerificationHours <= 0 {
+		return 24 * time.Hour
+	}
+	return time.Duration(conf.VerificationHours) * time.Hour
+}
+
+// SMTPAddress gets the address of the mail server as "host:port".  The default
+// port is 587.
+func (conf *Config) SMTPAddress() string {
+	port := conf.SMTPPort
+	if len(port) == 0 {
+		port = "587"
+	}
+	return conf.SMTPHost + ":" + port
+}
+
+// SessionLifetime gets how long a login session lasts without a request.  The
+// default is 30 minutes.
+func (conf *Config) SessionLifetime() time.Duration {
+	if conf.SessionLifetimeMinutes <= 0 {
+		return 30 * time.Minute
+	}
+	return time.Duration(conf.SessionLifetimeMinutes) * time.Minute
+}
+
+// DBMaxOpenConns gets the largest number of connections that the pool opens to
+// the database at once.  The default is 10.
+func (conf *Config) DBMaxOpenConns() int {
+	if conf.DBMaxOpenConnections <= 0 {
+		return 10
+	}
+	return conf.DBMaxOpenConnections
+}
+
+// DBMaxIdleConns gets the largest number of idle connections that the pool
+// keeps open.  It's never more than DBMaxOpenConns.  The default is 5.  A
+// negative value is passed through, which means that the pool keeps none (see
+// database.DBConfig).
+func (conf *Config) DBMaxIdleConns() int {
+	idle := conf.DBMaxIdleConnections
+	if idle < 0 {
+		return idle
+	}
+	if idle == 0 {
+		idle = 5
+	}
+	if idle > conf.DBMaxOpenConns() {
+		return conf.DBMaxOpenConns()
+	}
+	return idle
+}
+
+// DBConnMaxLifetime gets how long the pool uses a database connection before
+// replacing it.  The default is 30 minutes.
+func (conf *Config) DBConnMaxLifetime() time.Duration {
+	if conf.DBConnLifetimeMinutes <= 0 {
+		return 30 * time.Minute
+	}
+	return time.Duration(conf.DBConnLifetimeMinutes) * time.Minute
+}
+
+// DBConnMaxIdleTime gets how long a database connection can sit idle in the
+// pool before it's closed.  The default is five minutes.
+func (conf *Config) DBConnMaxIdleTime() time.Duration {
+	if conf.DBConnIdleMinutes <= 0 {
+		return 5 * time.Minute
+	}
+	return time.Duration(conf.DBConnIdleMinutes) * time.Minute
+}
+
+// DBTimeout gets how long a request may spend on its database work, including
+// waiting for a connection from the pool.  After that the work is abandoned
+// and the transaction is rolled back.  The default is 30 seconds.
+func (conf *Config) DBTimeout() time.Duration {
+	if conf.DBTimeoutSeconds <= 0 {
+		return 30 * time.Second
+	}
+	return time.Duration(conf.DBTimeoutSeconds) * time.Second
+}
+
 // GetConfig gets the config from the given file.
 func GetConfig(configFile string) (*Config, error) {
 	file, err := os.Open(configFile)
@@ -126,10 +312,17 @@ func parseConfigFromBytes(data []byte) (*Config, error) {
 	config.DBPort = os.Getenv("DBPort")
 	// The database (schema).
 	config.DBDatabase = os.Getenv("DBDatabase")
+	// The SQLite database file.
+	config.DBPath = os.Getenv("DBPath")
 	// The database user.
 	config.DBUser = os.Getenv("DBUser")
 	// The database password.
 	config.DBPassword = os.Getenv("DBPassword")
+	// The secret used to sign the tokens that carry state between pages.
+	config.TokenSecret = os.Getenv("TokenSecret")
+	// The credentials for the mail server.
+	config.SMTPUser = os.Getenv("SMTPUser")
+	config.SMTPPassword = os.Getenv("SMTPPassword")
 
 	// The address of this web server is "hostname:port".
 	config.Address = config.Hostname + ":" + config.Port // Accept requests to this name.
@@ -3,6 +3,7 @@ package config
 import (
 	"os"
 	"testing"
+	"time"
 
 	"github.com/goblimey/go-tools/testsupport"
 )
@@ -34,7 +35,30 @@ func TestParseConfig(t *testing.T) {
 			"stripe_secret_key": "foo",
 			"ordinary_member_fee": 1.1,
 			"associate_member_fee": 2.2,
-			"friend_fee": 3.3
+			"friend_fee": 3.3,
+			"token_lifetime_minutes": 30,
+			"rate_limit_per_ip": 7,
+			"rate_limit_per_email": 2,
+			"rate_limit_window_minutes": 10,
+			"login_failures_allowed": 3,
+			"login_lockout_minutes": 20,
+			"min_seconds_to_submit": 4,
+			"max_form_age_minutes": 90,
+			"verification_hours": 12,
+			"smtp_host": "mail.example.com",
+			"smtp_from": "membership@example.com",
+			"session_lifetime_minutes": 15,
+			"db_max_open_connections": 4,
+			"db_max_idle_connections": 6,
+			"db_connection_lifetime_minutes": 20,
+			"db_connection_idle_minutes": 2,
+			"db_timeout_seconds": 12,
+			"extra_fields_category": "VOLUNTEERING",
+			"system_user": "Membership",
+			"db_table_prefix": "soc",
+			"ordinary_member_role": "Ordinary Member",
+			"associate_member_role": "Associate Member",
+			"friend_role": "Friend of the Museum"
 		}
 	`)
 
@@ -48,6 +72,9 @@ func TestParseConfig(t *testing.T) {
 	os.Setenv("DBDatabase", "db")
 	os.Setenv("DBUser", "me")
 	os.Setenv("DBPassword", "pw")
+	os.Setenv("TokenSecret", "ts")
+	os.Setenv("SMTPUser", "su")
+	os.Setenv("SMTPPassword", "sp")
 
 	conf, err := parseConfigFromBytes(json)
 
@@ -163,6 +190,113 @@ func TestParseConfig(t *testing.T) {
 	if conf.FriendFee != 3.3 {
 		t.Errorf("want 3.3, got %f", conf.FriendFee)
 	}
+
+	if conf.TokenSecret != "ts" {
+		t.Errorf("want ts, got %s", conf.TokenSecret)
+	}
+
+	if conf.TokenLifetime() != 30*time.Minute {
+		t.Errorf("want 30m got %v", conf.TokenLifetime())
+	}
+
+	if conf.IPRateLimit() != 7 {
+		t.Errorf("want 7 got %d", conf.IPRateLimit())
+	}
+
+	if conf.EmailRateLimit() != 2 {
+		t.Errorf("want 2 got %d", conf.EmailRateLimit())
+	}
+
+	if conf.RateLimitWindow() != 10*time.Minute {
+		t.Errorf("want 10m got %v", conf.RateLimitWindow())
+	}
+
+	if conf.LoginFailureLimit() != 3 {
+		t.Errorf("want 3 got %d", conf.LoginFailureLimit())
+	}
+
+	if conf.LoginLockout() != 20*time.Minute {
+		t.Errorf("want 20m got %v", conf.LoginLockout())
+	}
+
+	if conf.MinTimeToSubmit() != 4*time.Second {
+		t.Errorf("want 4s got %v", conf.MinTimeToSubmit())
+	}
+
+	if conf.MaxFormAge() != 90*time.Minute {
+		t.Errorf("want 1h30m got %v", conf.MaxFormAge())
+	}
+
+	if conf.VerificationLifetime() != 12*time.Hour {
+		t.Errorf("want 12h got %v", conf.VerificationLifetime())
+	}
+
+	if conf.SMTPAddress() != "mail.example.com:587" {
+		t.Errorf("want mail.example.com:587 got %s", conf.SMTPAddress())
+	}
+
+	if conf.SMTPFrom != "membership@example.com" {
+		t.Errorf("want membership@example.com got %s", conf.SMTPFrom)
+	}
+
+	if conf.SessionLifetime() != 15*time.Minute {
+		t.Errorf("want 15m got %v", conf.SessionLifetime())
+	}
+
+	if conf.DBMaxOpenConns() != 4 {
+		t.Errorf("want 4 got %d", conf.DBMaxOpenConns())
+	}
+
+	// There can't be more idle connections than open ones.
+	if conf.DBMaxIdleConns() != 4 {
+		t.Errorf("want 4 got %d", conf.DBMaxIdleConns())
+	}
+
+	// A negative number means no idle connections and is passed through.
+	noIdle := Config{DBMaxIdleConnections: -1}
+	if noIdle.DBMaxIdleConns() != -1 {
+		t.Errorf("want -1 got %d", noIdle.DBMaxIdleConns())
+	}
+
+	if conf.DBConnMaxLifetime() != 20*time.Minute {
+		t.Errorf("want 20m got %v", conf.DBConnMaxLifetime())
+	}
+
+	if conf.DBConnMaxIdleTime() != 2*time.Minute {
+		t.Errorf("want 2m got %v", conf.DBConnMaxIdleTime())
+	}
+
+	if conf.DBTimeout() != 12*time.Second {
+		t.Errorf("want 12s got %v", conf.DBTimeout())
+	}
+
+	if conf.ExtraFieldsCategory != "VOLUNTEERING" {
+		t.Errorf("want VOLUNTEERING got %s", conf.ExtraFieldsCategory)
+	}
+
+	if conf.SystemUser != "Membership" {
+		t.Errorf("want Membership got %s", conf.SystemUser)
+	}
+
+	if conf.DBTablePrefix != "soc" {
+		t.Errorf("want soc got %s", conf.DBTablePrefix)
+	}
+
+	if conf.OrdinaryMemberRole != "Ordinary Member" {
+		t.Errorf("want Ordinary Member got %s", conf.OrdinaryMemberRole)
+	}
+
+	if conf.AssocMemberRole != "Associate Member" {
+		t.Errorf("want Associate Member got %s", conf.AssocMemberRole)
+	}
+
+	if conf.FriendRole != "Friend of the Museum" {
+		t.Errorf("want Friend of the Museum got %s", conf.FriendRole)
+	}
+
+	if conf.SMTPUser != "su" || conf.SMTPPassword != "sp" {
+		t.Errorf("want su and sp got %s and %s", conf.SMTPUser, conf.SMTPPassword)
+	}
 }
 
 func TestParseConfigWithError(t *testing.T) {
@@ -232,4 +366,37 @@ func TestGetConfig(t *testing.T) {
 	if config.TLSCertificateKeyFile != "key" {
 		t.Errorf("want key, got %s", config.TLSCertificateKeyFile)
 	}
+
+	// The database pool settings take their defaults.
+	if config.DBMaxOpenConns() != 10 {
+		t.Errorf("want 10 got %d", config.DBMaxOpenConns())
+	}
+
+	if config.DBMaxIdleConns() != 5 {
+		t.Errorf("want 5 got %d", config.DBMaxIdleConns())
+	}
+
+	if config.DBConnMaxLifetime() != 30*time.Minute {
+		t.Errorf("want 30m got %v", config.DBConnMaxLifetime())
+	}
+
+	if config.DBConnMaxIdleTime() != 5*time.Minute {
+		t.Errorf("want 5m got %v", config.DBConnMaxIdleTime())
+	}
+
+	if config.DBTimeout() != 30*time.Second {
+		t.Errorf("want 30s got %v", config.DBTimeout())
+	}
+
+	if config.MaxFormAge() != 4*time.Hour {
+		t.Errorf("want 4h got %v", config.MaxFormAge())
+	}
+
+	if config.LoginFailureLimit() != 5 {
+		t.Errorf("want 5 got %d", config.LoginFailureLimit())
+	}
+
+	if config.LoginLockout() != 15*time.Minute {
+		t.Errorf("want 15m got %v", config.LoginLockout())
+	}
 }
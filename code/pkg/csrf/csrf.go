@@ -0,0 +1,139 @@
+// The csrf package protects HTML forms against cross-site request forgery.
+//
+// It uses the "double submit" pattern.  On every request the middleware makes
+// sure that the browser has a cookie containing a token, signed with a secret
+// known only to the server.  The handler puts the same token into a hidden
+// field in each form that it displays.  When a form is posted, the middleware
+// checks that the cookie is properly signed and that the hidden field matches
+// it.  A page on another website can make the browser post a form to this
+// server, and the browser will send the cookie, but the other website can't
+// read the cookie so it can't fill in the hidden field.
+package csrf
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+
+	"github.com/goblimey/go-stripe-payments/code/pkg/token"
+)
+
+// CookieName is the name of the cookie that holds the token.
+const CookieName = "csrf_token"
+
+// FieldName is the name of the hidden form field that holds the token.
+const FieldName = "csrf_token"
+
+// contextKey is the type of the key used to store the token in the request
+// context.  It's unexported so that no other package can clash with it.
+type contextKey struct{}
+
+// Protector is the CSRF middleware.
+type Protector struct {
+	signer      *token.Signer
+	secure      bool         // True if the cookie should only be sent over HTTPS.
+	failureHTML string       // The page displayed when a check fails.
+	logger      *slog.Logger // The daily logger.
+}
+
+// New creates a Protector.  The secret is used to sign the tokens.  If secure
+// is true, the cookie is only sent over HTTPS.  The failure page is displayed
+// when a form fails the check.
+func New(secret string, secure bool, failureHTML string, logger *slog.Logger) *Protector {
+	p := Protector{
+		signer:      token.NewSigner(secret),
+		secure:      secure,
+		failureHTML: failureHTML,
+		logger:      logger,
+	}
+	return &p
+}
+
+// Protect wraps the given handler.  It ensures that the browser has a token
+// cookie and makes the token available to the handler via Token.  A POST
+// request is only passed to the handler if its form carries the same token
+// as the cookie.
+func (p *Protector) Protect(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		cookieToken := ""
+		cookie, cookieError := r.Cookie(CookieName)
+		if cookieError == nil && p.valid(cookie.Value) {
+			cookieToken = cookie.Value
+		}
+
+		if r.Method == http.MethodPost {
+			formToken := r.PostFormValue(FieldName)
+			if len(cookieToken) == 0 || !equal(formToken, cookieToken) {
+				p.logger.Warn("csrf check failed",
+					"path", r.URL.Path, "remote", r.RemoteAddr,
+					"cookie", len(cookieToken) > 0, "field", len(formToken) > 0)
+				w.WriteHeader(http.StatusForbidden)
+				w.Write([]byte(p.failureHTML))
+				return
+			}
+		}
+
+		if len(cookieToken) == 0 {
+			// The browser doesn't have a token yet (or it has a junk one).
+			// Issue one.
+			var newTokenError error
+			cookieToken, newTokenError = p.newToken()
+			if newTokenError != nil {
+				p.logger.Error(newTokenError.Error())
+				w.WriteHeader(http.StatusInternalServerError)
+				w.Write([]byte(p.failureHTML))
+				return
+			}
+
+			http.SetCookie(w, &http.Cookie{
+				Name:     CookieName,
+				Value:    cookieToken,
+				Path:     "/",
+				HttpOnly: true,
+				Secure:   p.secure,
+				// Lax rather than Strict so that the cookie survives the
+				// redirect back from the payment service.
+				SameSite: http.SameSiteLaxMode,
+			})
+		}
+
+		ctx := context.WithValue(r.Context(), contextKey{}, cookieToken)
+		next(w, r.WithContext(ctx))
+	}
+}
+
+// Token returns the token to be put in the hidden field of any form that the
+// handler displays.  It returns an empty string if the request didn't come
+// through the middleware.
+func Token(r *http.Request) string {
+	tok, ok := r.Context().Value(contextKey{}).(string)
+	if !ok {
+		return ""
+	}
+	return tok
+}
+
+// newToken creates a new signed token containing a random nonce.
+func (p *Protector) newToken() (string, error) {
+	nonce := make([]byte, 16)
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return "", err
+	}
+	return p.signer.Sign(token.PurposeCSRF, hex.EncodeToString(nonce)), nil
+}
+
+// valid returns true if the token was signed by this server as a CSRF token.
+func (p *Protector) valid(tok string) bool {
+	_, err := p.signer.Verify(token.PurposeCSRF, tok)
+	return err == nil
+}
+
+// equal compares two tokens in constant time.
+func equal(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
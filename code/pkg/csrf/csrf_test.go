@@ -0,0 +1,124 @@
+package csrf
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/goblimey/go-stripe-payments/code/pkg/token"
+)
+
+const failurePage = "<html>failed</html>"
+
+// newTestProtector creates a Protector that logs nowhere.
+func newTestProtector() *Protector {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	return New("secret", true, failurePage, logger)
+}
+
+// TestGetIssuesToken checks that a GET request without a cookie is given one
+// and that the handler can see the same token.
+func TestGetIssuesToken(t *testing.T) {
+
+	p := newTestProtector()
+
+	var seen string
+	handler := p.Protect(func(w http.ResponseWriter, r *http.Request) {
+		seen = Token(r)
+	})
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/subscribe", nil)
+	handler(w, r)
+
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 {
+		t.Errorf("want 1 cookie got %d", len(cookies))
+		return
+	}
+
+	if cookies[0].Name != CookieName {
+		t.Errorf("want %s got %s", CookieName, cookies[0].Name)
+	}
+
+	if !cookies[0].Secure || !cookies[0].HttpOnly {
+		t.Error("want a secure, HTTP only cookie")
+	}
+
+	if len(seen) == 0 || seen != cookies[0].Value {
+		t.Errorf("want %s got %s", cookies[0].Value, seen)
+	}
+}
+
+// TestPost checks the handling of POST requests.
+func TestPost(t *testing.T) {
+
+	p := newTestProtector()
+	good, _ := p.newToken()
+	other, _ := p.newToken()
+	forged, _ := New("other secret", true, failurePage, p.logger).newToken()
+	saleToken := p.signer.IssueSaleToken(&token.SaleToken{SaleID: 1})
+
+	var testData = []struct {
+		description string
+		cookie      string
+		field       string
+		wantCalled  bool
+	}{
+		{"matching", good, good, true},
+		{"no cookie", "", good, false},
+		{"no field", good, "", false},
+		{"different tokens", good, other, false},
+		{"forged cookie", forged, forged, false},
+		{"token for another purpose", saleToken, saleToken, false},
+		{"junk", "junk", "junk", false},
+	}
+
+	for _, td := range testData {
+
+		called := false
+		handler := p.Protect(func(w http.ResponseWriter, r *http.Request) {
+			called = true
+		})
+
+		values := url.Values{}
+		if len(td.field) > 0 {
+			values.Add(FieldName, td.field)
+		}
+		r := httptest.NewRequest(http.MethodPost, "/checkout",
+			strings.NewReader(values.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if len(td.cookie) > 0 {
+			r.AddCookie(&http.Cookie{Name: CookieName, Value: td.cookie})
+		}
+
+		w := httptest.NewRecorder()
+		handler(w, r)
+
+		if called != td.wantCalled {
+			t.Errorf("%s: want called %v got %v", td.description, td.wantCalled, called)
+		}
+
+		if !td.wantCalled {
+			if w.Code != http.StatusForbidden {
+				t.Errorf("%s: want status %d got %d", td.description, http.StatusForbidden, w.Code)
+			}
+			if w.Body.String() != failurePage {
+				t.Errorf("%s: want the failure page got %s", td.description, w.Body.String())
+			}
+		}
+	}
+}
+
+// TestTokenWithoutMiddleware checks that Token returns an empty string when
+// the request didn't come through the middleware.
+func TestTokenWithoutMiddleware(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	if got := Token(r); got != "" {
+		t.Errorf("want empty string got %s", got)
+	}
+}
@@ -0,0 +1,241 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Support for the administration pages.  An administrator can list and filter
+// the membership sales, look at the member accounts that a sale refers to,
+// mark a sale complete or cancelled and add notes to it.  The notes are kept in
+// the membership_sale_notes table, which also records the actions taken.
+
+// PaymentStatusCancelled is the status of a sale that an administrator has
+// cancelled.
+const PaymentStatusCancelled = "cancelled"
+
+// SaleFilter restricts the list of sales returned by GetMembershipSales.  The
+// zero value of a field means "any".
+type SaleFilter struct {
+	Year           int    // The membership year, for example 2025.
+	Status         string // The payment status, for example PaymentStatusComplete.
+	PaymentService string // The payment service, for example "Stripe".
+}
+
+// SaleNote is a note added to a sale by an administrator.
+type SaleNote struct {
+	ID        int64
+	SaleID    int64     // The ID of the membership_sales record.
+	UserID    int64     // The user ID of the administrator who wrote the note.
+	LoginName string    // The login name of that administrator (not stored).
+	Note      string    // The text of the note.
+	Created   time.Time // When the note was written.
+}
+
+// GetMembershipSales gets the membership_sales records that match the given
+// filter, newest first.
+func (db *Database) GetMembershipSales(ctx context.Context, filter *SaleFilter) ([]MembershipSale, error) {
+
+	conditions := make([]string, 0, 3)
+	args := make([]any, 0, 3)
+
+	if filter.Year > 0 {
+		args = append(args, filter.Year)
+		conditions = append(conditions, fmt.Sprintf("ms_membership_year = $%d", len(args)))
+	}
+
+	if len(filter.Status) > 0 {
+		args = append(args, filter.Status)
+		conditions = append(conditions, fmt.Sprintf("ms_payment_status = $%d", len(args)))
+	}
+
+	if len(filter.PaymentService) > 0 {
+		args = append(args, filter.PaymentService)
+		conditions = append(conditions, fmt.Sprintf("ms_payment_service = $%d", len(args)))
+	}
+
+	var whereClause string
+	if len(conditions) > 0 {
+		whereClause = "WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	query := db.membershipSaleQuery(whereClause + " ORDER BY ms_id DESC")
+
+	rows, searchErr := db.Query(ctx, query, args...)
+	if searchErr != nil {
+		return nil, searchErr
+	}
+	defer rows.Close()
+
+	result := make([]MembershipSale, 0)
+	for rows.Next() {
+		ms, scanError := scanMembershipSale(rows)
+		if scanError != nil {
+			return nil, scanError
+		}
+		result = append(result, *ms)
+	}
+
+	return result, rows.Err()
+}
+
+// GetPaymentServices gets the names of the payment services used in the
+// membership_sales table, in alphabetical order.
+func (db *Database) GetPaymentServices(ctx context.Context) ([]string, error) {
+
+	const query = `
+		SELECT DISTINCT ms_payment_service
+		FROM membership_sales
+		ORDER BY ms_payment_service;
+	`
+
+	rows, searchError := db.Query(ctx, query)
+	if searchError != nil {
+		return nil, searchError
+	}
+	defer rows.Close()
+
+	result := make([]string, 0)
+	for rows.Next() {
+		var service string
+		scanError := rows.Scan(&service)
+		if scanError != nil {
+			return nil, scanError
+		}
+		result = append(result, service)
+	}
+
+	return result, rows.Err()
+}
+
+// SetMembershipSaleStatus sets the payment status of the sale with the given ID.
+func (db *Database) SetMembershipSaleStatus(ctx context.Context, saleID int64, status string) error {
+
+	const query = `
+		UPDATE membership_sales
+		SET ms_payment_status = $1
+		WHERE ms_id = $2;
+	`
+
+	rows, updateError := db.UpdateRow(ctx, query, status, saleID)
+	if updateError != nil {
+		return updateError
+	}
+
+	if rows != 1 {
+		em := fmt.Sprintf("SetMembershipSaleStatus: no sale with ID %d", saleID)
+		return errors.New(em)
+	}
+
+	return nil
+}
+
+// UserHasRole returns true if the user with the given ID is a member of the
+// role with the given name at the given time, for example RoleNameAdmin.
+func (db *Database) UserHasRole(ctx context.Context, userID int64, roleName string, now time.Time) (bool, error) {
+
+	// Admidio stores the start and end of a membership as dates.  SQLite
+	// stores them as strings starting "YYYY-MM-DD", which compare correctly
+	// with a date in that form.
+	const query = `
+		SELECT count(*)
+		FROM adm_members AS m
+		JOIN adm_roles AS r
+			ON r.rol_id = m.mem_rol_id
+		WHERE m.mem_usr_id = $1
+		AND r.rol_name = $2
+		AND m.mem_begin <= $3
+		AND m.mem_end >= $4;
+	`
+
+	today := now.Format("2006-01-02")
+
+	var n int
+	err := db.QueryRow(ctx, query, userID, roleName, today, today).Scan(&n)
+	if err != nil {
+		return false, err
+	}
+
+	return n > 0, nil
+}
+
+// CreateSaleNote creates a membership_sale_notes record.
+func (db *Database) CreateSaleNote(ctx context.Context, n *SaleNote) error {
+
+	const qPostgres = `
+		INSERT INTO membership_sale_notes
+		(msn_ms_id, msn_usr_id, msn_note, msn_created)
+		VALUES($1, $2, $3, $4)
+		RETURNING msn_id;
+	`
+
+	const qSQLite = `
+		INSERT INTO membership_sale_notes
+		(msn_ms_id, msn_usr_id, msn_note, msn_created)
+		VALUES(?, ?, ?, ?);
+	`
+
+	var q string
+	switch db.Config.Type {
+	case "postgres":
+		q = qPostgres
+	default:
+		q = qSQLite
+	}
+
+	// The time is stored as seconds since the Unix epoch, which is handled
+	// the same way by all databases.
+	id, createError := db.CreateRow(ctx, q, n.SaleID, n.UserID, n.Note, n.Created.Unix())
+	if createError != nil {
+		return createError
+	}
+
+	n.ID = id
+
+	return nil
+}
+
+// GetSaleNotes gets the notes on the given sale, oldest first.
+func (db *Database) GetSaleNotes(ctx context.Context, saleID int64) ([]SaleNote, error) {
+
+	const queryTemplate = `
+		SELECT n.msn_id, n.msn_ms_id, n.msn_usr_id, %s(u.usr_login_name, ''),
+			n.msn_note, n.msn_created
+		FROM membership_sale_notes AS n
+		LEFT JOIN adm_users AS u
+			ON u.usr_id = n.msn_usr_id
+		WHERE n.msn_ms_id = $1
+		ORDER BY n.msn_id;
+	`
+
+	var query string
+	switch db.Config.Type {
+	case "postgres":
+		query = fmt.Sprintf(queryTemplate, "COALESCE")
+	default:
+		query = fmt.Sprintf(queryTemplate, "IFNULL")
+	}
+
+	rows, searchError := db.Query(ctx, query, saleID)
+	if searchError != nil {
+		return nil, searchError
+	}
+	defer rows.Close()
+
+	result := make([]SaleNote, 0)
+	for rows.Next() {
+		var n SaleNote
+		var created int64
+		scanError := rows.Scan(&n.ID, &n.SaleID, &n.UserID, &n.LoginName, &n.Note, &created)
+		if scanError != nil {
+			return nil, scanError
+		}
+		n.Created = time.Unix(created, 0)
+		result = append(result, n)
+	}
+
+	return result, rows.Err()
+}
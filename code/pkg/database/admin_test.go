@@ -0,0 +1,267 @@
+package database
+
+import (
+	"testing"
+	"time"
+)
+
+// TestGetMembershipSales checks that GetMembershipSales applies the filter.
+func TestGetMembershipSales(t *testing.T) {
+	ctx := t.Context()
+
+	for _, dbType := range databaseList {
+
+		db, connError := OpenDBForTesting(dbType)
+		if connError != nil {
+			t.Error(connError)
+			continue
+		}
+
+		db.BeginTx(ctx)
+
+		defer db.Rollback()
+		defer db.CloseAndDelete()
+
+		prepError := PrepareTestTables(db)
+		if prepError != nil {
+			t.Errorf("%s: %v", dbType, prepError)
+			continue
+		}
+
+		// Use service names that won't be in the table already.
+		u, _ := CreateUuid(ctx, db.Transaction, "ms_payment_service", "membership_sales")
+		service := "s" + u[:8]
+		otherService := "x" + u[:8]
+
+		sales := []MembershipSale{
+			{PaymentService: service, PaymentStatus: PaymentStatusComplete,
+				MembershipYear: 2024, FirstName: "a", LastName: "b", Email: "c"},
+			{PaymentService: service, PaymentStatus: PaymentStatusPending,
+				MembershipYear: 2024, FirstName: "a", LastName: "b", Email: "c"},
+			{PaymentService: service, PaymentStatus: PaymentStatusComplete,
+				MembershipYear: 2025, FirstName: "a", LastName: "b", Email: "c"},
+			{PaymentService: otherService, PaymentStatus: PaymentStatusComplete,
+				MembershipYear: 2025, FirstName: "a", LastName: "b", Email: "c"},
+		}
+
+		for i := range sales {
+			_, createError := sales[i].Create(ctx, db)
+			if createError != nil {
+				t.Errorf("%s: %v", dbType, createError)
+			}
+		}
+
+		var testData = []struct {
+			description string
+			filter      SaleFilter
+			want        []int64
+		}{
+			{"service", SaleFilter{PaymentService: service},
+				[]int64{sales[2].ID, sales[1].ID, sales[0].ID}},
+			{"service and year", SaleFilter{PaymentService: service, Year: 2024},
+				[]int64{sales[1].ID, sales[0].ID}},
+			{"all three", SaleFilter{PaymentService: service, Year: 2024, Status: PaymentStatusComplete},
+				[]int64{sales[0].ID}},
+			{"no match", SaleFilter{PaymentService: service, Year: 2023},
+				[]int64{}},
+		}
+
+		for _, td := range testData {
+			got, fetchError := db.GetMembershipSales(ctx, &td.filter)
+			if fetchError != nil {
+				t.Errorf("%s %s: %v", dbType, td.description, fetchError)
+				continue
+			}
+
+			if len(got) != len(td.want) {
+				t.Errorf("%s %s: want %d sales got %d", dbType, td.description, len(td.want), len(got))
+				continue
+			}
+
+			for i := range got {
+				if got[i].ID != td.want[i] {
+					t.Errorf("%s %s: want %v got sale %d at %d",
+						dbType, td.description, td.want, got[i].ID, i)
+				}
+			}
+		}
+
+		services, servicesError := db.GetPaymentServices(ctx)
+		if servicesError != nil {
+			t.Errorf("%s: %v", dbType, servicesError)
+			continue
+		}
+
+		found := 0
+		for _, s := range services {
+			if s == service || s == otherService {
+				found++
+			}
+		}
+		if found != 2 {
+			t.Errorf("%s: want both services in %v", dbType, services)
+		}
+
+		statusError := db.SetMembershipSaleStatus(ctx, sales[1].ID, PaymentStatusCancelled)
+		if statusError != nil {
+			t.Errorf("%s: %v", dbType, statusError)
+			continue
+		}
+
+		cancelled, fetchError := db.GetMembershipSale(ctx, sales[1].ID)
+		if fetchError != nil {
+			t.Errorf("%s: %v", dbType, fetchError)
+			continue
+		}
+
+		if cancelled.PaymentStatus != PaymentStatusCancelled {
+			t.Errorf("%s: want %s got %s", dbType, PaymentStatusCancelled, cancelled.PaymentStatus)
+		}
+	}
+}
+
+// TestUserHasRole checks UserHasRole.
+func TestUserHasRole(t *testing.T) {
+	ctx := t.Context()
+
+	for _, dbType := range databaseList {
+
+		db, connError := OpenDBForTesting(dbType)
+		if connError != nil {
+			t.Error(connError)
+			continue
+		}
+
+		db.BeginTx(ctx)
+
+		defer db.Rollback()
+		defer db.CloseAndDelete()
+
+		prepError := PrepareTestTables(db)
+		if prepError != nil {
+			t.Errorf("%s: %v", dbType, prepError)
+			continue
+		}
+
+		user, cue := CreateUser(ctx, db)
+		if cue != nil {
+			t.Errorf("%s: %v", dbType, cue)
+			continue
+		}
+
+		adminRole, roleError := db.GetRole(ctx, RoleNameAdmin)
+		if roleError != nil {
+			t.Errorf("%s: %v", dbType, roleError)
+			continue
+		}
+
+		start := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+		end := time.Date(2025, time.December, 31, 0, 0, 0, 0, time.UTC)
+		memberError := db.CreateMember(ctx, NewMember(user, adminRole, start, end))
+		if memberError != nil {
+			t.Errorf("%s: %v", dbType, memberError)
+			continue
+		}
+
+		var testData = []struct {
+			description string
+			role        string
+			now         time.Time
+			want        bool
+		}{
+			{"during", RoleNameAdmin, time.Date(2025, time.June, 1, 0, 0, 0, 0, time.UTC), true},
+			{"last day", RoleNameAdmin, end, true},
+			{"before", RoleNameAdmin, time.Date(2023, time.June, 1, 0, 0, 0, 0, time.UTC), false},
+			{"after", RoleNameAdmin, time.Date(2026, time.June, 1, 0, 0, 0, 0, time.UTC), false},
+			{"other role", RoleNameMember, time.Date(2025, time.June, 1, 0, 0, 0, 0, time.UTC), false},
+		}
+
+		for _, td := range testData {
+			got, err := db.UserHasRole(ctx, user.ID, td.role, td.now)
+			if err != nil {
+				t.Errorf("%s %s: %v", dbType, td.description, err)
+				continue
+			}
+			if got != td.want {
+				t.Errorf("%s %s: want %v got %v", dbType, td.description, td.want, got)
+			}
+		}
+	}
+}
+
+// TestSaleNotes checks that notes can be added to a sale and fetched.
+func TestSaleNotes(t *testing.T) {
+	ctx := t.Context()
+
+	for _, dbType := range databaseList {
+
+		db, connError := OpenDBForTesting(dbType)
+		if connError != nil {
+			t.Error(connError)
+			continue
+		}
+
+		db.BeginTx(ctx)
+
+		defer db.Rollback()
+		defer db.CloseAndDelete()
+
+		prepError := PrepareTestTables(db)
+		if prepError != nil {
+			t.Errorf("%s: %v", dbType, prepError)
+			continue
+		}
+
+		user, cue := CreateUser(ctx, db)
+		if cue != nil {
+			t.Errorf("%s: %v", dbType, cue)
+			continue
+		}
+
+		sale := MembershipSale{
+			PaymentService: "Stripe", PaymentStatus: PaymentStatusPending,
+			MembershipYear: 2025, FirstName: "a", LastName: "b", Email: "c",
+		}
+		saleID, saleError := sale.Create(ctx, db)
+		if saleError != nil {
+			t.Errorf("%s: %v", dbType, saleError)
+			continue
+		}
+
+		created := time.Date(2025, time.March, 1, 12, 0, 0, 0, time.UTC)
+		notes := []SaleNote{
+			{SaleID: saleID, UserID: user.ID, Note: "paid by cheque", Created: created},
+			{SaleID: saleID, UserID: user.ID, Note: "Marked complete.", Created: created.Add(time.Hour)},
+		}
+
+		for i := range notes {
+			createError := db.CreateSaleNote(ctx, &notes[i])
+			if createError != nil {
+				t.Errorf("%s: %v", dbType, createError)
+			}
+		}
+
+		got, fetchError := db.GetSaleNotes(ctx, saleID)
+		if fetchError != nil {
+			t.Errorf("%s: %v", dbType, fetchError)
+			continue
+		}
+
+		if len(got) != len(notes) {
+			t.Errorf("%s: want %d notes got %d", dbType, len(notes), len(got))
+			continue
+		}
+
+		for i := range got {
+			if got[i].ID != notes[i].ID || got[i].Note != notes[i].Note {
+				t.Errorf("%s: want %v got %v", dbType, notes[i], got[i])
+			}
+			if got[i].LoginName != user.LoginName {
+				t.Errorf("%s: want %s got %s", dbType, user.LoginName, got[i].LoginName)
+			}
+			if !got[i].Created.Equal(notes[i].Created) {
+				t.Errorf("%s: want %v got %v", dbType, notes[i].Created, got[i].Created)
+			}
+		}
+	}
+}
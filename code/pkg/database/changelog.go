@@ -0,0 +1,404 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Admidio keeps a history of the changes to each member's profile in
+// adm_user_log, which administrators see on the member's profile page.  The
+// application records the changes that it makes in the same way, so that an
+// administrator can see who changed what.  The changes are attributed to the
+// system user given by DBConfig.SystemUser ("System" by default).  If there
+// is no adm_user_log table, the changes to profile fields go to the
+// application's membership_change_log table instead.  adm_user_log only has
+// room for profile fields, so changes to membership end dates and new
+// accounts always go to membership_change_log.
+
+// DefaultSystemUser is the login name of the user that changes are attributed
+// to if the config doesn't give one.  Admidio creates it on installation.
+const DefaultSystemUser = "System"
+
+// change is one change to a user's records.
+type change struct {
+	fieldID  int64  // The ID of the profile field that changed, or zero.
+	item     string // The column that changed, for example "usd_value" or "mem_end".
+	oldValue string // The value before the change, empty if there was none.
+	newValue string // The value after the change, empty if it was removed.
+}
+
+// profileChange makes a change to the profile field with the given ID.
+func profileChange(fieldID int64, oldValue, newValue string) change {
+	return change{fieldID: fieldID, item: "usd_value", oldValue: oldValue, newValue: newValue}
+}
+
+// systemUserName gives the login name of the user that the application's
+// changes are attributed to.
+func (db *Database) systemUserName() string {
+	if len(db.Config.SystemUser) == 0 {
+		return DefaultSystemUser
+	}
+	return db.Config.SystemUser
+}
+
+// SystemUserID gets the ID of the user that the application's changes are
+// attributed to.  The ID is fetched once per Database object.
+// It's assumed that a transaction is already set up in the db object.
+func (db *Database) SystemUserID(ctx context.Context) (int64, error) {
+
+	if db.systemUserID > 0 {
+		return db.systemUserID, nil
+	}
+
+	name := db.systemUserName()
+
+	const query = `
+		SELECT usr_id
+		FROM adm_users
+		WHERE usr_login_name = $1;
+	`
+
+	var id int64
+	searchError := db.QueryRow(ctx, query, name).Scan(&id)
+	if searchError != nil {
+		if searchError == sql.ErrNoRows {
+			em := fmt.Sprintf("SystemUserID: there is no system user %q", name)
+			return 0, errors.New(em)
+		}
+		em := fmt.Sprintf("SystemUserID: %v", searchError)
+		return 0, errors.New(em)
+	}
+
+	db.systemUserID = id
+	return id, nil
+}
+
+// hasUserLog is true if the database has Admidio's adm_user_log table.  The
+// answer is fetched once per Database object.
+// It's assumed that a transaction is already set up in the db object.
+func (db *Database) hasUserLog(ctx context.Context) (bool, error) {
+
+	if db.userLogChecked {
+		return db.userLogFound, nil
+	}
+
+	found, existsError := db.tableExists(ctx, "adm_user_log")
+	if existsError != nil {
+		return false, existsError
+	}
+
+	db.userLogChecked = true
+	db.userLogFound = found
+	return found, nil
+}
+
+// tableExists is true if the database has a table with the given name, which
+// is adapted like the name in a query (see adapt).  It asks the catalogue rather than querying the table, because in Postgres a
+// failed query spoils the transaction.
+// It's assumed that a transaction is already set up in the db object.
+func (db *Database) tableExists(ctx context.Context, name string) (bool, error) {
+
+	var query string
+	switch db.Config.Type {
+	case "postgres":
+		query = `
+			SELECT count(*)
+			FROM information_schema.tables
+			WHERE table_schema = current_schema()
+			AND table_name = $1;
+		`
+	case "mysql":
+		query = `
+			SELECT count(*)
+			FROM information_schema.tables
+			WHERE table_schema = DATABASE()
+			AND table_name = $1;
+		`
+	default:
+		query = `
+			SELECT count(*)
+			FROM sqlite_master
+			WHERE type = 'table'
+			AND name = $1;
+		`
+	}
+
+	var n int
+	searchError := db.QueryRow(ctx, query, db.adapt(name)).Scan(&n)
+	if searchError != nil {
+		return false, searchError
+	}
+
+	return n > 0, nil
+}
+
+// logChanges records the given changes to the records of the given user,
+// attributed to the system user.  Changes that leave the value as it was
+// are ignored.  If any profile fields changed, the user's record is marked as
+// changed by the system user.
+// It's assumed that a transaction is already set up in the db object.
+func (db *Database) logChanges(ctx context.Context, userID int64, changes ...change) error {
+
+	const fn = "logChanges"
+
+	profileChanges := make([]change, 0, len(changes))
+	otherChanges := make([]change, 0, len(changes))
+	for _, c := range changes {
+		switch {
+		case c.oldValue == c.newValue:
+			continue
+		case c.fieldID > 0:
+			profileChanges = append(profileChanges, c)
+		default:
+			otherChanges = append(otherChanges, c)
+		}
+	}
+
+	if len(profileChanges) == 0 && len(otherChanges) == 0 {
+		return nil
+	}
+
+	systemUserID, systemUserError := db.SystemUserID(ctx)
+	if systemUserError != nil {
+		em := fmt.Sprintf("%s: %v", fn, systemUserError)
+		return errors.New(em)
+	}
+
+	if len(profileChanges) > 0 {
+
+		userLog, userLogError := db.hasUserLog(ctx)
+		if userLogError != nil {
+			em := fmt.Sprintf("%s: %v", fn, userLogError)
+			return errors.New(em)
+		}
+
+		if userLog {
+			insertError := db.insertUserLog(ctx, userID, systemUserID, profileChanges)
+			if insertError != nil {
+				em := fmt.Sprintf("%s: %v", fn, insertError)
+				return errors.New(em)
+			}
+		} else {
+			otherChanges = append(profileChanges, otherChanges...)
+		}
+
+		const markChangedSQL = `
+			UPDATE adm_users
+			SET usr_usr_id_change = $1, usr_timestamp_change = CURRENT_TIMESTAMP
+			WHERE usr_id = $2;
+		`
+		_, markError := db.Exec(ctx, markChangedSQL, systemUserID, userID)
+		if markError != nil {
+			em := fmt.Sprintf("%s: %v", fn, markError)
+			return errors.New(em)
+		}
+	}
+
+	if len(otherChanges) > 0 {
+		insertError := db.insertChangeLog(ctx, userID, systemUserID, otherChanges)
+		if insertError != nil {
+			em := fmt.Sprintf("%s: %v", fn, insertError)
+			return errors.New(em)
+		}
+	}
+
+	// Success!
+	return nil
+}
+
+// insertUserLog records the given changes to profile fields in adm_user_log.
+func (db *Database) insertUserLog(ctx context.Context, userID, systemUserID int64, changes []change) error {
+
+	rows := make([]string, 0, len(changes))
+	args := make([]any, 0, 5*len(changes))
+	for _, c := range changes {
+		n := len(args)
+		rows = append(rows, fmt.Sprintf("($%d, $%d, $%d, $%d, $%d)", n+1, n+2, n+3, n+4, n+5))
+		args = append(args, userID, c.fieldID, nullIfEmpty(c.oldValue), nullIfEmpty(c.newValue), systemUserID)
+	}
+
+	insertSQL := fmt.Sprintf(`
+		INSERT INTO adm_user_log
+		(usl_usr_id, usl_usf_id, usl_value_old, usl_value_new, usl_usr_id_create)
+		VALUES %s;
+	`, strings.Join(rows, ", "))
+
+	_, insertError := db.Exec(ctx, insertSQL, args...)
+	return insertError
+}
+
+// insertChangeLog records the given changes in membership_change_log.
+func (db *Database) insertChangeLog(ctx context.Context, userID, systemUserID int64, changes []change) error {
+
+	rows := make([]string, 0, len(changes))
+	args := make([]any, 0, 6*len(changes))
+	for _, c := range changes {
+		n := len(args)
+		rows = append(rows, fmt.Sprintf("($%d, $%d, $%d, $%d, $%d, $%d)", n+1, n+2, n+3, n+4, n+5, n+6))
+		var fieldID any
+		if c.fieldID > 0 {
+			fieldID = c.fieldID
+		}
+		args = append(args, userID, fieldID, c.item, nullIfEmpty(c.oldValue), nullIfEmpty(c.newValue), systemUserID)
+	}
+
+	insertSQL := fmt.Sprintf(`
+		INSERT INTO membership_change_log
+		(mcl_usr_id, mcl_usf_id, mcl_item, mcl_value_old, mcl_value_new, mcl_usr_id_create)
+		VALUES %s;
+	`, strings.Join(rows, ", "))
+
+	_, insertError := db.Exec(ctx, insertSQL, args...)
+	return insertError
+}
+
+// nullIfEmpty gives nil, which is stored as NULL, for an empty string and the
+// string otherwise.
+func nullIfEmpty(s string) any {
+	if len(s) == 0 {
+		return nil
+	}
+	return s
+}
+
+// storedForm gives a value in the form that it's stored in adm_user_data.  A
+// tick box is stored as 0 or 1.
+func storedForm(val any) string {
+	switch v := val.(type) {
+	case bool:
+		if v {
+			return "1"
+		}
+		return "0"
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// dateOf gives the date part, for example "2025-12-31", of a date or timestamp
+// fetched from the database.  Depending on the database and the column type,
+// that may be a time.Time, a string or a byte slice.
+func dateOf(v any) string {
+	var s string
+	switch d := v.(type) {
+	case nil:
+		return ""
+	case time.Time:
+		return d.Format("2006-01-02")
+	case []byte:
+		s = string(d)
+	default:
+		s = fmt.Sprint(d)
+	}
+	if len(s) > 10 {
+		s = s[:10]
+	}
+	return s
+}
+
+// userDataValues gets the values in adm_user_data of the user fields with the
+// given IDs for the given user, keyed by field ID.  Fields that aren't set are
+// left out.
+// It's assumed that a transaction is already set up in the db object.
+func (db *Database) userDataValues(ctx context.Context, userID int64, fieldIDs []int64) (map[int64]string, error) {
+
+	values := make(map[int64]string)
+	if len(fieldIDs) == 0 {
+		return values, nil
+	}
+
+	args := []any{userID}
+	for _, id := range fieldIDs {
+		args = append(args, id)
+	}
+
+	query := fmt.Sprintf(`
+		SELECT usd_usf_id, usd_value
+		FROM adm_user_data
+		WHERE usd_usr_id = $1
+		AND usd_usf_id IN (%s);
+	`, placeholderList(2, len(fieldIDs)))
+
+	rows, searchError := db.Query(ctx, query, args...)
+	if searchError != nil {
+		return nil, searchError
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var fieldID int64
+		var value sql.NullString
+		scanError := rows.Scan(&fieldID, &value)
+		if scanError != nil {
+			return nil, scanError
+		}
+		values[fieldID] = value.String
+	}
+
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+
+	return values, nil
+}
+
+// ChangeLogEntry is a row from membership_change_log.
+type ChangeLogEntry struct {
+	ID        int64
+	UserID    int64  // The user whose records changed.
+	FieldID   int64  // The profile field that changed, or zero.
+	Item      string // The column that changed, for example "mem_end".
+	OldValue  string // Empty if there was no value.
+	NewValue  string // Empty if the value was removed.
+	ChangedBy int64  // The user that made the change.
+}
+
+// GetChangeLog gets the entries in membership_change_log for the given user,
+// oldest first.
+// It's assumed that a transaction is already set up in the db object.
+func (db *Database) GetChangeLog(ctx context.Context, userID int64) ([]ChangeLogEntry, error) {
+
+	const fn = "GetChangeLog"
+
+	const query = `
+		SELECT mcl_id, mcl_usr_id, COALESCE(mcl_usf_id, 0), mcl_item,
+			COALESCE(mcl_value_old, ''), COALESCE(mcl_value_new, ''),
+			COALESCE(mcl_usr_id_create, 0)
+		FROM membership_change_log
+		WHERE mcl_usr_id = $1
+		ORDER BY mcl_id;
+	`
+
+	rows, searchError := db.Query(ctx, query, userID)
+	if searchError != nil {
+		em := fmt.Sprintf("%s: %v", fn, searchError)
+		return nil, errors.New(em)
+	}
+	defer rows.Close()
+
+	entries := make([]ChangeLogEntry, 0)
+	for rows.Next() {
+		var e ChangeLogEntry
+		scanError := rows.Scan(&e.ID, &e.UserID, &e.FieldID, &e.Item, &e.OldValue, &e.NewValue, &e.ChangedBy)
+		if scanError != nil {
+			em := fmt.Sprintf("%s: %v", fn, scanError)
+			return nil, errors.New(em)
+		}
+		entries = append(entries, e)
+	}
+
+	if rows.Err() != nil {
+		em := fmt.Sprintf("%s: %v", fn, rows.Err())
+		return nil, errors.New(em)
+	}
+
+	// Success!
+	return entries, nil
+}
@@ -0,0 +1,193 @@
+package database
+
+import (
+	"testing"
+)
+
+// TestChangeLog checks that new users, changes to profile fields and changes
+// to membership end dates are recorded in the change history, attributed to
+// the system user.
+func TestChangeLog(t *testing.T) {
+	ctx := t.Context()
+
+	for _, dbType := range databaseList {
+
+		db, connError := ConnectForTesting(dbType)
+		if connError != nil {
+			t.Error(connError)
+			continue
+		}
+
+		defer db.Rollback()
+		defer db.CloseAndDelete()
+
+		systemUserID, systemUserError := db.SystemUserID(ctx)
+		if systemUserError != nil {
+			t.Errorf("%s: %v", dbType, systemUserError)
+			continue
+		}
+
+		user, member, _, _, _, userError := createTestUserEtc(ctx, db)
+		if userError != nil {
+			t.Errorf("%s: %v", dbType, userError)
+			continue
+		}
+
+		// The new account is recorded.
+		log, logError := db.GetChangeLog(ctx, user.ID)
+		if logError != nil {
+			t.Errorf("%s: %v", dbType, logError)
+			continue
+		}
+		if len(log) == 0 || log[0].Item != "usr_login_name" || log[0].NewValue != user.LoginName {
+			t.Errorf("%s: want the new account got %v", dbType, log)
+		}
+		if user.IDCreate != int(systemUserID) {
+			t.Errorf("%s: want created by %d got %d", dbType, systemUserID, user.IDCreate)
+		}
+
+		// The change to the end date is recorded.
+		if err := db.SetMemberEndDate(ctx, user.ID, 2026); err != nil {
+			t.Errorf("%s: %v", dbType, err)
+			continue
+		}
+		log, _ = db.GetChangeLog(ctx, user.ID)
+		last := log[len(log)-1]
+		if last.Item != "mem_end" || last.OldValue != "2025-12-31" ||
+			last.NewValue != "2026-12-31" || last.ChangedBy != systemUserID {
+			t.Errorf("%s: want the change to the end date got %v", dbType, last)
+		}
+
+		var changedBy int64
+		const getMemberChange = `SELECT mem_usr_id_change FROM adm_members WHERE mem_id = $1;`
+		if err := db.QueryRow(ctx, getMemberChange, member.ID).Scan(&changedBy); err != nil {
+			t.Errorf("%s: %v", dbType, err)
+		} else if changedBy != systemUserID {
+			t.Errorf("%s: want member changed by %d got %d", dbType, systemUserID, changedBy)
+		}
+
+		fieldID, fieldError := db.GetUserDataFieldIDByNameIntern(ctx, "CITY")
+		if fieldError != nil {
+			t.Errorf("%s: %v", dbType, fieldError)
+			continue
+		}
+
+		userLog, userLogError := db.hasUserLog(ctx)
+		if userLogError != nil {
+			t.Errorf("%s: %v", dbType, userLogError)
+			continue
+		}
+
+		if !userLog {
+			// Without adm_user_log, changes to profile fields go to
+			// membership_change_log.  Setting the same value again isn't a
+			// change.
+			before := len(log)
+			for _, city := range []string{"Leatherhead", "Leatherhead", "Dorking"} {
+				if err := SetProfileField(ctx, db, user.ID, "CITY", city); err != nil {
+					t.Errorf("%s: %v", dbType, err)
+				}
+			}
+			log, _ = db.GetChangeLog(ctx, user.ID)
+			if len(log) != before+2 {
+				t.Errorf("%s: want %d entries got %d", dbType, before+2, len(log))
+				continue
+			}
+			last = log[len(log)-1]
+			if last.FieldID != fieldID || last.Item != "usd_value" ||
+				last.OldValue != "Leatherhead" || last.NewValue != "Dorking" {
+				t.Errorf("%s: want the change of city got %v", dbType, last)
+			}
+
+			// Create the table as Admidio does.
+			const createUserLog = `
+				CREATE TABLE adm_user_log (
+					usl_id integer PRIMARY KEY,
+					usl_usr_id integer NOT NULL,
+					usl_usf_id integer NOT NULL,
+					usl_value_old varchar(4000),
+					usl_value_new varchar(4000),
+					usl_usr_id_create integer,
+					usl_timestamp_create timestamp DEFAULT CURRENT_TIMESTAMP,
+					usl_comment varchar(255)
+				);
+			`
+			if _, err := db.Exec(ctx, createUserLog); err != nil {
+				t.Errorf("%s: %v", dbType, err)
+				continue
+			}
+			db.userLogChecked = false
+		}
+
+		// With adm_user_log, changes to profile fields go there.
+		before := len(log)
+		if err := SetProfileField(ctx, db, user.ID, "CITY", "Guildford"); err != nil {
+			t.Errorf("%s: %v", dbType, err)
+			continue
+		}
+		log, _ = db.GetChangeLog(ctx, user.ID)
+		if len(log) != before {
+			t.Errorf("%s: want %d entries got %d", dbType, before, len(log))
+		}
+
+		var newValue string
+		var createdBy int64
+		const getUserLog = `
+			SELECT usl_value_new, usl_usr_id_create
+			FROM adm_user_log
+			WHERE usl_usr_id = $1
+			AND usl_usf_id = $2
+			ORDER BY usl_id DESC;
+		`
+		if err := db.QueryRow(ctx, getUserLog, user.ID, fieldID).Scan(&newValue, &createdBy); err != nil {
+			t.Errorf("%s: %v", dbType, err)
+		} else if newValue != "Guildford" || createdBy != systemUserID {
+			t.Errorf("%s: want Guildford by %d got %s by %d", dbType, systemUserID, newValue, createdBy)
+		}
+
+		const getUserChange = `SELECT usr_usr_id_change FROM adm_users WHERE usr_id = $1;`
+		if err := db.QueryRow(ctx, getUserChange, user.ID).Scan(&changedBy); err != nil {
+			t.Errorf("%s: %v", dbType, err)
+		} else if changedBy != systemUserID {
+			t.Errorf("%s: want user changed by %d got %d", dbType, systemUserID, changedBy)
+		}
+	}
+}
+
+// TestSystemUserID checks that SystemUserID fails if the configured system
+// user doesn't exist.
+func TestSystemUserID(t *testing.T) {
+	ctx := t.Context()
+
+	for _, dbType := range databaseList {
+
+		db, connError := ConnectForTesting(dbType)
+		if connError != nil {
+			t.Error(connError)
+			continue
+		}
+
+		defer db.Rollback()
+		defer db.CloseAndDelete()
+
+		// The config is shared by the tests, so change a copy.
+		config := *db.Config
+		config.SystemUser = "NoSuchUser"
+		db.Config = &config
+
+		_, err := db.SystemUserID(ctx)
+		if err == nil {
+			t.Errorf("%s: want an error", dbType)
+		}
+
+		if err := db.logChanges(ctx, 1, change{item: "mem_end", newValue: "2025-12-31"}); err == nil {
+			t.Errorf("%s: want an error", dbType)
+		}
+
+		// A change that leaves the value as it was isn't logged, so it
+		// doesn't need the system user.
+		if err := db.logChanges(ctx, 1, change{item: "mem_end", oldValue: "x", newValue: "x"}); err != nil {
+			t.Errorf("%s: %v", dbType, err)
+		}
+	}
+}
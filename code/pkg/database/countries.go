@@ -1,6 +1,7 @@
 package database
 
 import (
+	"context"
 	"database/sql"
 )
 
@@ -13,7 +14,7 @@ type Country struct {
 // GetCountries gets the rows from adm_countries (country code and country name) in
 // apphabetical order of country name.  It's assumed that a transaction is already set up
 // in the db object.
-func (db *Database) GetCountries() ([]Country, error) {
+func (db *Database) GetCountries(ctx context.Context) ([]Country, error) {
 
 	const q = `
 		SELECT ct_id, ct_code, ct_name
@@ -23,7 +24,7 @@ func (db *Database) GetCountries() ([]Country, error) {
 
 	countries := make([]Country, 0)
 
-	rows, err := db.Query(q)
+	rows, err := db.Query(ctx, q)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return countries, nil
@@ -48,7 +49,7 @@ func (db *Database) GetCountries() ([]Country, error) {
 }
 
 // GetCountryByCode gets the row from adm_countries with the given code.
-func (db *Database) GetCountryByCode(countryCode string) (*Country, error) {
+func (db *Database) GetCountryByCode(ctx context.Context, countryCode string) (*Country, error) {
 	const q = `
 		SELECT ct_id, ct_name
 		FROM adm_countries
@@ -57,7 +58,7 @@ func (db *Database) GetCountryByCode(countryCode string) (*Country, error) {
 
 	country := Country{Code: countryCode}
 
-	err := db.QueryRow(q, countryCode).Scan(&country.ID, &country.Name)
+	err := db.QueryRow(ctx, q, countryCode).Scan(&country.ID, &country.Name)
 	if err != nil {
 		return nil, err
 	}
@@ -6,6 +6,8 @@ import (
 )
 
 func TestGetCountryByCode(t *testing.T) {
+	ctx := t.Context()
+
 	db, connError := OpenDBForTesting("sqlite")
 
 	if connError != nil {
@@ -13,7 +15,7 @@ func TestGetCountryByCode(t *testing.T) {
 		return
 	}
 
-	db.BeginTx()
+	db.BeginTx(ctx)
 
 	defer db.Rollback()
 	defer db.CloseAndDelete()
@@ -23,7 +25,7 @@ func TestGetCountryByCode(t *testing.T) {
 		t.Error(prepError)
 	}
 
-	gbr, err1 := db.GetCountryByCode("GBR")
+	gbr, err1 := db.GetCountryByCode(ctx, "GBR")
 	if err1 != nil {
 		t.Error(err1)
 		return
@@ -37,7 +39,7 @@ func TestGetCountryByCode(t *testing.T) {
 		t.Errorf("expected \"United Kingdom\", got %s", gbr.Name)
 	}
 
-	_, err2 := db.GetCountryByCode("junk")
+	_, err2 := db.GetCountryByCode(ctx, "junk")
 	if err2 == nil {
 		t.Error("expected an error")
 		return
@@ -8,10 +8,13 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"net"
 	"os"
+	"slices"
 	"time"
 
 	// Database drivers.
+	"github.com/go-sql-driver/mysql"
 	_ "github.com/lib/pq"
 
 	_ "modernc.org/sqlite"
@@ -26,13 +29,35 @@ const EmailPermNameIntern = "PERMISSION_TO_SEND_EMAILS"
 const DataStoragePermNameIntern = "DATA_PROTECTION_PERMISSION"
 
 type DBConfig struct {
-	Type   string       // The type of database, for example "postgres" or "sqlite".
+	Type   string       // The type of database, "postgres", "mysql" (MySQL or MariaDB) or "sqlite".
 	User   string       // The user connecting to the database.
 	Pass   string       // the password of the iser connecting.
 	Host   string       // The host machine running the database.
 	Port   string       // The port on the host machine that the database uses.
 	Name   string       // the name of the database (AKA "schema")
+	Path   string       // SQLite only - the database file, for example Admidio's.
 	Logger *slog.Logger // The structured logger for trace and error messages.
+
+	// Admidio's table prefix (g_tbl_praefix in its config.php) without the
+	// underscore.  Empty means DefaultTablePrefix.
+	TablePrefix string
+
+	// The login name of the user that the application's changes are
+	// attributed to in the change history.  Empty means DefaultSystemUser.
+	SystemUser string
+
+	// The Admidio roles given to ordinary members, associate members and
+	// friends of the museum (see RoleName).  Empty means RoleNameMember for
+	// ordinary and associate members and no role for friends.
+	OrdinaryRole  string
+	AssociateRole string
+	FriendRole    string
+
+	// The settings of a connection pool (see OpenPool).
+	MaxOpenConns    int           // The most connections open at once.  Zero means no limit.
+	MaxIdleConns    int           // The most idle connections kept open.  Zero means the database/sql default (two), less than zero means none.
+	ConnMaxLifetime time.Duration // A connection is closed after this long.  Zero means it's kept for ever.
+	ConnMaxIdleTime time.Duration // An idle connection is closed after this long.  Zero means it's kept for ever.
 }
 
 func GetDBConfigFromTheEnvironment() DBConfig {
@@ -44,15 +69,57 @@ func GetDBConfigFromTheEnvironment() DBConfig {
 		Host: os.Getenv("DBHost"),
 		Port: os.Getenv("DBPort"),
 		Name: os.Getenv("DBDatabase"),
+		Path: os.Getenv("DBPath"),
+
+		TablePrefix: os.Getenv("DBTablePrefix"),
+		SystemUser:  os.Getenv("DBSystemUser"),
+
+		OrdinaryRole:  os.Getenv("DBOrdinaryRole"),
+		AssociateRole: os.Getenv("DBAssociateRole"),
+		FriendRole:    os.Getenv("DBFriendRole"),
 	}
 
 	return config
 }
 
+// RoleName gets the name of the Admidio role given to members of the given
+// type (MembershipTypeOrdinary etc), or "" if that type is not given a role.
+func (dbc *DBConfig) RoleName(membershipType string) string {
+	switch membershipType {
+	case MembershipTypeOrdinary:
+		if len(dbc.OrdinaryRole) == 0 {
+			return RoleNameMember
+		}
+		return dbc.OrdinaryRole
+	case MembershipTypeAssociate:
+		if len(dbc.AssociateRole) == 0 {
+			return RoleNameMember
+		}
+		return dbc.AssociateRole
+	case MembershipTypeFriend:
+		return dbc.FriendRole
+	default:
+		return ""
+	}
+}
+
+// RoleNames gets the names of the roles given to members of the given types,
+// without repeats.  A type that is not given a role is skipped.
+func (dbc *DBConfig) RoleNames(membershipTypes ...string) []string {
+	names := make([]string, 0, len(membershipTypes))
+	for _, t := range membershipTypes {
+		name := dbc.RoleName(t)
+		if len(name) > 0 && !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
 func (dbc *DBConfig) String() string {
 	return fmt.Sprintf(
-		"Type: %s,User: %s, Host: %s, Port: %s, Name: %s, Pass: %s",
-		dbc.Type, dbc.User, dbc.Host, dbc.Port, dbc.Name, dbc.Pass)
+		"Type: %s,User: %s, Host: %s, Port: %s, Name: %s, Path: %s, Pass: %s",
+		dbc.Type, dbc.User, dbc.Host, dbc.Port, dbc.Name, dbc.Path, dbc.Pass)
 }
 
 type Organisation struct {
@@ -137,6 +204,19 @@ type Role struct {
 const RoleNameAdmin = "Administrator"
 const RoleNameMember = "Member"
 
+// The types of membership.  Each type can be given its own Admidio role, so
+// that Admidio's mailing lists and permissions can tell them apart (see
+// DBConfig.RoleName).  A user can have a membership of each role, with its own
+// start and end dates.
+const (
+	MembershipTypeOrdinary  = "ordinary"
+	MembershipTypeAssociate = "associate"
+	MembershipTypeFriend    = "friend"
+)
+
+// MembershipTypes lists the types of membership.
+var MembershipTypes = []string{MembershipTypeOrdinary, MembershipTypeAssociate, MembershipTypeFriend}
+
 func NewRole(name string, category *Category, user *User, admin, valid bool) *Role {
 	role := Role{
 		Name:          name,
@@ -234,6 +314,8 @@ type MembershipSale struct {
 	OrganisationName         string // Name of the organisation charging (quoted in various pages)
 	EmailAddressForQuestions string // Emai address for questions (quoted in various pages)
 	EmailAddressForFailures  string // Email addess for Failures after (quoted in various pages)
+	Token                    string // Signed token binding the sale to the member accounts (see the token package).
+	CSRFToken                string // The token for the hidden field that protects each form (see the csrf package).
 
 	// These fields are used after a successful sale to collect extra details
 	// (address etc). They are all optional.
@@ -264,6 +346,7 @@ type MembershipSale struct {
 	OtherTopicsOfInterestError string                // Error message.
 	AssocMobile                string                // Associate user's mobile number.
 	AssocMobileError           string                // Error mssage about the associate member's mobile number.
+	ExtraFields                []ExtraField          // The user fields in the configured extra fields category.
 }
 
 // NewMembershipSale creates a MembershipSale object.
@@ -409,19 +492,32 @@ type FieldData struct {
 	Cat        *Category
 }
 
+// Database holds a connection and the transaction that the queries run in.
+// It's not safe for concurrent use.  In the web server each request borrows
+// its own Database from the Pool, so the transaction belongs to the request.
 type Database struct {
-	Config        *DBConfig    // The database config.
-	Connection    *sql.DB      // The database connection.
-	Transaction   *sql.Tx      // The transaction.
-	SQLiteTempDir string       // The directory in /tmp used to store the SQLite DB.
-	Logger        *slog.Logger // The structured daily logger
+	Config        *DBConfig     // The database config.
+	Connection    *sql.DB       // The database connection.
+	Transaction   *sql.Tx       // The transaction.
+	SQLiteTempDir string        // The directory in /tmp used to store the SQLite DB.
+	Logger        *slog.Logger  // The structured daily logger
+	borrowed      bool          // True if the connection belongs to a Pool.
+	fieldIDs      *fieldIDCache // The IDs of the user fields, shared with the Pool, if any.
+	fieldsAdded   bool          // True if the transaction has created user fields.
+
+	systemUserID   int64 // The ID of the user that changes are attributed to, once fetched.
+	userLogChecked bool  // True if it's known whether adm_user_log exists.
+	userLogFound   bool  // True if adm_user_log exists.
+
+	queries *QuerySet // The query set for the version of Admidio, once detected.
 }
 
 // New creates a database object using the given configuration.
 func New(config *DBConfig) *Database {
 
 	db := Database{
-		Config: config,
+		Config:   config,
+		fieldIDs: newFieldIDCache(),
 	}
 	return &db
 }
@@ -429,6 +525,11 @@ func New(config *DBConfig) *Database {
 // Connect connects to the given database and sets the connection in the object.
 func (db *Database) Connect() error {
 
+	prefixError := db.checkTablePrefix()
+	if prefixError != nil {
+		return errors.New("Connect: " + prefixError.Error())
+	}
+
 	switch db.Config.Type {
 	case "postgres":
 		var err error
@@ -438,23 +539,23 @@ func (db *Database) Connect() error {
 			return err
 		}
 
-	case "sqlite":
-		// Create a working directory.  Close() removes it.
-		var wdErr error
-		db.SQLiteTempDir, wdErr = testsupport.CreateWorkingDirectory()
-		if wdErr != nil {
-			return wdErr
+	case "mysql":
+		var err error
+		db.Connection, err = ConnectToMySQL(db.Config)
+		if err != nil {
+			db.Config.Logger.Error("Connect: " + err.Error())
+			return err
 		}
 
-		// Attempts to use an in-memory database produced random failures
-		// due to the database being closed and cleared down prematurely
-		// after various queries had run.  Instead we use file databases
-		// in /tmp.
-
-		connectionDetails := "file:" + db.SQLiteTempDir + "/sqlite3.db"
+	case "sqlite":
+		// The database file must already exist - it's created by Admidio.
+		// (Tests use a temporary database - see ConnectForTestingWithSQLite.)
+		if len(db.Config.Path) == 0 {
+			return errors.New("Connect: no SQLite database file configured (DBPath)")
+		}
 
 		var connErr error
-		db.Connection, connErr = ConnectToSQLite(connectionDetails)
+		db.Connection, connErr = ConnectToSQLite(SQLiteConnectionDetails(db.Config.Path, false))
 		if connErr != nil {
 			return connErr
 		}
@@ -466,42 +567,82 @@ func (db *Database) Connect() error {
 	return nil
 }
 
-// Close closes the database connection.
+// Close closes the database connection.  If the connection was borrowed from
+// a Pool, it rolls back any unfinished transaction and leaves the pool open.
 func (db *Database) Close() error {
 
+	if db.borrowed {
+		// The connection belongs to the pool.  Just make sure that the
+		// transaction is finished, which hands the connection back.
+		db.Rollback()
+		return nil
+	}
+
 	var closeError error
 	if db.Connection != nil {
 		closeError = db.Connection.Close()
 
-		if db.Config.Type == "sqlite" {
-			// Whether the close worked or not, we must remove the DB file.
+		if db.Config.Type == "sqlite" && len(db.SQLiteTempDir) > 0 {
+			// The database is a temporary one.  Whether the close worked or
+			// not, we must remove the DB file.
 			testsupport.RemoveWorkingDirectory(db.SQLiteTempDir)
+			db.SQLiteTempDir = ""
 		}
 	}
 
 	return closeError
 }
 
-// BeginTx starts a transaction using the background context and
-// the default isolation options.  The transaction is stored in
+// BeginTx starts a transaction using the given context and the
+// default isolation options.  If the context is cancelled or its
+// deadline passes, the database driver abandons whatever query
+// is running and the transaction is rolled back.  The transaction is stored in
 // the Database object.  (The approach of storing a single
 // transaction makes sense in a web solution that opens a
 // transaction at the start of each HTTP request and closes
 // it at the end.)
-func (db *Database) BeginTx() error {
+func (db *Database) BeginTx(ctx context.Context) error {
 	var err error
-	db.Transaction, err = db.Connection.BeginTx(context.Background(), nil)
+	db.Transaction, err = db.Connection.BeginTx(ctx, nil)
 	return err
 }
 
-// Commit commits the stored transaction, if any.
+// logger returns the logger for warnings - the one set by the Pool or else the
+// one in the config.  It may be nil.
+func (db *Database) logger() *slog.Logger {
+	if db.Logger != nil {
+		return db.Logger
+	}
+	if db.Config != nil {
+		return db.Config.Logger
+	}
+	return nil
+}
+
+// Commit commits the stored transaction, if any.  If the commit fails, the
+// transaction is rolled back, so the cached IDs of any user fields that it
+// created are forgotten, as they are by Rollback.
 func (db *Database) Commit() error {
 
 	if db.Transaction == nil {
 		return nil
 	}
 
-	return db.Transaction.Commit()
+	commitError := db.Transaction.Commit()
+	if commitError != nil {
+		if db.fieldsAdded {
+			db.fieldIDs.forget()
+			db.fieldsAdded = false
+		}
+		db.systemUserID = 0
+		db.userLogChecked = false
+		return commitError
+	}
+
+	db.fieldsAdded = false
+
+	// Success!
+	return nil
 }
 
 // Rollback rolls back the stored transaction, if any.
@@ -511,36 +652,39 @@ func (db *Database) Rollback() error {
 		return nil
 	}
 
+	if db.fieldsAdded {
+		// The IDs of the new user fields may be cached but the fields
+		// are about to disappear.
+		db.fieldIDs.forget()
+		db.fieldsAdded = false
+	}
+
+	// The system user and adm_user_log may have been created by the
+	// transaction, so look for them again next time.
+	db.systemUserID = 0
+	db.userLogChecked = false
+
 	return db.Transaction.Rollback()
 }
 
 // Query executes the given query and returns the rows.  It massages
 // the query parameter placeholders into the correct form for the
 // database and uses db.sql.Query to do the work.
-func (db *Database) Query(query string, args ...any) (*sql.Rows, error) {
+func (db *Database) Query(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
 
-	if db.Config.Type == "sqlite" {
-		query = postgresParamsToSQLiteParams(query)
-	}
+	query, args = db.rewrite(query, args)
 
-	row, err := db.Transaction.Query(query, args...)
-	if db.Config.Type == "sqlite" {
-		tables := db.ListSQLiteTables()
-		_ = tables
-	}
-	return row, err
+	return db.Transaction.QueryContext(ctx, query, args...)
 }
 
 // QueryRow executes a query that is expected to return at most one row.
 // It massages the query parameter placeholders into the correct form for
 // the database and uses db.sql.QueryRow to do the work.
-func (db *Database) QueryRow(query string, args ...any) *sql.Row {
+func (db *Database) QueryRow(ctx context.Context, query string, args ...any) *sql.Row {
 
-	if db.Config.Type == "sqlite" {
-		query = postgresParamsToSQLiteParams(query)
-	}
+	query, args = db.rewrite(query, args)
 
-	row := db.Transaction.QueryRow(query, args...)
+	row := db.Transaction.QueryRowContext(ctx, query, args...)
 
 	return row
 }
@@ -548,13 +692,11 @@ func (db *Database) QueryRow(query string, args ...any) *sql.Row {
 // Exec executes an SQL statement such as an insert.
 // It massages the query parameter placeholders into the correct form for
 // the database and uses db.sql.Exec to do the work.
-func (db *Database) Exec(query string, args ...any) (sql.Result, error) {
+func (db *Database) Exec(ctx context.Context, query string, args ...any) (sql.Result, error) {
 
-	if db.Config.Type == "sqlite" {
-		query = postgresParamsToSQLiteParams(query)
-	}
+	query, args = db.rewrite(query, args)
 
-	result, err := db.Transaction.Exec(query, args...)
+	result, err := db.Transaction.ExecContext(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -564,8 +706,9 @@ func (db *Database) Exec(query string, args ...any) (sql.Result, error) {
 
 // CreateRow executes the given query and returns the id of the row.  It assumes that
 // the query is an insert.  It massages the query parameter placeholders into the
-// correct form for the database and uses db.sql.Query to do the work.
-func (db *Database) CreateRow(query string, args ...any) (int64, error) {
+// correct form for the database and uses db.sql.Query to do the work.  It's an
+// error if the insert doesn't produce an ID.
+func (db *Database) CreateRow(ctx context.Context, query string, args ...any) (int64, error) {
 
 	var id int64
 
@@ -573,15 +716,17 @@ func (db *Database) CreateRow(query string, args ...any) (int64, error) {
 	case "postgres":
 		// Postgress doesn't support LastInsertID so the query for postgress should contain a
 		// RETURNING clause that produces the ID for the scan.
-		err := db.Transaction.QueryRow(query, args...).Scan(&id)
+		query, args = db.rewrite(query, args)
+		err := db.Transaction.QueryRowContext(ctx, query, args...).Scan(&id)
 		if err != nil {
 			return 0, err
 		}
 
 	default:
-		// Databases such as SQLite supply the ID via LastInsertID.
-		query = postgresParamsToSQLiteParams(query)
-		res, err := db.Transaction.Exec(query, args...)
+		// Databases such as SQLite and MySQL supply the ID via LastInsertID.
+		// The query should not contain a RETURNING clause.
+		query, args = db.rewrite(query, args)
+		res, err := db.Transaction.ExecContext(ctx, query, args...)
 		if err != nil {
 			return 0, err
 		}
@@ -592,15 +737,21 @@ func (db *Database) CreateRow(query string, args ...any) (int64, error) {
 		}
 	}
 
+	if id == 0 {
+		return 0, errors.New("CreateRow: the insert produced no ID")
+	}
+
 	return id, nil
 }
 
 // UpdateRow executes the given query and returns the number of rows affected.  It assumes
 // that the query is an update.  It massages the query parameter placeholders into the
 // correct form for the database and uses db.sql.Query to do the work.
-func (db *Database) UpdateRow(query string, args ...any) (int64, error) {
+func (db *Database) UpdateRow(ctx context.Context, query string, args ...any) (int64, error) {
+
+	query, args = db.rewrite(query, args)
 
-	res, err := db.Transaction.Exec(query, args...)
+	res, err := db.Transaction.ExecContext(ctx, query, args...)
 	if err != nil {
 		return 0, err
 	}
@@ -616,13 +767,11 @@ func (db *Database) UpdateRow(query string, args ...any) (int64, error) {
 // DeleteRow executes a query which should be a delete.
 // It massages the query parameter placeholders into the correct form for
 // the database and uses db.sql.QueryRow to do the work.
-func (db *Database) DeleteRow(query string, args ...any) (int64, error) {
+func (db *Database) DeleteRow(ctx context.Context, query string, args ...any) (int64, error) {
 
-	if db.Config.Type == "sqlite" {
-		query = postgresParamsToSQLiteParams(query)
-	}
+	query, args = db.rewrite(query, args)
 
-	res, err1 := db.Transaction.Exec(query, args...)
+	res, err1 := db.Transaction.ExecContext(ctx, query, args...)
 	if err1 != nil {
 		return 0, err1
 	}
@@ -635,9 +784,73 @@ func (db *Database) DeleteRow(query string, args ...any) (int64, error) {
 	return numRows, nil
 }
 
+// Interrupted says why a query run with the given context was abandoned -
+// "timed out" if the context's deadline passed or "cancelled" if the context
+// was cancelled, for example because the browser went away.  It returns an
+// empty string if the context is still live, in which case any error from the
+// query is a genuine database error.
+func Interrupted(ctx context.Context) string {
+
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return "timed out"
+	case ctx.Err() != nil:
+		return "cancelled"
+	default:
+		return ""
+	}
+}
+
+// rewrite adapts the query to the table prefix and the version of Admidio (see
+// adapt) and converts its parameter placeholders to the form used by the
+// database, rearranging the arguments if necessary.
+func (db *Database) rewrite(query string, args []any) (string, []any) {
+	return db.placeholders(db.adapt(query), args)
+}
+
+// placeholders converts the parameter placeholders in the query from the
+// Postgres form ($1, $2 etc) to the form used by the database.  SQLite uses
+// ?1, ?2 etc.  MySQL uses ?, so the arguments are rearranged to match.
+func (db *Database) placeholders(query string, args []any) (string, []any) {
+
+	switch db.Config.Type {
+	case "postgres":
+		return query, args
+	case "mysql":
+		return postgresParamsToMySQLParams(query, args)
+	default:
+		return postgresParamsToSQLiteParams(query), args
+	}
+}
+
+// boolValue gives the value to store in a boolean column.  Postgres accepts
+// "t" and "f" and SQLite stores them as they are.  MySQL has no boolean type
+// - a boolean column is a small integer.
+func (db *Database) boolValue(b bool) any {
+
+	switch db.Config.Type {
+	case "mysql":
+		if b {
+			return 1
+		}
+		return 0
+	default:
+		if b {
+			return "t"
+		}
+		return "f"
+	}
+}
+
+// isTrue is true if the value read from a boolean column as a string means
+// true.  Postgres gives "true" (or "t"), SQLite gives "t" and MySQL gives "1".
+func isTrue(s string) bool {
+	return s == "t" || s == "true" || s == "1"
+}
+
 // ListSQLiteTables returns a list of the SQLite tables.
 // (Used for debugging.)
-func (db *Database) ListSQLiteTables() []string {
+func (db *Database) ListSQLiteTables(ctx context.Context) []string {
 
 	result := make([]string, 1)
 	if db.Config.Type != "sqlite" {
@@ -649,7 +862,7 @@ func (db *Database) ListSQLiteTables() []string {
 	// in the format "YYYY-MM-DD HH:MM:SS.SSS"
 	const sql = `SELECT name FROM sqlite_master WHERE type='table'`
 
-	rows, getNamesError := db.Connection.Query(sql)
+	rows, getNamesError := db.Connection.QueryContext(ctx, sql)
 
 	if getNamesError != nil {
 		result = append(result, getNamesError.Error())
@@ -708,7 +921,69 @@ func ConnectToPostgres(dbConfig *DBConfig) (*sql.DB, error) {
 	return conn, nil
 }
 
-// Connect connects to the database.
+// ConnectToMySQL connects to the MySQL or MariaDB database specified in the
+// config.
+func ConnectToMySQL(dbConfig *DBConfig) (*sql.DB, error) {
+
+	mysqlConfig := mysql.NewConfig()
+	mysqlConfig.User = dbConfig.User
+	mysqlConfig.Passwd = dbConfig.Pass
+	mysqlConfig.Net = "tcp"
+	mysqlConfig.Addr = net.JoinHostPort(dbConfig.Host, dbConfig.Port)
+	mysqlConfig.DBName = dbConfig.Name
+	// Dates and times are returned as strings, "YYYY-MM-DD" and
+	// "YYYY-MM-DD HH:MM:SS", as they are by SQLite.
+	mysqlConfig.ParseTime = false
+	// An update reports the number of rows that matched, not the number that
+	// changed, the same as the other databases.
+	mysqlConfig.ClientFoundRows = true
+	// The migrations are scripts containing several statements.
+	mysqlConfig.MultiStatements = true
+
+	conn, errConn := sql.Open("mysql", mysqlConfig.FormatDSN())
+	if errConn != nil {
+		em := fmt.Sprintf("ConnectToMySQL: open - %v", errConn)
+		return nil, errors.New(em)
+	}
+
+	// Ping actually opens the database connection.
+	errPing := conn.Ping()
+	if errPing != nil {
+		conn.Close()
+		em := fmt.Sprintf("ConnectToMySQL: ping - %v", errPing)
+		return nil, errors.New(em)
+	}
+
+	return conn, nil
+}
+
+// SQLiteBusyTimeout is how long an SQLite query waits for another connection
+// to finish writing before it fails with "database is locked".
+const SQLiteBusyTimeout = 5 * time.Second
+
+// SQLiteConnectionDetails gives the connection details for the SQLite
+// database in the given file.  Every connection uses write-ahead logging, so
+// that readers don't block the writer, waits SQLiteBusyTimeout for a lock and
+// enforces foreign keys.  If create is false the file must already exist.
+//
+// Transactions take the write lock when they begin (BEGIN IMMEDIATE).  By
+// default SQLite takes it at the first write, and if another connection has
+// written since the transaction's first read, it fails at once rather than
+// waiting.  Taking the lock up front means that concurrent requests queue.
+func SQLiteConnectionDetails(path string, create bool) string {
+
+	mode := "rw"
+	if create {
+		mode = "rwc"
+	}
+
+	// The modernc.org/sqlite driver runs each _pragma on every new connection.
+	return fmt.Sprintf(
+		"file:%s?mode=%s&_txlock=immediate&_pragma=journal_mode(WAL)&_pragma=busy_timeout(%d)&_pragma=foreign_keys(1)",
+		path, mode, SQLiteBusyTimeout.Milliseconds())
+}
+
+// ConnectToSQLite connects to the database and checks that it can be opened.
 func ConnectToSQLite(connectionDetails string) (*sql.DB, error) {
 
 	slog.Debug("ConnectToSQLite: " + connectionDetails)
@@ -719,17 +994,13 @@ func ConnectToSQLite(connectionDetails string) (*sql.DB, error) {
 		return nil, err
 	}
 
-	return conn, nil
-}
+	// Ping actually opens the database file.
+	errPing := conn.Ping()
+	if errPing != nil {
+		conn.Close()
+		em := fmt.Sprintf("ConnectToSQLite: %v", errPing)
+		return nil, errors.New(em)
+	}
 
-// postgresParamsToSQLiteParams takes a query string and converts any
-// Postgres-style parameter placeholders ('$1', '$2' etc) to sqlite-style
-// placeholders ('?').  It uses a simple regular expression replacement
-// so it can be defeated, for example by what looks like a placeholder
-// within an SQL string - "select '$1' from foo where bar=$1".
-func postgresParamsToSQLiteParams(query string) string {
-	resultBytes := regExpForPostgresParamsToSQLiteParams.
-		ReplaceAll([]byte(query), []byte("?"))
-	result := string(resultBytes)
-	return result
+	return conn, nil
 }
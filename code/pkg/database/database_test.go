@@ -1,43 +1,141 @@
 package database
 
 import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
+	"time"
 )
 
+// TestConnectSQLite checks that Connect opens an existing SQLite database
+// file with WAL, a busy timeout and foreign keys, and that Close leaves the
+// file alone.
 func TestConnectSQLite(t *testing.T) {
+	ctx := t.Context()
+
+	path := filepath.Join(t.TempDir(), "admidio.db")
+
+	// Connect refuses to create the database.
+	missingConfig := DBConfig{Type: "sqlite", Path: path, Logger: DBConfigForTestingWithSQLite.Logger}
+	missingError := New(&missingConfig).Connect()
+	if missingError == nil {
+		t.Error("want an error for a missing database file")
+	}
+
+	noPathConfig := DBConfig{Type: "sqlite", Logger: DBConfigForTestingWithSQLite.Logger}
+	noPathError := New(&noPathConfig).Connect()
+	if noPathError == nil {
+		t.Error("want an error when no database file is configured")
+	}
+
+	// Create the database.
+	conn, createError := ConnectToSQLite(SQLiteConnectionDetails(path, true))
+	if createError != nil {
+		t.Fatal(createError)
+	}
+	_, tableError := conn.Exec("CREATE TABLE t (x integer);")
+	if tableError != nil {
+		t.Fatal(tableError)
+	}
+	conn.Close()
+
+	config := DBConfig{Type: "sqlite", Path: path, Logger: DBConfigForTestingWithSQLite.Logger}
+	db := New(&config)
+
+	connError := db.Connect()
+	if connError != nil {
+		t.Fatal(connError)
+	}
+
+	var journalMode string
+	var busyTimeout, foreignKeys int
+	db.Connection.QueryRow("PRAGMA journal_mode;").Scan(&journalMode)
+	db.Connection.QueryRow("PRAGMA busy_timeout;").Scan(&busyTimeout)
+	db.Connection.QueryRow("PRAGMA foreign_keys;").Scan(&foreignKeys)
+
+	if journalMode != "wal" {
+		t.Errorf("want journal mode wal got %s", journalMode)
+	}
+	if int64(busyTimeout) != SQLiteBusyTimeout.Milliseconds() {
+		t.Errorf("want busy timeout %d got %d", SQLiteBusyTimeout.Milliseconds(), busyTimeout)
+	}
+	if foreignKeys != 1 {
+		t.Errorf("want foreign keys 1 got %d", foreignKeys)
+	}
+
+	db.BeginTx(ctx)
+	_, insertError := db.Exec(ctx, "INSERT INTO t (x) VALUES ($1);", 42)
+	if insertError != nil {
+		t.Fatal(insertError)
+	}
+	db.Commit()
+	db.Close()
+
+	// The data survive the close.
+	db = New(&config)
+	reconnectError := db.Connect()
+	if reconnectError != nil {
+		t.Fatal(reconnectError)
+	}
+	defer db.Close()
+
+	var x int
+	db.Connection.QueryRow("SELECT x FROM t;").Scan(&x)
+	if x != 42 {
+		t.Errorf("want 42 got %d", x)
+	}
+}
+
+// TestConnectForTestingWithSQLite checks that the temporary test database is
+// removed by CloseAndDelete.
+func TestConnectForTestingWithSQLite(t *testing.T) {
 
 	db := New(&DBConfigForTestingWithSQLite)
 
-	err := db.Connect()
+	err := db.ConnectForTestingWithSQLite()
 	if err != nil {
-		t.Error(err)
+		t.Fatal(err)
+	}
+
+	tempDir := db.SQLiteTempDir
+	if len(tempDir) == 0 {
+		t.Fatal("want a temporary directory")
 	}
 
-	defer db.CloseAndDelete()
+	db.CloseAndDelete()
 
-	if db == nil {
-		t.Error("should return non-nil")
+	_, statError := os.Stat(tempDir)
+	if !os.IsNotExist(statError) {
+		t.Errorf("want %s removed", tempDir)
 	}
 }
 
-// TestPostgresParamsToSQLiteParams checks the postgresParamsToSQLiteParams.
+// TestPostgresParamsToSQLiteParams checks that postgresParamsToSQLiteParams
+// numbers the placeholders and leaves quoted strings and comments alone.
 func TestPostgresParamsToSQLiteParams(t *testing.T) {
 
 	const multilineQuery = `abc$21
 		def$21`
-	const multilineResult = `abc?
-		def?`
+	const multilineResult = `abc?21
+		def?21`
 
 	var testData = []struct {
 		query string
 		want  string
 	}{
-		{"abc$1def$2", "abc?def?"},
-		{"$1$2$3", "???"},
+		{"abc$1def$2", "abc?1def?2"},
+		{"$1$2$3", "?1?2?3"},
 		{"noparams", "noparams"},
-		{"abc$21def$21", "abc?def?"},
+		{"abc$21def$21", "abc?21def?21"},
 		{multilineQuery, multilineResult},
+		{"$2 $1 $2", "?2 ?1 ?2"},
+		{"select '$1' from foo where bar=$1", "select '$1' from foo where bar=?1"},
+		{`select "a$1" from foo -- $2` + "\n where x = $2 /* $3 */", `select "a$1" from foo -- $2` + "\n where x = ?2 /* $3 */"},
+		{"select 'it''s $1', $1", "select 'it''s $1', ?1"},
+		{"price $ and $0", "price $ and $0"},
 	}
 
 	for _, td := range testData {
@@ -48,9 +146,100 @@ func TestPostgresParamsToSQLiteParams(t *testing.T) {
 	}
 }
 
+// TestBoolValue checks the values stored in and read from boolean columns.
+func TestBoolValue(t *testing.T) {
+
+	var testData = []struct {
+		dbType    string
+		wantTrue  any
+		wantFalse any
+	}{
+		{"postgres", "t", "f"},
+		{"mysql", 1, 0},
+		{"sqlite", "t", "f"},
+	}
+
+	for _, td := range testData {
+		db := New(&DBConfig{Type: td.dbType})
+		if db.boolValue(true) != td.wantTrue {
+			t.Errorf("%s: want %v got %v", td.dbType, td.wantTrue, db.boolValue(true))
+		}
+		if db.boolValue(false) != td.wantFalse {
+			t.Errorf("%s: want %v got %v", td.dbType, td.wantFalse, db.boolValue(false))
+		}
+		if !isTrue(fmt.Sprint(td.wantTrue)) {
+			t.Errorf("%s: want %v to be true", td.dbType, td.wantTrue)
+		}
+		if isTrue(fmt.Sprint(td.wantFalse)) {
+			t.Errorf("%s: want %v to be false", td.dbType, td.wantFalse)
+		}
+	}
+
+	if !isTrue("true") || isTrue("false") {
+		t.Error("want the Postgres values to be understood")
+	}
+}
+
+// TestInterrupted checks that a query fails when its context is cancelled or
+// its deadline has passed and that Interrupted says which.
+func TestInterrupted(t *testing.T) {
+
+	for _, dbType := range databaseList {
+
+		db, connError := OpenDBForTesting(dbType)
+		if connError != nil {
+			t.Error(connError)
+			continue
+		}
+
+		defer db.CloseAndDelete()
+
+		ctx, cancel := context.WithCancel(t.Context())
+
+		txError := db.BeginTx(ctx)
+		if txError != nil {
+			t.Errorf("%s: %v", dbType, txError)
+			cancel()
+			continue
+		}
+
+		if got := Interrupted(ctx); got != "" {
+			t.Errorf("%s: want an empty string got %s", dbType, got)
+		}
+
+		cancel()
+
+		var n int
+		scanError := db.QueryRow(ctx, "SELECT 1;").Scan(&n)
+		if scanError == nil {
+			t.Errorf("%s: want an error from a cancelled query", dbType)
+		}
+
+		if got := Interrupted(ctx); got != "cancelled" {
+			t.Errorf("%s: want cancelled got %s", dbType, got)
+		}
+
+		// A transaction can't be started once the deadline has passed.
+		expired, cancelExpired := context.WithDeadline(t.Context(), time.Now().Add(-time.Second))
+
+		txError = db.BeginTx(expired)
+		if txError == nil {
+			t.Errorf("%s: want an error from an expired context", dbType)
+			db.Rollback()
+		}
+
+		if got := Interrupted(expired); got != "timed out" {
+			t.Errorf("%s: want timed out got %s", dbType, got)
+		}
+
+		cancelExpired()
+	}
+}
+
 // TestUpdateRowWithAssociate checks ms.UpdateRow when there is an ordinary member and an
 // associate member.  (The Update separate logic and SQL for this.)
 func TestUpdateRowWithAssociate(t *testing.T) {
+	ctx := t.Context()
 
 	for _, dbType := range databaseList {
 		db, connError := OpenDBForTesting(dbType)
@@ -62,7 +251,7 @@ func TestUpdateRowWithAssociate(t *testing.T) {
 
 		db.Logger = createLoggerForTesting()
 
-		txError := db.BeginTx()
+		txError := db.BeginTx(ctx)
 		if txError != nil {
 			t.Error(txError)
 			return
@@ -76,34 +265,34 @@ func TestUpdateRowWithAssociate(t *testing.T) {
 		}
 
 		// We need two users.
-		u1Name, uuidError1 := CreateUuid(db.Transaction, "usr_login_name", "adm_users")
+		u1Name, uuidError1 := CreateUuid(ctx, db.Transaction, "usr_login_name", "adm_users")
 		if uuidError1 != nil {
 			t.Error(uuidError1)
 		}
 		u1 := NewUser(u1Name)
-		u1Err := db.CreateUser(u1)
+		u1Err := db.CreateUser(ctx, u1)
 		if u1Err != nil {
 			t.Error(u1Err)
 			return
 		}
 
-		u2Name, uuidError2 := CreateUuid(db.Transaction, "usr_login_name", "adm_users")
+		u2Name, uuidError2 := CreateUuid(ctx, db.Transaction, "usr_login_name", "adm_users")
 		if uuidError2 != nil {
 			t.Error(uuidError2)
 		}
 		u2 := NewUser(u2Name)
-		u2Err := db.CreateUser(u2)
+		u2Err := db.CreateUser(ctx, u2)
 		if u2Err != nil {
 			t.Error(u2Err)
 			return
 		}
 
-		u3Name, uuidError3 := CreateUuid(db.Transaction, "usr_login_name", "adm_users")
+		u3Name, uuidError3 := CreateUuid(ctx, db.Transaction, "usr_login_name", "adm_users")
 		if uuidError3 != nil {
 			t.Error(uuidError3)
 		}
 		u3 := NewUser(u3Name)
-		u3Err := db.CreateUser(u3)
+		u3Err := db.CreateUser(ctx, u3)
 		if u3Err != nil {
 			t.Error(u3Err)
 			return
@@ -119,7 +308,7 @@ func TestUpdateRowWithAssociate(t *testing.T) {
 			MembershipYear:  2024,
 		}
 
-		ms1ID, ms1Err := ms1.Create(db)
+		ms1ID, ms1Err := ms1.Create(ctx, db)
 		if ms1Err != nil {
 			t.Error(ms1Err)
 			return
@@ -156,14 +345,14 @@ func TestUpdateRowWithAssociate(t *testing.T) {
 			EnableGiftaid:          false,
 		}
 
-		ms2ID, ms2Err := ms2Orig.Create(db)
+		ms2ID, ms2Err := ms2Orig.Create(ctx, db)
 		if ms2Err != nil {
 			t.Error(ms2Err)
 			return
 		}
 
 		// ms2 should be a copy of ms2Orig.
-		ms2, fetchMSError1 := db.GetMembershipSale(ms2ID)
+		ms2, fetchMSError1 := db.GetMembershipSale(ctx, ms2ID)
 		if fetchMSError1 != nil {
 			t.Error(fetchMSError1)
 			return
@@ -202,14 +391,14 @@ func TestUpdateRowWithAssociate(t *testing.T) {
 		ms2Copy := *ms2
 
 		// Update
-		ms2Err2 := ms2.Update(db)
+		ms2Err2 := ms2.Update(ctx, db)
 		if ms2Err2 != nil {
 			t.Error(ms2Err2)
 			return
 		}
 
 		// Fetch.
-		ms3, fetchMSError2 := db.GetMembershipSale(ms2ID)
+		ms3, fetchMSError2 := db.GetMembershipSale(ctx, ms2ID)
 		if fetchMSError2 != nil {
 			t.Error(fetchMSError2)
 			return
@@ -224,7 +413,7 @@ func TestUpdateRowWithAssociate(t *testing.T) {
 		// The update should only affect one record.  A fairly simple mistake in the SQL
 		// would make it update all records.  To guard against that, check that ms1 has not
 		// been touched.
-		ms1Fetched, fetchMS1Error := db.GetMembershipSale(ms1ID)
+		ms1Fetched, fetchMS1Error := db.GetMembershipSale(ctx, ms1ID)
 		if fetchMS1Error != nil {
 			t.Error(fetchMS1Error)
 			return
@@ -241,6 +430,7 @@ func TestUpdateRowWithAssociate(t *testing.T) {
 // TestUpdateRowWithNoAssociate checks ms.UpdateRow when there is just an ordinary member and no
 // associate.  (The Update has separate logic and SQL for this.)
 func TestUpdateRowWithNoAssociate(t *testing.T) {
+	ctx := t.Context()
 
 	for _, dbType := range databaseList {
 		db, connError := OpenDBForTesting(dbType)
@@ -252,7 +442,7 @@ func TestUpdateRowWithNoAssociate(t *testing.T) {
 
 		db.Logger = createLoggerForTesting()
 
-		txError := db.BeginTx()
+		txError := db.BeginTx(ctx)
 		if txError != nil {
 			t.Error(txError)
 			return
@@ -266,23 +456,23 @@ func TestUpdateRowWithNoAssociate(t *testing.T) {
 		}
 
 		// We need two users.
-		u1Name, uuidError1 := CreateUuid(db.Transaction, "usr_login_name", "adm_users")
+		u1Name, uuidError1 := CreateUuid(ctx, db.Transaction, "usr_login_name", "adm_users")
 		if uuidError1 != nil {
 			t.Error(uuidError1)
 		}
 		u1 := NewUser(u1Name)
-		u1Err := db.CreateUser(u1)
+		u1Err := db.CreateUser(ctx, u1)
 		if u1Err != nil {
 			t.Error(u1Err)
 			return
 		}
 
-		u2Name, uuidError2 := CreateUuid(db.Transaction, "usr_login_name", "adm_users")
+		u2Name, uuidError2 := CreateUuid(ctx, db.Transaction, "usr_login_name", "adm_users")
 		if uuidError2 != nil {
 			t.Error(uuidError2)
 		}
 		u2 := NewUser(u2Name)
-		u2Err := db.CreateUser(u2)
+		u2Err := db.CreateUser(ctx, u2)
 		if u2Err != nil {
 			t.Error(u2Err)
 			return
@@ -297,7 +487,7 @@ func TestUpdateRowWithNoAssociate(t *testing.T) {
 			MembershipYear:  2024,
 		}
 
-		ms1ID, ms1Err := ms1.Create(db)
+		ms1ID, ms1Err := ms1.Create(ctx, db)
 		if ms1Err != nil {
 			t.Error(ms1Err)
 			return
@@ -326,14 +516,14 @@ func TestUpdateRowWithNoAssociate(t *testing.T) {
 			EnableGiftaid:          false,
 		}
 
-		ms2ID, ms2Err := ms2Orig.Create(db)
+		ms2ID, ms2Err := ms2Orig.Create(ctx, db)
 		if ms2Err != nil {
 			t.Error(ms2Err)
 			return
 		}
 
 		// ms2 should be a copy of ms2Orig.
-		ms2, fetchMSError1 := db.GetMembershipSale(ms2ID)
+		ms2, fetchMSError1 := db.GetMembershipSale(ctx, ms2ID)
 		if fetchMSError1 != nil {
 			t.Error(fetchMSError1)
 			return
@@ -368,14 +558,14 @@ func TestUpdateRowWithNoAssociate(t *testing.T) {
 		ms2Copy := *ms2
 
 		// Update
-		ms2Err2 := ms2.Update(db)
+		ms2Err2 := ms2.Update(ctx, db)
 		if ms2Err2 != nil {
 			t.Error(ms2Err2)
 			return
 		}
 
 		// Fetch.
-		ms3, fetchMSError2 := db.GetMembershipSale(ms2ID)
+		ms3, fetchMSError2 := db.GetMembershipSale(ctx, ms2ID)
 		if fetchMSError2 != nil {
 			t.Error(fetchMSError2)
 			return
@@ -390,7 +580,7 @@ func TestUpdateRowWithNoAssociate(t *testing.T) {
 		// The yupdate should only affect one record.  A fairly simple mistake in the SQL
 		// would make it update all records.  To guard against that, check that ms1 has not
 		// been touched.
-		ms1Fetched, fetchMS1Error := db.GetMembershipSale(ms1ID)
+		ms1Fetched, fetchMS1Error := db.GetMembershipSale(ctx, ms1ID)
 		if fetchMS1Error != nil {
 			t.Error(fetchMS1Error)
 			return
@@ -534,3 +724,44 @@ func TestMembershipSaleDisplays(t *testing.T) {
 		}
 	}
 }
+
+// TestRoleName checks that RoleName gives the configured role for each type
+// of membership and the default when none is configured.
+func TestRoleName(t *testing.T) {
+
+	configured := DBConfig{
+		OrdinaryRole:  "Ordinary Member",
+		AssociateRole: "Associate Member",
+		FriendRole:    "Friend",
+	}
+
+	var testData = []struct {
+		description    string
+		config         DBConfig
+		membershipType string
+		want           string
+	}{
+		{"ordinary default", DBConfig{}, MembershipTypeOrdinary, RoleNameMember},
+		{"associate default", DBConfig{}, MembershipTypeAssociate, RoleNameMember},
+		{"friend default", DBConfig{}, MembershipTypeFriend, ""},
+		{"ordinary", configured, MembershipTypeOrdinary, "Ordinary Member"},
+		{"associate", configured, MembershipTypeAssociate, "Associate Member"},
+		{"friend", configured, MembershipTypeFriend, "Friend"},
+		{"unknown", configured, "patron", ""},
+	}
+
+	for _, td := range testData {
+		got := td.config.RoleName(td.membershipType)
+		if td.want != got {
+			t.Errorf("%s: want %q got %q", td.description, td.want, got)
+		}
+	}
+
+	// The default roles of ordinary and associate members are the same and
+	// friends have none.
+	defaults := DBConfig{}
+	got := defaults.RoleNames(MembershipTypes...)
+	if len(got) != 1 || got[0] != RoleNameMember {
+		t.Errorf("want [%s] got %v", RoleNameMember, got)
+	}
+}
@@ -0,0 +1,461 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Finding and merging duplicate accounts.  The importer and the associate
+// members' "first.last" logins have left some people with more than one
+// account.  FindDuplicates uses the same scoring as the matching at checkout
+// (see ScoreMatch) to find pairs of accounts that are likely to belong to the
+// same person.  MergeUsers moves everything that belongs to one account onto
+// the other.  Admidio refers to users from many tables, so the account that is
+// merged away is not deleted but marked invalid, for an administrator to
+// delete in Admidio once they are happy with the merge.
+
+// Duplicate is a pair of accounts that may belong to the same person.
+type Duplicate struct {
+	First   MatchDetails // The account with the lower user ID.
+	Second  MatchDetails // The other account.
+	Score   int          // How well they match (see ScoreMatch).
+	Reasons []string     // What matched.
+}
+
+// FindDuplicates finds the pairs of accounts that match each other with a
+// score of at least MinimumMatchScore, best first.  Only accounts that share
+// an email address, a last name or a postcode are compared, since no other
+// pair can score enough.
+// It's assumed that a transaction is already set up in the db object.
+func (db *Database) FindDuplicates(ctx context.Context) ([]Duplicate, error) {
+
+	accounts, accountsError := db.getMatchDetails(ctx)
+	if accountsError != nil {
+		return nil, accountsError
+	}
+
+	// Group the accounts by the details that they may share.
+	groups := make(map[string][]int)
+	for i := range accounts {
+		a := &accounts[i]
+		keys := make([]string, 0, 4)
+		for _, email := range a.emailAddresses() {
+			keys = append(keys, "email:"+email)
+		}
+		if lastName := strings.ReplaceAll(NormaliseName(a.LastName), " ", ""); len(lastName) > 0 {
+			keys = append(keys, "last name:"+lastName)
+		}
+		if postcode := NormalisePostcode(a.Postcode); len(postcode) > 0 {
+			keys = append(keys, "postcode:"+postcode)
+		}
+		for _, key := range keys {
+			groups[key] = append(groups[key], i)
+		}
+	}
+
+	duplicates := make([]Duplicate, 0)
+	compared := make(map[[2]int]bool)
+	for _, group := range groups {
+		for x := 0; x < len(group); x++ {
+			for y := x + 1; y < len(group); y++ {
+				pair := [2]int{group[x], group[y]}
+				if compared[pair] {
+					continue
+				}
+				compared[pair] = true
+
+				first, second := &accounts[pair[0]], &accounts[pair[1]]
+				if first.UserID == second.UserID {
+					continue
+				}
+				score, reasons := ScoreMatch(first, second)
+				if score >= MinimumMatchScore {
+					duplicates = append(duplicates, Duplicate{*first, *second, score, reasons})
+				}
+			}
+		}
+	}
+
+	sort.Slice(duplicates, func(i, j int) bool {
+		a, b := &duplicates[i], &duplicates[j]
+		if a.Score != b.Score {
+			return a.Score > b.Score
+		}
+		if a.First.UserID != b.First.UserID {
+			return a.First.UserID < b.First.UserID
+		}
+		return a.Second.UserID < b.Second.UserID
+	})
+
+	return duplicates, nil
+}
+
+// MergeUsers moves the memberships, profile data, interests and sales of the
+// user dropID onto the user keepID and marks dropID invalid.  Where both users
+// have a membership of the same role, the membership of keepID is widened to
+// cover both.  Where both have a value for a profile field, the value of
+// keepID is kept.  It returns a description of each thing that it did.  It
+// doesn't commit, so the caller can roll back to get a dry run.
+// It's assumed that a transaction is already set up in the db object.
+func (db *Database) MergeUsers(ctx context.Context, keepID, dropID int64) ([]string, error) {
+
+	const fn = "MergeUsers"
+
+	if keepID == dropID {
+		em := fmt.Sprintf("%s: can't merge user %d with itself", fn, keepID)
+		return nil, errors.New(em)
+	}
+
+	systemUserID, systemUserError := db.SystemUserID(ctx)
+	if systemUserError != nil {
+		em := fmt.Sprintf("%s: %v", fn, systemUserError)
+		return nil, errors.New(em)
+	}
+
+	for _, id := range []int64{keepID, dropID} {
+		if id == systemUserID {
+			em := fmt.Sprintf("%s: user %d is the system user", fn, id)
+			return nil, errors.New(em)
+		}
+		if _, err := db.GetUser(ctx, id); err != nil {
+			em := fmt.Sprintf("%s: user %d - %v", fn, id, err)
+			return nil, errors.New(em)
+		}
+	}
+
+	done := make([]string, 0)
+
+	steps := []func(context.Context, int64, int64) ([]string, error){
+		db.mergeMemberships,
+		db.mergeUserData,
+		db.mergeInterests,
+		db.moveUserReferences,
+	}
+	for _, step := range steps {
+		d, stepError := step(ctx, keepID, dropID)
+		if stepError != nil {
+			em := fmt.Sprintf("%s: %v", fn, stepError)
+			return nil, errors.New(em)
+		}
+		done = append(done, d...)
+	}
+
+	const invalidateSQL = `
+		UPDATE adm_users
+		SET usr_valid = $1, usr_usr_id_change = $2, usr_timestamp_change = CURRENT_TIMESTAMP
+		WHERE usr_id = $3;
+	`
+	if _, err := db.Exec(ctx, invalidateSQL, false, systemUserID, dropID); err != nil {
+		em := fmt.Sprintf("%s: %v", fn, err)
+		return nil, errors.New(em)
+	}
+	done = append(done, fmt.Sprintf("user %d marked invalid - delete it in Admidio when you are happy with the merge", dropID))
+
+	keep := strconv.FormatInt(keepID, 10)
+	drop := strconv.FormatInt(dropID, 10)
+	if err := db.logChanges(ctx, dropID, change{item: "merged_into", newValue: keep}); err != nil {
+		em := fmt.Sprintf("%s: %v", fn, err)
+		return nil, errors.New(em)
+	}
+	if err := db.logChanges(ctx, keepID, change{item: "merged_from", newValue: drop}); err != nil {
+		em := fmt.Sprintf("%s: %v", fn, err)
+		return nil, errors.New(em)
+	}
+
+	// Success!
+	return done, nil
+}
+
+// mergeMemberships moves the adm_members records of the user dropID to the
+// user keepID.  If keepID already has a membership of the same role, that one
+// is widened to cover both periods and the other is deleted.
+func (db *Database) mergeMemberships(ctx context.Context, keepID, dropID int64) ([]string, error) {
+
+	const query = `
+		SELECT m.mem_id, m.mem_usr_id, m.mem_rol_id, r.rol_name, m.mem_begin, m.mem_end
+		FROM adm_members AS m
+		JOIN adm_roles AS r ON r.rol_id = m.mem_rol_id
+		WHERE m.mem_usr_id IN ($1, $2)
+		ORDER BY m.mem_id;
+	`
+
+	type membership struct {
+		id, userID, roleID int64
+		roleName           string
+		begin, end         any
+	}
+
+	rows, searchError := db.Query(ctx, query, keepID, dropID)
+	if searchError != nil {
+		return nil, searchError
+	}
+	defer rows.Close()
+
+	kept := make(map[int64]*membership)
+	dropped := make([]*membership, 0)
+	for rows.Next() {
+		var m membership
+		if err := rows.Scan(&m.id, &m.userID, &m.roleID, &m.roleName, &m.begin, &m.end); err != nil {
+			return nil, err
+		}
+		if m.userID == keepID {
+			if _, found := kept[m.roleID]; !found {
+				kept[m.roleID] = &m
+			}
+		} else {
+			dropped = append(dropped, &m)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	done := make([]string, 0, len(dropped))
+	for _, m := range dropped {
+
+		k, found := kept[m.roleID]
+		if !found {
+			const moveSQL = `UPDATE adm_members SET mem_usr_id = $1 WHERE mem_id = $2;`
+			if _, err := db.Exec(ctx, moveSQL, keepID, m.id); err != nil {
+				return nil, err
+			}
+			done = append(done, fmt.Sprintf("membership %d (%s, %s to %s) moved",
+				m.id, m.roleName, dateOf(m.begin), dateOf(m.end)))
+			continue
+		}
+
+		// Both users have the role.  Keep one membership covering both.
+		begin, end := k.begin, k.end
+		if dateOf(m.begin) < dateOf(begin) {
+			begin = m.begin
+		}
+		if dateOf(m.end) > dateOf(end) {
+			end = m.end
+		}
+
+		const widenSQL = `UPDATE adm_members SET mem_begin = $1, mem_end = $2 WHERE mem_id = $3;`
+		if _, err := db.Exec(ctx, widenSQL, begin, end, k.id); err != nil {
+			return nil, err
+		}
+		const deleteSQL = `DELETE FROM adm_members WHERE mem_id = $1;`
+		if _, err := db.Exec(ctx, deleteSQL, m.id); err != nil {
+			return nil, err
+		}
+		done = append(done, fmt.Sprintf("membership %d (%s) combined with membership %d - now %s to %s",
+			m.id, m.roleName, k.id, dateOf(begin), dateOf(end)))
+	}
+
+	return done, nil
+}
+
+// mergeUserData moves the profile fields of the user dropID that the user
+// keepID hasn't set.  Where both have set a field, the value of keepID is
+// kept and the other is discarded.  The moved values are recorded in the
+// change history of keepID.
+func (db *Database) mergeUserData(ctx context.Context, keepID, dropID int64) ([]string, error) {
+
+	const query = `
+		SELECT d.usd_id, d.usd_usr_id, d.usd_usf_id, f.usf_name_intern, d.usd_value
+		FROM adm_user_data AS d
+		JOIN adm_user_fields AS f ON f.usf_id = d.usd_usf_id
+		WHERE d.usd_usr_id IN ($1, $2)
+		ORDER BY f.usf_name_intern, d.usd_usr_id;
+	`
+
+	type datum struct {
+		id, userID, fieldID int64
+		name                string
+		value               string
+	}
+
+	rows, searchError := db.Query(ctx, query, keepID, dropID)
+	if searchError != nil {
+		return nil, searchError
+	}
+	defer rows.Close()
+
+	kept := make(map[int64]datum)
+	dropped := make([]datum, 0)
+	for rows.Next() {
+		var d datum
+		var value sql.NullString
+		if err := rows.Scan(&d.id, &d.userID, &d.fieldID, &d.name, &value); err != nil {
+			return nil, err
+		}
+		d.value = value.String
+		if d.userID == keepID {
+			kept[d.fieldID] = d
+		} else {
+			dropped = append(dropped, d)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	done := make([]string, 0, len(dropped))
+	changes := make([]change, 0, len(dropped))
+	for _, d := range dropped {
+
+		k, found := kept[d.fieldID]
+		switch {
+		case len(d.value) == 0:
+			// Nothing worth keeping.
+		case !found || len(k.value) == 0:
+			if found {
+				const deleteSQL = `DELETE FROM adm_user_data WHERE usd_id = $1;`
+				if _, err := db.Exec(ctx, deleteSQL, k.id); err != nil {
+					return nil, err
+				}
+			}
+			const moveSQL = `UPDATE adm_user_data SET usd_usr_id = $1 WHERE usd_id = $2;`
+			if _, err := db.Exec(ctx, moveSQL, keepID, d.id); err != nil {
+				return nil, err
+			}
+			done = append(done, fmt.Sprintf("%s: moved %q", d.name, d.value))
+			changes = append(changes, profileChange(d.fieldID, k.value, d.value))
+			continue
+		case k.value == d.value:
+			// The same value - nothing is lost.
+		default:
+			done = append(done, fmt.Sprintf("%s: kept %q, discarded %q", d.name, k.value, d.value))
+		}
+
+		const deleteSQL = `DELETE FROM adm_user_data WHERE usd_id = $1;`
+		if _, err := db.Exec(ctx, deleteSQL, d.id); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := db.logChanges(ctx, keepID, changes...); err != nil {
+		return nil, err
+	}
+
+	return done, nil
+}
+
+// mergeInterests moves the interests of the user dropID to the user keepID,
+// leaving out any that keepID already has, and combines their other
+// interests.  It does nothing if the database has no interests tables.
+func (db *Database) mergeInterests(ctx context.Context, keepID, dropID int64) ([]string, error) {
+
+	done := make([]string, 0)
+
+	found, existsError := db.tableExists(ctx, "adm_members_interests")
+	if existsError != nil {
+		return nil, existsError
+	}
+	if found {
+		const moveSQL = `
+			UPDATE adm_members_interests
+			SET mi_usr_id = $1
+			WHERE mi_usr_id = $2
+			AND mi_interest_id NOT IN (
+				SELECT mi_interest_id FROM (
+					SELECT mi_interest_id FROM adm_members_interests WHERE mi_usr_id = $1
+				) AS kept
+			);
+		`
+		moved, moveError := db.UpdateRow(ctx, moveSQL, keepID, dropID)
+		if moveError != nil {
+			return nil, moveError
+		}
+
+		const deleteSQL = `DELETE FROM adm_members_interests WHERE mi_usr_id = $1;`
+		if _, err := db.DeleteRow(ctx, deleteSQL, dropID); err != nil {
+			return nil, err
+		}
+
+		if moved > 0 {
+			done = append(done, fmt.Sprintf("%d interests moved", moved))
+		}
+	}
+
+	found, existsError = db.tableExists(ctx, "adm_members_other_interests")
+	if existsError != nil {
+		return nil, existsError
+	}
+	if !found {
+		return done, nil
+	}
+
+	dropOther, dropError := db.GetMembersOtherInterests(ctx, dropID)
+	if dropError == sql.ErrNoRows {
+		return done, nil
+	}
+	if dropError != nil {
+		return nil, dropError
+	}
+
+	keepOther, keepError := db.GetMembersOtherInterests(ctx, keepID)
+	switch {
+	case keepError == sql.ErrNoRows:
+		const moveSQL = `UPDATE adm_members_other_interests SET moi_usr_id = $1 WHERE moi_id = $2;`
+		if _, err := db.Exec(ctx, moveSQL, keepID, dropOther.ID); err != nil {
+			return nil, err
+		}
+		return append(done, fmt.Sprintf("other interests moved: %q", dropOther.Interests)), nil
+	case keepError != nil:
+		return nil, keepError
+	}
+
+	if len(dropOther.Interests) > 0 && dropOther.Interests != keepOther.Interests {
+		if len(keepOther.Interests) > 0 {
+			keepOther.Interests += "; "
+		}
+		keepOther.Interests += dropOther.Interests
+		if err := db.UpdateMembersOtherInterests(ctx, keepOther); err != nil {
+			return nil, err
+		}
+		done = append(done, fmt.Sprintf("other interests combined: %q", keepOther.Interests))
+	}
+
+	const deleteSQL = `DELETE FROM adm_members_other_interests WHERE moi_id = $1;`
+	if _, err := db.DeleteRow(ctx, deleteSQL, dropOther.ID); err != nil {
+		return nil, err
+	}
+
+	return done, nil
+}
+
+// userReferences are the columns outside adm_members, adm_user_data and the
+// interests tables that refer to a user and move with a merge.  The change
+// history (membership_change_log and adm_user_log) doesn't move - it records
+// what was done to each account, so rewriting it would falsify the record.
+// The merge itself is recorded in both accounts' histories.
+var userReferences = []struct {
+	table  string
+	column string
+}{
+	{"membership_sales", "ms_usr1_id"},
+	{"membership_sales", "ms_usr2_id"},
+	{"membership_verifications", "mv_usr_id"},
+}
+
+// moveUserReferences changes the references to the user dropID in the
+// columns in userReferences to refer to the user keepID.
+func (db *Database) moveUserReferences(ctx context.Context, keepID, dropID int64) ([]string, error) {
+
+	done := make([]string, 0)
+
+	for _, ref := range userReferences {
+
+		moveSQL := fmt.Sprintf(`UPDATE %s SET %s = $1 WHERE %s = $2;`, ref.table, ref.column, ref.column)
+		moved, moveError := db.UpdateRow(ctx, moveSQL, keepID, dropID)
+		if moveError != nil {
+			return nil, moveError
+		}
+		if moved > 0 {
+			done = append(done, fmt.Sprintf("%s.%s: %d rows moved", ref.table, ref.column, moved))
+		}
+	}
+
+	return done, nil
+}
@@ -0,0 +1,259 @@
+package database
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestFindDuplicates checks that FindDuplicates finds accounts that are likely
+// to belong to the same person and leaves out the others.
+func TestFindDuplicates(t *testing.T) {
+	ctx := t.Context()
+
+	for _, dbType := range databaseList {
+
+		db, connError := ConnectForTesting(dbType)
+		if connError != nil {
+			t.Error(connError)
+			continue
+		}
+
+		defer db.Rollback()
+		defer db.CloseAndDelete()
+
+		users := make([]*User, 4)
+		for i := range users {
+			var createError error
+			users[i], createError = CreateUser(ctx, db)
+			if createError != nil {
+				t.Fatal(createError)
+			}
+		}
+
+		// The names must be unique in a shared test database.
+		lastName := users[0].LoginName
+
+		// The first two are the same person at the same address.  The third
+		// is somebody else at that address.  The fourth has an email address
+		// that the first two don't.
+		SetProfileField(ctx, db, users[0].ID, "FIRST_NAME", "Robert")
+		SetProfileField(ctx, db, users[0].ID, "LAST_NAME", lastName)
+		SetProfileField(ctx, db, users[0].ID, "POSTCODE", "KT22 1AA")
+		SetProfileField(ctx, db, users[1].ID, "FIRST_NAME", "Bob")
+		SetProfileField(ctx, db, users[1].ID, "LAST_NAME", strings.ToUpper(lastName))
+		SetProfileField(ctx, db, users[1].ID, "POSTCODE", "kt221aa")
+		SetProfileField(ctx, db, users[2].ID, "FIRST_NAME", "Jane")
+		SetProfileField(ctx, db, users[2].ID, "LAST_NAME", lastName+"x")
+		SetProfileField(ctx, db, users[2].ID, "POSTCODE", "KT22 1AA")
+		SetProfileField(ctx, db, users[3].ID, "EMAIL", lastName+"@example.com")
+
+		duplicates, findError := db.FindDuplicates(ctx)
+		if findError != nil {
+			t.Errorf("%s: %v", dbType, findError)
+			continue
+		}
+
+		found := false
+		for _, d := range duplicates {
+			for _, u := range users[2:] {
+				if d.First.UserID == u.ID || d.Second.UserID == u.ID {
+					t.Errorf("%s: user %d should not be a duplicate - %v", dbType, u.ID, d)
+				}
+			}
+			if d.First.UserID == users[0].ID && d.Second.UserID == users[1].ID {
+				found = true
+				want := ScoreLastName + ScoreNickname + ScorePostcode
+				if d.Score != want {
+					t.Errorf("%s: want score %d got %d", dbType, want, d.Score)
+				}
+			}
+		}
+		if !found {
+			t.Errorf("%s: want users %d and %d in %v", dbType, users[0].ID, users[1].ID, duplicates)
+		}
+	}
+}
+
+// TestMergeUsers checks that MergeUsers moves the memberships, profile data,
+// interests and sales of one account onto another.
+func TestMergeUsers(t *testing.T) {
+	ctx := t.Context()
+
+	for _, dbType := range databaseList {
+
+		db, connError := ConnectForTesting(dbType)
+		if connError != nil {
+			t.Error(connError)
+			continue
+		}
+
+		defer db.Rollback()
+		defer db.CloseAndDelete()
+
+		role, roleError := db.GetRole(ctx, RoleNameMember)
+		if roleError != nil {
+			t.Errorf("%s: %v", dbType, roleError)
+			continue
+		}
+
+		interest1, _ := db.GetInterestByName(ctx, TestInterests1)
+		interest2, _ := db.GetInterestByName(ctx, TestInterests2)
+		if interest1 == nil || interest2 == nil {
+			t.Errorf("%s: no test interests", dbType)
+			continue
+		}
+
+		date := func(year int, month time.Month, day int) time.Time {
+			return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+		}
+
+		keepLogin, _ := CreateUuid(ctx, db.Transaction, "usr_login_name", "adm_users")
+		keep, _, keepError := db.CreateUserAndMember(ctx, keepLogin, "Mr", "Robert", "Smith",
+			role, date(2020, time.January, 1), date(2024, time.December, 31))
+		if keepError != nil {
+			t.Errorf("%s: %v", dbType, keepError)
+			continue
+		}
+
+		dropLogin, _ := CreateUuid(ctx, db.Transaction, "usr_login_name", "adm_users")
+		drop, _, dropError := db.CreateUserAndMember(ctx, dropLogin, "", "Bob", "Smith",
+			role, date(2018, time.January, 1), date(2026, time.December, 31))
+		if dropError != nil {
+			t.Errorf("%s: %v", dbType, dropError)
+			continue
+		}
+
+		SetProfileField(ctx, db, keep.ID, "CITY", "Leatherhead")
+		SetProfileField(ctx, db, drop.ID, "CITY", "Dorking")
+		SetProfileField(ctx, db, drop.ID, "POSTCODE", "RH4 1AA")
+		db.CreateMembersInterest(ctx, NewMembersInterest(keep.ID, interest1.ID))
+		db.CreateMembersInterest(ctx, NewMembersInterest(drop.ID, interest1.ID))
+		db.CreateMembersInterest(ctx, NewMembersInterest(drop.ID, interest2.ID))
+		db.UpsertMembersOtherInterests(ctx, &MembersOtherInterests{UserID: drop.ID, Interests: "milestones"})
+
+		sales := []MembershipSale{
+			{PaymentService: "Stripe", PaymentStatus: PaymentStatusComplete,
+				MembershipYear: 2024, UserID: drop.ID, FirstName: "Bob", LastName: "Smith"},
+			{PaymentService: "Stripe", PaymentStatus: PaymentStatusComplete,
+				MembershipYear: 2025, UserID: keep.ID, AssocUserID: drop.ID, FirstName: "Robert", LastName: "Smith"},
+		}
+		for i := range sales {
+			if _, err := sales[i].Create(ctx, db); err != nil {
+				t.Errorf("%s: %v", dbType, err)
+			}
+		}
+
+		if _, err := db.MergeUsers(ctx, keep.ID, keep.ID); err == nil {
+			t.Errorf("%s: want an error merging a user with itself", dbType)
+		}
+
+		done, mergeError := db.MergeUsers(ctx, keep.ID, drop.ID)
+		if mergeError != nil {
+			t.Errorf("%s: %v", dbType, mergeError)
+			continue
+		}
+
+		report := strings.Join(done, "\n")
+		for _, want := range []string{
+			"combined with membership",
+			`CITY: kept "Leatherhead", discarded "Dorking"`,
+			`POSTCODE: moved "RH4 1AA"`,
+			"1 interests moved",
+			"membership_sales.ms_usr1_id: 1 rows moved",
+			"membership_sales.ms_usr2_id: 1 rows moved",
+			"marked invalid",
+		} {
+			if !strings.Contains(report, want) {
+				t.Errorf("%s: want %q in\n%s", dbType, want, report)
+			}
+		}
+
+		// One membership covering both periods.
+		const getMemberships = `SELECT mem_usr_id, mem_begin, mem_end FROM adm_members WHERE mem_usr_id IN ($1, $2);`
+		rows, searchError := db.Query(ctx, getMemberships, keep.ID, drop.ID)
+		if searchError != nil {
+			t.Errorf("%s: %v", dbType, searchError)
+			continue
+		}
+		n := 0
+		for rows.Next() {
+			var userID int64
+			var begin, end any
+			if err := rows.Scan(&userID, &begin, &end); err != nil {
+				t.Errorf("%s: %v", dbType, err)
+			}
+			n++
+			if userID != keep.ID || dateOf(begin) != "2018-01-01" || dateOf(end) != "2026-12-31" {
+				t.Errorf("%s: want %d from 2018-01-01 to 2026-12-31 got %d from %s to %s",
+					dbType, keep.ID, userID, dateOf(begin), dateOf(end))
+			}
+		}
+		rows.Close()
+		if n != 1 {
+			t.Errorf("%s: want 1 membership got %d", dbType, n)
+		}
+
+		town, _ := GetProfileField[string](ctx, db, keep.ID, "CITY")
+		postcode, _ := GetProfileField[string](ctx, db, keep.ID, "POSTCODE")
+		if town != "Leatherhead" || postcode != "RH4 1AA" {
+			t.Errorf("%s: want Leatherhead RH4 1AA got %s %s", dbType, town, postcode)
+		}
+
+		var dropData int
+		const countData = `SELECT count(*) FROM adm_user_data WHERE usd_usr_id = $1;`
+		if err := db.QueryRow(ctx, countData, drop.ID).Scan(&dropData); err != nil || dropData != 0 {
+			t.Errorf("%s: want no data left got %d %v", dbType, dropData, err)
+		}
+
+		interests, _ := db.GetMembersInterests(ctx, keep.ID)
+		if len(interests) != 2 {
+			t.Errorf("%s: want 2 interests got %v", dbType, interests)
+		}
+
+		other, otherError := db.GetMembersOtherInterests(ctx, keep.ID)
+		if otherError != nil || other.Interests != "milestones" {
+			t.Errorf("%s: want milestones got %v %v", dbType, other, otherError)
+		}
+
+		keepSales, _ := db.GetMembershipSalesOfUser(ctx, keep.ID)
+		dropSales, _ := db.GetMembershipSalesOfUser(ctx, drop.ID)
+		if len(keepSales) != 2 || len(dropSales) != 0 {
+			t.Errorf("%s: want 2 and 0 sales got %d and %d", dbType, len(keepSales), len(dropSales))
+		}
+
+		dropped, _ := db.GetUser(ctx, drop.ID)
+		if dropped == nil || dropped.Valid {
+			t.Errorf("%s: want user %d invalid got %v", dbType, drop.ID, dropped)
+		}
+
+		log, _ := db.GetChangeLog(ctx, drop.ID)
+		if len(log) == 0 || log[len(log)-1].Item != "merged_into" {
+			t.Errorf("%s: want the merge in the change log got %v", dbType, log)
+		}
+
+		// The history of the dropped account stays with it.
+		if !hasChange(log, "Dorking") {
+			t.Errorf("%s: want the dropped account's history left alone got %v", dbType, log)
+		}
+
+		keepLog, _ := db.GetChangeLog(ctx, keep.ID)
+		if len(keepLog) == 0 || keepLog[len(keepLog)-1].Item != "merged_from" {
+			t.Errorf("%s: want the merge in the change log got %v", dbType, keepLog)
+		}
+		if hasChange(keepLog, "Dorking") {
+			t.Errorf("%s: want none of the dropped account's history got %v", dbType, keepLog)
+		}
+	}
+}
+
+// hasChange is true if one of the entries in the change log sets the given
+// value.
+func hasChange(log []ChangeLogEntry, newValue string) bool {
+	for _, entry := range log {
+		if entry.NewValue == newValue {
+			return true
+		}
+	}
+	return false
+}
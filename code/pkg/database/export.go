@@ -0,0 +1,244 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// MemberStatusCurrent, MemberStatusLapsed and MemberStatusAll select members by
+// the end date of their membership.  A current member's membership ends today
+// or later.
+const (
+	MemberStatusCurrent = "current"
+	MemberStatusLapsed  = "lapsed"
+	MemberStatusAll     = "all"
+)
+
+// MemberFilter selects the members for GetMemberDetails.  Zero values select
+// everybody.
+type MemberFilter struct {
+	Status       string // MemberStatusCurrent (the default), MemberStatusLapsed or MemberStatusAll.
+	InterestID   int64  // Only members with this interest (adm_interests ID).
+	ConsentField string // Only members who have ticked this checkbox field (adm_user_fields internal name).
+}
+
+// MemberDetails holds the contact details of a member, as exported for mail
+// merges.
+type MemberDetails struct {
+	UserID          int64
+	LoginName       string
+	Title           string
+	FirstName       string
+	LastName        string
+	Email           string
+	AddressLine1    string
+	AddressLine2    string
+	AddressLine3    string
+	Town            string
+	County          string
+	Postcode        string
+	Country         string
+	Phone           string
+	Mobile          string
+	Friend          bool
+	Giftaid         bool
+	EmailPermission bool     // True if the member has ticked PERMISSION_TO_SEND_EMAILS.
+	Interests       []string // The names of the member's interests.
+	EndDate         string   // The end of the membership, "YYYY-MM-DD".
+}
+
+// GetMemberDetails gets the details of the users with the role of ordinary or
+// associate members (see DBConfig.RoleName) selected by the filter, in order of
+// user ID.  It's assumed that a transaction is
+// already set up in the db object.
+func (db *Database) GetMemberDetails(ctx context.Context, filter *MemberFilter, now time.Time) ([]MemberDetails, error) {
+
+	const fn = "GetMemberDetails"
+
+	// A user may have more than one membership record with those roles.
+	// The latest end date counts.  %s converts the date to "YYYY-MM-DD".
+	const queryTemplate = `
+		SELECT u.usr_id, u.usr_login_name, %s
+		FROM adm_members AS m
+		JOIN adm_roles AS r
+			ON r.rol_id = m.mem_rol_id
+		JOIN adm_users AS u
+			ON u.usr_id = m.mem_usr_id
+		WHERE r.rol_name IN (%s)
+	`
+
+	var endDate string
+	switch db.Config.Type {
+	case "postgres":
+		endDate = "to_char(max(m.mem_end), 'YYYY-MM-DD')"
+	default:
+		// SQLite stores dates as strings starting "YYYY-MM-DD".
+		endDate = "substr(max(m.mem_end), 1, 10)"
+	}
+
+	args := make([]any, 0)
+	for _, name := range db.Config.RoleNames(MembershipTypeOrdinary, MembershipTypeAssociate) {
+		args = append(args, name)
+	}
+	query := fmt.Sprintf(queryTemplate, endDate, placeholderList(1, len(args)))
+
+	if filter.InterestID > 0 {
+		args = append(args, filter.InterestID)
+		query += fmt.Sprintf(`
+		AND EXISTS (
+			SELECT 1 FROM adm_members_interests
+			WHERE mi_usr_id = u.usr_id
+			AND mi_interest_id = $%d
+		)`, len(args))
+	}
+
+	if filter.ConsentField != "" {
+		args = append(args, filter.ConsentField)
+		query += fmt.Sprintf(`
+		AND EXISTS (
+			SELECT 1 FROM adm_user_data AS d
+			JOIN adm_user_fields AS f
+				ON f.usf_id = d.usd_usf_id
+			WHERE d.usd_usr_id = u.usr_id
+			AND f.usf_name_intern = $%d
+			AND d.usd_value = '1'
+		)`, len(args))
+	}
+
+	query += `
+		GROUP BY u.usr_id, u.usr_login_name`
+
+	today := now.Format("2006-01-02")
+
+	switch filter.Status {
+	case "", MemberStatusCurrent:
+		args = append(args, today)
+		query += fmt.Sprintf(`
+		HAVING max(m.mem_end) >= $%d`, len(args))
+	case MemberStatusLapsed:
+		args = append(args, today)
+		query += fmt.Sprintf(`
+		HAVING max(m.mem_end) < $%d`, len(args))
+	case MemberStatusAll:
+	default:
+		em := fmt.Sprintf("%s: unknown member status %q", fn, filter.Status)
+		return nil, errors.New(em)
+	}
+
+	query += `
+		ORDER BY u.usr_id;`
+
+	rows, searchError := db.Query(ctx, query, args...)
+	if searchError != nil {
+		em := fmt.Sprintf("%s: %v", fn, searchError)
+		return nil, errors.New(em)
+	}
+	defer rows.Close()
+
+	members := make([]MemberDetails, 0)
+	for rows.Next() {
+		var m MemberDetails
+		scanError := rows.Scan(&m.UserID, &m.LoginName, &m.EndDate)
+		if scanError != nil {
+			em := fmt.Sprintf("%s: %v", fn, scanError)
+			return nil, errors.New(em)
+		}
+		members = append(members, m)
+	}
+
+	if rows.Err() != nil {
+		em := fmt.Sprintf("%s: %v", fn, rows.Err())
+		return nil, errors.New(em)
+	}
+
+	// We must close the rows before we run another query.
+	rows.Close()
+
+	interests, interestsError := db.GetInterests(ctx)
+	if interestsError != nil {
+		em := fmt.Sprintf("%s: %v", fn, interestsError)
+		return nil, errors.New(em)
+	}
+
+	interestName := make(map[int64]string)
+	for _, i := range interests {
+		interestName[i.ID] = i.Name
+	}
+
+	userIDs := make([]int64, 0, len(members))
+	for _, m := range members {
+		userIDs = append(userIDs, m.UserID)
+	}
+
+	userData, userDataError := db.GetUserData(ctx, userIDs...)
+	if userDataError != nil {
+		em := fmt.Sprintf("%s: %v", fn, userDataError)
+		return nil, errors.New(em)
+	}
+
+	for i := range members {
+		detailsError := db.getMemberDetails(ctx, &members[i], userData[members[i].UserID], interestName)
+		if detailsError != nil {
+			em := fmt.Sprintf("%s: user %d: %v", fn, members[i].UserID, detailsError)
+			return nil, errors.New(em)
+		}
+	}
+
+	return members, nil
+}
+
+// getMemberDetails fills in the details of the member from their user data and
+// the member's interests.
+func (db *Database) getMemberDetails(ctx context.Context, m *MemberDetails, ud *UserData, interestName map[int64]string) error {
+
+	m.Title = strings.TrimSpace(ud.Title)
+	m.FirstName = strings.TrimSpace(ud.FirstName)
+	m.LastName = strings.TrimSpace(ud.LastName)
+	m.Email = strings.TrimSpace(ud.Email)
+	m.AddressLine1 = strings.TrimSpace(ud.AddressLine1)
+	m.AddressLine2 = strings.TrimSpace(ud.AddressLine2)
+	m.AddressLine3 = strings.TrimSpace(ud.AddressLine3)
+	m.Town = strings.TrimSpace(ud.Town)
+	m.County = strings.TrimSpace(ud.County)
+	m.Postcode = strings.TrimSpace(ud.Postcode)
+	m.Country = strings.TrimSpace(ud.CountryCode)
+	m.Phone = strings.TrimSpace(ud.Phone)
+	m.Mobile = strings.TrimSpace(ud.Mobile)
+	m.Friend = ud.Friend
+	m.Giftaid = ud.Giftaid
+	m.EmailPermission = ud.EmailPermission
+
+	mis, interestsError := db.GetMembersInterests(ctx, m.UserID)
+	if interestsError != nil {
+		return interestsError
+	}
+
+	m.Interests = make([]string, 0, len(mis))
+	for _, mi := range mis {
+		m.Interests = append(m.Interests, interestName[mi.InterestID])
+	}
+
+	return nil
+}
+
+// GetInterestByName gets the interest with the given name.  The comparison
+// ignores case.  It returns an error if there is no such interest.
+func (db *Database) GetInterestByName(ctx context.Context, name string) (*Interest, error) {
+
+	interests, fetchError := db.GetInterests(ctx)
+	if fetchError != nil {
+		return nil, fetchError
+	}
+
+	for i := range interests {
+		if strings.EqualFold(interests[i].Name, strings.TrimSpace(name)) {
+			return &interests[i], nil
+		}
+	}
+
+	em := fmt.Sprintf("no interest called %q", name)
+	return nil, errors.New(em)
+}
@@ -0,0 +1,148 @@
+package database
+
+import (
+	"testing"
+	"time"
+)
+
+// TestGetMemberDetails checks that GetMemberDetails selects members by status,
+// interest and consent and fetches their details.
+func TestGetMemberDetails(t *testing.T) {
+	ctx := t.Context()
+
+	for _, dbType := range databaseList {
+
+		db, connError := OpenDBForTesting(dbType)
+		if connError != nil {
+			t.Error(connError)
+			continue
+		}
+
+		db.BeginTx(ctx)
+
+		defer db.Rollback()
+		defer db.CloseAndDelete()
+
+		prepError := PrepareTestTables(db)
+		if prepError != nil {
+			t.Errorf("%s: %v", dbType, prepError)
+			continue
+		}
+
+		role, roleError := db.GetRole(ctx, RoleNameMember)
+		if roleError != nil {
+			t.Errorf("%s: %v", dbType, roleError)
+			continue
+		}
+
+		interest, interestError := db.GetInterestByName(ctx, TestInterests1)
+		if interestError != nil {
+			t.Errorf("%s: %v", dbType, interestError)
+			continue
+		}
+
+		now := time.Date(2025, time.March, 1, 12, 0, 0, 0, time.UTC)
+		start := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+		// current has an interest and consents to email, lapsed ended last year.
+		var users [2]*User
+		for i, end := range []time.Time{
+			time.Date(2025, time.December, 31, 0, 0, 0, 0, time.UTC),
+			time.Date(2024, time.December, 31, 0, 0, 0, 0, time.UTC),
+		} {
+			loginName, _ := CreateUuid(ctx, db.Transaction, "usr_login_name", "adm_users")
+			var createError error
+			users[i], _, createError = db.CreateUserAndMember(ctx, loginName, "Dr", "Jane", "Doe", role, start, end)
+			if createError != nil {
+				t.Errorf("%s: %v", dbType, createError)
+				continue
+			}
+		}
+		current, lapsed := users[0], users[1]
+
+		SetProfileField(ctx, db, current.ID, "EMAIL", "jane@example.com")
+		SetProfileField(ctx, db, current.ID, "STREET", "1 High Street")
+		SetProfileField(ctx, db, current.ID, "CITY", "Leatherhead")
+		SetProfileField(ctx, db, current.ID, "POSTCODE", "KT22 1AA")
+		SetProfileField(ctx, db, current.ID, "FRIEND_OF_THE_MUSEUM", true)
+		SetProfileField(ctx, db, current.ID, "GIFT_AID", true)
+		db.CreateMembersInterest(ctx, NewMembersInterest(current.ID, interest.ID))
+
+		SetProfileField(ctx, db, current.ID, EmailPermNameIntern, true)
+		SetProfileField(ctx, db, lapsed.ID, EmailPermNameIntern, false)
+
+		var testData = []struct {
+			description string
+			filter      MemberFilter
+			want        []int64
+		}{
+			{"default", MemberFilter{}, []int64{current.ID}},
+			{"lapsed", MemberFilter{Status: MemberStatusLapsed}, []int64{lapsed.ID}},
+			{"all", MemberFilter{Status: MemberStatusAll}, []int64{current.ID, lapsed.ID}},
+			{"interest", MemberFilter{Status: MemberStatusAll, InterestID: interest.ID}, []int64{current.ID}},
+			{"consent", MemberFilter{Status: MemberStatusAll, ConsentField: EmailPermNameIntern}, []int64{current.ID}},
+		}
+
+		for _, td := range testData {
+			members, fetchError := db.GetMemberDetails(ctx, &td.filter, now)
+			if fetchError != nil {
+				t.Errorf("%s %s: %v", dbType, td.description, fetchError)
+				continue
+			}
+
+			// The database may hold other members, so only look at ours.
+			got := make([]int64, 0)
+			for _, m := range members {
+				if m.UserID == current.ID || m.UserID == lapsed.ID {
+					got = append(got, m.UserID)
+				}
+			}
+
+			if len(got) != len(td.want) {
+				t.Errorf("%s %s: want %v got %v", dbType, td.description, td.want, got)
+				continue
+			}
+
+			for i := range got {
+				if got[i] != td.want[i] {
+					t.Errorf("%s %s: want %v got %v", dbType, td.description, td.want, got)
+				}
+			}
+		}
+
+		members, fetchError := db.GetMemberDetails(ctx, &MemberFilter{InterestID: interest.ID}, now)
+		if fetchError != nil {
+			t.Errorf("%s: %v", dbType, fetchError)
+			continue
+		}
+
+		var got *MemberDetails
+		for i := range members {
+			if members[i].UserID == current.ID {
+				got = &members[i]
+			}
+		}
+
+		if got == nil {
+			t.Errorf("%s: member %d not found", dbType, current.ID)
+			continue
+		}
+
+		if got.LoginName != current.LoginName || got.Title != "Dr" ||
+			got.FirstName != "Jane" || got.LastName != "Doe" ||
+			got.Email != "jane@example.com" || got.AddressLine1 != "1 High Street" ||
+			got.Town != "Leatherhead" || got.Postcode != "KT22 1AA" ||
+			!got.Friend || !got.Giftaid || !got.EmailPermission || got.EndDate != "2025-12-31" {
+			t.Errorf("%s: got %+v", dbType, *got)
+		}
+
+		if len(got.Interests) != 1 || got.Interests[0] != TestInterests1 {
+			t.Errorf("%s: want interests [%s] got %v", dbType, TestInterests1, got.Interests)
+		}
+
+		_, statusError := db.GetMemberDetails(ctx, &MemberFilter{Status: "junk"}, now)
+		if statusError == nil {
+			t.Errorf("%s: want an error for an unknown status", dbType)
+		}
+	}
+}
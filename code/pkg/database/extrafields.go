@@ -0,0 +1,289 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/mail"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Besides the fixed set of profile fields in ProfileFields, the details forms
+// can show the user fields in one Admidio category, chosen by the
+// extra_fields_category setting in the config.  An organisation can use that to
+// collect things like occupation or skills to volunteer.  The administrator
+// sets the fields up in Admidio and the forms show whatever is in the category.
+
+// ExtraField is a user field from the configured category, with its value for
+// one user.
+type ExtraField struct {
+	ID          int64    // The usf_id.
+	NameIntern  string   // The usf_name_intern.
+	Label       string   // The usf_name, shown on the forms.
+	Description string   // The usf_description, shown as a hint.
+	Type        string   // The usf_type, for example "TEXT" or "DROPDOWN".
+	Options     []string // The choices for a DROPDOWN or RADIO_BUTTON field.
+	Value       string   // The value in the form that it's stored in adm_user_data.
+	Error       string   // Error message.
+}
+
+// ExtraFieldChoice is one of the choices of a DROPDOWN or RADIO_BUTTON field.
+type ExtraFieldChoice struct {
+	Value    string // The value stored in adm_user_data - the choice's position in the list, from 1.
+	Label    string // The text of the choice.
+	Selected bool   // True if it's the field's current value.
+}
+
+// extraFieldTypes are the Admidio field types that the forms can show.  Fields
+// of any other type in the category are ignored.
+var extraFieldTypes = map[string]bool{
+	"TEXT":         true,
+	"TEXT_BIG":     true,
+	"CHECKBOX":     true,
+	"DATE":         true,
+	"DROPDOWN":     true,
+	"RADIO_BUTTON": true,
+	"EMAIL":        true,
+	"PHONE":        true,
+	"URL":          true,
+	"NUMBER":       true,
+	"DECIMAL":      true,
+}
+
+// FormName gives the name of the field's input on the details forms.
+func (f *ExtraField) FormName() string {
+	return fmt.Sprintf("extra_field_%d", f.ID)
+}
+
+// Checked is true if the field is a tick box and it's ticked.
+func (f *ExtraField) Checked() bool {
+	return f.Type == "CHECKBOX" && f.Value == "1"
+}
+
+// Choices gives the choices of a DROPDOWN or RADIO_BUTTON field.  Admidio
+// stores the position of the chosen item in the list, counting from 1.
+func (f *ExtraField) Choices() []ExtraFieldChoice {
+	choices := make([]ExtraFieldChoice, 0, len(f.Options))
+	for i, option := range f.Options {
+		value := strconv.Itoa(i + 1)
+		choices = append(choices, ExtraFieldChoice{value, option, value == f.Value})
+	}
+	return choices
+}
+
+// SetFromForm sets the value of the field from the value of its input on the
+// details forms.  A tick box is stored as 1 if it's ticked and 0 if not.
+func (f *ExtraField) SetFromForm(formValue string) {
+	formValue = strings.TrimSpace(formValue)
+	if f.Type == "CHECKBOX" {
+		if len(formValue) > 0 {
+			f.Value = "1"
+		} else {
+			f.Value = "0"
+		}
+		return
+	}
+	f.Value = formValue
+}
+
+// Validate checks the value of the field against its type, sets the error
+// message and returns true if the value is valid.  An empty value is valid.
+func (f *ExtraField) Validate() bool {
+
+	f.Error = ""
+	if len(f.Value) == 0 {
+		return true
+	}
+
+	switch f.Type {
+	case "CHECKBOX":
+		if f.Value != "0" && f.Value != "1" {
+			f.Error = "must be ticked or not ticked"
+		}
+	case "DATE":
+		if _, err := time.Parse("2006-01-02", f.Value); err != nil {
+			f.Error = "must be a date"
+		}
+	case "DROPDOWN", "RADIO_BUTTON":
+		n, err := strconv.Atoi(f.Value)
+		if err != nil || n < 1 || n > len(f.Options) {
+			f.Error = "must be one of the choices"
+		}
+	case "EMAIL":
+		if _, err := mail.ParseAddress(f.Value); err != nil {
+			f.Error = "must be an email address"
+		}
+	case "PHONE":
+		f.Error = ValidatePhoneNumber(f.Value)
+	case "URL":
+		u, err := url.ParseRequestURI(f.Value)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			f.Error = "must be a web address starting with http:// or https://"
+		}
+	case "NUMBER":
+		if _, err := strconv.Atoi(f.Value); err != nil {
+			f.Error = "must be a whole number"
+		}
+	case "DECIMAL":
+		if _, err := strconv.ParseFloat(f.Value, 64); err != nil {
+			f.Error = "must be a number"
+		}
+	}
+
+	return len(f.Error) == 0
+}
+
+// GetExtraFields gets the user fields in the category with the given internal
+// name, in the order that they appear on the profile page.  Fields that are in
+// ProfileFields and fields of types that the forms can't show are left out.
+// The values are empty (see LoadExtraFields).
+// It's assumed that a transaction is already set up in the db object.
+func (db *Database) GetExtraFields(ctx context.Context, categoryNameIntern string) ([]ExtraField, error) {
+
+	const fn = "GetExtraFields"
+
+	const query = `
+		SELECT f.usf_id, f.usf_name_intern, f.usf_name,
+			COALESCE(f.usf_description, ''), f.usf_type, COALESCE(f.usf_value_list, '')
+		FROM adm_user_fields AS f
+		INNER JOIN adm_categories AS c
+			ON c.cat_id = f.usf_cat_id
+		WHERE c.cat_name_intern = $1
+		AND c.cat_type = 'USF'
+		ORDER BY f.usf_sequence, f.usf_id;
+	`
+
+	rows, searchError := db.Query(ctx, query, categoryNameIntern)
+	if searchError != nil {
+		em := fmt.Sprintf("%s: %v", fn, searchError)
+		return nil, errors.New(em)
+	}
+	defer rows.Close()
+
+	fields := make([]ExtraField, 0)
+	for rows.Next() {
+		var f ExtraField
+		var valueList string
+		scanError := rows.Scan(&f.ID, &f.NameIntern, &f.Label, &f.Description, &f.Type, &valueList)
+		if scanError != nil {
+			em := fmt.Sprintf("%s: %v", fn, scanError)
+			return nil, errors.New(em)
+		}
+
+		db.fieldIDs.put(f.NameIntern, f.ID)
+
+		if _, found := findProfileField(f.NameIntern); found || !extraFieldTypes[f.Type] {
+			continue
+		}
+
+		f.Options = splitValueList(valueList)
+		fields = append(fields, f)
+	}
+
+	if rows.Err() != nil {
+		em := fmt.Sprintf("%s: %v", fn, rows.Err())
+		return nil, errors.New(em)
+	}
+
+	// Success!
+	return fields, nil
+}
+
+// LoadExtraFields sets the values of the given fields from adm_user_data for
+// the given user.  A field that isn't set gets an empty value.
+// It's assumed that a transaction is already set up in the db object.
+func (db *Database) LoadExtraFields(ctx context.Context, userID int64, fields []ExtraField) error {
+
+	const fn = "LoadExtraFields"
+
+	if len(fields) == 0 {
+		return nil
+	}
+
+	args := []any{userID}
+	index := make(map[int64]int)
+	for i := range fields {
+		fields[i].Value = ""
+		args = append(args, fields[i].ID)
+		index[fields[i].ID] = i
+	}
+
+	query := fmt.Sprintf(`
+		SELECT usd_usf_id, usd_value
+		FROM adm_user_data
+		WHERE usd_usr_id = $1
+		AND usd_usf_id IN (%s);
+	`, placeholderList(2, len(fields)))
+
+	rows, searchError := db.Query(ctx, query, args...)
+	if searchError != nil {
+		em := fmt.Sprintf("%s: %v", fn, searchError)
+		return errors.New(em)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var fieldID int64
+		var value string
+		scanError := rows.Scan(&fieldID, &value)
+		if scanError != nil {
+			em := fmt.Sprintf("%s: %v", fn, scanError)
+			return errors.New(em)
+		}
+		fields[index[fieldID]].Value = strings.TrimSpace(value)
+	}
+
+	if rows.Err() != nil {
+		em := fmt.Sprintf("%s: %v", fn, rows.Err())
+		return errors.New(em)
+	}
+
+	// Success!
+	return nil
+}
+
+// SaveExtraFields saves the values of the given fields in adm_user_data for
+// the given user, replacing any existing values.  An empty value is removed.
+// It's assumed that a transaction is already set up in the db object.
+func (db *Database) SaveExtraFields(ctx context.Context, userID int64, fields []ExtraField) error {
+
+	const fn = "SaveExtraFields"
+
+	if userID <= 0 {
+		em := fmt.Sprintf("%s: no userID", fn)
+		return errors.New(em)
+	}
+
+	ids := make([]int64, 0, len(fields))
+	values := make([]string, 0, len(fields))
+	for i := range fields {
+		ids = append(ids, fields[i].ID)
+		values = append(values, fields[i].Value)
+	}
+
+	replaceError := db.replaceUserData(ctx, userID, ids, values)
+	if replaceError != nil {
+		em := fmt.Sprintf("%s: %v", fn, replaceError)
+		return errors.New(em)
+	}
+
+	// Success!
+	return nil
+}
+
+// splitValueList splits the usf_value_list of a DROPDOWN or RADIO_BUTTON field,
+// which has one choice on each line, into a list of choices.  The stored value
+// is a position in the list, so only the blank lines at the end are dropped.
+func splitValueList(valueList string) []string {
+	options := strings.Split(strings.TrimRight(valueList, " \t\r\n"), "\n")
+	for i := range options {
+		options[i] = strings.TrimSpace(options[i])
+	}
+	if len(options) == 1 && len(options[0]) == 0 {
+		return []string{}
+	}
+	return options
+}
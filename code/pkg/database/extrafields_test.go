@@ -0,0 +1,217 @@
+package database
+
+import (
+	"testing"
+)
+
+// TestExtraFields checks that GetExtraFields gets the user fields in a
+// category and that SaveExtraFields and LoadExtraFields store and fetch their
+// values.
+func TestExtraFields(t *testing.T) {
+	ctx := t.Context()
+
+	for _, dbType := range databaseList {
+
+		db, connError := ConnectForTesting(dbType)
+		if connError != nil {
+			t.Error(connError)
+			continue
+		}
+
+		defer db.Rollback()
+		defer db.CloseAndDelete()
+
+		basic, basicError := db.GetCategoryByNameIntern(ctx, "BASIC_DATA")
+		if basicError != nil {
+			t.Fatal(basicError)
+		}
+
+		cat := NewCategory(basic.Org, "USF", "VOLUNTEERING", "Volunteering", false, false, 9, basic.CreateUser)
+		if err := db.CreateCategory(ctx, cat); err != nil {
+			t.Fatal(err)
+		}
+
+		// The fields are created out of order.  CITY is a profile field, which
+		// is shown elsewhere on the forms, and Admidio's DROPDOWN_MULTISELECT
+		// isn't supported, so they are left out.
+		fieldsToCreate := []struct {
+			nameIntern, name, fieldType string
+			sequence                    int
+		}{
+			{"SKILLS", "Skills", "DROPDOWN", 3},
+			{"OCCUPATION", "Occupation", "TEXT", 1},
+			{"CITY", "City", "TEXT", 2},
+			{"CAN_DRIVE", "Can drive", "CHECKBOX", 4},
+			{"AVAILABLE_FROM", "Available from", "DATE", 5},
+			{"DAYS", "Days", "DROPDOWN_MULTISELECT", 6},
+		}
+		for _, f := range fieldsToCreate {
+			uf := NewUserField(f.name, f.nameIntern, f.fieldType, basic.CreateUser, cat)
+			uf.Sequence = f.sequence
+			if err := db.CreateUserField(ctx, uf); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		const setValueList = `
+			UPDATE adm_user_fields
+			SET usf_value_list = $1, usf_description = $2
+			WHERE usf_name_intern = $3;
+		`
+		_, updateError := db.Exec(ctx, setValueList, "Gardening\r\nTalks\r\n\r\nGuiding\r\n", "What can you do?", "SKILLS")
+		if updateError != nil {
+			t.Fatal(updateError)
+		}
+
+		fields, fetchError := db.GetExtraFields(ctx, "VOLUNTEERING")
+		if fetchError != nil {
+			t.Errorf("%s: %v", dbType, fetchError)
+			continue
+		}
+
+		wantNames := []string{"OCCUPATION", "SKILLS", "CAN_DRIVE", "AVAILABLE_FROM"}
+		if len(fields) != len(wantNames) {
+			t.Errorf("%s: want %d fields got %d", dbType, len(wantNames), len(fields))
+			continue
+		}
+		for i, name := range wantNames {
+			if fields[i].NameIntern != name {
+				t.Errorf("%s: field %d: want %s got %s", dbType, i, name, fields[i].NameIntern)
+			}
+		}
+
+		// A blank line in the middle of the list keeps the positions of the
+		// choices after it.
+		skills := fields[1]
+		wantOptions := []string{"Gardening", "Talks", "", "Guiding"}
+		if len(skills.Options) != len(wantOptions) {
+			t.Errorf("%s: want %v got %v", dbType, wantOptions, skills.Options)
+		} else {
+			for i := range wantOptions {
+				if skills.Options[i] != wantOptions[i] {
+					t.Errorf("%s: want %v got %v", dbType, wantOptions, skills.Options)
+					break
+				}
+			}
+		}
+		if skills.Description != "What can you do?" {
+			t.Errorf("%s: want the description got %s", dbType, skills.Description)
+		}
+
+		u, ue := CreateUser(ctx, db)
+		if ue != nil {
+			t.Fatal(ue)
+		}
+
+		fields[0].Value = "Teacher"
+		fields[1].Value = "4"
+		fields[2].Value = "1"
+		fields[3].Value = ""
+		if err := db.SaveExtraFields(ctx, u.ID, fields); err != nil {
+			t.Errorf("%s: %v", dbType, err)
+			continue
+		}
+
+		// Saving again replaces the values.
+		fields[0].Value = "Engineer"
+		if err := db.SaveExtraFields(ctx, u.ID, fields); err != nil {
+			t.Errorf("%s: %v", dbType, err)
+			continue
+		}
+
+		got, _ := db.GetExtraFields(ctx, "VOLUNTEERING")
+		if err := db.LoadExtraFields(ctx, u.ID, got); err != nil {
+			t.Errorf("%s: %v", dbType, err)
+			continue
+		}
+
+		wantValues := []string{"Engineer", "4", "1", ""}
+		for i, want := range wantValues {
+			if got[i].Value != want {
+				t.Errorf("%s: %s: want %s got %s", dbType, got[i].NameIntern, want, got[i].Value)
+			}
+		}
+
+		if !got[2].Checked() {
+			t.Errorf("%s: want CAN_DRIVE to be checked", dbType)
+		}
+
+		choices := got[1].Choices()
+		if len(choices) != 4 || !choices[3].Selected || choices[3].Label != "Guiding" {
+			t.Errorf("%s: want Guiding to be selected got %v", dbType, choices)
+		}
+
+		// A category that doesn't exist has no fields.
+		none, noneError := db.GetExtraFields(ctx, "NO_SUCH_CATEGORY")
+		if noneError != nil || len(none) != 0 {
+			t.Errorf("%s: want no fields got %v %v", dbType, none, noneError)
+		}
+	}
+}
+
+// TestExtraFieldValidate checks that Validate checks the value of an extra
+// field against its type.
+func TestExtraFieldValidate(t *testing.T) {
+
+	var testData = []struct {
+		fieldType string
+		value     string
+		wantValid bool
+	}{
+		{"TEXT", "anything", true},
+		{"TEXT", "", true},
+		{"CHECKBOX", "1", true},
+		{"CHECKBOX", "yes", false},
+		{"DATE", "2025-02-28", true},
+		{"DATE", "28/02/2025", false},
+		{"DROPDOWN", "2", true},
+		{"DROPDOWN", "3", false},
+		{"DROPDOWN", "0", false},
+		{"RADIO_BUTTON", "x", false},
+		{"EMAIL", "a@example.com", true},
+		{"EMAIL", "not an address", false},
+		{"PHONE", "01234 567890", true},
+		{"PHONE", "junk", false},
+		{"URL", "https://example.com/", true},
+		{"URL", "example.com", false},
+		{"NUMBER", "42", true},
+		{"NUMBER", "4.2", false},
+		{"DECIMAL", "4.2", true},
+		{"DECIMAL", "four", false},
+	}
+
+	for _, td := range testData {
+		f := ExtraField{Type: td.fieldType, Options: []string{"a", "b"}, Value: td.value}
+		valid := f.Validate()
+		if td.wantValid != valid {
+			t.Errorf("%s %s: want %v got %v", td.fieldType, td.value, td.wantValid, valid)
+		}
+		if valid != (len(f.Error) == 0) {
+			t.Errorf("%s %s: want an error message only if invalid, got %s",
+				td.fieldType, td.value, f.Error)
+		}
+	}
+}
+
+// TestExtraFieldSetFromForm checks that SetFromForm stores a tick box as 0 or 1.
+func TestExtraFieldSetFromForm(t *testing.T) {
+
+	var testData = []struct {
+		fieldType string
+		formValue string
+		want      string
+	}{
+		{"CHECKBOX", "on", "1"},
+		{"CHECKBOX", "", "0"},
+		{"TEXT", "  Teacher ", "Teacher"},
+		{"DROPDOWN", "2", "2"},
+	}
+
+	for _, td := range testData {
+		f := ExtraField{Type: td.fieldType}
+		f.SetFromForm(td.formValue)
+		if td.want != f.Value {
+			t.Errorf("%s %s: want %s got %s", td.fieldType, td.formValue, td.want, f.Value)
+		}
+	}
+}
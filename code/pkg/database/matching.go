@@ -0,0 +1,462 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Matching the people in a sale against the existing accounts.  Names and
+// email addresses are compared after normalising them, so that "Bob  Smith"
+// matches "Robert Smith" and "José" matches "Jose".  Each account that
+// matches well enough is a candidate with a score that says how good the match
+// is and the reasons for it.  ChooseCandidate decides from the scores whether
+// one account is a clear match, so the caller doesn't have to guess when
+// several accounts match equally well.
+
+// The scores for the parts of a match.
+const (
+	ScoreEmail     = 50 // The email addresses are the same.
+	ScoreLastName  = 25 // The last names are the same.
+	ScoreFirstName = 20 // The first names are the same.
+	ScoreNickname  = 15 // One first name is a nickname for the other, for example Bob and Robert.
+	ScoreInitial   = 5  // One first name is just the initial of the other.
+	ScorePostcode  = 15 // The postcodes are the same.
+	ScoreStreet    = 10 // The first lines of the addresses are the same.
+)
+
+// MinimumMatchScore is the lowest score for an account to be a candidate.  An
+// email address on its own is enough, and so are a last name and a first name
+// or a nickname, but a last name on its own isn't.
+const MinimumMatchScore = 40
+
+// ClearLead is how far the best candidate's score must be ahead of the next
+// one for it to be a clear match.
+const ClearLead = 20
+
+// MatchOutcome says what ChooseCandidate made of a list of candidates.
+type MatchOutcome int
+
+const (
+	MatchNone      MatchOutcome = iota // There are no candidates - it's a new member.
+	MatchCertain                       // One candidate is clearly best and its email address matches.
+	MatchProbable                      // One candidate is clearly best but only by name, so the customer should confirm it.
+	MatchAmbiguous                     // There is no clear best candidate, so an administrator should decide.
+)
+
+// MatchDetails holds the details used to match a person against an account.
+type MatchDetails struct {
+	UserID    int64 // The ID of the account, 0 for the details from a sale.
+	LoginName string
+	FirstName string
+	LastName  string
+	Email     string
+	Street    string
+	Postcode  string
+}
+
+// MemberCandidate is an account that may belong to a person.
+type MemberCandidate struct {
+	MatchDetails
+	Score            int      // How well the account matches.
+	Reasons          []string // What matched, for example "email" and "last name".
+	FirstNameDiffers bool     // Both have a first name and they don't match.
+}
+
+// String describes the candidate for a log or a review, for example
+// "42 (score 95: email, last name, first name)".
+func (c MemberCandidate) String() string {
+	return fmt.Sprintf("%d (score %d: %s)", c.UserID, c.Score, strings.Join(c.Reasons, ", "))
+}
+
+// accentFolds gives the plain letter for each accented letter.
+var accentFolds = makeAccentFolds(map[string]string{
+	"a":  "àáâãäåāăą",
+	"c":  "çćĉċč",
+	"d":  "ďđð",
+	"e":  "èéêëēĕėęě",
+	"g":  "ĝğġģ",
+	"h":  "ĥħ",
+	"i":  "ìíîïĩīĭįı",
+	"j":  "ĵ",
+	"k":  "ķ",
+	"l":  "ĺļľŀł",
+	"n":  "ñńņňŉ",
+	"o":  "òóôõöøōŏő",
+	"r":  "ŕŗř",
+	"s":  "śŝşšș",
+	"t":  "ţťŧț",
+	"u":  "ùúûüũūŭůűų",
+	"w":  "ŵ",
+	"y":  "ýÿŷ",
+	"z":  "źżž",
+	"ae": "æ",
+	"oe": "œ",
+	"ss": "ß",
+	"th": "þ",
+})
+
+// makeAccentFolds turns a map from a plain letter to its accented forms into
+// a map from each accented form to the plain letter.
+func makeAccentFolds(forms map[string]string) map[rune]string {
+	folds := make(map[rune]string)
+	for plain, accented := range forms {
+		for _, r := range accented {
+			folds[r] = plain
+		}
+	}
+	return folds
+}
+
+// nicknameGroups are first names that are taken to be the same person.  A
+// name can be in more than one group - Alex may be Alexander or Alexandra.
+var nicknameGroups = [][]string{
+	{"robert", "bob", "bobby", "rob", "robbie", "bert"},
+	{"william", "bill", "billy", "will", "willy", "liam"},
+	{"richard", "dick", "rick", "ricky", "rich"},
+	{"james", "jim", "jimmy", "jamie"},
+	{"john", "jack", "johnny", "jon"},
+	{"jonathan", "jon"},
+	{"elizabeth", "liz", "lizzie", "beth", "betty", "eliza", "libby"},
+	{"margaret", "maggie", "meg", "peggy", "madge"},
+	{"catherine", "katherine", "kathryn", "kate", "katie", "kathy", "cathy", "kath", "kay"},
+	{"christopher", "chris", "kit"},
+	{"christine", "christina", "chris", "tina"},
+	{"michael", "mike", "mick", "mickey"},
+	{"anthony", "tony"},
+	{"thomas", "tom", "tommy"},
+	{"edward", "ed", "eddie", "ted", "ned"},
+	{"alexander", "alex"},
+	{"alexandra", "alex"},
+	{"david", "dave", "davy"},
+	{"daniel", "dan", "danny"},
+	{"benjamin", "ben", "benny"},
+	{"samuel", "sam", "sammy"},
+	{"samantha", "sam"},
+	{"patrick", "pat", "paddy"},
+	{"patricia", "pat", "patty", "trish"},
+	{"susan", "sue", "susie", "suzy"},
+	{"jennifer", "jen", "jenny"},
+	{"deborah", "debbie", "deb"},
+	{"rebecca", "becky", "becca"},
+	{"nicholas", "nick", "nicky"},
+	{"andrew", "andy", "drew"},
+	{"stephen", "steven", "steve"},
+	{"timothy", "tim"},
+	{"peter", "pete"},
+	{"frederick", "fred", "freddie"},
+	{"gerald", "gerry", "jerry"},
+	{"henry", "harry", "hal"},
+	{"charles", "charlie", "chuck"},
+	{"joseph", "joe", "joey"},
+	{"josephine", "jo", "josie"},
+	{"joanne", "joanna", "jo"},
+	{"matthew", "matt"},
+	{"philip", "phillip", "phil"},
+	{"ronald", "ron", "ronnie"},
+	{"donald", "don"},
+	{"kenneth", "ken", "kenny"},
+	{"lawrence", "laurence", "larry", "laurie"},
+	{"leonard", "len", "lenny"},
+	{"raymond", "ray"},
+	{"victoria", "vicky", "tori"},
+	{"jacqueline", "jackie"},
+	{"gillian", "jill", "gill"},
+	{"alison", "allison", "ali"},
+	{"valerie", "val"},
+}
+
+// nicknames maps each name in nicknameGroups to the indexes of its groups.
+var nicknames = makeNicknames(nicknameGroups)
+
+// makeNicknames maps each name in the groups to the indexes of its groups.
+func makeNicknames(groups [][]string) map[string][]int {
+	m := make(map[string][]int)
+	for i, group := range groups {
+		for _, name := range group {
+			m[name] = append(m[name], i)
+		}
+	}
+	return m
+}
+
+// NormaliseName gives the form of a name used for matching - lower case, with
+// the accents removed, apostrophes and full stops dropped and any other
+// punctuation and runs of white space replaced by a single space.  For
+// example, " O'Brien-Núñez " gives "obrien nunez".
+func NormaliseName(name string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(name) {
+		switch {
+		case accentFolds[r] != "":
+			b.WriteString(accentFolds[r])
+		case r == '\'' || r == '’' || r == '.':
+			// Dropped, so O'Brien and OBrien match.
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			b.WriteRune(r)
+		default:
+			b.WriteRune(' ')
+		}
+	}
+	return strings.Join(strings.Fields(b.String()), " ")
+}
+
+// NormaliseEmail gives the form of an email address used for matching - lower
+// case without surrounding white space.
+func NormaliseEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// NormalisePostcode gives the form of a postcode used for matching - upper
+// case without any white space.
+func NormalisePostcode(postcode string) string {
+	return strings.Join(strings.Fields(strings.ToUpper(postcode)), "")
+}
+
+// sameName is true if the two normalised names are the same, ignoring the
+// spaces, so that "Mary Ann" matches "Maryann".
+func sameName(a, b string) bool {
+	return len(a) > 0 && strings.ReplaceAll(a, " ", "") == strings.ReplaceAll(b, " ", "")
+}
+
+// isNickname is true if the first words of the two normalised first names are
+// in the same nickname group.
+func isNickname(a, b string) bool {
+	aFirst, _, _ := strings.Cut(a, " ")
+	bFirst, _, _ := strings.Cut(b, " ")
+	for _, i := range nicknames[aFirst] {
+		for _, j := range nicknames[bFirst] {
+			if i == j {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isInitial is true if one of the normalised first names is a single letter
+// and the other starts with it.
+func isInitial(a, b string) bool {
+	if len(a) == 0 || len(b) == 0 {
+		return false
+	}
+	return (len(a) == 1 || len(b) == 1) && a[0] == b[0]
+}
+
+// emailAddresses gives the normalised email addresses in the details - the
+// email address and the login name if that looks like an email address.
+func (md *MatchDetails) emailAddresses() []string {
+	addresses := make([]string, 0, 2)
+	if email := NormaliseEmail(md.Email); len(email) > 0 {
+		addresses = append(addresses, email)
+	}
+	if login := NormaliseEmail(md.LoginName); strings.Contains(login, "@") {
+		addresses = append(addresses, login)
+	}
+	return addresses
+}
+
+// ScoreMatch compares the details of a person with the details of an account
+// and gives the score for the match and the reasons for it.  Details that are
+// missing on either side score nothing.
+func ScoreMatch(person, account *MatchDetails) (int, []string) {
+
+	score := 0
+	reasons := make([]string, 0, 4)
+
+	emailMatch := false
+	for _, a := range person.emailAddresses() {
+		for _, b := range account.emailAddresses() {
+			if a == b {
+				emailMatch = true
+			}
+		}
+	}
+	if emailMatch {
+		score += ScoreEmail
+		reasons = append(reasons, "email")
+	}
+
+	if sameName(NormaliseName(person.LastName), NormaliseName(account.LastName)) {
+		score += ScoreLastName
+		reasons = append(reasons, "last name")
+	}
+
+	personFirst := NormaliseName(person.FirstName)
+	accountFirst := NormaliseName(account.FirstName)
+	switch {
+	case sameName(personFirst, accountFirst):
+		score += ScoreFirstName
+		reasons = append(reasons, "first name")
+	case isNickname(personFirst, accountFirst):
+		score += ScoreNickname
+		reasons = append(reasons, "first name (nickname)")
+	case isInitial(personFirst, accountFirst):
+		score += ScoreInitial
+		reasons = append(reasons, "first name (initial)")
+	}
+
+	personPostcode := NormalisePostcode(person.Postcode)
+	if len(personPostcode) > 0 && personPostcode == NormalisePostcode(account.Postcode) {
+		score += ScorePostcode
+		reasons = append(reasons, "postcode")
+	}
+
+	personStreet := NormaliseName(person.Street)
+	if len(personStreet) > 0 && personStreet == NormaliseName(account.Street) {
+		score += ScoreStreet
+		reasons = append(reasons, "street")
+	}
+
+	return score, reasons
+}
+
+// MatchCandidates scores the given accounts against the details of a person
+// and returns the ones that score at least MinimumMatchScore, best first.
+func MatchCandidates(person *MatchDetails, accounts []MatchDetails) []MemberCandidate {
+
+	candidates := make([]MemberCandidate, 0)
+	for _, account := range accounts {
+		if person.UserID > 0 && account.UserID == person.UserID {
+			continue
+		}
+		score, reasons := ScoreMatch(person, &account)
+		if score >= MinimumMatchScore {
+			differs := len(NormaliseName(person.FirstName)) > 0 &&
+				len(NormaliseName(account.FirstName)) > 0 &&
+				!hasFirstNameReason(reasons) &&
+				!slices.Contains(reasons, "first name (initial)")
+			candidates = append(candidates, MemberCandidate{account, score, reasons, differs})
+		}
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].Score != candidates[j].Score {
+			return candidates[i].Score > candidates[j].Score
+		}
+		return candidates[i].UserID < candidates[j].UserID
+	})
+
+	return candidates
+}
+
+// ChooseCandidate decides what a list of candidates from MatchCandidates
+// means.  If there is a candidate that scores at least ClearLead more than any
+// other, it's returned, along with MatchCertain if its email address and a
+// name match and its first name doesn't differ, or MatchProbable if not.
+// Members of a household often share an email address, so an email address
+// alone doesn't identify the account - Jane Smith using John Smith's email
+// address is probably a new member.  If there is no clear best candidate, the
+// result is MatchAmbiguous.
+func ChooseCandidate(candidates []MemberCandidate) (*MemberCandidate, MatchOutcome) {
+
+	if len(candidates) == 0 {
+		return nil, MatchNone
+	}
+
+	best := &candidates[0]
+	if len(candidates) > 1 && best.Score-candidates[1].Score < ClearLead {
+		return nil, MatchAmbiguous
+	}
+
+	nameMatches := slices.Contains(best.Reasons, "last name") || hasFirstNameReason(best.Reasons)
+	if slices.Contains(best.Reasons, "email") && nameMatches && !best.FirstNameDiffers {
+		return best, MatchCertain
+	}
+
+	return best, MatchProbable
+}
+
+// hasFirstNameReason is true if the reasons from ScoreMatch include a match
+// on the first name or a nickname.  A matching initial doesn't count.
+func hasFirstNameReason(reasons []string) bool {
+	return slices.Contains(reasons, "first name") || slices.Contains(reasons, "first name (nickname)")
+}
+
+// getMatchDetails gets the details of all the accounts for matching.  The
+// normalisation can't be done in SQL, so all the accounts are fetched and
+// compared in Go, which is fine for the size of society that uses Admidio.
+// It's assumed that a transaction is already set up in the db object.
+func (db *Database) getMatchDetails(ctx context.Context) ([]MatchDetails, error) {
+
+	const fn = "getMatchDetails"
+
+	fieldNames := []string{"FIRST_NAME", "LAST_NAME", "EMAIL", "STREET", "POSTCODE"}
+	fieldIDs := make([]int64, 0, len(fieldNames))
+	args := make([]any, 0, len(fieldNames))
+	for _, name := range fieldNames {
+		id, fieldError := db.GetUserDataFieldIDByNameIntern(ctx, name)
+		if fieldError != nil {
+			em := fmt.Sprintf("%s: %v", fn, fieldError)
+			return nil, errors.New(em)
+		}
+		fieldIDs = append(fieldIDs, id)
+		args = append(args, id)
+	}
+
+	const query = `
+		SELECT u.usr_id, u.usr_login_name, d.usd_usf_id, d.usd_value
+		FROM adm_users AS u
+		LEFT JOIN adm_user_data AS d
+			ON d.usd_usr_id = u.usr_id
+			AND d.usd_usf_id IN ($1, $2, $3, $4, $5)
+		ORDER BY u.usr_id;
+	`
+
+	rows, searchError := db.Query(ctx, query, args...)
+	if searchError != nil {
+		em := fmt.Sprintf("%s: %v", fn, searchError)
+		return nil, errors.New(em)
+	}
+	defer rows.Close()
+
+	accounts := make([]MatchDetails, 0)
+	for rows.Next() {
+		var userID int64
+		var loginName, value sql.NullString
+		var fieldID sql.NullInt64
+		if err := rows.Scan(&userID, &loginName, &fieldID, &value); err != nil {
+			em := fmt.Sprintf("%s: %v", fn, err)
+			return nil, errors.New(em)
+		}
+
+		if len(accounts) == 0 || accounts[len(accounts)-1].UserID != userID {
+			accounts = append(accounts, MatchDetails{UserID: userID, LoginName: loginName.String})
+		}
+		account := &accounts[len(accounts)-1]
+
+		switch fieldID.Int64 {
+		case fieldIDs[0]:
+			account.FirstName = value.String
+		case fieldIDs[1]:
+			account.LastName = value.String
+		case fieldIDs[2]:
+			account.Email = value.String
+		case fieldIDs[3]:
+			account.Street = value.String
+		case fieldIDs[4]:
+			account.Postcode = value.String
+		}
+	}
+
+	return accounts, rows.Err()
+}
+
+// FindMemberCandidates gets the accounts that may belong to the person with
+// the given details, with their scores, best first.  Any of the details may be
+// empty.
+// It's assumed that a transaction is already set up in the db object.
+func (db *Database) FindMemberCandidates(ctx context.Context, person *MatchDetails) ([]MemberCandidate, error) {
+
+	accounts, accountsError := db.getMatchDetails(ctx)
+	if accountsError != nil {
+		return nil, accountsError
+	}
+
+	return MatchCandidates(person, accounts), nil
+}
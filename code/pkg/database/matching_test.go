@@ -0,0 +1,270 @@
+package database
+
+import (
+	"fmt"
+	"testing"
+)
+
+// TestNormaliseName checks that NormaliseName ignores case, accents,
+// punctuation and extra spaces.
+func TestNormaliseName(t *testing.T) {
+
+	var testData = []struct {
+		name string
+		want string
+	}{
+		{"Smith", "smith"},
+		{"  Mary   Ann ", "mary ann"},
+		{"O'Brien-Núñez", "obrien nunez"},
+		{"O’Brien", "obrien"},
+		{"St. John", "st john"},
+		{"Zoë Æsop", "zoe aesop"},
+		{"Łukasz Grüß", "lukasz gruss"},
+		{"", ""},
+	}
+
+	for _, td := range testData {
+		got := NormaliseName(td.name)
+		if td.want != got {
+			t.Errorf("%q: want %q got %q", td.name, td.want, got)
+		}
+	}
+}
+
+// TestScoreMatch checks the scores and reasons given by ScoreMatch.
+func TestScoreMatch(t *testing.T) {
+
+	account := MatchDetails{
+		UserID:    42,
+		LoginName: "Robert.Smith@Example.com",
+		FirstName: "Robert",
+		LastName:  "Smith-Jones",
+		Email:     "bob@example.com",
+		Street:    "1 High Street",
+		Postcode:  "kt22 8aa",
+	}
+
+	var testData = []struct {
+		description string
+		person      MatchDetails
+		wantScore   int
+		wantReasons []string
+	}{
+		{
+			"everything",
+			MatchDetails{FirstName: "robert", LastName: "Smith Jones", Email: " BOB@example.com", Postcode: "KT228AA"},
+			ScoreEmail + ScoreLastName + ScoreFirstName + ScorePostcode,
+			[]string{"email", "last name", "first name", "postcode"},
+		},
+		{
+			"login name as email",
+			MatchDetails{Email: "robert.smith@example.com"},
+			ScoreEmail,
+			[]string{"email"},
+		},
+		{
+			"nickname",
+			MatchDetails{FirstName: "Bob", LastName: "smithjones"},
+			ScoreLastName + ScoreNickname,
+			[]string{"last name", "first name (nickname)"},
+		},
+		{
+			"address",
+			MatchDetails{FirstName: "Mary", LastName: "Jones", Street: "1 high st.", Postcode: "KT22 8AA"},
+			ScorePostcode,
+			[]string{"postcode"},
+		},
+		{
+			"address and last name",
+			MatchDetails{FirstName: "Mary", LastName: "Smith-Jones", Street: " 1  High Street", Postcode: "KT22 8AA"},
+			ScoreLastName + ScorePostcode + ScoreStreet,
+			[]string{"last name", "postcode", "street"},
+		},
+		{
+			"initial",
+			MatchDetails{FirstName: "R.", LastName: "Smith-Jones"},
+			ScoreLastName + ScoreInitial,
+			[]string{"last name", "first name (initial)"},
+		},
+		{
+			"nothing",
+			MatchDetails{FirstName: "Jane", LastName: "Doe", Email: "jane@example.com"},
+			0,
+			[]string{},
+		},
+		{
+			"empty details match nothing",
+			MatchDetails{},
+			0,
+			[]string{},
+		},
+	}
+
+	for _, td := range testData {
+		score, reasons := ScoreMatch(&td.person, &account)
+		if td.wantScore != score {
+			t.Errorf("%s: want %d got %d", td.description, td.wantScore, score)
+		}
+		if fmt.Sprint(td.wantReasons) != fmt.Sprint(reasons) {
+			t.Errorf("%s: want %v got %v", td.description, td.wantReasons, reasons)
+		}
+	}
+}
+
+// TestChooseCandidate checks that ChooseCandidate only picks a candidate that
+// is clearly ahead of the others.
+func TestChooseCandidate(t *testing.T) {
+
+	emailAndNames := MemberCandidate{MatchDetails{UserID: 1}, 95, []string{"email", "last name", "first name"}, false}
+	emailAndLastName := MemberCandidate{MatchDetails{UserID: 2}, 75, []string{"email", "last name"}, false}
+	names := MemberCandidate{MatchDetails{UserID: 3}, 45, []string{"last name", "first name"}, false}
+	sameNames := MemberCandidate{MatchDetails{UserID: 4}, 45, []string{"last name", "first name"}, false}
+	sameEmail := MemberCandidate{MatchDetails{UserID: 5}, 75, []string{"email", "last name"}, false}
+	householdEmail := MemberCandidate{MatchDetails{UserID: 6}, 75, []string{"email", "last name"}, true}
+	emailOnly := MemberCandidate{MatchDetails{UserID: 7}, 50, []string{"email"}, false}
+
+	var testData = []struct {
+		description string
+		candidates  []MemberCandidate
+		wantID      int64
+		wantOutcome MatchOutcome
+	}{
+		{"none", nil, 0, MatchNone},
+		{"email", []MemberCandidate{emailAndNames}, 1, MatchCertain},
+		{"email, clear lead", []MemberCandidate{emailAndNames, emailAndLastName}, 1, MatchCertain},
+		{"email, no clear lead", []MemberCandidate{emailAndLastName, sameEmail}, 0, MatchAmbiguous},
+		{"email ahead of names", []MemberCandidate{emailAndLastName, names}, 2, MatchCertain},
+		{"names", []MemberCandidate{names}, 3, MatchProbable},
+		{"tie", []MemberCandidate{names, sameNames}, 0, MatchAmbiguous},
+		{"shared email, different first name", []MemberCandidate{householdEmail}, 6, MatchProbable},
+		{"email but no names", []MemberCandidate{emailOnly}, 7, MatchProbable},
+	}
+
+	for _, td := range testData {
+		best, outcome := ChooseCandidate(td.candidates)
+		if td.wantOutcome != outcome {
+			t.Errorf("%s: want %d got %d", td.description, td.wantOutcome, outcome)
+		}
+		var gotID int64
+		if best != nil {
+			gotID = best.UserID
+		}
+		if td.wantID != gotID {
+			t.Errorf("%s: want %d got %d", td.description, td.wantID, gotID)
+		}
+	}
+}
+
+// TestSharedEmailAddress checks that somebody using the email address of
+// another member of their household is not taken to be that member.
+func TestSharedEmailAddress(t *testing.T) {
+
+	john := MatchDetails{UserID: 42, FirstName: "John", LastName: "Smith", Email: "smiths@example.com"}
+
+	var testData = []struct {
+		description string
+		person      MatchDetails
+		wantOutcome MatchOutcome
+	}{
+		{"same person", MatchDetails{FirstName: "John", LastName: "Smith", Email: "smiths@example.com"}, MatchCertain},
+		{"nickname", MatchDetails{FirstName: "Jack", LastName: "Smith", Email: "smiths@example.com"}, MatchCertain},
+		{"initial", MatchDetails{FirstName: "J", LastName: "Smith", Email: "smiths@example.com"}, MatchCertain},
+		{"no first name", MatchDetails{LastName: "Smith", Email: "smiths@example.com"}, MatchCertain},
+		{"somebody else", MatchDetails{FirstName: "Jane", LastName: "Smith", Email: "smiths@example.com"}, MatchProbable},
+	}
+
+	for _, td := range testData {
+		candidates := MatchCandidates(&td.person, []MatchDetails{john})
+		best, outcome := ChooseCandidate(candidates)
+		if td.wantOutcome != outcome {
+			t.Errorf("%s: want %d got %d", td.description, td.wantOutcome, outcome)
+		}
+		if best == nil || best.UserID != john.UserID {
+			t.Errorf("%s: want %d got %v", td.description, john.UserID, best)
+		}
+	}
+}
+
+// TestFindMemberCandidates checks that FindMemberCandidates finds the accounts
+// in the database that match, best first.
+func TestFindMemberCandidates(t *testing.T) {
+	ctx := t.Context()
+
+	for _, dbType := range databaseList {
+
+		db, connError := ConnectForTesting(dbType)
+		if connError != nil {
+			t.Error(connError)
+			continue
+		}
+
+		defer db.Rollback()
+		defer db.CloseAndDelete()
+
+		user, _, _, firstName, lastName, userError := createTestUserEtc(ctx, db)
+		if userError != nil {
+			t.Errorf("%s: %v", dbType, userError)
+			continue
+		}
+
+		if err := SetProfileField(ctx, db, user.ID, "POSTCODE", "RH4 1AA"); err != nil {
+			t.Errorf("%s: %v", dbType, err)
+			continue
+		}
+
+		// Another account with the same last name and email address.
+		other, otherError := CreateUser(ctx, db)
+		if otherError != nil {
+			t.Errorf("%s: %v", dbType, otherError)
+			continue
+		}
+		SetProfileField(ctx, db, other.ID, "LAST_NAME", lastName)
+		SetProfileField(ctx, db, other.ID, "EMAIL", user.LoginName)
+
+		person := MatchDetails{
+			FirstName: firstName,
+			LastName:  lastName,
+			Email:     user.LoginName,
+			Postcode:  "rh41aa",
+		}
+		candidates, findError := db.FindMemberCandidates(ctx, &person)
+		if findError != nil {
+			t.Errorf("%s: %v", dbType, findError)
+			continue
+		}
+
+		if len(candidates) != 2 {
+			t.Errorf("%s: want 2 candidates got %v", dbType, candidates)
+			continue
+		}
+
+		want := ScoreEmail + ScoreLastName + ScoreFirstName + ScorePostcode
+		if candidates[0].UserID != user.ID || candidates[0].Score != want {
+			t.Errorf("%s: want %d with score %d got %v", dbType, user.ID, want, candidates[0])
+		}
+
+		want = ScoreEmail + ScoreLastName
+		if candidates[1].UserID != other.ID || candidates[1].Score != want {
+			t.Errorf("%s: want %d with score %d got %v", dbType, other.ID, want, candidates[1])
+		}
+
+		// The first is clearly the best.
+		best, outcome := ChooseCandidate(candidates)
+		if outcome != MatchCertain || best.UserID != user.ID {
+			t.Errorf("%s: want %d got %v %d", dbType, user.ID, best, outcome)
+		}
+
+		// Without the first name and postcode it's a tie.
+		tie := MatchDetails{LastName: lastName, Email: user.LoginName}
+		tied, tieError := db.FindMemberCandidates(ctx, &tie)
+		if tieError != nil {
+			t.Errorf("%s: %v", dbType, tieError)
+			continue
+		}
+
+		_, outcome = ChooseCandidate(tied)
+		if outcome != MatchAmbiguous {
+			t.Errorf("%s: want %d got %d", dbType, MatchAmbiguous, outcome)
+		}
+	}
+}
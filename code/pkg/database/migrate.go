@@ -0,0 +1,226 @@
+package database
+
+import (
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// The application's own tables (membership_sales, the interests tables and so
+// on) are created and changed by numbered migrations built into the binary.
+// Each migration is a set of scripts in the migrations directory, one for
+// each type of database, for example 0001_membership_sales.postgres.sql,
+// 0001_membership_sales.mysql.sql and 0001_membership_sales.sqlite.sql.  The
+// schema_migrations table records the migrations that have been applied.  The
+// Admidio tables belong to Admidio and are not touched.
+//
+// To change the schema, add a new set of scripts with the next number.  Never
+// change a migration that has been released.
+//
+// MySQL commits each CREATE or ALTER statement as soon as it runs, so a
+// migration that fails part way through can't be rolled back there.  The
+// scripts use IF NOT EXISTS where they can so that they can be run again.
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// migrationFileName matches the name of a migration script and extracts the
+// version, the name and the database type.
+var migrationFileName = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.(postgres|mysql|sqlite)\.sql$`)
+
+// Migration is one step in building the schema.
+type Migration struct {
+	Version int    // The number at the start of the file name.
+	Name    string // The rest of the file name, for example "membership_sales".
+	SQL     string // The script for the type of database.
+}
+
+// MigrationStatus says whether a migration has been applied.
+type MigrationStatus struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time // Zero if not applied.
+}
+
+// createMigrationsTableSQL creates the table that records the migrations.
+// The time is in seconds since the Unix epoch.  It works for all types of
+// database.
+const createMigrationsTableSQL = `
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		sm_version integer PRIMARY KEY,
+		sm_name varchar(100) NOT NULL,
+		sm_applied bigint NOT NULL
+	);
+`
+
+// Migrations gets the migrations for the given type of database ("postgres",
+// "mysql" or "sqlite") in order of version.
+func Migrations(dbType string) ([]Migration, error) {
+
+	const fn = "Migrations"
+
+	entries, readError := fs.ReadDir(migrationFiles, "migrations")
+	if readError != nil {
+		em := fmt.Sprintf("%s: %v", fn, readError)
+		return nil, errors.New(em)
+	}
+
+	migrations := make([]Migration, 0)
+	versions := make(map[int]string)
+
+	for _, entry := range entries {
+		parts := migrationFileName.FindStringSubmatch(entry.Name())
+		if parts == nil {
+			em := fmt.Sprintf("%s: badly named migration %s", fn, entry.Name())
+			return nil, errors.New(em)
+		}
+
+		if parts[3] != dbType {
+			continue
+		}
+
+		version, _ := strconv.Atoi(parts[1])
+		if other, found := versions[version]; found {
+			em := fmt.Sprintf("%s: migrations %s and %s have the same version", fn, other, entry.Name())
+			return nil, errors.New(em)
+		}
+		versions[version] = entry.Name()
+
+		script, scriptError := migrationFiles.ReadFile("migrations/" + entry.Name())
+		if scriptError != nil {
+			em := fmt.Sprintf("%s: %v", fn, scriptError)
+			return nil, errors.New(em)
+		}
+
+		migrations = append(migrations, Migration{Version: version, Name: parts[2], SQL: string(script)})
+	}
+
+	if len(migrations) == 0 {
+		em := fmt.Sprintf("%s: no migrations for database type %q", fn, dbType)
+		return nil, errors.New(em)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations, nil
+}
+
+// MigrationStatuses gets the migrations for the database and says which have
+// been applied.  It's assumed that a transaction is already set up in the db
+// object.
+func (db *Database) MigrationStatuses(ctx context.Context) ([]MigrationStatus, error) {
+
+	migrations, migrationsError := Migrations(db.Config.Type)
+	if migrationsError != nil {
+		return nil, migrationsError
+	}
+
+	applied, appliedError := db.appliedMigrations(ctx)
+	if appliedError != nil {
+		return nil, appliedError
+	}
+
+	statuses := make([]MigrationStatus, 0, len(migrations))
+	for _, m := range migrations {
+		s := MigrationStatus{Migration: m}
+		appliedAt, found := applied[m.Version]
+		if found {
+			s.Applied = true
+			s.AppliedAt = appliedAt
+		}
+		statuses = append(statuses, s)
+	}
+
+	return statuses, nil
+}
+
+// MigrateUp applies the migrations that haven't been applied yet, in order,
+// and records them in schema_migrations.  It returns the migrations that it
+// applied.  It's assumed that a transaction is already set up in the db object.
+// The caller should commit it.  If a migration fails, the caller should roll
+// back so that none of them are applied.
+func (db *Database) MigrateUp(ctx context.Context, now time.Time) ([]Migration, error) {
+
+	const fn = "MigrateUp"
+
+	statuses, statusError := db.MigrationStatuses(ctx)
+	if statusError != nil {
+		return nil, statusError
+	}
+
+	const recordSQL = `
+		INSERT INTO schema_migrations (sm_version, sm_name, sm_applied)
+		VALUES ($1, $2, $3);
+	`
+
+	done := make([]Migration, 0)
+	for _, s := range statuses {
+		if s.Applied {
+			continue
+		}
+
+		_, migrateError := db.Exec(ctx, s.SQL)
+		if migrateError != nil {
+			em := fmt.Sprintf("%s: migration %04d_%s: %v", fn, s.Version, s.Name, migrateError)
+			return nil, errors.New(em)
+		}
+
+		_, recordError := db.Exec(ctx, recordSQL, s.Version, s.Name, now.Unix())
+		if recordError != nil {
+			em := fmt.Sprintf("%s: recording migration %04d_%s: %v", fn, s.Version, s.Name, recordError)
+			return nil, errors.New(em)
+		}
+
+		done = append(done, s.Migration)
+	}
+
+	// Success!
+	return done, nil
+}
+
+// appliedMigrations creates the schema_migrations table if necessary and gets
+// the version and time of each migration that has been applied.
+func (db *Database) appliedMigrations(ctx context.Context) (map[int]time.Time, error) {
+
+	const fn = "appliedMigrations"
+
+	_, createError := db.Exec(ctx, createMigrationsTableSQL)
+	if createError != nil {
+		em := fmt.Sprintf("%s: %v", fn, createError)
+		return nil, errors.New(em)
+	}
+
+	const query = `
+		SELECT sm_version, sm_applied
+		FROM schema_migrations;
+	`
+
+	rows, searchError := db.Query(ctx, query)
+	if searchError != nil {
+		em := fmt.Sprintf("%s: %v", fn, searchError)
+		return nil, errors.New(em)
+	}
+	defer rows.Close()
+
+	applied := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var appliedAt int64
+		scanError := rows.Scan(&version, &appliedAt)
+		if scanError != nil {
+			em := fmt.Sprintf("%s: %v", fn, scanError)
+			return nil, errors.New(em)
+		}
+		applied[version] = time.Unix(appliedAt, 0)
+	}
+
+	return applied, rows.Err()
+}
@@ -0,0 +1,129 @@
+package database
+
+import (
+	"testing"
+	"time"
+)
+
+// TestMigrations checks that the migrations for each type of database are
+// numbered from 1 without gaps and that each type has the same migrations.
+func TestMigrations(t *testing.T) {
+
+	postgres, postgresError := Migrations("postgres")
+	if postgresError != nil {
+		t.Fatal(postgresError)
+	}
+
+	for i := range postgres {
+		if postgres[i].Version != i+1 {
+			t.Errorf("want version %d got %d", i+1, postgres[i].Version)
+		}
+		if len(postgres[i].SQL) == 0 {
+			t.Errorf("postgres %04d_%s: want a script", postgres[i].Version, postgres[i].Name)
+		}
+	}
+
+	for _, dbType := range []string{"mysql", "sqlite"} {
+
+		migrations, migrationsError := Migrations(dbType)
+		if migrationsError != nil {
+			t.Error(migrationsError)
+			continue
+		}
+
+		if len(postgres) != len(migrations) {
+			t.Errorf("want the same number of migrations - postgres %d %s %d",
+				len(postgres), dbType, len(migrations))
+			continue
+		}
+
+		for i := range postgres {
+			if postgres[i].Version != migrations[i].Version || postgres[i].Name != migrations[i].Name {
+				t.Errorf("want the same migration - postgres %04d_%s %s %04d_%s",
+					postgres[i].Version, postgres[i].Name, dbType, migrations[i].Version, migrations[i].Name)
+			}
+			if len(migrations[i].SQL) == 0 {
+				t.Errorf("%s %04d_%s: want a script", dbType, migrations[i].Version, migrations[i].Name)
+			}
+		}
+	}
+
+	_, unknownError := Migrations("oracle")
+	if unknownError == nil {
+		t.Error("want an error for an unknown database type")
+	}
+}
+
+// TestMigrateUp checks that MigrateUp records the migrations and doesn't apply
+// them again.
+func TestMigrateUp(t *testing.T) {
+	ctx := t.Context()
+
+	for _, dbType := range databaseList {
+
+		db, connError := OpenDBForTesting(dbType)
+		if connError != nil {
+			t.Error(connError)
+			continue
+		}
+
+		db.BeginTx(ctx)
+
+		defer db.Rollback()
+		defer db.CloseAndDelete()
+
+		// For SQLite this applies the migrations.
+		prepError := PrepareTestTables(db)
+		if prepError != nil {
+			t.Errorf("%s: %v", dbType, prepError)
+			continue
+		}
+
+		now := time.Date(2025, time.March, 1, 12, 0, 0, 0, time.UTC)
+
+		// Any migrations not yet applied to a permanent database are applied
+		// now.  Then there is nothing left to do.
+		_, firstError := db.MigrateUp(ctx, now)
+		if firstError != nil {
+			t.Errorf("%s: %v", dbType, firstError)
+			continue
+		}
+
+		done, secondError := db.MigrateUp(ctx, now)
+		if secondError != nil {
+			t.Errorf("%s: %v", dbType, secondError)
+			continue
+		}
+
+		if len(done) != 0 {
+			t.Errorf("%s: want no migrations applied got %v", dbType, done)
+		}
+
+		statuses, statusError := db.MigrationStatuses(ctx)
+		if statusError != nil {
+			t.Errorf("%s: %v", dbType, statusError)
+			continue
+		}
+
+		migrations, _ := Migrations(dbType)
+		if len(statuses) != len(migrations) {
+			t.Errorf("%s: want %d statuses got %d", dbType, len(migrations), len(statuses))
+		}
+
+		for _, s := range statuses {
+			if !s.Applied || s.AppliedAt.IsZero() {
+				t.Errorf("%s: want %04d_%s applied", dbType, s.Version, s.Name)
+			}
+		}
+
+		// The tables created by the migrations are there.
+		for _, table := range []string{"membership_sales", "adm_interests", "adm_countries",
+			"membership_verifications", "membership_reviews", "membership_sale_notes"} {
+			var n int
+			countError := db.QueryRow(ctx, "SELECT count(*) FROM "+table).Scan(&n)
+			if countError != nil {
+				t.Errorf("%s: %s: %v", dbType, table, countError)
+			}
+		}
+	}
+}
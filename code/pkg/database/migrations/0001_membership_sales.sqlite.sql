@@ -0,0 +1,38 @@
+-- The membership sales.  A sale is created when the buyer submits the sale
+-- form and completed when the payment service reports that they have paid.
+-- SQLite stores the creation time as a string "YYYY-MM-DD HH:MM:SS".
+CREATE TABLE IF NOT EXISTS membership_sales (
+    ms_id INTEGER PRIMARY KEY,
+    ms_payment_service varchar(36) NOT NULL,
+    ms_payment_status varchar(20) NOT NULL,
+    ms_payment_id varchar(200),
+    ms_transaction_type varchar(30) NOT NULL DEFAULT 'membership renewal',
+    ms_membership_year integer NOT NULL,
+    ms_usr1_id integer DEFAULT NULL,
+    ms_usr1_fee REAL NOT NULL,
+    ms_usr1_friend boolean NOT NULL DEFAULT false,
+    -- 0.0 if not a friend.
+    ms_usr1_friend_fee REAL NOT NULL DEFAULT 0.0,
+    ms_usr1_title varchar(50),
+    ms_usr1_first_name varchar(50),
+    ms_usr1_last_name varchar(50),
+    ms_usr1_email varchar(50),
+    -- NULL if no associate.
+    ms_usr2_id integer DEFAULT NULL,
+    -- 0.0 if no associate.
+    ms_usr2_fee REAL NOT NULL DEFAULT 0.0,
+    -- false if no associate.
+    ms_usr2_friend boolean NOT NULL DEFAULT false,
+    -- 0.0 if no associate.
+    ms_usr2_friend_fee REAL NOT NULL DEFAULT 0.0,
+    ms_usr2_title varchar(50),
+    ms_usr2_first_name varchar(50),
+    ms_usr2_last_name varchar(50),
+    ms_usr2_email varchar(50),
+    -- 0.0 if no donation.
+    ms_donation REAL NOT NULL DEFAULT 0.0,
+    -- 0.0 if no donation to museum.
+    ms_donation_museum REAL NOT NULL DEFAULT 0.0,
+    ms_giftaid boolean NOT NULL DEFAULT false,
+    ms_timestamp_create varchar(30) NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
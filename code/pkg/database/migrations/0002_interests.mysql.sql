@@ -0,0 +1,37 @@
+-- The members' interests.
+--
+-- adm_interests contains a fixed set of possible interests.  It's used to
+-- populate a selection list.
+--
+-- adm_members_interests contains the members' interests, one to many from
+-- adm_users to adm_interests, so the possible contents is restricted.
+--
+-- adm_members_other_interests contains member's interests that are not
+-- included in adm_interests.  It's free text.
+CREATE TABLE IF NOT EXISTS adm_interests
+(
+    ntrst_id integer unsigned NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    ntrst_name varchar(50)
+);
+
+CREATE TABLE IF NOT EXISTS adm_members_interests
+(
+    mi_id integer unsigned NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    mi_usr_id integer unsigned NOT NULL,
+    mi_interest_id integer unsigned NOT NULL,
+    -- Only one interest per user and interest.
+    CONSTRAINT adm_un_usr_interest UNIQUE (mi_usr_id, mi_interest_id),
+    CONSTRAINT adm_fk_mi_usr FOREIGN KEY (mi_usr_id) REFERENCES adm_users (usr_id)
+        ON UPDATE RESTRICT ON DELETE RESTRICT,
+    CONSTRAINT adm_fk_mi_interest FOREIGN KEY (mi_interest_id) REFERENCES adm_interests (ntrst_id)
+        ON UPDATE RESTRICT ON DELETE RESTRICT
+);
+
+CREATE TABLE IF NOT EXISTS adm_members_other_interests
+(
+    moi_id integer unsigned NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    moi_usr_id integer unsigned NOT NULL,
+    moi_interests varchar(200),
+    CONSTRAINT adm_fk_moi_usr FOREIGN KEY (moi_usr_id) REFERENCES adm_users (usr_id)
+        ON UPDATE RESTRICT ON DELETE RESTRICT
+);
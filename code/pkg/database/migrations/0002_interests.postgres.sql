@@ -0,0 +1,37 @@
+-- The members' interests.
+--
+-- adm_interests contains a fixed set of possible interests.  It's used to
+-- populate a selection list.
+--
+-- adm_members_interests contains the members' interests, one to many from
+-- adm_users to adm_interests, so the possible contents is restricted.
+--
+-- adm_members_other_interests contains member's interests that are not
+-- included in adm_interests.  It's free text.
+CREATE TABLE IF NOT EXISTS adm_interests
+(
+    ntrst_id serial PRIMARY KEY,
+    ntrst_name varchar(50)
+);
+
+CREATE TABLE IF NOT EXISTS adm_members_interests
+(
+    mi_id serial PRIMARY KEY,
+    mi_usr_id integer NOT NULL
+        CONSTRAINT adm_fk_mi_usr REFERENCES adm_users (usr_id)
+        ON UPDATE RESTRICT ON DELETE RESTRICT,
+    mi_interest_id integer NOT NULL
+        CONSTRAINT adm_fk_mi_interest REFERENCES adm_interests (ntrst_id)
+        ON UPDATE RESTRICT ON DELETE RESTRICT,
+    -- Only one interest per user and interest.
+    CONSTRAINT adm_un_usr_interest UNIQUE (mi_usr_id, mi_interest_id)
+);
+
+CREATE TABLE IF NOT EXISTS adm_members_other_interests
+(
+    moi_id serial PRIMARY KEY,
+    moi_usr_id integer NOT NULL
+        CONSTRAINT adm_fk_moi_usr REFERENCES adm_users (usr_id)
+        ON UPDATE RESTRICT ON DELETE RESTRICT,
+    moi_interests varchar(200)
+);
@@ -0,0 +1,28 @@
+-- The members' interests.
+--
+-- adm_interests contains a fixed set of possible interests.  It's used to
+-- populate a selection list.
+--
+-- adm_members_interests contains the members' interests, one to many from
+-- adm_users to adm_interests, so the possible contents is restricted.
+--
+-- adm_members_other_interests contains member's interests that are not
+-- included in adm_interests.  It's free text.
+CREATE TABLE IF NOT EXISTS adm_interests (
+    ntrst_id INTEGER PRIMARY KEY NOT NULL,
+    ntrst_name varchar(50)
+);
+
+CREATE TABLE IF NOT EXISTS adm_members_interests (
+    mi_id INTEGER PRIMARY KEY NOT NULL,
+    mi_usr_id INTEGER NOT NULL,
+    mi_interest_id INTEGER NOT NULL,
+    -- Only one interest per user and interest.
+    CONSTRAINT adm_un_usr_interest UNIQUE (mi_usr_id, mi_interest_id)
+);
+
+CREATE TABLE IF NOT EXISTS adm_members_other_interests (
+    moi_id INTEGER PRIMARY KEY NOT NULL,
+    moi_usr_id INTEGER NOT NULL,
+    moi_interests varchar(200)
+);
@@ -0,0 +1,8 @@
+-- The countries offered in the address part of the extra details form.  The
+-- code is the ISO 3166 three letter code.
+CREATE TABLE IF NOT EXISTS adm_countries
+(
+    ct_id integer unsigned NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    ct_code varchar(3) UNIQUE NOT NULL,
+    ct_name varchar(100) UNIQUE NOT NULL
+);
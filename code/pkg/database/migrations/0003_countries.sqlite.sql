@@ -0,0 +1,7 @@
+-- The countries offered in the address part of the extra details form.  The
+-- code is the ISO 3166 three letter code.
+CREATE TABLE IF NOT EXISTS adm_countries (
+    ct_id INTEGER PRIMARY KEY NOT NULL,
+    ct_code varchar(3) UNIQUE NOT NULL,
+    ct_name varchar(100) UNIQUE NOT NULL
+);
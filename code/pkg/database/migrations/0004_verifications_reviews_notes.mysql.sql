@@ -0,0 +1,46 @@
+-- One-time codes sent to the email address on record of an existing account.
+-- Only a hash of the code is stored.  The expiry time is in seconds since the
+-- Unix epoch.
+CREATE TABLE IF NOT EXISTS membership_verifications
+(
+    mv_id integer unsigned NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    mv_ms_id integer unsigned NOT NULL,
+    mv_usr_id integer unsigned NOT NULL,
+    mv_email varchar(254) NOT NULL,
+    mv_code_hash varchar(64) NOT NULL UNIQUE,
+    mv_expires bigint NOT NULL,
+    mv_used boolean NOT NULL DEFAULT false,
+    mv_timestamp_create timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (mv_ms_id) REFERENCES membership_sales (ms_id)
+        ON UPDATE RESTRICT ON DELETE CASCADE,
+    FOREIGN KEY (mv_usr_id) REFERENCES adm_users (usr_id)
+        ON UPDATE RESTRICT ON DELETE CASCADE
+);
+
+-- The queue of sales that need an administrator to decide which member
+-- accounts they belong to.
+CREATE TABLE IF NOT EXISTS membership_reviews
+(
+    mr_id integer unsigned NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    mr_ms_id integer unsigned NOT NULL,
+    mr_reason text NOT NULL,
+    mr_status varchar(20) NOT NULL DEFAULT 'open',
+    mr_timestamp_create timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (mr_ms_id) REFERENCES membership_sales (ms_id)
+        ON UPDATE RESTRICT ON DELETE CASCADE
+);
+
+-- Notes added to sales by administrators, including a record of the actions
+-- that they take.  The creation time is in seconds since the Unix epoch.
+CREATE TABLE IF NOT EXISTS membership_sale_notes
+(
+    msn_id integer unsigned NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    msn_ms_id integer unsigned NOT NULL,
+    msn_usr_id integer unsigned,
+    msn_note text NOT NULL,
+    msn_created bigint NOT NULL,
+    FOREIGN KEY (msn_ms_id) REFERENCES membership_sales (ms_id)
+        ON UPDATE RESTRICT ON DELETE CASCADE,
+    FOREIGN KEY (msn_usr_id) REFERENCES adm_users (usr_id)
+        ON UPDATE RESTRICT ON DELETE SET NULL
+);
@@ -0,0 +1,41 @@
+-- One-time codes sent to the email address on record of an existing account.
+-- Only a hash of the code is stored.  The expiry time is in seconds since the
+-- Unix epoch.
+CREATE TABLE IF NOT EXISTS membership_verifications
+(
+    mv_id serial PRIMARY KEY,
+    mv_ms_id integer NOT NULL REFERENCES membership_sales (ms_id)
+        ON UPDATE RESTRICT ON DELETE CASCADE,
+    mv_usr_id integer NOT NULL REFERENCES adm_users (usr_id)
+        ON UPDATE RESTRICT ON DELETE CASCADE,
+    mv_email varchar(254) NOT NULL,
+    mv_code_hash varchar(64) NOT NULL UNIQUE,
+    mv_expires bigint NOT NULL,
+    mv_used boolean NOT NULL DEFAULT false,
+    mv_timestamp_create timestamp without time zone NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- The queue of sales that need an administrator to decide which member
+-- accounts they belong to.
+CREATE TABLE IF NOT EXISTS membership_reviews
+(
+    mr_id serial PRIMARY KEY,
+    mr_ms_id integer NOT NULL REFERENCES membership_sales (ms_id)
+        ON UPDATE RESTRICT ON DELETE CASCADE,
+    mr_reason text NOT NULL,
+    mr_status varchar(20) NOT NULL DEFAULT 'open',
+    mr_timestamp_create timestamp without time zone NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Notes added to sales by administrators, including a record of the actions
+-- that they take.  The creation time is in seconds since the Unix epoch.
+CREATE TABLE IF NOT EXISTS membership_sale_notes
+(
+    msn_id serial PRIMARY KEY,
+    msn_ms_id integer NOT NULL REFERENCES membership_sales (ms_id)
+        ON UPDATE RESTRICT ON DELETE CASCADE,
+    msn_usr_id integer REFERENCES adm_users (usr_id)
+        ON UPDATE RESTRICT ON DELETE SET NULL,
+    msn_note text NOT NULL,
+    msn_created bigint NOT NULL
+);
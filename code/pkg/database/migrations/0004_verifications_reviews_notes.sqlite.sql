@@ -0,0 +1,33 @@
+-- One-time codes sent to the email address on record of an existing account.
+-- Only a hash of the code is stored.  The expiry time is in seconds since the
+-- Unix epoch.
+CREATE TABLE IF NOT EXISTS membership_verifications (
+    mv_id INTEGER PRIMARY KEY,
+    mv_ms_id INTEGER NOT NULL,
+    mv_usr_id INTEGER NOT NULL,
+    mv_email varchar(254) NOT NULL,
+    mv_code_hash varchar(64) NOT NULL UNIQUE,
+    mv_expires INTEGER NOT NULL,
+    mv_used boolean NOT NULL DEFAULT false,
+    mv_timestamp_create varchar(30) NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- The queue of sales that need an administrator to decide which member
+-- accounts they belong to.
+CREATE TABLE IF NOT EXISTS membership_reviews (
+    mr_id INTEGER PRIMARY KEY,
+    mr_ms_id INTEGER NOT NULL,
+    mr_reason text NOT NULL,
+    mr_status varchar(20) NOT NULL DEFAULT 'open',
+    mr_timestamp_create varchar(30) NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Notes added to sales by administrators, including a record of the actions
+-- that they take.  The creation time is in seconds since the Unix epoch.
+CREATE TABLE IF NOT EXISTS membership_sale_notes (
+    msn_id INTEGER PRIMARY KEY,
+    msn_ms_id INTEGER NOT NULL,
+    msn_usr_id INTEGER,
+    msn_note text NOT NULL,
+    msn_created INTEGER NOT NULL
+);
@@ -0,0 +1,17 @@
+-- Changes that the application makes to member accounts, for systems without
+-- Admidio's adm_user_log, and the changes that adm_user_log has no room for -
+-- membership end dates and new accounts.  mcl_usf_id is the profile field that
+-- changed, if any, and mcl_item names the column that changed.
+CREATE TABLE IF NOT EXISTS membership_change_log
+(
+    mcl_id integer unsigned NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    mcl_usr_id integer unsigned NOT NULL,
+    mcl_usf_id integer unsigned,
+    mcl_item varchar(30) NOT NULL,
+    mcl_value_old text,
+    mcl_value_new text,
+    mcl_usr_id_create integer unsigned,
+    mcl_timestamp_create timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (mcl_usr_id) REFERENCES adm_users (usr_id)
+        ON UPDATE RESTRICT ON DELETE CASCADE
+);
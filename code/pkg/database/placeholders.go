@@ -0,0 +1,197 @@
+package database
+
+import (
+	"strconv"
+	"strings"
+)
+
+// The queries are written with Postgres placeholders - $1, $2 and so on.  A
+// placeholder can appear more than once and in any order.  SQLite has
+// numbered placeholders too, written ?1, ?2 and so on, but MySQL only has ?,
+// which takes the arguments in order.  To convert a query, it's split into
+// tokens so that a $ in a string literal, a quoted name or a comment is left
+// alone.
+
+// sqlTokenKind says what an sqlToken is.
+type sqlTokenKind int
+
+const (
+	sqlText        sqlTokenKind = iota // Names, keywords, operators, white space and so on.
+	sqlQuoted                          // A string literal or a quoted name, with its quotes.
+	sqlComment                         // A comment, including the -- or /* */.
+	sqlPlaceholder                     // A Postgres placeholder such as $1.
+)
+
+// sqlToken is a piece of an SQL statement.
+type sqlToken struct {
+	kind  sqlTokenKind
+	text  string // The text, exactly as it is in the statement.
+	param int    // The number of a placeholder, from 1.
+}
+
+// sqlTokens splits an SQL statement into tokens.  Joining the text of the
+// tokens gives the statement back.  If mysql is true, the tokens follow the
+// MySQL rules - a backslash escapes the next character in a quoted string, #
+// starts a comment and -- only starts one if it's followed by a space.  A
+// string or comment that isn't closed runs to the end of the statement.
+func sqlTokens(query string, mysql bool) []sqlToken {
+
+	tokens := make([]sqlToken, 0)
+
+	// The text since the last quoted string, comment or placeholder.
+	textStart := 0
+	flushText := func(end int) {
+		if end > textStart {
+			tokens = append(tokens, sqlToken{kind: sqlText, text: query[textStart:end]})
+		}
+	}
+
+	i := 0
+	for i < len(query) {
+
+		c := query[i]
+		var kind sqlTokenKind
+		var end int
+		param := 0
+
+		switch {
+		case c == '\'' || c == '"' || c == '`':
+			kind = sqlQuoted
+			end = endOfQuoted(query, i, mysql && c != '`')
+
+		case c == '-' && strings.HasPrefix(query[i:], "--") && (!mysql || dashCommentFollows(query, i+2)):
+			kind = sqlComment
+			end = endOfLine(query, i)
+
+		case c == '#' && mysql:
+			kind = sqlComment
+			end = endOfLine(query, i)
+
+		case c == '/' && strings.HasPrefix(query[i:], "/*"):
+			kind = sqlComment
+			end = strings.Index(query[i+2:], "*/")
+			if end < 0 {
+				end = len(query)
+			} else {
+				end += i + 4
+			}
+
+		case c == '$':
+			end = i + 1
+			for end < len(query) && query[end] >= '0' && query[end] <= '9' {
+				end++
+			}
+			n, err := strconv.Atoi(query[i+1 : end])
+			if err != nil || n < 1 {
+				// Not a placeholder, just a $.
+				i++
+				continue
+			}
+			kind = sqlPlaceholder
+			param = n
+
+		default:
+			i++
+			continue
+		}
+
+		flushText(i)
+		tokens = append(tokens, sqlToken{kind: kind, text: query[i:end], param: param})
+		i = end
+		textStart = end
+	}
+
+	flushText(len(query))
+
+	return tokens
+}
+
+// endOfQuoted gives the index just after the quoted string or name that starts
+// at index start.  A doubled quote is part of the string.  If backslash is
+// true, a backslash escapes the next character.
+func endOfQuoted(query string, start int, backslash bool) int {
+	quote := query[start]
+	i := start + 1
+	for i < len(query) {
+		switch {
+		case backslash && query[i] == '\\':
+			i += 2
+		case query[i] == quote && i+1 < len(query) && query[i+1] == quote:
+			i += 2
+		case query[i] == quote:
+			return i + 1
+		default:
+			i++
+		}
+	}
+	return len(query)
+}
+
+// endOfLine gives the index just after the end of the line that contains index
+// start.
+func endOfLine(query string, start int) int {
+	n := strings.IndexByte(query[start:], '\n')
+	if n < 0 {
+		return len(query)
+	}
+	return start + n + 1
+}
+
+// dashCommentFollows is true if the -- before index i starts a MySQL comment,
+// which it does if it's followed by white space or the end of the statement.
+func dashCommentFollows(query string, i int) bool {
+	return i >= len(query) || query[i] == ' ' || query[i] == '\t' || query[i] == '\n' || query[i] == '\r'
+}
+
+// postgresParamsToSQLiteParams converts the Postgres placeholders in a query
+// ($1, $2 etc) to SQLite numbered placeholders (?1, ?2 etc), so a placeholder
+// can still be used more than once and in any order.  Anything that looks like
+// a placeholder in a quoted string, a quoted name or a comment is left alone.
+func postgresParamsToSQLiteParams(query string) string {
+
+	var b strings.Builder
+	for _, t := range sqlTokens(query, false) {
+		if t.kind == sqlPlaceholder {
+			b.WriteString("?" + strconv.Itoa(t.param))
+			continue
+		}
+		b.WriteString(t.text)
+	}
+
+	return b.String()
+}
+
+// postgresParamsToMySQLParams converts the Postgres placeholders in a query
+// ($1, $2 etc) to MySQL placeholders (?).  A ? takes the next argument, so the
+// arguments are rearranged to suit - repeated if the placeholder is used more
+// than once and reordered if the placeholders are out of order.  If a
+// placeholder has no argument, the arguments are returned as they are and the
+// database reports the mismatch.  Anything that looks like a placeholder in a
+// quoted string, a quoted name or a comment is left alone.
+func postgresParamsToMySQLParams(query string, args []any) (string, []any) {
+
+	var b strings.Builder
+	order := make([]int, 0, len(args))
+	for _, t := range sqlTokens(query, true) {
+		if t.kind == sqlPlaceholder {
+			b.WriteString("?")
+			order = append(order, t.param)
+			continue
+		}
+		b.WriteString(t.text)
+	}
+
+	if len(order) == 0 {
+		return b.String(), args
+	}
+
+	arranged := make([]any, 0, len(order))
+	for _, n := range order {
+		if n > len(args) {
+			return b.String(), args
+		}
+		arranged = append(arranged, args[n-1])
+	}
+
+	return b.String(), arranged
+}
@@ -0,0 +1,183 @@
+package database
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+// TestSQLTokens checks that sqlTokens finds the quoted strings, comments and
+// placeholders in a statement, following the MySQL rules where they differ.
+func TestSQLTokens(t *testing.T) {
+
+	var testData = []struct {
+		description string
+		query       string
+		mysql       bool
+		want        []sqlTokenKind
+	}{
+		{"text", "select 1", false, []sqlTokenKind{sqlText}},
+		{"placeholders", "x=$1 and y=$12", false,
+			[]sqlTokenKind{sqlText, sqlPlaceholder, sqlText, sqlPlaceholder}},
+		{"string", "'a $1 b'", false, []sqlTokenKind{sqlQuoted}},
+		{"doubled quote", "'it''s $1'", false, []sqlTokenKind{sqlQuoted}},
+		{"quoted name", `"a""$1"`, false, []sqlTokenKind{sqlQuoted}},
+		{"backticks", "`a$1`", true, []sqlTokenKind{sqlQuoted}},
+		{"line comment", "-- $1\n$1", false, []sqlTokenKind{sqlComment, sqlPlaceholder}},
+		{"block comment", "/* $1 */$1", false, []sqlTokenKind{sqlComment, sqlPlaceholder}},
+		{"unclosed string", "'abc $1", false, []sqlTokenKind{sqlQuoted}},
+		{"unclosed comment", "/* abc $1", false, []sqlTokenKind{sqlComment}},
+		{"backslash in SQLite", `'a\' $1`, false, []sqlTokenKind{sqlQuoted, sqlText, sqlPlaceholder}},
+		{"backslash in MySQL", `'a\' $1'`, true, []sqlTokenKind{sqlQuoted}},
+		{"hash in SQLite", "# $1", false, []sqlTokenKind{sqlText, sqlPlaceholder}},
+		{"hash in MySQL", "# $1", true, []sqlTokenKind{sqlComment}},
+		{"dashes without a space in MySQL", "1--$1", true, []sqlTokenKind{sqlText, sqlPlaceholder}},
+		{"just a dollar", "$ $0 $a", false, []sqlTokenKind{sqlText}},
+	}
+
+	for _, td := range testData {
+		tokens := sqlTokens(td.query, td.mysql)
+		got := make([]sqlTokenKind, 0, len(tokens))
+		var text strings.Builder
+		for _, tok := range tokens {
+			got = append(got, tok.kind)
+			text.WriteString(tok.text)
+		}
+		if fmt.Sprint(td.want) != fmt.Sprint(got) {
+			t.Errorf("%s: want %v got %v", td.description, td.want, got)
+		}
+		if text.String() != td.query {
+			t.Errorf("%s: want %s got %s", td.description, td.query, text.String())
+		}
+	}
+}
+
+// TestPostgresParamsToMySQLParams checks that postgresParamsToMySQLParams
+// converts the placeholders to ? and rearranges the arguments to match.
+func TestPostgresParamsToMySQLParams(t *testing.T) {
+
+	var testData = []struct {
+		description string
+		query       string
+		args        []any
+		wantQuery   string
+		wantArgs    []any
+	}{
+		{"in order", "a=$1 and b=$2", []any{"x", 2}, "a=? and b=?", []any{"x", 2}},
+		{"out of order", "a=$2 and b=$1", []any{"x", 2}, "a=? and b=?", []any{2, "x"}},
+		{"reused", "a=$1 or b=$1 or c=$2", []any{"x", 2}, "a=? or b=? or c=?", []any{"x", "x", 2}},
+		{"quoted", "a='$2' and b=$1", []any{"x"}, "a='$2' and b=?", []any{"x"}},
+		{"missing argument", "a=$3", []any{"x"}, "a=?", []any{"x"}},
+		{"no placeholders", "select 1", nil, "select 1", nil},
+	}
+
+	for _, td := range testData {
+		gotQuery, gotArgs := postgresParamsToMySQLParams(td.query, td.args)
+		if td.wantQuery != gotQuery {
+			t.Errorf("%s: want %s got %s", td.description, td.wantQuery, gotQuery)
+		}
+		if fmt.Sprint(td.wantArgs) != fmt.Sprint(gotArgs) {
+			t.Errorf("%s: want %v got %v", td.description, td.wantArgs, gotArgs)
+		}
+	}
+}
+
+// FuzzSQLTokens checks that joining the tokens from sqlTokens gives the
+// statement back and that converting the placeholders leaves no placeholder
+// outside the quoted strings and comments.
+func FuzzSQLTokens(f *testing.F) {
+
+	f.Add("select $1, '$2', \"$3\" -- $4\n/* $5 */ $6", false)
+	f.Add(`'a\' $1 # $2`, true)
+	f.Add("$$1$", false)
+	f.Add("'''", true)
+	f.Add("/*/", false)
+
+	f.Fuzz(func(t *testing.T, query string, mysql bool) {
+
+		var text strings.Builder
+		for _, tok := range sqlTokens(query, mysql) {
+			text.WriteString(tok.text)
+		}
+		if text.String() != query {
+			t.Fatalf("want %q got %q", query, text.String())
+		}
+
+		var converted string
+		if mysql {
+			converted, _ = postgresParamsToMySQLParams(query, nil)
+		} else {
+			converted = postgresParamsToSQLiteParams(query)
+		}
+		for _, tok := range sqlTokens(converted, mysql) {
+			if tok.kind == sqlPlaceholder {
+				t.Fatalf("%q: placeholder %s left in %q", query, tok.text, converted)
+			}
+		}
+	})
+}
+
+// FuzzPlaceholders runs a query with string literals, comments and reused,
+// out of order placeholders on each of the test databases and checks that
+// each gives the expected result, so they agree with each other.
+func FuzzPlaceholders(f *testing.F) {
+	ctx := f.Context()
+
+	dbs := make(map[string]*Database)
+	for _, dbType := range databaseList {
+		db, connError := ConnectForTesting(dbType)
+		if connError != nil {
+			f.Fatal(connError)
+		}
+		defer db.CloseAndDelete()
+		defer db.Rollback()
+		dbs[dbType] = db
+	}
+
+	f.Add("plain", "one", "two")
+	f.Add("$1", "$2", "it's")
+	f.Add("it's $2 -- not a comment", "/* $1 */", "?")
+	f.Add("?1 ?2 ?", "\\", "$$")
+
+	f.Fuzz(func(t *testing.T, literal, arg1, arg2 string) {
+
+		for _, s := range []string{literal, arg1, arg2} {
+			// Postgres rejects text that isn't UTF-8 or contains a zero byte.
+			if !utf8.ValidString(s) || strings.ContainsRune(s, 0) {
+				t.Skip()
+			}
+		}
+
+		want := []string{literal, arg2, arg1, arg2}
+
+		for dbType, db := range dbs {
+
+			quoted := "'" + strings.ReplaceAll(literal, "'", "''") + "'"
+			textType := "text"
+			if dbType == "mysql" {
+				// In MySQL a backslash in a string escapes the next character.
+				quoted = strings.ReplaceAll(quoted, `\`, `\\`)
+				textType = "char"
+			}
+
+			query := fmt.Sprintf(`
+				SELECT %s /* $1 '$2' */, CAST($2 AS %s), -- $1
+					CAST($1 AS %s), CAST($2 AS %s);
+			`, quoted, textType, textType, textType)
+
+			got := make([]string, 4)
+			scanError := db.QueryRow(ctx, query, arg1, arg2).Scan(&got[0], &got[1], &got[2], &got[3])
+			if scanError != nil {
+				t.Fatalf("%s: %q: %v", dbType, query, scanError)
+			}
+
+			for i := range want {
+				if want[i] != got[i] {
+					t.Errorf("%s: %q: want %q got %q", dbType, query, want, got)
+					break
+				}
+			}
+		}
+	})
+}
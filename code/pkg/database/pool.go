@@ -0,0 +1,111 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// Pool is a pool of connections to the database, opened once when the server
+// starts and shared by all the HTTP requests.  database/sql does the work of
+// managing the connections.  Each request borrows a transaction.
+type Pool struct {
+	Config     *DBConfig     // The database config, including the pool settings.
+	Connection *sql.DB       // The pooled connections.
+	Version    string        // The version of Admidio, for example "4.3.14".
+	fieldIDs   *fieldIDCache // The IDs of the user fields, shared by the borrowers.
+	cacheOnce  sync.Once     // Creates fieldIDs.
+	queries    *QuerySet     // The query set for the version of Admidio.
+}
+
+// OpenPool connects to the database given by the config, applies the pool
+// settings in the config and chooses the queries for the version of Admidio
+// (see DetectAdmidioVersion).
+func OpenPool(config *DBConfig) (*Pool, error) {
+
+	db := New(config)
+	connectError := db.Connect()
+	if connectError != nil {
+		em := fmt.Sprintf("OpenPool: %v", connectError)
+		return nil, errors.New(em)
+	}
+
+	db.Connection.SetMaxOpenConns(config.MaxOpenConns)
+	if config.MaxIdleConns != 0 {
+		// SetMaxIdleConns(0) would keep no idle connections.
+		db.Connection.SetMaxIdleConns(config.MaxIdleConns)
+	}
+	db.Connection.SetConnMaxLifetime(config.ConnMaxLifetime)
+	db.Connection.SetConnMaxIdleTime(config.ConnMaxIdleTime)
+
+	// Choose the queries for the version of Admidio.  If it's not supported,
+	// the server shouldn't start.
+	txError := db.BeginTx(context.Background())
+	if txError != nil {
+		db.Connection.Close()
+		em := fmt.Sprintf("OpenPool: %v", txError)
+		return nil, errors.New(em)
+	}
+	version, versionError := db.DetectAdmidioVersion(context.Background())
+	db.Rollback()
+	if versionError != nil {
+		db.Connection.Close()
+		em := fmt.Sprintf("OpenPool: %v", versionError)
+		return nil, errors.New(em)
+	}
+
+	p := Pool{
+		Config:     config,
+		Connection: db.Connection,
+		Version:    version,
+		queries:    db.queries,
+	}
+
+	return &p, nil
+}
+
+// Borrow starts a transaction on one of the pooled connections and returns a
+// Database object holding it.  The caller should commit or roll back the
+// transaction and then call Close, which hands the connection back to the
+// pool rather than closing it.  If all of the connections are in use, Borrow
+// waits for one to become free.
+func (p *Pool) Borrow(ctx context.Context) (*Database, error) {
+
+	db := New(p.Config)
+	db.Connection = p.Connection
+	db.Logger = p.Config.Logger
+	db.borrowed = true
+	db.fieldIDs = p.sharedFieldIDs()
+	db.queries = p.queries
+
+	txError := db.BeginTx(ctx)
+	if txError != nil {
+		em := fmt.Sprintf("Borrow: %v", txError)
+		return nil, errors.New(em)
+	}
+
+	return db, nil
+}
+
+// sharedFieldIDs gets the cache of user field IDs that the pool shares between
+// the Database objects that it lends, creating it the first time.
+func (p *Pool) sharedFieldIDs() *fieldIDCache {
+	p.cacheOnce.Do(func() {
+		p.fieldIDs = newFieldIDCache()
+	})
+	return p.fieldIDs
+}
+
+// Stats gets the statistics of the pool - the number of connections open and
+// in use, how often requests have had to wait for one and so on.
+func (p *Pool) Stats() sql.DBStats {
+	return p.Connection.Stats()
+}
+
+// Close closes all of the connections in the pool.  It should only be called
+// when the server shuts down.
+func (p *Pool) Close() error {
+	return p.Connection.Close()
+}
@@ -0,0 +1,110 @@
+package database
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestPool checks that a Pool applies its settings, that borrowed transactions
+// use its connections and that closing a borrowed Database hands the
+// connection back rather than closing the pool.
+func TestPool(t *testing.T) {
+	ctx := t.Context()
+
+	path := filepath.Join(t.TempDir(), "admidio.db")
+
+	conn, createError := ConnectToSQLite(SQLiteConnectionDetails(path, true))
+	if createError != nil {
+		t.Fatal(createError)
+	}
+	_, tableError := conn.Exec("CREATE TABLE t (x integer);")
+	if tableError != nil {
+		t.Fatal(tableError)
+	}
+	// OpenPool checks the version of Admidio.
+	_, componentsError := conn.Exec(createComponentsTableSQL)
+	if componentsError != nil {
+		t.Fatal(componentsError)
+	}
+	_, versionError := conn.Exec(insertAdmidioVersionSQL)
+	if versionError != nil {
+		t.Fatal(versionError)
+	}
+	conn.Close()
+
+	config := DBConfig{
+		Type:            "sqlite",
+		Path:            path,
+		Logger:          DBConfigForTestingWithSQLite.Logger,
+		MaxOpenConns:    2,
+		MaxIdleConns:    1,
+		ConnMaxLifetime: time.Minute,
+		ConnMaxIdleTime: time.Minute,
+	}
+
+	pool, poolError := OpenPool(&config)
+	if poolError != nil {
+		t.Fatal(poolError)
+	}
+	defer pool.Close()
+
+	if pool.Stats().MaxOpenConnections != 2 {
+		t.Errorf("want 2 got %d", pool.Stats().MaxOpenConnections)
+	}
+
+	db1, borrowError1 := pool.Borrow(ctx)
+	if borrowError1 != nil {
+		t.Fatal(borrowError1)
+	}
+
+	if pool.Stats().InUse != 1 {
+		t.Errorf("want 1 in use got %d", pool.Stats().InUse)
+	}
+
+	_, insertError := db1.Exec(ctx, "INSERT INTO t (x) VALUES ($1);", 42)
+	if insertError != nil {
+		t.Fatal(insertError)
+	}
+	commitError := db1.Commit()
+	if commitError != nil {
+		t.Fatal(commitError)
+	}
+	db1.Close()
+
+	if pool.Stats().InUse != 0 {
+		t.Errorf("want 0 in use got %d", pool.Stats().InUse)
+	}
+
+	// Closing an unfinished transaction rolls it back and hands the
+	// connection back.
+	db2, borrowError2 := pool.Borrow(ctx)
+	if borrowError2 != nil {
+		t.Fatal(borrowError2)
+	}
+	_, insertError2 := db2.Exec(ctx, "INSERT INTO t (x) VALUES ($1);", 43)
+	if insertError2 != nil {
+		t.Fatal(insertError2)
+	}
+	db2.Close()
+
+	if pool.Stats().InUse != 0 {
+		t.Errorf("want 0 in use got %d", pool.Stats().InUse)
+	}
+
+	// The pool is still open and only the committed row is there.
+	db3, borrowError3 := pool.Borrow(ctx)
+	if borrowError3 != nil {
+		t.Fatal(borrowError3)
+	}
+	defer db3.Close()
+
+	var x int
+	searchError := db3.QueryRow(ctx, "SELECT sum(x) FROM t;").Scan(&x)
+	if searchError != nil {
+		t.Fatal(searchError)
+	}
+	if x != 42 {
+		t.Errorf("want 42 got %d", x)
+	}
+}
@@ -0,0 +1,439 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ProfileFields lists the Admidio profile fields that the application uses.
+// Everything else works from this list - GetUserData and SaveUserData, the
+// generic GetProfileField and SetProfileField, the details forms shown after a
+// sale and on the account page, the CSV importer and the schema check and
+// bootstrap - so adding a profile field means adding an entry here and a field
+// in UserData to hold its value (and, if it's on the forms, fields in
+// MembershipSale).  The fields that are
+// shown on the forms appear in this order.
+var ProfileFields = []ProfileField{
+	{
+		NameIntern: "SALUTATION",
+		Name:       "Salutation",
+		Type:       "TEXT",
+		Import:     true,
+		data:       func(ud *UserData) any { return &ud.Title },
+	},
+	{
+		NameIntern: "FIRST_NAME",
+		Name:       "SYS_FIRSTNAME",
+		Type:       "TEXT",
+		Import:     true,
+		data:       func(ud *UserData) any { return &ud.FirstName },
+	},
+	{
+		NameIntern: "LAST_NAME",
+		Name:       "SYS_LASTNAME",
+		Type:       "TEXT",
+		Import:     true,
+		data:       func(ud *UserData) any { return &ud.LastName },
+	},
+	{
+		NameIntern: "EMAIL",
+		Name:       "SYS_EMAIL",
+		Type:       "EMAIL",
+		Import:     true,
+		data:       func(ud *UserData) any { return &ud.Email },
+	},
+	{
+		NameIntern: "STREET",
+		Name:       "Address line 1",
+		Type:       "TEXT",
+		Label:      "<b>Address</b>",
+		FormName:   "address_line_1",
+		SaleField:  "AddressLine1",
+		Import:     true,
+		data:       func(ud *UserData) any { return &ud.AddressLine1 },
+		sale:       func(ms *MembershipSale) (*string, *string) { return &ms.AddressLine1, &ms.AddressLine1Error },
+	},
+	{
+		NameIntern: "ADDRESS_LINE_2",
+		Name:       "Address line 2",
+		Type:       "TEXT",
+		Label:      "&nbsp;",
+		FormName:   "address_line_2",
+		SaleField:  "AddressLine2",
+		Import:     true,
+		data:       func(ud *UserData) any { return &ud.AddressLine2 },
+		sale:       func(ms *MembershipSale) (*string, *string) { return &ms.AddressLine2, &ms.AddressLine2Error },
+	},
+	{
+		NameIntern: "ADDRESS_LINE_3",
+		Name:       "address line 3",
+		Type:       "TEXT",
+		Label:      "&nbsp;",
+		FormName:   "address_line_3",
+		SaleField:  "AddressLine3",
+		Import:     true,
+		data:       func(ud *UserData) any { return &ud.AddressLine3 },
+		sale:       func(ms *MembershipSale) (*string, *string) { return &ms.AddressLine3, &ms.AddressLine3Error },
+	},
+	{
+		NameIntern: "CITY",
+		Name:       "City",
+		Type:       "TEXT",
+		Label:      "<b>Town</b>",
+		FormName:   "town",
+		SaleField:  "Town",
+		Import:     true,
+		data:       func(ud *UserData) any { return &ud.Town },
+		sale:       func(ms *MembershipSale) (*string, *string) { return &ms.Town, &ms.TownError },
+	},
+	{
+		NameIntern: "COUNTY",
+		Name:       "County",
+		Type:       "TEXT",
+		Label:      "<b>County</b>",
+		FormName:   "county",
+		SaleField:  "County",
+		Import:     true,
+		data:       func(ud *UserData) any { return &ud.County },
+		sale:       func(ms *MembershipSale) (*string, *string) { return &ms.County, &ms.CountyError },
+	},
+	{
+		NameIntern: "POSTCODE",
+		Name:       "SYS_POSTCODE",
+		Type:       "TEXT",
+		Label:      "<b>Postcode</b>",
+		FormName:   "postcode",
+		SaleField:  "Postcode",
+		Import:     true,
+		data:       func(ud *UserData) any { return &ud.Postcode },
+		sale:       func(ms *MembershipSale) (*string, *string) { return &ms.Postcode, &ms.PostcodeError },
+	},
+	{
+		// The forms show a selection list of countries in place of this
+		// field (see MakeCountrySelectionList in the handler).
+		NameIntern: "COUNTRY",
+		Name:       "SYS_COUNTRY",
+		Type:       "TEXT",
+		FormName:   "country_code",
+		SaleField:  "CountryCode",
+		Import:     true,
+		data:       func(ud *UserData) any { return &ud.CountryCode },
+		sale:       func(ms *MembershipSale) (*string, *string) { return &ms.CountryCode, &ms.CountryCodeError },
+	},
+	{
+		NameIntern: "PHONE",
+		Name:       "SYS_PHONE",
+		Type:       "PHONE",
+		Label:      "<b>Landline phone number</b>",
+		FormName:   "phone",
+		SaleField:  "Phone",
+		Validate:   ValidatePhoneNumber,
+		Import:     true,
+		data:       func(ud *UserData) any { return &ud.Phone },
+		sale:       func(ms *MembershipSale) (*string, *string) { return &ms.Phone, &ms.PhoneError },
+	},
+	{
+		NameIntern: "MOBILE",
+		Name:       "SYS_MOBILE",
+		Type:       "PHONE",
+		Label:      "<b>Mobile number{{if gt (len .AssocAccountName) 0}} for {{.FirstName}} {{.LastName}}{{end}}</b>",
+		FormName:   "mobile",
+		SaleField:  "Mobile",
+		Validate:   ValidatePhoneNumber,
+		Import:     true,
+		data:       func(ud *UserData) any { return &ud.Mobile },
+		sale:       func(ms *MembershipSale) (*string, *string) { return &ms.Mobile, &ms.MobileError },
+	},
+	{
+		NameIntern: "LOCATION_OF_INTEREST",
+		Name:       "Location of Interest",
+		Type:       "TEXT",
+		Label:      "<b>Parish of Interest</b><br>(Ashtead, Fetcham, Bookham, Leatherhead or All Areas)",
+		FormName:   "location_of_interest",
+		SaleField:  "LocationOfInterest",
+		data:       func(ud *UserData) any { return &ud.LocationOfInterest },
+		sale: func(ms *MembershipSale) (*string, *string) {
+			return &ms.LocationOfInterest, &ms.LocationOfInterestError
+		},
+	},
+	{
+		NameIntern: DataStoragePermNameIntern,
+		Name:       "data protection permission",
+		Type:       "CHECKBOX",
+		Import:     true,
+		data:       func(ud *UserData) any { return &ud.DataProtection },
+	},
+	{
+		NameIntern: EmailPermNameIntern,
+		Name:       "Permission to send emails",
+		Type:       "CHECKBOX",
+		Import:     true,
+		data:       func(ud *UserData) any { return &ud.EmailPermission },
+	},
+	{
+		NameIntern: "NOTICES_BY_EMAIL",
+		Name:       "Notices by email",
+		Type:       "CHECKBOX",
+		data:       func(ud *UserData) any { return &ud.NoticesByEmail },
+	},
+	{
+		NameIntern: "FRIEND_OF_THE_MUSEUM",
+		Name:       "Friend of the Museum",
+		Type:       "CHECKBOX",
+		data:       func(ud *UserData) any { return &ud.Friend },
+	},
+	{
+		NameIntern: "GIFT_AID",
+		Name:       "gift aid",
+		Type:       "CHECKBOX",
+		data:       func(ud *UserData) any { return &ud.Giftaid },
+	},
+	{
+		NameIntern: "VALUE_OF_LAST_PAYMENT",
+		Name:       "Total value of last payment",
+		Type:       "DECIMAL",
+		data:       func(ud *UserData) any { return &ud.LastPayment },
+	},
+	{
+		NameIntern: "VALUE_OF_DONATION_TO_LDLHS",
+		Name:       "donation to the society",
+		Type:       "DECIMAL",
+		data:       func(ud *UserData) any { return &ud.DonationToSociety },
+	},
+	{
+		NameIntern: "VALUE_OF_DONATION_TO_THE_MUSEUM",
+		Name:       "Donation to the museum.",
+		Type:       "DECIMAL",
+		data:       func(ud *UserData) any { return &ud.DonationToMuseum },
+	},
+	{
+		NameIntern: "DATE_LAST_PAID",
+		Name:       "date last paid",
+		Type:       "DATE",
+		data:       func(ud *UserData) any { return &ud.DateLastPaid },
+	},
+	{
+		NameIntern: "MEMBERS_AT_ADDRESS",
+		Name:       "Number of members of LDLHS at address",
+		Type:       "NUMBER",
+		data:       func(ud *UserData) any { return &ud.MembersAtAddress },
+	},
+	{
+		NameIntern: "NUMBER_OF_FRIENDS_OF_THE_MUSEUM_AT_THIS_ADDRESS",
+		Name:       "Number of Friends of the Museum at this address",
+		Type:       "NUMBER",
+		data:       func(ud *UserData) any { return &ud.FriendsAtAddress },
+	},
+}
+
+// ProfileField describes one of the Admidio profile fields that the
+// application uses.  The Go type of the field is the type of the field of
+// UserData that holds it - string for text and dates, bool for tick boxes,
+// float64 for money and int for counts.  In the database tick boxes are set
+// to 0 or 1 and dates are "YYYY-MM-DD".
+type ProfileField struct {
+	NameIntern string                    // The usf_name_intern, for example "STREET".
+	Name       string                    // The usf_name shown on the Admidio profile page.
+	Type       string                    // The usf_type, for example "CHECKBOX".
+	Label      string                    // The label on the details forms (HTML template text).
+	FormName   string                    // The name of the input on the details forms.  Empty if the field isn't shown.
+	SaleField  string                    // The field of MembershipSale holding the value on the forms.
+	Validate   func(value string) string // Checks a value from a form and gives an error message.  Nil if any value will do.
+	Import     bool                      // True if the CSV importer sets the field.
+
+	// data gives a pointer to the field of UserData that holds the value.
+	data func(ud *UserData) any
+
+	// sale gives pointers to the fields of MembershipSale that hold the value
+	// and the error message on the forms.  Nil if the field isn't shown.
+	sale func(ms *MembershipSale) (value, errorMessage *string)
+}
+
+// Sale gives pointers to the fields of the sale that hold the value of the
+// field on the details forms and the error message about it.  It returns nils
+// if the field isn't shown on the forms.
+func (f *ProfileField) Sale(ms *MembershipSale) (value, errorMessage *string) {
+	if f.sale == nil {
+		return nil, nil
+	}
+	return f.sale(ms)
+}
+
+// stored gives the value of the field in the user data in the form that it's
+// stored in the database.
+func (f *ProfileField) stored(ud *UserData) string {
+	switch p := f.data(ud).(type) {
+	case *bool:
+		if *p {
+			return "1"
+		}
+		return "0"
+	case *float64:
+		return strconv.FormatFloat(*p, 'f', -1, 64)
+	case *int:
+		return strconv.Itoa(*p)
+	case *string:
+		return *p
+	default:
+		return ""
+	}
+}
+
+// load sets the field in the user data from the value stored in the database.
+// For a tick box 0 is false and any other number is true.
+func (f *ProfileField) load(ud *UserData, value string) error {
+	value = strings.TrimSpace(value)
+	switch p := f.data(ud).(type) {
+	case *bool:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		*p = n != 0
+	case *float64:
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return err
+		}
+		*p = v
+	case *int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		*p = n
+	case *string:
+		*p = value
+	}
+	return nil
+}
+
+// isSet is true if the field has a value in the user data - text that isn't
+// empty, a ticked box or a number that isn't zero.
+func (f *ProfileField) isSet(ud *UserData) bool {
+	switch p := f.data(ud).(type) {
+	case *bool:
+		return *p
+	case *float64:
+		return *p != 0
+	case *int:
+		return *p != 0
+	case *string:
+		return len(*p) > 0
+	default:
+		return false
+	}
+}
+
+// findProfileField finds the profile field with the given internal name.
+func findProfileField(nameIntern string) (*ProfileField, bool) {
+	for i := range ProfileFields {
+		if ProfileFields[i].NameIntern == nameIntern {
+			return &ProfileFields[i], true
+		}
+	}
+	return nil, false
+}
+
+// ImportedFields gives the internal names of the profile fields that the CSV
+// importer sets and that are set in the given user data.
+func ImportedFields(ud *UserData) []string {
+	names := make([]string, 0)
+	for i := range ProfileFields {
+		if ProfileFields[i].Import && ProfileFields[i].isSet(ud) {
+			names = append(names, ProfileFields[i].NameIntern)
+		}
+	}
+	return names
+}
+
+// GetProfileField gets the value of the profile field with the given internal
+// name for the given user.  T must be the Go type of the field (see
+// ProfileField).  If the field isn't set, it returns the zero value.
+// It's assumed that a transaction is already set up in the db object.
+func GetProfileField[T string | bool | float64 | int](ctx context.Context, db *Database, userID int64, nameIntern string) (T, error) {
+
+	const fn = "GetProfileField"
+
+	var zero T
+
+	field, found := findProfileField(nameIntern)
+	if !found {
+		em := fmt.Sprintf("%s: %s is not a profile field", fn, nameIntern)
+		return zero, errors.New(em)
+	}
+
+	fieldID, fieldError := db.GetUserDataFieldIDByNameIntern(ctx, nameIntern)
+	if fieldError != nil {
+		return zero, fieldError
+	}
+
+	stored, fetchError := GetUserDataField[string](ctx, db, fieldID, userID)
+	if fetchError != nil {
+		return zero, fetchError
+	}
+
+	ud := UserData{UserID: userID}
+	if len(stored) > 0 {
+		loadError := field.load(&ud, stored)
+		if loadError != nil {
+			em := fmt.Sprintf("%s: user %d field %s: %v", fn, userID, nameIntern, loadError)
+			return zero, errors.New(em)
+		}
+	}
+
+	v, ok := field.data(&ud).(*T)
+	if !ok {
+		em := fmt.Sprintf("%s: %s is not a %T", fn, nameIntern, zero)
+		return zero, errors.New(em)
+	}
+
+	return *v, nil
+}
+
+// SetProfileField sets the profile field with the given internal name for the
+// given user.  T must be the Go type of the field (see ProfileField).  Setting
+// a text field to an empty string removes it.
+// It's assumed that a transaction is already set up in the db object.
+func SetProfileField[T string | bool | float64 | int](ctx context.Context, db *Database, userID int64, nameIntern string, val T) error {
+
+	const fn = "SetProfileField"
+
+	field, found := findProfileField(nameIntern)
+	if !found {
+		em := fmt.Sprintf("%s: %s is not a profile field", fn, nameIntern)
+		return errors.New(em)
+	}
+
+	ud := UserData{UserID: userID}
+	p, ok := field.data(&ud).(*T)
+	if !ok {
+		em := fmt.Sprintf("%s: %s is not a %T", fn, nameIntern, val)
+		return errors.New(em)
+	}
+	*p = val
+
+	return db.SaveUserData(ctx, &ud, nameIntern)
+}
+
+// IllegalPhoneNumber is the error message for an illegal phone number.
+const IllegalPhoneNumber = "phone number must start with '+' or '0' and then must be all digits or spaces"
+
+// phoneNumberRegexp matches a valid phone number.  A phone number should start
+// with "+" or "0".  That should be followed by a list of (digit or space).
+var phoneNumberRegexp = regexp.MustCompile(`[+0][0-9 ]+`)
+
+// ValidatePhoneNumber checks a phone number.  It should start with "+" or "0"
+// and this should be followed by digits or spaces. For example
+// "+44 1234 567890" or "01234 567890" or "020 7123 4567".  If the number is
+// invalid, an error message is returned.
+func ValidatePhoneNumber(phoneNumber string) string {
+	if !phoneNumberRegexp.MatchString(phoneNumber) {
+		return IllegalPhoneNumber
+	}
+	return ""
+}
@@ -0,0 +1,78 @@
+package database
+
+import (
+	"testing"
+)
+
+// TestGetAndSetProfileField checks that SetProfileField and GetProfileField
+// store and fetch each type of profile field.
+func TestGetAndSetProfileField(t *testing.T) {
+	ctx := t.Context()
+
+	for _, dbType := range databaseList {
+
+		db, connError := ConnectForTesting(dbType)
+		if connError != nil {
+			t.Error(connError)
+			continue
+		}
+
+		defer db.Rollback()
+		defer db.CloseAndDelete()
+
+		u, ue := CreateUser(ctx, db)
+		if ue != nil {
+			t.Fatal(ue)
+		}
+
+		if err := SetProfileField(ctx, db, u.ID, "CITY", "Bookham"); err != nil {
+			t.Errorf("%s: %v", dbType, err)
+		}
+		if err := SetProfileField(ctx, db, u.ID, "GIFT_AID", true); err != nil {
+			t.Errorf("%s: %v", dbType, err)
+		}
+		if err := SetProfileField(ctx, db, u.ID, "VALUE_OF_DONATION_TO_LDLHS", 12.5); err != nil {
+			t.Errorf("%s: %v", dbType, err)
+		}
+		if err := SetProfileField(ctx, db, u.ID, "MEMBERS_AT_ADDRESS", 3); err != nil {
+			t.Errorf("%s: %v", dbType, err)
+		}
+
+		town, townError := GetProfileField[string](ctx, db, u.ID, "CITY")
+		if townError != nil || town != "Bookham" {
+			t.Errorf("%s: want Bookham got %s %v", dbType, town, townError)
+		}
+
+		giftaid, giftaidError := GetProfileField[bool](ctx, db, u.ID, "GIFT_AID")
+		if giftaidError != nil || !giftaid {
+			t.Errorf("%s: want true got %v %v", dbType, giftaid, giftaidError)
+		}
+
+		donation, donationError := GetProfileField[float64](ctx, db, u.ID, "VALUE_OF_DONATION_TO_LDLHS")
+		if donationError != nil || donation != 12.5 {
+			t.Errorf("%s: want 12.5 got %f %v", dbType, donation, donationError)
+		}
+
+		members, membersError := GetProfileField[int](ctx, db, u.ID, "MEMBERS_AT_ADDRESS")
+		if membersError != nil || members != 3 {
+			t.Errorf("%s: want 3 got %d %v", dbType, members, membersError)
+		}
+
+		// A field that isn't set gives the zero value.
+		county, countyError := GetProfileField[string](ctx, db, u.ID, "COUNTY")
+		if countyError != nil || county != "" {
+			t.Errorf("%s: want empty got %s %v", dbType, county, countyError)
+		}
+
+		// The wrong type or an unknown field is an error.
+		if _, err := GetProfileField[int](ctx, db, u.ID, "CITY"); err == nil {
+			t.Errorf("%s: want an error for the wrong type", dbType)
+		}
+		if err := SetProfileField(ctx, db, u.ID, "GIFT_AID", "yes"); err == nil {
+			t.Errorf("%s: want an error for the wrong type", dbType)
+		}
+		if err := SetProfileField(ctx, db, u.ID, "NO_SUCH_FIELD", "x"); err == nil {
+			t.Errorf("%s: want an error for an unknown field", dbType)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package database
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
@@ -20,43 +21,28 @@ const TransactionTypeRenewal = "membership renewal"
 const PaymentStatusPending = "pending"
 const PaymentStatusComplete = "complete"
 
-var regExpForPostgresParamsToSQLiteParams *regexp.Regexp
-
-// init should always work but if any of the calls in it fail, it will
-// crash the application.
-func init() {
-	// Set up the regular expression or die.
-	regExpForPostgresParamsToSQLiteParams = regexp.MustCompile(`\$[0-9]+`)
-}
-
 // CreateRole creates a role with the given name.
 // It's assumed that a transaction is set up in the db object.
-func (db *Database) CreateRole(role *Role) error {
+func (db *Database) CreateRole(ctx context.Context, role *Role) error {
 
 	var uError error
-	role.UUID, uError = CreateUuid(db.Transaction, "rol_uuid", "adm_roles")
+	role.UUID, uError = db.createUuid(ctx, "rol_uuid", "adm_roles")
 	if uError != nil {
 		return uError
 	}
 
-	// rol_adminstrator contains "t" or "f".
-	adminFlag := "f"
-	if role.Administrator {
-		adminFlag = "t"
-	}
-
 	const sqlPostgres = `
 	insert into adm_roles(
 		rol_uuid, rol_name, rol_cat_id, rol_usr_id_create, 
 		rol_administrator, rol_valid) 
-		values($1, $2, $3, $4, $5,'t') 
+		values($1, $2, $3, $4, $5, $6) 
 		RETURNING rol_id;`
 
 	const sqlSQLite = `
 	insert into adm_roles(
 		rol_uuid, rol_name, rol_cat_id, rol_usr_id_create, 
 		rol_administrator, rol_valid) 
-		values(?, ?, ?, ?, ?, 't');`
+		values(?, ?, ?, ?, ?, ?);`
 
 	var q string
 	switch db.Config.Type {
@@ -66,7 +52,8 @@ func (db *Database) CreateRole(role *Role) error {
 		q = sqlSQLite
 	}
 
-	id, err := db.CreateRow(q, role.UUID, role.Name, role.RoleCategory.ID, role.RoleCategory.CreateUser.ID, adminFlag)
+	id, err := db.CreateRow(ctx, q, role.UUID, role.Name, role.RoleCategory.ID, role.RoleCategory.CreateUser.ID,
+		db.boolValue(role.Administrator), db.boolValue(true))
 	if err != nil {
 		return err
 	}
@@ -77,11 +64,11 @@ func (db *Database) CreateRole(role *Role) error {
 }
 
 // GetRole gets the role with the given name.
-func (db *Database) GetRole(name string) (*Role, error) {
+func (db *Database) GetRole(ctx context.Context, name string) (*Role, error) {
 
 	role := Role{}
 	q := `select rol_id, rol_uuid, rol_name, rol_cat_id, rol_usr_id_create, rol_administrator, rol_valid from adm_roles where rol_name=$1;`
-	row := db.QueryRow(q, name)
+	row := db.QueryRow(ctx, q, name)
 	if row.Err() != nil {
 		return nil, row.Err()
 
@@ -93,21 +80,17 @@ func (db *Database) GetRole(name string) (*Role, error) {
 		return nil, err
 	}
 
-	if admin == "t" {
-		role.Administrator = true
-	}
-	if valid == "t" {
-		role.Valid = true
-	}
+	role.Administrator = isTrue(admin)
+	role.Valid = isTrue(valid)
 
 	var catError error
-	role.RoleCategory, catError = db.GetCategory(categoryID)
+	role.RoleCategory, catError = db.GetCategory(ctx, categoryID)
 	if catError != nil {
 		return nil, catError
 	}
 
 	var userError error
-	role.CreateUser, userError = db.GetUser(createUserID)
+	role.CreateUser, userError = db.GetUser(ctx, createUserID)
 	if userError != nil {
 		return nil, userError
 	}
@@ -117,10 +100,10 @@ func (db *Database) GetRole(name string) (*Role, error) {
 
 // CreateOrganisation creates an organisation (adm_organization) with
 // the given names.  It's assumed that a transaction is set up in the db object.
-func (db *Database) CreateOrganisation(org *Organisation) error {
+func (db *Database) CreateOrganisation(ctx context.Context, org *Organisation) error {
 
 	var uError error
-	org.UUID, uError = CreateUuid(db.Transaction, "org_uuid", "adm_organizations")
+	org.UUID, uError = db.createUuid(ctx, "org_uuid", "adm_organizations")
 	if uError != nil {
 		return uError
 	}
@@ -139,7 +122,7 @@ func (db *Database) CreateOrganisation(org *Organisation) error {
 	}
 
 	var err error
-	org.ID, err = db.CreateRow(q, org.UUID, org.Shortname, org.Longname, org.HomePage)
+	org.ID, err = db.CreateRow(ctx, q, org.UUID, org.Shortname, org.Longname, org.HomePage)
 	if err != nil {
 		return err
 	}
@@ -149,11 +132,11 @@ func (db *Database) CreateOrganisation(org *Organisation) error {
 
 // GetOrganisationById gets the oganisation with the given ID.  If there is no such organisation
 // it returns sql.ErrNoRow.
-func (db *Database) GetOrganisationById(id int64) (*Organisation, error) {
+func (db *Database) GetOrganisationById(ctx context.Context, id int64) (*Organisation, error) {
 
 	org := Organisation{}
 	q := `select org_id, org_uuid, org_shortname, org_longname, org_homepage from adm_organizations where org_id=$1;`
-	row := db.QueryRow(q, id)
+	row := db.QueryRow(ctx, q, id)
 	if row.Err() != nil {
 		return nil, row.Err()
 	}
@@ -170,7 +153,7 @@ func (db *Database) GetOrganisationById(id int64) (*Organisation, error) {
 
 // GetOrganisationsByShortName gets the oragniasation(s) with the given  shortname.  That should
 // return a list containing just one organisation but in theory there could be more.
-func (db *Database) GetOrganisationsByShortName(name string) ([]Organisation, error) {
+func (db *Database) GetOrganisationsByShortName(ctx context.Context, name string) ([]Organisation, error) {
 	const q = `
 		SELECT org_id, org_uuid, org_shortname, org_longname, org_homepage
 		FROM adm_organizations
@@ -179,7 +162,7 @@ func (db *Database) GetOrganisationsByShortName(name string) ([]Organisation, er
 
 	orgs := make([]Organisation, 0)
 
-	rows, err := db.Query(q, name)
+	rows, err := db.Query(ctx, q, name)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return orgs, nil
@@ -205,22 +188,16 @@ func (db *Database) GetOrganisationsByShortName(name string) ([]Organisation, er
 
 // CreateCategory creates an category (adm_categories) using the given category
 // data.  It's assumed that a transaction is set up in the db object.
-func (db *Database) CreateCategory(cat *Category) error {
+func (db *Database) CreateCategory(ctx context.Context, cat *Category) error {
 
 	var uError error
-	cat.UUID, uError = CreateUuid(db.Transaction, "cat_uuid", "adm_categories")
+	cat.UUID, uError = db.createUuid(ctx, "cat_uuid", "adm_categories")
 	if uError != nil {
 		return uError
 	}
 
-	sys := "f"
-	if cat.System {
-		sys = "t"
-	}
-	df := "f"
-	if cat.Default {
-		df = "t"
-	}
+	sys := db.boolValue(cat.System)
+	df := db.boolValue(cat.Default)
 
 	// For postgress, the SQL is something like:
 	// insert into adm_categories(cat_uuid, cat_type, cat_name_intern, cat_name,
@@ -244,7 +221,7 @@ func (db *Database) CreateCategory(cat *Category) error {
 		default:
 			q = sqlLeader + `values(?, ?, ?, ?, ?, ?, ?, NULL, NULL);`
 		}
-		cat.ID, err = db.CreateRow(
+		cat.ID, err = db.CreateRow(ctx,
 			q, cat.UUID, cat.Type, cat.NameIntern, cat.Name,
 			sys, df, cat.Sequence,
 		)
@@ -261,7 +238,7 @@ func (db *Database) CreateCategory(cat *Category) error {
 			q = sqlLeader + `values(?, ?, ?, ?, ?, ?, ?, NULL, ?);`
 		}
 
-		cat.ID, err = db.CreateRow(
+		cat.ID, err = db.CreateRow(ctx,
 			q, cat.UUID, cat.Type, cat.NameIntern, cat.Name,
 			sys, df, cat.Sequence, cat.CreateUser.ID,
 		)
@@ -278,7 +255,7 @@ func (db *Database) CreateCategory(cat *Category) error {
 			q = sqlLeader + `values(?, ?, ?, ?, ?, ?, ?, ?, NULL);`
 		}
 
-		cat.ID, err = db.CreateRow(
+		cat.ID, err = db.CreateRow(ctx,
 			q, cat.UUID, cat.Type, cat.NameIntern, cat.Name,
 			sys, df, cat.Sequence, cat.Org.ID,
 		)
@@ -296,7 +273,7 @@ func (db *Database) CreateCategory(cat *Category) error {
 
 		}
 
-		cat.ID, err = db.CreateRow(
+		cat.ID, err = db.CreateRow(ctx,
 			q, cat.UUID, cat.Type, cat.NameIntern, cat.Name,
 			sys, df, cat.Sequence, cat.Org.ID, cat.CreateUser.ID,
 		)
@@ -311,7 +288,7 @@ func (db *Database) CreateCategory(cat *Category) error {
 
 // GetCategory gets the category with the given ID.  If there is no such category
 // it returns sql.ErrNoRows.
-func (db *Database) GetCategory(id int64) (*Category, error) {
+func (db *Database) GetCategory(ctx context.Context, id int64) (*Category, error) {
 
 	cat := Category{}
 	// cat_org_id and cat_usr_id_create can be null.  If so, set to zero.
@@ -326,7 +303,7 @@ func (db *Database) GetCategory(id int64) (*Category, error) {
 	default:
 		q = fmt.Sprintf(qTemplate, "IFNULL", "IFNULL")
 	}
-	row := db.QueryRow(q, id)
+	row := db.QueryRow(ctx, q, id)
 
 	var orgID, createUserID int64
 	var system, def string
@@ -337,7 +314,7 @@ func (db *Database) GetCategory(id int64) (*Category, error) {
 		return nil, errors.New(em)
 	}
 
-	completeError := db.completeCategory(&cat, system, def, orgID, createUserID)
+	completeError := db.completeCategory(ctx, &cat, system, def, orgID, createUserID)
 	if completeError != nil {
 		em := fmt.Sprintf("cannot complete category %d - %v", cat.ID, completeError)
 		slog.Error(em)
@@ -349,7 +326,7 @@ func (db *Database) GetCategory(id int64) (*Category, error) {
 }
 
 // GetCategoriessByName gets the category with the given name.
-func (db *Database) GetCategoryByNameIntern(name string) (*Category, error) {
+func (db *Database) GetCategoryByNameIntern(ctx context.Context, name string) (*Category, error) {
 	// cat_org_id and cat_usr_id_create can be null.  If so, set to zero.
 	const qTemplate = `select cat_id, cat_uuid, %s(cat_org_id, 0), cat_type, cat_name_intern, cat_name, 
 		cat_system, cat_default, cat_sequence, %s(cat_usr_id_create, 0) 
@@ -366,14 +343,14 @@ func (db *Database) GetCategoryByNameIntern(name string) (*Category, error) {
 	var orgID, createUserID int64
 	var system, def string
 	cat := Category{}
-	rows := db.QueryRow(q, name)
+	rows := db.QueryRow(ctx, q, name)
 	err := rows.Scan(&cat.ID, &cat.UUID, &orgID, &cat.Type, &cat.NameIntern, &cat.Name,
 		&system, &def, &cat.Sequence, &createUserID)
 	if err != nil {
 		return nil, err
 	}
 
-	completeError := db.completeCategory(&cat, system, def, orgID, createUserID)
+	completeError := db.completeCategory(ctx, &cat, system, def, orgID, createUserID)
 	if completeError != nil {
 		em := fmt.Sprintf("completeCategory: %d - %v", cat.ID, completeError)
 		slog.Error(em)
@@ -385,24 +362,19 @@ func (db *Database) GetCategoryByNameIntern(name string) (*Category, error) {
 
 // completeCategory is a helper function.  It fills in the fields of a category that are not easy
 // to fetch directly from the database.
-func (db *Database) completeCategory(category *Category, system, def string, organisationID, createUserID int64) error {
+func (db *Database) completeCategory(ctx context.Context, category *Category, system, def string, organisationID, createUserID int64) error {
 
 	if category == nil {
 		return errors.New("cannot complete category - no category")
 	}
 
-	// Postgres return "true" and "false", SQLite returns "t"and "f".
-	if system == "true" || system == "t" {
-		category.System = true
-	}
-	if def == "true" || def == "t" {
-		category.Default = true
-	}
+	category.System = isTrue(system)
+	category.Default = isTrue(def)
 
 	if organisationID != 0 {
 		// Get the embedded organisation.
 		var err error
-		category.Org, err = db.GetOrganisationById(organisationID)
+		category.Org, err = db.GetOrganisationById(ctx, organisationID)
 		if err != nil {
 			return errors.New("completeCategory:" + err.Error())
 		}
@@ -410,7 +382,7 @@ func (db *Database) completeCategory(category *Category, system, def string, org
 	if createUserID != 0 {
 		// Get the embedded user.
 		var err error
-		category.CreateUser, err = db.GetUser(createUserID)
+		category.CreateUser, err = db.GetUser(ctx, createUserID)
 		if err != nil {
 			return errors.New("completeCategory:" + err.Error())
 		}
@@ -421,25 +393,34 @@ func (db *Database) completeCategory(category *Category, system, def string, org
 
 // CreateUser creates a user with the valid flag set.  The password is
 // locked so they need to use the password change mechanism to log in.
+// The new user is recorded in the change history.
 // It's assumed that a transaction is already set up in the db object.
-func (db *Database) CreateUser(user *User) error {
+func (db *Database) CreateUser(ctx context.Context, user *User) error {
 
 	var uuidError error
-	user.UUID, uuidError = CreateUuid(db.Transaction, "usr_uuid", "adm_users")
+	user.UUID, uuidError = db.createUuid(ctx, "usr_uuid", "adm_users")
 	if uuidError != nil {
 		return uuidError
 	}
 
+	// The new account is attributed to the system user.
+	systemUserID, systemUserError := db.SystemUserID(ctx)
+	if systemUserError != nil {
+		em := fmt.Sprintf("CreateUser: %s - %v", user.LoginName, systemUserError)
+		slog.Error(em)
+		return systemUserError
+	}
+
 	const postgresSQL = `
 		insert into adm_users
-		(usr_uuid, usr_login_name, usr_password, usr_valid)
-		values($1, $2, '*LK*', 't')
+		(usr_uuid, usr_login_name, usr_password, usr_valid, usr_usr_id_create)
+		values($1, $2, '*LK*', $3, $4)
 		RETURNING usr_id;
 	`
 	const sqliteSQL = `
 		insert into adm_users
-		(usr_uuid, usr_login_name, usr_password, usr_valid)
-		values(?, ?, '*LK*', 't');
+		(usr_uuid, usr_login_name, usr_password, usr_valid, usr_usr_id_create)
+		values(?, ?, '*LK*', ?, ?);
 	`
 
 	var q string
@@ -450,7 +431,7 @@ func (db *Database) CreateUser(user *User) error {
 		q = sqliteSQL
 	}
 
-	id, createError := db.CreateRow(q, user.UUID, user.LoginName)
+	id, createError := db.CreateRow(ctx, q, user.UUID, user.LoginName, db.boolValue(true), systemUserID)
 
 	if createError != nil {
 		// This error will mess up the whole process, so log it.
@@ -461,30 +442,44 @@ func (db *Database) CreateUser(user *User) error {
 
 	user.ID = id
 	user.Valid = true
+	user.IDCreate = int(systemUserID)
 
-	return nil
+	return db.logChanges(ctx, user.ID, change{item: "usr_login_name", newValue: user.LoginName})
 }
 
 // CreateUserWithNullPassword creates a user with a NULL password.  Such a user cannot log in
 // to the Admidio website.  The user "System" is set up that way and we need to set up that
 // user in the test environment.  The function assumes that a transaction is already set up
 // in the db object.
-func (db *Database) CreateUserWithNullPassword(user *User) error {
+func (db *Database) CreateUserWithNullPassword(ctx context.Context, user *User) error {
 
 	var uuidError error
-	user.UUID, uuidError = CreateUuid(db.Transaction, "usr_uuid", "adm_users")
+	user.UUID, uuidError = db.createUuid(ctx, "usr_uuid", "adm_users")
 	if uuidError != nil {
 		return uuidError
 	}
 
-	const q = `
+	const postgresSQL = `
 		insert into adm_users
 		(usr_uuid, usr_login_name, usr_valid)
-		values($1, $2, 't')
+		values($1, $2, $3)
 		RETURNING usr_id;
 	`
+	const sqliteSQL = `
+		insert into adm_users
+		(usr_uuid, usr_login_name, usr_valid)
+		values(?, ?, ?);
+	`
+
+	var q string
+	switch db.Config.Type {
+	case "postgres":
+		q = postgresSQL
+	default:
+		q = sqliteSQL
+	}
 
-	id, createError := db.CreateRow(q, user.UUID, user.LoginName)
+	id, createError := db.CreateRow(ctx, q, user.UUID, user.LoginName, db.boolValue(true))
 
 	if createError != nil {
 		return createError
@@ -498,7 +493,7 @@ func (db *Database) CreateUserWithNullPassword(user *User) error {
 
 // UpdateUser saves a (presumably) updated User.
 // It's assumed that a transaction is already set up in the db object.
-func (db *Database) UpdateUser(user *User) error {
+func (db *Database) UpdateUser(ctx context.Context, user *User) error {
 
 	const sql = `
 		UPDATE adm_users 
@@ -506,12 +501,7 @@ func (db *Database) UpdateUser(user *User) error {
 		WHERE usr_id=$5;
 	`
 
-	var valid string = "f"
-	if user.Valid {
-		valid = "t"
-	}
-
-	_, updateError := db.Exec(sql, user.UUID, user.LoginName, user.Password, valid, user.ID)
+	_, updateError := db.Exec(ctx, sql, user.UUID, user.LoginName, user.Password, db.boolValue(user.Valid), user.ID)
 
 	if updateError != nil {
 		return updateError
@@ -522,7 +512,7 @@ func (db *Database) UpdateUser(user *User) error {
 
 // GetUser gets the user with the given ID.  It's assumed that a transaction is already
 // set up in the db object.
-func (db *Database) GetUser(id int64) (*User, error) {
+func (db *Database) GetUser(ctx context.Context, id int64) (*User, error) {
 
 	// The password may be null (which prevents login).
 	// Postgres uses COALESCE to convert NULL to a readable value, SQLite uses IFNULL
@@ -542,7 +532,7 @@ func (db *Database) GetUser(id int64) (*User, error) {
 
 	u := NewUser("name to be overwritten")
 
-	err := db.QueryRow(q, id).Scan(&u.ID, &u.UUID, &u.LoginName, &u.Password, &u.Valid)
+	err := db.QueryRow(ctx, q, id).Scan(&u.ID, &u.UUID, &u.LoginName, &u.Password, &u.Valid)
 	if err != nil {
 		return nil, err
 	}
@@ -552,7 +542,7 @@ func (db *Database) GetUser(id int64) (*User, error) {
 
 // GetUsers gets all of the users.  It's assumed that a transaction is already set up
 // in the db object.
-func (db *Database) GetUsers() ([]User, error) {
+func (db *Database) GetUsers(ctx context.Context) ([]User, error) {
 
 	// The password may be null (which prevents login).
 	// Postgres uses COALESCE to convert NULL to a readable value, SQLite uses IFNULL
@@ -572,7 +562,7 @@ func (db *Database) GetUsers() ([]User, error) {
 
 	users := make([]User, 0)
 
-	rows, err := db.Query(q)
+	rows, err := db.Query(ctx, q)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return users, nil
@@ -599,7 +589,7 @@ func (db *Database) GetUsers() ([]User, error) {
 // GetUsersByLoginName gets the users with the given login name.  The search is case-insensitive.
 // The result should be a list containing just one user but in theory there could be more.  It's
 // assumed that a transaction is already set up in the db object.
-func (db *Database) GetUsersByLoginName(name string) ([]User, error) {
+func (db *Database) GetUsersByLoginName(ctx context.Context, name string) ([]User, error) {
 
 	// The password may be null (which prevents login).
 	// Postgres uses COALESCE to convert NULL to a readable value, SQLite uses IFNULL.
@@ -621,7 +611,7 @@ func (db *Database) GetUsersByLoginName(name string) ([]User, error) {
 
 	users := make([]User, 0)
 
-	rows, err := db.Query(q, name)
+	rows, err := db.Query(ctx, q, name)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return users, nil
@@ -648,11 +638,11 @@ func (db *Database) GetUsersByLoginName(name string) ([]User, error) {
 // GetUserByLoginName gets the user with the given login name (case-insensitive).
 // That field has a unique constraint so there will be at most one result.
 // It's assumed that a transaction is already set up in the db object.
-func (db *Database) GetUserByLoginName(name string) (*User, error) {
+func (db *Database) GetUserByLoginName(ctx context.Context, name string) (*User, error) {
 
 	// The password may be null (which prevents login).
 	const queryTemplate = `
-		SELECT usr_id, usr_uuid, %s(lower(usr_password), ''), usr_valid
+		SELECT usr_id, usr_uuid, %s(usr_password, ''), usr_valid
 		FROM adm_users
 		WHERE lower(usr_login_name) = lower($1);
 	`
@@ -666,7 +656,7 @@ func (db *Database) GetUserByLoginName(name string) (*User, error) {
 	}
 
 	user := NewUser(name)
-	err := db.QueryRow(q, name).Scan(&user.ID, &user.UUID, &user.Password, &user.Valid)
+	err := db.QueryRow(ctx, q, name).Scan(&user.ID, &user.UUID, &user.Password, &user.Valid)
 	if err != nil {
 		return nil, err
 	}
@@ -676,13 +666,13 @@ func (db *Database) GetUserByLoginName(name string) (*User, error) {
 
 // DeleteUser deletes the given user from the database and sets the ID in the object
 // to zero.  It's assumed that a transaction is already set up in the db object.
-func (db *Database) DeleteUser(u *User) error {
+func (db *Database) DeleteUser(ctx context.Context, u *User) error {
 	const q = `
 		DELETE FROM adm_users
 		WHERE usr_id = $1;
 	`
 
-	numRows, err := db.DeleteRow(q, u.ID)
+	numRows, err := db.DeleteRow(ctx, q, u.ID)
 
 	if err != nil {
 		msg := fmt.Sprintf("DeleteUser: error deleting user with ID %d", u.ID)
@@ -712,10 +702,10 @@ func NewUserField(name, nameIntern, fieldType string, user *User, cat *Category)
 
 // CreateUserField creates a user field in the database using the give field data.
 // It's assumed that a transaction is already set up in the db object.
-func (db *Database) CreateUserField(uf *FieldData) error {
+func (db *Database) CreateUserField(ctx context.Context, uf *FieldData) error {
 
 	var uError error
-	uf.UUID, uError = CreateUuid(db.Transaction, "usf_uuid", "adm_user_fields")
+	uf.UUID, uError = db.createUuid(ctx, "usf_uuid", "adm_user_fields")
 	if uError != nil {
 		return uError
 	}
@@ -740,7 +730,7 @@ func (db *Database) CreateUserField(uf *FieldData) error {
 		q = sqliteSQL
 	}
 
-	id, createError := db.CreateRow(
+	id, createError := db.CreateRow(ctx,
 		q, uf.UUID, uf.NameIntern, uf.Name, uf.Type, uf.Sequence, uf.Cat.ID, uf.CreateUser.ID)
 
 	if createError != nil {
@@ -748,13 +738,14 @@ func (db *Database) CreateUserField(uf *FieldData) error {
 	}
 
 	uf.ID = int64(id)
+	db.fieldsAdded = true
 
 	return nil
 }
 
 // GetUserDataFieldByNameIntern gets the user data field with the given internal name.
 // It's assumed that a transaction is already set up in the db object.
-func (db *Database) GetUserDataFieldByNameIntern(name string) (*FieldData, error) {
+func (db *Database) GetUserDataFieldByNameIntern(ctx context.Context, name string) (*FieldData, error) {
 
 	const q = `
 		SELECT usf_id, usf_uuid, usf_name_intern, usf_name, usf_type, usf_sequence, usf_cat_id, usf_usr_id_create
@@ -765,7 +756,7 @@ func (db *Database) GetUserDataFieldByNameIntern(name string) (*FieldData, error
 	var catID, userID int64
 	uf := NewUserField("name to be overwritten", "", "", nil, nil)
 
-	err := db.QueryRow(q, name).Scan(
+	err := db.QueryRow(ctx, q, name).Scan(
 		&uf.ID, &uf.UUID, &uf.NameIntern, &uf.Name, &uf.Type, &uf.Sequence, &catID, &userID)
 	if err != nil {
 		return nil, err
@@ -773,14 +764,14 @@ func (db *Database) GetUserDataFieldByNameIntern(name string) (*FieldData, error
 
 	// Fetch the embedded category.
 	var fetchCatError error
-	uf.Cat, fetchCatError = db.GetCategory(catID)
+	uf.Cat, fetchCatError = db.GetCategory(ctx, catID)
 	if fetchCatError != nil {
 		return nil, fetchCatError
 	}
 
 	// Fetch the embedded user.
 	var ue error
-	uf.CreateUser, ue = db.GetUser(userID)
+	uf.CreateUser, ue = db.GetUser(ctx, userID)
 	if ue != nil {
 		return nil, ue
 	}
@@ -790,7 +781,7 @@ func (db *Database) GetUserDataFieldByNameIntern(name string) (*FieldData, error
 
 // Create creates a MembershipSale record in the database.
 // It's assumed that a transaction is already set up in the db object.
-func (ms *MembershipSale) Create(db *Database) (int64, error) {
+func (ms *MembershipSale) Create(ctx context.Context, db *Database) (int64, error) {
 
 	// Postgres doesn't support LastInsertId.  We use the form recommended here:
 	// https://github.com/jackc/pgx/issues/1483, which is
@@ -857,7 +848,7 @@ func (ms *MembershipSale) Create(db *Database) (int64, error) {
 
 		sql := ms.fixSaleFormCreateStatement(db, sqlTemplate)
 
-		id, createError = db.CreateRow(
+		id, createError = db.CreateRow(ctx,
 			sql,
 			ms.PaymentService,
 			ms.PaymentStatus,
@@ -931,7 +922,7 @@ func (ms *MembershipSale) Create(db *Database) (int64, error) {
 
 		sql := ms.fixSaleFormCreateStatement(db, sqlTemplate)
 
-		id, createError = db.CreateRow(
+		id, createError = db.CreateRow(ctx,
 			sql,
 
 			ms.PaymentService,
@@ -1007,7 +998,7 @@ func (ms *MembershipSale) Create(db *Database) (int64, error) {
 
 		sql := ms.fixSaleFormCreateStatement(db, sqlTemplate)
 
-		id, createError = db.CreateRow(
+		id, createError = db.CreateRow(ctx,
 			sql,
 
 			ms.PaymentService,
@@ -1080,7 +1071,7 @@ func (ms *MembershipSale) Create(db *Database) (int64, error) {
 
 		sql := ms.fixSaleFormCreateStatement(db, sqlTemplate)
 
-		id, createError = db.CreateRow(
+		id, createError = db.CreateRow(ctx,
 			sql,
 
 			ms.PaymentService,
@@ -1145,19 +1136,63 @@ func (ms *MembershipSale) fixSaleFormCreateStatement(db *Database, createStateme
 // GetMembershipSale gets the membership_sale record for the user with
 // the given ID.
 // It's assumed that a transaction is already set up in the db object.
-func (db *Database) GetMembershipSale(id int64) (*MembershipSale, error) {
+func (db *Database) GetMembershipSale(ctx context.Context, id int64) (*MembershipSale, error) {
+
+	query := db.membershipSaleQuery("WHERE ms_id = $1")
+
+	row, searchErr := db.Query(ctx, query, id)
+	if searchErr != nil {
+		return nil, searchErr
+	}
+	defer row.Close()
+
+	if !row.Next() {
+		return nil, errors.New("GetMembershipSale: no matching record")
+	}
+
+	return scanMembershipSale(row)
+}
+
+// GetMembershipSalesOfUser gets the membership_sales records that refer to the
+// given user, as the ordinary or the associate member, newest first.
+func (db *Database) GetMembershipSalesOfUser(ctx context.Context, userID int64) ([]MembershipSale, error) {
+
+	query := db.membershipSaleQuery(
+		"WHERE ms_usr1_id = $1 OR ms_usr2_id = $2 ORDER BY ms_id DESC")
+
+	rows, searchErr := db.Query(ctx, query, userID, userID)
+	if searchErr != nil {
+		return nil, searchErr
+	}
+	defer rows.Close()
+
+	result := make([]MembershipSale, 0)
+	for rows.Next() {
+		ms, scanError := scanMembershipSale(rows)
+		if scanError != nil {
+			return nil, scanError
+		}
+		result = append(result, *ms)
+	}
+
+	return result, rows.Err()
+}
+
+// membershipSaleQuery returns a query that fetches membership_sales records in the
+// form expected by scanMembershipSale, restricted by the given clause.
+func (db *Database) membershipSaleQuery(whereClause string) string {
 
 	// Postgres uses COALESCE to convert NULL to a readable value, SQLite uses IFNULL
 	const queryTemplate = `
-	SELECT 
+	SELECT
 		ms_id,
 		ms_payment_service,
 		ms_payment_status,
 		ms_payment_id,
 		ms_membership_year,
 		ms_transaction_type,
-		%s(ms_usr1_id, 0),
-		%s(ms_usr1_title, ''),
+		%[1]s(ms_usr1_id, 0),
+		%[1]s(ms_usr1_title, ''),
 		ms_usr1_first_name,
 		ms_usr1_last_name,
 
@@ -1168,39 +1203,29 @@ func (db *Database) GetMembershipSale(id int64) (*MembershipSale, error) {
 		ms_donation,
 		ms_donation_museum,
 		ms_giftaid,
-		%s(ms_usr2_id, 0),
-		%s(ms_usr2_title, ''),
-		%s(ms_usr2_first_name, ''),
-		%s(ms_usr2_last_name, ''),
-		%s(ms_usr2_email, ''),
+		%[1]s(ms_usr2_id, 0),
+		%[1]s(ms_usr2_title, ''),
+		%[1]s(ms_usr2_first_name, ''),
+		%[1]s(ms_usr2_last_name, ''),
+		%[1]s(ms_usr2_email, ''),
 		ms_usr2_fee,
 		ms_usr2_friend,
 		ms_usr2_friend_fee
-		
+
 	FROM membership_sales
-	WHERE ms_id = $1;
+	%[2]s;
 `
 
-	var query string
 	switch db.Config.Type {
 	case "postgres":
-		query = fmt.Sprintf(queryTemplate, "COALESCE", "COALESCE", "COALESCE", "COALESCE", "COALESCE", "COALESCE", "COALESCE")
-		// query = fmt.Sprintf(queryTemplate, "COALESCE", "COALESCE", "COALESCE", "COALESCE", "COALESCE", "COALESCE", "COALESCE")
-		// query = fmt.Sprintf(queryTemplate, "COALESCE", "COALESCE", "COALESCE", "COALESCE", "COALESCE")
+		return fmt.Sprintf(queryTemplate, "COALESCE", whereClause)
 	default:
-		query = fmt.Sprintf(queryTemplate, "IFNULL", "IFNULL", "IFNULL", "IFNULL", "IFNULL", "IFNULL", "IFNULL")
-		// query = fmt.Sprintf(queryTemplate, "IFNULL", "IFNULL", "IFNULL", "IFNULL", "IFNULL")
-	}
-
-	row, searchErr := db.Query(query, id)
-	if searchErr != nil {
-		return nil, searchErr
+		return fmt.Sprintf(queryTemplate, "IFNULL", whereClause)
 	}
-	defer row.Close()
+}
 
-	if !row.Next() {
-		return nil, errors.New("GetMembershipSale: no matching record")
-	}
+// scanMembershipSale scans a row produced by a membershipSaleQuery.
+func scanMembershipSale(row *sql.Rows) (*MembershipSale, error) {
 
 	var ms MembershipSale
 
@@ -1238,7 +1263,6 @@ func (db *Database) GetMembershipSale(id int64) (*MembershipSale, error) {
 	}
 
 	return &ms, nil
-
 }
 
 // fixMembershipSaleCreateStatement takes an Sprintf template with three string placeholders
@@ -1265,18 +1289,10 @@ func (ms *MembershipSale) fixMembershipSaleCreateStatement(db *Database, createS
 // Update updates a MembershipSale record in the database from the data supplied
 // in the Membeship.
 // It's assumed that a transaction is already set up in the db object.
-func (ms *MembershipSale) Update(db *Database) error {
-
-	// Booleans are stored in the database as "t" or "f".
-	friend := "f"
-	if ms.Friend {
-		friend = "t"
-	}
+func (ms *MembershipSale) Update(ctx context.Context, db *Database) error {
 
-	giftaid := "f"
-	if ms.Giftaid {
-		giftaid = "t"
-	}
+	friend := db.boolValue(ms.Friend)
+	giftaid := db.boolValue(ms.Giftaid)
 
 	var rowsAffected int64
 	var createError error
@@ -1314,7 +1330,7 @@ func (ms *MembershipSale) Update(db *Database) error {
 			WHERE ms_id=$25;
 		`
 
-	rowsAffected, createError = db.UpdateRow(
+	rowsAffected, createError = db.UpdateRow(ctx,
 		sql,
 		ms.PaymentService,
 		ms.PaymentStatus,
@@ -1361,7 +1377,7 @@ func (ms *MembershipSale) Update(db *Database) error {
 
 // Delete deletes a MembershipSale record in the database.
 // It's assumed that a transaction is already set up in the db object.
-func (ms *MembershipSale) Delete(db *Database) error {
+func (ms *MembershipSale) Delete(ctx context.Context, db *Database) error {
 
 	q := `
 		DELETE FROM MEMBERSHIP_SALES 
@@ -1369,7 +1385,7 @@ func (ms *MembershipSale) Delete(db *Database) error {
 	`
 
 	id := ms.ID
-	numRows, execAndScanError := db.DeleteRow(q, ms.ID)
+	numRows, execAndScanError := db.DeleteRow(ctx, q, ms.ID)
 	if execAndScanError != nil {
 		return execAndScanError
 	}
@@ -1402,21 +1418,33 @@ func NewMember(user *User, role *Role, startTime, endTime time.Time) *Member {
 
 // CreateMember creates a member record from the given member data.
 // It's assumed that a transaction is already set up in the db object.
-func (db *Database) CreateMember(member *Member) error {
+func (db *Database) CreateMember(ctx context.Context, member *Member) error {
 
 	var uError error
-	member.UUID, uError = CreateUuid(db.Transaction, "mem_uuid", "adm_members")
+	member.UUID, uError = db.createUuid(ctx, "mem_uuid", "adm_members")
 	if uError != nil {
 		return uError
 	}
 
 	// Create a member record with the approved flag set.
-	const q = `
+	const postgresSQL = `
 		insert into adm_members(mem_uuid, mem_usr_id, mem_rol_id, mem_begin, mem_end, mem_approved) 
 		values($1, $2, $3, $4, $5, $6) RETURNING mem_id;
 	`
+	const sqliteSQL = `
+		insert into adm_members(mem_uuid, mem_usr_id, mem_rol_id, mem_begin, mem_end, mem_approved) 
+		values(?, ?, ?, ?, ?, ?);
+	`
+
+	var q string
+	switch db.Config.Type {
+	case "postgres":
+		q = postgresSQL
+	default:
+		q = sqliteSQL
+	}
 
-	id, err := db.CreateRow(
+	id, err := db.CreateRow(ctx,
 		q,
 		member.UUID,
 		member.UserID,
@@ -1438,13 +1466,13 @@ func (db *Database) CreateMember(member *Member) error {
 // DeleteMember deletes the given member from the database and sets the ID in the
 // object to zero.
 // It's assumed that a transaction is already set up in the db object.
-func (db *Database) DeleteMember(m *Member) error {
+func (db *Database) DeleteMember(ctx context.Context, m *Member) error {
 	q := `
 		DELETE FROM adm_members
 		WHERE mem_id = $1;
 	`
 
-	got, err := db.DeleteRow(q, m.ID)
+	got, err := db.DeleteRow(ctx, q, m.ID)
 
 	if err != nil {
 		msg := fmt.Sprintf("DeleteMember: error deleting member with ID %d", m.ID)
@@ -1462,7 +1490,7 @@ func (db *Database) DeleteMember(m *Member) error {
 
 // GetMember gets the member with the given ID.
 // It's assumed that a transaction is already set up in the db object.
-func (db *Database) GetMember(id int64) (*Member, error) {
+func (db *Database) GetMember(ctx context.Context, id int64) (*Member, error) {
 	const q = `
 		SELECT mem_id, mem_uuid, mem_rol_id, mem_usr_id, mem_begin, mem_end, mem_approved
 		FROM adm_members
@@ -1472,7 +1500,7 @@ func (db *Database) GetMember(id int64) (*Member, error) {
 	// Create a new member object.  Any values passed will be overwritten by the scan.
 	m := NewMember(nil, nil, time.Now(), time.Now())
 
-	err := db.QueryRow(q, id).Scan(&m.ID, &m.UUID, &m.RoleID, &m.UserID, &m.StartDate, &m.EndDate)
+	err := db.QueryRow(ctx, q, id).Scan(&m.ID, &m.UUID, &m.RoleID, &m.UserID, &m.StartDate, &m.EndDate)
 	if err != nil {
 		return nil, err
 	}
@@ -1481,7 +1509,7 @@ func (db *Database) GetMember(id int64) (*Member, error) {
 }
 
 // GetInterests get the list of interests from adm_members_interest.
-func (db *Database) GetInterests() ([]Interest, error) {
+func (db *Database) GetInterests(ctx context.Context) ([]Interest, error) {
 
 	mis := make([]Interest, 0)
 
@@ -1491,7 +1519,7 @@ func (db *Database) GetInterests() ([]Interest, error) {
 		order by ntrst_id;
 	`
 
-	rows, err := db.Query(q)
+	rows, err := db.Query(ctx, q)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			// The query worked but the table is empty.
@@ -1521,7 +1549,7 @@ func (db *Database) GetInterests() ([]Interest, error) {
 // CreateMembersInterest links a user to an interest if a linkage does not
 // already exist.  If the linkage already exists, the ID will remain set to
 // zero. It's assumed that a transaction is set up in the db object.
-func (db *Database) CreateMembersInterest(mi *MembersInterest) error {
+func (db *Database) CreateMembersInterest(ctx context.Context, mi *MembersInterest) error {
 
 	// Insert the record if not already done (upsert).
 
@@ -1536,20 +1564,45 @@ func (db *Database) CreateMembersInterest(mi *MembersInterest) error {
 		values(?, ?)
 		on conflict(mi_usr_id, mi_interest_id) do nothing;`
 
+	// MySQL ignores the insert if it would break the unique constraint.
+	const qMySQL = `
+	insert ignore into adm_members_interests(mi_usr_id, mi_interest_id)
+		values(?, ?);`
+
 	var q string
 	switch db.Config.Type {
 	case "postgres":
 		q = qPostgres
+	case "mysql":
+		q = qMySQL
 	default:
 		q = qSQLite
 	}
 
-	// If the database is postgres and the unique constraint prevents the insert,
-	// the attempt to get the mi_id will fail and produce a "no rows" error.  That's
-	// expected behaviour.  Leave mi.ID set to zero.
-	id, err := db.CreateRow(q, mi.UserID, mi.InterestID)
+	if db.Config.Type != "postgres" {
+		// If the unique constraint prevents the insert, no row is affected and
+		// there is no ID.  Leave mi.ID set to zero.
+		res, err := db.Exec(ctx, q, mi.UserID, mi.InterestID)
+		if err != nil {
+			return err
+		}
+		n, rowsError := res.RowsAffected()
+		if rowsError != nil {
+			return rowsError
+		}
+		if n == 0 {
+			return nil
+		}
+		mi.ID, err = res.LastInsertId()
+		return err
+	}
+
+	// If the unique constraint prevents the insert, the attempt to get the
+	// mi_id will fail and produce a "no rows" error.  That's expected
+	// behaviour.  Leave mi.ID set to zero.
+	id, err := db.CreateRow(ctx, q, mi.UserID, mi.InterestID)
 	if err != nil {
-		if db.Config.Type == "postgres" && err.Error() == "sql: no rows in result set" {
+		if err == sql.ErrNoRows {
 			return nil
 		}
 		return err
@@ -1562,7 +1615,7 @@ func (db *Database) CreateMembersInterest(mi *MembersInterest) error {
 
 // GetMembersInterestsForUser gets the adm_member_interest rows for the given user.
 // It's assumed that a transaction is already set up.
-func (db *Database) GetMembersInterests(userID int64) ([]MembersInterest, error) {
+func (db *Database) GetMembersInterests(ctx context.Context, userID int64) ([]MembersInterest, error) {
 
 	mis := make([]MembersInterest, 0)
 	// Ordering the result helps testing.
@@ -1573,7 +1626,7 @@ func (db *Database) GetMembersInterests(userID int64) ([]MembersInterest, error)
 		order by mi_id;
 	`
 
-	rows, err := db.Query(q, userID)
+	rows, err := db.Query(ctx, q, userID)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return mis, nil
@@ -1598,9 +1651,23 @@ func (db *Database) GetMembersInterests(userID int64) ([]MembersInterest, error)
 	return mis, nil
 }
 
+// DeleteMembersInterests deletes the adm_members_interests rows for the given
+// user, so that their choice of interests can be replaced.  It's assumed that a
+// transaction is already set up.
+func (db *Database) DeleteMembersInterests(ctx context.Context, userID int64) error {
+
+	const q = `
+		DELETE FROM adm_members_interests
+		WHERE mi_usr_id = $1;
+	`
+
+	_, err := db.DeleteRow(ctx, q, userID)
+	return err
+}
+
 // GetMembersOtherInterestsForUser gets the members other interests for the user with the
 // given ID.  It's assumed that a transaction is already set up in the db object.
-func (db *Database) GetMembersOtherInterests(userID int64) (*MembersOtherInterests, error) {
+func (db *Database) GetMembersOtherInterests(ctx context.Context, userID int64) (*MembersOtherInterests, error) {
 
 	const query = `
 		SELECT moi_id, moi_usr_id, moi_interests
@@ -1610,7 +1677,7 @@ func (db *Database) GetMembersOtherInterests(userID int64) (*MembersOtherInteres
 
 	var moi MembersOtherInterests
 
-	err := db.QueryRow(query, userID).Scan(&moi.ID, &moi.UserID, &moi.Interests)
+	err := db.QueryRow(ctx, query, userID).Scan(&moi.ID, &moi.UserID, &moi.Interests)
 	if err != nil {
 		return nil, err
 	}
@@ -1621,7 +1688,7 @@ func (db *Database) GetMembersOtherInterests(userID int64) (*MembersOtherInteres
 // CreateMembersOtherInterests creates the given user's other interests record.
 //
 //	The function assumes that a transaction is already set up in the db object.
-func (db *Database) CreateMembersOtherInterests(moi *MembersOtherInterests) error {
+func (db *Database) CreateMembersOtherInterests(ctx context.Context, moi *MembersOtherInterests) error {
 
 	// Insert the interests if not already done (upsert).
 	const qPostgres = `
@@ -1645,7 +1712,7 @@ func (db *Database) CreateMembersOtherInterests(moi *MembersOtherInterests) erro
 		q = qSQLite
 	}
 
-	id, createError := db.CreateRow(q, moi.UserID, moi.Interests)
+	id, createError := db.CreateRow(ctx, q, moi.UserID, moi.Interests)
 
 	if createError != nil {
 		return createError
@@ -1657,14 +1724,14 @@ func (db *Database) CreateMembersOtherInterests(moi *MembersOtherInterests) erro
 }
 
 // UpdateMembersOtherInterests updates the given user's other interests.
-func (db *Database) UpdateMembersOtherInterests(moi *MembersOtherInterests) error {
+func (db *Database) UpdateMembersOtherInterests(ctx context.Context, moi *MembersOtherInterests) error {
 	const sql = `
 		UPDATE adm_members_other_interests 
 		SET moi_interests=$1
 		WHERE moi_usr_id=$2;
 	`
 
-	_, updateError := db.Exec(sql, moi.Interests, moi.UserID)
+	_, updateError := db.Exec(ctx, sql, moi.Interests, moi.UserID)
 
 	if updateError != nil {
 		return updateError
@@ -1675,18 +1742,18 @@ func (db *Database) UpdateMembersOtherInterests(moi *MembersOtherInterests) erro
 
 // Update the users other interests or, if a record already exists, update it.
 // The function assumes that a transaction has been set up.
-func (db *Database) UpsertMembersOtherInterests(moi *MembersOtherInterests) error {
-	moiFetched, fetchError := db.GetMembersOtherInterests(moi.UserID)
+func (db *Database) UpsertMembersOtherInterests(ctx context.Context, moi *MembersOtherInterests) error {
+	moiFetched, fetchError := db.GetMembersOtherInterests(ctx, moi.UserID)
 	if fetchError == nil {
 		// There is already a record.  Update it.
 		moiFetched.Interests = moi.Interests
-		err := db.UpdateMembersOtherInterests(moiFetched)
+		err := db.UpdateMembersOtherInterests(ctx, moiFetched)
 		if err != nil {
 			return err
 		}
 	} else {
 		// Thee is no record yet.  Create one.
-		err := db.CreateMembersOtherInterests(moi)
+		err := db.CreateMembersOtherInterests(ctx, moi)
 		if err != nil {
 			return err
 		}
@@ -1698,53 +1765,53 @@ func (db *Database) UpsertMembersOtherInterests(moi *MembersOtherInterests) erro
 
 // CreateUserAndMember creates a user and associated records (member, first name field etc).
 // It's assumed that a transaction is already set up in the db object.
-func (db *Database) CreateUserAndMember(loginName, title, firstName, lastName string, role *Role, startTime, endTime time.Time) (*User, *Member, error) {
+func (db *Database) CreateUserAndMember(ctx context.Context, loginName, title, firstName, lastName string, role *Role, startTime, endTime time.Time) (*User, *Member, error) {
 
 	user := NewUser(loginName)
-	uError := db.CreateUser(user)
+	uError := db.CreateUser(ctx, user)
 	if uError != nil {
 		return nil, nil, uError
 	}
 
-	em, eme := db.GetUserDataFieldIDByNameIntern("EMAIL")
+	em, eme := db.GetUserDataFieldIDByNameIntern(ctx, "EMAIL")
 	if eme != nil {
 		return nil, nil, eme
 	}
-	emError := SetUserDataField(db, em, user.ID, loginName)
+	emError := SetUserDataField(ctx, db, em, user.ID, loginName)
 	if emError != nil {
 		return nil, nil, emError
 	}
 
 	// Set Title (Mr, Ms, Dr etc) - Salutation.
-	it, tne := db.GetUserDataFieldIDByNameIntern("SALUTATION")
+	it, tne := db.GetUserDataFieldIDByNameIntern(ctx, "SALUTATION")
 	if tne != nil {
 		return nil, nil, tne
 	}
-	tError := SetUserDataField(db, it, user.ID, title)
+	tError := SetUserDataField(ctx, db, it, user.ID, title)
 	if tError != nil {
 		return nil, nil, tError
 	}
 
-	ifn, fne := db.GetUserDataFieldIDByNameIntern("FIRST_NAME")
+	ifn, fne := db.GetUserDataFieldIDByNameIntern(ctx, "FIRST_NAME")
 	if fne != nil {
 		return nil, nil, fne
 	}
-	fnError := SetUserDataField(db, ifn, user.ID, firstName)
+	fnError := SetUserDataField(ctx, db, ifn, user.ID, firstName)
 	if fnError != nil {
 		return nil, nil, fnError
 	}
 
 	member := NewMember(user, role, startTime, endTime)
-	mError := db.CreateMember(member)
+	mError := db.CreateMember(ctx, member)
 	if mError != nil {
 		return nil, nil, mError
 	}
 
-	iln, lne := db.GetUserDataFieldIDByNameIntern("LAST_NAME")
+	iln, lne := db.GetUserDataFieldIDByNameIntern(ctx, "LAST_NAME")
 	if lne != nil {
 		return nil, nil, lne
 	}
-	snError := SetUserDataField(db, iln, user.ID, lastName)
+	snError := SetUserDataField(ctx, db, iln, user.ID, lastName)
 	if snError != nil {
 		return nil, nil, snError
 	}
@@ -1752,27 +1819,34 @@ func (db *Database) CreateUserAndMember(loginName, title, firstName, lastName st
 	return user, member, nil
 }
 
-// GetMemberOfUser gets the adm_members record with role 'Member' associated
-// with the user with the given ID.
+// GetMemberOfUser gets the latest adm_members record of the user with the
+// given ID with the role of ordinary or associate members.
 // It's assumed that a transaction is already set up in the db object.
-func (db *Database) GetMemberOfUser(user *User) (*Member, error) {
+func (db *Database) GetMemberOfUser(ctx context.Context, user *User) (*Member, error) {
 
 	// Create a new member object.  Any values passed will be overwritten by the scan.
 	u := NewUser("to be removed")
 	var role Role
 	m := NewMember(u, &role, time.Now(), time.Now())
 
-	const q = `
+	const template = `
 		SELECT m.mem_id, m.mem_uuid, m.mem_rol_id, m.mem_usr_id, 
 			m.mem_begin, m.mem_end, m.mem_approved
 		FROM adm_members as m
-		LEFT JOIN adm_roles as r
+		JOIN adm_roles as r
 			ON r.rol_id = m.mem_rol_id
-			AND r.rol_name = 'Member'
-		WHERE m.mem_usr_id = $1;
+		WHERE m.mem_usr_id = $1
+		AND r.rol_name IN (%s)
+		ORDER BY m.mem_end DESC;
 	`
 
-	err := db.QueryRow(q, user.ID).Scan(&m.ID, &m.UUID, &m.RoleID, &m.UserID, &m.StartDate, &m.EndDate, &m.Approved)
+	args := []any{user.ID}
+	for _, name := range db.Config.RoleNames(MembershipTypeOrdinary, MembershipTypeAssociate) {
+		args = append(args, name)
+	}
+	q := fmt.Sprintf(template, placeholderList(2, len(args)-1))
+
+	err := db.QueryRow(ctx, q, args...).Scan(&m.ID, &m.UUID, &m.RoleID, &m.UserID, &m.StartDate, &m.EndDate, &m.Approved)
 	if err != nil {
 		return nil, err
 	}
@@ -1782,9 +1856,9 @@ func (db *Database) GetMemberOfUser(user *User) (*Member, error) {
 
 // GetMembers gets all of the members.
 // It's assumed that a transaction is already set up in the db object.
-func (db *Database) GetMembers() ([]Member, error) {
+func (db *Database) GetMembers(ctx context.Context) ([]Member, error) {
 
-	role, roleError := db.GetRole("Member")
+	role, roleError := db.GetRole(ctx, "Member")
 	if roleError != nil {
 		return nil, roleError
 	}
@@ -1798,7 +1872,7 @@ func (db *Database) GetMembers() ([]Member, error) {
 
 	members := make([]Member, 0)
 
-	rows, err := db.Query(q)
+	rows, err := db.Query(ctx, q)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return members, nil
@@ -1826,11 +1900,11 @@ func (db *Database) GetMembers() ([]Member, error) {
 
 // CreateAccounts creates accounts for an ordinary member and, if given, for an
 // associate member.  Each account is represented by a record in the adm_users table,
-// a linked record in adm_members with role Member and, if an email address is
-// supplied, a linked record in adm_user_data giving the user's email address (which
-// is required to change their password).  The given membership sale record supplies
-// the data.  It's assumed that the db object contains a transaction.  The ID of the
-// ordinary user is returned.
+// a linked record in adm_members with the role for its type of membership (see
+// DBConfig.RoleName) and, if an email address is supplied, a linked record in
+// adm_user_data giving the user's email address (which is required to change their
+// password).  The given membership sale record supplies the data.  It's assumed that
+// the db object contains a transaction.  The ID of the ordinary user is returned.
 //
 // If two members live at the same address it's quite common for them to both use the
 // same email address or for the associate not to supply an email address but the system
@@ -1842,7 +1916,7 @@ func (db *Database) GetMembers() ([]Member, error) {
 // their password.  Their record just marks that they are a paid-up member.
 //
 // It's assumed that a transaction is already set up in the db object.
-func (db *Database) CreateAccounts(sale *MembershipSale, now time.Time, endTime time.Time) (int64, int64, error) {
+func (db *Database) CreateAccounts(ctx context.Context, sale *MembershipSale, now time.Time, endTime time.Time) (int64, int64, error) {
 
 	// Get the login name(s) from the sale.
 	name, namesError := getLoginNames(sale)
@@ -1852,7 +1926,7 @@ func (db *Database) CreateAccounts(sale *MembershipSale, now time.Time, endTime
 
 	// Create the user for the ordinary account.
 	ordinaryUser := NewUser(name[0])
-	createOrdinaryUserError := db.CreateUser(ordinaryUser)
+	createOrdinaryUserError := db.CreateUser(ctx, ordinaryUser)
 	if createOrdinaryUserError != nil {
 		return 0, 0, createOrdinaryUserError
 	}
@@ -1860,13 +1934,13 @@ func (db *Database) CreateAccounts(sale *MembershipSale, now time.Time, endTime
 	// Update the sale.
 	sale.UserID = ordinaryUser.ID
 
-	roleMember, roleError := db.GetRole("Member")
+	roleMember, roleError := db.GetRole(ctx, db.Config.RoleName(MembershipTypeOrdinary))
 	if roleError != nil {
 		return 0, 0, roleError
 	}
 	// Create the member record for the ordinary account.
 	member := NewMember(ordinaryUser, roleMember, now, endTime)
-	createMemberError := db.CreateMember(member)
+	createMemberError := db.CreateMember(ctx, member)
 	if createMemberError != nil {
 		return 0, 0, createMemberError
 	}
@@ -1878,10 +1952,38 @@ func (db *Database) CreateAccounts(sale *MembershipSale, now time.Time, endTime
 
 	// The sale includes payment for an associate member.  Set up records
 	// for them too.
+	assocUserID, assocError := db.CreateAssocAccount(ctx, sale, now, endTime)
+	if assocError != nil {
+		return 0, 0, assocError
+	}
+
+	return ordinaryUser.ID, assocUserID, nil
+}
+
+// CreateAssocAccount creates the adm_users and adm_members records for the
+// associate member in the given sale and returns the user ID.  It's used when
+// a new associate joins, either with a new ordinary member or with one who is
+// renewing.  It's assumed that a transaction is already set up in the db object.
+func (db *Database) CreateAssocAccount(ctx context.Context, sale *MembershipSale, now time.Time, endTime time.Time) (int64, error) {
+
+	name, namesError := getLoginNames(sale)
+	if namesError != nil {
+		return 0, namesError
+	}
+
+	if len(name) < 2 {
+		return 0, errors.New("CreateAssocAccount: the sale has no associate member")
+	}
+
+	roleMember, roleError := db.GetRole(ctx, db.Config.RoleName(MembershipTypeAssociate))
+	if roleError != nil {
+		return 0, roleError
+	}
+
 	assocUser := NewUser(name[1])
-	createAssocUserError := db.CreateUser(assocUser)
+	createAssocUserError := db.CreateUser(ctx, assocUser)
 	if createAssocUserError != nil {
-		return 0, 0, createAssocUserError
+		return 0, createAssocUserError
 	}
 
 	// Update the sale.
@@ -1889,12 +1991,12 @@ func (db *Database) CreateAccounts(sale *MembershipSale, now time.Time, endTime
 
 	// Create the member record for the associate account.
 	assocMember := NewMember(assocUser, roleMember, now, endTime)
-	createAssocMemberError := db.CreateMember(assocMember)
+	createAssocMemberError := db.CreateMember(ctx, assocMember)
 	if createAssocMemberError != nil {
-		return 0, 0, createAssocMemberError
+		return 0, createAssocMemberError
 	}
 
-	return ordinaryUser.ID, assocUser.ID, nil
+	return assocUser.ID, nil
 }
 
 // getLoginNames creates an returns the ordinary member's login name (their email
@@ -1957,103 +2059,58 @@ func GetMembershipYear(now time.Time) int {
 	return sellingYear
 }
 
-// GetUserIDofMember returns the userID of the user with a matching
-// email address OR matching first name and last name.  The user must
-// have the role Member.  The name and email search is case-insensitive.
+// GetMembershipYearOfUser returns the user's membership year as a four-digit int,
+// for example 2025.  That's the year in which their latest membership of the
+// roles of ordinary and associate members ends.
 // It's assumed that a transaction is already set up in the db object.
-func (db *Database) GetUserIDofMember(firstName, lastName, emailAddress string) (int64, error) {
-
-	// The email address may be an empty string.  The first name and last name should always
-	// be non-empty strings.
-	users := make([]User, 0, 1)
-	var userError error
-	if len(emailAddress) > 0 {
-		users, userError = db.GetUsersByLoginName(emailAddress)
-
-		if userError != nil {
-			return 0, userError
-		}
-	}
-
-	if len(users) > 0 {
-		// Success - found a user by the email address.
-		return users[0].ID, nil
-	}
+func (db *Database) GetMembershipYearOfUser(ctx context.Context, userID int64) (int, error) {
+	var dateStr string
+	var year int
 
-	// We have to look up the user by the first and last names.
-	lastNameID, lastNameIDErr := db.GetUserDataFieldIDByNameIntern("LAST_NAME")
-	if lastNameIDErr != nil {
-		return 0, lastNameIDErr
+	args := []any{userID}
+	for _, name := range db.Config.RoleNames(MembershipTypeOrdinary, MembershipTypeAssociate) {
+		args = append(args, name)
 	}
+	roles := placeholderList(2, len(args)-1)
 
-	firstNameID, firstNameIDErr := db.GetUserDataFieldIDByNameIntern("FIRST_NAME")
-	if firstNameIDErr != nil {
-		return 0, firstNameIDErr
-	}
+	switch db.Config.Type {
+	case "postgres":
 
-	// This queries searches for the member.  It uses lower()
-	// which works in both Postgres and sqlite.
-	const sql = `
-		SELECT users.usr_id as id
-        FROM adm_users as users
-        LEFT JOIN adm_members as members
-        ON users.usr_id = members.mem_usr_id
-        LEFT JOIN adm_roles as roles
-			ON roles.rol_id = members.mem_rol_id
-			AND roles.rol_name = 'Member'
-		LEFT JOIN adm_user_data AS firstName
-			ON firstName.usd_usr_id = users.usr_id
-			AND firstName.usd_usf_id = $1
-		LEFT JOIN adm_user_data AS lastName
-			ON lastName.usd_usr_id = users.usr_id
-			AND lastName.usd_usf_id = $2
-		WHERE (
-				lower(firstName.usd_value) = lower($3) 
-			AND lower(lastName.usd_value) = lower($4)
-		);
+		// The postgres to_char function does most of the work.
+		const sqlForPostgres = `
+			SELECT to_char(m.mem_end, 'YYYY')
+			FROM adm_members AS m
+			JOIN adm_roles as r
+				ON r.rol_id = m.mem_rol_id
+			WHERE m.mem_usr_id = $1
+			AND r.rol_name IN (%s)
+			ORDER BY m.mem_end DESC
 		`
 
-	rows, searchErr := db.Query(sql, firstNameID, lastNameID, firstName, lastName)
-	if searchErr != nil {
-		return 0, searchErr
-	}
-	defer rows.Close()
-
-	if !rows.Next() {
-		// No such member - a new member is registering.
-		return 0, nil
-	}
-
-	var id int64
-	err := rows.Scan(&id)
-	if err != nil {
-		return 0, err
-	}
-	rows.Close()
-	return id, nil
-}
+		query := fmt.Sprintf(sqlForPostgres, roles)
+		getYearError := db.QueryRow(ctx, query, args...).Scan(&year)
 
-// GetMembershipYearOfUser returns the user's membership year as a four-digit int,
-// for example 2025.
-// It's assumed that a transaction is already set up in the db object.
-func (db *Database) GetMembershipYearOfUser(userID int64) (int, error) {
-	var dateStr string
-	var year int
+		if getYearError != nil {
+			return 0, getYearError
+		}
 
-	if db.Config.Type == "sqlite" {
+	default:
 
 		// SQLite stores dates as string, int or float.  We use strings
-		// in the format "YYYY-MM-DD HH:MM:SS.SSS"
+		// in the format "YYYY-MM-DD HH:MM:SS.SSS".  MySQL gives dates
+		// as strings in the form "YYYY-MM-DD".
 		const sqlForSQLite = `
 			SELECT m.mem_end
 			FROM adm_members AS m
-			LEFT JOIN adm_roles as r
+			JOIN adm_roles as r
 				ON r.rol_id = m.mem_rol_id
-				AND r.rol_name = 'Member'
-			WHERE m.mem_usr_id = ?
+			WHERE m.mem_usr_id = $1
+			AND r.rol_name IN (%s)
+			ORDER BY m.mem_end DESC
 		`
 
-		getDateError := db.QueryRow(sqlForSQLite, userID).
+		query := fmt.Sprintf(sqlForSQLite, roles)
+		getDateError := db.QueryRow(ctx, query, args...).
 			Scan(&dateStr)
 
 		if getDateError != nil {
@@ -2081,70 +2138,155 @@ func (db *Database) GetMembershipYearOfUser(userID int64) (int, error) {
 		if formatError != nil {
 			return 0, errors.New("illegal year " + yearStr)
 		}
+	}
 
-	} else {
+	return year, nil
+}
 
-		// The postgres to_char function does most of the work.
-		const sqlForPostgres = `
-			SELECT to_char(m.mem_end, 'YYYY')
-			FROM adm_members AS m
-			LEFT JOIN adm_roles as r
-				ON r.rol_id = m.mem_rol_id
-				AND r.rol_name = 'Member'
-			WHERE m.mem_usr_id = $1
-		`
+// SetMemberEndDate sets the end date of a member to the end of the current membership year.
+// It's intended use is to allow an admin to revive a user account when the user renews their
+// membership manually, eg using a paper form and a cheque.  It sets the end date of the
+// user's membership of the roles of ordinary and associate members (see
+// DBConfig.RoleName).  It's assumed that a transaction is already set up in the db object.
+func (db *Database) SetMemberEndDate(ctx context.Context, userID int64, year int) error {
+	roleNames := db.Config.RoleNames(MembershipTypeOrdinary, MembershipTypeAssociate)
+	_, err := db.setRoleEndDate(ctx, userID, roleNames, year)
+	return err
+}
+
+// RenewMemberships gives the members in the given sale the memberships that
+// they have paid for until the end of the sale's membership year: ordinary or
+// associate membership and, if they are friends of the museum, friendship (see
+// RenewMembership).  A new membership starts at the given time.
+// It's assumed that a transaction is already set up in the db object.
+func (db *Database) RenewMemberships(ctx context.Context, sale *MembershipSale, start time.Time) error {
 
-		getYearError := db.QueryRow(sqlForPostgres, userID).Scan(&year)
+	type renewal struct {
+		userID         int64
+		membershipType string
+	}
 
-		if getYearError != nil {
-			return 0, getYearError
+	renewals := []renewal{{sale.UserID, MembershipTypeOrdinary}}
+	if sale.Friend {
+		renewals = append(renewals, renewal{sale.UserID, MembershipTypeFriend})
+	}
+	if sale.AssocUserID > 0 {
+		renewals = append(renewals, renewal{sale.AssocUserID, MembershipTypeAssociate})
+		if sale.AssocFriend {
+			renewals = append(renewals, renewal{sale.AssocUserID, MembershipTypeFriend})
 		}
 	}
 
-	return year, nil
+	for _, r := range renewals {
+		if r.userID <= 0 {
+			continue
+		}
+		err := db.RenewMembership(ctx, r.userID, r.membershipType, start, sale.MembershipYear)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Success!
+	return nil
 }
 
-// SetMemberEndDate sets the end date of a member to the end of the current membership year.
-// It's intended use is to allow an admin to revive a user account when the user renews their
-// membership manually, eg using a paper form and a cheque.  There's a facility on the website
-// that calls this function.
-// The function returns an error if the user does not exist or has no member record with role
-// 'Member'.  It's assumed that a transaction is already set up in the db object.
-func (db *Database) SetMemberEndDate(userID int64, year int) error {
+// RenewMembership gives the user membership of the role for the given type of
+// membership (see DBConfig.RoleName) until the end of the given year.  If they
+// already have a membership of that role, its end date is set, otherwise a
+// membership starting at the given time is created.  If the type of membership
+// has no role it does nothing.  A user who doesn't renew (for example a member
+// who is no longer a friend of the museum) keeps their old membership, which
+// has ended.
+// It's assumed that a transaction is already set up in the db object.
+func (db *Database) RenewMembership(ctx context.Context, userID int64, membershipType string, start time.Time, year int) error {
+
+	const fn = "RenewMembership"
+
+	roleName := db.Config.RoleName(membershipType)
+	if len(roleName) == 0 {
+		return nil
+	}
+
+	n, setError := db.setRoleEndDate(ctx, userID, []string{roleName}, year)
+	if setError != nil {
+		return setError
+	}
+
+	if n > 0 {
+		// The user already had the role.
+		return nil
+	}
+
+	role, roleError := db.GetRole(ctx, roleName)
+	if roleError != nil {
+		em := fmt.Sprintf("%s: role %q: %v", fn, roleName, roleError)
+		return errors.New(em)
+	}
+
+	end := time.Date(year, time.December, 31, 0, 0, 0, 0, start.Location())
+	member := NewMember(&User{ID: userID}, role, start, end)
+	createError := db.CreateMember(ctx, member)
+	if createError != nil {
+		em := fmt.Sprintf("%s: %v", fn, createError)
+		return errors.New(em)
+	}
+
+	logError := db.logChanges(ctx, userID,
+		change{item: "mem_begin", newValue: member.StartDate},
+		change{item: "mem_end", newValue: member.EndDate})
+	if logError != nil {
+		em := fmt.Sprintf("%s: %v", fn, logError)
+		return errors.New(em)
+	}
+
+	// Success!
+	return nil
+}
+
+// setRoleEndDate sets the end date of the user's memberships of the roles with
+// the given names to the end of the given year and returns the number of
+// memberships that it changed.
+// It's assumed that a transaction is already set up in the db object.
+func (db *Database) setRoleEndDate(ctx context.Context, userID int64, roleNames []string, year int) (int, error) {
 
 	// This query gets the member ID, start and end date of a member, given their user id.
 	// A user with many roles has many adm_members records, one per role (admin, member etc).
-	// We need the one with role "Member".
+	// We need the ones with the given roles.
 
-	const funcName = "Database.SetMemberEndDate"
+	const funcName = "Database.setRoleEndDate"
+
+	if len(roleNames) == 0 {
+		return 0, nil
+	}
 
-	const getMemberIDSQL = `
-		SELECT m.mem_id
+	const getMemberIDTemplate = `
+		SELECT m.mem_id, m.mem_end
 		FROM adm_members AS m
 		LEFT JOIN adm_users AS u
 			ON m.mem_usr_id=u.usr_id
 		LEFT JOIN adm_roles as r
 			ON r.rol_id = m.mem_rol_id
-		WHERE r.rol_name = 'Member'
+		WHERE r.rol_name IN (%s)
 		AND u.usr_id = $1;
 	`
 
-	// If everything is working properly we should only get exactly
-	// one result, the ID of the user's member record with role Member.
-	rows, getMemberIDError := db.Query(getMemberIDSQL, userID)
+	args := []any{userID}
+	for _, name := range roleNames {
+		args = append(args, name)
+	}
+	getMemberIDSQL := fmt.Sprintf(getMemberIDTemplate, placeholderList(2, len(roleNames)))
+
+	// If everything is working properly we should get at most one result
+	// for each role.
+	rows, getMemberIDError := db.Query(ctx, getMemberIDSQL, args...)
 	if getMemberIDError != nil {
-		if getMemberIDError == sql.ErrNoRows {
-			em := fmt.Sprintf("%s: no member for user %d",
-				funcName, userID)
-			return errors.New(em)
-		}
 		em := fmt.Sprintf("%s %v", funcName, getMemberIDError)
-		return errors.New(em)
+		return 0, errors.New(em)
 	}
 	defer rows.Close()
 
-	// The user has a member record with 'Member' role.  Set the
-	// end date, for example "2024-12-31 23:59:59 999999 +00".
+	// Set the end date, for example "2024-12-31 23:59:59 999999 +00".
 	// That's the last microsecond of the last second of the year
 	// in UTC.  It's safe to use this form for dates when we are
 	// in GMT, but not for dates during BST.  We are setting dates
@@ -2154,16 +2296,19 @@ func (db *Database) SetMemberEndDate(userID int64, year int) error {
 	// the end date in all of them
 
 	ids := make([]int, 0)
+	oldEndDates := make([]string, 0)
 	for {
 		if !rows.Next() {
 			break
 		}
 		var id int
-		err := rows.Scan(&id)
+		var oldEnd any
+		err := rows.Scan(&id, &oldEnd)
 		if err != nil {
-			return errors.New(funcName + err.Error())
+			return 0, errors.New(funcName + err.Error())
 		}
 		ids = append(ids, id)
+		oldEndDates = append(oldEndDates, dateOf(oldEnd))
 	}
 
 	// We must close the rows before we run another query.
@@ -2171,70 +2316,106 @@ func (db *Database) SetMemberEndDate(userID int64, year int) error {
 
 	endDate := fmt.Sprintf("%04d-12-31 23:59:59 999999 +00", year)
 
-	for _, id := range ids {
+	// The changes are attributed to the system user.
+	systemUserID, systemUserError := db.SystemUserID(ctx)
+	if systemUserError != nil {
+		em := fmt.Sprintf("%s: %v", funcName, systemUserError)
+		return 0, errors.New(em)
+	}
+
+	changes := make([]change, 0, len(ids))
+
+	for i, id := range ids {
 
 		var updateSQL string
 
-		if db.Config.Type == "sqlite" {
+		switch db.Config.Type {
+		case "postgres":
+
+			// Postgres has a format for timestamps and a converter function
+			// to turn a string into a timestamp.
+			updateSQL = `
+				UPDATE adm_members
+				SET mem_end = to_timestamp($1, 'YYYY-MM-DD HH24:MI:SS US TZH'),
+					mem_usr_id_change = $2, mem_timestamp_change = CURRENT_TIMESTAMP
+				WHERE mem_id =$3;
+			`
+
+		case "mysql":
 
-			// SQLite has no special date or timestamp format.  We store
-			// timestamps as strings in the format "YYYY-MM-DD HH:MM:SS.SSS".
-			// It supports rowsAffected.
+			// In MySQL mem_end is a date.  It rejects the time part of the
+			// string in strict mode.
+			endDate = fmt.Sprintf("%04d-12-31", year)
 			updateSQL = `
 				UPDATE adm_members
-				SET mem_end = ?
+				SET mem_end = ?,
+					mem_usr_id_change = ?, mem_timestamp_change = CURRENT_TIMESTAMP
 				WHERE mem_id =?;
 			`
 
-		} else {
+		default:
 
-			// Postgres has a format for timestamps and a converter function
-			// to turn a string into a timestamp.  It doesn't support
-			// rowsAffected, so we use RETURNING.
+			// SQLite has no special date or timestamp format.  We store
+			// timestamps as strings in the format "YYYY-MM-DD HH:MM:SS.SSS".
 			updateSQL = `
 				UPDATE adm_members
-				SET mem_end = to_timestamp($1, 'YYYY-MM-DD HH24:MI:SS US TZH')
-				WHERE mem_id =$2
-				RETURNING mem_id;
+				SET mem_end = ?,
+					mem_usr_id_change = ?, mem_timestamp_change = CURRENT_TIMESTAMP
+				WHERE mem_id =?;
 			`
 		}
 
-		returnedID, setDateError := db.CreateRow(updateSQL, endDate, id)
+		rowsUpdated, setDateError := db.UpdateRow(ctx, updateSQL, endDate, systemUserID, id)
 
 		if setDateError != nil {
 			em := fmt.Sprintf("%s: %v", funcName, setDateError)
-			return errors.New(em)
+			return 0, errors.New(em)
 		}
 
-		if returnedID == 0 {
-			em := fmt.Sprintf("%s: ID zero returned updating ID %d", funcName, id)
-			return errors.New(em)
+		if rowsUpdated == 0 {
+			em := fmt.Sprintf("%s: no rows updated for ID %d", funcName, id)
+			return 0, errors.New(em)
 		}
+
+		changes = append(changes,
+			change{item: "mem_end", oldValue: oldEndDates[i], newValue: fmt.Sprintf("%04d-12-31", year)})
+	}
+
+	logError := db.logChanges(ctx, userID, changes...)
+	if logError != nil {
+		em := fmt.Sprintf("%s: %v", funcName, logError)
+		return 0, errors.New(em)
 	}
 
 	// Success!
-	return nil
+	return len(ids), nil
 }
 
-// GetUserDataFieldIDByNameIntern gets the row from adm_user_fields with
-// the given internal name.
+// GetUserDataFieldIDByNameIntern gets the ID of the row from adm_user_fields
+// with the given internal name.  The IDs are cached (see fieldIDCache).
 // It's assumed that a transaction is already set up in the db object.
-func (db *Database) GetUserDataFieldIDByNameIntern(nameIntern string) (int64, error) {
+func (db *Database) GetUserDataFieldIDByNameIntern(ctx context.Context, nameIntern string) (int64, error) {
 
 	f := "GetUserDataFieldIDByNameIntern: "
 
+	if id, found := db.fieldIDs.get(nameIntern); found {
+		return id, nil
+	}
+
 	const q = `
 		SELECT usf_id
 		FROM adm_user_fields
 		WHERE usf_name_intern = $1
 	`
 	var fieldID int64
-	err := db.QueryRow(q, nameIntern).Scan(&fieldID)
+	err := db.QueryRow(ctx, q, nameIntern).Scan(&fieldID)
 	if err != nil {
 		em := fmt.Sprintf("%s: %v - %s", f, err, nameIntern)
 		return 0, errors.New(em)
 	}
 
+	db.fieldIDs.put(nameIntern, fieldID)
+
 	return fieldID, nil
 }
 
@@ -2243,12 +2424,12 @@ func (db *Database) GetUserDataFieldIDByNameIntern(nameIntern string) (int64, er
 // zero value of the return type (an empty string, a zero or false).  (To detect no row,
 // use GetUserDataFieldErrorOnNotFound().)
 // It's assumed that a transaction is already set up in the db object.
-func GetUserDataField[T int | float64 | bool | string](db *Database, fieldID, userID int64) (T, error) {
+func GetUserDataField[T int | float64 | bool | string](ctx context.Context, db *Database, fieldID, userID int64) (T, error) {
 
 	var result T
 	var zero T // The zero value of the type T.
 	var err error
-	result, err = GetUserDataFieldErrorOnNotFound[T](db, fieldID, userID)
+	result, err = GetUserDataFieldErrorOnNotFound[T](ctx, db, fieldID, userID)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			// The query worked but found no rows.  This is correct behaviour.
@@ -2269,7 +2450,7 @@ func GetUserDataField[T int | float64 | bool | string](db *Database, fieldID, us
 //
 // sql.ErrNoRows error.
 // It's assumed that a transaction is already set up in the db object.
-func GetUserDataFieldErrorOnNotFound[T int | float64 | bool | string](db *Database, fieldID, userID int64) (T, error) {
+func GetUserDataFieldErrorOnNotFound[T int | float64 | bool | string](ctx context.Context, db *Database, fieldID, userID int64) (T, error) {
 
 	const q = `
 		SELECT usd_value
@@ -2279,7 +2460,7 @@ func GetUserDataFieldErrorOnNotFound[T int | float64 | bool | string](db *Databa
 	`
 	var zero T
 	var result T
-	queryError := db.QueryRow(q, userID, fieldID).Scan(&result)
+	queryError := db.QueryRow(ctx, q, userID, fieldID).Scan(&result)
 	if queryError != nil {
 		// Return the zero value and the error.
 		return zero, queryError
@@ -2289,678 +2470,30 @@ func GetUserDataFieldErrorOnNotFound[T int | float64 | bool | string](db *Databa
 	return result, nil
 }
 
-// SetDataProtectionField sets the DATA_PROTECTION_PERMISSION field for the user in
-// adm_user_data.  In the DB, tick box fields are set to 0 or 1.
-func (db *Database) SetDataProtectionField(userID int64, ticked bool) error {
-
-	fieldID, fieldError := db.GetUserDataFieldIDByNameIntern(DataStoragePermNameIntern)
-	if fieldError != nil {
-		return fieldError
-	}
-	// If the member consents to giftaid, fill in the box.  In case
-	// it's already set from last year but not this year, ensure that
-	// the value in the DB record is reset.
-	if ticked {
-		return SetUserDataField(db, fieldID, userID, 1)
-	} else {
-		return SetUserDataField(db, fieldID, userID, 0)
-	}
-}
-
-// GetDataProtectionField gets the DATA_PROTECTION_PERMISSION field for the user from
-// adm_user_data.  In the DB, Tick box fields are set to 0 or 1.
-func (db *Database) GetDataProtectionField(userID int64) (bool, error) {
-	fieldID, fieldError := db.GetUserDataFieldIDByNameIntern(DataStoragePermNameIntern)
-	if fieldError != nil {
-		em := fmt.Sprintf("GetDataProtectionField: %v", fieldError)
-		return false, errors.New(em)
-	}
-
-	var fetchedValue int
-	fetchedValue, fetchError := GetUserDataField[int](db, fieldID, userID)
-	if fetchError != nil {
-		return false, fetchError
-	}
-
-	// 0 is false, any other value is true
-	if fetchedValue == 0 {
-		return false, nil
-	} else {
-		return true, nil
-	}
-}
-
-// SetReceiveEmailField sets the notices by email field for the user in
-// adm_user_data.  In the DB, tick box fields are set to 0 or 1.
-func (db *Database) SetReceiveEmailField(userID int64, ticked bool) error {
-
-	fieldID, fieldError := db.GetUserDataFieldIDByNameIntern("NOTICES_BY_EMAIL")
-	if fieldError != nil {
-		return fieldError
-	}
-	// If the member consents to giftaid, fill in the box.  In case
-	// it's already set from last year but not this year, ensure that
-	// the value in the DB record is reset.
-	if ticked {
-		return SetUserDataField(db, fieldID, userID, 1)
-	} else {
-		return SetUserDataField(db, fieldID, userID, 0)
-	}
-}
-
-// GetNoticesByEmailField gets the notices by email field for the user from
-// adm_user_data.  In the DB, Tick box fields are set to 0 or 1.
-func (db *Database) GetReceiveEmailField(userID int64) (bool, error) {
-	fieldID, fieldError := db.GetUserDataFieldIDByNameIntern("NOTICES_BY_EMAIL")
-	if fieldError != nil {
-		em := fmt.Sprintf("GetReceiveEmailField: %v", fieldError)
-		return false, errors.New(em)
-	}
-
-	var fetchedValue int
-	fetchedValue, fetchError := GetUserDataField[int](db, fieldID, userID)
-	if fetchError != nil {
-		return false, fetchError
-	}
-
-	// 0 is false, any other value is true
-	if fetchedValue == 0 {
-		return false, nil
-	} else {
-		return true, nil
-	}
-}
-
-// SetTitle sets the user's title.
-// It's assumed that a transaction is already set up in the db object.
-func (db *Database) SetTitle(userID int64, val string) error {
-	fieldID, fieldError := db.GetUserDataFieldIDByNameIntern("SALUTATION")
-	if fieldError != nil {
-		return fieldError
-	}
-
-	return SetUserDataField(db, fieldID, userID, val)
-}
-
-// GetTitle gets the user's title.
-// It's assumed that a transaction is already set up in the db object.
-func (db *Database) GetTitle(userID int64) (string, error) {
-	fieldID, fieldError := db.GetUserDataFieldIDByNameIntern("SALUTATION")
-	if fieldError != nil {
-		return "", fieldError
-	}
-
-	return GetUserDataField[string](db, fieldID, userID)
-}
-
-// SetFirstName sets the user's last name.
-// It's assumed that a transaction is already set up in the db object.
-func (db *Database) SetFirstName(userID int64, val string) error {
-	fieldID, fieldError := db.GetUserDataFieldIDByNameIntern("FIRST_NAME")
-	if fieldError != nil {
-		return fieldError
-	}
-
-	return SetUserDataField(db, fieldID, userID, val)
-}
-
-// GetFirstName gets the user's last name.
-// It's assumed that a transaction is already set up in the db object.
-func (db *Database) GetFirstName(userID int64) (string, error) {
-	fieldID, fieldError := db.GetUserDataFieldIDByNameIntern("FIRST_NAME")
-	if fieldError != nil {
-		return "", fieldError
-	}
-
-	return GetUserDataField[string](db, fieldID, userID)
-}
-
-// SetLastName sets the user's first name.
-// It's assumed that a transaction is already set up in the db object.
-func (db *Database) SetLastName(userID int64, val string) error {
-	fieldID, fieldError := db.GetUserDataFieldIDByNameIntern("LAST_NAME")
-	if fieldError != nil {
-		return fieldError
-	}
-
-	return SetUserDataField(db, fieldID, userID, val)
-}
-
-// GetLastName gets the user's last name.
-// It's assumed that a transaction is already set up in the db object.
-func (db *Database) GetLastName(userID int64) (string, error) {
-	fieldID, fieldError := db.GetUserDataFieldIDByNameIntern("LAST_NAME")
-	if fieldError != nil {
-		return "", fieldError
-	}
-
-	return GetUserDataField[string](db, fieldID, userID)
-}
-
-// SetEmail sets the user's email address in adm_user_data.
-// It's assumed that a transaction is already set up in the db object.
-func (db *Database) SetEmail(userID int64, val string) error {
-	fieldID, fieldError := db.GetUserDataFieldIDByNameIntern("EMAIL")
-	if fieldError != nil {
-		return fieldError
-	}
-
-	return SetUserDataField(db, fieldID, userID, val)
-}
-
-// GetEmail gets the user's Email address from adm_user_data.
-// It's assumed that a transaction is already set up in the db object.
-func (db *Database) GetEmail(userID int64) (string, error) {
-	fieldID, fieldError := db.GetUserDataFieldIDByNameIntern("EMAIL")
-	if fieldError != nil {
-		return "", fieldError
-	}
-
-	return GetUserDataField[string](db, fieldID, userID)
-}
-
-// SetAddressline1 sets the first line of the user's address.
-// It's assumed that a transaction is already set up in the db object.
-func (db *Database) SetAddressLine1(userID int64, val string) error {
-	fieldID, fieldError := db.GetUserDataFieldIDByNameIntern("STREET")
-	if fieldError != nil {
-		return fieldError
-	}
-
-	return SetUserDataField(db, fieldID, userID, val)
-}
-
-// GetAddressline1 gets the first line of the user's address.
-// It's assumed that a transaction is already set up in the db object.
-func (db *Database) GetAddressLine1(userID int64) (string, error) {
-	fieldID, fieldError := db.GetUserDataFieldIDByNameIntern("STREET")
-	if fieldError != nil {
-		return "", fieldError
-	}
-
-	return GetUserDataField[string](db, fieldID, userID)
-}
-
-// SetAddressLine2 sets the second line of the user's address.
-// It's assumed that a transaction is already set up in the db object.
-func (db *Database) SetAddressLine2(userID int64, val string) error {
-	fieldID, fieldError := db.GetUserDataFieldIDByNameIntern("ADDRESS_LINE_2")
-	if fieldError != nil {
-		return fieldError
-	}
-
-	return SetUserDataField(db, fieldID, userID, val)
-}
-
-// GetAddressline2 gets the second line of the user's address.
-// It's assumed that a transaction is already set up in the db object.
-func (db *Database) GetAddressLine2(userID int64) (string, error) {
-	fieldID, fieldError := db.GetUserDataFieldIDByNameIntern("ADDRESS_LINE_2")
-	if fieldError != nil {
-		return "", fieldError
-	}
-
-	return GetUserDataField[string](db, fieldID, userID)
-}
-
-// SetAddressLine3 sets the third line of the user's address.
-// It's assumed that a transaction is already set up in the db object.
-func (db *Database) SetAddressLine3(userID int64, val string) error {
-	fieldID, fieldError := db.GetUserDataFieldIDByNameIntern("ADDRESS_LINE_3")
-	if fieldError != nil {
-		return fieldError
-	}
-
-	return SetUserDataField(db, fieldID, userID, val)
-}
-
-// GetAddressline3 gets the third line of the user's address.
-// It's assumed that a transaction is already set up in the db object.
-func (db *Database) GetAddressLine3(userID int64) (string, error) {
-	fieldID, fieldError := db.GetUserDataFieldIDByNameIntern("ADDRESS_LINE_3")
-	if fieldError != nil {
-		return "", fieldError
-	}
-
-	return GetUserDataField[string](db, fieldID, userID)
-}
-
-// SetTown sets the town in the user's address.
-// It's assumed that a transaction is already set up in the db object.
-func (db *Database) SetTown(userID int64, val string) error {
-	fieldID, fieldError := db.GetUserDataFieldIDByNameIntern("CITY")
-	if fieldError != nil {
-		return fieldError
-	}
-
-	return SetUserDataField(db, fieldID, userID, val)
-}
-
-// GetTown gets the second line of the user's address.
-// It's assumed that a transaction is already set up in the db object.
-func (db *Database) GetTown(userID int64) (string, error) {
-	fieldID, fieldError := db.GetUserDataFieldIDByNameIntern("CITY")
-	if fieldError != nil {
-		return "", fieldError
-	}
-
-	return GetUserDataField[string](db, fieldID, userID)
-}
-
-// SetCounty sets the county in the user's address.
-// It's assumed that a transaction is already set up in the db object.
-func (db *Database) SetCounty(userID int64, val string) error {
-	fieldID, fieldError := db.GetUserDataFieldIDByNameIntern("COUNTY")
-	if fieldError != nil {
-		return fieldError
-	}
-
-	return SetUserDataField(db, fieldID, userID, val)
-}
-
-// GetCounty gets the second line of the user's address.
-// It's assumed that a transaction is already set up in the db object.
-func (db *Database) GetCounty(userID int64) (string, error) {
-	fieldID, fieldError := db.GetUserDataFieldIDByNameIntern("COUNTY")
-	if fieldError != nil {
-		return "", fieldError
-	}
-
-	return GetUserDataField[string](db, fieldID, userID)
-}
-
-// SetPostcode sets the postcode in the user's address.
-// It's assumed that a transaction is already set up in the db object.
-func (db *Database) SetPostcode(userID int64, val string) error {
-	fieldID, fieldError := db.GetUserDataFieldIDByNameIntern("POSTCODE")
-	if fieldError != nil {
-		return fieldError
-	}
-
-	return SetUserDataField(db, fieldID, userID, val)
-}
-
-// GetPostcode gets the postcode in the user's address.
-// It's assumed that a transaction is already set up in the db object.
-func (db *Database) GetPostcode(userID int64) (string, error) {
-	fieldID, fieldError := db.GetUserDataFieldIDByNameIntern("POSTCODE")
-	if fieldError != nil {
-		return "", fieldError
-	}
-
-	v, e := GetUserDataField[string](db, fieldID, userID)
-	if e != nil {
-		return "", e
-	}
-
-	return v, nil
-}
-
-// SetCountryCode sets the country in the user's address.
-// It's assumed that a transaction is already set up in the db object.
-func (db *Database) SetCountryCode(userID int64, val string) error {
-	fieldID, fieldError := db.GetUserDataFieldIDByNameIntern("COUNTRY")
-	if fieldError != nil {
-		return fieldError
-	}
-
-	return SetUserDataField(db, fieldID, userID, val)
-}
-
-// GetCountryCode gets the country in the user's address.
-// It's assumed that a transaction is already set up in the db object.
-func (db *Database) GetCountryCode(userID int64) (string, error) {
-	fieldID, fieldError := db.GetUserDataFieldIDByNameIntern("COUNTRY")
-	if fieldError != nil {
-		return "", fieldError
-	}
-
-	v, e := GetUserDataField[string](db, fieldID, userID)
-	if e != nil {
-		return "", e
-	}
-
-	return v, nil
-}
-
-// SetCountry sets the country in the user's address.
-// It's assumed that a transaction is already set up in the db object.
-func (db *Database) SetCountry(userID int64, val string) error {
-	fieldID, fieldError := db.GetUserDataFieldIDByNameIntern("COUNTRY")
-	if fieldError != nil {
-		return fieldError
-	}
-
-	return SetUserDataField(db, fieldID, userID, val)
-}
-
-// GetCountry gets the country in the user's address.
-// It's assumed that a transaction is already set up in the db object.
-func (db *Database) GetCountry(userID int64) (string, error) {
-	fieldID, fieldError := db.GetUserDataFieldIDByNameIntern("COUNTRY")
-	if fieldError != nil {
-		return "", fieldError
-	}
-
-	v, e := GetUserDataField[string](db, fieldID, userID)
-	if e != nil {
-		return "", e
-	}
-
-	return v, nil
-}
-
-// SetPhone sets the user's landline phone number in their profile.
-// It's assumed that a transaction is already set up in the db object.
-func (db *Database) SetPhone(userID int64, val string) error {
-	fieldID, fieldError := db.GetUserDataFieldIDByNameIntern("PHONE")
-	if fieldError != nil {
-		return fieldError
-	}
-
-	return SetUserDataField(db, fieldID, userID, val)
-}
-
-// GetPhone gets the landline phone number from the user's recvords.
-// It's assumed that a transaction is already set up in the db object.
-func (db *Database) GetPhone(userID int64) (string, error) {
-	fieldID, fieldError := db.GetUserDataFieldIDByNameIntern("PHONE")
-	if fieldError != nil {
-		return "", fieldError
-	}
-
-	v, e := GetUserDataField[string](db, fieldID, userID)
-	if e != nil {
-		return "", e
-	}
-
-	return v, nil
-}
-
-// SetMobile sets the user's mobile phone number in their profile.
-// It's assumed that a transaction is already set up in the db object.
-func (db *Database) SetMobile(userID int64, val string) error {
-	fieldID, fieldError := db.GetUserDataFieldIDByNameIntern("MOBILE")
-	if fieldError != nil {
-		return fieldError
-	}
-
-	return SetUserDataField(db, fieldID, userID, val)
-}
-
-// GetMobile gets the mobile phone number from the user's profile.
-// It's assumed that a transaction is already set up in the db object.
-func (db *Database) GetMobile(userID int64) (string, error) {
-	fieldID, fieldError := db.GetUserDataFieldIDByNameIntern("MOBILE")
-	if fieldError != nil {
-		return "", fieldError
-	}
-
-	v, e := GetUserDataField[string](db, fieldID, userID)
-	if e != nil {
-		return "", e
-	}
-
-	return v, nil
-}
-
-// SetLocationOfInterest sets the user's location of interest (parish) in their profile.
-// It's assumed that a transaction is already set up in the db object.
-func (db *Database) SetLocationOfInterest(userID int64, val string) error {
-	fieldID, fieldError := db.GetUserDataFieldIDByNameIntern("LOCATION_OF_INTEREST")
-	if fieldError != nil {
-		return fieldError
-	}
-
-	return SetUserDataField(db, fieldID, userID, val)
-}
-
-// GetLocationOfInterest gets the mobile phone number from the user's profile.
-// It's assumed that a transaction is already set up in the db object.
-func (db *Database) GetLocationOfInterest(userID int64) (string, error) {
-	fieldID, fieldError := db.GetUserDataFieldIDByNameIntern("LOCATION_OF_INTEREST")
-	if fieldError != nil {
-		return "", fieldError
-	}
-
-	v, e := GetUserDataField[string](db, fieldID, userID)
-	if e != nil {
-		return "", e
-	}
-
-	return v, nil
-}
-
-// SetLastPayment sets the date of last payment field in adm_user_data.
-// It's assumed that a transaction is already set up in the db object.
-func (db *Database) SetLastPayment(userID int64, payment float64) error {
-	fieldID, fieldError := db.GetUserDataFieldIDByNameIntern("VALUE_OF_LAST_PAYMENT")
-	if fieldError != nil {
-		return fieldError
-	}
-
-	return SetUserDataField(db, fieldID, userID, payment)
-}
-
-// SetDonationToSociety sets the donation to society field in adm_user_data.
-// It's assumed that a transaction is already set up in the db object.
-func (db *Database) SetDonationToSociety(userID int64, payment float64) error {
-	fieldID, fieldError := db.GetUserDataFieldIDByNameIntern("VALUE_OF_DONATION_TO_LDLHS")
-	if fieldError != nil {
-		return fieldError
-	}
-
-	return SetUserDataField(db, fieldID, userID, payment)
-}
-
-// GetDonationToSociety gets the donation to society from the user's profile.
-// It's assumed that a transaction is already set up in the db object.
-func (db *Database) GetDonationToSociety(userID int64) (float64, error) {
-
-	fieldID, fieldError := db.GetUserDataFieldIDByNameIntern("VALUE_OF_DONATION_TO_LDLHS")
-	if fieldError != nil {
-		return 0.0, fieldError
-	}
-
-	v, e := GetUserDataField[float64](db, fieldID, userID)
-	if e != nil {
-		return 0.0, e
-	}
-
-	return v, nil
-}
-
-// SetDonationToMuseum sets the donation to the museum.
-// It's assumed that a transaction is already set up in the db object.
-func (db *Database) SetDonationToMuseum(userID int64, payment float64) error {
-	fieldID, fieldError := db.GetUserDataFieldIDByNameIntern("VALUE_OF_DONATION_TO_THE_MUSEUM")
-	if fieldError != nil {
-		return fieldError
-	}
-
-	return SetUserDataField(db, fieldID, userID, payment)
-}
-
-// GetDonationToMuseum gets the donation to museum from the user's profile.
-// It's assumed that a transaction is already set up in the db object.
-func (db *Database) GetDonationToMuseum(userID int64) (float64, error) {
-
-	fieldID, fieldError := db.GetUserDataFieldIDByNameIntern("VALUE_OF_DONATION_TO_THE_MUSEUM")
-	if fieldError != nil {
-		return 0.0, fieldError
-	}
-
-	v, e := GetUserDataField[float64](db, fieldID, userID)
-	if e != nil {
-		return 0.0, e
-	}
-
-	return v, nil
-}
-
-// SetDateLastPaid sets the date last paid field in adm_user_data.
-// It's assumed that a transaction is already set up in the db object.
-func (db *Database) SetDateLastPaid(userID int64, d time.Time) error {
-
-	fieldID, fieldError := db.GetUserDataFieldIDByNameIntern("DATE_LAST_PAID")
-	if fieldError != nil {
-		return fieldError
-	}
-
-	return db.SetDateFieldInUserData(fieldID, userID, d)
-}
-
-// GetDateLastPaid gets the date last paid from the user's profile.
-// It's assumed that a transaction is already set up in the db object.
-func (db *Database) GetDateLastPaid(userID int64) (string, error) {
-	// This is only used for testing.  The date string is not converted
-	// to a date object.  If you want to do that, note that Postgres
-	// and SQLite store them differently:
-	// postgres: "2025-12-31T00:00:00Z"
-	// SQLite: "2025-12-31 23:59:59 999999 +00"
-
-	fieldID, fieldError := db.GetUserDataFieldIDByNameIntern("DATE_LAST_PAID")
-	if fieldError != nil {
-		return "", fieldError
-	}
-
-	v, e := GetUserDataField[string](db, fieldID, userID)
-	if e != nil {
-		return "", e
-	}
-
-	return v, nil
-}
-
-// SetFriendField sets the friend of the museum field for the user in
-// adm_user_data.  Tick box fields are set to 0 or 1.
-func (db *Database) SetFriendField(userID int64, ticked bool) error {
-	fieldID, fieldError := db.GetUserDataFieldIDByNameIntern("FRIEND_OF_THE_MUSEUM")
-	if fieldError != nil {
-		return fieldError
-	}
-
-	if ticked {
-		return SetUserDataField(db, fieldID, userID, 1)
-	} else {
-		return SetUserDataField(db, fieldID, userID, 0)
-	}
-
-}
-
-// SetGiftaid sets the giftaid field for the user in
-// adm_user_data.  In the DB, tick box fields are set to 0 or 1.
-func (db *Database) SetGiftaid(userID int64, ticked bool) error {
-
-	fieldID, fieldError := db.GetUserDataFieldIDByNameIntern("GIFT_AID")
-	if fieldError != nil {
-		return fieldError
-	}
-	// If the member consents to giftaid, fill in the box.  In case
-	// it's already set from last year but not this year, ensure that
-	// the value in the DB record is reset.
-	if ticked {
-		return SetUserDataField(db, fieldID, userID, 1)
-	} else {
-		return SetUserDataField(db, fieldID, userID, 0)
-	}
-}
-
-// GetGiftaid gets the giftaid field for the user from
-// adm_user_data.  In the DB, Tick box fields are set to 0 or 1.
-func (db *Database) GetGiftaid(userID int64) (bool, error) {
-	fieldID, fieldError := db.GetUserDataFieldIDByNameIntern("GIFT_AID")
-	if fieldError != nil {
-		em := fmt.Sprintf("GetGiftaid: %v", fieldError)
-		return false, errors.New(em)
-	}
-
-	var fetchedValue int
-	fetchedValue, fetchError := GetUserDataField[int](db, fieldID, userID)
-	if fetchError != nil {
-		return false, fetchError
-	}
-
-	// 0 is false, any other value is true
-	if fetchedValue == 0 {
-		return false, nil
-	} else {
-		return true, nil
-	}
-}
-
-// SetMembersAtAddress sets the number of members at the user's address in
-// adm_user_data.
-func (db *Database) SetMembersAtAddress(userID int64, members int) error {
-	fieldID, fieldError := db.GetUserDataFieldIDByNameIntern("MEMBERS_AT_ADDRESS")
-	if fieldError != nil {
-		return fieldError
-	}
-	return SetUserDataField(db, fieldID, userID, members)
-}
-
-// GetMembersAtAddress gets the number of members at address from the user's profile.
-// It's assumed that a transaction is already set up in the db object.
-func (db *Database) GetMembersAtAddress(userID int64) (int, error) {
-	fieldID, fieldError := db.GetUserDataFieldIDByNameIntern("MEMBERS_AT_ADDRESS")
-	if fieldError != nil {
-		return 0, fieldError
-	}
-
-	v, e := GetUserDataField[int](db, fieldID, userID)
-	if e != nil {
-		return 0, e
-	}
-
-	return v, nil
-}
-
-// SetFriendsAtAddress sets the number of friends of the museum at the
-// user's address in adm_user_data.
-func (db *Database) SetFriendsAtAddress(userID int64, members int) error {
-	fieldID, fieldError := db.GetUserDataFieldIDByNameIntern("NUMBER_OF_FRIENDS_OF_THE_MUSEUM_AT_THIS_ADDRESS")
-	if fieldError != nil {
-		return fieldError
-	}
-
-	return SetUserDataField(db, fieldID, userID, members)
-}
-
-// GetFriendsAtAddress gets the number of friends at the user's address.
-// It's assumed that a transaction is already set up in the db object.
-func (db *Database) GetFriendsAtAddress(userID int64) (int, error) {
-	fieldID, fieldError := db.GetUserDataFieldIDByNameIntern("NUMBER_OF_FRIENDS_OF_THE_MUSEUM_AT_THIS_ADDRESS")
-	if fieldError != nil {
-		return 0, fieldError
-	}
-
-	v, e := GetUserDataField[int](db, fieldID, userID)
-	if e != nil {
-		return 0, e
-	}
-
-	return v, nil
-}
-
 // SetUserDataField sets the field with ID fieldID in adm_user_data
 // for the given user to the given value.  If a record for the field is
 // missing, one is created.
-func SetUserDataField[T int | float64 | bool | string](db *Database, fieldID, userID int64, val T) error {
+func SetUserDataField[T int | float64 | bool | string](ctx context.Context, db *Database, fieldID, userID int64, val T) error {
 
 	fn := "SetUserDataField"
 
+	// Fetch the existing value for the change history.
+	oldValue, oldValueError := GetUserDataField[string](ctx, db, fieldID, userID)
+	if oldValueError != nil {
+		return oldValueError
+	}
+
 	var query string
 	var err error
-	var returnedID int64
+	var n int64 // The number of rows updated or the ID of the new row.
 
-	if db.FieldSet(fieldID, userID) {
+	if db.FieldSet(ctx, fieldID, userID) {
 		// There is already a record for this field.  Update the value.
 		const postgresSQL = `
 			UPDATE adm_user_data
 			SET usd_value = $1
 			WHERE usd_usr_id = $2
-			AND usd_usf_id = $3
-			RETURNING usd_id;
+			AND usd_usf_id = $3;
 		`
 		const sqliteSQL = `
 			UPDATE adm_user_data
@@ -2976,7 +2509,7 @@ func SetUserDataField[T int | float64 | bool | string](db *Database, fieldID, us
 			query = sqliteSQL
 		}
 
-		returnedID, err = db.CreateRow(query, val, userID, fieldID)
+		n, err = db.UpdateRow(ctx, query, val, userID, fieldID)
 
 	} else {
 
@@ -2999,40 +2532,49 @@ func SetUserDataField[T int | float64 | bool | string](db *Database, fieldID, us
 			query = sqliteSQL
 		}
 
-		returnedID, err = db.CreateRow(query, userID, fieldID, val)
+		n, err = db.CreateRow(ctx, query, userID, fieldID, val)
 	}
 
 	if err != nil {
 		return err
 	}
 
-	if returnedID == 0 {
-		em := fmt.Sprintf("%s: zero return updating ID %d", fn, returnedID)
+	if n == 0 {
+		em := fmt.Sprintf("%s: user %d field %d not set", fn, userID, fieldID)
 		return errors.New(em)
 	}
 
-	return nil
+	return db.logChanges(ctx, userID, profileChange(fieldID, oldValue, storedForm(val)))
 }
 
 // SetDateFieldInUserData sets the field with ID fieldID in adm_user_data to an
 // date value, eg '2025-10-30'.
-func (db *Database) SetDateFieldInUserData(fieldID, userID int64, t time.Time) error {
+func (db *Database) SetDateFieldInUserData(ctx context.Context, fieldID, userID int64, t time.Time) error {
 
 	f := "SetDateFieldInUserData"
 
+	// Fetch the existing value for the change history.
+	oldValue, oldValueError := GetUserDataField[string](ctx, db, fieldID, userID)
+	if oldValueError != nil {
+		em := fmt.Sprintf("%s: %v", f, oldValueError)
+		return errors.New(em)
+	}
+
 	dateStr := t.Format("2006-01-02")
 
-	// Neither Postgres nor SQLite support rowsAffected.  Use RETURNING.
+	// The value is a string in every database, so MySQL uses the SQLite
+	// form.
 	var q string
+	var n int64 // The number of rows updated or the ID of the new row.
+	var err error
 
-	if db.FieldSet(fieldID, userID) {
+	if db.FieldSet(ctx, fieldID, userID) {
 		// There is already a record for this field.  Update it.
 		const postgresSQL = `
 			UPDATE adm_user_data
 			SET usd_value = $1
 			WHERE usd_usr_id = $2
-			AND usd_usf_id = $3
-			RETURNING usd_usr_id;
+			AND usd_usf_id = $3;
 		`
 		const sqliteSQL = `
 			UPDATE adm_user_data
@@ -3047,13 +2589,15 @@ func (db *Database) SetDateFieldInUserData(fieldID, userID int64, t time.Time) e
 			q = sqliteSQL
 		}
 
+		n, err = db.UpdateRow(ctx, q, dateStr, userID, fieldID)
+
 	} else {
 
 		// There is no record for this field.  Create and set it.
 		const postgresSQL = `
 			INSERT INTO adm_user_data(usd_value, usd_usr_id, usd_usf_id)
 			VALUES ($1, $2, $3)
-			RETURNING usd_usr_id;
+			RETURNING usd_id;
 		`
 		const sqliteSQL = `
 			INSERT INTO adm_user_data(usd_value, usd_usr_id, usd_usf_id)
@@ -3065,33 +2609,43 @@ func (db *Database) SetDateFieldInUserData(fieldID, userID int64, t time.Time) e
 		default:
 			q = sqliteSQL
 		}
-	}
 
-	returnedID, execAndScanError :=
-		db.CreateRow(q, dateStr, userID, fieldID)
+		n, err = db.CreateRow(ctx, q, dateStr, userID, fieldID)
+	}
 
-	if execAndScanError != nil {
-		em := fmt.Sprintf("%s: %v", f, execAndScanError)
+	if err != nil {
+		em := fmt.Sprintf("%s: %v", f, err)
 		return errors.New(em)
 	}
 
-	if returnedID == 0 {
-		em := fmt.Sprintf("%s: ID zero returned updating ID %d", f, userID)
+	if n == 0 {
+		em := fmt.Sprintf("%s: user %d field %d not set", f, userID, fieldID)
 		return errors.New(em)
 	}
 
-	return nil
+	return db.logChanges(ctx, userID, profileChange(fieldID, oldValue, dateStr))
 }
 
 // SetTimeFieldInUserData sets the field with ID fieldID in adm_user_data to an
 // time value.
-func (db *Database) SetTimeFieldInUserData(fieldID, userID int64, t time.Time) error {
+func (db *Database) SetTimeFieldInUserData(ctx context.Context, fieldID, userID int64, t time.Time) error {
 
 	f := "SetTimeFieldInUserData"
 
+	// Fetch the existing value for the change history.
+	oldValue, oldValueError := GetUserDataField[string](ctx, db, fieldID, userID)
+	if oldValueError != nil {
+		em := fmt.Sprintf("%s: %v", f, oldValueError)
+		return errors.New(em)
+	}
+
+	timeStr := t.Format("2006-01-02 15:04:05")
+
 	var q string
+	var n int64 // The number of rows updated or the ID of the new row.
+	var err error
 
-	if db.FieldSet(fieldID, userID) {
+	if db.FieldSet(ctx, fieldID, userID) {
 		// There is already a record for this field.  Update it.
 
 		// Postgres uses to_timestamp() to set the timestamp from a string.
@@ -3099,8 +2653,7 @@ func (db *Database) SetTimeFieldInUserData(fieldID, userID int64, t time.Time) e
 		UPDATE adm_user_data
 		SET usd_value = to_timestamp($1, 'YYYY-MM-DD HH24:MI:SS')
 		WHERE usd_usr_id = $2
-		AND usd_usf_id = $3
-		RETURNING usd_usr_id;
+		AND usd_usf_id = $3;
 	`
 		const sqliteSQL = `
 		UPDATE adm_user_data
@@ -3115,18 +2668,19 @@ func (db *Database) SetTimeFieldInUserData(fieldID, userID int64, t time.Time) e
 			q = sqliteSQL
 		}
 
+		n, err = db.UpdateRow(ctx, q, timeStr, userID, fieldID)
+
 	} else {
 
 		// There is no record for this field.  Create and set it.
 		const postgresSQL = `
 			INSERT INTO adm_user_data(usd_value, usd_usr_id, usd_usf_id)
 			VALUES (to_timestamp($1, 'YYYY-MM-DD HH24:MI:SS+HH'), $2, $3)
-			RETURNING usd_usr_id;
+			RETURNING usd_id;
 		`
 		const sqliteSQL = `
 			INSERT INTO adm_user_data(usd_value, usd_usr_id, usd_usf_id)
-			VALUES (?, ?, ?)
-			RETURNING usd_usr_id;
+			VALUES (?, ?, ?);
 		`
 		switch db.Config.Type {
 		case "postgres":
@@ -3134,27 +2688,26 @@ func (db *Database) SetTimeFieldInUserData(fieldID, userID int64, t time.Time) e
 		default:
 			q = sqliteSQL
 		}
-	}
 
-	timeStr := t.Format("2006-01-02 15:04:05")
+		n, err = db.CreateRow(ctx, q, timeStr, userID, fieldID)
+	}
 
-	returnedID, err := db.CreateRow(q, timeStr, userID, fieldID)
 	if err != nil {
 		em := fmt.Sprintf("%s: %v", f, err)
 		return errors.New(em)
 	}
 
-	if returnedID == 0 {
-		em := fmt.Sprintf("%s: ID zero returned updating ID %d", f, userID)
+	if n == 0 {
+		em := fmt.Sprintf("%s: user %d field %d not set", f, userID, fieldID)
 		return errors.New(em)
 	}
 
-	return nil
+	return db.logChanges(ctx, userID, profileChange(fieldID, oldValue, timeStr))
 }
 
 // FieldSet checks whether the given field is set in adm_user_data
 // for the given user.
-func (db *Database) FieldSet(fieldID, userID int64) bool {
+func (db *Database) FieldSet(ctx context.Context, fieldID, userID int64) bool {
 	const sqlSelect = `
 		SELECT usd_id FROM adm_user_data
 		WHERE usd_usr_id = $1
@@ -3162,7 +2715,7 @@ func (db *Database) FieldSet(fieldID, userID int64) bool {
 	`
 
 	// Check if the user already has the field set.
-	rows, selectError := db.Query(sqlSelect, userID, fieldID)
+	rows, selectError := db.Query(ctx, sqlSelect, userID, fieldID)
 	if selectError != nil {
 		return false
 	}
@@ -3179,10 +2732,10 @@ func (db *Database) FieldSet(fieldID, userID int64) bool {
 
 // MemberExists returns true if there is already a member with the given
 // username or email address.
-func (db *Database) MemberExists(username, emailAddress string) (bool, error) {
+func (db *Database) MemberExists(ctx context.Context, username, emailAddress string) (bool, error) {
 
 	// Check for a user with the given username.
-	users, userError := db.GetUsersByLoginName(username)
+	users, userError := db.GetUsersByLoginName(ctx, username)
 	if userError != nil {
 		return false, nil
 	}
@@ -3198,7 +2751,7 @@ func (db *Database) MemberExists(username, emailAddress string) (bool, error) {
 
 	// Get the ID just once.
 	const emailFieldName = "EMAIL"
-	emailID, fieldError := db.GetUserDataFieldIDByNameIntern(emailFieldName)
+	emailID, fieldError := db.GetUserDataFieldIDByNameIntern(ctx, emailFieldName)
 	if fieldError != nil && fieldError != sql.ErrNoRows {
 		return false, errors.New("MemberExists: checking email field ID - " + fieldError.Error())
 	}
@@ -3210,7 +2763,7 @@ func (db *Database) MemberExists(username, emailAddress string) (bool, error) {
 			AND (lower(email.usd_value) = lower($2));
 	`
 
-	rows, emailError := db.Query(
+	rows, emailError := db.Query(ctx,
 		q,
 		emailID,
 		emailAddress,
@@ -3241,81 +2794,37 @@ func (db *Database) MemberExists(username, emailAddress string) (bool, error) {
 // GetExtraDetails gets the given user's extra details (address, phone number etc)
 // and fills in the appropriate fields of the given membership sale record.
 // The function assumes that a transaction has been set up.
-func (db *Database) GetExtraDetails(ms *MembershipSale) error {
+func (db *Database) GetExtraDetails(ctx context.Context, ms *MembershipSale) error {
 
 	if ms.UserID <= 0 {
 		return errors.New("GetExtraDetails.Save: no userID")
 	}
 
-	a1, a1e := db.GetAddressLine1(ms.UserID)
-	if a1e != nil {
-		return a1e
-	}
-
-	a2, a2e := db.GetAddressLine2(ms.UserID)
-	if a2e != nil {
-		return a2e
-	}
-
-	a3, a3e := db.GetAddressLine3(ms.UserID)
-	if a3e != nil {
-		return a3e
-	}
-
-	t, te := db.GetTown(ms.UserID)
-	if te != nil {
-		return te
-	}
-
-	c, ce := db.GetCounty(ms.UserID)
-	if ce != nil {
-		return ce
-	}
-
-	pc, pce := db.GetPostcode(ms.UserID)
-	if pce != nil {
-		return pce
-	}
-
-	ct, cte := db.GetCountry(ms.UserID)
-	if cte != nil {
-		return cte
-	}
-
-	p, pe := db.GetPhone(ms.UserID)
-	if pe != nil {
-		return pe
-	}
-
-	mob, me := db.GetMobile(ms.UserID)
-	if me != nil {
-		return me
-	}
-
-	loi, loie := db.GetLocationOfInterest(ms.UserID)
-	if loie != nil {
-		return loie
+	details, fetchError := db.GetUserData(ctx, ms.UserID)
+	if fetchError != nil {
+		return fetchError
 	}
+	ud := details[ms.UserID]
 
-	mi, mie := db.GetMembersInterests(ms.UserID)
+	mi, mie := db.GetMembersInterests(ctx, ms.UserID)
 	if mie != nil {
 		return mie
 	}
 
-	moi, moie := db.GetMembersOtherInterests(ms.UserID)
+	moi, moie := db.GetMembersOtherInterests(ctx, ms.UserID)
 	if moie != nil {
 		return moie
 	}
-	ms.AddressLine1 = a1
-	ms.AddressLine2 = a2
-	ms.AddressLine3 = a3
-	ms.Town = t
-	ms.County = c
-	ms.Postcode = pc
-	ms.Country = ct
-	ms.Phone = p
-	ms.Mobile = mob
-	ms.LocationOfInterest = loi
+	ms.AddressLine1 = ud.AddressLine1
+	ms.AddressLine2 = ud.AddressLine2
+	ms.AddressLine3 = ud.AddressLine3
+	ms.Town = ud.Town
+	ms.County = ud.County
+	ms.Postcode = ud.Postcode
+	ms.Country = ud.CountryCode
+	ms.Phone = ud.Phone
+	ms.Mobile = ud.Mobile
+	ms.LocationOfInterest = ud.LocationOfInterest
 	if ms.TopicsOfInterest == nil {
 		ms.TopicsOfInterest = make(map[int64]interface{})
 	}
@@ -3327,88 +2836,54 @@ func (db *Database) GetExtraDetails(ms *MembershipSale) error {
 	return nil
 }
 
+// extraDetailsFields are the user fields collected by the extra details page.
+var extraDetailsFields = []string{
+	"STREET", "ADDRESS_LINE_2", "ADDRESS_LINE_3", "CITY", "COUNTY", "POSTCODE",
+	"COUNTRY", "PHONE", "MOBILE", "LOCATION_OF_INTEREST",
+}
+
 // SaveExtraDetails saves the given user's extra details - address, phone number etc.
+// Only the details that are given are saved, except for the fields from the extra
+// fields category, which are all saved.  The associate member, if any, gets the
+// same address and landline number and their own mobile number.
 // The function assumes that a transaction has been set up.
-func (db *Database) SaveExtraDetails(ms *MembershipSale) error {
+func (db *Database) SaveExtraDetails(ctx context.Context, ms *MembershipSale) error {
 
 	if ms.UserID <= 0 {
 		return errors.New("saveExtraDetails.Save: no userID")
 	}
 
-	if len(ms.AddressLine1) > 0 {
-		err := db.SetAddressLine1(ms.UserID, ms.AddressLine1)
-		if err != nil {
-			return err
-		}
+	ud := UserData{
+		UserID:             ms.UserID,
+		AddressLine1:       ms.AddressLine1,
+		AddressLine2:       ms.AddressLine2,
+		AddressLine3:       ms.AddressLine3,
+		Town:               ms.Town,
+		County:             ms.County,
+		Postcode:           ms.Postcode,
+		CountryCode:        ms.CountryCode,
+		Phone:              ms.Phone,
+		Mobile:             ms.Mobile,
+		LocationOfInterest: ms.LocationOfInterest,
 	}
 
-	if len(ms.AddressLine2) > 0 {
-		err := db.SetAddressLine2(ms.UserID, ms.AddressLine2)
-		if err != nil {
-			return err
-		}
+	saveError := db.SaveUserData(ctx, &ud, GivenFields(&ud, extraDetailsFields)...)
+	if saveError != nil {
+		return saveError
 	}
 
-	if len(ms.AddressLine3) > 0 {
-		err := db.SetAddressLine3(ms.UserID, ms.AddressLine3)
-		if err != nil {
-			return err
-		}
-	}
-
-	if len(ms.Town) > 0 {
-		err := db.SetTown(ms.UserID, ms.Town)
-		if err != nil {
-			return err
-		}
-	}
-
-	if len(ms.County) > 0 {
-		err := db.SetCounty(ms.UserID, ms.County)
-		if err != nil {
-			return err
-		}
-	}
-
-	if len(ms.Postcode) > 0 {
-		err := db.SetPostcode(ms.UserID, ms.Postcode)
-		if err != nil {
-			return err
-		}
-	}
-
-	if len(ms.CountryCode) > 0 {
-		err := db.SetCountryCode(ms.UserID, ms.CountryCode)
-		if err != nil {
-			return err
-		}
-	}
-
-	if len(ms.Phone) > 0 {
-		err := db.SetPhone(ms.UserID, ms.Phone)
-		if err != nil {
-			return err
-		}
-	}
-
-	if len(ms.Mobile) > 0 {
-		err := db.SetMobile(ms.UserID, ms.Mobile)
-		if err != nil {
-			return err
-		}
-	}
-
-	if len(ms.LocationOfInterest) > 0 {
-		err := db.SetLocationOfInterest(ms.UserID, ms.LocationOfInterest)
-		if err != nil {
-			return err
+	// The fields from the extra fields category belong to this user only.
+	if len(ms.ExtraFields) > 0 {
+		extraError := db.SaveExtraFields(ctx, ms.UserID, ms.ExtraFields)
+		if extraError != nil {
+			return extraError
 		}
 	}
 
 	if len(ms.TopicsOfInterest) > 0 {
 		for interestID := range ms.TopicsOfInterest {
 			mi := NewMembersInterest(ms.UserID, interestID)
-			err := db.CreateMembersInterest(mi)
+			err := db.CreateMembersInterest(ctx, mi)
 			if err != nil {
 				return err
 			}
@@ -3417,7 +2892,7 @@ func (db *Database) SaveExtraDetails(ms *MembershipSale) error {
 
 	if len(ms.OtherTopicsOfInterest) > 0 {
 		moi := NewMembersOtherInterests(ms.UserID, ms.OtherTopicsOfInterest)
-		err := db.CreateMembersOtherInterests(moi)
+		err := db.CreateMembersOtherInterests(ctx, moi)
 		if err != nil {
 			return err
 		}
@@ -3425,68 +2900,16 @@ func (db *Database) SaveExtraDetails(ms *MembershipSale) error {
 
 	if ms.AssocUserID > 0 {
 		// There is an assocate user.  Set the same address and landline number.
-		if len(ms.AddressLine1) > 0 {
-			err := db.SetAddressLine1(ms.AssocUserID, ms.AddressLine1)
-			if err != nil {
-				return err
-			}
-		}
-
-		if len(ms.AddressLine2) > 0 {
-			err := db.SetAddressLine2(ms.AssocUserID, ms.AddressLine2)
-			if err != nil {
-				return err
-			}
-		}
-
-		if len(ms.AddressLine3) > 0 {
-			err := db.SetAddressLine3(ms.AssocUserID, ms.AddressLine3)
-			if err != nil {
-				return err
-			}
-		}
-
-		if len(ms.Town) > 0 {
-			err := db.SetTown(ms.AssocUserID, ms.Town)
-			if err != nil {
-				return err
-			}
-		}
+		// The extra details form may have specified the associate user's mobile
+		// number.
+		assoc := ud
+		assoc.UserID = ms.AssocUserID
+		assoc.Mobile = ms.AssocMobile
+		assoc.LocationOfInterest = ""
 
-		if len(ms.County) > 0 {
-			err := db.SetCounty(ms.AssocUserID, ms.County)
-			if err != nil {
-				return err
-			}
-		}
-
-		if len(ms.Postcode) > 0 {
-			err := db.SetPostcode(ms.AssocUserID, ms.Postcode)
-			if err != nil {
-				return err
-			}
-		}
-
-		if len(ms.CountryCode) > 0 {
-			err := db.SetCountryCode(ms.AssocUserID, ms.CountryCode)
-			if err != nil {
-				return err
-			}
-		}
-
-		if len(ms.Phone) > 0 {
-			err := db.SetPhone(ms.AssocUserID, ms.Phone)
-			if err != nil {
-				return err
-			}
-		}
-
-		// The extra details form may have specified the associate user's mobile number.
-		if len(ms.AssocMobile) > 0 {
-			err := db.SetMobile(ms.AssocUserID, ms.AssocMobile)
-			if err != nil {
-				return err
-			}
+		saveAssocError := db.SaveUserData(ctx, &assoc, GivenFields(&assoc, extraDetailsFields)...)
+		if saveAssocError != nil {
+			return saveAssocError
 		}
 	}
 
@@ -3541,10 +2964,16 @@ func GetUserName(email, firstname, lastname string) (string, error) {
 	}
 }
 
+// createUuid creates a UUID for a new row of the given Admidio table, which
+// is adapted like the name in a query (see adapt).
+func (db *Database) createUuid(ctx context.Context, field, table string) (string, error) {
+	return CreateUuid(ctx, db.Transaction, db.adapt(field), db.adapt(table))
+}
+
 // CreateUuid creates and returns a UUID which is unique in the given row of the given table.
 // The technque uses a random source which makes a duplicate extremely unlikely. although
 // possible.
-func CreateUuid(tx *sql.Tx, field, table string) (string, error) {
+func CreateUuid(ctx context.Context, tx *sql.Tx, field, table string) (string, error) {
 
 	// Do this up to ten times until you get a UUID that's not already
 	// used.  Each attempt is very unlikely to fail.
@@ -3557,11 +2986,14 @@ func CreateUuid(tx *sql.Tx, field, table string) (string, error) {
 		}
 
 		// Check that the UUID is not already in the table.
-		// (This is theoretically possible but unlikely.)
-		q := fmt.Sprintf("select %s from %s where %s = $1;",
-			field, table, field)
-
-		resultSet, err := tx.Query(q, uid.String())
+		// (This is theoretically possible but unlikely.)  We only have
+		// the transaction, not the type of database, so we can't convert
+		// a placeholder.  The UUID is just hex digits and hyphens, so it's
+		// safe to put it in the query.
+		q := fmt.Sprintf("select %s from %s where %s = '%s';",
+			field, table, field, uid.String())
+
+		resultSet, err := tx.QueryContext(ctx, q)
 		if err != nil {
 			// If there is no match under Postgres, this may return the error
 			// "no rows in result set".
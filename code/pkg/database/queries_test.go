@@ -1,6 +1,7 @@
 package database
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"math"
@@ -13,7 +14,7 @@ import (
 
 // databaseList is a list of database types that will be used in
 // integration tests.
-var databaseList = []string{"postgres", "sqlite"}
+var databaseList = DatabaseListForTesting("postgres", "sqlite")
 
 // TestGetMembershipYear checks that GetSellingYear correctly identifies
 // the membership year that we should be selling on a given date.
@@ -67,6 +68,8 @@ func TestGetMembershipYear(t *testing.T) {
 // TestClose checks that Close does not throw an error if the database
 // is open and quiet.
 func TestClose(t *testing.T) {
+	ctx := t.Context()
+
 	fmt.Println("TestClose")
 	for _, dbType := range databaseList {
 
@@ -77,7 +80,7 @@ func TestClose(t *testing.T) {
 			return
 		}
 
-		db.BeginTx()
+		db.BeginTx(ctx)
 
 		// If either of these fail, the test will fail.
 		defer db.Rollback()
@@ -86,6 +89,8 @@ func TestClose(t *testing.T) {
 }
 
 func TestCreateSQLiteTables(t *testing.T) {
+	ctx := t.Context()
+
 	fmt.Println("TestCreateSQLiteTables")
 	for _, dbType := range databaseList {
 
@@ -96,7 +101,7 @@ func TestCreateSQLiteTables(t *testing.T) {
 			return
 		}
 
-		db.BeginTx()
+		db.BeginTx(ctx)
 
 		defer db.Rollback()
 		defer db.CloseAndDelete()
@@ -110,6 +115,8 @@ func TestCreateSQLiteTables(t *testing.T) {
 
 // TestPrepareTestTables checks that PrepareTestTables runs without an error.
 func TestPrepareTestTables(t *testing.T) {
+	ctx := t.Context()
+
 	fmt.Println("TestPrepareTestTables")
 	for _, dbType := range databaseList {
 
@@ -119,7 +126,7 @@ func TestPrepareTestTables(t *testing.T) {
 			t.Error(connError)
 		}
 
-		db.BeginTx()
+		db.BeginTx(ctx)
 
 		defer db.Rollback()
 		defer db.CloseAndDelete()
@@ -132,6 +139,7 @@ func TestPrepareTestTables(t *testing.T) {
 }
 
 func TestGetRole(t *testing.T) {
+	ctx := t.Context()
 
 	fmt.Println("TestGetRole")
 
@@ -144,7 +152,7 @@ func TestGetRole(t *testing.T) {
 			return
 		}
 
-		db.BeginTx()
+		db.BeginTx(ctx)
 
 		defer db.Rollback()
 		defer db.CloseAndDelete()
@@ -156,7 +164,7 @@ func TestGetRole(t *testing.T) {
 
 		roleNames := []string{RoleNameAdmin, RoleNameMember}
 		for _, name := range roleNames {
-			got, err := db.GetRole(name)
+			got, err := db.GetRole(ctx, name)
 			if err != nil {
 				t.Error(dbType + ": " + err.Error())
 				return
@@ -175,6 +183,8 @@ func TestGetRole(t *testing.T) {
 
 // TestCreateAndDeleteUser checks that we can create and then delete a user.
 func TestCreateAndDeleteUser(t *testing.T) {
+	ctx := t.Context()
+
 	fmt.Println("TestCreateUser")
 	for _, dbType := range databaseList {
 		db, connError := OpenDBForTesting(dbType)
@@ -184,7 +194,7 @@ func TestCreateAndDeleteUser(t *testing.T) {
 			return
 		}
 
-		db.BeginTx()
+		db.BeginTx(ctx)
 
 		defer db.Rollback()
 		defer db.CloseAndDelete()
@@ -197,7 +207,7 @@ func TestCreateAndDeleteUser(t *testing.T) {
 		const want = "foo"
 		user := NewUser(want)
 
-		createError := db.CreateUser(user)
+		createError := db.CreateUser(ctx, user)
 
 		if createError != nil {
 			t.Errorf("%s: %v", dbType, createError)
@@ -216,7 +226,7 @@ func TestCreateAndDeleteUser(t *testing.T) {
 
 		// Fetch the user back and check it.
 
-		u, fetchUserError := db.GetUser(got)
+		u, fetchUserError := db.GetUser(ctx, got)
 		if fetchUserError != nil {
 			t.Error(fetchUserError)
 		}
@@ -237,7 +247,7 @@ func TestCreateAndDeleteUser(t *testing.T) {
 			t.Error("expected the user to be valid")
 		}
 
-		deleteError := db.DeleteUser(user)
+		deleteError := db.DeleteUser(ctx, user)
 
 		if deleteError != nil {
 			t.Error(deleteError)
@@ -254,6 +264,7 @@ func TestCreateAndDeleteUser(t *testing.T) {
 // TestGetUsersByLoginName checks GetUsersByLoginName.  Note - there is a veey small chance
 // that this test will fail due to a coincidence, but then work if run again.
 func TestGetUsersByLoginName(t *testing.T) {
+	ctx := t.Context()
 
 	containsAlpha, recError := regexp.Compile("[a-z]+")
 	if recError != nil {
@@ -269,7 +280,7 @@ func TestGetUsersByLoginName(t *testing.T) {
 			return
 		}
 
-		db.BeginTx()
+		db.BeginTx(ctx)
 
 		defer db.Rollback()
 		defer db.CloseAndDelete()
@@ -287,7 +298,7 @@ func TestGetUsersByLoginName(t *testing.T) {
 		// Attempt to create some unique names but give up eventually if we can't.
 		for i := 0; i < 20; i++ {
 
-			n, uuidError := CreateUuid(db.Transaction, "usr_login_name", "adm_users")
+			n, uuidError := CreateUuid(ctx, db.Transaction, "usr_login_name", "adm_users")
 			if uuidError != nil {
 				t.Error(uuidError)
 				return
@@ -303,7 +314,7 @@ func TestGetUsersByLoginName(t *testing.T) {
 			nameUpper := strings.ToUpper(n)
 
 			// Ensure that the transformed names have not been used.
-			users1, fetchError1 := db.GetUsersByLoginName(nameLower)
+			users1, fetchError1 := db.GetUsersByLoginName(ctx, nameLower)
 			if fetchError1 != nil {
 				t.Error(fetchError1)
 			}
@@ -313,7 +324,7 @@ func TestGetUsersByLoginName(t *testing.T) {
 				continue
 			}
 
-			users2, fetchError2 := db.GetUsersByLoginName(nameUpper)
+			users2, fetchError2 := db.GetUsersByLoginName(ctx, nameUpper)
 			if fetchError2 != nil {
 				t.Error(fetchError2)
 			}
@@ -327,7 +338,7 @@ func TestGetUsersByLoginName(t *testing.T) {
 
 			user := NewUser(nameLower)
 
-			createError := db.CreateUser(user)
+			createError := db.CreateUser(ctx, user)
 			if createError != nil {
 				t.Error(createError)
 			}
@@ -335,7 +346,7 @@ func TestGetUsersByLoginName(t *testing.T) {
 			// Finally, the test.
 
 			// Search for the user we just created by the name we used to create it.
-			userL, fetchErrorL := db.GetUsersByLoginName(nameLower)
+			userL, fetchErrorL := db.GetUsersByLoginName(ctx, nameLower)
 			if fetchErrorL != nil {
 				t.Error(fetchErrorL)
 			}
@@ -350,7 +361,7 @@ func TestGetUsersByLoginName(t *testing.T) {
 
 			// Search for the user we just created by the name we used to create it converted
 			// to upper case.
-			userU, fetchErrorU := db.GetUsersByLoginName(nameUpper)
+			userU, fetchErrorU := db.GetUsersByLoginName(ctx, nameUpper)
 			if fetchErrorU != nil {
 				t.Error(fetchErrorU)
 			}
@@ -378,6 +389,8 @@ func TestGetUsersByLoginName(t *testing.T) {
 // that requires, checks the result, deletes them and checks that
 // too.
 func TestCreateAndDeleteMember(t *testing.T) {
+	ctx := t.Context()
+
 	fmt.Println("TestCreateAndDeleteMember")
 	for _, dbType := range databaseList {
 
@@ -388,7 +401,7 @@ func TestCreateAndDeleteMember(t *testing.T) {
 			return
 		}
 
-		db.BeginTx()
+		db.BeginTx(ctx)
 
 		defer db.Rollback()
 		defer db.CloseAndDelete()
@@ -398,7 +411,7 @@ func TestCreateAndDeleteMember(t *testing.T) {
 			t.Error(prepError)
 		}
 
-		role, roleError := db.GetRole("Member") // Lazy loading.
+		role, roleError := db.GetRole(ctx, "Member") // Lazy loading.
 		if roleError != nil {
 			t.Error(dbType + ": " + roleError.Error())
 			return
@@ -407,14 +420,14 @@ func TestCreateAndDeleteMember(t *testing.T) {
 		const wantStartDate = "1970-01-01"
 		const wantEndDate = "2025-12-31"
 
-		loginName, e1 := CreateUuid(db.Transaction, "usr_login_name", "adm_users")
+		loginName, e1 := CreateUuid(ctx, db.Transaction, "usr_login_name", "adm_users")
 		if e1 != nil {
 			t.Error(e1)
 			return
 		}
 
 		user := NewUser(loginName)
-		userError := db.CreateUser(user)
+		userError := db.CreateUser(ctx, user)
 		if userError != nil {
 			t.Error(userError)
 			continue
@@ -422,7 +435,7 @@ func TestCreateAndDeleteMember(t *testing.T) {
 		startDate := time.Date(1970, time.January, 1, 0, 0, 0, 0, time.UTC)
 		endDate := time.Date(2025, time.December, 31, 0, 0, 0, 0, time.UTC)
 		member := NewMember(user, role, startDate, endDate)
-		err := db.CreateMember(member)
+		err := db.CreateMember(ctx, member)
 		if err != nil {
 			t.Error(dbType + ": " + err.Error())
 			continue
@@ -447,11 +460,11 @@ func TestCreateAndDeleteMember(t *testing.T) {
 		}
 
 		// Remove and check the remains.
-		deleteError := db.DeleteMember(member)
+		deleteError := db.DeleteMember(ctx, member)
 		if deleteError != nil {
 			t.Error(deleteError)
 		}
-		deleteUserError := db.DeleteUser(user)
+		deleteUserError := db.DeleteUser(ctx, user)
 		if deleteUserError != nil {
 			t.Error(deleteUserError)
 		}
@@ -466,6 +479,8 @@ func TestCreateAndDeleteMember(t *testing.T) {
 
 // TestCreateMembersInterest checks CreateMembersInterest.
 func TestCreateMembersInterest(t *testing.T) {
+	ctx := t.Context()
+
 	for _, dbType := range databaseList {
 
 		db, connError := OpenDBForTesting(dbType)
@@ -475,7 +490,7 @@ func TestCreateMembersInterest(t *testing.T) {
 			return
 		}
 
-		db.BeginTx()
+		db.BeginTx(ctx)
 
 		defer db.Rollback()
 		defer db.CloseAndDelete()
@@ -486,13 +501,13 @@ func TestCreateMembersInterest(t *testing.T) {
 			continue
 		}
 
-		user, cue := CreateUser(db)
+		user, cue := CreateUser(ctx, db)
 		if cue != nil {
 			t.Errorf("%s: %v", dbType, cue)
 			continue
 		}
 
-		mis, me := db.GetInterests()
+		mis, me := db.GetInterests(ctx)
 		if me != nil {
 			t.Errorf("%s: %v", dbType, me)
 		}
@@ -505,13 +520,13 @@ func TestCreateMembersInterest(t *testing.T) {
 		// we have at least one interest, which is enough for this test.
 
 		mi := NewMembersInterest(user.ID, mis[0].ID)
-		ce := db.CreateMembersInterest(mi)
+		ce := db.CreateMembersInterest(ctx, mi)
 		if ce != nil {
 			t.Errorf("%s: %v", dbType, ce)
 			continue
 		}
 
-		fetchedInterests, fetchError := db.GetMembersInterests(user.ID)
+		fetchedInterests, fetchError := db.GetMembersInterests(ctx, user.ID)
 		if fetchError != nil {
 			t.Errorf("%s: %v", dbType, fetchError)
 			continue
@@ -529,8 +544,91 @@ func TestCreateMembersInterest(t *testing.T) {
 
 }
 
+// TestDeleteMembersInterests checks that DeleteMembersInterests removes the
+// user's interests and leaves other users' alone.
+func TestDeleteMembersInterests(t *testing.T) {
+	ctx := t.Context()
+
+	for _, dbType := range databaseList {
+
+		db, connError := OpenDBForTesting(dbType)
+		if connError != nil {
+			t.Error(connError)
+			continue
+		}
+
+		db.BeginTx(ctx)
+
+		defer db.Rollback()
+		defer db.CloseAndDelete()
+
+		prepError := PrepareTestTables(db)
+		if prepError != nil {
+			t.Errorf("%s: %v", dbType, prepError)
+			continue
+		}
+
+		user1, cue1 := CreateUser(ctx, db)
+		if cue1 != nil {
+			t.Errorf("%s: %v", dbType, cue1)
+			continue
+		}
+
+		user2, cue2 := CreateUser(ctx, db)
+		if cue2 != nil {
+			t.Errorf("%s: %v", dbType, cue2)
+			continue
+		}
+
+		mis, me := db.GetInterests(ctx)
+		if me != nil {
+			t.Errorf("%s: %v", dbType, me)
+			continue
+		}
+
+		if len(mis) == 0 {
+			t.Errorf("%s: %s", dbType, "no interests")
+			continue
+		}
+
+		for _, user := range []*User{user1, user2} {
+			ce := db.CreateMembersInterest(ctx, NewMembersInterest(user.ID, mis[0].ID))
+			if ce != nil {
+				t.Errorf("%s: %v", dbType, ce)
+			}
+		}
+
+		deleteError := db.DeleteMembersInterests(ctx, user1.ID)
+		if deleteError != nil {
+			t.Errorf("%s: %v", dbType, deleteError)
+			continue
+		}
+
+		interests1, fetchError1 := db.GetMembersInterests(ctx, user1.ID)
+		if fetchError1 != nil {
+			t.Errorf("%s: %v", dbType, fetchError1)
+			continue
+		}
+
+		if len(interests1) != 0 {
+			t.Errorf("%s: want no interests got %d", dbType, len(interests1))
+		}
+
+		interests2, fetchError2 := db.GetMembersInterests(ctx, user2.ID)
+		if fetchError2 != nil {
+			t.Errorf("%s: %v", dbType, fetchError2)
+			continue
+		}
+
+		if len(interests2) != 1 {
+			t.Errorf("%s: want 1 interest got %d", dbType, len(interests2))
+		}
+	}
+}
+
 // TestGetMembersInterests checks GetMembersInterests.
 func TestGetMembersInterests(t *testing.T) {
+	ctx := t.Context()
 
 	for _, dbType := range databaseList {
 
@@ -541,7 +639,7 @@ func TestGetMembersInterests(t *testing.T) {
 			return
 		}
 
-		db.BeginTx()
+		db.BeginTx(ctx)
 
 		defer db.Rollback()
 		defer db.CloseAndDelete()
@@ -554,13 +652,13 @@ func TestGetMembersInterests(t *testing.T) {
 		// The setup is long-winded.
 
 		//  Create a user.
-		loginName, e1 := CreateUuid(db.Transaction, "usr_login_name", "adm_users")
+		loginName, e1 := CreateUuid(ctx, db.Transaction, "usr_login_name", "adm_users")
 		if e1 != nil {
 			t.Error(e1)
 			return
 		}
 		user := NewUser(loginName)
-		createUserError := db.CreateUser(user)
+		createUserError := db.CreateUser(ctx, user)
 		if createUserError != nil {
 			t.Errorf("%s: %v", dbType, createUserError)
 			return
@@ -569,7 +667,7 @@ func TestGetMembersInterests(t *testing.T) {
 		// Get the ID of two interests - any will do.  (If there are not at
 		// least two rows, the test will fail.)
 
-		interest, ie := db.GetInterests()
+		interest, ie := db.GetInterests(ctx)
 		if ie != nil {
 			t.Errorf("%s: %v", dbType, ie)
 			return
@@ -582,13 +680,13 @@ func TestGetMembersInterests(t *testing.T) {
 
 		// Create two MembersInterest rows in the database
 		mi1 := NewMembersInterest(user.ID, interest[0].ID)
-		createError1 := db.CreateMembersInterest(mi1)
+		createError1 := db.CreateMembersInterest(ctx, mi1)
 		if createError1 != nil {
 			t.Errorf("%s: %v", db.Config.Type, createError1)
 			continue
 		}
 		mi2 := NewMembersInterest(user.ID, interest[1].ID)
-		createError2 := db.CreateMembersInterest(mi2)
+		createError2 := db.CreateMembersInterest(ctx, mi2)
 		if createError2 != nil {
 			t.Errorf("%s: %v", db.Config.Type, createError2)
 			continue
@@ -596,7 +694,7 @@ func TestGetMembersInterests(t *testing.T) {
 
 		// Test GetMembersInterests.  The interest objects were fetched in order
 		// and the results are ordered.
-		got, gotError := db.GetMembersInterests(user.ID)
+		got, gotError := db.GetMembersInterests(ctx, user.ID)
 		if gotError != nil {
 			t.Errorf("%s: %v", db.Config.Type, gotError)
 			continue
@@ -625,14 +723,14 @@ func TestGetMembersInterests(t *testing.T) {
 		// Check the unique constraint handling - attempt to create a copy
 		// of mi1.
 		mi3 := NewMembersInterest(user.ID, interest[0].ID)
-		createError3 := db.CreateMembersInterest(mi3)
+		createError3 := db.CreateMembersInterest(ctx, mi3)
 		if createError3 != nil {
 			t.Errorf("%s: %v", db.Config.Type, createError3)
 			continue
 		}
 
 		// There should still only be two interests.
-		got2, got2Error := db.GetMembersInterests(user.ID)
+		got2, got2Error := db.GetMembersInterests(ctx, user.ID)
 		if got2Error != nil {
 			t.Errorf("%s: %v", db.Config.Type, got2Error)
 			continue
@@ -647,6 +745,8 @@ func TestGetMembersInterests(t *testing.T) {
 // TestGetMembersInterestForUserWithJunkUser checks that GetMembersInterestForUserWithJunkUser
 // returns an empty list if the user doesn't exist.
 func TestGetMembersInterestsWithJunkUser(t *testing.T) {
+	ctx := t.Context()
+
 	for _, dbType := range databaseList {
 
 		db, connError := ConnectForTesting(dbType)
@@ -662,7 +762,7 @@ func TestGetMembersInterestsWithJunkUser(t *testing.T) {
 		// system they are limited to 32 bits.  No matter, that should be big
 		// enough to ensure that there is no user with an ID this big.
 		const junkUserID = math.MaxInt32
-		interests, err := db.GetMembersInterests(junkUserID)
+		interests, err := db.GetMembersInterests(ctx, junkUserID)
 		if err != nil {
 			t.Errorf("%s: %v", dbType, err)
 			continue
@@ -677,6 +777,8 @@ func TestGetMembersInterestsWithJunkUser(t *testing.T) {
 
 // TestUpdateMembersOtherInterests checkss UpdateMembersOtherInterests.
 func TestUpdateMembersOtherInterests(t *testing.T) {
+	ctx := t.Context()
+
 	for _, dbType := range databaseList {
 
 		db, connError := OpenDBForTesting(dbType)
@@ -686,7 +788,7 @@ func TestUpdateMembersOtherInterests(t *testing.T) {
 			continue
 		}
 
-		db.BeginTx()
+		db.BeginTx(ctx)
 
 		defer db.Rollback()
 		defer db.CloseAndDelete()
@@ -698,7 +800,7 @@ func TestUpdateMembersOtherInterests(t *testing.T) {
 		}
 
 		// Set up.
-		user, cue := CreateUser(db)
+		user, cue := CreateUser(ctx, db)
 		if cue != nil {
 			t.Errorf("%s: %v", dbType, cue)
 			continue
@@ -706,7 +808,7 @@ func TestUpdateMembersOtherInterests(t *testing.T) {
 
 		const interests = "stuff"
 		moi := NewMembersOtherInterests(user.ID, interests)
-		coi := db.CreateMembersOtherInterests(moi)
+		coi := db.CreateMembersOtherInterests(ctx, moi)
 		if coi != nil {
 			t.Errorf("%s: %v", dbType, coi)
 			continue
@@ -715,9 +817,9 @@ func TestUpdateMembersOtherInterests(t *testing.T) {
 		// Test.
 		const newInterest = "other stuff"
 		moi.Interests = newInterest
-		db.UpdateMembersOtherInterests(moi)
+		db.UpdateMembersOtherInterests(ctx, moi)
 
-		got, fetchError := db.GetMembersOtherInterests(user.ID)
+		got, fetchError := db.GetMembersOtherInterests(ctx, user.ID)
 		if fetchError != nil {
 			t.Errorf("%s: %v", dbType, fetchError)
 			continue
@@ -732,6 +834,8 @@ func TestUpdateMembersOtherInterests(t *testing.T) {
 
 // TestUpsertMembersOtherInterests checks UpsertMembersOtherInterests.
 func TestUpsertMembersOtherInterests(t *testing.T) {
+	ctx := t.Context()
+
 	for _, dbType := range databaseList {
 
 		db, connError := OpenDBForTesting(dbType)
@@ -741,7 +845,7 @@ func TestUpsertMembersOtherInterests(t *testing.T) {
 			continue
 		}
 
-		db.BeginTx()
+		db.BeginTx(ctx)
 
 		defer db.Rollback()
 		defer db.CloseAndDelete()
@@ -753,25 +857,25 @@ func TestUpsertMembersOtherInterests(t *testing.T) {
 		}
 
 		// Set up.
-		user1, cue := CreateUser(db)
+		user1, cue := CreateUser(ctx, db)
 		if cue != nil {
 			t.Errorf("%s: %v", dbType, cue)
 			continue
 		}
 
-		user2, cue2 := CreateUser(db)
+		user2, cue2 := CreateUser(ctx, db)
 		if cue2 != nil {
 			t.Errorf("%s: %v", dbType, cue2)
 			continue
 		}
 
-		interests1, e1 := CreateUuid(db.Transaction, "moi_interests", "adm_members_other_interests")
+		interests1, e1 := CreateUuid(ctx, db.Transaction, "moi_interests", "adm_members_other_interests")
 		if e1 != nil {
 			t.Error(e1)
 			return
 		}
 		want1 := NewMembersOtherInterests(user1.ID, interests1)
-		coi := db.CreateMembersOtherInterests(want1)
+		coi := db.CreateMembersOtherInterests(ctx, want1)
 		if coi != nil {
 			t.Errorf("%s: %v", dbType, coi)
 			continue
@@ -783,19 +887,19 @@ func TestUpsertMembersOtherInterests(t *testing.T) {
 		// Test.
 
 		// Upsert user 1, relacing original interests.
-		interests2, e2 := CreateUuid(db.Transaction, "moi_interests", "adm_members_other_interests")
+		interests2, e2 := CreateUuid(ctx, db.Transaction, "moi_interests", "adm_members_other_interests")
 		if e2 != nil {
 			t.Error(e2)
 			return
 		}
 		want1.Interests = interests2
-		ue1 := db.UpsertMembersOtherInterests(want1)
+		ue1 := db.UpsertMembersOtherInterests(ctx, want1)
 		if ue1 != nil {
 			t.Errorf("%s: %v", dbType, ue1)
 			continue
 		}
 
-		got1, fetchError1 := db.GetMembersOtherInterests(user1.ID)
+		got1, fetchError1 := db.GetMembersOtherInterests(ctx, user1.ID)
 		if fetchError1 != nil {
 			t.Errorf("%s: %v", dbType, fetchError1)
 			continue
@@ -807,18 +911,18 @@ func TestUpsertMembersOtherInterests(t *testing.T) {
 		}
 
 		// Upsert user 2 creating a new record.
-		interests3, e3 := CreateUuid(db.Transaction, "moi_interests", "adm_members_other_interests")
+		interests3, e3 := CreateUuid(ctx, db.Transaction, "moi_interests", "adm_members_other_interests")
 		if e3 != nil {
 			t.Error(e3)
 			return
 		}
 		want2 := NewMembersOtherInterests(user2.ID, interests3)
-		ue2 := db.UpsertMembersOtherInterests(want2)
+		ue2 := db.UpsertMembersOtherInterests(ctx, want2)
 		if ue2 != nil {
 			t.Errorf("%s: %v", dbType, ue2)
 			continue
 		}
-		got2, fetchError2 := db.GetMembersOtherInterests(user2.ID)
+		got2, fetchError2 := db.GetMembersOtherInterests(ctx, user2.ID)
 		if fetchError2 != nil {
 			t.Errorf("%s: %v", dbType, fetchError2)
 			continue
@@ -833,6 +937,7 @@ func TestUpsertMembersOtherInterests(t *testing.T) {
 
 // TestGetMembersOtherInterestsForUser checks GetMembersOtherInterestsForUser.
 func TestGetMembersOtherInterestsForUser(t *testing.T) {
+	ctx := t.Context()
 
 	for _, dbType := range databaseList {
 
@@ -843,7 +948,7 @@ func TestGetMembersOtherInterestsForUser(t *testing.T) {
 			return
 		}
 
-		db.BeginTx()
+		db.BeginTx(ctx)
 
 		defer db.Rollback()
 		defer db.CloseAndDelete()
@@ -854,20 +959,20 @@ func TestGetMembersOtherInterestsForUser(t *testing.T) {
 		}
 
 		//  Create a user.
-		loginName, e1 := CreateUuid(db.Transaction, "usr_login_name", "adm_users")
+		loginName, e1 := CreateUuid(ctx, db.Transaction, "usr_login_name", "adm_users")
 		if e1 != nil {
 			t.Error(e1)
 			return
 		}
 		user := NewUser(loginName)
-		createUserError := db.CreateUser(user)
+		createUserError := db.CreateUser(ctx, user)
 		if createUserError != nil {
 			t.Error(createUserError)
 			return
 		}
 
 		// Create an other interest.
-		interests, e2 := CreateUuid(db.Transaction, "moi_interests", "adm_members_other_interests")
+		interests, e2 := CreateUuid(ctx, db.Transaction, "moi_interests", "adm_members_other_interests")
 		if e2 != nil {
 			t.Error(e2)
 			return
@@ -875,14 +980,14 @@ func TestGetMembersOtherInterestsForUser(t *testing.T) {
 
 		moi := NewMembersOtherInterests(user.ID, interests)
 
-		createInterestError := db.CreateMembersOtherInterests(moi)
+		createInterestError := db.CreateMembersOtherInterests(ctx, moi)
 		if createInterestError != nil {
 			t.Error(createInterestError)
 			return
 		}
 
 		// Test GetOMembersOtherInterestsForUser.
-		got, fetchError := db.GetMembersOtherInterests(user.ID)
+		got, fetchError := db.GetMembersOtherInterests(ctx, user.ID)
 		if fetchError != nil {
 			t.Error(fetchError)
 			return
@@ -907,6 +1012,8 @@ func TestGetMembersOtherInterestsForUser(t *testing.T) {
 
 // TestGetAndSetExtraDetails checkes SetExtraDetails and GetExtraDetails.
 func TestSetAndGetExtraDetails(t *testing.T) {
+	ctx := t.Context()
+
 	for _, dbType := range databaseList {
 
 		db, connError := ConnectForTesting(dbType)
@@ -919,13 +1026,13 @@ func TestSetAndGetExtraDetails(t *testing.T) {
 		defer db.Rollback()
 		defer db.CloseAndDelete()
 
-		u, ue := CreateUser(db)
+		u, ue := CreateUser(ctx, db)
 		if ue != nil {
 			// This ahould never fail.
 			t.Fatal(ue)
 		}
 
-		toi, toie := db.GetInterests()
+		toi, toie := db.GetInterests(ctx)
 		if toie != nil {
 			t.Fatal(toie)
 		}
@@ -950,14 +1057,14 @@ func TestSetAndGetExtraDetails(t *testing.T) {
 			OtherTopicsOfInterest: "i",
 		}
 
-		saveError := db.SaveExtraDetails(&ms)
+		saveError := db.SaveExtraDetails(ctx, &ms)
 		if saveError != nil {
 			t.Error(saveError)
 			break
 		}
 
 		got := MembershipSale{UserID: u.ID}
-		fetchError := db.GetExtraDetails(&got)
+		fetchError := db.GetExtraDetails(ctx, &got)
 		if fetchError != nil {
 			t.Error(fetchError)
 			break
@@ -1079,6 +1186,7 @@ func TestGetLoginNames(t *testing.T) {
 
 // TestCreateAccounts checks CreateAccounts
 func TestCreateAccounts(t *testing.T) {
+	ctx := t.Context()
 
 	fmt.Println("TestCreateAccounts")
 
@@ -1092,7 +1200,7 @@ func TestCreateAccounts(t *testing.T) {
 			return
 		}
 
-		db.BeginTx()
+		db.BeginTx(ctx)
 
 		defer db.Rollback()
 		defer db.CloseAndDelete()
@@ -1102,25 +1210,25 @@ func TestCreateAccounts(t *testing.T) {
 			t.Error(prepError)
 		}
 
-		loginName1, e1 := CreateUuid(db.Transaction, "usr_login_name", "adm_users")
+		loginName1, e1 := CreateUuid(ctx, db.Transaction, "usr_login_name", "adm_users")
 		if e1 != nil {
 			t.Error(e1)
 			return
 		}
 
-		loginName2, e2 := CreateUuid(db.Transaction, "usr_login_name", "adm_users")
+		loginName2, e2 := CreateUuid(ctx, db.Transaction, "usr_login_name", "adm_users")
 		if e2 != nil {
 			t.Error(e2)
 			return
 		}
 
-		loginName3, e3 := CreateUuid(db.Transaction, "usr_login_name", "adm_users")
+		loginName3, e3 := CreateUuid(ctx, db.Transaction, "usr_login_name", "adm_users")
 		if e3 != nil {
 			t.Error(e3)
 			return
 		}
 
-		loginName4, e4 := CreateUuid(db.Transaction, "usr_login_name", "adm_users")
+		loginName4, e4 := CreateUuid(ctx, db.Transaction, "usr_login_name", "adm_users")
 		if e4 != nil {
 			t.Error(e4)
 			return
@@ -1205,7 +1313,7 @@ func TestCreateAccounts(t *testing.T) {
 
 		for _, td := range testData {
 
-			u1, u2, createError := db.CreateAccounts(&td.sale, td.now, td.end)
+			u1, u2, createError := db.CreateAccounts(ctx, &td.sale, td.now, td.end)
 			if createError != nil {
 				t.Errorf("%s: %s - %v", dbType, td.description, createError)
 			}
@@ -1215,7 +1323,7 @@ func TestCreateAccounts(t *testing.T) {
 			// adm_user_data record with field name 'DATE_LAST_PAID' and
 			// containing the given time in the format 'YYYY-MM-DD'
 
-			user1, fetchUser1Error := db.GetUser(u1)
+			user1, fetchUser1Error := db.GetUser(ctx, u1)
 			if fetchUser1Error != nil {
 				t.Errorf("%s: %s - %v", dbType, td.description, fetchUser1Error)
 			}
@@ -1240,7 +1348,7 @@ func TestCreateAccounts(t *testing.T) {
 				t.Errorf("%s: want valid", td.description)
 			}
 
-			member1, fetchMemberError := db.GetMemberOfUser(user1)
+			member1, fetchMemberError := db.GetMemberOfUser(ctx, user1)
 			if fetchMemberError != nil {
 				t.Error(fetchMemberError)
 			}
@@ -1282,7 +1390,7 @@ func TestCreateAccounts(t *testing.T) {
 
 			if td.sale.AssocUserID != 0 {
 
-				user2, fetchUser2Error := db.GetUser(u2)
+				user2, fetchUser2Error := db.GetUser(ctx, u2)
 
 				if fetchUser2Error != nil {
 					t.Errorf("%s: %v", td.description, fetchUser2Error)
@@ -1315,6 +1423,7 @@ func TestCreateAccounts(t *testing.T) {
 
 // TestGetFieldID tests GetFieldID.
 func TestGetFieldID(t *testing.T) {
+	ctx := t.Context()
 
 	for _, dbType := range databaseList {
 
@@ -1325,7 +1434,7 @@ func TestGetFieldID(t *testing.T) {
 			return
 		}
 
-		db.BeginTx()
+		db.BeginTx(ctx)
 
 		defer db.Rollback()
 		defer db.CloseAndDelete()
@@ -1347,7 +1456,7 @@ func TestGetFieldID(t *testing.T) {
 
 			for _, td := range testData {
 
-				gotID, gotErr := db.GetUserDataFieldIDByNameIntern(td.fieldName)
+				gotID, gotErr := db.GetUserDataFieldIDByNameIntern(ctx, td.fieldName)
 
 				if gotErr != nil {
 					t.Error(gotErr)
@@ -1363,6 +1472,7 @@ func TestGetFieldID(t *testing.T) {
 
 // TestGetGiftaidID checks getGiftaidID.
 func TestGetGiftaidID(t *testing.T) {
+	ctx := t.Context()
 
 	for _, dbType := range databaseList {
 
@@ -1373,7 +1483,7 @@ func TestGetGiftaidID(t *testing.T) {
 			return
 		}
 
-		db.BeginTx()
+		db.BeginTx(ctx)
 
 		defer db.Rollback()
 		defer db.CloseAndDelete()
@@ -1383,7 +1493,7 @@ func TestGetGiftaidID(t *testing.T) {
 			t.Error(prepError)
 		}
 
-		gotID, gotErr := db.GetUserDataFieldIDByNameIntern("GIFT_AID")
+		gotID, gotErr := db.GetUserDataFieldIDByNameIntern(ctx, "GIFT_AID")
 
 		if gotErr != nil {
 			t.Error(db.Config.Type + ": " + gotErr.Error())
@@ -1395,196 +1505,10 @@ func TestGetGiftaidID(t *testing.T) {
 	}
 }
 
-// TestMemberExists checks MemberExists.
-func TestMemberExists(t *testing.T) {
-	fmt.Println("TestMemberExists")
-
-	for _, dbType := range databaseList {
-
-		db, connError := OpenDBForTesting(dbType)
-
-		if connError != nil {
-			t.Error(connError)
-			continue
-		}
-
-		db.BeginTx()
-
-		defer db.Rollback()
-		defer db.CloseAndDelete()
-
-		prepError := PrepareTestTables(db)
-		if prepError != nil {
-			t.Error(prepError)
-			continue
-		}
-
-		user, _, wantTitle, wantFirstName, wantLastName, umError := createTestUserEtc(db)
-		if umError != nil {
-			t.Errorf("%s: %v", dbType, umError)
-			continue
-		}
-
-		emID, emError :=
-			db.GetUserDataFieldIDByNameIntern("EMAIL")
-		if emError != nil {
-			t.Error(emError)
-			continue
-		}
-
-		email, emailError := GetUserDataField[string](db, emID, user.ID)
-		if emailError != nil {
-			t.Error(emailError)
-			continue
-		}
-
-		if email != user.LoginName {
-			t.Errorf("want %s got %s", user.LoginName, email)
-			continue
-		}
-
-		it, te := db.GetUserDataFieldIDByNameIntern("SALUTATION")
-		if te != nil {
-			t.Error(te)
-			continue
-		}
-
-		tt, tError := GetUserDataField[string](db, it, user.ID)
-		if tError != nil {
-			t.Error(tError)
-			continue
-		}
-
-		if tt != wantTitle {
-			t.Errorf("want %s got %s", wantTitle, tt)
-			continue
-		}
-
-		ifn, fne := db.GetUserDataFieldIDByNameIntern("FIRST_NAME")
-		if fne != nil {
-			t.Error(fne)
-			continue
-		}
-
-		fn, fnError := GetUserDataField[string](db, ifn, user.ID)
-		if fnError != nil {
-			t.Error(fnError)
-			continue
-		}
-
-		if fn != wantFirstName {
-			t.Errorf("want %s got %s", wantFirstName, fn)
-			continue
-		}
-
-		iln, lne := db.GetUserDataFieldIDByNameIntern("LAST_NAME")
-		if lne != nil {
-			t.Error(lne)
-			continue
-		}
-
-		ln, lnError := GetUserDataField[string](db, iln, user.ID)
-		if lnError != nil {
-			t.Error(lnError)
-			continue
-		}
-
-		if ln != wantLastName {
-			t.Errorf("want %s got %s", wantLastName, ln)
-			continue
-		}
-
-		id, searchErr := db.GetUserIDofMember(fn, ln, user.LoginName)
-		if searchErr != nil {
-			t.Error(db.Config.Type + " - expected member to exist")
-			continue
-		}
-
-		if id <= 0 {
-			t.Errorf("want id > 0 got %d", id)
-			continue
-		}
-
-		if id != user.ID {
-			t.Errorf("want %d got %d", user.ID, id)
-			continue
-		}
-	}
-}
-
-// TestMemberExistsDetailed tests MemberExists thoroughly.
-func TestMemberExistsDetailed(t *testing.T) {
-
-	for _, dbType := range databaseList {
-		db, connError := OpenDBForTesting(dbType)
-
-		if connError != nil {
-			t.Error(connError)
-			return
-		}
-
-		txError := db.BeginTx()
-		if txError != nil {
-			t.Error(txError)
-			return
-		}
-		defer db.Rollback()
-		defer db.CloseAndDelete()
-
-		prepError := PrepareTestTables(db)
-		if prepError != nil {
-			t.Error(prepError)
-		}
-
-		user, _, tl, fn, ln, ue := createTestUserEtc(db)
-		if ue != nil {
-			t.Errorf("%s: %v", dbType, ue)
-		}
-
-		var testData = []struct {
-			description string
-			title       string
-			firstName   string
-			lastName    string
-			email       string
-			shouldWork  bool
-		}{
-			{"no match", "junk", "junk", "junk", "junk", false},
-			{"all match", tl, fn, ln, user.LoginName, true},
-			{"email matches", "junk", "junk", "more junk", user.LoginName, true},
-			{"names match", tl, fn, ln, "junk", true},
-		}
-
-		for _, td := range testData {
-
-			id, searchError := db.GetUserIDofMember(td.firstName, td.lastName, td.email)
-			if td.shouldWork {
-				if searchError != nil {
-					t.Error(searchError)
-				}
-				if id <= 0 {
-					t.Errorf("%s want id > 0 got %d", td.description, id)
-				}
-			} else {
-				// if db.Config.Type == "postgres" {
-				// 	// we expect the call to fail with an error.
-				// 	if searchError == nil {
-				// 		t.Errorf("%s: want an error", td.description)
-				// 	}
-				// } else {
-				// Using sqlite we expect the query to return no rows and
-				// the call to return an ID of zero.
-				if id != 0 {
-					t.Errorf("%s: want ID 0 (no match), got %d", td.description, id)
-				}
-				//}
-			}
-		}
-	}
-}
-
-// TestSetLastPayment checks SetLastPayment.
+// TestSetLastPayment checks that SetProfileField sets the value of the last
+// payment.
 func TestSetLastPayment(t *testing.T) {
+	ctx := t.Context()
 
 	for _, dbType := range databaseList {
 
@@ -1595,7 +1519,7 @@ func TestSetLastPayment(t *testing.T) {
 			return
 		}
 
-		txError := db.BeginTx()
+		txError := db.BeginTx(ctx)
 		if txError != nil {
 			t.Error(txError)
 			return
@@ -1608,7 +1532,7 @@ func TestSetLastPayment(t *testing.T) {
 			t.Error(prepError)
 		}
 
-		user, _, _, _, _, umError := createTestUserEtc(db)
+		user, _, _, _, _, umError := createTestUserEtc(ctx, db)
 		if umError != nil {
 			em := fmt.Sprintf("%s: %v", db.Config.Type, umError)
 			t.Error(em)
@@ -1621,19 +1545,19 @@ func TestSetLastPayment(t *testing.T) {
 		// 	return
 		// }
 
-		setError := db.SetLastPayment(user.ID, 2.5)
+		setError := SetProfileField(ctx, db, user.ID, "VALUE_OF_LAST_PAYMENT", 2.5)
 		if setError != nil {
 			t.Error(setError)
 			continue
 		}
 
-		fieldID, fieldError := db.GetUserDataFieldIDByNameIntern("VALUE_OF_LAST_PAYMENT")
+		fieldID, fieldError := db.GetUserDataFieldIDByNameIntern(ctx, "VALUE_OF_LAST_PAYMENT")
 		if fieldError != nil {
 			t.Error(fieldError)
 			continue
 		}
 
-		p, getError := GetUserDataField[float64](db, fieldID, user.ID)
+		p, getError := GetUserDataField[float64](ctx, db, fieldID, user.ID)
 		if getError != nil {
 			t.Error(getError)
 			continue
@@ -1646,8 +1570,10 @@ func TestSetLastPayment(t *testing.T) {
 	}
 }
 
-// TestSetLastPayment checks SetLastPayment.
+// TestSetDonationToSociety checks that SetProfileField sets the donation to
+// the society.
 func TestSetDonationToSociety(t *testing.T) {
+	ctx := t.Context()
 
 	for _, dbType := range databaseList {
 
@@ -1658,7 +1584,7 @@ func TestSetDonationToSociety(t *testing.T) {
 			return
 		}
 
-		txError := db.BeginTx()
+		txError := db.BeginTx(ctx)
 		if txError != nil {
 			t.Error(txError)
 			return
@@ -1671,25 +1597,25 @@ func TestSetDonationToSociety(t *testing.T) {
 			t.Error(prepError)
 		}
 
-		user, _, _, _, _, umError := createTestUserEtc(db)
+		user, _, _, _, _, umError := createTestUserEtc(ctx, db)
 		if umError != nil {
 			em := fmt.Sprintf("%s: %v", db.Config.Type, umError)
 			t.Error(em)
 			return
 		}
 
-		err := db.SetDonationToSociety(user.ID, 2.5)
+		err := SetProfileField(ctx, db, user.ID, "VALUE_OF_DONATION_TO_LDLHS", 2.5)
 		if err != nil {
 			t.Error(err)
 		}
 
-		fieldID, fieldError := db.GetUserDataFieldIDByNameIntern("VALUE_OF_DONATION_TO_LDLHS")
+		fieldID, fieldError := db.GetUserDataFieldIDByNameIntern(ctx, "VALUE_OF_DONATION_TO_LDLHS")
 		if fieldError != nil {
 			t.Error(fieldError)
 			continue
 		}
 
-		p, getError := GetUserDataField[float64](db, fieldID, user.ID)
+		p, getError := GetUserDataField[float64](ctx, db, fieldID, user.ID)
 		if getError != nil {
 			t.Error(getError)
 			continue
@@ -1702,8 +1628,10 @@ func TestSetDonationToSociety(t *testing.T) {
 	}
 }
 
-// TestSetLastPayment checks SetLastPayment.
+// TestSetDonationToMuseum checks that SetProfileField sets the donation to the
+// museum.
 func TestSetDonationToMuseum(t *testing.T) {
+	ctx := t.Context()
 
 	for _, dbType := range databaseList {
 
@@ -1714,7 +1642,7 @@ func TestSetDonationToMuseum(t *testing.T) {
 			return
 		}
 
-		txError := db.BeginTx()
+		txError := db.BeginTx(ctx)
 		if txError != nil {
 			t.Error(txError)
 			return
@@ -1727,25 +1655,25 @@ func TestSetDonationToMuseum(t *testing.T) {
 			t.Error(prepError)
 		}
 
-		user, _, _, _, _, umError := createTestUserEtc(db)
+		user, _, _, _, _, umError := createTestUserEtc(ctx, db)
 		if umError != nil {
 			em := fmt.Sprintf("%s: %v", db.Config.Type, umError)
 			t.Error(em)
 			return
 		}
 
-		err := db.SetDonationToMuseum(user.ID, 2.5)
+		err := SetProfileField(ctx, db, user.ID, "VALUE_OF_DONATION_TO_THE_MUSEUM", 2.5)
 		if err != nil {
 			t.Error(err)
 		}
 
-		fieldID, fieldError := db.GetUserDataFieldIDByNameIntern("VALUE_OF_DONATION_TO_THE_MUSEUM")
+		fieldID, fieldError := db.GetUserDataFieldIDByNameIntern(ctx, "VALUE_OF_DONATION_TO_THE_MUSEUM")
 		if fieldError != nil {
 			t.Error(fieldError)
 			continue
 		}
 
-		p, getError := GetUserDataField[float64](db, fieldID, user.ID)
+		p, getError := GetUserDataField[float64](ctx, db, fieldID, user.ID)
 		if getError != nil {
 			t.Error(getError)
 			continue
@@ -1759,6 +1687,7 @@ func TestSetDonationToMuseum(t *testing.T) {
 }
 
 func TestCreateUuid(t *testing.T) {
+	ctx := t.Context()
 
 	for _, dbType := range databaseList {
 
@@ -1769,7 +1698,7 @@ func TestCreateUuid(t *testing.T) {
 			return
 		}
 
-		txError := db.BeginTx()
+		txError := db.BeginTx(ctx)
 
 		if txError != nil {
 			t.Error(txError)
@@ -1784,7 +1713,7 @@ func TestCreateUuid(t *testing.T) {
 			t.Error(prepError)
 		}
 
-		uid, uidError := CreateUuid(db.Transaction, "rol_uuid", "adm_roles")
+		uid, uidError := CreateUuid(ctx, db.Transaction, "rol_uuid", "adm_roles")
 		if uidError != nil {
 			t.Error(uidError)
 			continue
@@ -1799,6 +1728,7 @@ func TestCreateUuid(t *testing.T) {
 
 // TestSetUserDataField checks the SetUserDataField function.
 func TestSetUserDataField(t *testing.T) {
+	ctx := t.Context()
 
 	for _, dbType := range databaseList {
 
@@ -1812,7 +1742,7 @@ func TestSetUserDataField(t *testing.T) {
 		defer db.Rollback()
 		defer db.CloseAndDelete()
 
-		user, cuError := CreateUser(db)
+		user, cuError := CreateUser(ctx, db)
 		if cuError != nil {
 			t.Error(cuError)
 			continue
@@ -1841,13 +1771,13 @@ func TestSetUserDataField(t *testing.T) {
 		// them all later.
 		for _, td := range testData {
 
-			fieldID, fetchError := db.GetUserDataFieldIDByNameIntern(td.FieldName)
+			fieldID, fetchError := db.GetUserDataFieldIDByNameIntern(ctx, td.FieldName)
 			if fetchError != nil {
 				t.Errorf("cannot find adm_user_field %s", td.FieldName)
 				continue
 			}
 
-			setError := SetUserDataField(db, fieldID, user.ID, td.Want)
+			setError := SetUserDataField(ctx, db, fieldID, user.ID, td.Want)
 			if setError != nil {
 				t.Error(setError)
 				continue
@@ -1857,13 +1787,13 @@ func TestSetUserDataField(t *testing.T) {
 
 		for _, td := range testData {
 
-			fieldID, ide := db.GetUserDataFieldIDByNameIntern(td.FieldName)
+			fieldID, ide := db.GetUserDataFieldIDByNameIntern(ctx, td.FieldName)
 			if ide != nil {
 				t.Error(ide)
 				continue
 			}
 
-			got, getError := GetUserDataField[string](db, fieldID, user.ID)
+			got, getError := GetUserDataField[string](ctx, db, fieldID, user.ID)
 			if getError != nil {
 				t.Error(getError)
 				continue
@@ -1877,8 +1807,10 @@ func TestSetUserDataField(t *testing.T) {
 	}
 }
 
-// TestSetMembersAtAddress checks SetMembersAtAddress.
+// TestSetMembersAtAddress checks that SetProfileField sets the number of
+// members at the address.
 func TestSetMembersAtAddress(t *testing.T) {
+	ctx := t.Context()
 
 	for _, dbType := range databaseList {
 
@@ -1889,7 +1821,7 @@ func TestSetMembersAtAddress(t *testing.T) {
 			break
 		}
 
-		txError := db.BeginTx()
+		txError := db.BeginTx(ctx)
 		if txError != nil {
 			t.Errorf("%s: %v", dbType, txError)
 			break
@@ -1902,14 +1834,14 @@ func TestSetMembersAtAddress(t *testing.T) {
 			t.Error(prepError)
 		}
 
-		user, _, _, _, _, umError := createTestUserEtc(db)
+		user, _, _, _, _, umError := createTestUserEtc(ctx, db)
 		if umError != nil {
 			t.Error(umError)
 			return
 		}
 
 		const want = 5
-		setError := db.SetMembersAtAddress(user.ID, want)
+		setError := SetProfileField(ctx, db, user.ID, "MEMBERS_AT_ADDRESS", want)
 		if setError != nil {
 			t.Errorf("%s: %v", dbType, setError)
 			break
@@ -1921,14 +1853,14 @@ func TestSetMembersAtAddress(t *testing.T) {
 			AND usd_usf_id = $2
 		`
 
-		id, fetchIDError := db.GetUserDataFieldIDByNameIntern("MEMBERS_AT_ADDRESS")
+		id, fetchIDError := db.GetUserDataFieldIDByNameIntern(ctx, "MEMBERS_AT_ADDRESS")
 		if fetchIDError != nil {
 			t.Errorf("%s: %v", dbType, fetchIDError)
 			break
 		}
 
 		var got int
-		err := db.QueryRow(sql, user.ID, id).Scan(&got)
+		err := db.QueryRow(ctx, sql, user.ID, id).Scan(&got)
 		if err != nil {
 			t.Errorf("%s %v", dbType, err)
 			break
@@ -1941,8 +1873,10 @@ func TestSetMembersAtAddress(t *testing.T) {
 	}
 }
 
-// TestSetDateLastPaid checks SetDateLastPaid.
-func TestSetDateLastPaid(t *testing.T) {
+// TestSetDateFieldInUserData checks SetDateFieldInUserData.
+func TestSetDateFieldInUserData(t *testing.T) {
+	ctx := t.Context()
+
 	for _, dbType := range databaseList {
 
 		db, connError := OpenDBForTesting(dbType)
@@ -1952,7 +1886,7 @@ func TestSetDateLastPaid(t *testing.T) {
 			break
 		}
 
-		txError := db.BeginTx()
+		txError := db.BeginTx(ctx)
 		if txError != nil {
 			t.Error(txError)
 			return
@@ -1965,7 +1899,7 @@ func TestSetDateLastPaid(t *testing.T) {
 			t.Error(prepError)
 		}
 
-		user, _, _, _, _, umError := createTestUserEtc(db)
+		user, _, _, _, _, umError := createTestUserEtc(ctx, db)
 		if umError != nil {
 			t.Error(umError)
 			return
@@ -1974,7 +1908,13 @@ func TestSetDateLastPaid(t *testing.T) {
 		london, _ := time.LoadLocation("Europe/London")
 		tm := time.Date(2024, time.February, 14, 1, 2, 3, 4, london)
 
-		setError := db.SetDateLastPaid(user.ID, tm)
+		id, fieldError := db.GetUserDataFieldIDByNameIntern(ctx, "DATE_LAST_PAID")
+		if fieldError != nil {
+			t.Errorf("%s: %v", dbType, fieldError)
+			break
+		}
+
+		setError := db.SetDateFieldInUserData(ctx, id, user.ID, tm)
 		if setError != nil {
 			t.Errorf("%s: %v", dbType, setError)
 			break
@@ -1986,14 +1926,8 @@ func TestSetDateLastPaid(t *testing.T) {
 				where usd_usr_id = $1
 				AND usd_usf_id = $2`
 
-		id, fieldError := db.GetUserDataFieldIDByNameIntern("DATE_LAST_PAID")
-		if fieldError != nil {
-			t.Errorf("%s: %v", dbType, fieldError)
-			break
-		}
-
 		var got string
-		queryAndScanError := db.QueryRow(sqlCommand, user.ID, id).Scan(&got)
+		queryAndScanError := db.QueryRow(ctx, sqlCommand, user.ID, id).Scan(&got)
 		if queryAndScanError != nil {
 			t.Errorf("%s: %v", dbType, queryAndScanError)
 			break
@@ -2010,8 +1944,11 @@ func TestSetDateLastPaid(t *testing.T) {
 	}
 }
 
-// TestSetFriendField checks SetFriendField.
+// TestSetFriendField checks that SetProfileField sets the friend of the museum
+// field.
 func TestSetFriendField(t *testing.T) {
+	ctx := t.Context()
+
 	for _, dbType := range databaseList {
 
 		db, connError := OpenDBForTesting(dbType)
@@ -2021,7 +1958,7 @@ func TestSetFriendField(t *testing.T) {
 			return
 		}
 
-		txError := db.BeginTx()
+		txError := db.BeginTx(ctx)
 		if txError != nil {
 			t.Error(txError)
 			return
@@ -2034,13 +1971,13 @@ func TestSetFriendField(t *testing.T) {
 			t.Error(prepError)
 		}
 
-		user, _, _, _, _, umError := createTestUserEtc(db)
+		user, _, _, _, _, umError := createTestUserEtc(ctx, db)
 		if umError != nil {
 			t.Error(umError)
 			return
 		}
 
-		err := db.SetFriendField(user.ID, true)
+		err := SetProfileField(ctx, db, user.ID, "FRIEND_OF_THE_MUSEUM", true)
 		if err != nil {
 			t.Error(err)
 		}
@@ -2049,6 +1986,7 @@ func TestSetFriendField(t *testing.T) {
 
 // TestSetMemberEndDate checks SetMemberEnddate
 func TestSetMemberEndDate(t *testing.T) {
+	ctx := t.Context()
 
 	for _, dbType := range databaseList {
 
@@ -2059,7 +1997,7 @@ func TestSetMemberEndDate(t *testing.T) {
 			return
 		}
 
-		txError := db.BeginTx()
+		txError := db.BeginTx(ctx)
 		if txError != nil {
 			t.Error(txError)
 			continue
@@ -2073,18 +2011,18 @@ func TestSetMemberEndDate(t *testing.T) {
 			continue
 		}
 
-		user, _, _, _, _, ue := createTestUserEtc(db)
+		user, _, _, _, _, ue := createTestUserEtc(ctx, db)
 		if ue != nil {
 			t.Errorf("%s: %v", dbType, ue)
 		}
 
 		// Set the member's end date to 2025 and check it.
-		setError := db.SetMemberEndDate(user.ID, 2025)
+		setError := db.SetMemberEndDate(ctx, user.ID, 2025)
 		if setError != nil {
 			t.Error(dbType + ": " + setError.Error())
 			return
 		}
-		checkError2 := checkMemberEndYear(db, user.ID, 2025)
+		checkError2 := checkMemberEndYear(ctx, db, user.ID, 2025)
 		if checkError2 != nil {
 			t.Error(dbType + ": " + checkError2.Error())
 			return
@@ -2092,8 +2030,10 @@ func TestSetMemberEndDate(t *testing.T) {
 	}
 }
 
-// TestSetGiftaid checks the SetGiftaid method.
+// TestSetGiftaid checks that SetProfileField and GetProfileField set and get
+// the giftaid field.
 func TestSetGiftaid(t *testing.T) {
+	ctx := t.Context()
 
 	for _, dbType := range databaseList {
 		db, connError := OpenDBForTesting(dbType)
@@ -2103,7 +2043,7 @@ func TestSetGiftaid(t *testing.T) {
 			return
 		}
 
-		txError := db.BeginTx()
+		txError := db.BeginTx(ctx)
 		if txError != nil {
 			t.Error(txError)
 			return
@@ -2116,12 +2056,12 @@ func TestSetGiftaid(t *testing.T) {
 			t.Error(prepError)
 		}
 
-		giftaidID, fetchIDErr := db.GetUserDataFieldIDByNameIntern("GIFT_AID")
+		giftaidID, fetchIDErr := db.GetUserDataFieldIDByNameIntern(ctx, "GIFT_AID")
 		if fetchIDErr != nil {
 			t.Errorf("%s: %v", dbType, fetchIDErr)
 		}
 
-		user, _, _, _, _, ue := createTestUserEtc(db)
+		user, _, _, _, _, ue := createTestUserEtc(ctx, db)
 		if ue != nil {
 			t.Errorf("%s: %v", dbType, ue)
 		}
@@ -2132,21 +2072,21 @@ func TestSetGiftaid(t *testing.T) {
 			WHERE usd_usr_id = $1
 			AND usd_usf_id = $2;
 		`
-		_, execError := db.Exec(deleteGiftaidCMD, user.ID, giftaidID)
+		_, execError := db.Exec(ctx, deleteGiftaidCMD, user.ID, giftaidID)
 		if execError != nil {
 			t.Errorf("%s: %v", db.Config.Type, execError)
 			break
 		}
 
 		// Create a giftaid field set to true.
-		createErr1 := db.SetGiftaid(user.ID, true)
+		createErr1 := SetProfileField(ctx, db, user.ID, "GIFT_AID", true)
 		if createErr1 != nil {
 			t.Errorf("%s: %v", db.Config.Type, createErr1)
 			break
 		}
 
 		// Check the field - should be true.
-		got1, err1 := db.GetGiftaid(user.ID)
+		got1, err1 := GetProfileField[bool](ctx, db, user.ID, "GIFT_AID")
 
 		if err1 != nil {
 			t.Error(err1)
@@ -2158,12 +2098,12 @@ func TestSetGiftaid(t *testing.T) {
 		}
 
 		// Update the giftaid field to false.
-		createErr := db.SetGiftaid(user.ID, false)
+		createErr := SetProfileField(ctx, db, user.ID, "GIFT_AID", false)
 		if createErr != nil {
 			t.Errorf("%s: %v", db.Config.Type, createErr)
 		}
 
-		got2, err2 := db.GetGiftaid(user.ID)
+		got2, err2 := GetProfileField[bool](ctx, db, user.ID, "GIFT_AID")
 
 		if err2 != nil {
 			t.Error(err2)
@@ -2178,6 +2118,7 @@ func TestSetGiftaid(t *testing.T) {
 }
 
 func TestMembershipSale(t *testing.T) {
+	ctx := t.Context()
 
 	for _, dbType := range databaseList {
 		db, connError := OpenDBForTesting(dbType)
@@ -2187,7 +2128,7 @@ func TestMembershipSale(t *testing.T) {
 			return
 		}
 
-		txError := db.BeginTx()
+		txError := db.BeginTx(ctx)
 		if txError != nil {
 			t.Error(txError)
 			return
@@ -2200,13 +2141,13 @@ func TestMembershipSale(t *testing.T) {
 			t.Error(prepError)
 		}
 
-		user, _, _, _, _, ue := createTestUserEtc(db)
+		user, _, _, _, _, ue := createTestUserEtc(ctx, db)
 		if ue != nil {
 			t.Errorf("%s: %v", dbType, ue)
 			continue
 		}
 
-		assoc, _, _, _, _, ae := createTestUserEtc(db)
+		assoc, _, _, _, _, ae := createTestUserEtc(ctx, db)
 		if ae != nil {
 			t.Errorf("%s: %v", dbType, ue)
 			continue
@@ -2408,7 +2349,7 @@ func TestMembershipSale(t *testing.T) {
 		}
 
 		for _, td := range testData {
-			id, createError := td.input.Create(db)
+			id, createError := td.input.Create(ctx, db)
 			if createError != nil {
 				t.Errorf("%s: %s %v", dbType, td.description, createError)
 				continue
@@ -2423,7 +2364,7 @@ func TestMembershipSale(t *testing.T) {
 				continue
 			}
 
-			got, fetchError := db.GetMembershipSale(id)
+			got, fetchError := db.GetMembershipSale(ctx, id)
 			if fetchError != nil {
 				t.Errorf("%s %s - %v", dbType, td.description, fetchError)
 				continue
@@ -2448,13 +2389,13 @@ func TestMembershipSale(t *testing.T) {
 			got.PaymentStatus = wantPaymentStatus
 
 			// If the user ID is set then we can update
-			updateError := got.Update(db)
+			updateError := got.Update(ctx, db)
 			if updateError != nil {
 				t.Errorf("%s %s: %v", dbType, td.description, updateError)
 				break
 			}
 
-			updatedMS, fetchError := db.GetMembershipSale(got.ID)
+			updatedMS, fetchError := db.GetMembershipSale(ctx, got.ID)
 			if fetchError != nil {
 				t.Errorf("%s %s: %v", dbType, td.description, fetchError)
 				break
@@ -2469,7 +2410,7 @@ func TestMembershipSale(t *testing.T) {
 
 			savedID := got.ID
 
-			deleteError := got.Delete(db)
+			deleteError := got.Delete(ctx, db)
 			if deleteError != nil {
 				t.Errorf("%s %s - %v", dbType, td.description, deleteError)
 				break
@@ -2481,7 +2422,7 @@ func TestMembershipSale(t *testing.T) {
 			}
 
 			// This should fail and ms should be nil.
-			ms, expectedError := db.GetMembershipSale(savedID)
+			ms, expectedError := db.GetMembershipSale(ctx, savedID)
 
 			if expectedError == nil {
 				t.Errorf("%s %s: expected an error", dbType, td.description)
@@ -2499,6 +2440,7 @@ func TestMembershipSale(t *testing.T) {
 // TestMembershipSaleUpdateFailsWithUnknownID checks that a membeship sale
 // update fails when the ID does not match anything in the database.
 func TestMembershipSaleUpdateFailsWithUnknownID(t *testing.T) {
+	ctx := t.Context()
 
 	for _, dbType := range databaseList {
 		db, connError := OpenDBForTesting(dbType)
@@ -2508,7 +2450,7 @@ func TestMembershipSaleUpdateFailsWithUnknownID(t *testing.T) {
 			continue
 		}
 
-		txError := db.BeginTx()
+		txError := db.BeginTx(ctx)
 		if txError != nil {
 			t.Error(txError)
 			continue
@@ -2522,7 +2464,7 @@ func TestMembershipSaleUpdateFailsWithUnknownID(t *testing.T) {
 			continue
 		}
 
-		user, _, _, _, _, ue := createTestUserEtc(db)
+		user, _, _, _, _, ue := createTestUserEtc(ctx, db)
 		if ue != nil {
 			t.Errorf("%s: %v", dbType, ue)
 			continue
@@ -2538,7 +2480,7 @@ func TestMembershipSaleUpdateFailsWithUnknownID(t *testing.T) {
 			AssocFriend: false, AssocFriendFeePaid: 0.0,
 		}
 
-		id, createError := sale.Create(db)
+		id, createError := sale.Create(ctx, db)
 		if createError != nil {
 			t.Errorf("%s - %v", dbType, createError)
 			continue
@@ -2553,7 +2495,7 @@ func TestMembershipSaleUpdateFailsWithUnknownID(t *testing.T) {
 		sale.ID++
 
 		// Expect the update to fail.
-		err := sale.Update(db)
+		err := sale.Update(ctx, db)
 
 		if err == nil {
 			t.Error("expected an error")
@@ -2567,16 +2509,16 @@ func TestMembershipSaleUpdateFailsWithUnknownID(t *testing.T) {
 // checkMemberEndYear is a helper function that checks that the year in
 // the given user's member end date in the given database matches the
 // given year.  It works for sqlite and postgres.
-func checkMemberEndYear(db *Database, userID int64, targetYear int) error {
+func checkMemberEndYear(ctx context.Context, db *Database, userID int64, targetYear int) error {
 
 	// To prepare, set the user's end date to a year other than the
 	// target, fetch it back and check it.
 
 	startingYear := targetYear - 1
 
-	db.SetMemberEndDate(userID, startingYear)
+	db.SetMemberEndDate(ctx, userID, startingYear)
 
-	gotYear1, err1 := db.GetMembershipYearOfUser(userID)
+	gotYear1, err1 := db.GetMembershipYearOfUser(ctx, userID)
 
 	if err1 != nil {
 		return err1
@@ -2588,8 +2530,8 @@ func checkMemberEndYear(db *Database, userID int64, targetYear int) error {
 	}
 
 	// To test, set the year to the given year and check.
-	db.SetMemberEndDate(userID, targetYear)
-	gotYear2, err2 := db.GetMembershipYearOfUser(userID)
+	db.SetMemberEndDate(ctx, userID, targetYear)
+	gotYear2, err2 := db.GetMembershipYearOfUser(ctx, userID)
 
 	if err2 != nil {
 		return err2
@@ -2606,6 +2548,7 @@ func checkMemberEndYear(db *Database, userID int64, targetYear int) error {
 
 // TestSetTimeFieldInUserData check SetTimeFieldInUserData.
 func TestSetTimeFieldInUserData(t *testing.T) {
+	ctx := t.Context()
 
 	for _, dbType := range databaseList {
 
@@ -2616,7 +2559,7 @@ func TestSetTimeFieldInUserData(t *testing.T) {
 			continue
 		}
 
-		txError := db.BeginTx()
+		txError := db.BeginTx(ctx)
 		if txError != nil {
 			t.Error(txError)
 			continue
@@ -2630,13 +2573,13 @@ func TestSetTimeFieldInUserData(t *testing.T) {
 			continue
 		}
 
-		fieldID, fieldError := db.GetUserDataFieldIDByNameIntern("DATE_LAST_PAID")
+		fieldID, fieldError := db.GetUserDataFieldIDByNameIntern(ctx, "DATE_LAST_PAID")
 		if fieldError != nil {
 			t.Error(fieldError)
 			continue
 		}
 
-		user, _, _, _, _, ue := createTestUserEtc(db)
+		user, _, _, _, _, ue := createTestUserEtc(ctx, db)
 		if ue != nil {
 			t.Errorf("%s: %v", dbType, ue)
 		}
@@ -2654,7 +2597,7 @@ func TestSetTimeFieldInUserData(t *testing.T) {
 			want = wantPostgres
 		}
 
-		setError := db.SetTimeFieldInUserData(fieldID, user.ID, d)
+		setError := db.SetTimeFieldInUserData(ctx, fieldID, user.ID, d)
 
 		if setError != nil {
 			t.Error(setError)
@@ -2668,7 +2611,7 @@ func TestSetTimeFieldInUserData(t *testing.T) {
 			and usd_usf_id = $2;
 		`
 		var got string
-		fetchError := db.QueryRow(sql, user.ID, fieldID).Scan(&got)
+		fetchError := db.QueryRow(ctx, sql, user.ID, fieldID).Scan(&got)
 		if fetchError != nil {
 			t.Error(fetchError)
 		}
@@ -2681,6 +2624,7 @@ func TestSetTimeFieldInUserData(t *testing.T) {
 }
 
 func TestCreateCategoryAndGetCategory(t *testing.T) {
+	ctx := t.Context()
 
 	for _, dbType := range databaseList {
 
@@ -2691,7 +2635,7 @@ func TestCreateCategoryAndGetCategory(t *testing.T) {
 			continue
 		}
 
-		txError := db.BeginTx()
+		txError := db.BeginTx(ctx)
 		if txError != nil {
 			t.Error(txError)
 			continue
@@ -2733,13 +2677,13 @@ func TestCreateCategoryAndGetCategory(t *testing.T) {
 		}
 
 		for _, td := range testData {
-			createCatError := db.CreateCategory(td.Cat)
+			createCatError := db.CreateCategory(ctx, td.Cat)
 			if createCatError != nil {
 				t.Errorf("%s: %s %v", td.Description, dbType, createCatError)
 				continue
 			}
 
-			got, fetchCatError := db.GetCategory(td.Cat.ID)
+			got, fetchCatError := db.GetCategory(ctx, td.Cat.ID)
 			if fetchCatError != nil {
 				t.Errorf("%s: %s %v", td.Description, dbType, fetchCatError)
 				continue
@@ -2851,14 +2795,14 @@ func TestGetUserName(t *testing.T) {
 }
 
 // CreateUser creates a user for testing.
-func CreateUser(db *Database) (*User, error) {
+func CreateUser(ctx context.Context, db *Database) (*User, error) {
 	//  Create a user.
-	loginName, e1 := CreateUuid(db.Transaction, "usr_login_name", "adm_users")
+	loginName, e1 := CreateUuid(ctx, db.Transaction, "usr_login_name", "adm_users")
 	if e1 != nil {
 		return nil, e1
 	}
 	user := NewUser(loginName)
-	e2 := db.CreateUser(user)
+	e2 := db.CreateUser(ctx, user)
 	if e2 != nil {
 		return nil, e2
 	}
@@ -2868,37 +2812,253 @@ func CreateUser(db *Database) (*User, error) {
 
 // CreateTestUserEtc is a helper that creates a user with member
 // and adm_user_data records.
-func createTestUserEtc(db *Database) (*User, *Member, string, string, string, error) {
+func createTestUserEtc(ctx context.Context, db *Database) (*User, *Member, string, string, string, error) {
 	startTime := time.Date(1970, time.January, 1, 0, 0, 0, 0, time.UTC)
 	endTime := time.Date(2025, time.December, 31, 0, 0, 0, 0, time.UTC)
 
-	roleMember, re := db.GetRole(RoleNameMember)
+	roleMember, re := db.GetRole(ctx, RoleNameMember)
 	if re != nil {
 		return nil, nil, "", "", "", re
 	}
 	// Use different names each time to avoid clashes in the postgres
 	// database.
 
-	email, e1 := CreateUuid(db.Transaction, "usr_login_name", "adm_users")
+	email, e1 := CreateUuid(ctx, db.Transaction, "usr_login_name", "adm_users")
 	if e1 != nil {
 		return nil, nil, "", "", "", e1
 	}
 
-	title, e2 := CreateUuid(db.Transaction, "usd_value", "adm_user_data")
+	title, e2 := CreateUuid(ctx, db.Transaction, "usd_value", "adm_user_data")
 	if e2 != nil {
 		return nil, nil, "", "", "", e2
 	}
 
-	firstName, e3 := CreateUuid(db.Transaction, "usd_value", "adm_user_data")
+	firstName, e3 := CreateUuid(ctx, db.Transaction, "usd_value", "adm_user_data")
 	if e3 != nil {
 		return nil, nil, "", "", "", e3
 	}
 
-	lastName, e4 := CreateUuid(db.Transaction, "usd_value", "adm_user_data")
+	lastName, e4 := CreateUuid(ctx, db.Transaction, "usd_value", "adm_user_data")
 	if e4 != nil {
 		return nil, nil, "", "", "", e4
 	}
-	user, member, umError := db.CreateUserAndMember(email, title, firstName, lastName, roleMember, startTime, endTime)
+	user, member, umError := db.CreateUserAndMember(ctx, email, title, firstName, lastName, roleMember, startTime, endTime)
 
 	return user, member, title, firstName, lastName, umError
 }
+
+// TestGetMembershipSalesOfUser checks that GetMembershipSalesOfUser finds the
+// sales in which the user is the ordinary or the associate member, newest first.
+func TestGetMembershipSalesOfUser(t *testing.T) {
+	ctx := t.Context()
+
+	for _, dbType := range databaseList {
+
+		db, connError := OpenDBForTesting(dbType)
+		if connError != nil {
+			t.Error(connError)
+			continue
+		}
+
+		db.BeginTx(ctx)
+
+		defer db.Rollback()
+		defer db.CloseAndDelete()
+
+		prepError := PrepareTestTables(db)
+		if prepError != nil {
+			t.Errorf("%s: %v", dbType, prepError)
+			continue
+		}
+
+		user, cue := CreateUser(ctx, db)
+		if cue != nil {
+			t.Errorf("%s: %v", dbType, cue)
+			continue
+		}
+
+		other, coe := CreateUser(ctx, db)
+		if coe != nil {
+			t.Errorf("%s: %v", dbType, coe)
+			continue
+		}
+
+		sales := []MembershipSale{
+			{PaymentService: "Stripe", PaymentStatus: PaymentStatusComplete,
+				MembershipYear: 2024, UserID: user.ID,
+				FirstName: "a", LastName: "b", Email: "c"},
+			{PaymentService: "Stripe", PaymentStatus: PaymentStatusComplete,
+				MembershipYear: 2024, UserID: other.ID,
+				FirstName: "d", LastName: "e", Email: "f"},
+			{PaymentService: "Stripe", PaymentStatus: PaymentStatusComplete,
+				MembershipYear: 2025, UserID: other.ID, AssocUserID: user.ID,
+				FirstName: "d", LastName: "e", Email: "f"},
+		}
+
+		for i := range sales {
+			_, createError := sales[i].Create(ctx, db)
+			if createError != nil {
+				t.Errorf("%s: %v", dbType, createError)
+			}
+		}
+
+		got, fetchError := db.GetMembershipSalesOfUser(ctx, user.ID)
+		if fetchError != nil {
+			t.Errorf("%s: %v", dbType, fetchError)
+			continue
+		}
+
+		if len(got) != 2 {
+			t.Errorf("%s: want 2 sales got %d", dbType, len(got))
+			continue
+		}
+
+		if got[0].ID != sales[2].ID || got[1].ID != sales[0].ID {
+			t.Errorf("%s: want sales %d and %d got %d and %d",
+				dbType, sales[2].ID, sales[0].ID, got[0].ID, got[1].ID)
+		}
+
+		none, noneError := db.GetMembershipSalesOfUser(ctx, user.ID+other.ID)
+		if noneError != nil {
+			t.Errorf("%s: %v", dbType, noneError)
+			continue
+		}
+
+		if len(none) != 0 {
+			t.Errorf("%s: want no sales got %d", dbType, len(none))
+		}
+	}
+}
+
+// TestRenewMemberships checks that RenewMemberships gives ordinary members,
+// associate members and friends memberships of their own roles, each with
+// its own dates.
+func TestRenewMemberships(t *testing.T) {
+	ctx := t.Context()
+
+	for _, dbType := range databaseList {
+
+		db, connError := ConnectForTesting(dbType)
+		if connError != nil {
+			t.Error(connError)
+			continue
+		}
+
+		defer db.Rollback()
+		defer db.CloseAndDelete()
+
+		// The config is shared by the tests, so change a copy.
+		config := *db.Config
+		config.AssociateRole = "Associate Member"
+		config.FriendRole = "Friend of the Museum"
+		db.Config = &config
+
+		start := time.Date(2024, time.October, 5, 12, 0, 0, 0, time.UTC)
+
+		// Bootstrap creates the new roles.
+		if _, err := db.Bootstrap(ctx, start); err != nil {
+			t.Errorf("%s: %v", dbType, err)
+			continue
+		}
+
+		email, _ := CreateUuid(ctx, db.Transaction, "usr_login_name", "adm_users")
+		sale := MembershipSale{
+			MembershipYear: 2025,
+			FirstName:      "Robert",
+			LastName:       "Smith",
+			Email:          email,
+			Friend:         true,
+			AssocFirstName: email,
+			AssocLastName:  "Smith",
+		}
+
+		end := time.Date(2025, time.December, 31, 0, 0, 0, 0, time.UTC)
+		userID, assocID, createError := db.CreateAccounts(ctx, &sale, start, end)
+		if createError != nil {
+			t.Errorf("%s: %v", dbType, createError)
+			continue
+		}
+
+		// roles gets the end date of each of the user's memberships, by role.
+		roles := func(userID int64) map[string]string {
+			const query = `
+				SELECT r.rol_name, m.mem_begin, m.mem_end
+				FROM adm_members AS m
+				JOIN adm_roles AS r ON r.rol_id = m.mem_rol_id
+				WHERE m.mem_usr_id = $1;
+			`
+			result := make(map[string]string)
+			rows, err := db.Query(ctx, query, userID)
+			if err != nil {
+				t.Errorf("%s: %v", dbType, err)
+				return result
+			}
+			defer rows.Close()
+			for rows.Next() {
+				var name string
+				var begin, end any
+				if err := rows.Scan(&name, &begin, &end); err != nil {
+					t.Errorf("%s: %v", dbType, err)
+				}
+				result[name] = dateOf(begin) + " to " + dateOf(end)
+			}
+			return result
+		}
+
+		renewError := db.RenewMemberships(ctx, &sale, start)
+		if renewError != nil {
+			t.Errorf("%s: %v", dbType, renewError)
+			continue
+		}
+
+		want := map[string]string{
+			RoleNameMember:         "2024-10-05 to 2025-12-31",
+			"Friend of the Museum": "2024-10-05 to 2025-12-31",
+		}
+		if got := roles(userID); !reflect.DeepEqual(want, got) {
+			t.Errorf("%s: want %v got %v", dbType, want, got)
+		}
+
+		want = map[string]string{"Associate Member": "2024-10-05 to 2025-12-31"}
+		if got := roles(assocID); !reflect.DeepEqual(want, got) {
+			t.Errorf("%s: want %v got %v", dbType, want, got)
+		}
+
+		// The next year the ordinary member stops being a friend and the
+		// associate becomes one.  The friendship of the ordinary member
+		// ends where it was.
+		sale.MembershipYear = 2026
+		sale.Friend = false
+		sale.AssocFriend = true
+		nextStart := time.Date(2025, time.November, 1, 12, 0, 0, 0, time.UTC)
+
+		renewError = db.RenewMemberships(ctx, &sale, nextStart)
+		if renewError != nil {
+			t.Errorf("%s: %v", dbType, renewError)
+			continue
+		}
+
+		want = map[string]string{
+			RoleNameMember:         "2024-10-05 to 2026-12-31",
+			"Friend of the Museum": "2024-10-05 to 2025-12-31",
+		}
+		if got := roles(userID); !reflect.DeepEqual(want, got) {
+			t.Errorf("%s: want %v got %v", dbType, want, got)
+		}
+
+		want = map[string]string{
+			"Associate Member":     "2024-10-05 to 2026-12-31",
+			"Friend of the Museum": "2025-11-01 to 2026-12-31",
+		}
+		if got := roles(assocID); !reflect.DeepEqual(want, got) {
+			t.Errorf("%s: want %v got %v", dbType, want, got)
+		}
+
+		// The membership year of the associate comes from their associate
+		// membership.
+		year, yearError := db.GetMembershipYearOfUser(ctx, assocID)
+		if yearError != nil || year != 2026 {
+			t.Errorf("%s: want 2026 got %d %v", dbType, year, yearError)
+		}
+	}
+}
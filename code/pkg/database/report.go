@@ -0,0 +1,164 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"math"
+)
+
+// SalesSummary summarises the completed membership sales for one month of a
+// membership year.  SaleYear and Month give the month in which the sales were
+// made, so the sales for a membership year may start in the previous calendar
+// year.  In the summary of the whole year they are both 0.
+type SalesSummary struct {
+	Year                int     `json:"year"`                  // The membership year.
+	SaleYear            int     `json:"sale_year"`             // The calendar year of the sales.
+	Month               int     `json:"month"`                 // The month of the sales, 1 to 12.
+	Sales               int     `json:"sales"`                 // The number of sales.
+	NewMembers          int     `json:"new_members"`           // Sales of new membership.
+	Renewals            int     `json:"renewals"`              // Sales that renew a membership.
+	Associates          int     `json:"associates"`            // The number of associate members.
+	Friends             int     `json:"friends"`               // The number of members who are also friends.
+	OrdinaryMemberFees  float64 `json:"ordinary_member_fees"`  // Total of the ordinary members' fees.
+	AssociateMemberFees float64 `json:"associate_member_fees"` // Total of the associate members' fees.
+	FriendFees          float64 `json:"friend_fees"`           // Total of the friends' fees.
+	DonationsToSociety  float64 `json:"donations_to_society"`  // Total of the donations to the society.
+	DonationsToMuseum   float64 `json:"donations_to_museum"`   // Total of the donations to the museum.
+	GiftaidSales        int     `json:"giftaid_sales"`         // The number of sales with a Gift Aid declaration.
+	GiftaidTotal        float64 `json:"giftaid_total"`         // The total paid in those sales.
+	Total               float64 `json:"total"`                 // The total paid.
+}
+
+// Add adds the counts and totals of the given summary to this one.
+func (s *SalesSummary) Add(other *SalesSummary) {
+	s.Sales += other.Sales
+	s.NewMembers += other.NewMembers
+	s.Renewals += other.Renewals
+	s.Associates += other.Associates
+	s.Friends += other.Friends
+	s.OrdinaryMemberFees += other.OrdinaryMemberFees
+	s.AssociateMemberFees += other.AssociateMemberFees
+	s.FriendFees += other.FriendFees
+	s.DonationsToSociety += other.DonationsToSociety
+	s.DonationsToMuseum += other.DonationsToMuseum
+	s.GiftaidSales += other.GiftaidSales
+	s.GiftaidTotal += other.GiftaidTotal
+	s.Total += other.Total
+}
+
+// GetSalesSummaries summarises the completed sales of the given membership
+// year by the month in which they were made.  The result has one element for
+// each month with sales, in order of date, followed by a summary of the whole
+// year (with Month 0).
+func (db *Database) GetSalesSummaries(ctx context.Context, year int) ([]SalesSummary, error) {
+
+	// %[1]s and %[2]s extract the year and the month from the creation time
+	// and %[3]s replaces a NULL value.
+	const queryTemplate = `
+		SELECT
+			%[1]s AS sale_year,
+			%[2]s AS sale_month,
+			count(*),
+			sum(CASE WHEN ms_transaction_type = $1 THEN 1 ELSE 0 END),
+			sum(CASE WHEN ms_transaction_type = $2 THEN 1 ELSE 0 END),
+			sum(CASE WHEN %[3]s(ms_usr2_first_name, '') <> '' THEN 1 ELSE 0 END),
+			sum(CASE WHEN ms_usr1_friend THEN 1 ELSE 0 END) +
+				sum(CASE WHEN ms_usr2_friend THEN 1 ELSE 0 END),
+			sum(ms_usr1_fee),
+			sum(ms_usr2_fee),
+			sum(ms_usr1_friend_fee + ms_usr2_friend_fee),
+			sum(ms_donation),
+			sum(ms_donation_museum),
+			sum(CASE WHEN ms_giftaid THEN 1 ELSE 0 END),
+			sum(CASE WHEN ms_giftaid
+				THEN ms_usr1_fee + ms_usr1_friend_fee + ms_usr2_fee +
+					ms_usr2_friend_fee + ms_donation + ms_donation_museum
+				ELSE 0 END)
+		FROM membership_sales
+		WHERE ms_membership_year = $3
+		AND ms_payment_status = $4
+		GROUP BY sale_year, sale_month
+		ORDER BY sale_year, sale_month;
+	`
+
+	var query string
+	switch db.Config.Type {
+	case "postgres":
+		query = fmt.Sprintf(queryTemplate,
+			"CAST(EXTRACT(YEAR FROM ms_timestamp_create) AS integer)",
+			"CAST(EXTRACT(MONTH FROM ms_timestamp_create) AS integer)",
+			"COALESCE")
+	case "mysql":
+		query = fmt.Sprintf(queryTemplate,
+			"YEAR(ms_timestamp_create)",
+			"MONTH(ms_timestamp_create)",
+			"IFNULL")
+	default:
+		// SQLite stores the creation time as a string "YYYY-MM-DD HH:MM:SS".
+		query = fmt.Sprintf(queryTemplate,
+			"CAST(strftime('%Y', ms_timestamp_create) AS integer)",
+			"CAST(strftime('%m', ms_timestamp_create) AS integer)",
+			"IFNULL")
+	}
+
+	rows, searchError := db.Query(ctx, query, TransactionTypeNewMember, TransactionTypeRenewal,
+		year, PaymentStatusComplete)
+	if searchError != nil {
+		return nil, searchError
+	}
+	defer rows.Close()
+
+	yearSummary := SalesSummary{Year: year}
+
+	result := make([]SalesSummary, 0, 13)
+	for rows.Next() {
+		s := SalesSummary{Year: year}
+		scanError := rows.Scan(
+			&s.SaleYear,
+			&s.Month,
+			&s.Sales,
+			&s.NewMembers,
+			&s.Renewals,
+			&s.Associates,
+			&s.Friends,
+			&s.OrdinaryMemberFees,
+			&s.AssociateMemberFees,
+			&s.FriendFees,
+			&s.DonationsToSociety,
+			&s.DonationsToMuseum,
+			&s.GiftaidSales,
+			&s.GiftaidTotal,
+		)
+		if scanError != nil {
+			return nil, scanError
+		}
+
+		s.Total = s.OrdinaryMemberFees + s.AssociateMemberFees + s.FriendFees +
+			s.DonationsToSociety + s.DonationsToMuseum
+		s.round()
+
+		result = append(result, s)
+		yearSummary.Add(&s)
+	}
+
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+
+	yearSummary.round()
+	result = append(result, yearSummary)
+
+	return result, nil
+}
+
+// round rounds the amounts in the summary to two decimal places.  Postgres REAL
+// values are single precision and adding up floating point values produces
+// rounding errors.
+func (s *SalesSummary) round() {
+	for _, amount := range []*float64{
+		&s.OrdinaryMemberFees, &s.AssociateMemberFees, &s.FriendFees,
+		&s.DonationsToSociety, &s.DonationsToMuseum, &s.GiftaidTotal, &s.Total,
+	} {
+		*amount = math.Round(*amount*100) / 100
+	}
+}
@@ -0,0 +1,132 @@
+package database
+
+import (
+	"testing"
+)
+
+// TestGetSalesSummaries checks that GetSalesSummaries counts and totals the
+// completed sales of a membership year by month.
+func TestGetSalesSummaries(t *testing.T) {
+	ctx := t.Context()
+
+	for _, dbType := range databaseList {
+
+		db, connError := OpenDBForTesting(dbType)
+		if connError != nil {
+			t.Error(connError)
+			continue
+		}
+
+		db.BeginTx(ctx)
+
+		defer db.Rollback()
+		defer db.CloseAndDelete()
+
+		prepError := PrepareTestTables(db)
+		if prepError != nil {
+			t.Errorf("%s: %v", dbType, prepError)
+			continue
+		}
+
+		// Use a membership year that won't have any sales already.
+		const year = 1901
+
+		sales := []struct {
+			sale    MembershipSale
+			created string
+		}{
+			// November of the previous year - a new member with an associate.
+			{MembershipSale{PaymentService: "Stripe", PaymentStatus: PaymentStatusComplete,
+				TransactionType: TransactionTypeNewMember, MembershipYear: year,
+				FirstName: "a", LastName: "b", Email: "c",
+				OrdinaryMemberFeePaid: 24, Friend: true, FriendFeePaid: 5,
+				AssocFirstName: "d", AssocLastName: "b", AssocFeePaid: 6,
+				AssocFriend: true, AssocFriendFeePaid: 5,
+				DonationToSociety: 1.5, DonationToMuseum: 2.25, Giftaid: true},
+				"1900-11-15 10:00:00"},
+			// January - two renewals, one with Gift Aid.
+			{MembershipSale{PaymentService: "Stripe", PaymentStatus: PaymentStatusComplete,
+				TransactionType: TransactionTypeRenewal, MembershipYear: year,
+				FirstName: "a", LastName: "b", Email: "c",
+				OrdinaryMemberFeePaid: 24, Giftaid: true},
+				"1901-01-02 10:00:00"},
+			{MembershipSale{PaymentService: "Stripe", PaymentStatus: PaymentStatusComplete,
+				TransactionType: TransactionTypeRenewal, MembershipYear: year,
+				FirstName: "a", LastName: "b", Email: "c",
+				OrdinaryMemberFeePaid: 24, DonationToSociety: 0.1},
+				"1901-01-31 10:00:00"},
+			// Not complete, so not counted.
+			{MembershipSale{PaymentService: "Stripe", PaymentStatus: PaymentStatusPending,
+				TransactionType: TransactionTypeRenewal, MembershipYear: year,
+				FirstName: "a", LastName: "b", Email: "c",
+				OrdinaryMemberFeePaid: 24},
+				"1901-01-31 10:00:00"},
+			// Another year, so not counted.
+			{MembershipSale{PaymentService: "Stripe", PaymentStatus: PaymentStatusComplete,
+				TransactionType: TransactionTypeRenewal, MembershipYear: year + 1,
+				FirstName: "a", LastName: "b", Email: "c",
+				OrdinaryMemberFeePaid: 24},
+				"1901-12-31 10:00:00"},
+		}
+
+		for i := range sales {
+			id, createError := sales[i].sale.Create(ctx, db)
+			if createError != nil {
+				t.Errorf("%s: %v", dbType, createError)
+				continue
+			}
+
+			const setCreated = `
+				UPDATE membership_sales
+				SET ms_timestamp_create = $1
+				WHERE ms_id = $2;
+			`
+			_, updateError := db.Exec(ctx, setCreated, sales[i].created, id)
+			if updateError != nil {
+				t.Errorf("%s: %v", dbType, updateError)
+			}
+		}
+
+		want := []SalesSummary{
+			{Year: year, SaleYear: year - 1, Month: 11, Sales: 1, NewMembers: 1, Associates: 1, Friends: 2,
+				OrdinaryMemberFees: 24, AssociateMemberFees: 6, FriendFees: 10,
+				DonationsToSociety: 1.5, DonationsToMuseum: 2.25,
+				GiftaidSales: 1, GiftaidTotal: 43.75, Total: 43.75},
+			{Year: year, SaleYear: year, Month: 1, Sales: 2, Renewals: 2,
+				OrdinaryMemberFees: 48, DonationsToSociety: 0.1,
+				GiftaidSales: 1, GiftaidTotal: 24, Total: 48.1},
+			{Year: year, Month: 0, Sales: 3, NewMembers: 1, Renewals: 2, Associates: 1, Friends: 2,
+				OrdinaryMemberFees: 72, AssociateMemberFees: 6, FriendFees: 10,
+				DonationsToSociety: 1.6, DonationsToMuseum: 2.25,
+				GiftaidSales: 2, GiftaidTotal: 67.75, Total: 91.85},
+		}
+
+		got, reportError := db.GetSalesSummaries(ctx, year)
+		if reportError != nil {
+			t.Errorf("%s: %v", dbType, reportError)
+			continue
+		}
+
+		if len(got) != len(want) {
+			t.Errorf("%s: want %d summaries got %d - %v", dbType, len(want), len(got), got)
+			continue
+		}
+
+		for i := range want {
+			if got[i] != want[i] {
+				t.Errorf("%s: want\n%+v\ngot\n%+v", dbType, want[i], got[i])
+			}
+		}
+
+		// A year with no sales produces just the empty year summary.
+		empty, emptyError := db.GetSalesSummaries(ctx, year-1)
+		if emptyError != nil {
+			t.Errorf("%s: %v", dbType, emptyError)
+			continue
+		}
+
+		if len(empty) != 1 || empty[0] != (SalesSummary{Year: year - 1}) {
+			t.Errorf("%s: want an empty year summary got %v", dbType, empty)
+		}
+	}
+}
@@ -0,0 +1,208 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// The queries in this package are written for the tables of Admidio 4.3 (see
+// admidio.4.3.14.schema.sql) with Admidio's default table prefix "adm".  An
+// installation can choose another prefix (g_tbl_praefix in Admidio's
+// config.php) and another release of Admidio may rename tables or columns, so
+// each query is adapted just before it runs - the prefix of the table names is
+// replaced with the configured one and the names are changed to suit the
+// release.  DetectAdmidioVersion reads the release from the database and
+// chooses the matching QuerySet.  It fails if there is none, rather than
+// letting the queries fail later.
+
+// DefaultTablePrefix is Admidio's default table prefix, the one that the
+// queries are written with.
+const DefaultTablePrefix = "adm"
+
+// QuerySet describes the Admidio releases that the queries work with and how
+// the queries are adapted to them.
+type QuerySet struct {
+	Name     string            // The name for messages, for example "Admidio 4.3".
+	Versions []string          // The releases, major and minor version, for example "4.3".
+	Renames  map[string]string // The names in the 4.3 schema that the releases call something else.
+
+	renamesRegexp *regexp.Regexp // Matches the names in Renames.
+	compileOnce   sync.Once      // Creates renamesRegexp.
+}
+
+// QuerySets are the sets of queries for the Admidio releases that the
+// application supports.  The first is the one that the queries are written
+// for.  To support another release, add a QuerySet giving any names that it
+// changed.
+var QuerySets = []*QuerySet{
+	{Name: "Admidio 4.3", Versions: []string{"4.3"}},
+}
+
+// regExpForTablePrefix matches the default table prefix at the start of a
+// name.
+var regExpForTablePrefix = regexp.MustCompile(`\badm_`)
+
+// regExpForValidTablePrefix matches a table prefix that's safe to put into a
+// query.
+var regExpForValidTablePrefix = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
+// supports is true if the query set is for the release with the given
+// version, for example "4.3.14".
+func (qs *QuerySet) supports(version string) bool {
+	parts := strings.Split(strings.TrimSpace(version), ".")
+	if len(parts) < 2 {
+		return false
+	}
+	majorMinor := parts[0] + "." + parts[1]
+	for _, v := range qs.Versions {
+		if v == majorMinor {
+			return true
+		}
+	}
+	return false
+}
+
+// rename changes the names in the query that the query set renames.
+func (qs *QuerySet) rename(query string) string {
+
+	if len(qs.Renames) == 0 {
+		return query
+	}
+
+	qs.compileOnce.Do(func() {
+		names := make([]string, 0, len(qs.Renames))
+		for name := range qs.Renames {
+			names = append(names, regexp.QuoteMeta(name))
+		}
+		// Try the longest names first, so that a name isn't matched by
+		// another that it starts with.
+		sort.Slice(names, func(i, j int) bool { return len(names[i]) > len(names[j]) })
+		qs.renamesRegexp = regexp.MustCompile(`\b(` + strings.Join(names, "|") + `)\b`)
+	})
+
+	return qs.renamesRegexp.ReplaceAllStringFunc(query, func(name string) string {
+		return qs.Renames[name]
+	})
+}
+
+// tablePrefix gives the prefix of Admidio's table names, without the
+// underscore that follows it.
+func (db *Database) tablePrefix() string {
+	if len(db.Config.TablePrefix) == 0 {
+		return DefaultTablePrefix
+	}
+	return db.Config.TablePrefix
+}
+
+// querySet gives the query set chosen by DetectAdmidioVersion, or the one that
+// the queries are written for if there has been no detection.
+func (db *Database) querySet() *QuerySet {
+	if db.queries == nil {
+		return QuerySets[0]
+	}
+	return db.queries
+}
+
+// adapt adapts a query, or just a table name, written for the tables of
+// Admidio 4.3 with the default table prefix to the configured table prefix
+// and the chosen query set.  Only the SQL text is changed - string literals,
+// quoted names and comments are left alone (see sqlTokens).
+func (db *Database) adapt(query string) string {
+
+	qs := db.querySet()
+	prefix := db.tablePrefix()
+	if len(qs.Renames) == 0 && prefix == DefaultTablePrefix {
+		return query
+	}
+
+	var b strings.Builder
+	for _, t := range sqlTokens(query, db.Config.Type == "mysql") {
+		if t.kind != sqlText {
+			b.WriteString(t.text)
+			continue
+		}
+
+		// The renames are given with the default prefix, so they come first.
+		text := qs.rename(t.text)
+		if prefix != DefaultTablePrefix {
+			text = regExpForTablePrefix.ReplaceAllLiteralString(text, prefix+"_")
+		}
+		b.WriteString(text)
+	}
+
+	return b.String()
+}
+
+// checkTablePrefix returns an error if the configured table prefix isn't
+// safe to put into a query.
+func (db *Database) checkTablePrefix() error {
+	if !regExpForValidTablePrefix.MatchString(db.tablePrefix()) {
+		em := fmt.Sprintf("illegal table prefix %q - it may only contain letters, digits and underscores",
+			db.Config.TablePrefix)
+		return errors.New(em)
+	}
+	return nil
+}
+
+// DetectAdmidioVersion reads the version of Admidio from the database, for
+// example "4.3.14", chooses the matching query set for the queries that run
+// on the db object and returns the version.  It returns an error if the
+// Admidio tables can't be found with the configured prefix or the version
+// isn't supported.
+// It's assumed that a transaction is already set up in the db object.
+func (db *Database) DetectAdmidioVersion(ctx context.Context) (string, error) {
+
+	const fn = "DetectAdmidioVersion"
+
+	found, existsError := db.tableExists(ctx, "adm_components")
+	if existsError != nil {
+		em := fmt.Sprintf("%s: %v", fn, existsError)
+		return "", errors.New(em)
+	}
+	if !found {
+		em := fmt.Sprintf("%s: there is no table %s - is Admidio installed and is the table prefix %q right?",
+			fn, db.adapt("adm_components"), db.tablePrefix())
+		return "", errors.New(em)
+	}
+
+	const query = `
+		SELECT com_version
+		FROM adm_components
+		WHERE com_type = 'SYSTEM'
+		AND com_name_intern = 'CORE';
+	`
+
+	var version string
+	searchError := db.QueryRow(ctx, query).Scan(&version)
+	if searchError != nil {
+		if searchError == sql.ErrNoRows {
+			em := fmt.Sprintf("%s: %s does not give the version of Admidio", fn, db.adapt("adm_components"))
+			return "", errors.New(em)
+		}
+		em := fmt.Sprintf("%s: %v", fn, searchError)
+		return "", errors.New(em)
+	}
+
+	for _, qs := range QuerySets {
+		if qs.supports(version) {
+			db.queries = qs
+			// Success!
+			return version, nil
+		}
+	}
+
+	supported := make([]string, 0, len(QuerySets))
+	for _, qs := range QuerySets {
+		supported = append(supported, qs.Name)
+	}
+
+	em := fmt.Sprintf("%s: Admidio version %s is not supported - this application works with %s",
+		fn, version, strings.Join(supported, ", "))
+	return "", errors.New(em)
+}
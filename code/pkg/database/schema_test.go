@@ -0,0 +1,263 @@
+package database
+
+import (
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// TestAdapt checks that adapt replaces the default table prefix with the
+// configured one and applies the renames of the query set.
+func TestAdapt(t *testing.T) {
+
+	renamed := QuerySet{
+		Name:     "renamed",
+		Versions: []string{"9.9"},
+		Renames:  map[string]string{"usr_login_name": "usr_login", "adm_user_log": "adm_user_history"},
+	}
+
+	var testData = []struct {
+		description string
+		prefix      string
+		querySet    *QuerySet
+		query       string
+		want        string
+	}{
+		{
+			"default prefix", "", nil,
+			"SELECT usr_id FROM adm_users WHERE usr_login_name = $1;",
+			"SELECT usr_id FROM adm_users WHERE usr_login_name = $1;",
+		},
+		{
+			"another prefix", "soc", nil,
+			"SELECT m.mem_id FROM adm_members AS m JOIN adm_roles AS r ON r.rol_id = m.mem_rol_id;",
+			"SELECT m.mem_id FROM soc_members AS m JOIN soc_roles AS r ON r.rol_id = m.mem_rol_id;",
+		},
+		{
+			"only at the start of a name", "soc", nil,
+			"CONSTRAINT adm_fk_x REFERENCES adm_users (usr_id), mcl_adm_x",
+			"CONSTRAINT soc_fk_x REFERENCES soc_users (usr_id), mcl_adm_x",
+		},
+		{
+			"just a table name", "soc", nil,
+			"adm_users",
+			"soc_users",
+		},
+		{
+			"renames", "soc", &renamed,
+			"SELECT usr_login_name, usr_login_name_x FROM adm_users, adm_user_log;",
+			"SELECT usr_login, usr_login_name_x FROM soc_users, soc_user_history;",
+		},
+		{
+			"not in a string", "soc", &renamed,
+			"UPDATE adm_users SET usr_text = 'adm_users usr_login_name' WHERE usr_id = $1;",
+			"UPDATE soc_users SET usr_text = 'adm_users usr_login_name' WHERE usr_id = $1;",
+		},
+		{
+			"not in a comment", "soc", &renamed,
+			"-- adm_users usr_login_name\nSELECT usr_login_name /* adm_roles */ FROM adm_users;",
+			"-- adm_users usr_login_name\nSELECT usr_login /* adm_roles */ FROM soc_users;",
+		},
+	}
+
+	for _, td := range testData {
+		db := New(&DBConfig{Type: "sqlite", TablePrefix: td.prefix})
+		db.queries = td.querySet
+		got := db.adapt(td.query)
+		if td.want != got {
+			t.Errorf("%s: want %s got %s", td.description, td.want, got)
+		}
+	}
+}
+
+// TestQuerySetSupports checks that a query set supports the releases with its
+// major and minor versions.
+func TestQuerySetSupports(t *testing.T) {
+
+	var testData = []struct {
+		version string
+		want    bool
+	}{
+		{"4.3.14", true},
+		{"4.3.0", true},
+		{" 4.3.1 ", true},
+		{"4.3", true},
+		{"4.2.9", false},
+		{"4.30.1", false},
+		{"5.0.0", false},
+		{"4", false},
+		{"", false},
+	}
+
+	for _, td := range testData {
+		got := QuerySets[0].supports(td.version)
+		if td.want != got {
+			t.Errorf("%s: want %v got %v", td.version, td.want, got)
+		}
+	}
+}
+
+// TestCheckTablePrefix checks that a table prefix that isn't safe to put into
+// a query is rejected.
+func TestCheckTablePrefix(t *testing.T) {
+
+	var testData = []struct {
+		prefix    string
+		wantError bool
+	}{
+		{"", false},
+		{"adm", false},
+		{"my_site2", false},
+		{"adm; DROP TABLE x", true},
+		{"a-b", true},
+	}
+
+	for _, td := range testData {
+		db := New(&DBConfig{Type: "sqlite", TablePrefix: td.prefix})
+		err := db.checkTablePrefix()
+		if td.wantError != (err != nil) {
+			t.Errorf("%q: want error %v got %v", td.prefix, td.wantError, err)
+		}
+	}
+}
+
+// TestDetectAdmidioVersion checks that DetectAdmidioVersion accepts the
+// supported versions of Admidio and fails clearly for the others.
+func TestDetectAdmidioVersion(t *testing.T) {
+	ctx := t.Context()
+
+	for _, dbType := range databaseList {
+
+		db, connError := ConnectForTesting(dbType)
+		if connError != nil {
+			t.Error(connError)
+			continue
+		}
+
+		defer db.Rollback()
+		defer db.CloseAndDelete()
+
+		version, versionError := db.DetectAdmidioVersion(ctx)
+		if versionError != nil {
+			t.Errorf("%s: %v", dbType, versionError)
+			continue
+		}
+		if !strings.HasPrefix(version, "4.3.") {
+			t.Errorf("%s: want 4.3.x got %s", dbType, version)
+		}
+		if db.querySet() != QuerySets[0] {
+			t.Errorf("%s: want %s got %s", dbType, QuerySets[0].Name, db.querySet().Name)
+		}
+
+		const setVersion = `
+			UPDATE adm_components
+			SET com_version = $1
+			WHERE com_type = 'SYSTEM'
+			AND com_name_intern = 'CORE';
+		`
+		if _, err := db.Exec(ctx, setVersion, "5.0.2"); err != nil {
+			t.Errorf("%s: %v", dbType, err)
+			continue
+		}
+
+		_, versionError = db.DetectAdmidioVersion(ctx)
+		if versionError == nil {
+			t.Errorf("%s: want an error for version 5.0.2", dbType)
+		} else if !strings.Contains(versionError.Error(), "Admidio version 5.0.2 is not supported") {
+			t.Errorf("%s: want a clear error got %v", dbType, versionError)
+		}
+
+		// With the wrong table prefix, the Admidio tables can't be found.
+		config := *db.Config
+		config.TablePrefix = "nosuch"
+		db.Config = &config
+
+		_, versionError = db.DetectAdmidioVersion(ctx)
+		if versionError == nil || !strings.Contains(versionError.Error(), "there is no table nosuch_components") {
+			t.Errorf("%s: want a missing table error got %v", dbType, versionError)
+		}
+	}
+}
+
+// TestTablePrefix checks that the application works with an Admidio database
+// whose tables have another prefix.  Only SQLite can create such a database
+// for the test.
+func TestTablePrefix(t *testing.T) {
+	ctx := t.Context()
+
+	if !slices.Contains(databaseList, "sqlite") {
+		t.Skip("needs SQLite")
+	}
+
+	config := DBConfigForTestingWithSQLite
+	config.TablePrefix = "soc"
+	db := New(&config)
+	if err := db.ConnectForTestingWithSQLite(); err != nil {
+		t.Fatal(err)
+	}
+	defer db.CloseAndDelete()
+
+	if err := db.BeginTx(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Rollback()
+
+	// The test tables are created through the same adaptation as the
+	// queries, so they get the prefix too.
+	if err := PrepareTestTables(db); err != nil {
+		t.Fatal(err)
+	}
+
+	// Query and tableExists adapt the table names, so ask the catalogue
+	// directly.
+	const countTables = `SELECT count(*) FROM sqlite_master WHERE type = 'table' AND name = ?;`
+	for _, name := range []string{"soc_users", "soc_members", "soc_user_data", "soc_interests", "membership_sales", "adm_users"} {
+		var n int
+		if err := db.Transaction.QueryRowContext(ctx, countTables, name).Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		want := 1
+		if name == "adm_users" {
+			want = 0
+		}
+		if n != want {
+			t.Errorf("%s: want %d got %d", name, want, n)
+		}
+	}
+
+	if _, err := db.DetectAdmidioVersion(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	user := NewUser("prefix.test")
+	if err := db.CreateUser(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := SetProfileField(ctx, db, user.ID, "CITY", "Leatherhead"); err != nil {
+		t.Fatal(err)
+	}
+
+	city, cityError := GetProfileField[string](ctx, db, user.ID, "CITY")
+	if cityError != nil {
+		t.Fatal(cityError)
+	}
+	if city != "Leatherhead" {
+		t.Errorf("want Leatherhead got %s", city)
+	}
+
+	// A pool detects the version of Admidio when it opens and fails if the
+	// tables can't be found.
+	path := filepath.Join(t.TempDir(), "admidio.db")
+	conn, createError := ConnectToSQLite(SQLiteConnectionDetails(path, true))
+	if createError != nil {
+		t.Fatal(createError)
+	}
+	conn.Close()
+
+	poolConfig := DBConfig{Type: "sqlite", Path: path, Logger: config.Logger, TablePrefix: "soc"}
+	if _, err := OpenPool(&poolConfig); err == nil {
+		t.Error("want an error from OpenPool")
+	}
+}
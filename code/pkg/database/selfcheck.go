@@ -0,0 +1,191 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// CheckSchema checks that the database has everything that the application
+// needs - the user fields in ProfileFields, the roles given to the types of
+// membership (see DBConfig.RoleName), the system user that changes are
+// attributed to and the tables created by the migrations.  It returns a
+// description of each problem, so an empty list means that all is well.  It's
+// assumed that a transaction is already set up in the db object.  The caller
+// should roll it back.
+func (db *Database) CheckSchema(ctx context.Context) ([]string, error) {
+
+	const fn = "CheckSchema"
+
+	problems := make([]string, 0)
+
+	// The application's own tables.  Checking the migrations creates the
+	// schema_migrations table if necessary.
+	statuses, statusError := db.MigrationStatuses(ctx)
+	if statusError != nil {
+		em := fmt.Sprintf("%s: %v", fn, statusError)
+		return nil, errors.New(em)
+	}
+
+	for _, s := range statuses {
+		if !s.Applied {
+			problems = append(problems,
+				fmt.Sprintf("migration %04d_%s has not been applied", s.Version, s.Name))
+		}
+	}
+
+	for _, roleName := range db.Config.RoleNames(MembershipTypes...) {
+		_, roleError := db.GetRole(ctx, roleName)
+		switch {
+		case roleError == sql.ErrNoRows:
+			problems = append(problems, fmt.Sprintf("there is no role %q", roleName))
+		case roleError != nil:
+			em := fmt.Sprintf("%s: %v", fn, roleError)
+			return nil, errors.New(em)
+		}
+	}
+
+	systemUsers, systemUserError := db.GetUsersByLoginName(ctx, db.systemUserName())
+	if systemUserError != nil {
+		em := fmt.Sprintf("%s: %v", fn, systemUserError)
+		return nil, errors.New(em)
+	}
+	if len(systemUsers) == 0 {
+		problems = append(problems, fmt.Sprintf("there is no system user %q", db.systemUserName()))
+	}
+
+	for _, f := range ProfileFields {
+		_, fieldError := db.GetUserDataFieldByNameIntern(ctx, f.NameIntern)
+		switch {
+		case fieldError == sql.ErrNoRows:
+			problems = append(problems, fmt.Sprintf("there is no user field %s", f.NameIntern))
+		case fieldError != nil:
+			em := fmt.Sprintf("%s: field %s: %v", fn, f.NameIntern, fieldError)
+			return nil, errors.New(em)
+		}
+	}
+
+	return problems, nil
+}
+
+// Bootstrap sets up whatever CheckSchema finds missing.  It applies the
+// pending migrations, creates the roles given to the types of membership in
+// the COMMON category and creates the missing user fields in the BASIC_DATA
+// category, all owned by the System user.  It returns a description of each thing that it did.  The
+// Admidio categories and the System user must already exist.  It's assumed
+// that a transaction is already set up in the db object.  The caller should
+// commit it.
+func (db *Database) Bootstrap(ctx context.Context, now time.Time) ([]string, error) {
+
+	const fn = "Bootstrap"
+
+	done := make([]string, 0)
+
+	migrations, migrateError := db.MigrateUp(ctx, now)
+	if migrateError != nil {
+		em := fmt.Sprintf("%s: %v", fn, migrateError)
+		return nil, errors.New(em)
+	}
+	for _, m := range migrations {
+		done = append(done, fmt.Sprintf("applied migration %04d_%s", m.Version, m.Name))
+	}
+
+	users, userError := db.GetUsersByLoginName(ctx, db.systemUserName())
+	if userError != nil {
+		em := fmt.Sprintf("%s: %v", fn, userError)
+		return nil, errors.New(em)
+	}
+	if len(users) == 0 {
+		em := fmt.Sprintf("%s: there is no system user %q", fn, db.systemUserName())
+		return nil, errors.New(em)
+	}
+	systemUser := &users[0]
+
+	for _, roleName := range db.Config.RoleNames(MembershipTypes...) {
+		_, roleError := db.GetRole(ctx, roleName)
+		switch {
+		case roleError == sql.ErrNoRows:
+			catCommon, catError := db.GetCategoryByNameIntern(ctx, "COMMON")
+			if catError != nil {
+				em := fmt.Sprintf("%s: category COMMON: %v", fn, catError)
+				return nil, errors.New(em)
+			}
+
+			// CreateRole records the creator of the category as the creator of
+			// the role.
+			if catCommon.CreateUser == nil {
+				catCommon.CreateUser = systemUser
+			}
+
+			role := NewRole(roleName, catCommon, systemUser, false, true)
+			createError := db.CreateRole(ctx, role)
+			if createError != nil {
+				em := fmt.Sprintf("%s: creating role %s: %v", fn, roleName, createError)
+				return nil, errors.New(em)
+			}
+			done = append(done, fmt.Sprintf("created role %q", roleName))
+
+		case roleError != nil:
+			em := fmt.Sprintf("%s: %v", fn, roleError)
+			return nil, errors.New(em)
+		}
+	}
+
+	catBasic, catError := db.GetCategoryByNameIntern(ctx, "BASIC_DATA")
+	if catError != nil {
+		em := fmt.Sprintf("%s: category BASIC_DATA: %v", fn, catError)
+		return nil, errors.New(em)
+	}
+
+	// New fields go at the end of the profile page.
+	sequence, sequenceError := db.maxUserFieldSequence(ctx, catBasic.ID)
+	if sequenceError != nil {
+		em := fmt.Sprintf("%s: %v", fn, sequenceError)
+		return nil, errors.New(em)
+	}
+
+	for _, f := range ProfileFields {
+		_, fieldError := db.GetUserDataFieldByNameIntern(ctx, f.NameIntern)
+		if fieldError == nil {
+			continue
+		}
+		if fieldError != sql.ErrNoRows {
+			em := fmt.Sprintf("%s: field %s: %v", fn, f.NameIntern, fieldError)
+			return nil, errors.New(em)
+		}
+
+		sequence++
+		uf := NewUserField(f.Name, f.NameIntern, f.Type, systemUser, catBasic)
+		uf.Sequence = sequence
+		createError := db.CreateUserField(ctx, uf)
+		if createError != nil {
+			em := fmt.Sprintf("%s: creating field %s: %v", fn, f.NameIntern, createError)
+			return nil, errors.New(em)
+		}
+		done = append(done, fmt.Sprintf("created user field %s", f.NameIntern))
+	}
+
+	// Success!
+	return done, nil
+}
+
+// maxUserFieldSequence gets the highest sequence number of the user fields in
+// the given category, or zero if there are none.
+func (db *Database) maxUserFieldSequence(ctx context.Context, categoryID int64) (int, error) {
+
+	const query = `
+		SELECT COALESCE(max(usf_sequence), 0)
+		FROM adm_user_fields
+		WHERE usf_cat_id = $1;
+	`
+
+	var sequence int
+	searchError := db.QueryRow(ctx, query, categoryID).Scan(&sequence)
+	if searchError != nil {
+		return 0, searchError
+	}
+
+	return sequence, nil
+}
@@ -0,0 +1,150 @@
+package database
+
+import (
+	"testing"
+	"time"
+)
+
+// TestCheckSchemaAndBootstrap checks that CheckSchema finds a missing user
+// field and a missing Member role and that Bootstrap creates them, and that it
+// finds a missing system user.
+func TestCheckSchemaAndBootstrap(t *testing.T) {
+	ctx := t.Context()
+
+	for _, dbType := range databaseList {
+
+		db, connError := OpenDBForTesting(dbType)
+		if connError != nil {
+			t.Error(connError)
+			continue
+		}
+
+		db.BeginTx(ctx)
+
+		defer db.Rollback()
+		defer db.CloseAndDelete()
+
+		prepError := PrepareTestTables(db)
+		if prepError != nil {
+			t.Errorf("%s: %v", dbType, prepError)
+			continue
+		}
+
+		now := time.Date(2025, time.March, 1, 12, 0, 0, 0, time.UTC)
+
+		// Apply any migrations not yet applied to a permanent database.
+		_, migrateError := db.MigrateUp(ctx, now)
+		if migrateError != nil {
+			t.Errorf("%s: %v", dbType, migrateError)
+			continue
+		}
+
+		problems, checkError := db.CheckSchema(ctx)
+		if checkError != nil {
+			t.Errorf("%s: %v", dbType, checkError)
+			continue
+		}
+
+		if len(problems) != 0 {
+			t.Errorf("%s: want no problems got %v", dbType, problems)
+		}
+
+		// Hide the gift aid field and the Member role by renaming them.  (In
+		// the postgres test database other rows refer to them, so they can't
+		// be deleted.)
+		_, fieldError := db.Exec(ctx,
+			`UPDATE adm_user_fields SET usf_name_intern = 'OLD_GIFT_AID' WHERE usf_name_intern = 'GIFT_AID';`)
+		if fieldError != nil {
+			t.Errorf("%s: %v", dbType, fieldError)
+			continue
+		}
+
+		_, roleError := db.Exec(ctx,
+			`UPDATE adm_roles SET rol_name = 'Old Member' WHERE rol_name = $1;`, RoleNameMember)
+		if roleError != nil {
+			t.Errorf("%s: %v", dbType, roleError)
+			continue
+		}
+
+		want := []string{
+			`there is no role "Member"`,
+			"there is no user field GIFT_AID",
+		}
+
+		problems, checkError = db.CheckSchema(ctx)
+		if checkError != nil {
+			t.Errorf("%s: %v", dbType, checkError)
+			continue
+		}
+
+		if len(problems) != len(want) {
+			t.Errorf("%s: want %d problems got %v", dbType, len(want), problems)
+			continue
+		}
+
+		for i := range want {
+			if want[i] != problems[i] {
+				t.Errorf("%s: want %s got %s", dbType, want[i], problems[i])
+			}
+		}
+
+		done, bootstrapError := db.Bootstrap(ctx, now)
+		if bootstrapError != nil {
+			t.Errorf("%s: %v", dbType, bootstrapError)
+			continue
+		}
+
+		wantDone := []string{
+			`created role "Member"`,
+			"created user field GIFT_AID",
+		}
+
+		if len(done) != len(wantDone) {
+			t.Errorf("%s: want %v got %v", dbType, wantDone, done)
+			continue
+		}
+
+		for i := range wantDone {
+			if wantDone[i] != done[i] {
+				t.Errorf("%s: want %s got %s", dbType, wantDone[i], done[i])
+			}
+		}
+
+		problems, checkError = db.CheckSchema(ctx)
+		if checkError != nil {
+			t.Errorf("%s: %v", dbType, checkError)
+			continue
+		}
+
+		if len(problems) != 0 {
+			t.Errorf("%s: after bootstrap want no problems got %v", dbType, problems)
+		}
+
+		field, fetchError := db.GetUserDataFieldByNameIntern(ctx, "GIFT_AID")
+		if fetchError != nil {
+			t.Errorf("%s: %v", dbType, fetchError)
+			continue
+		}
+
+		if field.Type != "CHECKBOX" {
+			t.Errorf("%s: want CHECKBOX got %s", dbType, field.Type)
+		}
+
+		// A system user that doesn't exist is a problem.  The config is
+		// shared by the tests, so change a copy.
+		config := *db.Config
+		config.SystemUser = "NoSuchUser"
+		db.Config = &config
+
+		problems, checkError = db.CheckSchema(ctx)
+		if checkError != nil {
+			t.Errorf("%s: %v", dbType, checkError)
+			continue
+		}
+
+		wantProblem := `there is no system user "NoSuchUser"`
+		if len(problems) != 1 || problems[0] != wantProblem {
+			t.Errorf("%s: want %s got %v", dbType, wantProblem, problems)
+		}
+	}
+}
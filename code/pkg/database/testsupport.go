@@ -1,11 +1,14 @@
 package database
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
 	"log/slog"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/goblimey/go-tools/dailylogger"
 
@@ -17,6 +20,7 @@ const TestInterests2 = "efgh"
 const TestInterests3 = "ijkl"
 
 var DBConfigForTestingWithPostgres DBConfig
+var DBConfigForTestingWithMySQL DBConfig
 var DBConfigForTestingWithSQLite DBConfig
 var ourOrganisation *Organisation
 var systemUser *User
@@ -36,43 +40,68 @@ func init() {
 		Pass:   "secret",
 		Logger: logger,
 	}
+	DBConfigForTestingWithMySQL = DBConfig{
+		Type:   "mysql",
+		Host:   "localhost",
+		Port:   "3306",
+		User:   "root",
+		Name:   "testdb",
+		Pass:   "secret",
+		Logger: logger,
+	}
 	DBConfigForTestingWithSQLite = DBConfig{
 		Type:   "sqlite",
 		Logger: logger,
 	}
 }
 
-// ConnectForTesting connects to a local test database.
-// creates an SQLite database in a temporary directory
-// and sets the directory name in the Database object.
+// DatabaseListForTesting gets the types of database that the tests run
+// against.  The environment variable TestDatabases can hold a comma-separated
+// list, for example "sqlite,mysql".  If it's not set, the given defaults are
+// used.
+func DatabaseListForTesting(defaults ...string) []string {
+
+	list := os.Getenv("TestDatabases")
+	if len(list) == 0 {
+		return defaults
+	}
+
+	dbTypes := make([]string, 0)
+	for _, dbType := range strings.Split(list, ",") {
+		dbType = strings.TrimSpace(dbType)
+		if len(dbType) > 0 {
+			dbTypes = append(dbTypes, dbType)
+		}
+	}
+
+	return dbTypes
+}
+
+// ConnectForTestingWithSQLite creates an SQLite database in a temporary
+// directory, connects to it and sets the directory name in the Database
+// object.  Close and CloseAndDelete remove the directory.
 func (db *Database) ConnectForTestingWithSQLite() error {
 
 	// Attempts to use an in-memory database produced random failures
 	// due to the database being closed and cleared down prematurely
 	// after various queries had run.  Instead we create a temporary
-	// directory and use a file database in that.  The caller is
-	// expected to remove the temporary directory once it's done with it.
+	// directory and use a file database in that.
 
 	tempDir, tempError := testsupport.CreateWorkingDirectory()
 	if tempError != nil {
 		return tempError
 	}
 
-	connectionDetails := "file:" + tempDir + "/sqlite.db"
+	connectionDetails := SQLiteConnectionDetails(tempDir+"/sqlite.db", true)
 
 	var connErr error
 	db.Connection, connErr = ConnectToSQLite(connectionDetails)
 	if connErr != nil {
+		testsupport.RemoveWorkingDirectory(tempDir)
 		return connErr
 	}
 	db.SQLiteTempDir = tempDir
 
-	connError := db.Connect()
-	if connError != nil {
-		slog.Error(connError.Error())
-		os.Exit(-1)
-	}
-
 	return nil
 }
 
@@ -107,6 +136,7 @@ func (db *Database) CloseAndDelete() error {
 }
 
 func ConnectForTesting(dbType string) (*Database, error) {
+	ctx := context.Background()
 
 	db, connError := OpenDBForTesting(dbType)
 
@@ -114,7 +144,7 @@ func ConnectForTesting(dbType string) (*Database, error) {
 		return nil, connError
 	}
 
-	db.BeginTx()
+	db.BeginTx(ctx)
 
 	prepError := PrepareTestTables(db)
 	if prepError != nil {
@@ -127,14 +157,22 @@ func ConnectForTesting(dbType string) (*Database, error) {
 func OpenDBForTesting(dbType string) (*Database, error) {
 
 	var db *Database
-	if dbType == "sqlite" {
+	switch dbType {
+	case "sqlite":
 		db = New(&DBConfigForTestingWithSQLite)
 		db.Config.Logger = createLoggerForTesting()
 		connError := db.ConnectForTestingWithSQLite()
 		if connError != nil {
 			return nil, connError
 		}
-	} else {
+	case "mysql":
+		db = New(&DBConfigForTestingWithMySQL)
+		db.Config.Logger = createLoggerForTesting()
+		connError := db.Connect()
+		if connError != nil {
+			return nil, connError
+		}
+	default:
 		db = New(&DBConfigForTestingWithPostgres)
 		db.Config.Logger = createLoggerForTesting()
 		connError := db.Connect()
@@ -148,11 +186,13 @@ func OpenDBForTesting(dbType string) (*Database, error) {
 
 // CreateTablesForTesting is a helper function that creates the tables
 // needed for testing.  The Postgres tables are already created so only
-// SQLite needs this.
+// SQLite needs this.  The Admidio tables are created here, a subset of
+// the real ones.  The application's own tables are created by MigrateUp.
 func CreateTablesForTesting(db *Database) error {
+	ctx := context.Background()
 
-	// The test postgres DB is permanent and the tables are created just once
-	// once manually using admidio.4.3.14.schema.sql.
+	// The test postgres and MySQL DBs are permanent and the tables are
+	// created just once, by installing Admidio.
 	// The sqlite DB is temporary, created at the start of each test and
 	// deleted at the end, so the tables are created over and over using this
 	// function.
@@ -278,6 +318,8 @@ func CreateTablesForTesting(db *Database) error {
 				usf_name_intern character varying(110) NOT NULL,
 				usf_name character varying(100) NOT NULL,
 				usf_sequence smallint NOT NULL,
+				usf_description text,
+				usf_value_list text,
 				usf_usr_id_create integer
 			);
 		`
@@ -292,7 +334,7 @@ func CreateTablesForTesting(db *Database) error {
 			usd_id INTEGER PRIMARY KEY,
 			usd_usr_id     integer NOT NULL,
 			usd_usf_id     integer NOT NULL,
-			usd_value      varchar(30) NOT NULL		
+			usd_value      varchar(30)
 			);
 		`
 
@@ -301,120 +343,66 @@ func CreateTablesForTesting(db *Database) error {
 			return createUserDataError
 		}
 
-		const createInterestsTableSQL = `
-			CREATE TABLE adm_interests (
-			ntrst_id INTEGER PRIMARY KEY NOT NULL,
-			ntrst_name character varying(50)
-		)
-		`
-
-		createInterestsError := createTableForTesting(db, createInterestsTableSQL)
-		if createInterestsError != nil {
-			return createInterestsError
+		createComponentsError := createTableForTesting(db, createComponentsTableSQL)
+		if createComponentsError != nil {
+			return createComponentsError
 		}
 
-		const createMembersInterestsTableSQL = `
-			CREATE TABLE adm_members_interests (
-				mi_id INTEGER PRIMARY KEY NOT NULL,
-				mi_usr_id INTEGER NOT NULL,
-				mi_interest_id INTEGER NOT NULL,
-				CONSTRAINT adm_interests_un_usr_interest UNIQUE (mi_usr_id, mi_interest_id)
-			);
-		`
-
-		createMembersInterestsError := createTableForTesting(db, createMembersInterestsTableSQL)
-		if createMembersInterestsError != nil {
-			return createMembersInterestsError
+		_, versionError := db.Exec(ctx, insertAdmidioVersionSQL)
+		if versionError != nil {
+			return versionError
 		}
 
-		const createMembersOtherInterestsTableSQL = `
-		CREATE TABLE adm_members_other_interests (
-			moi_id INTEGER PRIMARY KEY NOT NULL,
-			moi_usr_id INTEGER NOT NULL,
-			moi_interests character varying(200)
-		);
-	`
-
-		createMembersOtherInterestsError := createTableForTesting(db, createMembersOtherInterestsTableSQL)
-		if createMembersOtherInterestsError != nil {
-			return createMembersOtherInterestsError
-		}
-
-		const createMembershipSalesSQL = `
-			CREATE TABLE IF NOT EXISTS membership_sales (
-				ms_id INTEGER PRIMARY KEY,
-				ms_payment_service CHARACTER VARYING(36) NOT NULL,
-				ms_payment_status CHARACTER VARYING(20) NOT NULL,
-				ms_payment_id CHARACTER VARYING(200),
-				ms_transaction_type varchar(30) NOT NULL DEFAULT 'membership renewal',
-				ms_membership_year integer NOT NULL,
-				ms_usr1_id integer DEFAULT NULL,
-				ms_usr1_fee REAL NOT NULL,
-				ms_usr1_friend boolean NOT NULL DEFAULT false,
-				-- 0.0 if not a friend.
-				ms_usr1_friend_fee REAL NOT NULL default 0.0,
-				ms_usr1_title VARCHAR(50),
-				ms_usr1_first_name varchar (30),
-				ms_usr1_last_name varchar (50),
-				ms_usr1_email varchar (50),
-				-- 0 if no associate
-				ms_usr2_id integer DEFAULT NULL,
-				-- 0.0 if no associate
-				ms_usr2_fee REAL NOT NULL default 0.0,
-				-- false if no associate.
-				ms_usr2_friend boolean NOT NULL DEFAULT false,
-				-- 0.0 if no associate.
-				ms_usr2_friend_fee REAL NOT NULL DEFAULT 0.0,
-				ms_usr2_title VARCHAR(30),
-				ms_usr2_first_name varchar (50),
-				ms_usr2_last_name varchar(50),
-				ms_usr2_email varchar (50),
-				-- 0.0 if no donation.
-				ms_donation REAL NOT NULL DEFAULT 0.0,
-				-- 0.0 if no donation to museum.
-				ms_donation_museum REAL NOT NULL DEFAULT 0.0,
-				ms_giftaid boolean NOT NULL DEFAULT false,
-				ms_timestamp_create varchar(30) NOT NULL DEFAULT CURRENT_TIMESTAMP
-			);
-		`
-
-		const createCountriesTableSQL = `
-			CREATE TABLE IF NOT EXISTS adm_countries (
-				ct_id INTEGER PRIMARY KEY NOT NULL,
-				ct_code CHARACTER VARYING(3) NOT NULL,
-				ct_name CHARACTER VARYING(50) NOT NULL
-			);
-		`
-
-		createCountriesError := createTableForTesting(db, createCountriesTableSQL)
-		if createCountriesError != nil {
-			return createCountriesError
-		}
-
-		membersCreateError := createTableForTesting(db, createMembershipSalesSQL)
-		if membersCreateError != nil {
-			return membersCreateError
+		// The application's own tables are created by the same migrations
+		// as the production database.
+		_, migrateError := db.MigrateUp(ctx, time.Now())
+		if migrateError != nil {
+			return migrateError
 		}
 	}
 
 	return nil
 }
 
+// createComponentsTableSQL creates the table in which Admidio records its
+// version (see DetectAdmidioVersion).
+const createComponentsTableSQL = `
+	CREATE TABLE IF NOT EXISTS adm_components (
+		com_id INTEGER PRIMARY KEY,
+		com_type varchar(10) NOT NULL,
+		com_name varchar(255) NOT NULL,
+		com_name_intern varchar(255) NOT NULL,
+		com_version varchar(10) NOT NULL,
+		com_beta smallint NOT NULL DEFAULT 0,
+		com_update_step integer NOT NULL DEFAULT 0,
+		com_update_completed boolean NOT NULL DEFAULT true,
+		com_timestamp_installed timestamp DEFAULT CURRENT_TIMESTAMP
+	);
+`
+
+// insertAdmidioVersionSQL records the version of Admidio that the test tables
+// are taken from.
+const insertAdmidioVersionSQL = `
+	INSERT INTO adm_components (com_type, com_name, com_name_intern, com_version)
+	VALUES ('SYSTEM', 'Admidio Core', 'CORE', '4.3.14');
+`
+
 // PopulateTestTables is a helper function that loads the reference data
 // into the tables.  It only does anything if the database is SQLite - the
 // data are created for every test.  The postgres test database is
 // permanent and created manually befor testing starts.
 func PopulateTestTables(db *Database) error {
+	ctx := context.Background()
 
 	// Ensure the system user is set up.
-	u, fetchUserError := db.GetUsersByLoginName("System")
+	u, fetchUserError := db.GetUsersByLoginName(ctx, "System")
 	if fetchUserError != nil {
 		return fetchUserError
 	}
 
 	if len(u) == 0 {
 		systemUser = NewUser("System")
-		err := db.CreateUserWithNullPassword(systemUser)
+		err := db.CreateUserWithNullPassword(ctx, systemUser)
 		if err != nil {
 			return err
 		}
@@ -423,7 +411,7 @@ func PopulateTestTables(db *Database) error {
 	}
 
 	// Ensure that the organisation is set up.
-	o, fetchOrgError := db.GetOrganisationsByShortName("org")
+	o, fetchOrgError := db.GetOrganisationsByShortName(ctx, "org")
 	if fetchOrgError != nil {
 		return fetchOrgError
 	}
@@ -442,7 +430,7 @@ func PopulateTestTables(db *Database) error {
 		// Set the global pointer.
 		ourOrganisation = &org
 
-		createOrgError := db.CreateOrganisation(ourOrganisation)
+		createOrgError := db.CreateOrganisation(ctx, ourOrganisation)
 		if createOrgError != nil {
 			return createOrgError
 		}
@@ -451,7 +439,7 @@ func PopulateTestTables(db *Database) error {
 	// Ensure that the Categories are set up - "COMMON" and "BASIC_DATA".
 
 	var fetchC1Error error
-	catCommon, fetchC1Error = db.GetCategoryByNameIntern("COMMON")
+	catCommon, fetchC1Error = db.GetCategoryByNameIntern(ctx, "COMMON")
 	if fetchC1Error != nil && fetchC1Error != sql.ErrNoRows {
 		return fetchC1Error
 	}
@@ -459,14 +447,14 @@ func PopulateTestTables(db *Database) error {
 		// This category is not set up.
 		catCommon = NewCategory(ourOrganisation, "ROL", "COMMON", "SYS_COMMON", false, true, 1, systemUser)
 
-		err := db.CreateCategory(catCommon)
+		err := db.CreateCategory(ctx, catCommon)
 		if err != nil {
 			return err
 		}
 	}
 
 	var fetchC2Error error
-	catBasic, fetchC2Error = db.GetCategoryByNameIntern("BASIC_DATA")
+	catBasic, fetchC2Error = db.GetCategoryByNameIntern(ctx, "BASIC_DATA")
 	if fetchC2Error != nil && fetchC1Error != sql.ErrNoRows {
 		return fetchC2Error
 	}
@@ -474,7 +462,7 @@ func PopulateTestTables(db *Database) error {
 	if fetchC2Error == sql.ErrNoRows {
 		catBasic = NewCategory(ourOrganisation, "USF", "BASIC_DATA", "SYS_BASIC_DATA", false, true, 1, systemUser)
 
-		err := db.CreateCategory(catBasic)
+		err := db.CreateCategory(ctx, catBasic)
 		if err != nil {
 			return err
 		}
@@ -486,7 +474,7 @@ func PopulateTestTables(db *Database) error {
 
 	for _, name := range roleName {
 
-		_, fetchError := db.GetRole(name)
+		_, fetchError := db.GetRole(ctx, name)
 
 		switch {
 		case fetchError != nil && fetchError != sql.ErrNoRows:
@@ -501,7 +489,7 @@ func PopulateTestTables(db *Database) error {
 			}
 			// Roles we create are always valid.
 			role := NewRole(name, catCommon, systemUser, isAdmin, true)
-			createError := db.CreateRole(role)
+			createError := db.CreateRole(ctx, role)
 			if createError != nil {
 				return createError
 			}
@@ -549,7 +537,7 @@ func PopulateTestTables(db *Database) error {
 	// In an SQLite database, we need to create the rows for evey test.
 	for _, f := range UserFieldsForTesting {
 
-		_, fetchError := db.GetUserDataFieldByNameIntern(f.NameIntern)
+		_, fetchError := db.GetUserDataFieldByNameIntern(ctx, f.NameIntern)
 		if fetchError != nil && fetchError != sql.ErrNoRows {
 			return fetchError
 		}
@@ -557,7 +545,7 @@ func PopulateTestTables(db *Database) error {
 		if fetchError == sql.ErrNoRows {
 			// The field is not in adm_user_fields.  Add it.
 			fd := NewUserField(f.Name, f.NameIntern, f.Type, systemUser, catBasic)
-			err := db.CreateUserField(fd)
+			err := db.CreateUserField(ctx, fd)
 			if err != nil {
 				em := fmt.Sprintf("error creating field name %s - %v", f.NameIntern, err)
 				return errors.New(em)
@@ -567,17 +555,17 @@ func PopulateTestTables(db *Database) error {
 
 	const createInterestSQL = `insert into adm_interests (ntrst_name) values(?);`
 
-	_, interestError1 := db.CreateRow(createInterestSQL, TestInterests1)
+	_, interestError1 := db.Exec(ctx, createInterestSQL, TestInterests1)
 	if interestError1 != nil {
 		return interestError1
 	}
 
-	_, interestError2 := db.CreateRow(createInterestSQL, TestInterests2)
+	_, interestError2 := db.Exec(ctx, createInterestSQL, TestInterests2)
 	if interestError2 != nil {
 		return interestError2
 	}
 
-	_, interestError3 := db.CreateRow(createInterestSQL, TestInterests3)
+	_, interestError3 := db.Exec(ctx, createInterestSQL, TestInterests3)
 	if interestError3 != nil {
 		return interestError3
 	}
@@ -586,17 +574,17 @@ func PopulateTestTables(db *Database) error {
 
 	// Create the countries in reverse alphabetical order by name, so that the test
 	// can check the ORDER BY.
-	_, ce1 := db.CreateRow(createCountrySQL, "ZWE", "Zimbabwe")
+	_, ce1 := db.Exec(ctx, createCountrySQL, "ZWE", "Zimbabwe")
 	if ce1 != nil {
 		return ce1
 	}
 
-	_, ce2 := db.CreateRow(createCountrySQL, "GBR", "United Kingdom")
+	_, ce2 := db.Exec(ctx, createCountrySQL, "GBR", "United Kingdom")
 	if ce2 != nil {
 		return ce2
 	}
 
-	_, ce3 := db.CreateRow(createCountrySQL, "ABW", "Aruba")
+	_, ce3 := db.Exec(ctx, createCountrySQL, "ABW", "Aruba")
 	if ce3 != nil {
 		return ce3
 	}
@@ -605,8 +593,8 @@ func PopulateTestTables(db *Database) error {
 }
 
 func PrepareTestTables(db *Database) error {
-	// This only creates tables when the DB is sqlite.  The postgress
-	// test database is set up permanently.
+	// This only creates tables when the DB is sqlite.  The postgres and
+	// MySQL test databases are set up permanently.
 
 	if db.Config.Type == "sqlite" {
 		e := CreateTablesForTesting(db)
@@ -626,9 +614,10 @@ func PrepareTestTables(db *Database) error {
 // createTablesForTesting ensures that the test tables are set up and
 // populated with the reference data.
 func createTableForTesting(db *Database, sql string) error {
+	ctx := context.Background()
 
 	// Create the table.
-	_, createError := db.Exec(sql)
+	_, createError := db.Exec(ctx, sql)
 	if createError != nil {
 		return createError
 	}
@@ -0,0 +1,376 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Admidio stores each item of a user's profile (their name, address, tick boxes
+// and so on) as a separate row in adm_user_data, keyed on the ID of the field in
+// adm_user_fields.  Fetching the items one at a time costs two queries each, one
+// to look up the field ID and one to get the value.  GetUserData fetches all of
+// the items for one or many users in one query and SaveUserData saves them in
+// two.  The field IDs are cached (see fieldIDCache).
+
+// UserData holds the values from adm_user_data that the application uses for
+// one user.  A value that isn't set in the database is the zero value.  Each
+// field is commented with the internal name of the Admidio user field, which
+// is described in ProfileFields.
+type UserData struct {
+	UserID             int64
+	Title              string  // SALUTATION
+	FirstName          string  // FIRST_NAME
+	LastName           string  // LAST_NAME
+	Email              string  // EMAIL
+	AddressLine1       string  // STREET
+	AddressLine2       string  // ADDRESS_LINE_2
+	AddressLine3       string  // ADDRESS_LINE_3
+	Town               string  // CITY
+	County             string  // COUNTY
+	Postcode           string  // POSTCODE
+	CountryCode        string  // COUNTRY - for example "GBR".
+	Phone              string  // PHONE
+	Mobile             string  // MOBILE
+	LocationOfInterest string  // LOCATION_OF_INTEREST
+	DataProtection     bool    // DATA_PROTECTION_PERMISSION
+	EmailPermission    bool    // PERMISSION_TO_SEND_EMAILS
+	NoticesByEmail     bool    // NOTICES_BY_EMAIL
+	Friend             bool    // FRIEND_OF_THE_MUSEUM
+	Giftaid            bool    // GIFT_AID
+	LastPayment        float64 // VALUE_OF_LAST_PAYMENT
+	DonationToSociety  float64 // VALUE_OF_DONATION_TO_LDLHS
+	DonationToMuseum   float64 // VALUE_OF_DONATION_TO_THE_MUSEUM
+	DateLastPaid       string  // DATE_LAST_PAID - "YYYY-MM-DD".
+	MembersAtAddress   int     // MEMBERS_AT_ADDRESS
+	FriendsAtAddress   int     // NUMBER_OF_FRIENDS_OF_THE_MUSEUM_AT_THIS_ADDRESS
+}
+
+// UserDataFields gives the internal names of all of the fields of UserData.
+func UserDataFields() []string {
+	names := make([]string, 0, len(ProfileFields))
+	for _, f := range ProfileFields {
+		names = append(names, f.NameIntern)
+	}
+	return names
+}
+
+// GivenFields gives those of the named fields that are set in the user data -
+// text that isn't empty, a ticked box or a number that isn't zero.
+func GivenFields(ud *UserData, names []string) []string {
+	given := make([]string, 0, len(names))
+	for _, name := range names {
+		field, found := findProfileField(name)
+		if found && field.isSet(ud) {
+			given = append(given, name)
+		}
+	}
+	return given
+}
+
+// userDataBatchSize is the most users that GetUserData fetches in one query.
+// Larger requests are split so that the query stays within the limits that
+// the databases place on the number of parameters.
+const userDataBatchSize = 500
+
+// GetUserData gets the values from adm_user_data for each of the given users.
+// The result has an entry for every user, even if they have nothing set.  The
+// users are fetched in one query (or one for each 500 users).
+// It's assumed that a transaction is already set up in the db object.
+func (db *Database) GetUserData(ctx context.Context, userIDs ...int64) (map[int64]*UserData, error) {
+
+	const fn = "GetUserData"
+
+	result := make(map[int64]*UserData)
+	for _, id := range userIDs {
+		result[id] = &UserData{UserID: id}
+	}
+
+	for start := 0; start < len(userIDs); start += userDataBatchSize {
+		end := min(start+userDataBatchSize, len(userIDs))
+		batch := userIDs[start:end]
+
+		query := fmt.Sprintf(`
+			SELECT d.usd_usr_id, f.usf_id, f.usf_name_intern, d.usd_value
+			FROM adm_user_data AS d
+			INNER JOIN adm_user_fields AS f
+				ON f.usf_id = d.usd_usf_id
+			WHERE d.usd_usr_id IN (%s);
+		`, placeholderList(1, len(batch)))
+
+		args := make([]any, 0, len(batch))
+		for _, id := range batch {
+			args = append(args, id)
+		}
+
+		rows, searchError := db.Query(ctx, query, args...)
+		if searchError != nil {
+			em := fmt.Sprintf("%s: %v", fn, searchError)
+			return nil, errors.New(em)
+		}
+
+		for rows.Next() {
+			var userID, fieldID int64
+			var nameIntern string
+			var value sql.NullString
+			scanError := rows.Scan(&userID, &fieldID, &nameIntern, &value)
+			if scanError != nil {
+				rows.Close()
+				em := fmt.Sprintf("%s: %v", fn, scanError)
+				return nil, errors.New(em)
+			}
+
+			db.fieldIDs.put(nameIntern, fieldID)
+
+			field, found := findProfileField(nameIntern)
+			if !found {
+				// A field that the application doesn't use.
+				continue
+			}
+
+			if !value.Valid {
+				// Admidio allows a NULL value.  Treat it as not set.
+				continue
+			}
+
+			// A bad value in one field shouldn't stop the rest of the user's
+			// data (or anybody else's) being fetched, so it's logged and
+			// treated as not set.
+			setError := field.load(result[userID], value.String)
+			if setError != nil {
+				if logger := db.logger(); logger != nil {
+					em := fmt.Sprintf("%s: user %d field %s: %v", fn, userID, nameIntern, setError)
+					logger.Warn(em)
+				}
+			}
+		}
+
+		rows.Close()
+		if rows.Err() != nil {
+			em := fmt.Sprintf("%s: %v", fn, rows.Err())
+			return nil, errors.New(em)
+		}
+	}
+
+	// Success!
+	return result, nil
+}
+
+// SaveUserData saves the given fields of the user data, which are specified by
+// their internal names, for example "STREET".  (To save all of them, give
+// UserDataFields()...)  Any existing values of those fields are replaced.  A
+// text field that is empty is removed.  The save takes two statements, a delete
+// and an insert.
+// It's assumed that a transaction is already set up in the db object.
+func (db *Database) SaveUserData(ctx context.Context, ud *UserData, fields ...string) error {
+
+	const fn = "SaveUserData"
+
+	if ud.UserID <= 0 {
+		em := fmt.Sprintf("%s: no userID", fn)
+		return errors.New(em)
+	}
+
+	if len(fields) == 0 {
+		return nil
+	}
+
+	fieldIDs, idError := db.getUserDataFieldIDs(ctx, fields)
+	if idError != nil {
+		em := fmt.Sprintf("%s: %v", fn, idError)
+		return errors.New(em)
+	}
+
+	ids := make([]int64, 0, len(fields))
+	values := make([]string, 0, len(fields))
+	for _, name := range fields {
+		field, _ := findProfileField(name)
+		ids = append(ids, fieldIDs[name])
+		values = append(values, field.stored(ud))
+	}
+
+	replaceError := db.replaceUserData(ctx, ud.UserID, ids, values)
+	if replaceError != nil {
+		em := fmt.Sprintf("%s: %v", fn, replaceError)
+		return errors.New(em)
+	}
+
+	// Success!
+	return nil
+}
+
+// replaceUserData replaces the values of the user fields with the given IDs for
+// the given user with the given values, which are in the form that they are
+// stored in adm_user_data.  A value that is empty is removed.  It takes two
+// statements, a delete and an insert, and the changes are recorded in the
+// change history.
+// It's assumed that a transaction is already set up in the db object.
+func (db *Database) replaceUserData(ctx context.Context, userID int64, fieldIDs []int64, values []string) error {
+
+	if len(fieldIDs) == 0 {
+		return nil
+	}
+
+	// Fetch the existing values for the change history.
+	oldValues, oldValuesError := db.userDataValues(ctx, userID, fieldIDs)
+	if oldValuesError != nil {
+		return oldValuesError
+	}
+
+	changes := make([]change, 0, len(fieldIDs))
+	for i, id := range fieldIDs {
+		changes = append(changes, profileChange(id, oldValues[id], values[i]))
+	}
+
+	// Remove the existing values.
+	deleteArgs := []any{userID}
+	for _, id := range fieldIDs {
+		deleteArgs = append(deleteArgs, id)
+	}
+
+	deleteSQL := fmt.Sprintf(`
+		DELETE FROM adm_user_data
+		WHERE usd_usr_id = $1
+		AND usd_usf_id IN (%s);
+	`, placeholderList(2, len(fieldIDs)))
+
+	_, deleteError := db.Exec(ctx, deleteSQL, deleteArgs...)
+	if deleteError != nil {
+		return deleteError
+	}
+
+	// Insert the new ones.
+	rows := make([]string, 0, len(fieldIDs))
+	insertArgs := make([]any, 0, 3*len(fieldIDs))
+	for i, id := range fieldIDs {
+		if len(values[i]) == 0 {
+			continue
+		}
+		n := len(insertArgs)
+		rows = append(rows, fmt.Sprintf("($%d, $%d, $%d)", n+1, n+2, n+3))
+		insertArgs = append(insertArgs, userID, id, values[i])
+	}
+
+	if len(rows) > 0 {
+		insertSQL := fmt.Sprintf(`
+			INSERT INTO adm_user_data (usd_usr_id, usd_usf_id, usd_value)
+			VALUES %s;
+		`, strings.Join(rows, ", "))
+
+		_, insertError := db.Exec(ctx, insertSQL, insertArgs...)
+		if insertError != nil {
+			return insertError
+		}
+	}
+
+	return db.logChanges(ctx, userID, changes...)
+}
+
+// getUserDataFieldIDs gets the IDs of the user fields with the given internal
+// names, which must be fields of UserData.  Any that aren't in the cache are
+// fetched in one query.
+func (db *Database) getUserDataFieldIDs(ctx context.Context, names []string) (map[string]int64, error) {
+
+	ids := make(map[string]int64)
+	missing := make([]any, 0)
+	for _, name := range names {
+		if _, found := findProfileField(name); !found {
+			em := fmt.Sprintf("%s is not a profile field", name)
+			return nil, errors.New(em)
+		}
+		id, found := db.fieldIDs.get(name)
+		if found {
+			ids[name] = id
+		} else {
+			missing = append(missing, name)
+		}
+	}
+
+	if len(missing) > 0 {
+		query := fmt.Sprintf(`
+			SELECT usf_name_intern, usf_id
+			FROM adm_user_fields
+			WHERE usf_name_intern IN (%s);
+		`, placeholderList(1, len(missing)))
+
+		rows, searchError := db.Query(ctx, query, missing...)
+		if searchError != nil {
+			return nil, searchError
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var name string
+			var id int64
+			scanError := rows.Scan(&name, &id)
+			if scanError != nil {
+				return nil, scanError
+			}
+			ids[name] = id
+			db.fieldIDs.put(name, id)
+		}
+
+		if rows.Err() != nil {
+			return nil, rows.Err()
+		}
+	}
+
+	for _, name := range names {
+		if _, found := ids[name]; !found {
+			em := fmt.Sprintf("there is no user field %s", name)
+			return nil, errors.New(em)
+		}
+	}
+
+	return ids, nil
+}
+
+// placeholderList gives a list of n Postgres-style placeholders starting at
+// $start, for example "$2, $3, $4", for use in an IN clause.
+func placeholderList(start, n int) string {
+	placeholders := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		placeholders = append(placeholders, fmt.Sprintf("$%d", start+i))
+	}
+	return strings.Join(placeholders, ", ")
+}
+
+// fieldIDCache holds the IDs of the Admidio user fields keyed on their internal
+// names.  Administrators can add fields but the ID of a field never changes, so
+// the IDs can be kept for the life of the server.  A Pool holds one cache and
+// shares it between all of the Database objects that it lends, so the lookup is
+// done once rather than on every request.  It's safe for concurrent use.
+type fieldIDCache struct {
+	mutex sync.RWMutex
+	ids   map[string]int64
+}
+
+// newFieldIDCache creates an empty cache.
+func newFieldIDCache() *fieldIDCache {
+	return &fieldIDCache{ids: make(map[string]int64)}
+}
+
+// get gets the ID of the field with the given internal name, if it's cached.
+func (c *fieldIDCache) get(nameIntern string) (int64, bool) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	id, found := c.ids[nameIntern]
+	return id, found
+}
+
+// put stores the ID of the field with the given internal name.
+func (c *fieldIDCache) put(nameIntern string, id int64) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.ids[nameIntern] = id
+}
+
+// forget empties the cache.
+func (c *fieldIDCache) forget() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.ids = make(map[string]int64)
+}
@@ -0,0 +1,314 @@
+package database
+
+import (
+	"testing"
+)
+
+// TestSaveAndGetUserData checks that SaveUserData saves the user data and
+// GetUserData fetches it for several users at once.
+func TestSaveAndGetUserData(t *testing.T) {
+	ctx := t.Context()
+
+	for _, dbType := range databaseList {
+
+		db, connError := ConnectForTesting(dbType)
+		if connError != nil {
+			t.Error(connError)
+			continue
+		}
+
+		defer db.Rollback()
+		defer db.CloseAndDelete()
+
+		u1, ue1 := CreateUser(ctx, db)
+		if ue1 != nil {
+			t.Fatal(ue1)
+		}
+
+		u2, ue2 := CreateUser(ctx, db)
+		if ue2 != nil {
+			t.Fatal(ue2)
+		}
+
+		want1 := UserData{
+			UserID:             u1.ID,
+			Title:              "Dr",
+			FirstName:          "Freda",
+			LastName:           "Bloggs",
+			Email:              "freda@example.com",
+			AddressLine1:       "1 High Street",
+			AddressLine2:       "Leafy Lane",
+			AddressLine3:       "Nether End",
+			Town:               "Leatherhead",
+			County:             "Surrey",
+			Postcode:           "KT22 1AA",
+			CountryCode:        "GBR",
+			Phone:              "01372 123456",
+			Mobile:             "07700 900123",
+			LocationOfInterest: "Fetcham",
+			DataProtection:     true,
+			EmailPermission:    true,
+			Giftaid:            true,
+			LastPayment:        34.5,
+			DonationToSociety:  10,
+			DonationToMuseum:   2.25,
+			DateLastPaid:       "2025-03-01",
+			MembersAtAddress:   2,
+			FriendsAtAddress:   1,
+		}
+
+		saveError := db.SaveUserData(ctx, &want1, UserDataFields()...)
+		if saveError != nil {
+			t.Errorf("%s: %v", dbType, saveError)
+			continue
+		}
+
+		// Only the named fields are saved.
+		ud2 := UserData{UserID: u2.ID, Town: "Dorking", Friend: true, Phone: "not saved"}
+		saveError = db.SaveUserData(ctx, &ud2, "CITY", "FRIEND_OF_THE_MUSEUM")
+		if saveError != nil {
+			t.Errorf("%s: %v", dbType, saveError)
+			continue
+		}
+		want2 := UserData{UserID: u2.ID, Town: "Dorking", Friend: true}
+
+		// A user with nothing set gets an empty entry.
+		const noSuchUser = 99999
+		got, fetchError := db.GetUserData(ctx, u1.ID, u2.ID, noSuchUser)
+		if fetchError != nil {
+			t.Errorf("%s: %v", dbType, fetchError)
+			continue
+		}
+
+		if len(got) != 3 {
+			t.Errorf("%s: want 3 got %d", dbType, len(got))
+			continue
+		}
+
+		if *got[u1.ID] != want1 {
+			t.Errorf("%s: want %v got %v", dbType, want1, *got[u1.ID])
+		}
+
+		if *got[u2.ID] != want2 {
+			t.Errorf("%s: want %v got %v", dbType, want2, *got[u2.ID])
+		}
+
+		if *got[noSuchUser] != (UserData{UserID: noSuchUser}) {
+			t.Errorf("%s: want an empty entry got %v", dbType, *got[noSuchUser])
+		}
+
+		// The values can be read one at a time as before.
+		friend, friendError := GetProfileField[bool](ctx, db, u2.ID, "FRIEND_OF_THE_MUSEUM")
+		if friendError != nil {
+			t.Errorf("%s: %v", dbType, friendError)
+		}
+		if !friend {
+			t.Errorf("%s: want friend to be true", dbType)
+		}
+
+		donation, donationError := GetProfileField[float64](ctx, db, u1.ID, "VALUE_OF_DONATION_TO_THE_MUSEUM")
+		if donationError != nil {
+			t.Errorf("%s: %v", dbType, donationError)
+		}
+		if donation != 2.25 {
+			t.Errorf("%s: want 2.25 got %f", dbType, donation)
+		}
+
+		// Saving an empty text field removes it and replacing a value
+		// doesn't leave the old one behind.
+		update := UserData{UserID: u1.ID, AddressLine2: "", Town: "Ashtead"}
+		saveError = db.SaveUserData(ctx, &update, "ADDRESS_LINE_2", "CITY")
+		if saveError != nil {
+			t.Errorf("%s: %v", dbType, saveError)
+			continue
+		}
+
+		if db.FieldSet(ctx, mustFieldID(t, db, "ADDRESS_LINE_2"), u1.ID) {
+			t.Errorf("%s: want ADDRESS_LINE_2 to be removed", dbType)
+		}
+
+		town, townError := GetUserDataFieldErrorOnNotFound[string](ctx, db, mustFieldID(t, db, "CITY"), u1.ID)
+		if townError != nil {
+			t.Errorf("%s: %v", dbType, townError)
+		}
+		if town != "Ashtead" {
+			t.Errorf("%s: want Ashtead got %s", dbType, town)
+		}
+
+		// A field that UserData doesn't have is an error.
+		saveError = db.SaveUserData(ctx, &update, "NO_SUCH_FIELD")
+		if saveError == nil {
+			t.Errorf("%s: want an error", dbType)
+		}
+	}
+}
+
+// TestFieldIDCache checks that the IDs of the user fields are cached and that
+// the cache is emptied when a transaction that created a user field is rolled
+// back.
+func TestFieldIDCache(t *testing.T) {
+	ctx := t.Context()
+
+	for _, dbType := range databaseList {
+
+		db, connError := ConnectForTesting(dbType)
+		if connError != nil {
+			t.Error(connError)
+			continue
+		}
+
+		defer db.Rollback()
+		defer db.CloseAndDelete()
+
+		id, fetchError := db.GetUserDataFieldIDByNameIntern(ctx, "CITY")
+		if fetchError != nil {
+			t.Errorf("%s: %v", dbType, fetchError)
+			continue
+		}
+
+		cached, found := db.fieldIDs.get("CITY")
+		if !found || cached != id {
+			t.Errorf("%s: want %d in the cache got %d %v", dbType, id, cached, found)
+		}
+
+		// Creating a user field and then rolling back empties the cache.
+		field, fieldError := db.GetUserDataFieldByNameIntern(ctx, "CITY")
+		if fieldError != nil {
+			t.Errorf("%s: %v", dbType, fieldError)
+			continue
+		}
+
+		uf := NewUserField("Test", "TEST_FIELD", "TEXT", field.CreateUser, field.Cat)
+		createError := db.CreateUserField(ctx, uf)
+		if createError != nil {
+			t.Errorf("%s: %v", dbType, createError)
+			continue
+		}
+
+		_, fetchError = db.GetUserDataFieldIDByNameIntern(ctx, "TEST_FIELD")
+		if fetchError != nil {
+			t.Errorf("%s: %v", dbType, fetchError)
+			continue
+		}
+
+		db.Rollback()
+
+		if _, found := db.fieldIDs.get("TEST_FIELD"); found {
+			t.Errorf("%s: want TEST_FIELD to be forgotten", dbType)
+		}
+	}
+}
+
+// TestFieldIDCacheFailedCommit checks that the cache is emptied when the commit
+// of a transaction that created a user field fails.
+func TestFieldIDCacheFailedCommit(t *testing.T) {
+	ctx := t.Context()
+
+	for _, dbType := range databaseList {
+
+		db, connError := ConnectForTesting(dbType)
+		if connError != nil {
+			t.Error(connError)
+			continue
+		}
+
+		defer db.Rollback()
+		defer db.CloseAndDelete()
+
+		field, fieldError := db.GetUserDataFieldByNameIntern(ctx, "CITY")
+		if fieldError != nil {
+			t.Errorf("%s: %v", dbType, fieldError)
+			continue
+		}
+
+		uf := NewUserField("Test", "TEST_FIELD", "TEXT", field.CreateUser, field.Cat)
+		createError := db.CreateUserField(ctx, uf)
+		if createError != nil {
+			t.Errorf("%s: %v", dbType, createError)
+			continue
+		}
+
+		_, fetchError := db.GetUserDataFieldIDByNameIntern(ctx, "TEST_FIELD")
+		if fetchError != nil {
+			t.Errorf("%s: %v", dbType, fetchError)
+			continue
+		}
+
+		// End the transaction behind the Database's back, as the driver does
+		// when the context is cancelled, so that the commit fails.
+		db.Transaction.Rollback()
+
+		commitError := db.Commit()
+		if commitError == nil {
+			t.Errorf("%s: want an error", dbType)
+		}
+
+		if _, found := db.fieldIDs.get("TEST_FIELD"); found {
+			t.Errorf("%s: want TEST_FIELD to be forgotten", dbType)
+		}
+	}
+}
+
+// TestGetUserDataBadValues checks that GetUserData skips a NULL value and a
+// value that doesn't parse instead of failing, and still fetches the rest.
+func TestGetUserDataBadValues(t *testing.T) {
+	ctx := t.Context()
+
+	for _, dbType := range databaseList {
+
+		db, connError := ConnectForTesting(dbType)
+		if connError != nil {
+			t.Error(connError)
+			continue
+		}
+
+		defer db.Rollback()
+		defer db.CloseAndDelete()
+
+		u, ue := CreateUser(ctx, db)
+		if ue != nil {
+			t.Fatal(ue)
+		}
+
+		const insertSQL = `
+			INSERT INTO adm_user_data (usd_usr_id, usd_usf_id, usd_value)
+			VALUES ($1, $2, $3);
+		`
+
+		seed := []struct {
+			nameIntern string
+			value      any
+		}{
+			{"CITY", nil},
+			{"MEMBERS_AT_ADDRESS", "abc"},
+			{"POSTCODE", "KT22 1AA"},
+		}
+
+		for _, s := range seed {
+			_, insertError := db.Exec(ctx, insertSQL, u.ID, mustFieldID(t, db, s.nameIntern), s.value)
+			if insertError != nil {
+				t.Fatalf("%s: %v", dbType, insertError)
+			}
+		}
+
+		got, fetchError := db.GetUserData(ctx, u.ID)
+		if fetchError != nil {
+			t.Errorf("%s: %v", dbType, fetchError)
+			continue
+		}
+
+		want := UserData{UserID: u.ID, Postcode: "KT22 1AA"}
+		if *got[u.ID] != want {
+			t.Errorf("%s: want %v got %v", dbType, want, *got[u.ID])
+		}
+	}
+}
+
+// mustFieldID gets the ID of the user field with the given internal name.
+func mustFieldID(t *testing.T, db *Database, nameIntern string) int64 {
+	id, err := db.GetUserDataFieldIDByNameIntern(t.Context(), nameIntern)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
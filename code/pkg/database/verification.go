@@ -0,0 +1,371 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Support for email-verified renewals.  When somebody buying membership gives
+// the email address of an existing account, they must prove that they own it
+// before they can pay.  A one-time code is sent to the email address on record
+// and the sale waits in PaymentStatusUnverified until the link containing the
+// code is followed.  When they give only the names of an existing member we
+// can't tell whether they are that member, so the sale goes into a queue for
+// an administrator to review.
+
+// PaymentStatusUnverified is the status of a sale that is waiting for the
+// customer to follow the link in the verification email.
+const PaymentStatusUnverified = "unverified"
+
+// ReviewStatusOpen is the status of a review that has not been dealt with yet.
+const ReviewStatusOpen = "open"
+
+// ReviewStatusClosed is the status of a review that has been dealt with.
+const ReviewStatusClosed = "closed"
+
+// Verification holds a one-time code that proves that the customer owns the
+// email address of an existing account.  Only a hash of the code is stored.
+type Verification struct {
+	ID       int64
+	SaleID   int64     // The ID of the membership_sales record.
+	UserID   int64     // The user ID of the account being claimed.
+	Email    string    // The email address on record that the code was sent to.
+	CodeHash string    // The SHA-256 hash of the code, as a hex string.
+	Expires  time.Time // The code is not valid after this time.
+	Used     bool      // True when the code has been used.
+}
+
+// Review is an entry in the queue of sales that need an administrator to
+// decide which member accounts they belong to.
+type Review struct {
+	ID     int64
+	SaleID int64  // The ID of the membership_sales record.
+	Reason string // Why the sale needs review.
+	Status string // ReviewStatusOpen or ReviewStatusClosed.
+}
+
+// GetUserIDsByEmail gets the IDs of the users whose login name or EMAIL field
+// matches the given email address (ignoring case).
+func (db *Database) GetUserIDsByEmail(ctx context.Context, emailAddress string) ([]int64, error) {
+
+	emailID, fieldError := db.GetUserDataFieldIDByNameIntern(ctx, "EMAIL")
+	if fieldError != nil {
+		return nil, fieldError
+	}
+
+	const query = `
+		SELECT usr_id
+		FROM adm_users
+		WHERE lower(usr_login_name) = lower($1)
+		UNION
+		SELECT usd_usr_id
+		FROM adm_user_data
+		WHERE usd_usf_id = $2
+		AND lower(usd_value) = lower($3);
+	`
+
+	return db.getIDs(ctx, query, emailAddress, emailID, emailAddress)
+}
+
+// GetUserIDsByName gets the IDs of the users whose first and last names match the
+// given names (ignoring case).
+func (db *Database) GetUserIDsByName(ctx context.Context, firstName, lastName string) ([]int64, error) {
+
+	firstNameID, firstNameIDErr := db.GetUserDataFieldIDByNameIntern(ctx, "FIRST_NAME")
+	if firstNameIDErr != nil {
+		return nil, firstNameIDErr
+	}
+
+	lastNameID, lastNameIDErr := db.GetUserDataFieldIDByNameIntern(ctx, "LAST_NAME")
+	if lastNameIDErr != nil {
+		return nil, lastNameIDErr
+	}
+
+	const query = `
+		SELECT firstName.usd_usr_id
+		FROM adm_user_data AS firstName
+		JOIN adm_user_data AS lastName
+			ON lastName.usd_usr_id = firstName.usd_usr_id
+			AND lastName.usd_usf_id = $1
+		WHERE firstName.usd_usf_id = $2
+		AND lower(firstName.usd_value) = lower($3)
+		AND lower(lastName.usd_value) = lower($4)
+		ORDER BY firstName.usd_usr_id;
+	`
+
+	return db.getIDs(ctx, query, lastNameID, firstNameID, firstName, lastName)
+}
+
+// getIDs runs the given query, which should produce a list of IDs, and
+// returns them.
+func (db *Database) getIDs(ctx context.Context, query string, args ...any) ([]int64, error) {
+	rows, searchError := db.Query(ctx, query, args...)
+	if searchError != nil {
+		return nil, searchError
+	}
+	defer rows.Close()
+
+	result := make([]int64, 0)
+	for rows.Next() {
+		var id int64
+		scanError := rows.Scan(&id)
+		if scanError != nil {
+			return nil, scanError
+		}
+		result = append(result, id)
+	}
+
+	return result, rows.Err()
+}
+
+// GetEmailOnRecord gets the email address to which a verification code for the
+// given user should be sent.  That's the EMAIL field if it's set, otherwise the
+// login name if it looks like an email address.  If there isn't one, the result
+// is an empty string.
+func (db *Database) GetEmailOnRecord(ctx context.Context, userID int64) (string, error) {
+
+	email, emailError := GetProfileField[string](ctx, db, userID, "EMAIL")
+	if emailError != nil {
+		return "", emailError
+	}
+
+	if len(email) > 0 {
+		return email, nil
+	}
+
+	user, userError := db.GetUser(ctx, userID)
+	if userError != nil {
+		return "", userError
+	}
+
+	if strings.Contains(user.LoginName, "@") {
+		return user.LoginName, nil
+	}
+
+	return "", nil
+}
+
+// CreateVerification creates a membership_verifications record.
+func (db *Database) CreateVerification(ctx context.Context, v *Verification) error {
+
+	const qPostgres = `
+		INSERT INTO membership_verifications
+		(mv_ms_id, mv_usr_id, mv_email, mv_code_hash, mv_expires, mv_used)
+		VALUES($1, $2, $3, $4, $5, $6)
+		RETURNING mv_id;
+	`
+
+	const qSQLite = `
+		INSERT INTO membership_verifications
+		(mv_ms_id, mv_usr_id, mv_email, mv_code_hash, mv_expires, mv_used)
+		VALUES(?, ?, ?, ?, ?, ?);
+	`
+
+	var q string
+	switch db.Config.Type {
+	case "postgres":
+		q = qPostgres
+	default:
+		q = qSQLite
+	}
+
+	// The expiry time is stored as seconds since the Unix epoch, which is
+	// handled the same way by all databases.
+	id, createError := db.CreateRow(ctx, q, v.SaleID, v.UserID, v.Email, v.CodeHash,
+		v.Expires.Unix(), v.Used)
+	if createError != nil {
+		return createError
+	}
+
+	v.ID = id
+
+	return nil
+}
+
+// GetVerificationByCodeHash gets the membership_verifications record with the
+// given code hash.
+func (db *Database) GetVerificationByCodeHash(ctx context.Context, codeHash string) (*Verification, error) {
+
+	const query = `
+		SELECT mv_id, mv_ms_id, mv_usr_id, mv_email, mv_code_hash, mv_expires, mv_used
+		FROM membership_verifications
+		WHERE mv_code_hash = $1;
+	`
+
+	var v Verification
+	var expires int64
+	err := db.QueryRow(ctx, query, codeHash).Scan(
+		&v.ID, &v.SaleID, &v.UserID, &v.Email, &v.CodeHash, &expires, &v.Used)
+	if err != nil {
+		return nil, err
+	}
+
+	v.Expires = time.Unix(expires, 0)
+
+	return &v, nil
+}
+
+// UseVerification marks the given verification as used.  It fails if the
+// verification has already been used, so a code can only be used once even
+// if two requests race.
+func (db *Database) UseVerification(ctx context.Context, v *Verification) error {
+
+	const query = `
+		UPDATE membership_verifications
+		SET mv_used = $1
+		WHERE mv_id = $2
+		AND mv_used = $3;
+	`
+
+	rows, updateError := db.UpdateRow(ctx, query, true, v.ID, false)
+	if updateError != nil {
+		return updateError
+	}
+
+	if rows != 1 {
+		em := fmt.Sprintf("UseVerification: verification %d has already been used", v.ID)
+		return errors.New(em)
+	}
+
+	v.Used = true
+
+	return nil
+}
+
+// CountUnusedVerifications counts the verifications for the given sale that
+// have not been used yet.
+func (db *Database) CountUnusedVerifications(ctx context.Context, saleID int64) (int, error) {
+
+	const query = `
+		SELECT count(*)
+		FROM membership_verifications
+		WHERE mv_ms_id = $1
+		AND mv_used = $2;
+	`
+
+	var n int
+	err := db.QueryRow(ctx, query, saleID, false).Scan(&n)
+	if err != nil {
+		return 0, err
+	}
+
+	return n, nil
+}
+
+// CreateReview adds a sale to the queue of sales that need review.
+func (db *Database) CreateReview(ctx context.Context, r *Review) error {
+
+	if len(r.Status) == 0 {
+		r.Status = ReviewStatusOpen
+	}
+
+	const qPostgres = `
+		INSERT INTO membership_reviews
+		(mr_ms_id, mr_reason, mr_status)
+		VALUES($1, $2, $3)
+		RETURNING mr_id;
+	`
+
+	const qSQLite = `
+		INSERT INTO membership_reviews
+		(mr_ms_id, mr_reason, mr_status)
+		VALUES(?, ?, ?);
+	`
+
+	var q string
+	switch db.Config.Type {
+	case "postgres":
+		q = qPostgres
+	default:
+		q = qSQLite
+	}
+
+	id, createError := db.CreateRow(ctx, q, r.SaleID, r.Reason, r.Status)
+	if createError != nil {
+		return createError
+	}
+
+	r.ID = id
+
+	return nil
+}
+
+// GetReviewsOfSale gets the reviews of the given sale, open or closed.
+func (db *Database) GetReviewsOfSale(ctx context.Context, saleID int64) ([]Review, error) {
+
+	const query = `
+		SELECT mr_id, mr_ms_id, mr_reason, mr_status
+		FROM membership_reviews
+		WHERE mr_ms_id = $1
+		ORDER BY mr_id;
+	`
+
+	return db.getReviews(ctx, query, saleID)
+}
+
+// GetOpenReviews gets the reviews that have not been dealt with, oldest first.
+func (db *Database) GetOpenReviews(ctx context.Context) ([]Review, error) {
+
+	const query = `
+		SELECT mr_id, mr_ms_id, mr_reason, mr_status
+		FROM membership_reviews
+		WHERE mr_status = $1
+		ORDER BY mr_id;
+	`
+
+	return db.getReviews(ctx, query, ReviewStatusOpen)
+}
+
+// SaleNeedsReview returns true if the given sale has an open review.
+func (db *Database) SaleNeedsReview(ctx context.Context, saleID int64) (bool, error) {
+	reviews, err := db.GetReviewsOfSale(ctx, saleID)
+	if err != nil {
+		return false, err
+	}
+
+	for _, r := range reviews {
+		if r.Status == ReviewStatusOpen {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// CloseReview marks the given review as dealt with.
+func (db *Database) CloseReview(ctx context.Context, reviewID int64) error {
+
+	const query = `
+		UPDATE membership_reviews
+		SET mr_status = $1
+		WHERE mr_id = $2;
+	`
+
+	_, err := db.UpdateRow(ctx, query, ReviewStatusClosed, reviewID)
+	return err
+}
+
+// getReviews runs the given query, which should produce a list of reviews, and
+// returns them.
+func (db *Database) getReviews(ctx context.Context, query string, args ...any) ([]Review, error) {
+	rows, searchError := db.Query(ctx, query, args...)
+	if searchError != nil {
+		return nil, searchError
+	}
+	defer rows.Close()
+
+	result := make([]Review, 0)
+	for rows.Next() {
+		var r Review
+		scanError := rows.Scan(&r.ID, &r.SaleID, &r.Reason, &r.Status)
+		if scanError != nil {
+			return nil, scanError
+		}
+		result = append(result, r)
+	}
+
+	return result, rows.Err()
+}
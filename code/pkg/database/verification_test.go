@@ -0,0 +1,284 @@
+package database
+
+import (
+	"testing"
+	"time"
+)
+
+// TestGetUserIDsByEmailAndName checks the lookups used to identify an existing
+// member at checkout.
+func TestGetUserIDsByEmailAndName(t *testing.T) {
+	ctx := t.Context()
+
+	for _, dbType := range databaseList {
+
+		db, connError := OpenDBForTesting(dbType)
+		if connError != nil {
+			t.Error(connError)
+			continue
+		}
+
+		db.BeginTx(ctx)
+
+		defer db.Rollback()
+		defer db.CloseAndDelete()
+
+		prepError := PrepareTestTables(db)
+		if prepError != nil {
+			t.Error(prepError)
+			continue
+		}
+
+		user, _, _, firstName, lastName, umError := createTestUserEtc(ctx, db)
+		if umError != nil {
+			t.Errorf("%s: %v", dbType, umError)
+			continue
+		}
+
+		byEmail, emailError := db.GetUserIDsByEmail(ctx, user.LoginName)
+		if emailError != nil {
+			t.Errorf("%s: %v", dbType, emailError)
+			continue
+		}
+
+		if len(byEmail) != 1 || byEmail[0] != user.ID {
+			t.Errorf("%s: want [%d] got %v", dbType, user.ID, byEmail)
+		}
+
+		byName, nameError := db.GetUserIDsByName(ctx, firstName, lastName)
+		if nameError != nil {
+			t.Errorf("%s: %v", dbType, nameError)
+			continue
+		}
+
+		if len(byName) != 1 || byName[0] != user.ID {
+			t.Errorf("%s: want [%d] got %v", dbType, user.ID, byName)
+		}
+
+		none, noneError := db.GetUserIDsByEmail(ctx, "no.such.person@example.com")
+		if noneError != nil {
+			t.Errorf("%s: %v", dbType, noneError)
+			continue
+		}
+
+		if len(none) != 0 {
+			t.Errorf("%s: want no users got %v", dbType, none)
+		}
+
+		emailOnRecord, eorError := db.GetEmailOnRecord(ctx, user.ID)
+		if eorError != nil {
+			t.Errorf("%s: %v", dbType, eorError)
+			continue
+		}
+
+		if emailOnRecord != user.LoginName {
+			t.Errorf("%s: want %s got %s", dbType, user.LoginName, emailOnRecord)
+		}
+	}
+}
+
+// TestVerification checks the create, fetch and use cycle of a verification.
+func TestVerification(t *testing.T) {
+	ctx := t.Context()
+
+	for _, dbType := range databaseList {
+
+		db, connError := OpenDBForTesting(dbType)
+		if connError != nil {
+			t.Error(connError)
+			continue
+		}
+
+		db.BeginTx(ctx)
+
+		defer db.Rollback()
+		defer db.CloseAndDelete()
+
+		prepError := PrepareTestTables(db)
+		if prepError != nil {
+			t.Error(prepError)
+			continue
+		}
+
+		user, _, _, _, _, umError := createTestUserEtc(ctx, db)
+		if umError != nil {
+			t.Errorf("%s: %v", dbType, umError)
+			continue
+		}
+
+		sale := MembershipSale{
+			PaymentService: "Stripe",
+			PaymentStatus:  PaymentStatusUnverified,
+			MembershipYear: 2025,
+			UserID:         user.ID,
+			FirstName:      "a",
+			LastName:       "b",
+			Email:          user.LoginName,
+		}
+		saleID, saleError := sale.Create(ctx, db)
+		if saleError != nil {
+			t.Errorf("%s: %v", dbType, saleError)
+			continue
+		}
+
+		codeHash, _ := CreateUuid(ctx, db.Transaction, "mv_code_hash", "membership_verifications")
+
+		expires := time.Date(2025, time.March, 1, 12, 0, 0, 0, time.UTC)
+		v := Verification{
+			SaleID:   saleID,
+			UserID:   user.ID,
+			Email:    user.LoginName,
+			CodeHash: codeHash,
+			Expires:  expires,
+		}
+
+		createError := db.CreateVerification(ctx, &v)
+		if createError != nil {
+			t.Errorf("%s: %v", dbType, createError)
+			continue
+		}
+
+		n, countError := db.CountUnusedVerifications(ctx, saleID)
+		if countError != nil {
+			t.Errorf("%s: %v", dbType, countError)
+			continue
+		}
+		if n != 1 {
+			t.Errorf("%s: want 1 got %d", dbType, n)
+		}
+
+		got, fetchError := db.GetVerificationByCodeHash(ctx, codeHash)
+		if fetchError != nil {
+			t.Errorf("%s: %v", dbType, fetchError)
+			continue
+		}
+
+		if got.ID != v.ID || got.SaleID != saleID || got.UserID != user.ID {
+			t.Errorf("%s: want %v got %v", dbType, v, *got)
+		}
+
+		if !got.Expires.Equal(expires) {
+			t.Errorf("%s: want %v got %v", dbType, expires, got.Expires)
+		}
+
+		if got.Used {
+			t.Errorf("%s: want unused", dbType)
+		}
+
+		useError := db.UseVerification(ctx, got)
+		if useError != nil {
+			t.Errorf("%s: %v", dbType, useError)
+			continue
+		}
+
+		// A code can only be used once.
+		reuseError := db.UseVerification(ctx, got)
+		if reuseError == nil {
+			t.Errorf("%s: want an error using the code again", dbType)
+		}
+
+		n, countError = db.CountUnusedVerifications(ctx, saleID)
+		if countError != nil {
+			t.Errorf("%s: %v", dbType, countError)
+			continue
+		}
+		if n != 0 {
+			t.Errorf("%s: want 0 got %d", dbType, n)
+		}
+	}
+}
+
+// TestReview checks the review queue.
+func TestReview(t *testing.T) {
+	ctx := t.Context()
+
+	for _, dbType := range databaseList {
+
+		db, connError := OpenDBForTesting(dbType)
+		if connError != nil {
+			t.Error(connError)
+			continue
+		}
+
+		db.BeginTx(ctx)
+
+		defer db.Rollback()
+		defer db.CloseAndDelete()
+
+		prepError := PrepareTestTables(db)
+		if prepError != nil {
+			t.Error(prepError)
+			continue
+		}
+
+		sale := MembershipSale{
+			PaymentService: "Stripe",
+			PaymentStatus:  PaymentStatusPending,
+			MembershipYear: 2025,
+			FirstName:      "a",
+			LastName:       "b",
+			Email:          "c",
+		}
+		saleID, saleError := sale.Create(ctx, db)
+		if saleError != nil {
+			t.Errorf("%s: %v", dbType, saleError)
+			continue
+		}
+
+		needsReview, nrError := db.SaleNeedsReview(ctx, saleID)
+		if nrError != nil {
+			t.Errorf("%s: %v", dbType, nrError)
+			continue
+		}
+		if needsReview {
+			t.Errorf("%s: want no review", dbType)
+		}
+
+		r := Review{SaleID: saleID, Reason: "name matches an existing member"}
+		createError := db.CreateReview(ctx, &r)
+		if createError != nil {
+			t.Errorf("%s: %v", dbType, createError)
+			continue
+		}
+
+		needsReview, nrError = db.SaleNeedsReview(ctx, saleID)
+		if nrError != nil {
+			t.Errorf("%s: %v", dbType, nrError)
+			continue
+		}
+		if !needsReview {
+			t.Errorf("%s: want a review", dbType)
+		}
+
+		open, openError := db.GetOpenReviews(ctx)
+		if openError != nil {
+			t.Errorf("%s: %v", dbType, openError)
+			continue
+		}
+
+		found := false
+		for _, o := range open {
+			if o.ID == r.ID {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("%s: review %d not in the open list", dbType, r.ID)
+		}
+
+		closeError := db.CloseReview(ctx, r.ID)
+		if closeError != nil {
+			t.Errorf("%s: %v", dbType, closeError)
+			continue
+		}
+
+		needsReview, nrError = db.SaleNeedsReview(ctx, saleID)
+		if nrError != nil {
+			t.Errorf("%s: %v", dbType, nrError)
+			continue
+		}
+		if needsReview {
+			t.Errorf("%s: want no open review", dbType)
+		}
+	}
+}
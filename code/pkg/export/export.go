@@ -0,0 +1,228 @@
+// The export package writes members' contact details as CSV, for mail merges,
+// or as vCards, for address books.
+package export
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/goblimey/go-stripe-payments/code/pkg/database"
+)
+
+// FormatCSV and FormatVCard are the formats that members can be exported in.
+const FormatCSV = "csv"
+const FormatVCard = "vcard"
+
+// csvHeader is the first line of a CSV export.
+var csvHeader = []string{
+	"title",
+	"first name",
+	"last name",
+	"email",
+	"address line 1",
+	"address line 2",
+	"address line 3",
+	"town",
+	"county",
+	"postcode",
+	"country",
+	"phone",
+	"mobile",
+	"friend",
+	"gift aid",
+	"interests",
+	"end date",
+}
+
+// Write writes the members in the given format, "csv" or "vcard".
+func Write(w io.Writer, format string, members []database.MemberDetails) error {
+	switch format {
+	case FormatCSV:
+		return WriteCSV(w, members)
+	case FormatVCard:
+		return WriteVCard(w, members)
+	default:
+		em := fmt.Sprintf("unknown export format %q - want %s or %s", format, FormatCSV, FormatVCard)
+		return errors.New(em)
+	}
+}
+
+// WriteCSV writes the members as CSV, one line per member after a header
+// line.  The interests are separated by semicolons.
+func WriteCSV(w io.Writer, members []database.MemberDetails) error {
+	writer := csv.NewWriter(w)
+
+	writeError := writer.Write(csvHeader)
+	if writeError != nil {
+		return writeError
+	}
+
+	for i := range members {
+		m := &members[i]
+		line := []string{
+			m.Title,
+			m.FirstName,
+			m.LastName,
+			m.Email,
+			m.AddressLine1,
+			m.AddressLine2,
+			m.AddressLine3,
+			m.Town,
+			m.County,
+			m.Postcode,
+			m.Country,
+			m.Phone,
+			m.Mobile,
+			yesNo(m.Friend),
+			yesNo(m.Giftaid),
+			strings.Join(m.Interests, "; "),
+			m.EndDate,
+		}
+		writeError = writer.Write(line)
+		if writeError != nil {
+			return writeError
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+// WriteVCard writes the members as vCards (version 3.0, RFC 2426), one after
+// another.  Empty fields are left out.
+func WriteVCard(w io.Writer, members []database.MemberDetails) error {
+
+	for i := range members {
+		m := &members[i]
+
+		lines := []string{
+			"BEGIN:VCARD",
+			"VERSION:3.0",
+			"N:" + structured(m.LastName, m.FirstName, "", m.Title, ""),
+			"FN:" + escape(fullName(m)),
+		}
+
+		if m.Email != "" {
+			lines = append(lines, "EMAIL;TYPE=INTERNET:"+escape(m.Email))
+		}
+
+		street := make([]string, 0, 3)
+		for _, line := range []string{m.AddressLine1, m.AddressLine2, m.AddressLine3} {
+			if line != "" {
+				street = append(street, line)
+			}
+		}
+
+		if len(street) > 0 || m.Town != "" || m.Postcode != "" {
+			// The street lines are separated by commas, which must not be escaped.
+			escapedStreet := make([]string, 0, len(street))
+			for _, line := range street {
+				escapedStreet = append(escapedStreet, escape(line))
+			}
+			adr := ";;" + strings.Join(escapedStreet, ",") + ";" +
+				structured(m.Town, m.County, m.Postcode, m.Country)
+			lines = append(lines, "ADR;TYPE=HOME:"+adr)
+		}
+
+		if m.Phone != "" {
+			lines = append(lines, "TEL;TYPE=HOME,VOICE:"+escape(m.Phone))
+		}
+
+		if m.Mobile != "" {
+			lines = append(lines, "TEL;TYPE=CELL:"+escape(m.Mobile))
+		}
+
+		if len(m.Interests) > 0 {
+			categories := make([]string, 0, len(m.Interests))
+			for _, interest := range m.Interests {
+				categories = append(categories, escape(interest))
+			}
+			lines = append(lines, "CATEGORIES:"+strings.Join(categories, ","))
+		}
+
+		if m.EndDate != "" {
+			lines = append(lines, "NOTE:"+escape("Membership ends "+m.EndDate))
+		}
+
+		lines = append(lines, "END:VCARD")
+
+		for _, line := range lines {
+			_, writeError := io.WriteString(w, fold(line))
+			if writeError != nil {
+				return writeError
+			}
+		}
+	}
+
+	return nil
+}
+
+// fullName gives the member's name in the form "Dr Jane Doe".
+func fullName(m *database.MemberDetails) string {
+	parts := make([]string, 0, 3)
+	for _, p := range []string{m.Title, m.FirstName, m.LastName} {
+		if p != "" {
+			parts = append(parts, p)
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// structured escapes the given values and joins them with semicolons, as in
+// the N and ADR properties.
+func structured(values ...string) string {
+	escaped := make([]string, 0, len(values))
+	for _, v := range values {
+		escaped = append(escaped, escape(v))
+	}
+	return strings.Join(escaped, ";")
+}
+
+// escaper escapes the characters that are special in a vCard value.
+var escaper = strings.NewReplacer(
+	`\`, `\\`,
+	`,`, `\,`,
+	`;`, `\;`,
+	"\r\n", `\n`,
+	"\n", `\n`,
+)
+
+// escape escapes a vCard value.
+func escape(s string) string {
+	return escaper.Replace(s)
+}
+
+// maxLineLength is the longest line, in bytes, allowed in a vCard, not
+// counting the line ending.
+const maxLineLength = 75
+
+// fold splits a long vCard line into lines of at most maxLineLength bytes.
+// Each continuation line starts with a space.  The result ends with CRLF.
+// UTF-8 characters are not split.
+func fold(line string) string {
+	var b strings.Builder
+	length := 0
+	for _, r := range line {
+		size := len(string(r))
+		if length+size > maxLineLength {
+			b.WriteString("\r\n ")
+			// The leading space counts towards the length.
+			length = 1
+		}
+		b.WriteRune(r)
+		length += size
+	}
+	b.WriteString("\r\n")
+	return b.String()
+}
+
+// yesNo gives "yes" for true and "no" for false.
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
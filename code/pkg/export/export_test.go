@@ -0,0 +1,113 @@
+package export
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/goblimey/go-stripe-payments/code/pkg/database"
+)
+
+var testMembers = []database.MemberDetails{
+	{Title: "Dr", FirstName: "Jane", LastName: "Doe", Email: "jane@example.com",
+		AddressLine1: "Flat 1, The Mill", AddressLine2: "1 High Street",
+		Town: "Leatherhead", County: "Surrey", Postcode: "KT22 1AA", Country: "United Kingdom",
+		Phone: "01372 000000", Friend: true, Interests: []string{"Roman roads", "maps"},
+		EndDate: "2025-12-31"},
+	{FirstName: "John", LastName: "Smith"},
+}
+
+// TestWriteCSV checks that WriteCSV writes a header and one line per member.
+func TestWriteCSV(t *testing.T) {
+	const want = "title,first name,last name,email,address line 1,address line 2,address line 3," +
+		"town,county,postcode,country,phone,mobile,friend,gift aid,interests,end date\n" +
+		`Dr,Jane,Doe,jane@example.com,"Flat 1, The Mill",1 High Street,,Leatherhead,Surrey,` +
+		"KT22 1AA,United Kingdom,01372 000000,,yes,no,Roman roads; maps,2025-12-31\n" +
+		",John,Smith,,,,,,,,,,,no,no,,\n"
+
+	var buffer bytes.Buffer
+	writeError := Write(&buffer, FormatCSV, testMembers)
+	if writeError != nil {
+		t.Fatal(writeError)
+	}
+
+	if buffer.String() != want {
+		t.Errorf("want\n%s\ngot\n%s", want, buffer.String())
+	}
+}
+
+// TestWriteVCard checks that WriteVCard writes one escaped vCard per member.
+func TestWriteVCard(t *testing.T) {
+	const want = "BEGIN:VCARD\r\n" +
+		"VERSION:3.0\r\n" +
+		"N:Doe;Jane;;Dr;\r\n" +
+		"FN:Dr Jane Doe\r\n" +
+		"EMAIL;TYPE=INTERNET:jane@example.com\r\n" +
+		`ADR;TYPE=HOME:;;Flat 1\, The Mill,1 High Street;Leatherhead;Surrey;KT22 1AA` + "\r\n" +
+		" ;United Kingdom\r\n" +
+		"TEL;TYPE=HOME,VOICE:01372 000000\r\n" +
+		"CATEGORIES:Roman roads,maps\r\n" +
+		"NOTE:Membership ends 2025-12-31\r\n" +
+		"END:VCARD\r\n" +
+		"BEGIN:VCARD\r\n" +
+		"VERSION:3.0\r\n" +
+		"N:Smith;John;;;\r\n" +
+		"FN:John Smith\r\n" +
+		"END:VCARD\r\n"
+
+	var buffer bytes.Buffer
+	writeError := Write(&buffer, FormatVCard, testMembers)
+	if writeError != nil {
+		t.Fatal(writeError)
+	}
+
+	if buffer.String() != want {
+		t.Errorf("want\n%q\ngot\n%q", want, buffer.String())
+	}
+}
+
+// TestFold checks that long lines are folded without splitting a character.
+func TestFold(t *testing.T) {
+	var testData = []struct {
+		description string
+		line        string
+	}{
+		{"short", "FN:Jane Doe"},
+		{"exact", "NOTE:" + strings.Repeat("a", maxLineLength-5)},
+		{"long", "NOTE:" + strings.Repeat("a", 200)},
+		{"multibyte", "NOTE:" + strings.Repeat("é", 100)},
+	}
+
+	for _, td := range testData {
+		got := fold(td.line)
+
+		if !strings.HasSuffix(got, "\r\n") {
+			t.Errorf("%s: want CRLF at the end", td.description)
+		}
+
+		lines := strings.Split(strings.TrimSuffix(got, "\r\n"), "\r\n")
+		for i, line := range lines {
+			if len(line) > maxLineLength {
+				t.Errorf("%s: line %d is %d bytes", td.description, i, len(line))
+			}
+			if i > 0 && !strings.HasPrefix(line, " ") {
+				t.Errorf("%s: line %d doesn't start with a space", td.description, i)
+			}
+		}
+
+		// Unfolding gives back the original.
+		unfolded := strings.ReplaceAll(strings.TrimSuffix(got, "\r\n"), "\r\n ", "")
+		if unfolded != td.line {
+			t.Errorf("%s: want %q got %q", td.description, td.line, unfolded)
+		}
+	}
+}
+
+// TestWriteUnknownFormat checks that Write rejects an unknown format.
+func TestWriteUnknownFormat(t *testing.T) {
+	var buffer bytes.Buffer
+	writeError := Write(&buffer, "xml", testMembers)
+	if writeError == nil {
+		t.Error("want an error")
+	}
+}
@@ -23,6 +23,9 @@ type SaleForm struct {
 
 	EnableGiftaid bool // Enable giftaid (for UK charities).
 
+	CSRFToken   string // The token for the hidden field that protects the form (see the csrf package).
+	FormStarted string // Signed time at which the form was displayed, used to spot bots.
+
 	// Data for validation.
 	Title                  string `json:"title"`
 	FirstName              string `json:"first_name"`
@@ -56,6 +59,8 @@ type SaleForm struct {
 	AssocFriendFeeToPay float64 // The fee to be paid for the associate to be a friend (0 if no associate or not a friend).
 	UserID              int64   // The ID of the ordinary member in the database (> zero).
 	AssocUserID         int64   // The ID of the associate member in the database (zero if no associate).
+	ConfirmMember       bool    // True if the customer should confirm that an existing account is the ordinary member's.
+	ConfirmAssocMember  bool    // True if the customer should confirm that an existing account is the associate member's.
 
 	// Error messages set if the form data is invalid.
 	GeneralErrorMessage           string // Set on a fatal error, eg database connection failure.
@@ -0,0 +1,208 @@
+// The labels package produces address labels for the members who have to be
+// written to by post - those with no email address and those who haven't given
+// permission to send them emails.  Members at the same address share a label.
+// The labels can be written as CSV or as a PDF of a sheet of labels (see pdf.go).
+package labels
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/goblimey/go-stripe-payments/code/pkg/database"
+)
+
+// Household is one label - the members living at one address.
+type Household struct {
+	Name    string   // For example "Jane and John Doe".
+	Address []string // The address lines, town, county, postcode and country.
+	Members []database.MemberDetails
+}
+
+// NeedsPost is true if the member must be written to by post.
+func NeedsPost(m *database.MemberDetails) bool {
+	return strings.TrimSpace(m.Email) == "" || !m.EmailPermission
+}
+
+// HasAddress is true if the member's address is good enough to post a letter.
+func HasAddress(m *database.MemberDetails) bool {
+	return strings.TrimSpace(m.AddressLine1) != "" &&
+		(strings.TrimSpace(m.Town) != "" || strings.TrimSpace(m.Postcode) != "")
+}
+
+// Households groups the members by address, in the order that each address
+// first appears.  Addresses are compared ignoring case, spaces and punctuation,
+// so "1 High St." and "1 high st" match if the postcodes also match.  The home
+// country is left out of the address.  Members with no address are left out.
+func Households(members []database.MemberDetails, homeCountry string) []Household {
+
+	households := make([]Household, 0)
+	index := make(map[string]int)
+
+	for _, m := range members {
+		if !HasAddress(&m) {
+			continue
+		}
+
+		key := addressKey(&m)
+		i, found := index[key]
+		if !found {
+			i = len(households)
+			index[key] = i
+			households = append(households, Household{Address: address(&m, homeCountry)})
+		}
+		households[i].Members = append(households[i].Members, m)
+	}
+
+	for i := range households {
+		households[i].Name = householdName(households[i].Members)
+	}
+
+	return households
+}
+
+// addressKey gives a key that is the same for two ways of writing an address.
+func addressKey(m *database.MemberDetails) string {
+	return normalise(m.AddressLine1) + "|" + normalise(m.Postcode)
+}
+
+// normalise reduces a string to its lower case letters and digits.
+func normalise(s string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(s) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || r > 127 {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// address gives the non-empty lines of the member's address.
+func address(m *database.MemberDetails, homeCountry string) []string {
+	country := m.Country
+	if strings.EqualFold(strings.TrimSpace(country), strings.TrimSpace(homeCountry)) {
+		country = ""
+	}
+
+	lines := make([]string, 0, 7)
+	for _, line := range []string{
+		m.AddressLine1, m.AddressLine2, m.AddressLine3,
+		m.Town, m.County, strings.ToUpper(m.Postcode), country,
+	} {
+		line = strings.TrimSpace(line)
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// householdName gives the name on the label.  One member gets their title and
+// full name, "Dr Jane Doe".  Members with the same last name get "Jane and
+// John Doe".  Otherwise it's "Jane Doe and John Smith".
+func householdName(members []database.MemberDetails) string {
+
+	if len(members) == 1 {
+		m := &members[0]
+		return joinNonEmpty(" ", m.Title, m.FirstName, m.LastName)
+	}
+
+	sameLastName := true
+	for _, m := range members[1:] {
+		if !strings.EqualFold(m.LastName, members[0].LastName) {
+			sameLastName = false
+			break
+		}
+	}
+
+	names := make([]string, 0, len(members))
+	for _, m := range members {
+		if sameLastName {
+			names = append(names, m.FirstName)
+		} else {
+			names = append(names, joinNonEmpty(" ", m.FirstName, m.LastName))
+		}
+	}
+
+	name := andList(names)
+	if sameLastName {
+		name += " " + members[0].LastName
+	}
+
+	return name
+}
+
+// andList joins names in the form "Ann, Bob and Cat".
+func andList(names []string) string {
+	if len(names) == 1 {
+		return names[0]
+	}
+	return strings.Join(names[:len(names)-1], ", ") + " and " + names[len(names)-1]
+}
+
+// joinNonEmpty joins the non-empty strings with the separator.
+func joinNonEmpty(separator string, parts ...string) string {
+	nonEmpty := make([]string, 0, len(parts))
+	for _, p := range parts {
+		p = strings.TrimSpace(p)
+		if p != "" {
+			nonEmpty = append(nonEmpty, p)
+		}
+	}
+	return strings.Join(nonEmpty, separator)
+}
+
+// csvHeader is the first line of the CSV.  The address is in address line 1
+// to address line 7 so that a mail merge can use the same fields for every
+// label.
+var csvHeader = []string{
+	"name",
+	"address line 1",
+	"address line 2",
+	"address line 3",
+	"address line 4",
+	"address line 5",
+	"address line 6",
+	"address line 7",
+	"members",
+	"login names",
+}
+
+// WriteCSV writes the households as CSV, one line per label after a header
+// line.
+func WriteCSV(w io.Writer, households []Household) error {
+	writer := csv.NewWriter(w)
+
+	writeError := writer.Write(csvHeader)
+	if writeError != nil {
+		return writeError
+	}
+
+	for _, h := range households {
+		line := make([]string, 0, len(csvHeader))
+		line = append(line, h.Name)
+		for i := 0; i < 7; i++ {
+			if i < len(h.Address) {
+				line = append(line, h.Address[i])
+			} else {
+				line = append(line, "")
+			}
+		}
+
+		loginNames := make([]string, 0, len(h.Members))
+		for _, m := range h.Members {
+			loginNames = append(loginNames, m.LoginName)
+		}
+
+		line = append(line, strconv.Itoa(len(h.Members)), strings.Join(loginNames, " "))
+
+		writeError = writer.Write(line)
+		if writeError != nil {
+			return writeError
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
@@ -0,0 +1,124 @@
+package labels
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/goblimey/go-stripe-payments/code/pkg/database"
+)
+
+var testMembers = []database.MemberDetails{
+	{LoginName: "jane", Title: "Dr", FirstName: "Jane", LastName: "Doe",
+		AddressLine1: "1 High St.", Town: "Leatherhead", Postcode: "kt22 1aa", Country: "United Kingdom"},
+	{LoginName: "bob", FirstName: "Bob", LastName: "Smith",
+		AddressLine1: "2 Church Road", Town: "Paris", Postcode: "75001", Country: "France"},
+	{LoginName: "john", Title: "Mr", FirstName: "John", LastName: "Doe",
+		AddressLine1: "1 high st", Town: "Leatherhead", Postcode: "KT22 1AA"},
+	{LoginName: "nobody", FirstName: "No", LastName: "Address"},
+	{LoginName: "ann", FirstName: "Ann", LastName: "Jones",
+		AddressLine1: "2 Church Road", Town: "Paris", Postcode: "75001", Country: "France"},
+}
+
+// TestHouseholds checks that members at the same address share a label.
+func TestHouseholds(t *testing.T) {
+
+	got := Households(testMembers, "united kingdom")
+
+	want := []struct {
+		name    string
+		address []string
+		members int
+	}{
+		{"Jane and John Doe", []string{"1 High St.", "Leatherhead", "KT22 1AA"}, 2},
+		{"Bob Smith and Ann Jones", []string{"2 Church Road", "Paris", "75001", "France"}, 2},
+	}
+
+	if len(got) != len(want) {
+		t.Fatalf("want %d households got %d - %v", len(want), len(got), got)
+	}
+
+	for i := range want {
+		if got[i].Name != want[i].name {
+			t.Errorf("%d: want %s got %s", i, want[i].name, got[i].Name)
+		}
+		if len(got[i].Members) != want[i].members {
+			t.Errorf("%d: want %d members got %d", i, want[i].members, len(got[i].Members))
+		}
+		if len(got[i].Address) != len(want[i].address) {
+			t.Errorf("%d: want %v got %v", i, want[i].address, got[i].Address)
+			continue
+		}
+		for j := range want[i].address {
+			if got[i].Address[j] != want[i].address[j] {
+				t.Errorf("%d: want %v got %v", i, want[i].address, got[i].Address)
+			}
+		}
+	}
+}
+
+// TestHouseholdName checks the name on a label.
+func TestHouseholdName(t *testing.T) {
+	jane := database.MemberDetails{Title: "Dr", FirstName: "Jane", LastName: "Doe"}
+	john := database.MemberDetails{Title: "Mr", FirstName: "John", LastName: "Doe"}
+	jim := database.MemberDetails{FirstName: "Jim", LastName: "doe"}
+	bob := database.MemberDetails{FirstName: "Bob", LastName: "Smith"}
+
+	var testData = []struct {
+		members []database.MemberDetails
+		want    string
+	}{
+		{[]database.MemberDetails{jane}, "Dr Jane Doe"},
+		{[]database.MemberDetails{bob}, "Bob Smith"},
+		{[]database.MemberDetails{jane, john}, "Jane and John Doe"},
+		{[]database.MemberDetails{jane, john, jim}, "Jane, John and Jim Doe"},
+		{[]database.MemberDetails{jane, bob}, "Jane Doe and Bob Smith"},
+	}
+
+	for _, td := range testData {
+		got := householdName(td.members)
+		if got != td.want {
+			t.Errorf("want %s got %s", td.want, got)
+		}
+	}
+}
+
+// TestNeedsPost checks that members with no email address or no permission
+// to send email are written to by post.
+func TestNeedsPost(t *testing.T) {
+	var testData = []struct {
+		email      string
+		permission bool
+		want       bool
+	}{
+		{"a@example.com", true, false},
+		{"a@example.com", false, true},
+		{"", true, true},
+		{" ", false, true},
+	}
+
+	for _, td := range testData {
+		m := database.MemberDetails{Email: td.email, EmailPermission: td.permission}
+		got := NeedsPost(&m)
+		if got != td.want {
+			t.Errorf("%q %v: want %v got %v", td.email, td.permission, td.want, got)
+		}
+	}
+}
+
+// TestWriteCSV checks that WriteCSV writes one line per household.
+func TestWriteCSV(t *testing.T) {
+	const want = "name,address line 1,address line 2,address line 3,address line 4," +
+		"address line 5,address line 6,address line 7,members,login names\n" +
+		"Jane and John Doe,1 High St.,Leatherhead,KT22 1AA,,,,,2,jane john\n" +
+		"Bob Smith and Ann Jones,2 Church Road,Paris,75001,France,,,,2,bob ann\n"
+
+	var buffer bytes.Buffer
+	writeError := WriteCSV(&buffer, Households(testMembers, "United Kingdom"))
+	if writeError != nil {
+		t.Fatal(writeError)
+	}
+
+	if buffer.String() != want {
+		t.Errorf("want\n%s\ngot\n%s", want, buffer.String())
+	}
+}
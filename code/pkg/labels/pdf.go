@@ -0,0 +1,212 @@
+package labels
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Layout describes a sheet of labels.  Sizes are in millimetres.
+type Layout struct {
+	Name            string  // The manufacturer's code, for example "L7160".
+	Description     string  // For example "21 per sheet, 63.5 x 38.1 mm".
+	Columns         int     // The number of labels across the sheet.
+	Rows            int     // The number of labels down the sheet.
+	LabelWidth      float64 // The width of a label.
+	LabelHeight     float64 // The height of a label.
+	LeftMargin      float64 // The distance from the left of the sheet to the first column.
+	TopMargin       float64 // The distance from the top of the sheet to the first row.
+	HorizontalPitch float64 // The distance from the left of one column to the left of the next.
+	VerticalPitch   float64 // The distance from the top of one row to the top of the next.
+}
+
+// The sheets are A4, 210 x 297 mm.
+const pageWidth = 210.0
+const pageHeight = 297.0
+
+// Layouts are the common A4 sheets, by name.  Other manufacturers sell sheets
+// with the same layouts.
+var Layouts = map[string]*Layout{
+	"L7160": {"L7160", "21 per sheet, 63.5 x 38.1 mm", 3, 7, 63.5, 38.1, 7.2, 15.1, 66.0, 38.1},
+	"L7161": {"L7161", "18 per sheet, 63.5 x 46.6 mm", 3, 6, 63.5, 46.6, 7.2, 8.8, 66.0, 46.6},
+	"L7162": {"L7162", "16 per sheet, 99.1 x 33.9 mm", 2, 8, 99.1, 33.9, 4.65, 12.9, 101.6, 33.9},
+	"L7163": {"L7163", "14 per sheet, 99.1 x 38.1 mm", 2, 7, 99.1, 38.1, 4.65, 15.1, 101.6, 38.1},
+	"L7173": {"L7173", "10 per sheet, 99.1 x 57 mm", 2, 5, 99.1, 57.0, 4.65, 6.0, 101.6, 57.0},
+}
+
+// LayoutNames gives the names of the layouts in order.
+func LayoutNames() []string {
+	names := make([]string, 0, len(Layouts))
+	for name := range Layouts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// The text is printed in Helvetica, one of the fonts that every PDF reader
+// has, so the font doesn't need to be embedded.
+const maxFontSize = 10.0 // points
+const minFontSize = 6.0  // points
+const lineSpacing = 1.2  // The distance between lines as a multiple of the font size.
+const padding = 4.0      // The gap between the edge of the label and the text, in mm.
+
+// averageCharWidth is roughly the width of a Helvetica character as a fraction
+// of the font size.  It's used to shrink the text of a label with long lines.
+const averageCharWidth = 0.5
+
+// pointsPerMM converts millimetres to points, the PDF unit.
+const pointsPerMM = 72 / 25.4
+
+// WritePDF writes the households as a PDF of sheets of labels in the given
+// layout.  The first skip labels of the first sheet are left blank so that a
+// partly used sheet can be printed on.
+func WritePDF(w io.Writer, households []Household, layout *Layout, skip int) error {
+
+	perSheet := layout.Columns * layout.Rows
+	if skip < 0 || skip >= perSheet {
+		em := fmt.Sprintf("can't skip %d labels of the %d on a sheet", skip, perSheet)
+		return errors.New(em)
+	}
+
+	// Make the content of each page.
+	pages := make([]string, 0)
+	var page strings.Builder
+	position := skip
+	for _, h := range households {
+		if position == perSheet {
+			pages = append(pages, page.String())
+			page.Reset()
+			position = 0
+		}
+		lines := append([]string{h.Name}, h.Address...)
+		writeLabel(&page, layout, position/layout.Columns, position%layout.Columns, lines)
+		position++
+	}
+	pages = append(pages, page.String())
+
+	return writeDocument(w, pages)
+}
+
+// writeLabel writes the PDF commands that print the lines of text on the label
+// in the given row and column.
+func writeLabel(page *strings.Builder, layout *Layout, row, column int, lines []string) {
+
+	width := layout.LabelWidth - 2*padding
+	height := layout.LabelHeight - 2*padding
+
+	// Shrink the text to fit the label.
+	size := maxFontSize
+	for _, line := range lines {
+		lineWidth := float64(len([]rune(line))) * averageCharWidth * size / pointsPerMM
+		if lineWidth > width {
+			size = size * width / lineWidth
+		}
+	}
+	textHeight := float64(len(lines)) * size * lineSpacing / pointsPerMM
+	if textHeight > height {
+		size = size * height / textHeight
+	}
+	if size < minFontSize {
+		size = minFontSize
+	}
+
+	// PDF measures from the bottom left corner of the page.
+	left := layout.LeftMargin + float64(column)*layout.HorizontalPitch + padding
+	top := pageHeight - layout.TopMargin - float64(row)*layout.VerticalPitch - padding
+
+	for i, line := range lines {
+		x := left * pointsPerMM
+		y := top*pointsPerMM - size - float64(i)*size*lineSpacing
+		fmt.Fprintf(page, "BT /F1 %.1f Tf 1 0 0 1 %.2f %.2f Tm (%s) Tj ET\n",
+			size, x, y, pdfString(line))
+	}
+}
+
+// writeDocument writes a PDF document with one page for each of the given
+// content streams.
+func writeDocument(w io.Writer, pages []string) error {
+
+	// Objects 1 to 3 are the catalogue, the list of pages and the font.  Each
+	// page is followed by its content stream.
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"", // The list of pages, filled in below.
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>",
+	}
+
+	kids := make([]string, 0, len(pages))
+	for _, content := range pages {
+		pageObject := len(objects) + 1
+		kids = append(kids, fmt.Sprintf("%d 0 R", pageObject))
+		objects = append(objects,
+			fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.2f %.2f] "+
+				"/Resources << /Font << /F1 3 0 R >> >> /Contents %d 0 R >>",
+				pageWidth*pointsPerMM, pageHeight*pointsPerMM, pageObject+1),
+			fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", len(content), content),
+		)
+	}
+	objects[1] = fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>",
+		strings.Join(kids, " "), len(pages))
+
+	var b bytes.Buffer
+	b.WriteString("%PDF-1.4\n")
+
+	offsets := make([]int, 0, len(objects))
+	for i, object := range objects {
+		offsets = append(offsets, b.Len())
+		fmt.Fprintf(&b, "%d 0 obj\n%s\nendobj\n", i+1, object)
+	}
+
+	// The cross reference table gives the position of each object.  Each
+	// entry is exactly 20 bytes.
+	xref := b.Len()
+	fmt.Fprintf(&b, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&b, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&b, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n",
+		len(objects)+1, xref)
+
+	_, writeError := w.Write(b.Bytes())
+	return writeError
+}
+
+// winAnsi maps the characters outside Latin-1 that the WinAnsi encoding
+// supports to their codes.
+var winAnsi = map[rune]byte{
+	'€': 0x80, '‚': 0x82, 'ƒ': 0x83, '„': 0x84, '…': 0x85, '†': 0x86, '‡': 0x87,
+	'ˆ': 0x88, '‰': 0x89, 'Š': 0x8a, '‹': 0x8b, 'Œ': 0x8c, 'Ž': 0x8e,
+	'‘': 0x91, '’': 0x92, '“': 0x93, '”': 0x94, '•': 0x95, '–': 0x96, '—': 0x97,
+	'˜': 0x98, '™': 0x99, 'š': 0x9a, '›': 0x9b, 'œ': 0x9c, 'ž': 0x9e, 'Ÿ': 0x9f,
+}
+
+// pdfString converts the text to the WinAnsi encoding used by the font and
+// escapes the characters that are special in a PDF string.  Characters that
+// the font can't print become question marks.
+func pdfString(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '\\' || r == '(' || r == ')':
+			b.WriteByte('\\')
+			b.WriteByte(byte(r))
+		case r >= ' ' && r < 0x7f:
+			b.WriteByte(byte(r))
+		case r >= 0xa0 && r <= 0xff:
+			// Latin-1 and WinAnsi are the same in this range.
+			fmt.Fprintf(&b, "\\%03o", r)
+		default:
+			code, ok := winAnsi[r]
+			if ok {
+				fmt.Fprintf(&b, "\\%03o", code)
+			} else {
+				b.WriteByte('?')
+			}
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,114 @@
+package labels
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// TestWritePDF checks that WritePDF produces a well formed PDF with the right
+// number of pages.
+func TestWritePDF(t *testing.T) {
+
+	layout := Layouts["L7163"] // 14 per sheet.
+
+	households := make([]Household, 0)
+	for i := 0; i < 20; i++ {
+		households = append(households, Household{
+			Name:    fmt.Sprintf("Member (%d)", i),
+			Address: []string{"1 High Street", "Leatherhead", "KT22 1AA"},
+		})
+	}
+
+	var testData = []struct {
+		description string
+		households  []Household
+		skip        int
+		wantPages   int
+	}{
+		{"none", nil, 0, 1},
+		{"one sheet", households[:14], 0, 1},
+		{"two sheets", households, 0, 2},
+		{"skip", households[:14], 1, 2},
+	}
+
+	for _, td := range testData {
+		var buffer bytes.Buffer
+		writeError := WritePDF(&buffer, td.households, layout, td.skip)
+		if writeError != nil {
+			t.Errorf("%s: %v", td.description, writeError)
+			continue
+		}
+
+		pdf := buffer.String()
+
+		if !strings.HasPrefix(pdf, "%PDF-1.4\n") || !strings.HasSuffix(pdf, "%%EOF\n") {
+			t.Errorf("%s: want a PDF header and trailer", td.description)
+		}
+
+		if !strings.Contains(pdf, fmt.Sprintf("/Count %d >>", td.wantPages)) {
+			t.Errorf("%s: want %d pages", td.description, td.wantPages)
+		}
+
+		// The parentheses in the names are escaped.
+		if len(td.households) > 0 && !strings.Contains(pdf, `(Member \(0\)) Tj`) {
+			t.Errorf("%s: want the first name escaped", td.description)
+		}
+
+		checkXref(t, td.description, pdf)
+	}
+
+	var buffer bytes.Buffer
+	skipError := WritePDF(&buffer, households, layout, 14)
+	if skipError == nil {
+		t.Error("want an error skipping a whole sheet")
+	}
+}
+
+// checkXref checks that each entry in the cross reference table gives the
+// position of the object.
+func checkXref(t *testing.T, description, pdf string) {
+	start := regexp.MustCompile(`startxref\n(\d+)\n`).FindStringSubmatch(pdf)
+	if start == nil {
+		t.Errorf("%s: no startxref", description)
+		return
+	}
+
+	xref, _ := strconv.Atoi(start[1])
+	if !strings.HasPrefix(pdf[xref:], "xref\n") {
+		t.Errorf("%s: startxref %d doesn't point at the xref table", description, xref)
+		return
+	}
+
+	entries := regexp.MustCompile(`(\d{10}) 00000 n \n`).FindAllStringSubmatch(pdf[xref:], -1)
+	for i, entry := range entries {
+		offset, _ := strconv.Atoi(entry[1])
+		want := fmt.Sprintf("%d 0 obj\n", i+1)
+		if !strings.HasPrefix(pdf[offset:], want) {
+			t.Errorf("%s: object %d is not at %d", description, i+1, offset)
+		}
+	}
+}
+
+// TestPDFString checks the encoding and escaping of text.
+func TestPDFString(t *testing.T) {
+	var testData = []struct {
+		text string
+		want string
+	}{
+		{"1 High Street", "1 High Street"},
+		{`a (b) \c`, `a \(b\) \\c`},
+		{"Zoë O’Brien", `Zo\353 O\222Brien`},
+		{"東京", "??"},
+	}
+
+	for _, td := range testData {
+		got := pdfString(td.text)
+		if got != td.want {
+			t.Errorf("%s: want %s got %s", td.text, td.want, got)
+		}
+	}
+}
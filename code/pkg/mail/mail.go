@@ -0,0 +1,75 @@
+// The mail package sends plain text email messages, for example the one-time
+// links used to verify that a customer owns an email address.
+package mail
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// Sender sends an email message.
+type Sender interface {
+	Send(to, subject, body string) error
+}
+
+// SMTPSender sends email via an SMTP server.
+type SMTPSender struct {
+	Address  string // The server address, "host:port".
+	From     string // The sender address.
+	User     string // The user name for authentication, empty for none.
+	Password string // The password for authentication.
+}
+
+// NewSMTPSender creates an SMTPSender.
+func NewSMTPSender(address, from, user, password string) *SMTPSender {
+	s := SMTPSender{Address: address, From: from, User: user, Password: password}
+	return &s
+}
+
+// Send sends a plain text message to the given address.
+func (s *SMTPSender) Send(to, subject, body string) error {
+
+	msg, msgError := Message(s.From, to, subject, body, time.Now())
+	if msgError != nil {
+		return msgError
+	}
+
+	var auth smtp.Auth
+	if len(s.User) > 0 {
+		host, _, splitError := net.SplitHostPort(s.Address)
+		if splitError != nil {
+			return splitError
+		}
+		auth = smtp.PlainAuth("", s.User, s.Password, host)
+	}
+
+	return smtp.SendMail(s.Address, auth, s.From, []string{to}, msg)
+}
+
+// Message builds a plain text email message.  The addresses and the subject
+// must not contain line breaks, otherwise somebody could inject headers.
+func Message(from, to, subject, body string, date time.Time) ([]byte, error) {
+
+	for _, header := range []string{from, to, subject} {
+		if strings.ContainsAny(header, "\r\n") {
+			em := fmt.Sprintf("Message: line break in header %q", header)
+			return nil, errors.New(em)
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString("From: " + from + "\r\n")
+	b.WriteString("To: " + to + "\r\n")
+	b.WriteString("Subject: " + subject + "\r\n")
+	b.WriteString("Date: " + date.Format(time.RFC1123Z) + "\r\n")
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+
+	return []byte(b.String()), nil
+}
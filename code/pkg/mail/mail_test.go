@@ -0,0 +1,57 @@
+package mail
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestMessage checks that Message builds a valid message.
+func TestMessage(t *testing.T) {
+
+	date := time.Date(2025, time.March, 1, 12, 0, 0, 0, time.UTC)
+
+	const want = "From: a@example.com\r\n" +
+		"To: b@example.com\r\n" +
+		"Subject: hello\r\n" +
+		"Date: Sat, 01 Mar 2025 12:00:00 +0000\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: text/plain; charset=UTF-8\r\n" +
+		"\r\n" +
+		"line 1\r\nline 2\r\n"
+
+	got, err := Message("a@example.com", "b@example.com", "hello", "line 1\nline 2\n", date)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if string(got) != want {
+		t.Errorf("want\n%s\ngot\n%s", want, string(got))
+	}
+}
+
+// TestMessageRejectsHeaderInjection checks that Message refuses headers that
+// contain line breaks.
+func TestMessageRejectsHeaderInjection(t *testing.T) {
+
+	var testData = []struct {
+		description string
+		to          string
+		subject     string
+	}{
+		{"to", "b@example.com\r\nBcc: c@example.com", "hello"},
+		{"subject", "b@example.com", "hello\nBcc: c@example.com"},
+	}
+
+	for _, td := range testData {
+		_, err := Message("a@example.com", td.to, td.subject, "body", time.Now())
+		if err == nil {
+			t.Errorf("%s: want an error", td.description)
+			continue
+		}
+		if !strings.Contains(err.Error(), "line break") {
+			t.Errorf("%s: unexpected error %v", td.description, err)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+// The ratelimit package provides a simple in-process rate limiter.  It counts
+// the events for each key (for example an IP address or an email address) in
+// a fixed window of time and refuses events over the limit.  The counts are
+// held in memory, so they are lost when the server restarts, which is good
+// enough to slow down a bot hammering a form.
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// Limiter limits the number of events per key in a window of time.  It's safe
+// for use by concurrent goroutines.
+type Limiter struct {
+	limit     int           // The number of events allowed per window.
+	window    time.Duration // The length of the window.
+	mutex     sync.Mutex    // Protects the counts.
+	counts    map[string]*count
+	lastPrune time.Time // The last time expired counts were removed.
+}
+
+// count holds the number of events for a key in the current window.
+type count struct {
+	start time.Time // The start of the window.
+	n     int       // The number of events since the start.
+}
+
+// New creates a Limiter that allows the given number of events for each key in
+// each window.  A limit of zero or less means no limit.
+func New(limit int, window time.Duration) *Limiter {
+	l := Limiter{
+		limit:  limit,
+		window: window,
+		counts: make(map[string]*count),
+	}
+	return &l
+}
+
+// Allow records an event for the given key at the given time and returns true
+// if it's within the limit.  Events that are refused still count, so a client
+// that keeps trying stays blocked until it gives up for a whole window.
+func (l *Limiter) Allow(key string, now time.Time) bool {
+
+	if l.limit <= 0 {
+		return true
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.prune(now)
+
+	c := l.current(key, now)
+	c.n++
+
+	return c.n <= l.limit
+}
+
+// Blocked returns true if the given key has used up its limit in the current
+// window.  Unlike Allow it doesn't count an event, so it can be used with
+// Record to count only some events, for example failed logins.
+func (l *Limiter) Blocked(key string, now time.Time) bool {
+
+	if l.limit <= 0 {
+		return false
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	c, ok := l.counts[key]
+	if !ok || now.Sub(c.start) >= l.window {
+		return false
+	}
+
+	return c.n >= l.limit
+}
+
+// Record counts an event for the given key at the given time without checking
+// the limit.
+func (l *Limiter) Record(key string, now time.Time) {
+
+	if l.limit <= 0 {
+		return
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.prune(now)
+
+	c := l.current(key, now)
+	c.n++
+}
+
+// current gets the count for the given key, starting a new one if the key has
+// no count or its window has passed.  The caller must hold the mutex.
+func (l *Limiter) current(key string, now time.Time) *count {
+	c, ok := l.counts[key]
+	if !ok || now.Sub(c.start) >= l.window {
+		c = &count{start: now}
+		l.counts[key] = c
+	}
+	return c
+}
+
+// prune removes the counts whose window has passed.  It does the work at most
+// once per window.  The caller must hold the mutex.
+func (l *Limiter) prune(now time.Time) {
+	if now.Sub(l.lastPrune) < l.window {
+		return
+	}
+
+	for key, c := range l.counts {
+		if now.Sub(c.start) >= l.window {
+			delete(l.counts, key)
+		}
+	}
+
+	l.lastPrune = now
+}
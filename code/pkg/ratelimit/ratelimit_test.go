@@ -0,0 +1,122 @@
+package ratelimit
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestAllow checks that events over the limit are refused until the window
+// has passed.
+func TestAllow(t *testing.T) {
+
+	start := time.Date(2025, time.March, 1, 12, 0, 0, 0, time.UTC)
+	l := New(2, time.Minute)
+
+	var testData = []struct {
+		description string
+		key         string
+		when        time.Time
+		want        bool
+	}{
+		{"first", "a", start, true},
+		{"second", "a", start.Add(time.Second), true},
+		{"third", "a", start.Add(2 * time.Second), false},
+		{"other key", "b", start.Add(2 * time.Second), true},
+		{"still blocked", "a", start.Add(59 * time.Second), false},
+		{"next window", "a", start.Add(time.Minute), true},
+	}
+
+	for _, td := range testData {
+		got := l.Allow(td.key, td.when)
+		if got != td.want {
+			t.Errorf("%s: want %v got %v", td.description, td.want, got)
+		}
+	}
+}
+
+// TestBlocked checks that Blocked only counts the events given to Record.
+func TestBlocked(t *testing.T) {
+
+	start := time.Date(2025, time.March, 1, 12, 0, 0, 0, time.UTC)
+	l := New(2, time.Minute)
+
+	var testData = []struct {
+		description string
+		record      bool // Record an event before checking.
+		key         string
+		when        time.Time
+		want        bool
+	}{
+		{"nothing recorded", false, "a", start, false},
+		{"still nothing recorded", false, "a", start.Add(time.Second), false},
+		{"first", true, "a", start.Add(2 * time.Second), false},
+		{"second", true, "a", start.Add(3 * time.Second), true},
+		{"other key", false, "b", start.Add(3 * time.Second), false},
+		{"next window", false, "a", start.Add(2 * time.Minute), false},
+	}
+
+	for _, td := range testData {
+		if td.record {
+			l.Record(td.key, td.when)
+		}
+		got := l.Blocked(td.key, td.when)
+		if got != td.want {
+			t.Errorf("%s: want %v got %v", td.description, td.want, got)
+		}
+	}
+}
+
+// TestNoLimit checks that a limit of zero allows everything.
+func TestNoLimit(t *testing.T) {
+	l := New(0, time.Minute)
+	now := time.Now()
+	for i := 0; i < 1000; i++ {
+		if !l.Allow("a", now) {
+			t.Errorf("want true on event %d", i)
+			return
+		}
+	}
+}
+
+// TestPrune checks that expired counts are removed.
+func TestPrune(t *testing.T) {
+	start := time.Date(2025, time.March, 1, 12, 0, 0, 0, time.UTC)
+	l := New(1, time.Minute)
+	l.Allow("a", start)
+	l.Allow("b", start)
+
+	l.Allow("c", start.Add(2*time.Minute))
+
+	if len(l.counts) != 1 {
+		t.Errorf("want 1 count got %d", len(l.counts))
+	}
+}
+
+// TestConcurrent checks that the limit holds when many goroutines use the
+// limiter at once.  Run it with the race detector.
+func TestConcurrent(t *testing.T) {
+	const limit = 10
+	l := New(limit, time.Hour)
+	now := time.Now()
+
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	allowed := 0
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if l.Allow("a", now) {
+				mutex.Lock()
+				allowed++
+				mutex.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if allowed != limit {
+		t.Errorf("want %d got %d", limit, allowed)
+	}
+}
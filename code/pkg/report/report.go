@@ -0,0 +1,110 @@
+// The report package writes the membership statistics produced by the
+// database package as CSV or JSON, for the members command and the
+// administration pages.
+package report
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/goblimey/go-stripe-payments/code/pkg/database"
+)
+
+// FormatCSV and FormatJSON are the formats that a report can be written in.
+const FormatCSV = "csv"
+const FormatJSON = "json"
+
+// csvHeader is the first line of a CSV report.
+var csvHeader = []string{
+	"year",
+	"month",
+	"sales",
+	"new members",
+	"renewals",
+	"associates",
+	"friends",
+	"ordinary member fees",
+	"associate member fees",
+	"friend fees",
+	"donations to society",
+	"donations to museum",
+	"giftaid sales",
+	"giftaid total",
+	"total",
+}
+
+// MonthName gives the name of the month of a summary, or "total" for the
+// summary of the whole year.
+func MonthName(summary *database.SalesSummary) string {
+	if summary.Month < 1 || summary.Month > 12 {
+		return "total"
+	}
+	return fmt.Sprintf("%d-%02d", summary.SaleYear, summary.Month)
+}
+
+// WriteCSV writes the summaries as CSV, one line per summary after a header line.
+func WriteCSV(w io.Writer, summaries []database.SalesSummary) error {
+	writer := csv.NewWriter(w)
+
+	writeError := writer.Write(csvHeader)
+	if writeError != nil {
+		return writeError
+	}
+
+	for i := range summaries {
+		s := &summaries[i]
+		line := []string{
+			strconv.Itoa(s.Year),
+			MonthName(s),
+			strconv.Itoa(s.Sales),
+			strconv.Itoa(s.NewMembers),
+			strconv.Itoa(s.Renewals),
+			strconv.Itoa(s.Associates),
+			strconv.Itoa(s.Friends),
+			money(s.OrdinaryMemberFees),
+			money(s.AssociateMemberFees),
+			money(s.FriendFees),
+			money(s.DonationsToSociety),
+			money(s.DonationsToMuseum),
+			strconv.Itoa(s.GiftaidSales),
+			money(s.GiftaidTotal),
+			money(s.Total),
+		}
+		writeError = writer.Write(line)
+		if writeError != nil {
+			return writeError
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+// WriteJSON writes the summaries as a JSON array.
+func WriteJSON(w io.Writer, summaries []database.SalesSummary) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "    ")
+	return encoder.Encode(summaries)
+}
+
+// Write writes the summaries in the given format, "csv" or "json".
+func Write(w io.Writer, format string, summaries []database.SalesSummary) error {
+	switch format {
+	case FormatCSV:
+		return WriteCSV(w, summaries)
+	case FormatJSON:
+		return WriteJSON(w, summaries)
+	default:
+		em := fmt.Sprintf("unknown report format %q - want %s or %s", format, FormatCSV, FormatJSON)
+		return errors.New(em)
+	}
+}
+
+// money formats an amount of money with two decimal places.
+func money(amount float64) string {
+	return strconv.FormatFloat(amount, 'f', 2, 64)
+}
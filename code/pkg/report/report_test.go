@@ -0,0 +1,75 @@
+package report
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/goblimey/go-stripe-payments/code/pkg/database"
+)
+
+var testSummaries = []database.SalesSummary{
+	{Year: 2025, SaleYear: 2024, Month: 11, Sales: 1, NewMembers: 1,
+		OrdinaryMemberFees: 24, DonationsToMuseum: 2.5, GiftaidSales: 1, GiftaidTotal: 26.5, Total: 26.5},
+	{Year: 2025, Sales: 1, NewMembers: 1,
+		OrdinaryMemberFees: 24, DonationsToMuseum: 2.5, GiftaidSales: 1, GiftaidTotal: 26.5, Total: 26.5},
+}
+
+// TestWriteCSV checks that WriteCSV writes a header and one line per summary.
+func TestWriteCSV(t *testing.T) {
+	const want = "year,month,sales,new members,renewals,associates,friends," +
+		"ordinary member fees,associate member fees,friend fees," +
+		"donations to society,donations to museum,giftaid sales,giftaid total,total\n" +
+		"2025,2024-11,1,1,0,0,0,24.00,0.00,0.00,0.00,2.50,1,26.50,26.50\n" +
+		"2025,total,1,1,0,0,0,24.00,0.00,0.00,0.00,2.50,1,26.50,26.50\n"
+
+	var buffer bytes.Buffer
+	writeError := Write(&buffer, FormatCSV, testSummaries)
+	if writeError != nil {
+		t.Fatal(writeError)
+	}
+
+	got := buffer.String()
+	if got != want {
+		t.Errorf("want\n%s\ngot\n%s", want, got)
+	}
+}
+
+// TestWriteJSON checks that the JSON written by WriteJSON can be read back.
+func TestWriteJSON(t *testing.T) {
+	var buffer bytes.Buffer
+	writeError := Write(&buffer, FormatJSON, testSummaries)
+	if writeError != nil {
+		t.Fatal(writeError)
+	}
+
+	if !strings.Contains(buffer.String(), `"donations_to_museum": 2.5`) {
+		t.Errorf("want snake case names got\n%s", buffer.String())
+	}
+
+	var got []database.SalesSummary
+	readError := json.Unmarshal(buffer.Bytes(), &got)
+	if readError != nil {
+		t.Fatal(readError)
+	}
+
+	if len(got) != len(testSummaries) {
+		t.Fatalf("want %d summaries got %d", len(testSummaries), len(got))
+	}
+
+	for i := range got {
+		if got[i] != testSummaries[i] {
+			t.Errorf("want %v got %v", testSummaries[i], got[i])
+		}
+	}
+}
+
+// TestWriteUnknownFormat checks that Write rejects an unknown format.
+func TestWriteUnknownFormat(t *testing.T) {
+	var buffer bytes.Buffer
+	writeError := Write(&buffer, "xml", testSummaries)
+	if writeError == nil {
+		t.Error("want an error")
+	}
+}
@@ -0,0 +1,132 @@
+// The session package provides simple server-side sessions for the pages that
+// a member or an administrator logs in to.  The session ID is a long random
+// string carried in a cookie and the session data is held in memory, so all
+// sessions are lost when the server restarts and the user has to log in again.
+package session
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Session holds the data for one logged in user.
+type Session struct {
+	ID      string    // The random session ID, also the cookie value.
+	UserID  int64     // The adm_users ID of the logged in user.
+	Expires time.Time // The session is not valid after this time.
+}
+
+// Store holds the sessions.  It's safe for use by concurrent goroutines.
+type Store struct {
+	cookieName string        // The name of the session cookie.
+	lifetime   time.Duration // How long a session lasts after the last request.
+	secure     bool          // True if the cookie should only be sent over HTTPS.
+	mutex      sync.Mutex    // Protects the sessions.
+	sessions   map[string]*Session
+}
+
+// New creates a Store whose sessions are carried in a cookie with the given name
+// and expire after the given time without a request.
+func New(cookieName string, lifetime time.Duration, secure bool) *Store {
+	s := Store{
+		cookieName: cookieName,
+		lifetime:   lifetime,
+		secure:     secure,
+		sessions:   make(map[string]*Session),
+	}
+	return &s
+}
+
+// Create creates a session for the given user and sets the cookie.
+func (s *Store) Create(w http.ResponseWriter, userID int64, now time.Time) (*Session, error) {
+
+	b := make([]byte, 32)
+	_, randError := rand.Read(b)
+	if randError != nil {
+		return nil, randError
+	}
+
+	session := Session{
+		ID:      hex.EncodeToString(b),
+		UserID:  userID,
+		Expires: now.Add(s.lifetime),
+	}
+
+	s.mutex.Lock()
+	s.prune(now)
+	s.sessions[session.ID] = &session
+	s.mutex.Unlock()
+
+	http.SetCookie(w, s.cookie(session.ID, 0))
+
+	return &session, nil
+}
+
+// Get returns the session named by the cookie in the request and extends its
+// life.  If there is no valid session it returns nil.
+func (s *Store) Get(r *http.Request, now time.Time) *Session {
+
+	cookie, cookieError := r.Cookie(s.cookieName)
+	if cookieError != nil {
+		return nil
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	session, ok := s.sessions[cookie.Value]
+	if !ok {
+		return nil
+	}
+
+	if now.After(session.Expires) {
+		delete(s.sessions, cookie.Value)
+		return nil
+	}
+
+	session.Expires = now.Add(s.lifetime)
+
+	// Return a copy so that the caller can't change the stored session.
+	result := *session
+	return &result
+}
+
+// Delete ends the session named by the cookie in the request, if any, and
+// clears the cookie.
+func (s *Store) Delete(w http.ResponseWriter, r *http.Request) {
+
+	cookie, cookieError := r.Cookie(s.cookieName)
+	if cookieError == nil {
+		s.mutex.Lock()
+		delete(s.sessions, cookie.Value)
+		s.mutex.Unlock()
+	}
+
+	http.SetCookie(w, s.cookie("", -1))
+}
+
+// cookie creates the session cookie.
+func (s *Store) cookie(value string, maxAge int) *http.Cookie {
+	c := http.Cookie{
+		Name:     s.cookieName,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   s.secure,
+		SameSite: http.SameSiteLaxMode,
+	}
+	return &c
+}
+
+// prune removes expired sessions.  The caller must hold the mutex.
+func (s *Store) prune(now time.Time) {
+	for id, session := range s.sessions {
+		if now.After(session.Expires) {
+			delete(s.sessions, id)
+		}
+	}
+}
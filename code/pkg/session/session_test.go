@@ -0,0 +1,86 @@
+package session
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestSession checks that a session can be created, found, extended and deleted.
+func TestSession(t *testing.T) {
+
+	start := time.Date(2025, time.March, 1, 12, 0, 0, 0, time.UTC)
+	s := New("sess", 30*time.Minute, true)
+
+	w := httptest.NewRecorder()
+	created, createError := s.Create(w, 42, start)
+	if createError != nil {
+		t.Fatal(createError)
+	}
+
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != "sess" || cookies[0].Value != created.ID {
+		t.Fatalf("want one session cookie got %v", cookies)
+	}
+
+	if !cookies[0].HttpOnly || !cookies[0].Secure {
+		t.Error("cookie should be HttpOnly and Secure")
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/account", nil)
+	r.AddCookie(cookies[0])
+
+	var testData = []struct {
+		description string
+		when        time.Time
+		wantUserID  int64
+	}{
+		{"fresh", start.Add(time.Minute), 42},
+		// The previous request extended the session.
+		{"extended", start.Add(30 * time.Minute), 42},
+		{"expired", start.Add(61 * time.Minute), 0},
+	}
+
+	for _, td := range testData {
+		got := s.Get(r, td.when)
+		var gotUserID int64
+		if got != nil {
+			gotUserID = got.UserID
+		}
+		if gotUserID != td.wantUserID {
+			t.Errorf("%s: want %d got %d", td.description, td.wantUserID, gotUserID)
+		}
+	}
+}
+
+// TestDelete checks that a deleted session can't be used.
+func TestDelete(t *testing.T) {
+
+	now := time.Date(2025, time.March, 1, 12, 0, 0, 0, time.UTC)
+	s := New("sess", time.Hour, false)
+
+	w := httptest.NewRecorder()
+	s.Create(w, 42, now)
+	cookie := w.Result().Cookies()[0]
+
+	r := httptest.NewRequest(http.MethodPost, "/account/logout", nil)
+	r.AddCookie(cookie)
+
+	s.Delete(httptest.NewRecorder(), r)
+
+	if s.Get(r, now) != nil {
+		t.Error("want no session after delete")
+	}
+}
+
+// TestForgedCookie checks that an unknown session ID is refused.
+func TestForgedCookie(t *testing.T) {
+	s := New("sess", time.Hour, false)
+	r := httptest.NewRequest(http.MethodGet, "/account", nil)
+	r.AddCookie(&http.Cookie{Name: "sess", Value: "0123456789abcdef"})
+
+	if s.Get(r, time.Now()) != nil {
+		t.Error("want no session for a forged cookie")
+	}
+}
@@ -0,0 +1,134 @@
+// The token package creates and checks signed, expiring tokens.  They are
+// used to carry state between the pages of the payment flow so that the
+// server doesn't have to trust values posted back by the browser.
+//
+// A token is two base64 (URL encoding, no padding) strings separated by a
+// dot.  The first is the payload, the second is an HMAC-SHA256 signature of
+// the payload made with a secret known only to the server.  Anybody can read
+// the payload but nobody can change it without the secret.
+//
+// The same secret signs tokens for several purposes, so every payload starts
+// with its purpose, for example "sale:".  Verify checks it, so a token issued
+// for one purpose can't be used for another.
+package token
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ErrInvalid is returned when a token is malformed or its signature is wrong.
+var ErrInvalid = errors.New("invalid token")
+
+// ErrExpired is returned when a token is properly signed but out of date.
+var ErrExpired = errors.New("token has expired")
+
+// The purposes of tokens.
+const (
+	PurposeCSRF = "csrf" // The CSRF cookie (see the csrf package).
+	PurposeSale = "sale" // A SaleToken.
+	PurposeForm = "form" // The time at which the sale form was displayed.
+)
+
+// Signer signs and checks tokens using a secret.
+type Signer struct {
+	secret []byte
+}
+
+// NewSigner creates a Signer using the given secret.
+func NewSigner(secret string) *Signer {
+	s := Signer{secret: []byte(secret)}
+	return &s
+}
+
+// Sign returns a token containing the given payload for the given purpose.
+func (s *Signer) Sign(purpose, payload string) string {
+	encodedPayload := base64.RawURLEncoding.EncodeToString([]byte(purpose + ":" + payload))
+	encodedMAC := base64.RawURLEncoding.EncodeToString(s.mac(encodedPayload))
+	return encodedPayload + "." + encodedMAC
+}
+
+// Verify checks the signature and the purpose of the given token and, if
+// they are valid, returns the payload without the purpose.
+func (s *Signer) Verify(purpose, token string) (string, error) {
+	encodedPayload, encodedMAC, found := strings.Cut(token, ".")
+	if !found {
+		return "", ErrInvalid
+	}
+
+	mac, macError := base64.RawURLEncoding.DecodeString(encodedMAC)
+	if macError != nil {
+		return "", ErrInvalid
+	}
+
+	if !hmac.Equal(mac, s.mac(encodedPayload)) {
+		return "", ErrInvalid
+	}
+
+	payload, payloadError := base64.RawURLEncoding.DecodeString(encodedPayload)
+	if payloadError != nil {
+		return "", ErrInvalid
+	}
+
+	rest, purposeOK := strings.CutPrefix(string(payload), purpose+":")
+	if !purposeOK {
+		return "", ErrInvalid
+	}
+
+	// Success!
+	return rest, nil
+}
+
+// mac returns the HMAC-SHA256 of the given string.
+func (s *Signer) mac(str string) []byte {
+	m := hmac.New(sha256.New, s.secret)
+	m.Write([]byte(str))
+	return m.Sum(nil)
+}
+
+// SaleToken binds a membership sale to the user accounts that it paid for.
+// It's issued on the success page and presented back with the extra details.
+type SaleToken struct {
+	SaleID      int64     // The ID of the membership_sales record.
+	UserID      int64     // The user ID of the ordinary member.
+	AssocUserID int64     // The user ID of the associate member (0 if none).
+	Expires     time.Time // The token is not valid after this time.
+}
+
+// IssueSaleToken creates a signed token from the given SaleToken.
+func (s *Signer) IssueSaleToken(st *SaleToken) string {
+	payload := fmt.Sprintf("%d:%d:%d:%d",
+		st.SaleID, st.UserID, st.AssocUserID, st.Expires.Unix())
+	return s.Sign(PurposeSale, payload)
+}
+
+// CheckSaleToken checks the given token and returns the SaleToken that it
+// contains.  The token must be properly signed and must not have expired at
+// the given time.
+func (s *Signer) CheckSaleToken(token string, now time.Time) (*SaleToken, error) {
+	payload, verifyError := s.Verify(PurposeSale, token)
+	if verifyError != nil {
+		return nil, verifyError
+	}
+
+	var st SaleToken
+	var expires int64
+	n, scanError := fmt.Sscanf(payload, "%d:%d:%d:%d",
+		&st.SaleID, &st.UserID, &st.AssocUserID, &expires)
+	if scanError != nil || n != 4 {
+		return nil, ErrInvalid
+	}
+	st.Expires = time.Unix(expires, 0)
+
+	if now.After(st.Expires) {
+		return nil, ErrExpired
+	}
+
+	// Success!
+	return &st, nil
+}
@@ -0,0 +1,108 @@
+package token
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestSignAndVerify checks that a signed payload can be recovered.
+func TestSignAndVerify(t *testing.T) {
+	var testData = []struct {
+		description string
+		payload     string
+	}{
+		{"empty", ""},
+		{"simple", "hello"},
+		{"with separator", "a.b:c"},
+		{"unicode", "Zoë Ångström"},
+	}
+
+	signer := NewSigner("secret")
+
+	for _, td := range testData {
+		tok := signer.Sign(PurposeForm, td.payload)
+		got, err := signer.Verify(PurposeForm, tok)
+		if err != nil {
+			t.Errorf("%s: %v", td.description, err)
+			continue
+		}
+		if got != td.payload {
+			t.Errorf("%s: want %s got %s", td.description, td.payload, got)
+		}
+	}
+}
+
+// TestVerifyRejectsTampering checks that Verify rejects altered and
+// wrongly-signed tokens.
+func TestVerifyRejectsTampering(t *testing.T) {
+
+	signer := NewSigner("secret")
+	tok := signer.Sign(PurposeSale, "1:2:3:4")
+	payload, mac, _ := strings.Cut(tok, ".")
+	otherPayload := NewSigner("secret").Sign(PurposeSale, "9:2:3:4")
+	otherPayload, _, _ = strings.Cut(otherPayload, ".")
+
+	var testData = []struct {
+		description string
+		token       string
+	}{
+		{"empty", ""},
+		{"no separator", payload + mac},
+		{"swapped payload", otherPayload + "." + mac},
+		{"truncated MAC", payload + "." + mac[:10]},
+		{"bad base64", payload + ".!!!"},
+		{"other secret", NewSigner("other").Sign(PurposeSale, "1:2:3:4")},
+		{"other purpose", signer.Sign(PurposeCSRF, "1:2:3:4")},
+		{"purpose in the payload", signer.Sign(PurposeCSRF, "sale:1:2:3:4")},
+	}
+
+	for _, td := range testData {
+		_, err := signer.Verify(PurposeSale, td.token)
+		if !errors.Is(err, ErrInvalid) {
+			t.Errorf("%s: want ErrInvalid got %v", td.description, err)
+		}
+	}
+}
+
+// TestSaleToken checks the issue and check cycle of a sale token.
+func TestSaleToken(t *testing.T) {
+
+	signer := NewSigner("secret")
+	now := time.Date(2025, time.March, 1, 12, 0, 0, 0, time.UTC)
+
+	want := SaleToken{SaleID: 42, UserID: 7, AssocUserID: 8, Expires: now.Add(time.Hour)}
+	tok := signer.IssueSaleToken(&want)
+
+	got, err := signer.CheckSaleToken(tok, now)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if got.SaleID != want.SaleID {
+		t.Errorf("want %d got %d", want.SaleID, got.SaleID)
+	}
+	if got.UserID != want.UserID {
+		t.Errorf("want %d got %d", want.UserID, got.UserID)
+	}
+	if got.AssocUserID != want.AssocUserID {
+		t.Errorf("want %d got %d", want.AssocUserID, got.AssocUserID)
+	}
+	if !got.Expires.Equal(want.Expires) {
+		t.Errorf("want %v got %v", want.Expires, got.Expires)
+	}
+
+	// Two hours later the token has expired.
+	_, expiredError := signer.CheckSaleToken(tok, now.Add(2*time.Hour))
+	if !errors.Is(expiredError, ErrExpired) {
+		t.Errorf("want ErrExpired got %v", expiredError)
+	}
+
+	// A properly signed token with the wrong payload is rejected.
+	_, formatError := signer.CheckSaleToken(signer.Sign(PurposeSale, "junk"), now)
+	if !errors.Is(formatError, ErrInvalid) {
+		t.Errorf("want ErrInvalid got %v", formatError)
+	}
+}
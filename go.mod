@@ -3,6 +3,7 @@ module github.com/goblimey/go-stripe-payments
 go 1.24.1
 
 require (
+	github.com/go-sql-driver/mysql v1.9.3
 	github.com/goblimey/dailylogger v0.0.0-20260117165653-f43018dc239b
 	github.com/goblimey/go-tools v0.0.11
 	github.com/goblimey/portablesyscall v0.0.0-20260111231805-0c68a3fd59ea
@@ -10,10 +11,12 @@ require (
 	github.com/kylelemons/godebug v1.1.0
 	github.com/lib/pq v1.10.9
 	github.com/stripe/stripe-go/v81 v81.0.0
+	golang.org/x/crypto v0.22.0
 	modernc.org/sqlite v1.38.0
 )
 
 require (
+	filippo.io/edwards25519 v1.1.0 // indirect
 	github.com/dustin/go-humanize v1.0.1 // indirect
 	github.com/goblimey/switchwriter v0.0.0-20260103122352-d7a30a22828f // indirect
 	github.com/mattn/go-isatty v0.0.20 // indirect